@@ -3,16 +3,23 @@ package dynamic
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
 	"github.com/keep94/marvin2/ops"
 	"github.com/keep94/maybe"
+	"github.com/keep94/tasks"
+	"math"
 	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -21,6 +28,25 @@ const (
 
 	// Default name of brightness parameter
 	BrightnessParamName = "Bri"
+
+	// Default name of the param holding the rows of a CustomSceneFactory
+	LightsParamName = "Lights"
+
+	// Default name of the light id param in each row of a
+	// CustomSceneFactory
+	LightParamName = "Light"
+
+	// Default name of the colors param of a CycleFactory
+	ColorsParamName = "Colors"
+
+	// Default name of the step param of a CycleFactory
+	StepParamName = "Step"
+
+	// Default name of the interval param of a RandomColorFactory
+	IntervalParamName = "Interval"
+
+	// Default name of the seed param of a RandomColorFactory
+	SeedParamName = "Seed"
 )
 
 var (
@@ -28,6 +54,17 @@ var (
 	ErrNoValue = errors.New("dynamic: No value.")
 
 	errBadValue = errors.New("dynamic: Bad value.")
+
+	errBadChoice = errors.New("not one of the choices")
+
+	errLightIdRequired = errors.New("must be a light id")
+
+	// Reported if a Registry already has a hue task with a given id.
+	ErrDuplicateId = errors.New("dynamic: Duplicate id.")
+
+	// Reported if there is no decoder for the version of an encoded
+	// hue action.
+	ErrUnknownVersion = errors.New("dynamic: Unknown version.")
 )
 
 // Interface Param represents a single parameter for generating a ops.HueTask.
@@ -51,6 +88,280 @@ type Param interface {
 	Convert(s string) (interface{}, string)
 }
 
+// Interface ValidatingParam is implemented by Params that can report why
+// what the user entered is invalid. All the Params that this package
+// provides implement ValidatingParam.
+type ValidatingParam interface {
+	Param
+
+	// ConvertStrict works like Convert except that if what the user
+	// entered is invalid, ConvertStrict returns the default value along
+	// with an error explaining what is wrong such as
+	// "must be a whole number from 0 to 255." An empty string is valid
+	// and means the default value.
+	ConvertStrict(s string) (interface{}, string, error)
+}
+
+// ConvertStrict calls param.ConvertStrict if param implements
+// ValidatingParam. Otherwise it calls param.Convert and returns a nil
+// error.
+func ConvertStrict(param Param, s string) (interface{}, string, error) {
+	if validating, ok := param.(ValidatingParam); ok {
+		return validating.ConvertStrict(s)
+	}
+	value, str := param.Convert(s)
+	return value, str, nil
+}
+
+// FieldError reports that what the user entered for a particular
+// parameter is invalid.
+type FieldError struct {
+	// The index of the parameter
+	Index int
+
+	// The name of the parameter
+	Name string
+
+	// What the user entered
+	Value string
+
+	// What is wrong
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %v", e.Name, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FieldErrors is the error HueTask.FromUrlValuesStrict returns. It holds
+// one FieldError for each invalid parameter in parameter order.
+type FieldErrors []*FieldError
+
+func (e FieldErrors) Error() string {
+	parts := make([]string, len(e))
+	for i := range e {
+		parts[i] = e[i].Error()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ByIndex returns the FieldError for the parameter at index or nil if
+// that parameter is valid.
+func (e FieldErrors) ByIndex(index int) *FieldError {
+	for _, fieldError := range e {
+		if fieldError.Index == index {
+			return fieldError
+		}
+	}
+	return nil
+}
+
+// Types of parameters in a ParamSchema
+const (
+	IntType         = "int"
+	FloatType       = "float"
+	DurationType    = "duration"
+	TextType        = "text"
+	ColorType       = "color"
+	ChoiceType      = "choice"
+	MultiChoiceType = "multichoice"
+	GroupType       = "group"
+)
+
+// ParamSchema describes a parameter so that front ends can render input
+// forms on their own. Fields that don't apply to a parameter's Type are
+// left empty and are omitted from the JSON.
+type ParamSchema struct {
+	// The key of the url value for this parameter without the prefix.
+	// For the third parameter, Key is "2". For parameters in a group
+	// row, Key is the index of the parameter within the row.
+	Key string `json:"key"`
+
+	// The name of the parameter
+	Name string `json:"name"`
+
+	// One of the Type constants such as IntType
+	Type string `json:"type"`
+
+	// The minimum and maximum value inclusive
+	Min interface{} `json:"min,omitempty"`
+	Max interface{} `json:"max,omitempty"`
+
+	// The default value and its description
+	Default     interface{} `json:"default,omitempty"`
+	DefaultName string      `json:"defaultName,omitempty"`
+
+	// The size of the text field
+	MaxChars int `json:"maxChars,omitempty"`
+
+	// Digits after the decimal point for FloatType
+	Precision int `json:"precision,omitempty"`
+
+	// The names of the choices for ChoiceType and MultiChoiceType. The
+	// url value for a choice is its index here plus 1.
+	Choices []string `json:"choices,omitempty"`
+
+	// The stable url values of the choices in Choices for pickers that
+	// KeyedPicker returns. Front ends should submit these instead of
+	// indexes so that saved values survive changes to the choices.
+	ChoiceKeys []string `json:"choiceKeys,omitempty"`
+
+	// The minimum and maximum number of choices for MultiChoiceType
+	MinCount int `json:"minCount,omitempty"`
+	MaxCount int `json:"maxCount,omitempty"`
+
+	// The maximum number of rows and the params in each row for GroupType
+	MaxRows int            `json:"maxRows,omitempty"`
+	Params  []*ParamSchema `json:"params,omitempty"`
+
+	// Hints for rendering the parameter. See ParamMetadata.
+	Unit        string  `json:"unit,omitempty"`
+	Step        float64 `json:"step,omitempty"`
+	Placeholder string  `json:"placeholder,omitempty"`
+	Widget      string  `json:"widget,omitempty"`
+}
+
+// Interface SchemaParam is implemented by Params that can describe
+// themselves. All the Params that this package provides implement
+// SchemaParam.
+type SchemaParam interface {
+	Param
+
+	// Schema returns the schema of this Param. The returned schema has
+	// no Key or Name.
+	Schema() *ParamSchema
+}
+
+// Schema returns the schema of the named parameter param with given key.
+// If param does not implement SchemaParam, Schema describes it as a
+// ChoiceType if it has a Selection or as a TextType otherwise.
+func Schema(key string, param NamedParam) *ParamSchema {
+	var result *ParamSchema
+	if schemaParam, ok := param.Param.(SchemaParam); ok {
+		result = schemaParam.Schema()
+	} else if selection := param.Selection(); selection != nil {
+		result = &ParamSchema{Type: ChoiceType, Choices: selection[1:]}
+	} else {
+		result = &ParamSchema{Type: TextType, MaxChars: param.MaxCharCount()}
+	}
+	result.Key = key
+	result.Name = param.Name
+	metadata := param.Metadata()
+	result.Unit = metadata.Unit
+	result.Step = metadata.Step
+	result.Placeholder = metadata.Placeholder
+	result.Widget = metadata.Widget
+	return result
+}
+
+// TaskSchema describes a HueTask and its parameters.
+type TaskSchema struct {
+	Id          int            `json:"id"`
+	Description string         `json:"description"`
+	Params      []*ParamSchema `json:"params"`
+}
+
+// Widgets for ParamMetadata
+const (
+	// A slider between the minimum and maximum value
+	SliderWidget = "slider"
+
+	// A color swatch
+	ColorWidget = "color"
+)
+
+// ParamMetadata holds hints that help forms render a parameter. All
+// fields are optional.
+type ParamMetadata struct {
+	// The unit of the value such as "seconds" or "%"
+	Unit string
+
+	// The step size of a slider or spinner
+	Step float64
+
+	// Text shown in an empty text field such as "e.g 1h30m"
+	Placeholder string
+
+	// How to render the parameter such as SliderWidget
+	Widget string
+}
+
+// Interface MetadataParam is implemented by Params that have metadata.
+type MetadataParam interface {
+	Param
+
+	// Metadata returns the metadata of this Param.
+	Metadata() ParamMetadata
+}
+
+// WithMetadata returns a Param that works like param but has metadata.
+// The returned Param implements the same interfaces in this package that
+// param implements.
+func WithMetadata(param Param, metadata ParamMetadata) Param {
+	result := &metadataParam{Param: param, metadata: metadata}
+	if group, ok := param.(GroupParam); ok {
+		return &metadataGroupParam{metadataParam: result, group: group}
+	}
+	return result
+}
+
+// Metadata returns the metadata of param if it implements MetadataParam;
+// otherwise Metadata returns the zero value.
+func Metadata(param Param) ParamMetadata {
+	if metadataParam, ok := param.(MetadataParam); ok {
+		return metadataParam.Metadata()
+	}
+	return ParamMetadata{}
+}
+
+// Translator translates English text that users see to another language.
+// key is the English text. A Translator returns key unchanged if it has no
+// translation for it.
+type Translator func(key string) string
+
+func (t Translator) text(key string) string {
+	if t == nil {
+		return key
+	}
+	return t(key)
+}
+
+// Interface LocalizableParam is implemented by Params that show users text
+// other than what they enter such as choice names. All the Params that this
+// package provides that show such text implement LocalizableParam.
+type LocalizableParam interface {
+	Param
+
+	// Localize returns a copy of this Param that translates its text
+	// with translate.
+	Localize(translate Translator) Param
+}
+
+// Localize returns param localized with translate if param implements
+// LocalizableParam; otherwise Localize returns param unchanged.
+func Localize(param Param, translate Translator) Param {
+	if localizable, ok := param.(LocalizableParam); ok {
+		return localizable.Localize(translate)
+	}
+	return param
+}
+
+func localizeParams(
+	params NamedParamList, translate Translator) NamedParamList {
+	result := make(NamedParamList, len(params))
+	for i := range params {
+		result[i] = NamedParam{
+			Name:  translate.text(params[i].Name),
+			Param: Localize(params[i].Param, translate),
+		}
+	}
+	return result
+}
+
 // Choice represents a single choice in a choice dialog.
 type Choice struct {
 
@@ -78,6 +389,112 @@ func Picker(
 	}
 }
 
+// KeyedPicker works like Picker except that each choice also has a key,
+// a url value that stays the same when choices get added or removed.
+// keys[i] is the key of choices[i]; an empty key means the ordinal value
+// of the choice. Convert accepts keys as well as ordinal values. Keys
+// must not be whole numbers.
+func KeyedPicker(
+	choices ChoiceList,
+	keys []string,
+	defaultValue interface{},
+	defaultName string) Param {
+	if len(keys) != len(choices) {
+		panic("dynamic: KeyedPicker needs one key for each choice")
+	}
+	return &keyedPicker{
+		picker: picker{
+			Choices:      choices,
+			DefaultValue: defaultValue,
+			DefaultName:  defaultName,
+		},
+		keys: keys,
+	}
+}
+
+// MultiPicker returns a Param that is presented as a choice dialog in which
+// the user may select several choices. choices are the choices user will
+// see exluding the "Select one" choice. Convert accepts the ordinal values
+// of the selected options separated by commas such as "1,3". The value of
+// the returned Param is a []interface{} holding the values of the selected
+// choices in the order selected. defaultValue is the value of the returned
+// Param if user does not select any choice; defaultName is the
+// description of the default value to use in generated ops.HueTask
+// descriptions. The returned Param implements MultiSelectParam.
+func MultiPicker(
+	choices ChoiceList, defaultValue interface{}, defaultName string) Param {
+	return BoundedMultiPicker(
+		choices, 1, len(choices), defaultValue, defaultName)
+}
+
+// BoundedMultiPicker works like MultiPicker except that the user must
+// select at least minCount choices. If the user selects fewer, the value
+// of the returned Param is defaultValue. If the user selects more than
+// maxCount choices, only the first maxCount are used.
+func BoundedMultiPicker(
+	choices ChoiceList,
+	minCount, maxCount int,
+	defaultValue interface{},
+	defaultName string) Param {
+	return &multiPicker{
+		picker: picker{
+			Choices:      choices,
+			DefaultValue: defaultValue,
+			DefaultName:  defaultName,
+		},
+		minCount: minCount,
+		maxCount: maxCount,
+	}
+}
+
+// Interface MultiSelectParam is implemented by Params that let the user
+// select more than one option.
+type MultiSelectParam interface {
+	Param
+
+	// MultiSelect returns true if the user may select more than one
+	// option.
+	MultiSelect() bool
+}
+
+// Group returns a Param that lets the user fill in up to maxRows rows of
+// params such as a light id, color, and brightness for each light in a
+// custom scene. The value of the returned Param is a [][]interface{}
+// holding the values of each row the user filled in; rows the user left
+// blank are skipped. Convert accepts rows separated by semicolons with
+// the values within each row separated by commas. The returned Param
+// implements GroupParam.
+func Group(maxRows int, params NamedParamList) Param {
+	return &groupParam{maxRows: maxRows, params: params}
+}
+
+// Interface GroupParam is implemented by Params made up of repeated
+// rows of other Params.
+type GroupParam interface {
+	Param
+
+	// RowParams returns the params in each row.
+	RowParams() NamedParamList
+
+	// MaxRows returns the maximum number of rows.
+	MaxRows() int
+
+	// ConvertRows works like Convert except that it takes what the user
+	// entered as a slice of rows. Each row holds what the user entered
+	// for each param in RowParams.
+	ConvertRows(rows [][]string) (interface{}, string)
+}
+
+// Interface ValidatingGroupParam is implemented by GroupParams that can
+// report why what the user entered is invalid.
+type ValidatingGroupParam interface {
+	GroupParam
+
+	// ConvertRowsStrict works like ConvertRows except that it also
+	// reports what is invalid like ValidatingParam.ConvertStrict.
+	ConvertRowsStrict(rows [][]string) (interface{}, string, error)
+}
+
 // Int returns an Param that is presented as a text field and has an
 // integer value. minValue and maxValue the minimum and maximum value
 // inclusive of the integer; defaultValue is the default value if user
@@ -93,9 +510,56 @@ func Int(
 	}
 }
 
+// Float returns a Param that is presented as a text field and has a
+// float64 value. minValue and maxValue are the minimum and maximum value
+// inclusive; defaultValue is the default value if user doesn't enter a
+// number or enters one that is out of range; precision is the number of
+// digits after the decimal point to which the value is rounded and with
+// which it appears in descriptions; maxChars is the size of the text field.
+func Float(
+	minValue, maxValue, defaultValue float64,
+	precision, maxChars int) Param {
+	return &floatParam{
+		MinValue:     minValue,
+		MaxValue:     maxValue,
+		DefaultValue: defaultValue,
+		Precision:    precision,
+		MaxChars:     maxChars,
+	}
+}
+
+// Duration returns a Param that is presented as a text field and has a
+// time.Duration value. The user enters durations such as "90s", "5m", or
+// "1h30m". minValue and maxValue are the minimum and maximum value
+// inclusive; defaultValue is the default value if user doesn't enter a
+// duration or enters one that is out of range. The returned Param shows
+// an example duration as its placeholder.
+func Duration(minValue, maxValue, defaultValue time.Duration) Param {
+	return WithMetadata(
+		&durationParam{
+			MinValue:     minValue,
+			MaxValue:     maxValue,
+			DefaultValue: defaultValue,
+		},
+		ParamMetadata{Placeholder: "e.g 1h30m"})
+}
+
+// Text returns a Param that is presented as a text field and has a string
+// value such as a label or message. Leading and trailing whitespace is
+// removed from what the user enters, and what remains is truncated to
+// maxChars characters; defaultValue is the value if user enters nothing.
+// The entered text appears as is in the description of generated
+// ops.HueTask instances.
+func Text(maxChars int, defaultValue string) Param {
+	return &textParam{
+		MaxChars:     maxChars,
+		DefaultValue: defaultValue,
+	}
+}
+
 // Brightness is a convenience rourtine that returns an integer parameter
 // representing brightness which is (0-255) with default of 255 and size
-// of 3 chars.
+// of 3 chars. It is rendered as a slider.
 func Brightness() Param {
 	return kBrightness
 }
@@ -107,6 +571,30 @@ func ColorPicker(defaultColor gohue.Color, defaultName string) Param {
 	return Picker(kColorChoices, defaultColor, defaultName)
 }
 
+// FreeColor returns a Param that is presented as a text field and has a
+// gohue.Color value. The user enters either an RGB color such as "#FF8000"
+// or the x and y coordinates of the color separated by a comma such as
+// "0.675,0.322". defaultColor is the default color if the user enters
+// nothing or something that isn't a color; defaultName is the name to show
+// for the default color. The returned Param is rendered as a color
+// swatch.
+func FreeColor(defaultColor gohue.Color, defaultName string) Param {
+	return WithMetadata(
+		&freeColorParam{
+			DefaultValue: defaultColor,
+			DefaultName:  defaultName,
+		},
+		ParamMetadata{Placeholder: "#FF8000", Widget: ColorWidget})
+}
+
+// ColorChoices returns the predefined colors that ColorPicker offers.
+// Callers may modify the returned list.
+func ColorChoices() ChoiceList {
+	result := make(ChoiceList, len(kColorChoices))
+	copy(result, kColorChoices)
+	return result
+}
+
 // NamedParam represents a Param that is named.
 type NamedParam struct {
 
@@ -116,6 +604,11 @@ type NamedParam struct {
 	Param
 }
 
+// Metadata returns the metadata of this instance's Param.
+func (n NamedParam) Metadata() ParamMetadata {
+	return Metadata(n.Param)
+}
+
 // NamedParamList represents an immutable list of NamedParam
 type NamedParamList []NamedParam
 
@@ -168,6 +661,21 @@ type HueTask struct {
 
 	// Helps to generate the ops.HueTask
 	Factory
+
+	// Optional tags for grouping hue tasks e.g "party", "holiday".
+	// ops.HueTask instances that this instance generates get these
+	// same tags.
+	Tags []string
+}
+
+// HasTag returns true if this instance has tag ignoring case.
+func (h *HueTask) HasTag(tag string) bool {
+	for _, t := range h.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
 }
 
 // FromOpsHueTask is a convenience routine that converts an
@@ -177,6 +685,7 @@ func FromOpsHueTask(h *ops.HueTask) *HueTask {
 		Id:          h.Id,
 		Description: h.Description,
 		Factory:     Constant(h.HueAction),
+		Tags:        h.Tags,
 	}
 }
 
@@ -189,7 +698,81 @@ func (h *HueTask) FromExplicit(
 		Id:          h.Id,
 		Description: h.getDescription(paramsAsStrings),
 		HueAction:   action,
+		Tags:        h.Tags,
+	}
+}
+
+// WithDefaults returns a copy of this instance that uses values in place
+// of what the user enters whenever the user leaves a parameter blank.
+// values is keyed like the url values that FromUrlValues takes but
+// without the prefix e.g "0", "1", "2". Typically values are the values
+// the user last submitted for this instance so that the user doesn't have
+// to enter the same values again. Parameters that are GroupParams keep
+// their own defaults. If the Factory of this instance implements
+// FactoryEncoderDecoder, so does the Factory of the returned instance.
+func (h *HueTask) WithDefaults(values url.Values) *HueTask {
+	params := h.Params()
+	newParams := make(NamedParamList, len(params))
+	for i := range params {
+		newParams[i] = params[i]
+		if _, ok := params[i].Param.(GroupParam); ok {
+			continue
+		}
+		key := strconv.Itoa(i)
+		if _, ok := values[key]; !ok {
+			continue
+		}
+		newParams[i].Param = &defaultParam{
+			Param: params[i].Param,
+			value: urlValue(params[i].Param, key, values),
+		}
+	}
+	return &HueTask{
+		Id:          h.Id,
+		Description: h.Description,
+		Factory:     withParams(h.Factory, newParams),
+		Tags:        h.Tags,
+	}
+}
+
+// Localize returns a copy of this instance that translates its
+// description, the names of its parameters, and the names of choices with
+// translate. Descriptions of ops.HueTask instances that the returned
+// instance generates are then entirely in the language of translate.
+// If the Factory of this instance implements FactoryEncoderDecoder, so
+// does the Factory of the returned instance.
+func (h *HueTask) Localize(translate Translator) *HueTask {
+	return &HueTask{
+		Id:          h.Id,
+		Description: translate.text(h.Description),
+		Factory: withParams(
+			h.Factory, localizeParams(h.Params(), translate)),
+		Tags: h.Tags,
+	}
+}
+
+// Schema returns the schema of this instance which describes its
+// parameters.
+func (h *HueTask) Schema() *TaskSchema {
+	return &TaskSchema{
+		Id:          h.Id,
+		Description: h.Description,
+		Params:      paramSchemas(h.Params()),
+	}
+}
+
+// SchemaJSON returns the schema of this instance as JSON so that REST
+// clients can render input forms for this instance.
+func (h *HueTask) SchemaJSON() ([]byte, error) {
+	return json.Marshal(h.Schema())
+}
+
+func paramSchemas(params NamedParamList) []*ParamSchema {
+	result := make([]*ParamSchema, len(params))
+	for i := range params {
+		result[i] = Schema(strconv.Itoa(i), params[i])
 	}
+	return result
 }
 
 // FromUrlValues generates an ops.HueTask based on url values from an html
@@ -197,18 +780,101 @@ func (h *HueTask) FromExplicit(
 // user supplied inputs would be under "p0" "p1" "p2" etc; values are the
 // url values. FromUrlValues includes the description of this instance along
 // with a description of each user supplied parameter in the returned
-// ops.HueTask
+// ops.HueTask. For a MultiSelectParam, FromUrlValues joins all the url
+// values for that parameter with commas before converting. For a
+// GroupParam, the value for row r and param j in that row of the ith
+// parameter is under "pi.r.j" e.g "p0.2.1".
 func (h *HueTask) FromUrlValues(prefix string, values url.Values) *ops.HueTask {
 	params := h.Params()
 	paramValues := make([]interface{}, len(params))
 	paramNames := make([]string, len(params))
 	for i := range params {
+		key := fmt.Sprintf("%s%d", prefix, i)
+		if group, ok := params[i].Param.(GroupParam); ok {
+			paramValues[i], paramNames[i] = group.ConvertRows(
+				groupRowsFromUrlValues(group, key, values))
+			continue
+		}
 		paramValues[i], paramNames[i] = params[i].Convert(
-			values.Get(fmt.Sprintf("%s%d", prefix, i)))
+			urlValue(params[i].Param, key, values))
 	}
 	return h.FromExplicit(h.New(paramValues), paramNames)
 }
 
+// FromUrlValuesStrict works like FromUrlValues except that it also reports
+// what the user entered incorrectly. If any parameter is invalid,
+// FromUrlValuesStrict returns a FieldErrors instance along with an
+// ops.HueTask that uses the default value for each invalid parameter
+// just as FromUrlValues would.
+func (h *HueTask) FromUrlValuesStrict(
+	prefix string, values url.Values) (*ops.HueTask, error) {
+	params := h.Params()
+	paramValues := make([]interface{}, len(params))
+	paramNames := make([]string, len(params))
+	var fieldErrors FieldErrors
+	for i := range params {
+		key := fmt.Sprintf("%s%d", prefix, i)
+		var value string
+		var err error
+		if group, ok := params[i].Param.(GroupParam); ok {
+			rows := groupRowsFromUrlValues(group, key, values)
+			if validating, ok := group.(ValidatingGroupParam); ok {
+				paramValues[i], paramNames[i], err = validating.ConvertRowsStrict(rows)
+			} else {
+				paramValues[i], paramNames[i] = group.ConvertRows(rows)
+			}
+		} else {
+			value = urlValue(params[i].Param, key, values)
+			paramValues[i], paramNames[i], err = ConvertStrict(
+				params[i].Param, value)
+		}
+		if err != nil {
+			fieldErrors = append(fieldErrors, &FieldError{
+				Index: i,
+				Name:  params[i].Name,
+				Value: value,
+				Err:   err,
+			})
+		}
+	}
+	task := h.FromExplicit(h.New(paramValues), paramNames)
+	if len(fieldErrors) > 0 {
+		return task, fieldErrors
+	}
+	return task, nil
+}
+
+func isMultiSelect(param Param) bool {
+	multi, ok := param.(MultiSelectParam)
+	return ok && multi.MultiSelect()
+}
+
+func urlValue(param Param, key string, values url.Values) string {
+	if isMultiSelect(param) {
+		return strings.Join(values[key], ",")
+	}
+	return values.Get(key)
+}
+
+// groupRowsFromUrlValues returns what the user entered for a GroupParam
+// stored under key. The value for row r and param p in each row is
+// under key.r.p e.g "p2.0.1".
+func groupRowsFromUrlValues(
+	group GroupParam, key string, values url.Values) [][]string {
+	rowParams := group.RowParams()
+	rows := make([][]string, group.MaxRows())
+	for r := range rows {
+		rows[r] = make([]string, len(rowParams))
+		for p := range rowParams {
+			rows[r][p] = urlValue(
+				rowParams[p].Param,
+				fmt.Sprintf("%s.%d.%d", key, r, p),
+				values)
+		}
+	}
+	return rows
+}
+
 func (h *HueTask) getDescription(names []string) string {
 	params := h.Params()
 	if len(params) == 0 {
@@ -253,6 +919,104 @@ func (l HueTaskList) SortByDescriptionIgnoreCase() HueTaskList {
 	return result
 }
 
+// Filter returns a new HueTaskList containing the HueTasks in this
+// instance for which predicate returns true in the same order.
+func (l HueTaskList) Filter(predicate func(h *HueTask) bool) HueTaskList {
+	var result HueTaskList
+	for _, h := range l {
+		if predicate(h) {
+			result = append(result, h)
+		}
+	}
+	return result
+}
+
+// ByTag returns a new HueTaskList containing the HueTasks in this
+// instance that have tag ignoring case.
+func (l HueTaskList) ByTag(tag string) HueTaskList {
+	return l.Filter(func(h *HueTask) bool {
+		return h.HasTag(tag)
+	})
+}
+
+// Search returns a new HueTaskList containing the HueTasks in this
+// instance whose description contains query ignoring case. An empty
+// query matches every HueTask.
+func (l HueTaskList) Search(query string) HueTaskList {
+	query = strings.ToLower(query)
+	return l.Filter(func(h *HueTask) bool {
+		return strings.Contains(strings.ToLower(h.Description), query)
+	})
+}
+
+// Registry holds the dynamic hue tasks of an installation by id.
+// Registry implements huedb.DynamicHueTaskStore. The zero value is an
+// empty registry ready to use. Registry instances are safe to use with
+// multiple goroutines.
+type Registry struct {
+	mutex sync.RWMutex
+	byId  map[int]*HueTask
+}
+
+// NewRegistry returns a new Registry holding tasks under their Id
+// fields. NewRegistry returns ErrDuplicateId if two tasks have the same
+// Id.
+func NewRegistry(tasks ...*HueTask) (*Registry, error) {
+	result := &Registry{}
+	for _, task := range tasks {
+		if err := result.Register(task.Id, task); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Register adds task under id. If this instance already has a task
+// under id, Register returns ErrDuplicateId and leaves this instance
+// unchanged.
+func (r *Registry) Register(id int, task *HueTask) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.byId[id]; ok {
+		return ErrDuplicateId
+	}
+	if r.byId == nil {
+		r.byId = make(map[int]*HueTask)
+	}
+	r.byId[id] = task
+	return nil
+}
+
+// MustRegister works like Register but panics on error.
+func (r *Registry) MustRegister(id int, task *HueTask) {
+	if err := r.Register(id, task); err != nil {
+		panic(fmt.Sprintf("%v: %d", err, id))
+	}
+}
+
+// ById returns the task under id or nil if there is no such task.
+func (r *Registry) ById(id int) *HueTask {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.byId[id]
+}
+
+// All returns all the tasks in this instance sorted by id.
+func (r *Registry) All() HueTaskList {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	ids := make([]int, 0, len(r.byId))
+	for id := range r.byId {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	result := make(HueTaskList, len(ids))
+	for i, id := range ids {
+		result[i] = r.byId[id]
+	}
+	return result
+}
+
 // ParamSerializer encodes parameters for hue tasks as a string.
 type ParamSerializer map[string][]string
 
@@ -266,9 +1030,41 @@ func (p ParamSerializer) Encode() string {
 	return buffer.String()
 }
 
-// NewParamSerializer decodes a string back into parameters. Caller can
-// safely modify the returned value.
+// EncodeBinary works like Encode except that it encodes stored parameters
+// in a compact binary format. EncodeBinary is for hue actions with many
+// parameters such as per-light scenes whose Encode output is large.
+// NewParamSerializer decodes what both Encode and EncodeBinary return.
+func (p ParamSerializer) EncodeBinary() string {
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var buffer []byte
+	buffer = appendUvarint(buffer, uint64(len(keys)))
+	for _, key := range keys {
+		buffer = appendBinaryString(buffer, key)
+		buffer = appendUvarint(buffer, uint64(len(p[key])))
+		for _, value := range p[key] {
+			if anint, err := strconv.Atoi(value); err == nil && strconv.Itoa(anint) == value {
+				buffer = append(buffer, kBinaryInt)
+				buffer = appendVarint(buffer, int64(anint))
+			} else {
+				buffer = append(buffer, kBinaryString)
+				buffer = appendBinaryString(buffer, value)
+			}
+		}
+	}
+	return kBinaryMarker + base64.RawURLEncoding.EncodeToString(buffer)
+}
+
+// NewParamSerializer decodes a string back into parameters. s can come
+// from either Encode or EncodeBinary. Caller can safely modify the
+// returned value.
 func NewParamSerializer(s string) (ParamSerializer, error) {
+	if strings.HasPrefix(s, kBinaryMarker) {
+		return decodeBinaryParamSerializer(s[len(kBinaryMarker):])
+	}
 	buffer := bytes.NewBufferString(s)
 	decoder := json.NewDecoder(buffer)
 	var result ParamSerializer
@@ -286,6 +1082,63 @@ func (p ParamSerializer) SetInt(key string, value int) ParamSerializer {
 // then returns ErrNoValue. May return a different error if the value
 // stored is corrupted or cannot be converted to an int.
 func (p ParamSerializer) GetInt(key string) (result int, err error) {
+	value, err := p.GetString(key)
+	if err != nil {
+		return
+	}
+	return strconv.Atoi(value)
+}
+
+// SetFloat stores a float64 value and returns this instance for chaining.
+func (p ParamSerializer) SetFloat(key string, value float64) ParamSerializer {
+	return p.SetString(key, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// GetFloat returns the stored float64 value. If no value stored under key
+// then returns ErrNoValue. May return a different error if the value
+// stored is corrupted or cannot be converted to a float64.
+func (p ParamSerializer) GetFloat(key string) (result float64, err error) {
+	value, err := p.GetString(key)
+	if err != nil {
+		return
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// SetDuration stores a duration value and returns this instance for
+// chaining.
+func (p ParamSerializer) SetDuration(
+	key string, value time.Duration) ParamSerializer {
+	return p.SetString(key, strconv.FormatInt(int64(value), 10))
+}
+
+// GetDuration returns the stored duration. If no value stored under key
+// then returns ErrNoValue. May return a different error if the value
+// stored is corrupted or cannot be converted to a duration.
+func (p ParamSerializer) GetDuration(key string) (
+	result time.Duration, err error) {
+	value, err := p.GetString(key)
+	if err != nil {
+		return
+	}
+	nanos, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return
+	}
+	result = time.Duration(nanos)
+	return
+}
+
+// SetString stores a string value and returns this instance for chaining.
+func (p ParamSerializer) SetString(key string, value string) ParamSerializer {
+	p[key] = []string{value}
+	return p
+}
+
+// GetString returns the stored string value. If no value stored under key
+// then returns ErrNoValue. May return a different error if the value
+// stored is corrupted.
+func (p ParamSerializer) GetString(key string) (result string, err error) {
 	value, ok := p[key]
 	if !ok {
 		err = ErrNoValue
@@ -295,7 +1148,24 @@ func (p ParamSerializer) GetInt(key string) (result int, err error) {
 		err = errBadValue
 		return
 	}
-	return strconv.Atoi(value[0])
+	result = value[0]
+	return
+}
+
+// SetBool stores a bool value and returns this instance for chaining.
+func (p ParamSerializer) SetBool(key string, value bool) ParamSerializer {
+	return p.SetString(key, strconv.FormatBool(value))
+}
+
+// GetBool returns the stored bool value. If no value stored under key
+// then returns ErrNoValue. May return a different error if the value
+// stored is corrupted or cannot be converted to a bool.
+func (p ParamSerializer) GetBool(key string) (result bool, err error) {
+	value, err := p.GetString(key)
+	if err != nil {
+		return
+	}
+	return strconv.ParseBool(value)
 }
 
 // SetBrightness stores a brightness value and returns this instance
@@ -322,9 +1192,7 @@ func (p ParamSerializer) GetBrightness(key string) (result uint8, err error) {
 
 // SetColor stores an color value and returns this instance for chaining.
 func (p ParamSerializer) SetColor(key string, color gohue.Color) ParamSerializer {
-	x := int(color.X()*10000.0 + 0.5)
-	y := int(color.Y()*10000.0 + 0.5)
-	p[key] = []string{strconv.Itoa(x), strconv.Itoa(y)}
+	p[key] = appendColorStrings(nil, color)
 	return p
 }
 
@@ -341,18 +1209,42 @@ func (p ParamSerializer) GetColor(key string) (result gohue.Color, err error) {
 		err = errBadValue
 		return
 	}
-	var x, y int
-	if x, err = strconv.Atoi(value[0]); err != nil {
-		return
+	return colorFromStrings(value[0], value[1])
+}
+
+// SetColorList stores a list of colors and returns this instance for
+// chaining.
+func (p ParamSerializer) SetColorList(
+	key string, colors []gohue.Color) ParamSerializer {
+	value := make([]string, 0, 2*len(colors))
+	for _, color := range colors {
+		value = appendColorStrings(value, color)
 	}
-	if y, err = strconv.Atoi(value[1]); err != nil {
+	p[key] = value
+	return p
+}
+
+// GetColorList returns the stored list of colors. If no value stored under
+// key then returns ErrNoValue. May return a different error if the value
+// stored is corrupted or cannot be converted to a list of colors.
+func (p ParamSerializer) GetColorList(key string) (
+	result []gohue.Color, err error) {
+	value, ok := p[key]
+	if !ok {
+		err = ErrNoValue
 		return
 	}
-	if x < 0 || x > 10000 || y < 0 || y > 10000 {
+	if len(value)%2 != 0 {
 		err = errBadValue
 		return
 	}
-	result = gohue.NewColor(float64(x)/10000.0, float64(y)/10000.0)
+	result = make([]gohue.Color, len(value)/2)
+	for i := range result {
+		if result[i], err = colorFromStrings(
+			value[2*i], value[2*i+1]); err != nil {
+			return nil, err
+		}
+	}
 	return
 }
 
@@ -479,6 +1371,641 @@ func (p PlainColorFactory) Decode(s string) (action ops.HueAction, err error) {
 	return
 }
 
+// CustomSceneFactory implements Factory and lets the user choose the color
+// and brightness of each light individually from one form. Each row of
+// the form has a light id, a color, and a brightness. Light id 0 means
+// all lights not given their own row. The user must enter the light id of
+// each row; FromUrlValues skips rows without one. The zero value lets the
+// user fill in up to 10 rows.
+type CustomSceneFactory struct {
+	// The maximum number of rows. 0 means 10.
+	MaxLights int
+}
+
+func (p CustomSceneFactory) Params() NamedParamList {
+	maxLights := p.MaxLights
+	if maxLights <= 0 {
+		maxLights = 10
+	}
+	return NamedParamList{
+		{
+			Name:  LightsParamName,
+			Param: Group(maxLights, kCustomSceneRowParams),
+		},
+	}
+}
+
+func (p CustomSceneFactory) New(values []interface{}) ops.HueAction {
+	rows := values[0].([][]interface{})
+	result := make(ops.LightColors, len(rows))
+	for _, row := range rows {
+		id := row[0].(int)
+		if id == kNoLightId {
+			continue
+		}
+		result[id] = ops.ColorBrightness{
+			Color:      gohue.NewMaybeColor(row[1].(gohue.Color)),
+			Brightness: maybe.NewUint8(uint8(row[2].(int))),
+		}
+	}
+	return customScene(result)
+}
+
+// colors are the color and brightness of each light.
+func (p CustomSceneFactory) NewExplicit(
+	colors ops.LightColors) (
+	action ops.HueAction, paramsAsStrings []string) {
+	ids := make([]int, 0, len(colors))
+	for id := range colors {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	rows := make([]string, len(ids))
+	for i, id := range ids {
+		rows[i] = fmt.Sprintf(
+			"%s: %d %s: %s %s: %d",
+			LightParamName,
+			id,
+			ColorParamName,
+			colors[id].Color.Color,
+			BrightnessParamName,
+			colors[id].Brightness.Value)
+	}
+	desc := "None"
+	if len(rows) > 0 {
+		desc = strings.Join(rows, "; ")
+	}
+	return customScene(colors), []string{desc}
+}
+
+// Encode encodes a HueAction that this instance created as a string
+func (p CustomSceneFactory) Encode(action ops.HueAction) string {
+	serializer := make(ParamSerializer)
+	colors, ok := action.(customSceneAction)
+	if !ok {
+		colors = customSceneAction(action.(ops.StaticHueAction))
+	}
+	for id, cb := range colors {
+		serializer.SetColor(
+			fmt.Sprintf("%s.%d", ColorParamName, id), cb.Color.Color)
+		serializer.SetBrightness(
+			fmt.Sprintf("%s.%d", BrightnessParamName, id),
+			cb.Brightness.Value)
+	}
+	return serializer.Encode()
+}
+
+// Decode decodes a string that Encode produced back into a HueAction.
+func (p CustomSceneFactory) Decode(s string) (action ops.HueAction, err error) {
+	serializer, err := NewParamSerializer(s)
+	if err != nil {
+		return
+	}
+	result := make(ops.LightColors)
+	prefix := ColorParamName + "."
+	for key := range serializer {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		var id int
+		if id, err = strconv.Atoi(key[len(prefix):]); err != nil {
+			return
+		}
+		var color gohue.Color
+		if color, err = serializer.GetColor(key); err != nil {
+			return
+		}
+		var brightness uint8
+		if brightness, err = serializer.GetBrightness(
+			fmt.Sprintf("%s.%d", BrightnessParamName, id)); err != nil {
+			return
+		}
+		result[id] = ops.ColorBrightness{
+			Color:      gohue.NewMaybeColor(color),
+			Brightness: maybe.NewUint8(brightness),
+		}
+	}
+	action = customScene(result)
+	return
+}
+
+// customScene returns the HueAction that sets each light to its color
+// and brightness in colors. Light id 0 in colors means all lights not in
+// colors.
+func customScene(colors ops.LightColors) ops.HueAction {
+	if _, ok := colors[0]; ok {
+		return customSceneAction(colors)
+	}
+	return ops.StaticHueAction(colors)
+}
+
+// customSceneAction works like ops.StaticHueAction except that light id 0
+// means only the lights without their own color and brightness.
+// These instances must be treated as immutable.
+type customSceneAction ops.LightColors
+
+func (a customSceneAction) Do(
+	ctxt ops.Context, lightSet lights.Set, e *tasks.Execution) {
+	rows := make(ops.StaticHueAction, len(a))
+	for id, cb := range a {
+		if id != 0 {
+			rows[id] = cb
+		}
+	}
+	others := lightSet
+	if !lightSet.IsAll() {
+		others = lightSet.Subtract(rows.UsedLights(lights.All))
+	}
+	// When run on all lights, there is no way to leave out the lights with
+	// their own row, so they get the default before their own color.
+	if !others.IsNone() {
+		ops.StaticHueAction{0: a[0]}.Do(ctxt, others, e)
+	}
+	if rowLights := rows.UsedLights(lightSet); !rowLights.IsNone() {
+		rows.Do(ctxt, rowLights, e)
+	}
+}
+
+func (a customSceneAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
+
+var (
+	kCustomSceneRowParams = NamedParamList{
+		{Name: LightParamName, Param: &lightIdParam{
+			intParam{MinValue: 0, MaxValue: 999, MaxChars: 3}}},
+		{Name: ColorParamName, Param: ColorPicker(gohue.White, "White")},
+		{Name: BrightnessParamName, Param: Brightness()},
+	}
+)
+
+// CycleFactory implements Factory and lets the user pick 2 to 5 colors,
+// how long to show each color, and brightness and then generates an
+// ops.CycleAction. The default is to cycle between red, green, and blue
+// every 10 seconds at full brightness.
+type CycleFactory struct {
+}
+
+func (p CycleFactory) Params() NamedParamList {
+	return kCycleParams
+}
+
+func (p CycleFactory) New(values []interface{}) ops.HueAction {
+	choices := values[0].([]interface{})
+	colors := make([]gohue.Color, len(choices))
+	for i := range choices {
+		colors[i] = choices[i].(gohue.Color)
+	}
+	return ops.CycleAction{
+		Colors:     colors,
+		Step:       values[1].(time.Duration),
+		Brightness: uint8(values[2].(int)),
+	}
+}
+
+// colors are the colors in the order shown; colorsString is the string
+// representation of the colors; step is how long each color is shown;
+// brightness is the brightness of the lights.
+func (p CycleFactory) NewExplicit(
+	colors []gohue.Color,
+	colorsString string,
+	step time.Duration,
+	brightness uint8) (action ops.HueAction, paramsAsStrings []string) {
+	briStr := strconv.Itoa(int(brightness))
+	action = ops.CycleAction{
+		Colors:     colors,
+		Step:       step,
+		Brightness: brightness,
+	}
+	return action, []string{colorsString, formatDuration(step), briStr}
+}
+
+// Encode encodes a HueAction that this instance created as a string
+func (p CycleFactory) Encode(action ops.HueAction) string {
+	cycle := action.(ops.CycleAction)
+	serializer := make(ParamSerializer)
+	serializer.SetInt(ColorsParamName, len(cycle.Colors))
+	for i, color := range cycle.Colors {
+		serializer.SetColor(fmt.Sprintf("%s.%d", ColorParamName, i), color)
+	}
+	serializer.SetInt(StepParamName, int(cycle.Step/time.Millisecond))
+	serializer.SetBrightness(BrightnessParamName, cycle.Brightness)
+	return serializer.Encode()
+}
+
+// Decode decodes a string that Encode produced back into a HueAction.
+func (p CycleFactory) Decode(s string) (action ops.HueAction, err error) {
+	serializer, err := NewParamSerializer(s)
+	if err != nil {
+		return
+	}
+	count, err := serializer.GetInt(ColorsParamName)
+	if err != nil {
+		return
+	}
+	if count < 0 {
+		err = errBadValue
+		return
+	}
+	colors := make([]gohue.Color, count)
+	for i := range colors {
+		colors[i], err = serializer.GetColor(
+			fmt.Sprintf("%s.%d", ColorParamName, i))
+		if err != nil {
+			return
+		}
+	}
+	stepMillis, err := serializer.GetInt(StepParamName)
+	if err != nil {
+		return
+	}
+	brightness, err := serializer.GetBrightness(BrightnessParamName)
+	if err != nil {
+		return
+	}
+	action = ops.CycleAction{
+		Colors:     colors,
+		Step:       time.Duration(stepMillis) * time.Millisecond,
+		Brightness: brightness,
+	}
+	return
+}
+
+var (
+	kCycleParams = NamedParamList{
+		{
+			Name: ColorsParamName,
+			Param: BoundedMultiPicker(
+				kColorChoices,
+				2,
+				5,
+				[]interface{}{gohue.Red, gohue.Green, gohue.Blue},
+				"Red, Green, Blue"),
+		},
+		{
+			Name:  StepParamName,
+			Param: Duration(time.Second, time.Hour, 10*time.Second),
+		},
+		{Name: BrightnessParamName, Param: Brightness()},
+	}
+)
+
+// RandomColorFactory implements Factory and lets the user provide how
+// often lights change color, brightness, and a seed and then generates an
+// ops.RandomColorAction. Because the seed is part of the generated action,
+// a persisted instance replays the same sequence of colors each time it
+// runs. The default is to change colors every 5 seconds at full
+// brightness with a seed of 1.
+type RandomColorFactory struct {
+}
+
+func (p RandomColorFactory) Params() NamedParamList {
+	return kRandomColorParams
+}
+
+func (p RandomColorFactory) New(values []interface{}) ops.HueAction {
+	return ops.RandomColorAction{
+		Interval:   values[0].(time.Duration),
+		Brightness: uint8(values[1].(int)),
+		Seed:       int64(values[2].(int)),
+	}
+}
+
+// interval is how often the lights change color; brightness is the
+// brightness of the lights; seed determines the sequence of colors.
+func (p RandomColorFactory) NewExplicit(
+	interval time.Duration,
+	brightness uint8,
+	seed int64) (action ops.HueAction, paramsAsStrings []string) {
+	action = ops.RandomColorAction{
+		Interval:   interval,
+		Brightness: brightness,
+		Seed:       seed,
+	}
+	return action, []string{
+		formatDuration(interval),
+		strconv.Itoa(int(brightness)),
+		strconv.FormatInt(seed, 10),
+	}
+}
+
+// Encode encodes a HueAction that this instance created as a string
+func (p RandomColorFactory) Encode(action ops.HueAction) string {
+	random := action.(ops.RandomColorAction)
+	serializer := make(ParamSerializer)
+	serializer.SetInt(
+		IntervalParamName, int(random.Interval/time.Millisecond))
+	serializer.SetBrightness(BrightnessParamName, random.Brightness)
+	serializer.SetInt(SeedParamName, int(random.Seed))
+	return serializer.Encode()
+}
+
+// Decode decodes a string that Encode produced back into a HueAction.
+func (p RandomColorFactory) Decode(s string) (action ops.HueAction, err error) {
+	serializer, err := NewParamSerializer(s)
+	if err != nil {
+		return
+	}
+	intervalMillis, err := serializer.GetInt(IntervalParamName)
+	if err != nil {
+		return
+	}
+	brightness, err := serializer.GetBrightness(BrightnessParamName)
+	if err != nil {
+		return
+	}
+	seed, err := serializer.GetInt(SeedParamName)
+	if err != nil {
+		return
+	}
+	action = ops.RandomColorAction{
+		Interval:   time.Duration(intervalMillis) * time.Millisecond,
+		Brightness: brightness,
+		Seed:       int64(seed),
+	}
+	return
+}
+
+var (
+	kRandomColorParams = NamedParamList{
+		{
+			Name:  IntervalParamName,
+			Param: Duration(time.Second, time.Hour, 5*time.Second),
+		},
+		{Name: BrightnessParamName, Param: Brightness()},
+		{Name: SeedParamName, Param: Int(0, 999999, 1, 6)},
+	}
+)
+
+// Compose returns a Factory whose params are the params of each factory
+// in order and whose hue actions run the hue actions of each factory one
+// after the other using ops.Sequence. For example, composing a factory
+// that sets a color with one that blinks offers "set color then blink"
+// as a single dynamic task. If every factory implements
+// FactoryEncoderDecoder, so does the returned Factory.
+func Compose(factories ...Factory) Factory {
+	return newComposedFactory(ops.Sequence, factories)
+}
+
+// ComposeParallel works like Compose except that the hue actions of the
+// factories run concurrently using ops.Parallel.
+func ComposeParallel(factories ...Factory) Factory {
+	return newComposedFactory(ops.Parallel, factories)
+}
+
+// FormatVersion prefixes an encoded hue action with a version marker such
+// as "v2:". version must be positive.
+func FormatVersion(version int, encoded string) string {
+	return fmt.Sprintf("v%d:%s", version, encoded)
+}
+
+// ParseVersion splits an encoded hue action into its version and what
+// follows the version marker. Encodings without a version marker, which
+// include all encodings made before there were version markers, are
+// version 0, and ParseVersion returns them unchanged.
+func ParseVersion(s string) (version int, encoded string) {
+	colon := strings.IndexByte(s, ':')
+	if colon < 2 || s[0] != 'v' {
+		return 0, s
+	}
+	version, err := strconv.Atoi(s[1:colon])
+	if err != nil || version <= 0 {
+		return 0, s
+	}
+	return version, s[colon+1:]
+}
+
+// Binary returns a FactoryEncoderDecoder that works like factory except
+// that it encodes hue actions with ParamSerializer.EncodeBinary instead of
+// ParamSerializer.Encode. The returned instance still decodes what factory
+// encodes so that hue actions persisted before switching to Binary
+// continue to work. If factory does not encode hue actions with a
+// ParamSerializer, the returned instance encodes them just as factory
+// does.
+func Binary(factory FactoryEncoderDecoder) FactoryEncoderDecoder {
+	return binaryFactory{factory}
+}
+
+// DecoderFunc converts a function to a Decoder.
+type DecoderFunc func(encoded string) (ops.HueAction, error)
+
+func (f DecoderFunc) Decode(encoded string) (ops.HueAction, error) {
+	return f(encoded)
+}
+
+// VersionedFactory adds versioned encoding to a Factory so that the
+// Factory can change how it encodes hue actions without breaking hue
+// actions already persisted. Encode always encodes at Version while
+// Decode picks the decoder that matches the version of what it decodes.
+// VersionedFactory implements FactoryEncoderDecoder.
+// These instances must be treated as immutable.
+type VersionedFactory struct {
+	Factory
+
+	// The current version. Must be positive.
+	Version int
+
+	// Encodes hue actions at the current version
+	Encoder Encoder
+
+	// Decoders by version. Decoders[0] decodes encodings made before
+	// the Factory used versions. Decoders for older versions typically
+	// build the hue action the way the current version would so that
+	// old encodings are migrated when read.
+	Decoders map[int]Decoder
+}
+
+// Encode encodes action at the current version.
+func (f *VersionedFactory) Encode(action ops.HueAction) string {
+	return FormatVersion(f.Version, f.Encoder.Encode(action))
+}
+
+// Decode decodes a string that Encode produced at any version for which
+// there is a decoder. If there is no such decoder, Decode returns
+// ErrUnknownVersion.
+func (f *VersionedFactory) Decode(s string) (ops.HueAction, error) {
+	version, encoded := ParseVersion(s)
+	decoder, ok := f.Decoders[version]
+	if !ok {
+		return nil, ErrUnknownVersion
+	}
+	return decoder.Decode(encoded)
+}
+
+// Migrate converts s, which can be at any version for which there is a
+// decoder, to the current version.
+func (f *VersionedFactory) Migrate(s string) (string, error) {
+	if version, _ := ParseVersion(s); version == f.Version {
+		return s, nil
+	}
+	action, err := f.Decode(s)
+	if err != nil {
+		return "", err
+	}
+	return f.Encode(action), nil
+}
+
+const (
+	// Binary encodings start with this marker which JSON never starts with.
+	kBinaryMarker = "!"
+
+	kBinaryInt    = 0
+	kBinaryString = 1
+)
+
+type binaryFactory struct {
+	FactoryEncoderDecoder
+}
+
+func (f binaryFactory) Encode(action ops.HueAction) string {
+	encoded := f.FactoryEncoderDecoder.Encode(action)
+	serializer, err := NewParamSerializer(encoded)
+	if err != nil || serializer == nil {
+		return encoded
+	}
+	return serializer.EncodeBinary()
+}
+
+// appendColorStrings appends the x and y of color as integers scaled by
+// 10000.
+func appendColorStrings(value []string, color gohue.Color) []string {
+	x := int(color.X()*10000.0 + 0.5)
+	y := int(color.Y()*10000.0 + 0.5)
+	return append(value, strconv.Itoa(x), strconv.Itoa(y))
+}
+
+func colorFromStrings(xStr, yStr string) (result gohue.Color, err error) {
+	var x, y int
+	if x, err = strconv.Atoi(xStr); err != nil {
+		return
+	}
+	if y, err = strconv.Atoi(yStr); err != nil {
+		return
+	}
+	if x < 0 || x > 10000 || y < 0 || y > 10000 {
+		err = errBadValue
+		return
+	}
+	result = gohue.NewColor(float64(x)/10000.0, float64(y)/10000.0)
+	return
+}
+
+func appendUvarint(buffer []byte, x uint64) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	return append(buffer, scratch[:binary.PutUvarint(scratch[:], x)]...)
+}
+
+func appendVarint(buffer []byte, x int64) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	return append(buffer, scratch[:binary.PutVarint(scratch[:], x)]...)
+}
+
+func appendBinaryString(buffer []byte, s string) []byte {
+	buffer = appendUvarint(buffer, uint64(len(s)))
+	return append(buffer, s...)
+}
+
+// binaryReader reads what EncodeBinary writes. Once it encounters an
+// error, it stops reading and remembers the error.
+type binaryReader struct {
+	buffer []byte
+	err    error
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	result, n := binary.Uvarint(r.buffer)
+	if n <= 0 {
+		r.err = errBadValue
+		return 0
+	}
+	r.buffer = r.buffer[n:]
+	return result
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	result, n := binary.Varint(r.buffer)
+	if n <= 0 {
+		r.err = errBadValue
+		return 0
+	}
+	r.buffer = r.buffer[n:]
+	return result
+}
+
+func (r *binaryReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.buffer) == 0 {
+		r.err = errBadValue
+		return 0
+	}
+	result := r.buffer[0]
+	r.buffer = r.buffer[1:]
+	return result
+}
+
+func (r *binaryReader) string() string {
+	length := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if length > uint64(len(r.buffer)) {
+		r.err = errBadValue
+		return ""
+	}
+	result := string(r.buffer[:length])
+	r.buffer = r.buffer[length:]
+	return result
+}
+
+// count reads a count of items each of which takes at least one byte.
+func (r *binaryReader) count() int {
+	result := r.uvarint()
+	if r.err == nil && result > uint64(len(r.buffer)) {
+		r.err = errBadValue
+	}
+	return int(result)
+}
+
+func decodeBinaryParamSerializer(s string) (ParamSerializer, error) {
+	buffer, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	reader := &binaryReader{buffer: buffer}
+	keyCount := reader.count()
+	result := make(ParamSerializer, keyCount)
+	for i := 0; i < keyCount && reader.err == nil; i++ {
+		key := reader.string()
+		values := make([]string, reader.count())
+		for j := 0; j < len(values) && reader.err == nil; j++ {
+			switch reader.byte() {
+			case kBinaryInt:
+				values[j] = strconv.FormatInt(reader.varint(), 10)
+			case kBinaryString:
+				values[j] = reader.string()
+			default:
+				reader.err = errBadValue
+			}
+		}
+		result[key] = values
+	}
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	if len(reader.buffer) != 0 {
+		return nil, errBadValue
+	}
+	return result, nil
+}
+
 func plainAction(color gohue.Color, brightness uint8) ops.HueAction {
 	return ops.StaticHueAction{
 		0: ops.ColorBrightness{
@@ -501,7 +2028,9 @@ var (
 )
 
 var (
-	kBrightness   = Int(0, 255, 255, 3)
+	kBrightness = WithMetadata(
+		Int(0, 255, 255, 3),
+		ParamMetadata{Step: 1, Widget: SliderWidget})
 	kColorChoices = ChoiceList{
 		{"Red", gohue.Red},
 		{"Green", gohue.Green},
@@ -535,23 +2064,339 @@ func (p *intParam) MaxCharCount() int {
 	return p.MaxChars
 }
 
+func (p *intParam) Schema() *ParamSchema {
+	return &ParamSchema{
+		Type:     IntType,
+		Min:      p.MinValue,
+		Max:      p.MaxValue,
+		Default:  p.DefaultValue,
+		MaxChars: p.MaxChars,
+	}
+}
+
 func (p *intParam) Convert(s string) (interface{}, string) {
+	value, str, _ := p.ConvertStrict(s)
+	return value, str
+}
+
+func (p *intParam) ConvertStrict(s string) (interface{}, string, error) {
+	if s == "" {
+		return p.DefaultValue, strconv.Itoa(p.DefaultValue), nil
+	}
 	result, err := strconv.Atoi(s)
 	if err != nil || result > p.MaxValue || result < p.MinValue {
-		result = p.DefaultValue
+		return p.DefaultValue, strconv.Itoa(p.DefaultValue), fmt.Errorf(
+			"must be a whole number from %d to %d", p.MinValue, p.MaxValue)
 	}
-	return result, strconv.Itoa(result)
+	return result, strconv.Itoa(result), nil
+}
+
+const (
+	// What lightIdParam converts a blank light id to
+	kNoLightId = -1
+)
+
+// lightIdParam works like intParam except that the user must enter a
+// value. When the user leaves it blank, lightIdParam converts to
+// kNoLightId.
+type lightIdParam struct {
+	intParam
+}
+
+func (p *lightIdParam) Schema() *ParamSchema {
+	result := p.intParam.Schema()
+	result.Default = nil
+	return result
+}
+
+func (p *lightIdParam) Convert(s string) (interface{}, string) {
+	value, str, _ := p.ConvertStrict(s)
+	return value, str
+}
+
+func (p *lightIdParam) ConvertStrict(s string) (interface{}, string, error) {
+	if s == "" {
+		return kNoLightId, "", errLightIdRequired
+	}
+	return p.intParam.ConvertStrict(s)
+}
+
+type floatParam struct {
+	noSelect
+	MinValue     float64
+	MaxValue     float64
+	DefaultValue float64
+	Precision    int
+	MaxChars     int
+}
+
+func (p *floatParam) MaxCharCount() int {
+	return p.MaxChars
+}
+
+func (p *floatParam) Schema() *ParamSchema {
+	return &ParamSchema{
+		Type:      FloatType,
+		Min:       p.MinValue,
+		Max:       p.MaxValue,
+		Default:   p.DefaultValue,
+		MaxChars:  p.MaxChars,
+		Precision: p.Precision,
+	}
+}
+
+func (p *floatParam) Convert(s string) (interface{}, string) {
+	value, str, _ := p.ConvertStrict(s)
+	return value, str
+}
+
+func (p *floatParam) ConvertStrict(s string) (interface{}, string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return p.round(p.DefaultValue)
+	}
+	result, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(result) || result > p.MaxValue || result < p.MinValue {
+		value, str, _ := p.round(p.DefaultValue)
+		return value, str, fmt.Errorf(
+			"must be a number from %s to %s",
+			strconv.FormatFloat(p.MinValue, 'f', -1, 64),
+			strconv.FormatFloat(p.MaxValue, 'f', -1, 64))
+	}
+	return p.round(result)
+}
+
+func (p *floatParam) round(x float64) (interface{}, string, error) {
+	scale := math.Pow(10.0, float64(p.Precision))
+	x = math.Round(x*scale) / scale
+	return x, strconv.FormatFloat(x, 'f', p.Precision, 64), nil
+}
+
+type durationParam struct {
+	noSelect
+	MinValue     time.Duration
+	MaxValue     time.Duration
+	DefaultValue time.Duration
+}
+
+func (p *durationParam) MaxCharCount() int {
+	return 8
+}
+
+func (p *durationParam) Schema() *ParamSchema {
+	return &ParamSchema{
+		Type:     DurationType,
+		Min:      formatDuration(p.MinValue),
+		Max:      formatDuration(p.MaxValue),
+		Default:  formatDuration(p.DefaultValue),
+		MaxChars: p.MaxCharCount(),
+	}
+}
+
+func (p *durationParam) Convert(s string) (interface{}, string) {
+	value, str, _ := p.ConvertStrict(s)
+	return value, str
+}
+
+func (p *durationParam) ConvertStrict(s string) (interface{}, string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return p.DefaultValue, formatDuration(p.DefaultValue), nil
+	}
+	result, err := time.ParseDuration(s)
+	if err != nil || result > p.MaxValue || result < p.MinValue {
+		return p.DefaultValue, formatDuration(p.DefaultValue), fmt.Errorf(
+			"must be a duration such as 1m30s from %s to %s",
+			formatDuration(p.MinValue), formatDuration(p.MaxValue))
+	}
+	return result, formatDuration(result), nil
+}
+
+type textParam struct {
+	noSelect
+	MaxChars     int
+	DefaultValue string
+}
+
+func (p *textParam) MaxCharCount() int {
+	return p.MaxChars
+}
+
+func (p *textParam) Localize(translate Translator) Param {
+	return &textParam{
+		MaxChars:     p.MaxChars,
+		DefaultValue: translate.text(p.DefaultValue),
+	}
+}
+
+func (p *textParam) Schema() *ParamSchema {
+	return &ParamSchema{
+		Type:     TextType,
+		Default:  p.DefaultValue,
+		MaxChars: p.MaxChars,
+	}
+}
+
+func (p *textParam) Convert(s string) (interface{}, string) {
+	value, str, _ := p.ConvertStrict(s)
+	return value, str
+}
+
+func (p *textParam) ConvertStrict(s string) (interface{}, string, error) {
+	result := strings.TrimSpace(s)
+	var err error
+	if runes := []rune(result); len(runes) > p.MaxChars {
+		result = strings.TrimSpace(string(runes[:p.MaxChars]))
+		err = fmt.Errorf("must be at most %d characters", p.MaxChars)
+	}
+	if result == "" {
+		result = p.DefaultValue
+	}
+	return result, result, err
+}
+
+type freeColorParam struct {
+	noSelect
+	DefaultValue gohue.Color
+	DefaultName  string
+}
+
+func (p *freeColorParam) MaxCharCount() int {
+	return 13
+}
+
+func (p *freeColorParam) Localize(translate Translator) Param {
+	return &freeColorParam{
+		DefaultValue: p.DefaultValue,
+		DefaultName:  translate.text(p.DefaultName),
+	}
+}
+
+func (p *freeColorParam) Schema() *ParamSchema {
+	return &ParamSchema{
+		Type: ColorType,
+		Default: fmt.Sprintf(
+			"%.4f,%.4f", p.DefaultValue.X(), p.DefaultValue.Y()),
+		DefaultName: p.DefaultName,
+		MaxChars:    p.MaxCharCount(),
+	}
+}
+
+func (p *freeColorParam) Convert(s string) (interface{}, string) {
+	value, str, _ := p.ConvertStrict(s)
+	return value, str
+}
+
+func (p *freeColorParam) ConvertStrict(s string) (interface{}, string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return p.DefaultValue, p.DefaultName, nil
+	}
+	if strings.HasPrefix(s, "#") {
+		if result, ok := parseHexColor(s[1:]); ok {
+			return result, strings.ToUpper(s), nil
+		}
+	} else if result, ok := parseXYColor(s); ok {
+		return result, result.String(), nil
+	}
+	return p.DefaultValue, p.DefaultName, errors.New(
+		"must be a color such as #FF8000 or 0.675,0.322")
+}
+
+// parseHexColor converts an RGB color in RRGGBB form to a gohue.Color.
+// Black has no color, so parseHexColor rejects it.
+func parseHexColor(s string) (result gohue.Color, ok bool) {
+	if len(s) != 6 {
+		return
+	}
+	rgb, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return
+	}
+	r := gammaCorrect(float64(rgb>>16) / 255.0)
+	g := gammaCorrect(float64((rgb>>8)&0xff) / 255.0)
+	b := gammaCorrect(float64(rgb&0xff) / 255.0)
+	x := r*0.664511 + g*0.154324 + b*0.162028
+	y := r*0.283881 + g*0.668433 + b*0.047685
+	z := r*0.000088 + g*0.072310 + b*0.986039
+	sum := x + y + z
+	if sum == 0.0 {
+		return
+	}
+	return gohue.NewColor(x/sum, y/sum), true
+}
+
+// gammaCorrect converts an sRGB component between 0.0 and 1.0 to a
+// linear one.
+func gammaCorrect(c float64) float64 {
+	if c > 0.04045 {
+		return math.Pow((c+0.055)/1.055, 2.4)
+	}
+	return c / 12.92
+}
+
+// parseXYColor converts "x,y" to a gohue.Color.
+func parseXYColor(s string) (result gohue.Color, ok bool) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return
+	}
+	x, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || !(x >= 0.0 && x <= 1.0) {
+		return
+	}
+	y, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || !(y >= 0.0 && y <= 1.0) {
+		return
+	}
+	return gohue.NewColor(x, y), true
+}
+
+// formatDuration formats d like time.Duration.String but without
+// trailing zero units so that 90 minutes is "1h30m" rather than
+// "1h30m0s".
+func formatDuration(d time.Duration) string {
+	result := d.String()
+	if strings.HasSuffix(result, "m0s") {
+		result = result[:len(result)-2]
+	}
+	if strings.HasSuffix(result, "h0m") {
+		result = result[:len(result)-2]
+	}
+	return result
 }
 
 type picker struct {
 	Choices      ChoiceList
 	DefaultValue interface{}
 	DefaultName  string
+	translate    Translator
+}
+
+func (p *picker) Localize(translate Translator) Param {
+	result := p.localize(translate)
+	return &result
+}
+
+func (p *picker) localize(translate Translator) picker {
+	choices := make(ChoiceList, len(p.Choices))
+	for i := range p.Choices {
+		choices[i] = Choice{
+			Name:  translate.text(p.Choices[i].Name),
+			Value: p.Choices[i].Value,
+		}
+	}
+	return picker{
+		Choices:      choices,
+		DefaultValue: p.DefaultValue,
+		DefaultName:  translate.text(p.DefaultName),
+		translate:    translate,
+	}
 }
 
 func (p *picker) Selection() []string {
 	result := make([]string, len(p.Choices)+1)
-	result[0] = "--Pick one--"
+	result[0] = p.translate.text("--Pick one--")
 	for i := range p.Choices {
 		result[i+1] = p.Choices[i].Name
 	}
@@ -562,12 +2407,458 @@ func (p *picker) MaxCharCount() int {
 	return 0
 }
 
+func (p *picker) Schema() *ParamSchema {
+	return &ParamSchema{
+		Type:        ChoiceType,
+		DefaultName: p.DefaultName,
+		Choices:     p.Selection()[1:],
+	}
+}
+
 func (p *picker) Convert(s string) (interface{}, string) {
-	val, _ := strconv.Atoi(s)
-	if val < 1 || val > len(p.Choices) {
-		return p.DefaultValue, p.DefaultName
+	value, str, _ := p.ConvertStrict(s)
+	return value, str
+}
+
+func (p *picker) ConvertStrict(s string) (interface{}, string, error) {
+	val, err := strconv.Atoi(s)
+	if s == "" || (err == nil && val == 0) {
+		return p.DefaultValue, p.DefaultName, nil
+	}
+	if err != nil || val < 1 || val > len(p.Choices) {
+		return p.DefaultValue, p.DefaultName, errBadChoice
+	}
+	return p.Choices[val-1].Value, p.Choices[val-1].Name, nil
+}
+
+type keyedPicker struct {
+	picker
+	keys []string
+}
+
+func (p *keyedPicker) Localize(translate Translator) Param {
+	return &keyedPicker{picker: p.picker.localize(translate), keys: p.keys}
+}
+
+func (p *keyedPicker) Schema() *ParamSchema {
+	result := p.picker.Schema()
+	result.ChoiceKeys = make([]string, len(p.keys))
+	for i, key := range p.keys {
+		if key == "" {
+			key = strconv.Itoa(i + 1)
+		}
+		result.ChoiceKeys[i] = key
+	}
+	return result
+}
+
+func (p *keyedPicker) Convert(s string) (interface{}, string) {
+	value, str, _ := p.ConvertStrict(s)
+	return value, str
+}
+
+func (p *keyedPicker) ConvertStrict(s string) (interface{}, string, error) {
+	if s != "" {
+		for i, key := range p.keys {
+			if key == s {
+				return p.Choices[i].Value, p.Choices[i].Name, nil
+			}
+		}
+	}
+	return p.picker.ConvertStrict(s)
+}
+
+type multiPicker struct {
+	picker
+	minCount int
+	maxCount int
+}
+
+func (p *multiPicker) Selection() []string {
+	result := p.picker.Selection()
+	result[0] = p.translate.text("--Pick one or more--")
+	return result
+}
+
+func (p *multiPicker) Localize(translate Translator) Param {
+	return &multiPicker{
+		picker:   p.picker.localize(translate),
+		minCount: p.minCount,
+		maxCount: p.maxCount,
+	}
+}
+
+func (p *multiPicker) MultiSelect() bool {
+	return true
+}
+
+func (p *multiPicker) Schema() *ParamSchema {
+	return &ParamSchema{
+		Type:        MultiChoiceType,
+		DefaultName: p.DefaultName,
+		Choices:     p.Selection()[1:],
+		MinCount:    p.minCount,
+		MaxCount:    p.maxCount,
+	}
+}
+
+func (p *multiPicker) Convert(s string) (interface{}, string) {
+	value, str, _ := p.ConvertStrict(s)
+	return value, str
+}
+
+func (p *multiPicker) ConvertStrict(s string) (interface{}, string, error) {
+	var values []interface{}
+	var names []string
+	var err error
+	selected := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if len(values) == p.maxCount {
+			err = fmt.Errorf("pick at most %d", p.maxCount)
+			break
+		}
+		val, atoiErr := strconv.Atoi(part)
+		if atoiErr != nil || val < 1 || val > len(p.Choices) {
+			err = errBadChoice
+			continue
+		}
+		if selected[val] {
+			continue
+		}
+		selected[val] = true
+		values = append(values, p.Choices[val-1].Value)
+		names = append(names, p.Choices[val-1].Name)
+	}
+	if len(values) == 0 {
+		return p.DefaultValue, p.DefaultName, err
+	}
+	if len(values) < p.minCount {
+		return p.DefaultValue, p.DefaultName, fmt.Errorf(
+			"pick at least %d", p.minCount)
+	}
+	return values, strings.Join(names, ", "), err
+}
+
+type groupParam struct {
+	noSelect
+	maxRows   int
+	params    NamedParamList
+	translate Translator
+}
+
+func (p *groupParam) Localize(translate Translator) Param {
+	return &groupParam{
+		maxRows:   p.maxRows,
+		params:    localizeParams(p.params, translate),
+		translate: translate,
+	}
+}
+
+func (p *groupParam) MaxCharCount() int {
+	return 0
+}
+
+func (p *groupParam) RowParams() NamedParamList {
+	return p.params
+}
+
+func (p *groupParam) MaxRows() int {
+	return p.maxRows
+}
+
+func (p *groupParam) Schema() *ParamSchema {
+	return &ParamSchema{
+		Type:    GroupType,
+		MaxRows: p.maxRows,
+		Params:  paramSchemas(p.params),
+	}
+}
+
+func (p *groupParam) Convert(s string) (interface{}, string) {
+	value, str, _ := p.ConvertStrict(s)
+	return value, str
+}
+
+func (p *groupParam) ConvertStrict(s string) (interface{}, string, error) {
+	var rows [][]string
+	if strings.TrimSpace(s) != "" {
+		for _, row := range strings.Split(s, ";") {
+			rows = append(rows, strings.Split(row, ","))
+		}
+	}
+	return p.ConvertRowsStrict(rows)
+}
+
+func (p *groupParam) ConvertRows(rows [][]string) (interface{}, string) {
+	value, str, _ := p.ConvertRowsStrict(rows)
+	return value, str
+}
+
+func (p *groupParam) ConvertRowsStrict(
+	rows [][]string) (interface{}, string, error) {
+	var values [][]interface{}
+	var names []string
+	var errs []string
+	for r, row := range rows {
+		if isBlankRow(row) {
+			continue
+		}
+		if len(values) == p.maxRows {
+			errs = append(errs, fmt.Sprintf("at most %d rows", p.maxRows))
+			break
+		}
+		rowValues := make([]interface{}, len(p.params))
+		rowNames := make([]string, len(p.params))
+		for i := range p.params {
+			var s string
+			if i < len(row) {
+				s = strings.TrimSpace(row[i])
+			}
+			var name string
+			var err error
+			rowValues[i], name, err = ConvertStrict(p.params[i].Param, s)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf(
+					"row %d %s %v", r+1, p.params[i].Name, err))
+			}
+			rowNames[i] = fmt.Sprintf("%s: %s", p.params[i].Name, name)
+		}
+		values = append(values, rowValues)
+		names = append(names, strings.Join(rowNames, " "))
+	}
+	var err error
+	if len(errs) > 0 {
+		err = errors.New(strings.Join(errs, "; "))
+	}
+	if len(values) == 0 {
+		return values, p.translate.text("None"), err
+	}
+	return values, strings.Join(names, "; "), err
+}
+
+func isBlankRow(row []string) bool {
+	for _, s := range row {
+		if strings.TrimSpace(s) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+type composedFactory struct {
+	combine   func(actions ...ops.HueAction) ops.HueAction
+	factories []Factory
+	params    NamedParamList
+}
+
+func newComposedFactory(
+	combine func(actions ...ops.HueAction) ops.HueAction,
+	factories []Factory) Factory {
+	var params NamedParamList
+	for _, factory := range factories {
+		params = append(params, factory.Params()...)
+	}
+	result := &composedFactory{
+		combine:   combine,
+		factories: factories,
+		params:    params,
+	}
+	for _, factory := range factories {
+		if _, ok := factory.(FactoryEncoderDecoder); !ok {
+			return result
+		}
+	}
+	return &composedEncoderFactory{result}
+}
+
+func (f *composedFactory) Params() NamedParamList {
+	return f.params
+}
+
+func (f *composedFactory) New(values []interface{}) ops.HueAction {
+	actions := make([]ops.HueAction, len(f.factories))
+	for i, factory := range f.factories {
+		count := len(factory.Params())
+		actions[i] = factory.New(values[:count])
+		values = values[count:]
+	}
+	return f.newAction(actions)
+}
+
+func (f *composedFactory) newAction(actions []ops.HueAction) ops.HueAction {
+	return &composedAction{
+		HueAction: f.combine(actions...),
+		actions:   actions,
+	}
+}
+
+// composedAction remembers the hue action of each factory so that
+// composedEncoderFactory can encode them.
+type composedAction struct {
+	ops.HueAction
+	actions []ops.HueAction
+}
+
+type composedEncoderFactory struct {
+	*composedFactory
+}
+
+func (f *composedEncoderFactory) Encode(action ops.HueAction) string {
+	actions := action.(*composedAction).actions
+	encoded := make([]string, len(actions))
+	for i := range actions {
+		encoded[i] = f.factories[i].(Encoder).Encode(actions[i])
+	}
+	result, err := json.Marshal(encoded)
+	if err != nil {
+		panic(err)
+	}
+	return string(result)
+}
+
+func (f *composedEncoderFactory) Decode(s string) (ops.HueAction, error) {
+	var encoded []string
+	if err := json.Unmarshal([]byte(s), &encoded); err != nil {
+		return nil, err
+	}
+	if len(encoded) != len(f.factories) {
+		return nil, errBadValue
+	}
+	actions := make([]ops.HueAction, len(encoded))
+	for i := range encoded {
+		var err error
+		actions[i], err = f.factories[i].(Decoder).Decode(encoded[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f.newAction(actions), nil
+}
+
+// defaultParam uses value when the user leaves the wrapped Param blank.
+type defaultParam struct {
+	Param
+	value string
+}
+
+func (p *defaultParam) Convert(s string) (interface{}, string) {
+	if s == "" {
+		s = p.value
+	}
+	return p.Param.Convert(s)
+}
+
+func (p *defaultParam) ConvertStrict(s string) (interface{}, string, error) {
+	if s == "" {
+		s = p.value
+	}
+	return ConvertStrict(p.Param, s)
+}
+
+func (p *defaultParam) MultiSelect() bool {
+	return isMultiSelect(p.Param)
+}
+
+func (p *defaultParam) Schema() *ParamSchema {
+	return Schema("", NamedParam{Param: p.Param})
+}
+
+func (p *defaultParam) Metadata() ParamMetadata {
+	return Metadata(p.Param)
+}
+
+func (p *defaultParam) Localize(translate Translator) Param {
+	return &defaultParam{Param: Localize(p.Param, translate), value: p.value}
+}
+
+// withParams returns a Factory that works like factory but has params as
+// its params.
+func withParams(factory Factory, params NamedParamList) Factory {
+	result := &paramsFactory{Factory: factory, params: params}
+	if ed, ok := factory.(FactoryEncoderDecoder); ok {
+		return &paramsEncoderFactory{paramsFactory: result, ed: ed}
+	}
+	return result
+}
+
+type paramsFactory struct {
+	Factory
+	params NamedParamList
+}
+
+func (f *paramsFactory) Params() NamedParamList {
+	return f.params
+}
+
+type paramsEncoderFactory struct {
+	*paramsFactory
+	ed FactoryEncoderDecoder
+}
+
+func (f *paramsEncoderFactory) Encode(action ops.HueAction) string {
+	return f.ed.Encode(action)
+}
+
+func (f *paramsEncoderFactory) Decode(s string) (ops.HueAction, error) {
+	return f.ed.Decode(s)
+}
+
+type metadataParam struct {
+	Param
+	metadata ParamMetadata
+}
+
+func (p *metadataParam) Metadata() ParamMetadata {
+	return p.metadata
+}
+
+func (p *metadataParam) ConvertStrict(s string) (interface{}, string, error) {
+	return ConvertStrict(p.Param, s)
+}
+
+func (p *metadataParam) MultiSelect() bool {
+	return isMultiSelect(p.Param)
+}
+
+func (p *metadataParam) Schema() *ParamSchema {
+	return Schema("", NamedParam{Param: p.Param})
+}
+
+func (p *metadataParam) Localize(translate Translator) Param {
+	metadata := p.metadata
+	metadata.Unit = translate.text(metadata.Unit)
+	metadata.Placeholder = translate.text(metadata.Placeholder)
+	return WithMetadata(Localize(p.Param, translate), metadata)
+}
+
+type metadataGroupParam struct {
+	*metadataParam
+	group GroupParam
+}
+
+func (p *metadataGroupParam) RowParams() NamedParamList {
+	return p.group.RowParams()
+}
+
+func (p *metadataGroupParam) MaxRows() int {
+	return p.group.MaxRows()
+}
+
+func (p *metadataGroupParam) ConvertRows(rows [][]string) (interface{}, string) {
+	return p.group.ConvertRows(rows)
+}
+
+func (p *metadataGroupParam) ConvertRowsStrict(
+	rows [][]string) (interface{}, string, error) {
+	if validating, ok := p.group.(ValidatingGroupParam); ok {
+		return validating.ConvertRowsStrict(rows)
 	}
-	return p.Choices[val-1].Value, p.Choices[val-1].Name
+	value, str := p.group.ConvertRows(rows)
+	return value, str, nil
 }
 
 type constantFactory struct {
@@ -4,11 +4,14 @@ import (
 	"github.com/keep94/gohue"
 	"github.com/keep94/marvin2/dynamic"
 	"github.com/keep94/marvin2/dynamic/testutils"
+	"github.com/keep94/marvin2/lights"
 	"github.com/keep94/marvin2/ops"
 	"github.com/keep94/maybe"
+	"github.com/keep94/tasks"
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestInt(t *testing.T) {
@@ -33,6 +36,104 @@ func TestInt(t *testing.T) {
 	assertIntParamValue(t, 1, "1", val, str)
 }
 
+func TestFloat(t *testing.T) {
+	param := dynamic.Float(-10.0, 40.0, 21.5, 1, 5)
+	if param.MaxCharCount() != 5 {
+		t.Error("Expected 5 for MaxCharCount")
+	}
+	if param.Selection() != nil {
+		t.Error("Expected nil for Selection")
+	}
+	val, str := param.Convert("18.25")
+	assertFloatParamValue(t, 18.3, "18.3", val, str)
+	val, str = param.Convert(" 40 ")
+	assertFloatParamValue(t, 40.0, "40.0", val, str)
+	val, str = param.Convert("-10")
+	assertFloatParamValue(t, -10.0, "-10.0", val, str)
+	val, str = param.Convert("40.01")
+	assertFloatParamValue(t, 21.5, "21.5", val, str)
+	val, str = param.Convert("NaN")
+	assertFloatParamValue(t, 21.5, "21.5", val, str)
+	val, str = param.Convert("")
+	assertFloatParamValue(t, 21.5, "21.5", val, str)
+}
+
+func TestDuration(t *testing.T) {
+	param := dynamic.Duration(time.Second, 2*time.Hour, 5*time.Minute)
+	if param.MaxCharCount() != 8 {
+		t.Error("Expected 8 for MaxCharCount")
+	}
+	if param.Selection() != nil {
+		t.Error("Expected nil for Selection")
+	}
+	val, str := param.Convert("90s")
+	assertDurationParamValue(t, 90*time.Second, "1m30s", val, str)
+	val, str = param.Convert("1h30m")
+	assertDurationParamValue(t, 90*time.Minute, "1h30m", val, str)
+	val, str = param.Convert(" 2h ")
+	assertDurationParamValue(t, 2*time.Hour, "2h", val, str)
+	val, str = param.Convert("1s")
+	assertDurationParamValue(t, time.Second, "1s", val, str)
+	val, str = param.Convert("2h1s")
+	assertDurationParamValue(t, 5*time.Minute, "5m", val, str)
+	val, str = param.Convert("500ms")
+	assertDurationParamValue(t, 5*time.Minute, "5m", val, str)
+	val, str = param.Convert("90")
+	assertDurationParamValue(t, 5*time.Minute, "5m", val, str)
+}
+
+func TestText(t *testing.T) {
+	param := dynamic.Text(10, "Untitled")
+	if param.MaxCharCount() != 10 {
+		t.Error("Expected 10 for MaxCharCount")
+	}
+	if param.Selection() != nil {
+		t.Error("Expected no selection")
+	}
+	val, str := param.Convert("Movie night")
+	assertTextParamValue(t, "Movie nigh", val, str)
+	val, str = param.Convert("  Dinner ")
+	assertTextParamValue(t, "Dinner", val, str)
+	val, str = param.Convert("Café lamp")
+	assertTextParamValue(t, "Café lamp", val, str)
+	val, str = param.Convert("   ")
+	assertTextParamValue(t, "Untitled", val, str)
+	val, str = param.Convert("")
+	assertTextParamValue(t, "Untitled", val, str)
+}
+
+func TestFreeColor(t *testing.T) {
+	param := dynamic.FreeColor(gohue.White, "White")
+	if param.MaxCharCount() != 13 {
+		t.Error("Expected 13 for MaxCharCount")
+	}
+	if param.Selection() != nil {
+		t.Error("Expected no selection")
+	}
+	val, str := param.Convert("0.675,0.322")
+	assertColorParamValue(t, gohue.Red, "(0.6750, 0.3220)", val, str)
+	val, str = param.Convert(" 0.167, 0.04 ")
+	assertColorParamValue(t, gohue.Blue, "(0.1670, 0.0400)", val, str)
+	val, str = param.Convert("#ff0000")
+	assertColorParamValue(
+		t, gohue.NewColor(0.7006, 0.2993), "#FF0000", val, str)
+	val, str = param.Convert("#FFFFFF")
+	assertColorParamValue(
+		t, gohue.NewColor(0.3227, 0.3290), "#FFFFFF", val, str)
+	val, str = param.Convert("#000000")
+	assertColorParamValue(t, gohue.White, "White", val, str)
+	val, str = param.Convert("#FF00")
+	assertColorParamValue(t, gohue.White, "White", val, str)
+	val, str = param.Convert("#GG0000")
+	assertColorParamValue(t, gohue.White, "White", val, str)
+	val, str = param.Convert("1.5,0.3")
+	assertColorParamValue(t, gohue.White, "White", val, str)
+	val, str = param.Convert("0.3")
+	assertColorParamValue(t, gohue.White, "White", val, str)
+	val, str = param.Convert("")
+	assertColorParamValue(t, gohue.White, "White", val, str)
+}
+
 func TestPicker(t *testing.T) {
 	choiceList := dynamic.ChoiceList{
 		{"Red", 30},
@@ -62,6 +163,850 @@ func TestPicker(t *testing.T) {
 	assertIntParamValue(t, 21, "XXI", val, str)
 }
 
+func TestKeyedPicker(t *testing.T) {
+	choiceList := dynamic.ChoiceList{
+		{"Red", 30},
+		{"Green", 59},
+	}
+	param := dynamic.KeyedPicker(choiceList, []string{"", "g"}, 21, "XXI")
+	val, str := param.Convert("g")
+	assertIntParamValue(t, 59, "Green", val, str)
+	val, str = param.Convert("1")
+	assertIntParamValue(t, 30, "Red", val, str)
+	if _, _, err := dynamic.ConvertStrict(param, "b"); err == nil {
+		t.Error("Expected error for unknown key")
+	}
+	schema := param.(dynamic.SchemaParam).Schema()
+	if !reflect.DeepEqual([]string{"1", "g"}, schema.ChoiceKeys) {
+		t.Errorf("Unexpected keys %v", schema.ChoiceKeys)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	param := dynamic.Group(2, dynamic.NamedParamList{
+		{Name: "Light", Param: dynamic.Int(1, 99, 1, 2)},
+		{Name: "Bri", Param: dynamic.Brightness()},
+	})
+	group := param.(dynamic.GroupParam)
+	if group.MaxRows() != 2 {
+		t.Error("Expected 2 for MaxRows")
+	}
+	if len(group.RowParams()) != 2 {
+		t.Error("Expected 2 row params")
+	}
+	if param.Selection() != nil {
+		t.Error("Expected no selection")
+	}
+	val, str := group.ConvertRows(
+		[][]string{{"", ""}, {"3", "100"}, {" ", ""}, {"", "50"}, {"5", "1"}})
+	expected := [][]interface{}{{3, 100}, {1, 50}}
+	if !reflect.DeepEqual(expected, val) {
+		t.Errorf("Expected %v, got %v", expected, val)
+	}
+	if str != "Light: 3 Bri: 100; Light: 1 Bri: 50" {
+		t.Errorf("Got %s", str)
+	}
+	val, str = param.Convert("7,20;8")
+	expected = [][]interface{}{{7, 20}, {8, 255}}
+	if !reflect.DeepEqual(expected, val) {
+		t.Errorf("Expected %v, got %v", expected, val)
+	}
+	if str != "Light: 7 Bri: 20; Light: 8 Bri: 255" {
+		t.Errorf("Got %s", str)
+	}
+	val, str = param.Convert("")
+	if len(val.([][]interface{})) != 0 || str != "None" {
+		t.Errorf("Expected no rows, got %v %s", val, str)
+	}
+}
+
+func TestCustomSceneFactory(t *testing.T) {
+	aTask := &dynamic.HueTask{
+		Id:          109,
+		Description: "Scene",
+		Factory:     dynamic.CustomSceneFactory{MaxLights: 3},
+	}
+	urlValues := make(url.Values)
+	urlValues.Set("p0.0.0", "2")
+	// Color red is first in chooser
+	urlValues.Set("p0.0.1", "1")
+	urlValues.Set("p0.0.2", "98")
+	urlValues.Set("p0.2.0", "5")
+	// Row past MaxLights ignored
+	urlValues.Set("p0.3.0", "6")
+	expected := &ops.HueTask{
+		Id: 109,
+		Description: "Scene Lights: Light: 2 Color: Red Bri: 98; " +
+			"Light: 5 Color: White Bri: 255",
+		HueAction: ops.StaticHueAction{
+			2: {
+				Color:      gohue.NewMaybeColor(gohue.Red),
+				Brightness: maybe.NewUint8(98),
+			},
+			5: {
+				Color:      gohue.NewMaybeColor(gohue.White),
+				Brightness: maybe.NewUint8(255),
+			},
+		},
+	}
+	actual := aTask.FromUrlValues("p", urlValues)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	testutils.VerifySerialization(t, aTask.Factory, actual.HueAction)
+
+	actual = aTask.FromExplicit(
+		aTask.Factory.(dynamic.CustomSceneFactory).NewExplicit(
+			ops.LightColors{
+				5: {
+					Color:      gohue.NewMaybeColor(gohue.Blue),
+					Brightness: maybe.NewUint8(10),
+				},
+				1: {
+					Color:      gohue.NewMaybeColor(gohue.Red),
+					Brightness: maybe.NewUint8(20),
+				},
+			}))
+	expectedDesc := "Scene Lights: Light: 1 Color: (0.6750, 0.3220) Bri: 20; " +
+		"Light: 5 Color: (0.1670, 0.0400) Bri: 10"
+	if actual.Description != expectedDesc {
+		t.Errorf("Expected %s, got %s", expectedDesc, actual.Description)
+	}
+	testutils.VerifySerialization(t, aTask.Factory, actual.HueAction)
+}
+
+func TestCustomSceneFactoryDefault(t *testing.T) {
+	aTask := &dynamic.HueTask{
+		Id:          109,
+		Description: "Scene",
+		Factory:     dynamic.CustomSceneFactory{MaxLights: 3},
+	}
+	urlValues := make(url.Values)
+	// The default row comes first but must not override light 2.
+	urlValues.Set("p0.0.0", "0")
+	urlValues.Set("p0.0.2", "50")
+	urlValues.Set("p0.1.0", "2")
+	// Color red is first in chooser
+	urlValues.Set("p0.1.1", "1")
+	urlValues.Set("p0.1.2", "98")
+	// A row without a light id must not become another default.
+	urlValues.Set("p0.2.1", "1")
+	urlValues.Set("p0.2.2", "10")
+	actual, err := aTask.FromUrlValuesStrict("p", urlValues)
+	if err == nil {
+		t.Error("Expected error for row without light id")
+	}
+	ctxt := make(recordingContext)
+	err = tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		actual.HueAction.Do(
+			ctxt, actual.HueAction.UsedLights(lights.New(2, 3)), e)
+	}))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := recordingContext{
+		2: {
+			C:   gohue.NewMaybeColor(gohue.Red),
+			Bri: maybe.NewUint8(98),
+			On:  maybe.NewBool(true),
+		},
+		3: {
+			C:   gohue.NewMaybeColor(gohue.White),
+			Bri: maybe.NewUint8(50),
+			On:  maybe.NewBool(true),
+		},
+	}
+	if !reflect.DeepEqual(expected, ctxt) {
+		t.Errorf("Expected %v, got %v", expected, ctxt)
+	}
+	testutils.VerifySerialization(t, aTask.Factory, actual.HueAction)
+	ctxt = make(recordingContext)
+	err = tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		actual.HueAction.Do(ctxt, lights.All, e)
+	}))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected = recordingContext{
+		0: {
+			C:   gohue.NewMaybeColor(gohue.White),
+			Bri: maybe.NewUint8(50),
+			On:  maybe.NewBool(true),
+		},
+		2: {
+			C:   gohue.NewMaybeColor(gohue.Red),
+			Bri: maybe.NewUint8(98),
+			On:  maybe.NewBool(true),
+		},
+	}
+	if !reflect.DeepEqual(expected, ctxt) {
+		t.Errorf("Expected %v, got %v", expected, ctxt)
+	}
+}
+
+func TestBoundedMultiPicker(t *testing.T) {
+	choiceList := dynamic.ChoiceList{
+		{"Red", 30},
+		{"Green", 59},
+		{"Blue", 11},
+	}
+	param := dynamic.BoundedMultiPicker(
+		choiceList, 2, 2, []interface{}{21}, "XXI")
+	val, str := param.Convert("3,1,2")
+	assertMultiParamValue(t, []interface{}{11, 30}, "Blue, Red", val, str)
+	val, str = param.Convert("2,2")
+	assertMultiParamValue(t, []interface{}{21}, "XXI", val, str)
+}
+
+func TestCycleFactory(t *testing.T) {
+	aTask := &dynamic.HueTask{
+		Id:          110,
+		Description: "Cycle",
+		Factory:     dynamic.CycleFactory{},
+	}
+	urlValues := make(url.Values)
+	// Blue, Red, and Yellow
+	urlValues.Add("p0", "3")
+	urlValues.Add("p0", "1")
+	urlValues.Add("p0", "4")
+	urlValues.Set("p1", "90s")
+	urlValues.Set("p2", "120")
+	expected := &ops.HueTask{
+		Id:          110,
+		Description: "Cycle Colors: Blue, Red, Yellow Step: 1m30s Bri: 120",
+		HueAction: ops.CycleAction{
+			Colors:     []gohue.Color{gohue.Blue, gohue.Red, gohue.Yellow},
+			Step:       90 * time.Second,
+			Brightness: 120,
+		},
+	}
+	actual := aTask.FromUrlValues("p", urlValues)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	testutils.VerifySerialization(t, aTask.Factory, actual.HueAction)
+
+	// Only one color picked so defaults used
+	urlValues = make(url.Values)
+	urlValues.Set("p0", "2")
+	expected = &ops.HueTask{
+		Id:          110,
+		Description: "Cycle Colors: Red, Green, Blue Step: 10s Bri: 255",
+		HueAction: ops.CycleAction{
+			Colors:     []gohue.Color{gohue.Red, gohue.Green, gohue.Blue},
+			Step:       10 * time.Second,
+			Brightness: 255,
+		},
+	}
+	actual = aTask.FromUrlValues("p", urlValues)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestCycleFactoryNewExplicit(t *testing.T) {
+	aTask := &dynamic.HueTask{
+		Id:          111,
+		Description: "Cycle",
+		Factory:     dynamic.CycleFactory{},
+	}
+	expected := &ops.HueTask{
+		Id:          111,
+		Description: "Cycle Colors: Pink, Cyan Step: 2m Bri: 40",
+		HueAction: ops.CycleAction{
+			Colors:     []gohue.Color{gohue.Pink, gohue.Cyan},
+			Step:       2 * time.Minute,
+			Brightness: 40,
+		},
+	}
+	actual := aTask.FromExplicit(
+		aTask.Factory.(dynamic.CycleFactory).NewExplicit(
+			[]gohue.Color{gohue.Pink, gohue.Cyan},
+			"Pink, Cyan",
+			2*time.Minute,
+			40))
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	testutils.VerifySerialization(t, aTask.Factory, actual.HueAction)
+}
+
+func TestRandomColorFactory(t *testing.T) {
+	aTask := &dynamic.HueTask{
+		Id:          113,
+		Description: "Party",
+		Factory:     dynamic.RandomColorFactory{},
+	}
+	urlValues := make(url.Values)
+	urlValues.Set("p0", "2m")
+	urlValues.Set("p1", "77")
+	urlValues.Set("p2", "31415")
+	expected := &ops.HueTask{
+		Id:          113,
+		Description: "Party Interval: 2m Bri: 77 Seed: 31415",
+		HueAction: ops.RandomColorAction{
+			Interval:   2 * time.Minute,
+			Brightness: 77,
+			Seed:       31415,
+		},
+	}
+	actual := aTask.FromUrlValues("p", urlValues)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	testutils.VerifySerialization(t, aTask.Factory, actual.HueAction)
+
+	// Test defaults
+	expected = &ops.HueTask{
+		Id:          113,
+		Description: "Party Interval: 5s Bri: 255 Seed: 1",
+		HueAction: ops.RandomColorAction{
+			Interval:   5 * time.Second,
+			Brightness: 255,
+			Seed:       1,
+		},
+	}
+	actual = aTask.FromUrlValues("p", make(url.Values))
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+
+	actual = aTask.FromExplicit(
+		aTask.Factory.(dynamic.RandomColorFactory).NewExplicit(
+			time.Minute, 12, 99))
+	expected = &ops.HueTask{
+		Id:          113,
+		Description: "Party Interval: 1m Bri: 12 Seed: 99",
+		HueAction: ops.RandomColorAction{
+			Interval:   time.Minute,
+			Brightness: 12,
+			Seed:       99,
+		},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	testutils.VerifySerialization(t, aTask.Factory, actual.HueAction)
+}
+
+func TestConvertStrict(t *testing.T) {
+	assertStrict(t, dynamic.Int(0, 255, 255, 3), "", 255, false)
+	assertStrict(t, dynamic.Int(0, 255, 255, 3), "25", 25, false)
+	assertStrict(t, dynamic.Int(0, 255, 255, 3), "256", 255, true)
+	assertStrict(t, dynamic.Int(0, 255, 255, 3), "2x", 255, true)
+	assertStrict(t, dynamic.Float(0.5, 2.0, 1.0, 1, 4), "1.55", 1.6, false)
+	assertStrict(t, dynamic.Float(0.5, 2.0, 1.0, 1, 4), "3", 1.0, true)
+	assertStrict(
+		t,
+		dynamic.Duration(time.Second, time.Hour, time.Minute),
+		"2h",
+		time.Minute,
+		true)
+	assertStrict(t, dynamic.Text(3, "x"), "abc", "abc", false)
+	assertStrict(t, dynamic.Text(3, "x"), "abcd", "abc", true)
+	assertStrict(
+		t, dynamic.FreeColor(gohue.White, "White"), "#12", gohue.White, true)
+	assertStrict(
+		t, dynamic.FreeColor(gohue.White, "White"), "", gohue.White, false)
+	choices := dynamic.ChoiceList{{"Red", 30}, {"Green", 59}}
+	assertStrict(t, dynamic.Picker(choices, 21, "XXI"), "2", 59, false)
+	assertStrict(t, dynamic.Picker(choices, 21, "XXI"), "0", 21, false)
+	assertStrict(t, dynamic.Picker(choices, 21, "XXI"), "3", 21, true)
+	multi := dynamic.BoundedMultiPicker(choices, 2, 2, 21, "XXI")
+	assertStrict(t, multi, "2,1", []interface{}{59, 30}, false)
+	assertStrict(t, multi, "2", 21, true)
+	assertStrict(t, multi, "2,1,3", []interface{}{59, 30}, true)
+	group := dynamic.Group(1, dynamic.NamedParamList{
+		{Name: "Bri", Param: dynamic.Brightness()}})
+	assertStrict(t, group, "7", [][]interface{}{{7}}, false)
+	assertStrict(t, group, "300", [][]interface{}{{255}}, true)
+	assertStrict(t, group, "7;8", [][]interface{}{{7}}, true)
+}
+
+func TestFromUrlValuesStrict(t *testing.T) {
+	aTask := &dynamic.HueTask{
+		Id:          105,
+		Description: "Foo",
+		Factory:     dynamic.PlainFactory{},
+	}
+	urlValues := make(url.Values)
+	urlValues.Set("p0", "1")
+	urlValues.Set("p1", "98")
+	actual, err := aTask.FromUrlValuesStrict("p", urlValues)
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if actual.Description != "Foo Color: Red Bri: 98" {
+		t.Errorf("Got %s", actual.Description)
+	}
+	urlValues.Set("p0", "11")
+	urlValues.Set("p1", "300")
+	actual, err = aTask.FromUrlValuesStrict("p", urlValues)
+	if actual.Description != "Foo Color: White Bri: 255" {
+		t.Errorf("Got %s", actual.Description)
+	}
+	fieldErrors, ok := err.(dynamic.FieldErrors)
+	if !ok || len(fieldErrors) != 2 {
+		t.Fatalf("Expected 2 field errors, got %v", err)
+	}
+	briError := fieldErrors.ByIndex(1)
+	if briError == nil || briError.Name != "Bri" || briError.Value != "300" {
+		t.Errorf("Unexpected field error %v", briError)
+	}
+	expectedMessage := "Color not one of the choices; " +
+		"Bri must be a whole number from 0 to 255"
+	if err.Error() != expectedMessage {
+		t.Errorf("Expected %s, got %s", expectedMessage, err.Error())
+	}
+	if fieldErrors.ByIndex(2) != nil {
+		t.Error("Expected no error for index 2")
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	assertVersion(t, "v3:{}", 3, "{}")
+	assertVersion(t, "v12:a:b", 12, "a:b")
+	assertVersion(t, "{\"Bri\":[\"3\"]}", 0, "{\"Bri\":[\"3\"]}")
+	assertVersion(t, "v0:x", 0, "v0:x")
+	assertVersion(t, "vx:x", 0, "vx:x")
+	assertVersion(t, "v:x", 0, "v:x")
+	assertVersion(t, "", 0, "")
+	if out := dynamic.FormatVersion(2, "abc"); out != "v2:abc" {
+		t.Errorf("Expected v2:abc, got %s", out)
+	}
+}
+
+func TestVersionedFactory(t *testing.T) {
+	// Version 0 encoded only brightness with a fixed color of red.
+	version0 := dynamic.DecoderFunc(func(s string) (ops.HueAction, error) {
+		serializer, err := dynamic.NewParamSerializer(s)
+		if err != nil {
+			return nil, err
+		}
+		brightness, err := serializer.GetBrightness("Bri")
+		if err != nil {
+			return nil, err
+		}
+		action, _ := dynamic.PlainFactory{}.NewExplicit(
+			gohue.Red, "Red", brightness)
+		return action, nil
+	})
+	factory := &dynamic.VersionedFactory{
+		Factory: dynamic.PlainFactory{},
+		Version: 1,
+		Encoder: dynamic.PlainFactory{},
+		Decoders: map[int]dynamic.Decoder{
+			0: version0,
+			1: dynamic.PlainFactory{},
+		},
+	}
+	action, _ := dynamic.PlainFactory{}.NewExplicit(gohue.Blue, "Blue", 40)
+	encoded := factory.Encode(action)
+	if version, _ := dynamic.ParseVersion(encoded); version != 1 {
+		t.Errorf("Expected version 1, got %d", version)
+	}
+	testutils.VerifySerialization(t, factory, action)
+
+	old := dynamic.PlainColorFactory{gohue.Red}.Encode(action)
+	decoded, err := factory.Decode(old)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected, _ := dynamic.PlainFactory{}.NewExplicit(gohue.Red, "Red", 40)
+	if !reflect.DeepEqual(expected, decoded) {
+		t.Errorf("Expected %v, got %v", expected, decoded)
+	}
+	migrated, err := factory.Migrate(old)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if migrated != factory.Encode(expected) {
+		t.Errorf("Expected migrated %s, got %s",
+			factory.Encode(expected), migrated)
+	}
+	if out, _ := factory.Migrate(encoded); out != encoded {
+		t.Errorf("Expected %s, got %s", encoded, out)
+	}
+	if _, err := factory.Decode("v2:{}"); err != dynamic.ErrUnknownVersion {
+		t.Errorf("Expected ErrUnknownVersion, got %v", err)
+	}
+}
+
+func TestSchemaJSON(t *testing.T) {
+	aTask := &dynamic.HueTask{
+		Id:          105,
+		Description: "Foo",
+		Factory:     dynamic.PlainFactory{},
+	}
+	actual, err := aTask.SchemaJSON()
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := `{"id":105,"description":"Foo","params":[` +
+		`{"key":"0","name":"Color","type":"choice","defaultName":"White",` +
+		`"choices":["Red","Green","Blue","Yellow","Magenta","Cyan",` +
+		`"Purple","White","Pink","Orange"]},` +
+		`{"key":"1","name":"Bri","type":"int","min":0,"max":255,` +
+		`"default":255,"maxChars":3,"step":1,"widget":"slider"}]}`
+	if string(actual) != expected {
+		t.Errorf("Expected %s, got %s", expected, actual)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	aTask := &dynamic.HueTask{
+		Id:          109,
+		Description: "Scene",
+		Factory:     dynamic.CustomSceneFactory{MaxLights: 3},
+	}
+	schema := aTask.Schema()
+	if len(schema.Params) != 1 {
+		t.Fatalf("Expected 1 param, got %d", len(schema.Params))
+	}
+	group := schema.Params[0]
+	if group.Type != dynamic.GroupType || group.MaxRows != 3 || group.Name != "Lights" {
+		t.Errorf("Unexpected group schema %+v", group)
+	}
+	if len(group.Params) != 3 || group.Params[2].Key != "2" || group.Params[2].Type != dynamic.IntType {
+		t.Errorf("Unexpected row schema %+v", group.Params)
+	}
+	expected := &dynamic.ParamSchema{
+		Key:         "0",
+		Name:        "Step",
+		Type:        dynamic.DurationType,
+		Min:         "1s",
+		Max:         "1h",
+		Default:     "10s",
+		MaxChars:    8,
+		Placeholder: "e.g 1h30m",
+	}
+	actual := dynamic.Schema("0", dynamic.NamedParam{
+		Name:  "Step",
+		Param: dynamic.Duration(time.Second, time.Hour, 10*time.Second),
+	})
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %+v, got %+v", expected, actual)
+	}
+	actual = dynamic.Schema("2", dynamic.NamedParam{
+		Name: "Custom", Param: dynamic.FreeColor(gohue.White, "White")})
+	if actual.Type != dynamic.ColorType || actual.Widget != dynamic.ColorWidget || actual.Placeholder != "#FF8000" {
+		t.Errorf("Unexpected color schema %+v", actual)
+	}
+	// Params that don't implement SchemaParam
+	expected = &dynamic.ParamSchema{
+		Key:      "1",
+		Name:     "Plain",
+		Type:     dynamic.TextType,
+		MaxChars: 4,
+	}
+	actual = dynamic.Schema("1", dynamic.NamedParam{
+		Name: "Plain", Param: plainParam{}})
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %+v, got %+v", expected, actual)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	plain := &dynamic.HueTask{
+		Id: 7, Description: "Plain", Factory: dynamic.PlainFactory{}}
+	cycle := &dynamic.HueTask{
+		Id: 3, Description: "Cycle", Factory: dynamic.CycleFactory{}}
+	registry, err := dynamic.NewRegistry(plain, cycle)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if registry.ById(7) != plain || registry.ById(3) != cycle {
+		t.Error("Expected to find registered tasks")
+	}
+	if registry.ById(4) != nil {
+		t.Error("Expected nil for unregistered id")
+	}
+	other := &dynamic.HueTask{Id: 7, Description: "Other"}
+	if err := registry.Register(7, other); err != dynamic.ErrDuplicateId {
+		t.Errorf("Expected ErrDuplicateId, got %v", err)
+	}
+	if registry.ById(7) != plain {
+		t.Error("Expected duplicate registration to leave task unchanged")
+	}
+	registry.MustRegister(5, other)
+	expected := dynamic.HueTaskList{cycle, other, plain}
+	if actual := registry.All(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	if _, err := dynamic.NewRegistry(plain, plain); err != dynamic.ErrDuplicateId {
+		t.Errorf("Expected ErrDuplicateId, got %v", err)
+	}
+	var empty dynamic.Registry
+	if len(empty.All()) != 0 || empty.ById(7) != nil {
+		t.Error("Expected zero Registry to be empty")
+	}
+}
+
+func TestCompose(t *testing.T) {
+	factory := dynamic.Compose(
+		dynamic.PlainFactory{}, dynamic.PlainColorFactory{gohue.Blue})
+	aTask := &dynamic.HueTask{
+		Id:          114,
+		Description: "Both",
+		Factory:     factory,
+	}
+	urlValues := make(url.Values)
+	// Color red is first in chooser
+	urlValues.Set("p0", "1")
+	urlValues.Set("p1", "98")
+	urlValues.Set("p2", "20")
+	actual := aTask.FromUrlValues("p", urlValues)
+	if actual.Description != "Both Color: Red Bri: 98 Bri: 20" {
+		t.Errorf("Got %s", actual.Description)
+	}
+	ctxt := make(recordingContext)
+	err := tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		actual.HueAction.Do(ctxt, lights.New(3), e)
+	}))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	// Sequence means the second factory's action runs last.
+	expected := recordingContext{
+		3: {
+			C:   gohue.NewMaybeColor(gohue.Blue),
+			Bri: maybe.NewUint8(20),
+			On:  maybe.NewBool(true),
+		},
+	}
+	if !reflect.DeepEqual(expected, ctxt) {
+		t.Errorf("Expected %v, got %v", expected, ctxt)
+	}
+	if out := actual.HueAction.UsedLights(lights.New(3)); !reflect.DeepEqual(
+		lights.New(3), out) {
+		t.Errorf("Expected {3}, got %v", out)
+	}
+	testutils.VerifySerialization(t, factory, actual.HueAction)
+	if _, err := factory.(dynamic.Decoder).Decode(`["{}"]`); err == nil {
+		t.Error("Expected error decoding wrong number of actions")
+	}
+}
+
+func TestComposeNoEncoder(t *testing.T) {
+	factory := dynamic.ComposeParallel(
+		dynamic.PlainFactory{}, colorsFactory{})
+	if len(factory.Params()) != 3 {
+		t.Errorf("Expected 3 params, got %d", len(factory.Params()))
+	}
+	if _, ok := factory.(dynamic.Encoder); ok {
+		t.Error("Expected no Encoder when a factory can't encode")
+	}
+	action := factory.New([]interface{}{
+		gohue.Red, 5, []interface{}{7}})
+	if out := action.UsedLights(lights.New(2)); !reflect.DeepEqual(
+		lights.New(2), out) {
+		t.Errorf("Expected {2}, got %v", out)
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	aTask := &dynamic.HueTask{
+		Id:          105,
+		Description: "Foo",
+		Factory:     dynamic.PlainFactory{},
+	}
+	lastValues := make(url.Values)
+	// Blue is third in chooser
+	lastValues.Set("1", "40")
+	lastValues.Set("0", "3")
+	withDefaults := aTask.WithDefaults(lastValues)
+	urlValues := make(url.Values)
+	urlValues.Set("p1", "60")
+	actual := withDefaults.FromUrlValues("p", urlValues)
+	if actual.Description != "Foo Color: Blue Bri: 60" {
+		t.Errorf("Got %s", actual.Description)
+	}
+	actual = withDefaults.FromUrlValues("p", make(url.Values))
+	if actual.Description != "Foo Color: Blue Bri: 40" {
+		t.Errorf("Got %s", actual.Description)
+	}
+	testutils.VerifySerialization(t, withDefaults.Factory, actual.HueAction)
+
+	// Original unchanged
+	actual = aTask.FromUrlValues("p", make(url.Values))
+	if actual.Description != "Foo Color: White Bri: 255" {
+		t.Errorf("Got %s", actual.Description)
+	}
+
+	cycleTask := &dynamic.HueTask{
+		Id:          110,
+		Description: "Cycle",
+		Factory:     dynamic.CycleFactory{},
+	}
+	lastValues = make(url.Values)
+	lastValues.Add("0", "3")
+	lastValues.Add("0", "1")
+	withDefaults = cycleTask.WithDefaults(lastValues)
+	if !withDefaults.Params()[0].Param.(dynamic.MultiSelectParam).MultiSelect() {
+		t.Error("Expected multi select to be preserved")
+	}
+	actual = withDefaults.FromUrlValues("p", make(url.Values))
+	if actual.Description != "Cycle Colors: Blue, Red Step: 10s Bri: 255" {
+		t.Errorf("Got %s", actual.Description)
+	}
+	if _, err := withDefaults.FromUrlValuesStrict("p", make(url.Values)); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	german := map[string]string{
+		"Fixed":            "Fest",
+		"Color":            "Farbe",
+		"Bri":              "Hell",
+		"Red":              "Rot",
+		"White":            "Weiß",
+		"--Pick one--":     "--Wählen--",
+		"Colors":           "Farben",
+		"Green":            "Grün",
+		"Blue":             "Blau",
+		"Lights":           "Lampen",
+		"Light":            "Lampe",
+		"None":             "Keine",
+		"Red, Green, Blue": "Rot, Grün, Blau",
+	}
+	translate := func(key string) string {
+		if result, ok := german[key]; ok {
+			return result
+		}
+		return key
+	}
+	aTask := (&dynamic.HueTask{
+		Id:          105,
+		Description: "Fixed",
+		Factory:     dynamic.PlainFactory{},
+	}).Localize(translate)
+	params := aTask.Params()
+	if params[0].Name != "Farbe" || params[1].Name != "Hell" {
+		t.Errorf("Expected translated names, got %v", params)
+	}
+	selection := params[0].Selection()
+	if selection[0] != "--Wählen--" || selection[1] != "Rot" || selection[5] != "Magenta" {
+		t.Errorf("Expected translated choices, got %v", selection)
+	}
+	urlValues := make(url.Values)
+	urlValues.Set("p0", "1")
+	urlValues.Set("p1", "98")
+	actual := aTask.FromUrlValues("p", urlValues)
+	if actual.Description != "Fest Farbe: Rot Hell: 98" {
+		t.Errorf("Got %s", actual.Description)
+	}
+	actual = aTask.FromUrlValues("p", make(url.Values))
+	if actual.Description != "Fest Farbe: Weiß Hell: 255" {
+		t.Errorf("Got %s", actual.Description)
+	}
+	testutils.VerifySerialization(t, aTask.Factory, actual.HueAction)
+
+	cycleTask := (&dynamic.HueTask{
+		Id:          110,
+		Description: "Cycle",
+		Factory:     dynamic.CycleFactory{},
+	}).Localize(translate)
+	urlValues = make(url.Values)
+	urlValues.Add("p0", "2")
+	urlValues.Add("p0", "3")
+	actual = cycleTask.FromUrlValues("p", urlValues)
+	if actual.Description != "Cycle Farben: Grün, Blau Step: 10s Hell: 255" {
+		t.Errorf("Got %s", actual.Description)
+	}
+	actual = cycleTask.FromUrlValues("p", make(url.Values))
+	if actual.Description != "Cycle Farben: Rot, Grün, Blau Step: 10s Hell: 255" {
+		t.Errorf("Got %s", actual.Description)
+	}
+
+	sceneTask := (&dynamic.HueTask{
+		Id:          109,
+		Description: "Scene",
+		Factory:     dynamic.CustomSceneFactory{},
+	}).Localize(translate)
+	actual = sceneTask.FromUrlValues("p", make(url.Values))
+	if actual.Description != "Scene Lampen: Keine" {
+		t.Errorf("Got %s", actual.Description)
+	}
+	urlValues = make(url.Values)
+	urlValues.Set("p0.0.0", "2")
+	actual = sceneTask.FromUrlValues("p", urlValues)
+	if actual.Description != "Scene Lampen: Lampe: 2 Farbe: Weiß Hell: 255" {
+		t.Errorf("Got %s", actual.Description)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	step := dynamic.WithMetadata(
+		dynamic.Duration(time.Second, time.Hour, time.Minute),
+		dynamic.ParamMetadata{Unit: "duration", Placeholder: "e.g 1h30m"})
+	bri := dynamic.WithMetadata(
+		dynamic.Brightness(),
+		dynamic.ParamMetadata{
+			Unit: "%", Step: 5, Widget: dynamic.SliderWidget})
+	aTask := &dynamic.HueTask{
+		Id:          115,
+		Description: "Meta",
+		Factory: fakeParamsFactory{
+			{Name: "Step", Param: step},
+			{Name: "Bri", Param: bri},
+			{Name: "Plain", Param: plainParam{}},
+		},
+	}
+	params := aTask.Params()
+	expected := dynamic.ParamMetadata{
+		Unit: "%", Step: 5, Widget: dynamic.SliderWidget}
+	if out := params[1].Metadata(); out != expected {
+		t.Errorf("Expected %v, got %v", expected, out)
+	}
+	if out := params[2].Metadata(); out != (dynamic.ParamMetadata{}) {
+		t.Errorf("Expected no metadata, got %v", out)
+	}
+	val, str, err := dynamic.ConvertStrict(bri, "300")
+	if val != 255 || str != "255" || err == nil {
+		t.Errorf("Expected strict conversion, got %v %s %v", val, str, err)
+	}
+	schema := aTask.Schema()
+	if schema.Params[0].Placeholder != "e.g 1h30m" || schema.Params[0].Type != dynamic.DurationType {
+		t.Errorf("Unexpected schema %+v", schema.Params[0])
+	}
+	if schema.Params[1].Widget != dynamic.SliderWidget || schema.Params[1].Step != 5 || schema.Params[1].Max != 255 {
+		t.Errorf("Unexpected schema %+v", schema.Params[1])
+	}
+
+	// Metadata survives WithDefaults and Localize
+	withDefaults := aTask.WithDefaults(url.Values{"1": {"30"}})
+	if out := withDefaults.Params()[1].Metadata(); out != expected {
+		t.Errorf("Expected %v, got %v", expected, out)
+	}
+	localized := aTask.Localize(func(key string) string {
+		if key == "e.g 1h30m" {
+			return "z.B. 1h30m"
+		}
+		return key
+	})
+	if out := localized.Params()[0].Metadata().Placeholder; out != "z.B. 1h30m" {
+		t.Errorf("Expected translated placeholder, got %s", out)
+	}
+
+	// Groups stay groups
+	group := dynamic.WithMetadata(
+		dynamic.Group(2, dynamic.NamedParamList{
+			{Name: "Bri", Param: dynamic.Brightness()}}),
+		dynamic.ParamMetadata{Placeholder: "rows"})
+	groupTask := &dynamic.HueTask{
+		Id:          116,
+		Description: "Group",
+		Factory:     fakeParamsFactory{{Name: "Rows", Param: group}},
+	}
+	urlValues := make(url.Values)
+	urlValues.Set("p0.1.0", "7")
+	actual := groupTask.FromUrlValues("p", urlValues)
+	if actual.Description != "Group Rows: Bri: 7" {
+		t.Errorf("Got %s", actual.Description)
+	}
+}
+
 func TestConstant(t *testing.T) {
 	anAction := ops.StaticHueAction{
 		0: {
@@ -94,6 +1039,71 @@ func TestConstant(t *testing.T) {
 	}
 }
 
+func TestMultiPicker(t *testing.T) {
+	choiceList := dynamic.ChoiceList{
+		{"Red", 30},
+		{"Green", 59},
+		{"Blue", 11},
+	}
+	param := dynamic.MultiPicker(choiceList, []interface{}{21}, "XXI")
+	if param.MaxCharCount() != 0 {
+		t.Error("Expected 0 for MaxCharCount")
+	}
+	if !param.(dynamic.MultiSelectParam).MultiSelect() {
+		t.Error("Expected MultiSelect to be true")
+	}
+	expectedSelection := []string{
+		"--Pick one or more--", "Red", "Green", "Blue"}
+	actualSelection := param.Selection()
+	if !reflect.DeepEqual(expectedSelection, actualSelection) {
+		t.Errorf("Expected %v, got %v", expectedSelection, actualSelection)
+	}
+	val, str := param.Convert("3,1")
+	assertMultiParamValue(t, []interface{}{11, 30}, "Blue, Red", val, str)
+	val, str = param.Convert("2")
+	assertMultiParamValue(t, []interface{}{59}, "Green", val, str)
+	val, str = param.Convert("1, 4, x, 1,2")
+	assertMultiParamValue(t, []interface{}{30, 59}, "Red, Green", val, str)
+	val, str = param.Convert("0,4")
+	assertMultiParamValue(t, []interface{}{21}, "XXI", val, str)
+	val, str = param.Convert("")
+	assertMultiParamValue(t, []interface{}{21}, "XXI", val, str)
+	if _, ok := dynamic.Picker(choiceList, 21, "XXI").(dynamic.MultiSelectParam); ok {
+		t.Error("Expected Picker not to be a MultiSelectParam")
+	}
+}
+
+func TestFromUrlValuesMultiSelect(t *testing.T) {
+	aTask := &dynamic.HueTask{
+		Id:          106,
+		Description: "Cycle",
+		Factory:     colorsFactory{},
+	}
+	urlValues := make(url.Values)
+	urlValues.Add("p0", "3")
+	urlValues.Add("p0", "1")
+	actual := aTask.FromUrlValues("p", urlValues)
+	expected := &ops.HueTask{
+		Id:          106,
+		Description: "Cycle Colors: Blue, Red",
+		HueAction:   colorsAction{11, 30},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	urlValues = make(url.Values)
+	urlValues.Set("p0", "2,3")
+	actual = aTask.FromUrlValues("p", urlValues)
+	expected = &ops.HueTask{
+		Id:          106,
+		Description: "Cycle Colors: Green, Blue",
+		HueAction:   colorsAction{59, 11},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
 func TestFromUrlValues(t *testing.T) {
 	// TODO: find a way to make this test less fragile.
 	// right now it depends on ordering of color chooser and ordering of params.
@@ -207,6 +1217,164 @@ func TestSortByDescriptionIgnoreCase(t *testing.T) {
 	}
 }
 
+func TestHueTaskListFilter(t *testing.T) {
+	hueTasks := dynamic.HueTaskList{
+		{Id: 10, Description: "Go Party", Tags: []string{"Party"}},
+		{Id: 5, Description: "george", Tags: []string{"holiday", "party"}},
+		{Id: 7, Description: "abby"},
+	}
+	expected := dynamic.HueTaskList{hueTasks[0], hueTasks[1]}
+	if actual := hueTasks.ByTag("PARTY"); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	if actual := hueTasks.ByTag("birthday"); len(actual) != 0 {
+		t.Errorf("Expected no hue tasks, got %v", actual)
+	}
+	expected = dynamic.HueTaskList{hueTasks[0], hueTasks[2]}
+	if actual := hueTasks.Search("A"); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	if actual := hueTasks.Search(""); !reflect.DeepEqual(hueTasks, actual) {
+		t.Errorf("Expected %v, got %v", hueTasks, actual)
+	}
+	expected = dynamic.HueTaskList{hueTasks[2]}
+	actual := hueTasks.Filter(func(h *dynamic.HueTask) bool {
+		return h.Id < 8 && len(h.Tags) == 0
+	})
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestHueTaskTags(t *testing.T) {
+	aTask := &dynamic.HueTask{
+		Id:          1,
+		Description: "Plain",
+		Factory:     dynamic.PlainColorFactory{},
+		Tags:        []string{"party"},
+	}
+	h := aTask.FromExplicit(
+		aTask.Factory.(dynamic.PlainColorFactory).NewExplicit(52))
+	if !h.HasTag("Party") {
+		t.Error("Expected generated hue task to have party tag")
+	}
+	if !aTask.WithDefaults(nil).HasTag("party") {
+		t.Error("Expected WithDefaults to keep tags")
+	}
+	opsTask := &ops.HueTask{Id: 2, Description: "Static", Tags: []string{"a"}}
+	if !dynamic.FromOpsHueTask(opsTask).HasTag("A") {
+		t.Error("Expected FromOpsHueTask to keep tags")
+	}
+}
+
+func TestParamSerializerEncodeBinary(t *testing.T) {
+	serializer := make(dynamic.ParamSerializer)
+	serializer.SetInt("Light.0", -3)
+	serializer.SetColor("Color.5", gohue.Red)
+	serializer["Name"] = []string{"Living room", "007", ""}
+	serializer["Empty"] = []string{}
+	encoded := serializer.EncodeBinary()
+	if len(encoded) >= len(serializer.Encode()) {
+		t.Errorf("Expected %s to be shorter than JSON", encoded)
+	}
+	actual, err := dynamic.NewParamSerializer(encoded)
+	if err != nil {
+		t.Fatalf("Got error decoding: %v", err)
+	}
+	if !reflect.DeepEqual(serializer, actual) {
+		t.Errorf("Expected %v, got %v", serializer, actual)
+	}
+	if _, err := dynamic.NewParamSerializer(encoded[:len(encoded)-2]); err == nil {
+		t.Error("Expected error decoding truncated encoding")
+	}
+	if _, err := dynamic.NewParamSerializer(encoded + "AA"); err == nil {
+		t.Error("Expected error decoding encoding with extra bytes")
+	}
+}
+
+func TestBinary(t *testing.T) {
+	factory := dynamic.CustomSceneFactory{MaxLights: 3}
+	action, _ := factory.NewExplicit(
+		ops.LightColors{
+			5: {
+				Color:      gohue.NewMaybeColor(gohue.Blue),
+				Brightness: maybe.NewUint8(10),
+			},
+			1: {
+				Color:      gohue.NewMaybeColor(gohue.Red),
+				Brightness: maybe.NewUint8(20),
+			},
+		})
+	binaryFactory := dynamic.Binary(factory)
+	encoded := binaryFactory.Encode(action)
+	jsonEncoded := factory.Encode(action)
+	if len(encoded) >= len(jsonEncoded) {
+		t.Errorf("Expected %s to be shorter than %s", encoded, jsonEncoded)
+	}
+	testutils.VerifySerialization(t, binaryFactory, action)
+
+	// Decoding auto-detects JSON encodings made before switching to binary
+	decoded, err := binaryFactory.Decode(jsonEncoded)
+	if err != nil || !reflect.DeepEqual(action, decoded) {
+		t.Errorf("Expected %v, got %v, %v", action, decoded, err)
+	}
+	decoded, err = factory.Decode(encoded)
+	if err != nil || !reflect.DeepEqual(action, decoded) {
+		t.Errorf("Expected %v, got %v, %v", action, decoded, err)
+	}
+
+	// Encodings that aren't ParamSerializers pass through unchanged
+	constant := dynamic.Constant(action)
+	if actual := dynamic.Binary(constant).Encode(action); actual != constant.Encode(action) {
+		t.Errorf("Expected %s, got %s", constant.Encode(action), actual)
+	}
+}
+
+func TestParamSerializerMoreTypes(t *testing.T) {
+	p := make(dynamic.ParamSerializer)
+	p.SetFloat("float", -2.375).SetDuration("duration", 1500*time.Millisecond)
+	p.SetString("string", "Hello, world").SetBool("bool", true)
+	p.SetColorList("colors", []gohue.Color{gohue.Red, gohue.Blue})
+	p.SetColorList("none", nil)
+	for _, s := range []string{p.Encode(), p.EncodeBinary()} {
+		q, err := dynamic.NewParamSerializer(s)
+		if err != nil {
+			t.Fatal("Got error deserializing.")
+		}
+		if out, err := q.GetFloat("float"); out != -2.375 || err != nil {
+			t.Errorf("Expected -2.375, got %v", out)
+		}
+		if out, err := q.GetDuration("duration"); out != 1500*time.Millisecond || err != nil {
+			t.Errorf("Expected 1.5s, got %v", out)
+		}
+		if out, err := q.GetString("string"); out != "Hello, world" || err != nil {
+			t.Errorf("Expected 'Hello, world', got %v", out)
+		}
+		if out, err := q.GetBool("bool"); !out || err != nil {
+			t.Errorf("Expected true, got %v", out)
+		}
+		expected := []gohue.Color{gohue.Red, gohue.Blue}
+		if out, err := q.GetColorList("colors"); !reflect.DeepEqual(expected, out) || err != nil {
+			t.Errorf("Expected %v, got %v", expected, out)
+		}
+		if out, err := q.GetColorList("none"); len(out) != 0 || err != nil {
+			t.Errorf("Expected no colors, got %v", out)
+		}
+		if _, err := q.GetBool("string"); err == nil || err == dynamic.ErrNoValue {
+			t.Errorf("Expected to get an undefined error, got %v", err)
+		}
+		if _, err := q.GetColorList("float"); err == nil || err == dynamic.ErrNoValue {
+			t.Errorf("Expected to get an undefined error, got %v", err)
+		}
+		if _, err := q.GetString("colors"); err == nil || err == dynamic.ErrNoValue {
+			t.Errorf("Expected to get an undefined error, got %v", err)
+		}
+		if _, err := q.GetDuration("notthere"); err != dynamic.ErrNoValue {
+			t.Errorf("Expected to get ErrNoValue, got %v", err)
+		}
+	}
+}
+
 func TestParamSerializerBadValue(t *testing.T) {
 	s := `{"bar":["6082","10001"],"baz":["6082", "-1"],"a":["-1","6082"],"b":["6082","10001"],"foo":["a","3"],"c":["3","a"],"d":["l"],"e":["-1"],"f":["256"]}`
 	q, err := dynamic.NewParamSerializer(s)
@@ -303,3 +1471,166 @@ func assertIntParamValue(
 		t.Errorf("Expected %s, got %s", estr, str)
 	}
 }
+
+func assertFloatParamValue(
+	t *testing.T, eval float64, estr string, val interface{}, str string) {
+	if val.(float64) != eval {
+		t.Errorf("Expected %v, got %v", eval, val.(float64))
+	}
+	if estr != str {
+		t.Errorf("Expected %s, got %s", estr, str)
+	}
+}
+
+func assertDurationParamValue(
+	t *testing.T,
+	eval time.Duration,
+	estr string,
+	val interface{},
+	str string) {
+	if val.(time.Duration) != eval {
+		t.Errorf("Expected %v, got %v", eval, val.(time.Duration))
+	}
+	if estr != str {
+		t.Errorf("Expected %s, got %s", estr, str)
+	}
+}
+
+func assertColorParamValue(
+	t *testing.T,
+	expectedValue gohue.Color,
+	expectedStr string,
+	actualValue interface{},
+	actualStr string) {
+	if expectedValue != actualValue.(gohue.Color) {
+		t.Errorf("Expected %v, got %v", expectedValue, actualValue)
+	}
+	if expectedStr != actualStr {
+		t.Errorf("Expected %s, got %s", expectedStr, actualStr)
+	}
+}
+
+func assertTextParamValue(
+	t *testing.T,
+	expected string,
+	actualValue interface{},
+	actualStr string) {
+	if expected != actualValue.(string) {
+		t.Errorf("Expected %s, got %s", expected, actualValue)
+	}
+	if expected != actualStr {
+		t.Errorf("Expected %s, got %s", expected, actualStr)
+	}
+}
+
+func assertMultiParamValue(
+	t *testing.T,
+	expectedValue []interface{},
+	expectedStr string,
+	actualValue interface{},
+	actualStr string) {
+	if !reflect.DeepEqual(expectedValue, actualValue) {
+		t.Errorf("Expected %v, got %v", expectedValue, actualValue)
+	}
+	if expectedStr != actualStr {
+		t.Errorf("Expected %s, got %s", expectedStr, actualStr)
+	}
+}
+
+type colorsAction []interface{}
+
+func (a colorsAction) Do(
+	ctxt ops.Context, lightSet lights.Set, e *tasks.Execution) {
+}
+
+func (a colorsAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
+
+type colorsFactory struct {
+}
+
+func (f colorsFactory) Params() dynamic.NamedParamList {
+	return dynamic.NamedParamList{
+		{
+			Name: "Colors",
+			Param: dynamic.MultiPicker(
+				dynamic.ChoiceList{
+					{"Red", 30},
+					{"Green", 59},
+					{"Blue", 11},
+				},
+				[]interface{}{21},
+				"XXI"),
+		},
+	}
+}
+
+func (f colorsFactory) New(values []interface{}) ops.HueAction {
+	return colorsAction(values[0].([]interface{}))
+}
+
+func assertStrict(
+	t *testing.T,
+	param dynamic.Param,
+	s string,
+	expected interface{},
+	expectErr bool) {
+	t.Helper()
+	value, _, err := dynamic.ConvertStrict(param, s)
+	if !reflect.DeepEqual(expected, value) {
+		t.Errorf("%q: Expected %v, got %v", s, expected, value)
+	}
+	if expectErr != (err != nil) {
+		t.Errorf("%q: Unexpected error value %v", s, err)
+	}
+	if _, ok := param.(dynamic.ValidatingParam); !ok {
+		t.Errorf("%q: Expected ValidatingParam", s)
+	}
+}
+
+func assertVersion(
+	t *testing.T, s string, expectedVersion int, expectedEncoded string) {
+	t.Helper()
+	version, encoded := dynamic.ParseVersion(s)
+	if version != expectedVersion || encoded != expectedEncoded {
+		t.Errorf(
+			"%s: Expected %d %s, got %d %s",
+			s, expectedVersion, expectedEncoded, version, encoded)
+	}
+}
+
+type plainParam struct {
+}
+
+func (p plainParam) Selection() []string {
+	return nil
+}
+
+func (p plainParam) MaxCharCount() int {
+	return 4
+}
+
+func (p plainParam) Convert(s string) (interface{}, string) {
+	return s, s
+}
+
+type recordingContext map[int]*gohue.LightProperties
+
+func (c recordingContext) Set(
+	lightId int,
+	properties *gohue.LightProperties) (response []byte, err error) {
+	propertiesCopy := *properties
+	c[lightId] = &propertiesCopy
+	return
+}
+
+type fakeParamsFactory dynamic.NamedParamList
+
+func (f fakeParamsFactory) Params() dynamic.NamedParamList {
+	return dynamic.NamedParamList(f)
+}
+
+func (f fakeParamsFactory) New(values []interface{}) ops.HueAction {
+	return colorsAction(values)
+}
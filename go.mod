@@ -1,8 +1,10 @@
 module github.com/keep94/marvin2
 
-go 1.14
+go 1.18
 
 require (
+	github.com/go-sql-driver/mysql v1.7.1
+	github.com/jackc/pgx/v4 v4.18.3
 	github.com/keep94/consume v0.4.0
 	github.com/keep94/gofunctional3 v1.0.2
 	github.com/keep94/gohue v1.0.1
@@ -11,6 +13,23 @@ require (
 	github.com/keep94/sunrise v1.0.1
 	github.com/keep94/tasks v1.0.1
 	github.com/keep94/toolbox v0.4.3
-	github.com/stretchr/testify v1.7.0
-	golang.org/x/net v0.0.0-20200904194848-62affa334b73
+	github.com/stretchr/testify v1.8.1
+	go.etcd.io/bbolt v1.3.9
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/jackc/chunkreader/v2 v2.0.1 // indirect
+	github.com/jackc/pgconn v1.14.3 // indirect
+	github.com/jackc/pgio v1.0.0 // indirect
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgproto3/v2 v2.3.3 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
+	github.com/jackc/pgtype v1.14.0 // indirect
+	github.com/keep94/common v1.0.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/crypto v0.20.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
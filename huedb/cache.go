@@ -0,0 +1,143 @@
+package huedb
+
+import (
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/toolbox/db"
+	"sync"
+)
+
+// NamedColorsCacheStore is what NamedColorsCache wraps.
+type NamedColorsCacheStore interface {
+	NamedColorsByIdRunner
+	AddNamedColorsRunner
+	UpdateNamedColorsRunner
+	RemoveNamedColorsRunner
+}
+
+// NamedColorsCache is a read-through cache for NamedColorsById. Use it as
+// the Store of FutureHueTask instances so that running a scheduled task
+// does not read the database each time. NamedColorsCache passes
+// AddNamedColors, UpdateNamedColors, and RemoveNamedColors through to the
+// store it wraps and invalidates the affected id so all writes must go
+// through it. Reads within a transaction bypass the cache since they may
+// see changes that are not yet committed. Because writes within a
+// transaction invalidate before the transaction commits, callers writing
+// within a transaction should call Invalidate again after it commits.
+// NamedColorsCache instances are safe to use with multiple goroutines.
+type NamedColorsCache struct {
+	store NamedColorsCacheStore
+	mutex sync.Mutex
+	// nil value means no such id
+	cache map[int64]*ops.NamedColors
+	// Incremented on each invalidation so that a read racing with a write
+	// does not cache what it read.
+	generation int64
+}
+
+// NewNamedColorsCache returns a new NamedColorsCache that wraps store.
+func NewNamedColorsCache(store NamedColorsCacheStore) *NamedColorsCache {
+	return &NamedColorsCache{
+		store: store, cache: make(map[int64]*ops.NamedColors)}
+}
+
+// NamedColorsById gets named colors by id from the cache reading them from
+// the wrapped store on a cache miss. NamedColorsById caches ErrNoSuchId
+// too.
+func (c *NamedColorsCache) NamedColorsById(
+	t db.Transaction, id int64, namedColors *ops.NamedColors) error {
+	if t != nil {
+		return c.store.NamedColorsById(t, id, namedColors)
+	}
+	cached, ok, generation := c.get(id)
+	if ok {
+		if cached == nil {
+			return ErrNoSuchId
+		}
+		copyNamedColors(namedColors, cached)
+		return nil
+	}
+	var fetched ops.NamedColors
+	err := c.store.NamedColorsById(nil, id, &fetched)
+	if err == ErrNoSuchId {
+		c.put(id, nil, generation)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	c.put(id, &fetched, generation)
+	copyNamedColors(namedColors, &fetched)
+	return nil
+}
+
+// AddNamedColors adds named colors to the wrapped store.
+func (c *NamedColorsCache) AddNamedColors(
+	t db.Transaction, namedColors *ops.NamedColors) error {
+	if err := c.store.AddNamedColors(t, namedColors); err != nil {
+		return err
+	}
+	c.Invalidate(namedColors.Id)
+	return nil
+}
+
+// UpdateNamedColors updates named colors in the wrapped store.
+func (c *NamedColorsCache) UpdateNamedColors(
+	t db.Transaction, namedColors *ops.NamedColors) error {
+	if err := c.store.UpdateNamedColors(t, namedColors); err != nil {
+		return err
+	}
+	c.Invalidate(namedColors.Id)
+	return nil
+}
+
+// RemoveNamedColors removes named colors from the wrapped store.
+func (c *NamedColorsCache) RemoveNamedColors(t db.Transaction, id int64) error {
+	if err := c.store.RemoveNamedColors(t, id); err != nil {
+		return err
+	}
+	c.Invalidate(id)
+	return nil
+}
+
+// Invalidate removes id from the cache.
+func (c *NamedColorsCache) Invalidate(id int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.cache, id)
+	c.generation++
+}
+
+// InvalidateAll empties the cache.
+func (c *NamedColorsCache) InvalidateAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.cache = make(map[int64]*ops.NamedColors)
+	c.generation++
+}
+
+func (c *NamedColorsCache) get(id int64) (
+	namedColors *ops.NamedColors, ok bool, generation int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	namedColors, ok = c.cache[id]
+	return namedColors, ok, c.generation
+}
+
+func (c *NamedColorsCache) put(
+	id int64, namedColors *ops.NamedColors, generation int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation == c.generation {
+		c.cache[id] = namedColors
+	}
+}
+
+func copyNamedColors(dest, src *ops.NamedColors) {
+	*dest = *src
+	if src.Colors != nil {
+		dest.Colors = make(ops.LightColors, len(src.Colors))
+		for lightId, colorBrightness := range src.Colors {
+			dest.Colors[lightId] = colorBrightness
+		}
+	}
+}
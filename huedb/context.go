@@ -0,0 +1,336 @@
+package huedb
+
+import (
+	"context"
+	"github.com/keep94/consume"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/toolbox/db"
+)
+
+// The *Ctx interfaces below are like their counterparts without Ctx
+// except that their methods take a context.Context so that callers can
+// enforce timeouts and cancellation on database calls. Stores that
+// support contexts natively, such as those in the for_postgres and
+// for_mysql packages, pass ctx to the database so that ctx being done
+// aborts a query that is already running. The Ctx functions adapt stores
+// that don't support contexts. Such adapters return ctx.Err() without
+// calling the underlying store if ctx is already done; otherwise they
+// call the underlying store in the caller's goroutine and wait for it to
+// finish. This way, the underlying store never uses the caller's
+// transaction after the caller gets control back.
+
+type NamedColorsByIdCtxRunner interface {
+	NamedColorsByIdCtx(
+		ctx context.Context,
+		t db.Transaction,
+		id int64,
+		colors *ops.NamedColors) error
+}
+
+type NamedColorsCtxRunner interface {
+	NamedColorsCtx(
+		ctx context.Context, t db.Transaction, consumer consume.Consumer) error
+}
+
+type NamedColorsByDescriptionCtxRunner interface {
+	NamedColorsByDescriptionCtx(
+		ctx context.Context,
+		t db.Transaction,
+		query string,
+		consumer consume.Consumer) error
+}
+
+type AddNamedColorsCtxRunner interface {
+	AddNamedColorsCtx(
+		ctx context.Context, t db.Transaction, colors *ops.NamedColors) error
+}
+
+type UpdateNamedColorsCtxRunner interface {
+	UpdateNamedColorsCtx(
+		ctx context.Context, t db.Transaction, colors *ops.NamedColors) error
+}
+
+type RemoveNamedColorsCtxRunner interface {
+	RemoveNamedColorsCtx(ctx context.Context, t db.Transaction, id int64) error
+}
+
+type EncodedAtTimeTasksCtxRunner interface {
+	EncodedAtTimeTasksCtx(
+		ctx context.Context,
+		t db.Transaction,
+		groupId string,
+		consumer consume.Consumer) error
+}
+
+type EncodedAtTimeTaskCtxStore interface {
+	AddEncodedAtTimeTaskCtx(
+		ctx context.Context,
+		t db.Transaction,
+		task *EncodedAtTimeTask) error
+	RemoveEncodedAtTimeTaskByScheduleIdCtx(
+		ctx context.Context, t db.Transaction, groupId, scheduleId string) error
+	EncodedAtTimeTasksCtxRunner
+}
+
+type EncodedScheduledTasksCtxRunner interface {
+	EncodedScheduledTasksCtx(
+		ctx context.Context, t db.Transaction, consumer consume.Consumer) error
+}
+
+type LastParamsCtxRunner interface {
+	LastParamsCtx(
+		ctx context.Context,
+		t db.Transaction,
+		hueTaskId int,
+		params *LastParams) error
+}
+
+type SaveLastParamsCtxRunner interface {
+	SaveLastParamsCtx(
+		ctx context.Context, t db.Transaction, params *LastParams) error
+}
+
+// NamedColorsByIdCtx adapts store to NamedColorsByIdCtxRunner. If store
+// already implements NamedColorsByIdCtxRunner, NamedColorsByIdCtx returns
+// store unchanged. The same goes for the other Ctx functions.
+func NamedColorsByIdCtx(store NamedColorsByIdRunner) NamedColorsByIdCtxRunner {
+	if native, ok := store.(NamedColorsByIdCtxRunner); ok {
+		return native
+	}
+	return namedColorsByIdCtx{store}
+}
+
+// NamedColorsCtx adapts store to NamedColorsCtxRunner.
+func NamedColorsCtx(store NamedColorsRunner) NamedColorsCtxRunner {
+	if native, ok := store.(NamedColorsCtxRunner); ok {
+		return native
+	}
+	return namedColorsCtx{store}
+}
+
+// NamedColorsByDescriptionCtx adapts store to
+// NamedColorsByDescriptionCtxRunner.
+func NamedColorsByDescriptionCtx(
+	store NamedColorsByDescriptionRunner) NamedColorsByDescriptionCtxRunner {
+	if native, ok := store.(NamedColorsByDescriptionCtxRunner); ok {
+		return native
+	}
+	return namedColorsByDescriptionCtx{store}
+}
+
+// AddNamedColorsCtx adapts store to AddNamedColorsCtxRunner.
+func AddNamedColorsCtx(store AddNamedColorsRunner) AddNamedColorsCtxRunner {
+	if native, ok := store.(AddNamedColorsCtxRunner); ok {
+		return native
+	}
+	return addNamedColorsCtx{store}
+}
+
+// UpdateNamedColorsCtx adapts store to UpdateNamedColorsCtxRunner.
+func UpdateNamedColorsCtx(
+	store UpdateNamedColorsRunner) UpdateNamedColorsCtxRunner {
+	if native, ok := store.(UpdateNamedColorsCtxRunner); ok {
+		return native
+	}
+	return updateNamedColorsCtx{store}
+}
+
+// RemoveNamedColorsCtx adapts store to RemoveNamedColorsCtxRunner.
+func RemoveNamedColorsCtx(
+	store RemoveNamedColorsRunner) RemoveNamedColorsCtxRunner {
+	if native, ok := store.(RemoveNamedColorsCtxRunner); ok {
+		return native
+	}
+	return removeNamedColorsCtx{store}
+}
+
+// EncodedAtTimeTasksCtx adapts store to EncodedAtTimeTasksCtxRunner.
+func EncodedAtTimeTasksCtx(
+	store EncodedAtTimeTaskStore) EncodedAtTimeTasksCtxRunner {
+	if native, ok := store.(EncodedAtTimeTasksCtxRunner); ok {
+		return native
+	}
+	return encodedAtTimeTaskCtx{store}
+}
+
+// EncodedAtTimeTaskCtx adapts store to EncodedAtTimeTaskCtxStore.
+func EncodedAtTimeTaskCtx(
+	store EncodedAtTimeTaskStore) EncodedAtTimeTaskCtxStore {
+	if native, ok := store.(EncodedAtTimeTaskCtxStore); ok {
+		return native
+	}
+	return encodedAtTimeTaskCtx{store}
+}
+
+// EncodedScheduledTasksCtx adapts store to EncodedScheduledTasksCtxRunner.
+func EncodedScheduledTasksCtx(
+	store EncodedScheduledTasksRunner) EncodedScheduledTasksCtxRunner {
+	if native, ok := store.(EncodedScheduledTasksCtxRunner); ok {
+		return native
+	}
+	return encodedScheduledTasksCtx{store}
+}
+
+// LastParamsCtx adapts store to LastParamsCtxRunner.
+func LastParamsCtx(store LastParamsRunner) LastParamsCtxRunner {
+	if native, ok := store.(LastParamsCtxRunner); ok {
+		return native
+	}
+	return lastParamsCtx{store}
+}
+
+// SaveLastParamsCtx adapts store to SaveLastParamsCtxRunner.
+func SaveLastParamsCtx(store SaveLastParamsRunner) SaveLastParamsCtxRunner {
+	if native, ok := store.(SaveLastParamsCtxRunner); ok {
+		return native
+	}
+	return saveLastParamsCtx{store}
+}
+
+type namedColorsByIdCtx struct {
+	store NamedColorsByIdRunner
+}
+
+func (n namedColorsByIdCtx) NamedColorsByIdCtx(
+	ctx context.Context,
+	t db.Transaction,
+	id int64,
+	colors *ops.NamedColors) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return n.store.NamedColorsById(t, id, colors)
+}
+
+type namedColorsCtx struct {
+	store NamedColorsRunner
+}
+
+func (n namedColorsCtx) NamedColorsCtx(
+	ctx context.Context, t db.Transaction, consumer consume.Consumer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return n.store.NamedColors(t, consumer)
+}
+
+type namedColorsByDescriptionCtx struct {
+	store NamedColorsByDescriptionRunner
+}
+
+func (n namedColorsByDescriptionCtx) NamedColorsByDescriptionCtx(
+	ctx context.Context,
+	t db.Transaction,
+	query string,
+	consumer consume.Consumer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return n.store.NamedColorsByDescription(t, query, consumer)
+}
+
+type addNamedColorsCtx struct {
+	store AddNamedColorsRunner
+}
+
+func (a addNamedColorsCtx) AddNamedColorsCtx(
+	ctx context.Context, t db.Transaction, colors *ops.NamedColors) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.store.AddNamedColors(t, colors)
+}
+
+type updateNamedColorsCtx struct {
+	store UpdateNamedColorsRunner
+}
+
+func (u updateNamedColorsCtx) UpdateNamedColorsCtx(
+	ctx context.Context, t db.Transaction, colors *ops.NamedColors) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return u.store.UpdateNamedColors(t, colors)
+}
+
+type removeNamedColorsCtx struct {
+	store RemoveNamedColorsRunner
+}
+
+func (r removeNamedColorsCtx) RemoveNamedColorsCtx(
+	ctx context.Context, t db.Transaction, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.store.RemoveNamedColors(t, id)
+}
+
+type encodedAtTimeTaskCtx struct {
+	store EncodedAtTimeTaskStore
+}
+
+func (e encodedAtTimeTaskCtx) AddEncodedAtTimeTaskCtx(
+	ctx context.Context, t db.Transaction, task *EncodedAtTimeTask) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.store.AddEncodedAtTimeTask(t, task)
+}
+
+func (e encodedAtTimeTaskCtx) RemoveEncodedAtTimeTaskByScheduleIdCtx(
+	ctx context.Context, t db.Transaction, groupId, scheduleId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.store.RemoveEncodedAtTimeTaskByScheduleId(t, groupId, scheduleId)
+}
+
+func (e encodedAtTimeTaskCtx) EncodedAtTimeTasksCtx(
+	ctx context.Context,
+	t db.Transaction,
+	groupId string,
+	consumer consume.Consumer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.store.EncodedAtTimeTasks(t, groupId, consumer)
+}
+
+type encodedScheduledTasksCtx struct {
+	store EncodedScheduledTasksRunner
+}
+
+func (e encodedScheduledTasksCtx) EncodedScheduledTasksCtx(
+	ctx context.Context, t db.Transaction, consumer consume.Consumer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.store.EncodedScheduledTasks(t, consumer)
+}
+
+type lastParamsCtx struct {
+	store LastParamsRunner
+}
+
+func (l lastParamsCtx) LastParamsCtx(
+	ctx context.Context,
+	t db.Transaction,
+	hueTaskId int,
+	params *LastParams) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return l.store.LastParams(t, hueTaskId, params)
+}
+
+type saveLastParamsCtx struct {
+	store SaveLastParamsRunner
+}
+
+func (s saveLastParamsCtx) SaveLastParamsCtx(
+	ctx context.Context, t db.Transaction, params *LastParams) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.store.SaveLastParams(t, params)
+}
@@ -0,0 +1,213 @@
+// Package credentials stores the credentials for the hue bridge encrypted
+// at rest so that they need not be passed in plaintext flags. The
+// credentials file is encrypted with AES-256-GCM using a key kept in a
+// separate key file.
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/ops"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// kKeySize is the size of keys in bytes.
+const kKeySize = 32
+
+var (
+	// Indicates that no credentials have been saved.
+	ErrNoCredentials = errors.New("credentials: No credentials.")
+
+	// Indicates that a key file does not have a valid key.
+	ErrBadKey = errors.New("credentials: Bad key.")
+
+	// Indicates that the credentials file is corrupt or was encrypted with
+	// a different key.
+	ErrDecrypt = errors.New("credentials: Cannot decrypt.")
+)
+
+// Credentials are what is needed to connect to a hue bridge.
+type Credentials struct {
+	// The host name or IP address of the bridge.
+	Host string `json:"host"`
+
+	// The username the bridge issued to this app.
+	Username string `json:"username"`
+
+	// The client key the bridge issued to this app for streaming. Empty if
+	// none.
+	ClientKey string `json:"client_key,omitempty"`
+}
+
+// Context returns a context for talking to the bridge.
+func (c *Credentials) Context() *gohue.Context {
+	return gohue.NewContext(c.Host, c.Username)
+}
+
+// BridgeContext works like Context except that the returned context
+// implements ops.CancelableContext so that calls to the bridge abort as
+// soon as the task making them is interrupted.
+func (c *Credentials) BridgeContext() *ops.BridgeContext {
+	return ops.NewBridgeContext(c.Host, c.Username, nil)
+}
+
+// GenerateKey writes a new random key to the file at keyPath readable
+// only by its owner. GenerateKey fails rather than overwrite an existing
+// key file since doing so would make existing credentials unreadable.
+func GenerateKey(keyPath string) error {
+	key := make([]byte, kKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReadKey reads the key from the file at keyPath. The file contains the
+// key as hex. ReadKey returns ErrBadKey if the file does not have a valid
+// key.
+func ReadKey(keyPath string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(contents)))
+	if err != nil || len(key) != kKeySize {
+		return nil, ErrBadKey
+	}
+	return key, nil
+}
+
+// Store keeps credentials encrypted in a file. Store instances are safe
+// to use with multiple goroutines.
+type Store struct {
+	path string
+	aead cipher.AEAD
+	mu   sync.Mutex
+}
+
+// New returns a Store that keeps credentials in the file at path
+// encrypted with key. key comes from ReadKey.
+func New(path string, key []byte) (*Store, error) {
+	if len(key) != kKeySize {
+		return nil, ErrBadKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{path: path, aead: aead}, nil
+}
+
+// Open works like New except that it reads the key from the file at
+// keyPath.
+func Open(path, keyPath string) (*Store, error) {
+	key, err := ReadKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return New(path, key)
+}
+
+// Get reads the credentials into creds. Get returns ErrNoCredentials if
+// none have been saved and ErrDecrypt if the file cannot be decrypted.
+func (s *Store) Get(creds *Credentials) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contents, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return ErrNoCredentials
+	}
+	if err != nil {
+		return err
+	}
+	nonceSize := s.aead.NonceSize()
+	if len(contents) < nonceSize {
+		return ErrDecrypt
+	}
+	plaintext, err := s.aead.Open(
+		nil, contents[:nonceSize], contents[nonceSize:], nil)
+	if err != nil {
+		return ErrDecrypt
+	}
+	var result Credentials
+	if err := json.Unmarshal(plaintext, &result); err != nil {
+		return err
+	}
+	*creds = result
+	return nil
+}
+
+// Save encrypts creds and atomically replaces the credentials file with
+// them. Save creates the file readable only by its owner.
+func (s *Store) Save(creds *Credentials) error {
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	contents := s.aead.Seal(nonce, nonce, plaintext, nil)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeAtomically(s.path, contents)
+}
+
+// Remove removes the saved credentials if any.
+func (s *Store) Remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func writeAtomically(path string, contents []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package credentials_test
+
+import (
+	"bytes"
+	"github.com/keep94/marvin2/huedb/credentials"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	keyPath := filepath.Join(dir, "key")
+	path := filepath.Join(dir, "bridge")
+	if err := credentials.GenerateKey(keyPath); err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	if err := credentials.GenerateKey(keyPath); err == nil {
+		t.Error("Expected error overwriting key")
+	}
+	store, err := credentials.Open(path, keyPath)
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	var creds credentials.Credentials
+	if err := store.Get(&creds); err != credentials.ErrNoCredentials {
+		t.Errorf("Expected ErrNoCredentials, got %v", err)
+	}
+	saved := credentials.Credentials{
+		Host:      "192.168.1.2",
+		Username:  "secret-user",
+		ClientKey: "secret-key",
+	}
+	if err := store.Save(&saved); err != nil {
+		t.Fatalf("Error saving: %v", err)
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+	if bytes.Contains(contents, []byte("secret")) {
+		t.Error("Expected credentials to be encrypted")
+	}
+	reopened, err := credentials.Open(path, keyPath)
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	if err := reopened.Get(&creds); err != nil {
+		t.Fatalf("Error getting credentials: %v", err)
+	}
+	if creds != saved {
+		t.Errorf("Expected %v, got %v", saved, creds)
+	}
+	if err := reopened.Remove(); err != nil {
+		t.Fatalf("Error removing: %v", err)
+	}
+	if err := reopened.Get(&creds); err != credentials.ErrNoCredentials {
+		t.Errorf("Expected ErrNoCredentials, got %v", err)
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bridge")
+	store, err := credentials.New(path, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	if err := store.Save(&credentials.Credentials{Host: "h"}); err != nil {
+		t.Fatalf("Error saving: %v", err)
+	}
+	other, err := credentials.New(path, bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	var creds credentials.Credentials
+	if err := other.Get(&creds); err != credentials.ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt, got %v", err)
+	}
+	if _, err := credentials.New(path, []byte("short")); err != credentials.ErrBadKey {
+		t.Errorf("Expected ErrBadKey, got %v", err)
+	}
+	keyPath := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(keyPath, []byte("not hex"), 0600); err != nil {
+		t.Fatalf("Error writing key: %v", err)
+	}
+	if _, err := credentials.ReadKey(keyPath); err != credentials.ErrBadKey {
+		t.Errorf("Expected ErrBadKey, got %v", err)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	return dir
+}
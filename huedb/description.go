@@ -0,0 +1,132 @@
+package huedb
+
+import (
+	"errors"
+	"github.com/keep94/consume"
+	"github.com/keep94/marvin2/logging"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/toolbox/db"
+	"strings"
+)
+
+var (
+	// Indicates that other named colors already have the same description.
+	ErrDuplicateDescription = errors.New("huedb: Duplicate description.")
+)
+
+type NamedColorsByExactDescriptionRunner interface {
+	// NamedColorsByExactDescription gets the named colors whose description
+	// equals description ignoring case.
+	NamedColorsByExactDescription(
+		t db.Transaction, description string, consumer consume.Consumer) error
+}
+
+// FindByExactDescription gets the named colors in store whose description
+// equals description ignoring case. If store implements
+// NamedColorsByExactDescriptionRunner, FindByExactDescription uses it;
+// otherwise it filters the results of NamedColorsByDescription.
+func FindByExactDescription(
+	t db.Transaction,
+	store NamedColorsByDescriptionRunner,
+	description string,
+	consumer consume.Consumer) error {
+	if exact, ok := store.(NamedColorsByExactDescriptionRunner); ok {
+		return exact.NamedColorsByExactDescription(t, description, consumer)
+	}
+	consumer = consume.MapFilter(
+		consumer,
+		func(namedColors *ops.NamedColors) bool {
+			return strings.EqualFold(namedColors.Description, description)
+		})
+	return store.NamedColorsByDescription(t, description, consumer)
+}
+
+// DuplicateDescriptionPolicy controls what CheckDescriptions does when it
+// finds a duplicate description.
+type DuplicateDescriptionPolicy int
+
+const (
+	// Log duplicate descriptions but still add or update.
+	WarnDuplicateDescriptions DuplicateDescriptionPolicy = iota
+
+	// Return ErrDuplicateDescription without adding or updating.
+	RejectDuplicateDescriptions
+)
+
+// DescriptionCheckStore is what CheckDescriptions wraps.
+type DescriptionCheckStore interface {
+	NamedColorsByDescriptionRunner
+	AddNamedColorsRunner
+	UpdateNamedColorsRunner
+}
+
+// NamedColorsWriter adds and updates named colors.
+type NamedColorsWriter interface {
+	AddNamedColorsRunner
+	UpdateNamedColorsRunner
+}
+
+// CheckDescriptions returns a NamedColorsWriter that adds and updates
+// named colors in store after checking that no other named colors in
+// store have the same description ignoring case. Duplicate descriptions
+// make picking named colors by name ambiguous. policy controls what
+// happens when the returned writer finds a duplicate. Archived named
+// colors do not count as duplicates. To avoid races, callers should check
+// and write within the same transaction. logger gets the duplicates that
+// WarnDuplicateDescriptions lets through.
+func CheckDescriptions(
+	store DescriptionCheckStore,
+	policy DuplicateDescriptionPolicy,
+	logger logging.Logger) NamedColorsWriter {
+	return &descriptionChecker{store: store, policy: policy, logger: logger}
+}
+
+type descriptionChecker struct {
+	store  DescriptionCheckStore
+	policy DuplicateDescriptionPolicy
+	logger logging.Logger
+}
+
+func (c *descriptionChecker) AddNamedColors(
+	t db.Transaction, namedColors *ops.NamedColors) error {
+	if err := c.check(t, namedColors); err != nil {
+		return err
+	}
+	return c.store.AddNamedColors(t, namedColors)
+}
+
+func (c *descriptionChecker) UpdateNamedColors(
+	t db.Transaction, namedColors *ops.NamedColors) error {
+	if err := c.check(t, namedColors); err != nil {
+		return err
+	}
+	return c.store.UpdateNamedColors(t, namedColors)
+}
+
+func (c *descriptionChecker) check(
+	t db.Transaction, namedColors *ops.NamedColors) error {
+	var existing ops.NamedColors
+	found := false
+	consumer := consume.ConsumerFunc(func(ptr interface{}) {
+		other := ptr.(*ops.NamedColors)
+		if other.Id != namedColors.Id && !found {
+			existing = *other
+			found = true
+		}
+	})
+	if err := FindByExactDescription(
+		t, c.store, namedColors.Description, consumer); err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+	if c.policy == RejectDuplicateDescriptions {
+		return ErrDuplicateDescription
+	}
+	c.logger.Log(
+		"Duplicate named colors description",
+		logging.NewField("named_colors_id", existing.Id),
+		logging.NewField("description", existing.Description))
+	return nil
+}
@@ -0,0 +1,381 @@
+package huedb
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/keep94/consume"
+	"github.com/keep94/marvin2/huedb/internal/lightjson"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/toolbox/db"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// kExportVersion is the version of the document that Export writes.
+const kExportVersion = 1
+
+var (
+	// Indicates that Import does not understand the version of the
+	// document.
+	ErrExportVersion = errors.New("huedb: Unsupported export version.")
+)
+
+// ExportStore is what Export dumps. If an ExportStore also implements
+// ArchivedNamedColorsRunner, Export includes archived named colors.
+type ExportStore interface {
+	NamedColorsRunner
+	ScenesRunner
+	EncodedScheduledTasksRunner
+	EncodedAtTimeTaskStore
+}
+
+// ImportStore is what Import loads into. If an ImportStore also
+// implements ArchiveNamedColorsRunner, Import keeps archived named colors
+// archived.
+type ImportStore interface {
+	AddNamedColorsRunner
+	AddSceneRunner
+	AddEncodedScheduledTaskRunner
+	EncodedAtTimeTaskStore
+	NamedColorsByDescriptionRunner
+}
+
+// Export writes the named colors, scenes, scheduled tasks, and the at time
+// tasks of each group in groupIds in store to w as a versioned JSON
+// document that Import can load into any backend. Export leaves out last
+// params and last actions as those are only caches.
+func Export(w io.Writer, store ExportStore, groupIds ...string) error {
+	doc := exportDocument{Version: kExportVersion}
+	var namedColors []*ops.NamedColors
+	if err := store.NamedColors(
+		nil, consume.AppendPtrsTo(&namedColors)); err != nil {
+		return err
+	}
+	if err := doc.addNamedColors(namedColors, false); err != nil {
+		return err
+	}
+	if archivedStore, ok := store.(ArchivedNamedColorsRunner); ok {
+		var archived []*ops.NamedColors
+		if err := archivedStore.ArchivedNamedColors(
+			nil, consume.AppendPtrsTo(&archived)); err != nil {
+			return err
+		}
+		if err := doc.addNamedColors(archived, true); err != nil {
+			return err
+		}
+	}
+	var scenes []*Scene
+	if err := store.Scenes(nil, consume.AppendPtrsTo(&scenes)); err != nil {
+		return err
+	}
+	for _, scene := range scenes {
+		states, ok := lightjson.FromStates(scene.States)
+		if !ok {
+			return ErrBadLightColors
+		}
+		doc.Scenes = append(doc.Scenes, exportScene{
+			Id:     scene.Id,
+			Name:   scene.Name,
+			States: states,
+			Tags:   scene.Tags,
+		})
+	}
+	if err := store.EncodedScheduledTasks(
+		nil, consume.AppendTo(&doc.ScheduledTasks)); err != nil {
+		return err
+	}
+	for _, groupId := range groupIds {
+		if err := store.EncodedAtTimeTasks(
+			nil, groupId, consume.AppendTo(&doc.AtTimeTasks)); err != nil {
+			return err
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&doc)
+}
+
+// ImportConfig tells Import which hue task ids belong to named colors
+// and which belong to scenes so that Import can fix references to them.
+type ImportConfig struct {
+	// The hue task ids of named colors such as the PersistentIdRange of
+	// the IdSpace of the app.
+	NamedColors ops.IdRange
+
+	// The hue task ids of scenes. Leave empty if scenes are not hue tasks.
+	Scenes ops.IdRange
+
+	// If true, imported named colors whose description already exists in
+	// the store ignoring case refer to the existing named colors instead
+	// of being added again, and imported at time tasks whose schedule id
+	// already exists in their group are skipped. This makes importing the
+	// same data twice harmless.
+	SkipDuplicates bool
+}
+
+// Dump holds what ImportDump adds to a store. The ids in a Dump are the
+// ids the entities had where they came from. Importers of other formats
+// build a Dump so that they fix ids the same way Import does.
+type Dump struct {
+	NamedColors    []DumpedNamedColors
+	Scenes         []Scene
+	ScheduledTasks []EncodedScheduledTask
+	AtTimeTasks    []EncodedAtTimeTask
+}
+
+// DumpedNamedColors is a named colors within a Dump.
+type DumpedNamedColors struct {
+	ops.NamedColors
+
+	// True if the named colors is archived.
+	Archived bool
+}
+
+// Import reads a document that Export wrote from r and adds its contents
+// to store. See ImportDump. Import returns ErrExportVersion if it does
+// not understand the document.
+func Import(
+	r io.Reader, doer db.Doer, store ImportStore, config *ImportConfig) error {
+	var doc exportDocument
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return err
+	}
+	if doc.Version != kExportVersion {
+		return ErrExportVersion
+	}
+	dump, err := doc.toDump()
+	if err != nil {
+		return err
+	}
+	return ImportDump(doer, store, dump, config)
+}
+
+// ImportDump adds the contents of dump to store within a single
+// transaction of doer so that either everything gets imported or nothing
+// does. Added entities get new ids. ImportDump changes the hue task ids
+// in scheduled tasks and at time tasks that refer to imported named colors
+// or scenes to match using the ranges in config. ImportDump also changes
+// the hue task id that starts the schedule id of an at time task so that
+// the scheduler can still find the task by its schedule id.
+func ImportDump(
+	doer db.Doer, store ImportStore, dump *Dump, config *ImportConfig) error {
+	return doer.Do(func(t db.Transaction) error {
+		return importDump(t, store, dump, config)
+	})
+}
+
+// idMap maps the old hue task ids of imported entities to their new ones.
+type idMap map[int]int
+
+// add records that the entity with local id oldId in idRange now has
+// local id newId. add does nothing if idRange does not contain oldId.
+func (m idMap) add(idRange ops.IdRange, oldId, newId int64) {
+	if oldGlobal := idRange.Global(oldId); idRange.Contains(oldGlobal) {
+		m[oldGlobal] = idRange.Global(newId)
+	}
+}
+
+// remap returns the new hue task id for id.
+func (m idMap) remap(id int) int {
+	if newId, ok := m[id]; ok {
+		return newId
+	}
+	return id
+}
+
+// exportDocument is what Export writes.
+type exportDocument struct {
+	Version        int                    `json:"version"`
+	NamedColors    []exportNamedColors    `json:"named_colors"`
+	Scenes         []exportScene          `json:"scenes"`
+	ScheduledTasks []EncodedScheduledTask `json:"scheduled_tasks"`
+	AtTimeTasks    []EncodedAtTimeTask    `json:"at_time_tasks"`
+}
+
+// toDump converts this document to a Dump.
+func (d *exportDocument) toDump() (*Dump, error) {
+	result := &Dump{
+		ScheduledTasks: d.ScheduledTasks,
+		AtTimeTasks:    d.AtTimeTasks,
+	}
+	for _, exported := range d.NamedColors {
+		colors, ok := lightjson.ToColors(exported.Colors)
+		if !ok {
+			return nil, ErrBadLightColors
+		}
+		result.NamedColors = append(result.NamedColors, DumpedNamedColors{
+			NamedColors: ops.NamedColors{
+				Id:          exported.Id,
+				Colors:      colors,
+				Description: exported.Description,
+			},
+			Archived: exported.Archived,
+		})
+	}
+	for _, exported := range d.Scenes {
+		states, ok := lightjson.ToStates(exported.States)
+		if !ok {
+			return nil, ErrBadLightColors
+		}
+		result.Scenes = append(result.Scenes, Scene{
+			Id:     exported.Id,
+			Name:   exported.Name,
+			States: states,
+			Tags:   exported.Tags,
+		})
+	}
+	return result, nil
+}
+
+func importDump(
+	t db.Transaction,
+	store ImportStore,
+	dump *Dump,
+	config *ImportConfig) error {
+	archiveStore, canArchive := store.(ArchiveNamedColorsRunner)
+	newIds := make(idMap)
+	for _, dumped := range dump.NamedColors {
+		if config.SkipDuplicates {
+			existingId, found, err := findNamedColors(
+				t, store, dumped.Description)
+			if err != nil {
+				return err
+			}
+			if found {
+				newIds.add(config.NamedColors, dumped.Id, existingId)
+				continue
+			}
+		}
+		namedColors := ops.NamedColors{
+			Colors: dumped.Colors, Description: dumped.Description}
+		if err := store.AddNamedColors(t, &namedColors); err != nil {
+			return err
+		}
+		newIds.add(config.NamedColors, dumped.Id, namedColors.Id)
+		if dumped.Archived && canArchive {
+			if err := archiveStore.ArchiveNamedColors(
+				t, namedColors.Id); err != nil {
+				return err
+			}
+		}
+	}
+	for _, dumped := range dump.Scenes {
+		scene := Scene{Name: dumped.Name, States: dumped.States, Tags: dumped.Tags}
+		if err := store.AddScene(t, &scene); err != nil {
+			return err
+		}
+		newIds.add(config.Scenes, dumped.Id, scene.Id)
+	}
+	for i := range dump.ScheduledTasks {
+		task := dump.ScheduledTasks[i]
+		task.HueTaskId = newIds.remap(task.HueTaskId)
+		if err := store.AddEncodedScheduledTask(t, &task); err != nil {
+			return err
+		}
+	}
+	existingScheduleIds := make(map[string]map[string]bool)
+	var atTimeTasks []*EncodedAtTimeTask
+	for i := range dump.AtTimeTasks {
+		task := dump.AtTimeTasks[i]
+		newId := newIds.remap(task.HueTaskId)
+		task.ScheduleId = fixScheduleId(task.ScheduleId, task.HueTaskId, newId)
+		task.HueTaskId = newId
+		if config.SkipDuplicates {
+			scheduleIds, ok := existingScheduleIds[task.GroupId]
+			if !ok {
+				var err error
+				scheduleIds, err = findScheduleIds(t, store, task.GroupId)
+				if err != nil {
+					return err
+				}
+				existingScheduleIds[task.GroupId] = scheduleIds
+			}
+			if scheduleIds[task.ScheduleId] {
+				continue
+			}
+		}
+		atTimeTasks = append(atTimeTasks, &task)
+	}
+	return AddEncodedAtTimeTasks(t, store, atTimeTasks)
+}
+
+// findNamedColors returns the id of the first named colors in store
+// whose description equals description ignoring case.
+func findNamedColors(
+	t db.Transaction,
+	store NamedColorsByDescriptionRunner,
+	description string) (id int64, found bool, err error) {
+	var existing []*ops.NamedColors
+	if err = FindByExactDescription(
+		t, store, description, consume.AppendPtrsTo(&existing)); err != nil {
+		return
+	}
+	if len(existing) == 0 {
+		return
+	}
+	return existing[0].Id, true, nil
+}
+
+// findScheduleIds returns the schedule ids of the at time tasks in
+// groupId.
+func findScheduleIds(
+	t db.Transaction,
+	store EncodedAtTimeTaskStore,
+	groupId string) (map[string]bool, error) {
+	var tasks []*EncodedAtTimeTask
+	if err := store.EncodedAtTimeTasks(
+		t, groupId, consume.AppendPtrsTo(&tasks)); err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		result[task.ScheduleId] = true
+	}
+	return result, nil
+}
+
+// fixScheduleId changes the hue task id that starts scheduleId from
+// oldId to newId so that the scheduler can still find the task by its
+// schedule id.
+func fixScheduleId(scheduleId string, oldId, newId int) string {
+	if oldId == newId {
+		return scheduleId
+	}
+	prefix := strconv.Itoa(oldId) + ":"
+	if !strings.HasPrefix(scheduleId, prefix) {
+		return scheduleId
+	}
+	return strconv.Itoa(newId) + ":" + scheduleId[len(prefix):]
+}
+
+func (d *exportDocument) addNamedColors(
+	namedColors []*ops.NamedColors, archived bool) error {
+	for _, nc := range namedColors {
+		colors, ok := lightjson.FromColors(nc.Colors)
+		if !ok {
+			return ErrBadLightColors
+		}
+		d.NamedColors = append(d.NamedColors, exportNamedColors{
+			Id:          nc.Id,
+			Description: nc.Description,
+			Colors:      colors,
+			Archived:    archived,
+		})
+	}
+	return nil
+}
+
+type exportNamedColors struct {
+	Id          int64                             `json:"id"`
+	Description string                            `json:"description"`
+	Colors      map[int]lightjson.ColorBrightness `json:"colors"`
+	Archived    bool                              `json:"archived,omitempty"`
+}
+
+type exportScene struct {
+	Id     int64                        `json:"id"`
+	Name   string                       `json:"name"`
+	States map[int]lightjson.LightState `json:"states"`
+	Tags   []string                     `json:"tags,omitempty"`
+}
@@ -3,13 +3,16 @@
 package fixture
 
 import (
+	"context"
 	"github.com/keep94/consume"
 	"github.com/keep94/gohue"
 	"github.com/keep94/marvin2/huedb"
 	"github.com/keep94/marvin2/ops"
 	"github.com/keep94/maybe"
+	"net/url"
 	"reflect"
 	"testing"
+	"time"
 )
 
 var (
@@ -55,6 +58,21 @@ type NamedColorsStore interface {
 	huedb.NamedColorsRunner
 }
 
+type NamedColorsByDescriptionStore interface {
+	MinimalStore
+	huedb.NamedColorsByDescriptionRunner
+}
+
+type NamedColorsByExactDescriptionStore interface {
+	MinimalStore
+	huedb.NamedColorsByExactDescriptionRunner
+}
+
+type NamedColorsWithOptionsStore interface {
+	MinimalStore
+	huedb.NamedColorsWithOptionsRunner
+}
+
 type UpdateNamedColorsStore interface {
 	MinimalStore
 	huedb.UpdateNamedColorsRunner
@@ -65,6 +83,239 @@ type RemoveNamedColorsStore interface {
 	huedb.RemoveNamedColorsRunner
 }
 
+type ContextStore interface {
+	NamedColorsStore
+	huedb.UpdateNamedColorsRunner
+	huedb.RemoveNamedColorsRunner
+}
+
+// ArchiveNamedColorsStore is what ArchiveNamedColors tests. If the store
+// also implements huedb.NamedColorsWithOptionsRunner, ArchiveNamedColors
+// tests that too.
+type ArchiveNamedColorsStore interface {
+	NamedColorsStore
+	huedb.NamedColorsByDescriptionRunner
+	huedb.ArchiveNamedColorsRunner
+	huedb.RestoreNamedColorsRunner
+	huedb.ArchivedNamedColorsRunner
+}
+
+type ModifiedSinceStore interface {
+	UpdateNamedColorsStore
+	huedb.NamedColorsModifiedSinceRunner
+	huedb.EncodedAtTimeTaskStore
+	huedb.EncodedAtTimeTasksModifiedSinceRunner
+}
+
+type TaskRunStore interface {
+	huedb.AddTaskRunRunner
+	huedb.LastTaskRunRunner
+	huedb.TaskStatsRunner
+	huedb.TaskRunsPerDayRunner
+}
+
+type ScheduledTaskStore interface {
+	huedb.EncodedScheduledTasksRunner
+	huedb.AddEncodedScheduledTaskRunner
+	huedb.UpdateEncodedScheduledTaskRunner
+	huedb.RemoveEncodedScheduledTaskRunner
+	huedb.EnableEncodedScheduledTaskRunner
+}
+
+func ScheduledTasks(t *testing.T, store ScheduledTaskStore) {
+	first := &huedb.EncodedScheduledTask{
+		HueTaskId:   3,
+		Action:      "abc",
+		Description: "Foo",
+		LightSet:    "1,2",
+		Recurring:   "7:00",
+		Priority:    100,
+		Enabled:     true,
+	}
+	second := &huedb.EncodedScheduledTask{
+		HueTaskId:   10007,
+		Description: "Bar",
+		LightSet:    "All",
+		Recurring:   "hourly",
+	}
+	if err := store.AddEncodedScheduledTask(nil, first); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	if err := store.AddEncodedScheduledTask(nil, second); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	assertScheduledTasks(t, store, first, second)
+	first.Enabled = false
+	first.Action = "def"
+	if err := store.UpdateEncodedScheduledTask(nil, first); err != nil {
+		t.Fatalf("Got error updating: %v", err)
+	}
+	assertScheduledTasks(t, store, first, second)
+	if err := store.EnableEncodedScheduledTask(nil, second.Id, true); err != nil {
+		t.Fatalf("Got error enabling: %v", err)
+	}
+	second.Enabled = true
+	assertScheduledTasks(t, store, first, second)
+	if err := store.RemoveEncodedScheduledTask(nil, first.Id); err != nil {
+		t.Fatalf("Got error removing: %v", err)
+	}
+	assertScheduledTasks(t, store, second)
+}
+
+type SceneStore interface {
+	huedb.SceneByIdRunner
+	huedb.ScenesRunner
+	huedb.AddSceneRunner
+	huedb.UpdateSceneRunner
+	huedb.RemoveSceneRunner
+}
+
+func Scenes(t *testing.T, store SceneStore) {
+	first := &huedb.Scene{
+		Name: "Movie",
+		States: ops.LightStates{
+			2: {
+				On:         true,
+				Brightness: maybe.NewUint8(200),
+				Ct:         maybe.NewUint16(366),
+				ColorMode:  ops.ColorModeCT,
+			},
+			3: {
+				On:             true,
+				Color:          gohue.NewMaybeColor(gohue.NewColor(0.5, 0.3)),
+				Brightness:     maybe.NewUint8(0),
+				Effect:         ops.EffectColorLoop,
+				TransitionTime: maybe.NewUint16(10),
+			},
+			4: {},
+		},
+		Tags: []string{"evening", "a,b"},
+	}
+	second := &huedb.Scene{Name: "Empty"}
+	if err := store.AddScene(nil, first); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	if err := store.AddScene(nil, second); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	assertScene(t, store, first)
+	assertScene(t, store, second)
+	assertScenes(t, store, first, second)
+	first.Name = "Dinner"
+	first.States = ops.LightStates{
+		5: {On: true, Brightness: maybe.NewUint8(30)},
+	}
+	first.Tags = nil
+	if err := store.UpdateScene(nil, first); err != nil {
+		t.Fatalf("Got error updating: %v", err)
+	}
+	assertScene(t, store, first)
+	if err := store.RemoveScene(nil, second.Id); err != nil {
+		t.Fatalf("Got error removing: %v", err)
+	}
+	var scene huedb.Scene
+	if err := store.SceneById(nil, second.Id, &scene); err != huedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	assertScenes(t, store, first)
+}
+
+type DescriptionOverrideStore interface {
+	huedb.DescriptionOverrideRunner
+	huedb.DescriptionOverridesRunner
+	huedb.SaveDescriptionOverrideRunner
+	huedb.RemoveDescriptionOverrideRunner
+}
+
+func DescriptionOverrides(t *testing.T, store DescriptionOverrideStore) {
+	var override huedb.DescriptionOverride
+	if err := store.DescriptionOverride(nil, 7, &override); err != huedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	first := &huedb.DescriptionOverride{HueTaskId: 8, Description: "Foo"}
+	second := &huedb.DescriptionOverride{HueTaskId: 7, Description: "Bar"}
+	if err := store.SaveDescriptionOverride(nil, first); err != nil {
+		t.Fatalf("Got error saving: %v", err)
+	}
+	if err := store.SaveDescriptionOverride(nil, second); err != nil {
+		t.Fatalf("Got error saving: %v", err)
+	}
+	assertDescriptionOverride(t, store, first)
+	assertDescriptionOverrides(t, store, second, first)
+	first.Description = "Baz"
+	if err := store.SaveDescriptionOverride(nil, first); err != nil {
+		t.Fatalf("Got error saving: %v", err)
+	}
+	assertDescriptionOverride(t, store, first)
+	if err := store.RemoveDescriptionOverride(nil, 7); err != nil {
+		t.Fatalf("Got error removing: %v", err)
+	}
+	if err := store.DescriptionOverride(nil, 7, &override); err != huedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	assertDescriptionOverrides(t, store, first)
+}
+
+func LastFired(t *testing.T, store huedb.LastFiredStore) {
+	var fired huedb.LastFired
+	if err := store.LastFired(nil, 7, &fired); err != huedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	first := &huedb.LastFired{
+		ScheduledTaskId: 7,
+		Time:            time.Unix(1400000000, 0),
+	}
+	second := &huedb.LastFired{
+		ScheduledTaskId: 8,
+		Time:            time.Unix(1400000300, 0),
+	}
+	if err := store.SaveLastFired(nil, first); err != nil {
+		t.Fatalf("Got error saving: %v", err)
+	}
+	if err := store.SaveLastFired(nil, second); err != nil {
+		t.Fatalf("Got error saving: %v", err)
+	}
+	assertLastFired(t, store, first)
+	assertLastFired(t, store, second)
+	first.Time = time.Unix(1400086400, 0)
+	if err := store.SaveLastFired(nil, first); err != nil {
+		t.Fatalf("Got error saving: %v", err)
+	}
+	assertLastFired(t, store, first)
+}
+
+func LastParams(t *testing.T, store huedb.LastParamsStore) {
+	var params huedb.LastParams
+	if err := store.LastParams(nil, 7, &params); err != huedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	first := &huedb.LastParams{
+		HueTaskId: 7,
+		Values:    url.Values{"0": {"3", "1"}, "1": {"98"}},
+	}
+	second := &huedb.LastParams{
+		HueTaskId:   8,
+		Values:      url.Values{"0": {"a b&c"}},
+		Action:      `{"Bri":["98"]}`,
+		Description: "Foo Bri: 98",
+	}
+	if err := store.SaveLastParams(nil, first); err != nil {
+		t.Fatalf("Got error saving: %v", err)
+	}
+	if err := store.SaveLastParams(nil, second); err != nil {
+		t.Fatalf("Got error saving: %v", err)
+	}
+	assertLastParams(t, store, first)
+	assertLastParams(t, store, second)
+	first.Values = url.Values{"1": {"45"}}
+	first.Action = "abc"
+	first.Description = "Foo Bri: 45"
+	if err := store.SaveLastParams(nil, first); err != nil {
+		t.Fatalf("Got error saving: %v", err)
+	}
+	assertLastParams(t, store, first)
+}
+
 func NamedColorsById(t *testing.T, store MinimalStore) {
 	var first, second, firstResult, secondResult ops.NamedColors
 	createNamedColors(t, store, &first, &second)
@@ -92,6 +343,74 @@ func NamedColors(t *testing.T, store NamedColorsStore) {
 	assertNCEqual(t, &second, &results[1])
 }
 
+func NamedColorsByDescription(
+	t *testing.T, store NamedColorsByDescriptionStore) {
+	var first, second ops.NamedColors
+	createNamedColors(t, store, &first, &second)
+	third := ops.NamedColors{Description: "50% _off_"}
+	if err := store.AddNamedColors(nil, &third); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	assertNamedColorsByDescription(t, store, "fO", &first)
+	assertNamedColorsByDescription(t, store, "A", &second)
+	assertNamedColorsByDescription(t, store, "", &first, &second, &third)
+	assertNamedColorsByDescription(t, store, "% _", &third)
+	assertNamedColorsByDescription(t, store, "F_o")
+	assertNamedColorsByDescription(t, store, "5%")
+}
+
+func NamedColorsByExactDescription(
+	t *testing.T, store NamedColorsByExactDescriptionStore) {
+	var first, second ops.NamedColors
+	createNamedColors(t, store, &first, &second)
+	third := ops.NamedColors{Description: "FOO"}
+	if err := store.AddNamedColors(nil, &third); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	assertNamedColorsByExactDescription(t, store, "foo", &first, &third)
+	assertNamedColorsByExactDescription(t, store, "Bar", &second)
+	assertNamedColorsByExactDescription(t, store, "Ba")
+	assertNamedColorsByExactDescription(t, store, "F%")
+}
+
+func NamedColorsWithOptions(
+	t *testing.T, store NamedColorsWithOptionsStore) {
+	var first, second ops.NamedColors
+	createNamedColors(t, store, &first, &second)
+	third := ops.NamedColors{Description: "bar"}
+	if err := store.AddNamedColors(nil, &third); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	assertNamedColorsWithOptions(
+		t, store, &huedb.NamedColorsOptions{}, &first, &second, &third)
+	assertNamedColorsWithOptions(
+		t,
+		store,
+		&huedb.NamedColorsOptions{OrderByDescription: true},
+		&second, &third, &first)
+	assertNamedColorsWithOptions(
+		t,
+		store,
+		&huedb.NamedColorsOptions{OrderByDescription: true, Limit: 2},
+		&second, &third)
+	assertNamedColorsWithOptions(
+		t,
+		store,
+		&huedb.NamedColorsOptions{
+			OrderByDescription: true, Limit: 2, Offset: 2},
+		&first)
+	assertNamedColorsWithOptions(
+		t,
+		store,
+		&huedb.NamedColorsOptions{Offset: 1},
+		&second, &third)
+	assertNamedColorsWithOptions(
+		t,
+		store,
+		&huedb.NamedColorsOptions{Description: "BA", Limit: 1, Offset: 1},
+		&third)
+}
+
 func UpdateNamedColors(t *testing.T, store UpdateNamedColorsStore) {
 	var first, second, firstResult, secondResult ops.NamedColors
 	createNamedColors(t, store, &first, &second)
@@ -145,6 +464,59 @@ func UpdateNamedColors(t *testing.T, store UpdateNamedColorsStore) {
 	}
 }
 
+// ConcurrentUpdateNamedColors tests stores that support optimistic
+// concurrency.
+func ConcurrentUpdateNamedColors(
+	t *testing.T, store UpdateNamedColorsStore) {
+	var first, second ops.NamedColors
+	createNamedColors(t, store, &first, &second)
+	if first.Version != 1 {
+		t.Errorf("Expected add to set version 1, got %d", first.Version)
+	}
+	var tab1, tab2 ops.NamedColors
+	if err := store.NamedColorsById(nil, first.Id, &tab1); err != nil {
+		t.Fatalf("Got error reading database by id: %v", err)
+	}
+	if err := store.NamedColorsById(nil, first.Id, &tab2); err != nil {
+		t.Fatalf("Got error reading database by id: %v", err)
+	}
+	tab1.Description = "Tab 1"
+	if err := store.UpdateNamedColors(nil, &tab1); err != nil {
+		t.Fatalf("Got error updating database: %v", err)
+	}
+	tab2.Description = "Tab 2"
+	if err := store.UpdateNamedColors(nil, &tab2); err != huedb.ErrConcurrentModification {
+		t.Errorf("Expected ErrConcurrentModification, got %v", err)
+	}
+	var result ops.NamedColors
+	if err := store.NamedColorsById(nil, first.Id, &result); err != nil {
+		t.Fatalf("Got error reading database by id: %v", err)
+	}
+	assertNCEqual(t, &tab1, &result)
+
+	// Updating after reading again works
+	result.Description = "Tab 2"
+	if err := store.UpdateNamedColors(nil, &result); err != nil {
+		t.Errorf("Got error updating database: %v", err)
+	}
+	if result.Version <= tab1.Version {
+		t.Errorf("Expected version to increase past %d, got %d", tab1.Version, result.Version)
+	}
+
+	// Version 0 updates without checking
+	unchecked := ops.NamedColors{Id: first.Id, Description: "Unchecked"}
+	if err := store.UpdateNamedColors(nil, &unchecked); err != nil {
+		t.Errorf("Got error updating database: %v", err)
+	}
+	if unchecked.Version != result.Version+1 {
+		t.Errorf("Expected version %d, got %d", result.Version+1, unchecked.Version)
+	}
+	if err := store.NamedColorsById(nil, first.Id, &result); err != nil {
+		t.Fatalf("Got error reading database by id: %v", err)
+	}
+	assertNCEqual(t, &unchecked, &result)
+}
+
 func RemoveNamedColors(t *testing.T, store RemoveNamedColorsStore) {
 	var first, second, firstResult, secondResult ops.NamedColors
 	createNamedColors(t, store, &first, &second)
@@ -162,6 +534,442 @@ func RemoveNamedColors(t *testing.T, store RemoveNamedColorsStore) {
 	assertNCEqual(t, &second, &secondResult)
 }
 
+// Contexts tests the Ctx adapters in the huedb package with store. If
+// store implements the Ctx interfaces natively, Contexts tests those
+// instead.
+func Contexts(t *testing.T, store ContextStore) {
+	ctx := context.Background()
+	first := *kFirstNamedColor
+	if err := huedb.AddNamedColorsCtx(store).AddNamedColorsCtx(
+		ctx, nil, &first); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	var firstResult ops.NamedColors
+	if err := huedb.NamedColorsByIdCtx(store).NamedColorsByIdCtx(
+		ctx, nil, first.Id, &firstResult); err != nil {
+		t.Fatalf("Got error reading by id: %v", err)
+	}
+	assertNCEqual(t, &first, &firstResult)
+	first.Description = "Baz"
+	if err := huedb.UpdateNamedColorsCtx(store).UpdateNamedColorsCtx(
+		ctx, nil, &first); err != nil {
+		t.Fatalf("Got error updating: %v", err)
+	}
+	var all []*ops.NamedColors
+	if err := huedb.NamedColorsCtx(store).NamedColorsCtx(
+		ctx, nil, consume.AppendPtrsTo(&all)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if len(all) != 1 || all[0].Description != "Baz" {
+		t.Errorf("Expected only Baz, got %v", all)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	second := *kSecondNamedColor
+	if err := huedb.AddNamedColorsCtx(store).AddNamedColorsCtx(
+		canceled, nil, &second); err != context.Canceled {
+		t.Errorf("Expected context.Canceled adding, got %v", err)
+	}
+	if err := huedb.NamedColorsByIdCtx(store).NamedColorsByIdCtx(
+		canceled, nil, first.Id, &firstResult); err != context.Canceled {
+		t.Errorf("Expected context.Canceled reading by id, got %v", err)
+	}
+	updated := first
+	updated.Description = "Canceled"
+	if err := huedb.UpdateNamedColorsCtx(store).UpdateNamedColorsCtx(
+		canceled, nil, &updated); err != context.Canceled {
+		t.Errorf("Expected context.Canceled updating, got %v", err)
+	}
+	if err := huedb.RemoveNamedColorsCtx(store).RemoveNamedColorsCtx(
+		canceled, nil, first.Id); err != context.Canceled {
+		t.Errorf("Expected context.Canceled removing, got %v", err)
+	}
+	if err := huedb.NamedColorsCtx(store).NamedColorsCtx(
+		canceled,
+		nil,
+		consume.AppendPtrsTo(&all)); err != context.Canceled {
+		t.Errorf("Expected context.Canceled reading, got %v", err)
+	}
+
+	// Nothing changes when ctx is done
+	assertNamedColors(t, store, &first)
+}
+
+func ArchiveNamedColors(t *testing.T, store ArchiveNamedColorsStore) {
+	var first, second, firstResult ops.NamedColors
+	createNamedColors(t, store, &first, &second)
+	if err := store.ArchiveNamedColors(nil, first.Id); err != nil {
+		t.Fatalf("Got error archiving: %v", err)
+	}
+	assertNamedColors(t, store, &second)
+	assertArchivedNamedColors(t, store, &first)
+	assertNamedColorsByDescription(t, store, "", &second)
+	if withOptions, ok := store.(huedb.NamedColorsWithOptionsRunner); ok {
+		assertNamedColorsWithOptions(
+			t, withOptions, &huedb.NamedColorsOptions{}, &second)
+	}
+
+	// Archived named colors can still be fetched by id
+	if err := store.NamedColorsById(nil, first.Id, &firstResult); err != nil {
+		t.Errorf("Got error reading database by id: %v", err)
+	}
+	assertNCEqual(t, &first, &firstResult)
+
+	if err := store.RestoreNamedColors(nil, first.Id); err != nil {
+		t.Fatalf("Got error restoring: %v", err)
+	}
+	assertNamedColors(t, store, &first, &second)
+	assertArchivedNamedColors(t, store)
+}
+
+func ModifiedSince(t *testing.T, store ModifiedSinceStore) {
+	var first, second ops.NamedColors
+	createNamedColors(t, store, &first, &second)
+	if !first.CreatedAt.IsZero() || !first.UpdatedAt.IsZero() {
+		t.Errorf("Expected add to leave timestamps alone, got %v", &first)
+	}
+	var fetched ops.NamedColors
+	if err := store.NamedColorsById(nil, first.Id, &fetched); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if fetched.CreatedAt.IsZero() || !fetched.CreatedAt.Equal(fetched.UpdatedAt) {
+		t.Errorf("Expected timestamps to be set, got %v", &fetched)
+	}
+	createdAt := fetched.CreatedAt
+	if err := store.UpdateNamedColors(nil, &first); err != nil {
+		t.Fatalf("Got error updating: %v", err)
+	}
+	if !first.UpdatedAt.IsZero() {
+		t.Errorf("Expected update to leave timestamps alone, got %v", &first)
+	}
+	if err := store.NamedColorsById(nil, first.Id, &fetched); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if !fetched.CreatedAt.Equal(createdAt) {
+		t.Errorf("Expected created at %v, got %v", createdAt, fetched.CreatedAt)
+	}
+	if fetched.UpdatedAt.Before(createdAt) {
+		t.Errorf("Expected updated at to advance, got %v", fetched.UpdatedAt)
+	}
+	var results []*ops.NamedColors
+	if err := store.NamedColorsModifiedSince(
+		nil, createdAt, consume.AppendPtrsTo(&results)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	results = nil
+	if err := store.NamedColorsModifiedSince(
+		nil,
+		fetched.UpdatedAt.Add(time.Hour),
+		consume.AppendPtrsTo(&results)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Expected no results, got %v", results)
+	}
+
+	firstTask := &huedb.EncodedAtTimeTask{GroupId: "g", ScheduleId: "1"}
+	secondTask := &huedb.EncodedAtTimeTask{GroupId: "g", ScheduleId: "2"}
+	otherTask := &huedb.EncodedAtTimeTask{GroupId: "h", ScheduleId: "3"}
+	for _, task := range []*huedb.EncodedAtTimeTask{
+		firstTask, secondTask, otherTask} {
+		if err := store.AddEncodedAtTimeTask(nil, task); err != nil {
+			t.Fatalf("Got error adding: %v", err)
+		}
+		if !task.CreatedAt.IsZero() || !task.UpdatedAt.IsZero() {
+			t.Errorf("Expected add to leave timestamps alone, got %v", task)
+		}
+	}
+	var tasks []*huedb.EncodedAtTimeTask
+	if err := store.EncodedAtTimeTasks(
+		nil, "g", consume.AppendPtrsTo(&tasks)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	for _, task := range tasks {
+		if task.CreatedAt.IsZero() || !task.CreatedAt.Equal(task.UpdatedAt) {
+			t.Errorf("Expected timestamps to be set, got %v", task)
+		}
+	}
+	since := tasks[0].CreatedAt
+	tasks = nil
+	if err := store.EncodedAtTimeTasksModifiedSince(
+		nil, "g", since, consume.AppendPtrsTo(&tasks)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if len(tasks) != 2 || tasks[0].Id != secondTask.Id || tasks[1].Id != firstTask.Id {
+		t.Errorf("Expected %v, got %v", []*huedb.EncodedAtTimeTask{secondTask, firstTask}, tasks)
+	}
+	tasks = nil
+	if err := store.EncodedAtTimeTasksModifiedSince(
+		nil,
+		"g",
+		since.Add(time.Hour),
+		consume.AppendPtrsTo(&tasks)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if len(tasks) != 0 {
+		t.Errorf("Expected no tasks, got %v", tasks)
+	}
+}
+
+// EncodedAtTimeTasks tests group isolation, ordering, and remove semantics
+// of an EncodedAtTimeTaskStore.
+func EncodedAtTimeTasks(t *testing.T, store huedb.EncodedAtTimeTaskStore) {
+	assertAtTimeTasks(t, store, "g")
+	first := &huedb.EncodedAtTimeTask{
+		GroupId:      "g",
+		ScheduleId:   "1:2:All",
+		HueTaskId:    1,
+		Action:       "a",
+		Description:  "b",
+		LightSet:     "All",
+		Time:         2,
+		EndTime:      9,
+		RestoreAtEnd: true,
+	}
+	other := &huedb.EncodedAtTimeTask{
+		GroupId:     "h",
+		ScheduleId:  "1:2:All",
+		HueTaskId:   1,
+		Description: "b",
+		LightSet:    "All",
+		Time:        2,
+	}
+	second := &huedb.EncodedAtTimeTask{
+		GroupId:     "g",
+		ScheduleId:  "3:4:1,2",
+		HueTaskId:   3,
+		Action:      "c",
+		Description: "d",
+		LightSet:    "1,2",
+		Time:        4,
+	}
+	third := &huedb.EncodedAtTimeTask{
+		GroupId:     "g",
+		ScheduleId:  "5:6:All",
+		HueTaskId:   5,
+		Description: "e",
+		LightSet:    "All",
+		Time:        1,
+	}
+	for _, task := range []*huedb.EncodedAtTimeTask{
+		first, other, second, third} {
+		if err := store.AddEncodedAtTimeTask(nil, task); err != nil {
+			t.Fatalf("Got error adding: %v", err)
+		}
+	}
+	if first.Id == 0 || first.Id == other.Id || first.Id == second.Id {
+		t.Errorf("Expected unique ids, got %d, %d, %d",
+			first.Id, other.Id, second.Id)
+	}
+
+	// Tasks come back in the order they were added, not by time.
+	assertAtTimeTasks(t, store, "g", first, second, third)
+	assertAtTimeTasks(t, store, "h", other)
+	assertAtTimeTasks(t, store, "i")
+
+	var firstOnly []*huedb.EncodedAtTimeTask
+	if err := store.EncodedAtTimeTasks(
+		nil,
+		"g",
+		consume.Slice(consume.AppendPtrsTo(&firstOnly), 0, 1)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	firstOnly[0].CreatedAt = time.Time{}
+	firstOnly[0].UpdatedAt = time.Time{}
+	if !reflect.DeepEqual([]*huedb.EncodedAtTimeTask{first}, firstOnly) {
+		t.Errorf("Expected %v, got %v", first, firstOnly)
+	}
+
+	// Removing affects only the given group.
+	if err := store.RemoveEncodedAtTimeTaskByScheduleId(
+		nil, "g", "1:2:All"); err != nil {
+		t.Fatalf("Got error removing: %v", err)
+	}
+	assertAtTimeTasks(t, store, "g", second, third)
+	assertAtTimeTasks(t, store, "h", other)
+
+	// Removing a task that isn't there is not an error.
+	if err := store.RemoveEncodedAtTimeTaskByScheduleId(
+		nil, "g", "1:2:All"); err != nil {
+		t.Errorf("Got error removing again: %v", err)
+	}
+	if err := store.RemoveEncodedAtTimeTaskByScheduleId(
+		nil, "i", "3:4:1,2"); err != nil {
+		t.Errorf("Got error removing from empty group: %v", err)
+	}
+	assertAtTimeTasks(t, store, "g", second, third)
+
+	// Ids are never reused.
+	fourth := &huedb.EncodedAtTimeTask{GroupId: "g", ScheduleId: "1:2:All"}
+	if err := store.AddEncodedAtTimeTask(nil, fourth); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	if fourth.Id <= third.Id {
+		t.Errorf("Expected id greater than %d, got %d", third.Id, fourth.Id)
+	}
+	assertAtTimeTasks(t, store, "g", second, third, fourth)
+}
+
+func TaskRuns(t *testing.T, store TaskRunStore) {
+	day := time.Date(2026, 3, 14, 0, 0, 0, 0, time.Local)
+	runs := []*huedb.TaskRun{
+		{
+			HueTaskId:   3,
+			Description: "Old",
+			Start:       day.Add(-time.Hour),
+			Duration:    time.Minute,
+		},
+		{
+			HueTaskId:   5,
+			Description: "Five",
+			Start:       day.Add(time.Hour),
+			Duration:    time.Second,
+		},
+		{
+			HueTaskId:   3,
+			Description: "Three",
+			Start:       day.Add(2 * time.Hour),
+			Duration:    2 * time.Second,
+		},
+		{
+			HueTaskId:   3,
+			Description: "Three Renamed",
+			Start:       day.Add(25 * time.Hour),
+			Duration:    4 * time.Second,
+		},
+		{
+			HueTaskId:   4,
+			Description: "Four",
+			Start:       day.Add(26 * time.Hour),
+			Duration:    1500 * time.Millisecond,
+		},
+	}
+	for _, run := range runs {
+		if err := store.AddTaskRun(nil, run); err != nil {
+			t.Fatalf("Got error adding: %v", err)
+		}
+	}
+	if runs[0].Id == 0 || runs[0].Id == runs[1].Id {
+		t.Errorf("Expected unique ids, got %d, %d", runs[0].Id, runs[1].Id)
+	}
+	var last huedb.TaskRun
+	if err := store.LastTaskRun(nil, 3, &last); err != nil {
+		t.Fatalf("Got error reading last run: %v", err)
+	}
+	if last.Id != runs[3].Id || !last.Start.Equal(runs[3].Start) || last.Description != "Three Renamed" {
+		t.Errorf("Expected %v, got %v", runs[3], &last)
+	}
+	if err := store.LastTaskRun(nil, 6, &last); err != huedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	var stats []*huedb.TaskStats
+	if err := store.TaskStats(
+		nil, day, consume.AppendPtrsTo(&stats)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	expectedStats := []*huedb.TaskStats{
+		{
+			HueTaskId:       3,
+			Description:     "Three Renamed",
+			Runs:            2,
+			AverageDuration: 3 * time.Second,
+			LastRun:         day.Add(25 * time.Hour),
+		},
+		{
+			HueTaskId:       4,
+			Description:     "Four",
+			Runs:            1,
+			AverageDuration: 1500 * time.Millisecond,
+			LastRun:         day.Add(26 * time.Hour),
+		},
+		{
+			HueTaskId:       5,
+			Description:     "Five",
+			Runs:            1,
+			AverageDuration: time.Second,
+			LastRun:         day.Add(time.Hour),
+		},
+	}
+	if len(stats) != len(expectedStats) {
+		t.Fatalf("Expected %v, got %v", expectedStats, stats)
+	}
+	for i := range expectedStats {
+		// Stores may give back times in a different location.
+		if stats[i].LastRun.Equal(expectedStats[i].LastRun) {
+			stats[i].LastRun = expectedStats[i].LastRun
+		}
+		if !reflect.DeepEqual(expectedStats[i], stats[i]) {
+			t.Errorf("Expected %v, got %v", expectedStats[i], stats[i])
+		}
+	}
+	var daily []*huedb.DailyRuns
+	if err := store.TaskRunsPerDay(
+		nil, day.Add(-24*time.Hour), consume.AppendPtrsTo(&daily)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	expectedDaily := []*huedb.DailyRuns{
+		{Day: day.AddDate(0, 0, -1), Runs: 1},
+		{Day: day, Runs: 2},
+		{Day: day.AddDate(0, 0, 1), Runs: 2},
+	}
+	assertDailyRuns(t, expectedDaily, daily)
+	daily = nil
+	if err := store.TaskRunsPerDay(
+		nil, day.Add(48*time.Hour), consume.AppendPtrsTo(&daily)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if len(daily) != 0 {
+		t.Errorf("Expected no days, got %v", daily)
+	}
+}
+
+// TaskRunsPerDayLocal tests that stores group task runs into days by
+// local time rather than by UTC. TaskRunsPerDayLocal temporarily changes
+// time.Local, so store must be empty and tests must not run in parallel.
+func TaskRunsPerDayLocal(t *testing.T, store TaskRunStore) {
+	oldLocal := time.Local
+	time.Local = time.FixedZone("UTC-8", -8*60*60)
+	defer func() { time.Local = oldLocal }()
+	day := time.Date(2026, 3, 14, 0, 0, 0, 0, time.Local)
+	// Both runs fall on March 15 UTC.
+	runs := []*huedb.TaskRun{
+		{HueTaskId: 3, Description: "Late", Start: day.Add(23 * time.Hour)},
+		{HueTaskId: 3, Description: "Early", Start: day.Add(25 * time.Hour)},
+	}
+	for _, run := range runs {
+		if err := store.AddTaskRun(nil, run); err != nil {
+			t.Fatalf("Got error adding: %v", err)
+		}
+	}
+	var daily []*huedb.DailyRuns
+	if err := store.TaskRunsPerDay(
+		nil, day, consume.AppendPtrsTo(&daily)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	expectedDaily := []*huedb.DailyRuns{
+		{Day: day, Runs: 1},
+		{Day: day.AddDate(0, 0, 1), Runs: 1},
+	}
+	assertDailyRuns(t, expectedDaily, daily)
+}
+
+func assertDailyRuns(
+	t *testing.T, expected, actual []*huedb.DailyRuns) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		if !actual[i].Day.Equal(expected[i].Day) || actual[i].Runs != expected[i].Runs {
+			t.Errorf("Expected %v, got %v", expected[i], actual[i])
+		}
+	}
+}
+
 func createNamedColors(
 	t *testing.T,
 	store MinimalStore,
@@ -185,8 +993,211 @@ func createNamedColor(
 	}
 }
 
-func assertNCEqual(t *testing.T, expected, actual *ops.NamedColors) {
+func assertScene(
+	t *testing.T, store huedb.SceneByIdRunner, expected *huedb.Scene) {
+	var actual huedb.Scene
+	if err := store.SceneById(nil, expected.Id, &actual); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if !reflect.DeepEqual(expected, &actual) {
+		t.Errorf("Expected %v, got %v", expected, &actual)
+	}
+}
+
+func assertScenes(
+	t *testing.T, store huedb.ScenesRunner, expected ...*huedb.Scene) {
+	var actual []*huedb.Scene
+	if err := store.Scenes(nil, consume.AppendPtrsTo(&actual)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func assertLastFired(
+	t *testing.T, store huedb.LastFiredRunner, expected *huedb.LastFired) {
+	var actual huedb.LastFired
+	if err := store.LastFired(
+		nil, expected.ScheduledTaskId, &actual); err != nil {
+		t.Fatalf("Got error reading last fired: %v", err)
+	}
+	if !actual.Time.Equal(expected.Time) ||
+		actual.ScheduledTaskId != expected.ScheduledTaskId {
+		t.Errorf("Expected %v, got %v", expected, &actual)
+	}
+}
+
+func assertDescriptionOverride(
+	t *testing.T,
+	store huedb.DescriptionOverrideRunner,
+	expected *huedb.DescriptionOverride) {
+	var actual huedb.DescriptionOverride
+	if err := store.DescriptionOverride(
+		nil, expected.HueTaskId, &actual); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if !reflect.DeepEqual(expected, &actual) {
+		t.Errorf("Expected %v, got %v", expected, &actual)
+	}
+}
+
+func assertDescriptionOverrides(
+	t *testing.T,
+	store huedb.DescriptionOverridesRunner,
+	expected ...*huedb.DescriptionOverride) {
+	var actual []*huedb.DescriptionOverride
+	if err := store.DescriptionOverrides(
+		nil, consume.AppendPtrsTo(&actual)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func assertLastParams(
+	t *testing.T, store huedb.LastParamsRunner, expected *huedb.LastParams) {
+	var actual huedb.LastParams
+	if err := store.LastParams(nil, expected.HueTaskId, &actual); err != nil {
+		t.Errorf("Got error reading last params: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(expected, &actual) {
+		t.Errorf("Expected %v, got %v", expected, &actual)
+	}
+}
+
+func assertNamedColorsByDescription(
+	t *testing.T,
+	store huedb.NamedColorsByDescriptionRunner,
+	query string,
+	expected ...*ops.NamedColors) {
+	var results []ops.NamedColors
+	if err := store.NamedColorsByDescription(
+		nil, query, consume.AppendTo(&results)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("%s: Expected %d results, got %d", query, len(expected), len(results))
+	}
+	for i := range expected {
+		assertNCEqual(t, expected[i], &results[i])
+	}
+}
+
+func assertNamedColorsByExactDescription(
+	t *testing.T,
+	store huedb.NamedColorsByExactDescriptionRunner,
+	description string,
+	expected ...*ops.NamedColors) {
+	var results []ops.NamedColors
+	if err := store.NamedColorsByExactDescription(
+		nil, description, consume.AppendTo(&results)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("%s: Expected %d results, got %d", description, len(expected), len(results))
+	}
+	for i := range expected {
+		assertNCEqual(t, expected[i], &results[i])
+	}
+}
+
+func assertNamedColorsWithOptions(
+	t *testing.T,
+	store huedb.NamedColorsWithOptionsRunner,
+	options *huedb.NamedColorsOptions,
+	expected ...*ops.NamedColors) {
+	var results []ops.NamedColors
+	if err := store.NamedColorsWithOptions(
+		nil, options, consume.AppendTo(&results)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("%+v: Expected %d results, got %d", *options, len(expected), len(results))
+	}
+	for i := range expected {
+		assertNCEqual(t, expected[i], &results[i])
+	}
+}
+
+func assertAtTimeTasks(
+	t *testing.T,
+	store huedb.EncodedAtTimeTaskStore,
+	groupId string,
+	expected ...*huedb.EncodedAtTimeTask) {
+	var actual []*huedb.EncodedAtTimeTask
+	if err := store.EncodedAtTimeTasks(
+		nil, groupId, consume.AppendPtrsTo(&actual)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	if len(expected) == 0 {
+		expected = nil
+	}
+	// Stores set timestamps on what they store, not on what they add.
+	for _, task := range actual {
+		task.CreatedAt = time.Time{}
+		task.UpdatedAt = time.Time{}
+	}
 	if !reflect.DeepEqual(expected, actual) {
 		t.Errorf("Expected %v, got %v", expected, actual)
 	}
 }
+
+func assertScheduledTasks(
+	t *testing.T,
+	store huedb.EncodedScheduledTasksRunner,
+	expected ...*huedb.EncodedScheduledTask) {
+	var actual []*huedb.EncodedScheduledTask
+	if err := store.EncodedScheduledTasks(
+		nil, consume.AppendPtrsTo(&actual)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func assertNamedColors(
+	t *testing.T,
+	store huedb.NamedColorsRunner,
+	expected ...*ops.NamedColors) {
+	var results []ops.NamedColors
+	if err := store.NamedColors(nil, consume.AppendTo(&results)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	assertNCsEqual(t, expected, results)
+}
+
+func assertArchivedNamedColors(
+	t *testing.T,
+	store huedb.ArchivedNamedColorsRunner,
+	expected ...*ops.NamedColors) {
+	var results []ops.NamedColors
+	if err := store.ArchivedNamedColors(
+		nil, consume.AppendTo(&results)); err != nil {
+		t.Fatalf("Got error reading database: %v", err)
+	}
+	assertNCsEqual(t, expected, results)
+}
+
+func assertNCsEqual(
+	t *testing.T, expected []*ops.NamedColors, actual []ops.NamedColors) {
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %d results, got %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		assertNCEqual(t, expected[i], &actual[i])
+	}
+}
+
+func assertNCEqual(t *testing.T, expected, actual *ops.NamedColors) {
+	// Stores set timestamps on what they store, not on what they add.
+	withoutTimestamps := *actual
+	withoutTimestamps.CreatedAt = expected.CreatedAt
+	withoutTimestamps.UpdatedAt = expected.UpdatedAt
+	if !reflect.DeepEqual(expected, &withoutTimestamps) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
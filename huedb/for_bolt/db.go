@@ -0,0 +1,457 @@
+// Package for_bolt provides a bbolt implementation of interfaces in
+// huedb package. Unlike for_sqlite, for_bolt needs no cgo which makes
+// cross compiling for small devices such as the Raspberry Pi easy.
+package for_bolt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/keep94/consume"
+	"github.com/keep94/marvin2/huedb"
+	"github.com/keep94/marvin2/huedb/internal/columns"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/toolbox/db"
+	"go.etcd.io/bbolt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	kNamedColorsBucket = []byte("named_colors")
+	kAtTimeTasksBucket = []byte("at_time_tasks")
+	kAllBuckets        = [][]byte{kNamedColorsBucket, kAtTimeTasksBucket}
+)
+
+// Store implements the NamedColors and EncodedAtTimeTask interfaces
+// in the huedb package. Transactions passed to the methods of Store are
+// *bbolt.Tx instances such as the ones that the db.Doer that NewDoer
+// returns creates.
+type Store struct {
+	db *bbolt.DB
+}
+
+// New returns a new Store that stores in db. New creates any buckets
+// that db is missing.
+func New(db *bbolt.DB) (Store, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range kAllBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Store{}, err
+	}
+	return Store{db}, nil
+}
+
+// NewDoer returns a db.Doer that runs each action within a single
+// read-write transaction of db.
+func NewDoer(db *bbolt.DB) db.Doer {
+	return doer{db}
+}
+
+func (s Store) NamedColorsById(
+	t db.Transaction, id int64, namedColors *ops.NamedColors) error {
+	return s.view(t, func(tx *bbolt.Tx) error {
+		var stored storedNamedColors
+		ok, err := getNamedColors(tx, id, &stored)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return huedb.ErrNoSuchId
+		}
+		return stored.get(id, namedColors)
+	})
+}
+
+func (s Store) NamedColors(
+	t db.Transaction, consumer consume.Consumer) error {
+	return s.namedColors(t, false, consumer, func(*ops.NamedColors) bool {
+		return true
+	})
+}
+
+func (s Store) NamedColorsByDescription(
+	t db.Transaction, query string, consumer consume.Consumer) error {
+	query = strings.ToLower(query)
+	return s.namedColors(t, false, consumer, func(nc *ops.NamedColors) bool {
+		return strings.Contains(strings.ToLower(nc.Description), query)
+	})
+}
+
+func (s Store) AddNamedColors(
+	t db.Transaction, namedColors *ops.NamedColors) error {
+	return s.update(t, func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(kNamedColorsBucket)
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		stored := storedNamedColors{Version: 1}
+		if err := stored.set(namedColors); err != nil {
+			return err
+		}
+		stored.CreatedAt = time.Now()
+		stored.UpdatedAt = stored.CreatedAt
+		if err := putNamedColors(tx, int64(id), &stored); err != nil {
+			return err
+		}
+		namedColors.Id = int64(id)
+		namedColors.Version = stored.Version
+		return nil
+	})
+}
+
+func (s Store) UpdateNamedColors(
+	t db.Transaction, namedColors *ops.NamedColors) error {
+	return s.update(t, func(tx *bbolt.Tx) error {
+		var stored storedNamedColors
+		ok, err := getNamedColors(tx, namedColors.Id, &stored)
+		if err != nil || !ok {
+			return err
+		}
+		if namedColors.Version != 0 && stored.Version != namedColors.Version {
+			return huedb.ErrConcurrentModification
+		}
+		if err := stored.set(namedColors); err != nil {
+			return err
+		}
+		stored.UpdatedAt = time.Now()
+		stored.Version++
+		if err := putNamedColors(tx, namedColors.Id, &stored); err != nil {
+			return err
+		}
+		namedColors.Version = stored.Version
+		return nil
+	})
+}
+
+func (s Store) ArchiveNamedColors(t db.Transaction, id int64) error {
+	return s.setArchived(t, id, true)
+}
+
+func (s Store) RestoreNamedColors(t db.Transaction, id int64) error {
+	return s.setArchived(t, id, false)
+}
+
+func (s Store) ArchivedNamedColors(
+	t db.Transaction, consumer consume.Consumer) error {
+	return s.namedColors(t, true, consumer, func(*ops.NamedColors) bool {
+		return true
+	})
+}
+
+func (s Store) NamedColorsModifiedSince(
+	t db.Transaction, since time.Time, consumer consume.Consumer) error {
+	var matches []*ops.NamedColors
+	err := s.namedColors(
+		t,
+		false,
+		consume.AppendPtrsTo(&matches),
+		func(nc *ops.NamedColors) bool {
+			return !nc.UpdatedAt.Before(since)
+		})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if !matches[i].UpdatedAt.Equal(matches[j].UpdatedAt) {
+			return matches[i].UpdatedAt.After(matches[j].UpdatedAt)
+		}
+		return matches[i].Id > matches[j].Id
+	})
+	for _, match := range matches {
+		if !consumer.CanConsume() {
+			break
+		}
+		consumer.Consume(match)
+	}
+	return nil
+}
+
+func (s Store) RemoveNamedColors(t db.Transaction, id int64) error {
+	return s.update(t, func(tx *bbolt.Tx) error {
+		return tx.Bucket(kNamedColorsBucket).Delete(itob(id))
+	})
+}
+
+func (s Store) EncodedAtTimeTasks(
+	t db.Transaction, groupId string, consumer consume.Consumer) error {
+	return s.view(t, func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(kAtTimeTasksBucket).Cursor()
+		for k, v := cursor.First(); k != nil && consumer.CanConsume(); k, v = cursor.Next() {
+			var task huedb.EncodedAtTimeTask
+			if err := json.Unmarshal(v, &task); err != nil {
+				return err
+			}
+			if task.GroupId != groupId {
+				continue
+			}
+			task.Id = btoi(k)
+			consumer.Consume(&task)
+		}
+		return nil
+	})
+}
+
+func (s Store) EncodedAtTimeTasksModifiedSince(
+	t db.Transaction,
+	groupId string,
+	since time.Time,
+	consumer consume.Consumer) error {
+	var inGroup []*huedb.EncodedAtTimeTask
+	err := s.EncodedAtTimeTasks(t, groupId, consume.AppendPtrsTo(&inGroup))
+	if err != nil {
+		return err
+	}
+	var matches []*huedb.EncodedAtTimeTask
+	for _, task := range inGroup {
+		if !task.UpdatedAt.Before(since) {
+			matches = append(matches, task)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if !matches[i].UpdatedAt.Equal(matches[j].UpdatedAt) {
+			return matches[i].UpdatedAt.After(matches[j].UpdatedAt)
+		}
+		return matches[i].Id > matches[j].Id
+	})
+	for _, match := range matches {
+		if !consumer.CanConsume() {
+			break
+		}
+		consumer.Consume(match)
+	}
+	return nil
+}
+
+func (s Store) AddEncodedAtTimeTask(
+	t db.Transaction, task *huedb.EncodedAtTimeTask) error {
+	return s.update(t, func(tx *bbolt.Tx) error {
+		return addAtTimeTask(tx, task, time.Now())
+	})
+}
+
+func (s Store) AddEncodedAtTimeTasks(
+	t db.Transaction, tasks []*huedb.EncodedAtTimeTask) error {
+	return s.update(t, func(tx *bbolt.Tx) error {
+		createdAt := time.Now()
+		for _, task := range tasks {
+			if err := addAtTimeTask(tx, task, createdAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s Store) RemoveEncodedAtTimeTaskByScheduleId(
+	t db.Transaction, groupId, scheduleId string) error {
+	return s.removeAtTimeTasks(t, func(task *huedb.EncodedAtTimeTask) bool {
+		return task.GroupId == groupId && task.ScheduleId == scheduleId
+	})
+}
+
+func (s Store) RemoveEncodedAtTimeTasksBefore(
+	t db.Transaction, before int64) error {
+	return s.removeAtTimeTasks(t, func(task *huedb.EncodedAtTimeTask) bool {
+		return task.EndedBefore(before)
+	})
+}
+
+func (s Store) ClearEncodedAtTimeTasks(t db.Transaction) error {
+	return s.update(t, func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket(kAtTimeTasksBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(kAtTimeTasksBucket)
+		return err
+	})
+}
+
+func (s Store) removeAtTimeTasks(
+	t db.Transaction, matches func(task *huedb.EncodedAtTimeTask) bool) error {
+	return s.update(t, func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(kAtTimeTasksBucket)
+		var toRemove [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var task huedb.EncodedAtTimeTask
+			if err := json.Unmarshal(v, &task); err != nil {
+				return err
+			}
+			if matches(&task) {
+				toRemove = append(toRemove, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range toRemove {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s Store) setArchived(t db.Transaction, id int64, archived bool) error {
+	return s.update(t, func(tx *bbolt.Tx) error {
+		var stored storedNamedColors
+		ok, err := getNamedColors(tx, id, &stored)
+		if err != nil || !ok {
+			return err
+		}
+		stored.Archived = archived
+		return putNamedColors(tx, id, &stored)
+	})
+}
+
+// namedColors consumes the named colors that include accepts among
+// either the archived or the unarchived named colors.
+func (s Store) namedColors(
+	t db.Transaction,
+	archived bool,
+	consumer consume.Consumer,
+	include func(nc *ops.NamedColors) bool) error {
+	return s.view(t, func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(kNamedColorsBucket).Cursor()
+		for k, v := cursor.First(); k != nil && consumer.CanConsume(); k, v = cursor.Next() {
+			var stored storedNamedColors
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return err
+			}
+			if stored.Archived != archived {
+				continue
+			}
+			var namedColors ops.NamedColors
+			if err := stored.get(btoi(k), &namedColors); err != nil {
+				return err
+			}
+			if include(&namedColors) {
+				consumer.Consume(&namedColors)
+			}
+		}
+		return nil
+	})
+}
+
+func (s Store) view(t db.Transaction, f func(tx *bbolt.Tx) error) error {
+	if t != nil {
+		return f(t.(*bbolt.Tx))
+	}
+	return s.db.View(f)
+}
+
+func (s Store) update(t db.Transaction, f func(tx *bbolt.Tx) error) error {
+	if t != nil {
+		return f(t.(*bbolt.Tx))
+	}
+	return s.db.Update(f)
+}
+
+// addAtTimeTask adds task as created at createdAt and sets its Id.
+func addAtTimeTask(
+	tx *bbolt.Tx, task *huedb.EncodedAtTimeTask, createdAt time.Time) error {
+	bucket := tx.Bucket(kAtTimeTasksBucket)
+	id, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	stored := *task
+	stored.CreatedAt = createdAt
+	stored.UpdatedAt = createdAt
+	value, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put(itob(int64(id)), value); err != nil {
+		return err
+	}
+	task.Id = int64(id)
+	return nil
+}
+
+type doer struct {
+	db *bbolt.DB
+}
+
+func (d doer) Do(action db.Action) error {
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		return action(tx)
+	})
+}
+
+// storedNamedColors is how ops.NamedColors are stored in bbolt.
+// The Id is the key.
+type storedNamedColors struct {
+	Description string    `json:"description"`
+	Colors      string    `json:"colors"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int64     `json:"version"`
+	Archived    bool      `json:"archived,omitempty"`
+}
+
+func (s *storedNamedColors) set(namedColors *ops.NamedColors) error {
+	colors, err := columns.EncodeLightColors(namedColors.Colors)
+	if err != nil {
+		return err
+	}
+	s.Description = namedColors.Description
+	s.Colors = colors
+	return nil
+}
+
+func (s *storedNamedColors) get(
+	id int64, namedColors *ops.NamedColors) error {
+	colors, err := columns.DecodeLightColors(s.Colors)
+	if err != nil {
+		return err
+	}
+	*namedColors = ops.NamedColors{
+		Id:          id,
+		Colors:      colors,
+		Description: s.Description,
+		CreatedAt:   s.CreatedAt,
+		UpdatedAt:   s.UpdatedAt,
+		Version:     s.Version,
+	}
+	return nil
+}
+
+// getNamedColors reads the named colors with given id into stored.
+// getNamedColors returns false if there are no such named colors.
+func getNamedColors(
+	tx *bbolt.Tx, id int64, stored *storedNamedColors) (bool, error) {
+	value := tx.Bucket(kNamedColorsBucket).Get(itob(id))
+	if value == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(value, stored)
+}
+
+func putNamedColors(tx *bbolt.Tx, id int64, stored *storedNamedColors) error {
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(kNamedColorsBucket).Put(itob(id), value)
+}
+
+// itob converts an id to a key that sorts in id order.
+func itob(id int64) []byte {
+	result := make([]byte, 8)
+	binary.BigEndian.PutUint64(result, uint64(id))
+	return result
+}
+
+func btoi(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key))
+}
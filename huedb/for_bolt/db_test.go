@@ -0,0 +1,176 @@
+package for_bolt_test
+
+import (
+	"github.com/keep94/consume"
+	"github.com/keep94/marvin2/huedb"
+	"github.com/keep94/marvin2/huedb/fixture"
+	"github.com/keep94/marvin2/huedb/for_bolt"
+	"github.com/keep94/toolbox/db"
+	"go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNamedColorsById(t *testing.T) {
+	bdb, dir := openDb(t)
+	defer closeDb(t, bdb, dir)
+	fixture.NamedColorsById(t, newStore(t, bdb))
+}
+
+func TestNamedColors(t *testing.T) {
+	bdb, dir := openDb(t)
+	defer closeDb(t, bdb, dir)
+	fixture.NamedColors(t, newStore(t, bdb))
+}
+
+func TestNamedColorsByDescription(t *testing.T) {
+	bdb, dir := openDb(t)
+	defer closeDb(t, bdb, dir)
+	fixture.NamedColorsByDescription(t, newStore(t, bdb))
+}
+
+func TestUpdateNamedColors(t *testing.T) {
+	bdb, dir := openDb(t)
+	defer closeDb(t, bdb, dir)
+	fixture.UpdateNamedColors(t, newStore(t, bdb))
+}
+
+func TestConcurrentUpdateNamedColors(t *testing.T) {
+	bdb, dir := openDb(t)
+	defer closeDb(t, bdb, dir)
+	fixture.ConcurrentUpdateNamedColors(t, newStore(t, bdb))
+}
+
+func TestRemoveNamedColors(t *testing.T) {
+	bdb, dir := openDb(t)
+	defer closeDb(t, bdb, dir)
+	fixture.RemoveNamedColors(t, newStore(t, bdb))
+}
+
+func TestArchiveNamedColors(t *testing.T) {
+	bdb, dir := openDb(t)
+	defer closeDb(t, bdb, dir)
+	fixture.ArchiveNamedColors(t, newStore(t, bdb))
+}
+
+func TestEncodedAtTimeTasksFixture(t *testing.T) {
+	bdb, dir := openDb(t)
+	defer closeDb(t, bdb, dir)
+	fixture.EncodedAtTimeTasks(t, newStore(t, bdb))
+}
+
+func TestModifiedSince(t *testing.T) {
+	bdb, dir := openDb(t)
+	defer closeDb(t, bdb, dir)
+	fixture.ModifiedSince(t, newStore(t, bdb))
+}
+
+func TestEncodedAtTimeTasks(t *testing.T) {
+	bdb, dir := openDb(t)
+	defer closeDb(t, bdb, dir)
+	store := newStore(t, bdb)
+	first := &huedb.EncodedAtTimeTask{
+		GroupId:      "g",
+		ScheduleId:   "1:2:All",
+		HueTaskId:    1,
+		Action:       "a",
+		Description:  "b",
+		LightSet:     "All",
+		Time:         2,
+		EndTime:      9,
+		RestoreAtEnd: true,
+	}
+	second := &huedb.EncodedAtTimeTask{
+		GroupId:     "g",
+		ScheduleId:  "3:4:All",
+		HueTaskId:   3,
+		Action:      "c",
+		Description: "d",
+		LightSet:    "All",
+		Time:        4,
+	}
+	other := &huedb.EncodedAtTimeTask{
+		GroupId:    "h",
+		ScheduleId: "1:2:All",
+		HueTaskId:  1,
+	}
+	err := for_bolt.NewDoer(bdb).Do(func(t db.Transaction) error {
+		for _, task := range []*huedb.EncodedAtTimeTask{first, second, other} {
+			if err := store.AddEncodedAtTimeTask(t, task); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error adding tasks: %v", err)
+	}
+	assertAtTimeTasks(t, store, "g", first, second)
+	assertAtTimeTasks(t, store, "h", other)
+	if err := store.RemoveEncodedAtTimeTaskByScheduleId(
+		nil, "g", "1:2:All"); err != nil {
+		t.Fatalf("Error removing task: %v", err)
+	}
+	assertAtTimeTasks(t, store, "g", second)
+	assertAtTimeTasks(t, store, "h", other)
+	if err := store.ClearEncodedAtTimeTasks(nil); err != nil {
+		t.Fatalf("Error clearing tasks: %v", err)
+	}
+	assertAtTimeTasks(t, store, "g")
+	assertAtTimeTasks(t, store, "h")
+}
+
+func assertAtTimeTasks(
+	t *testing.T,
+	store for_bolt.Store,
+	groupId string,
+	expected ...*huedb.EncodedAtTimeTask) {
+	t.Helper()
+	var tasks []*huedb.EncodedAtTimeTask
+	if err := store.EncodedAtTimeTasks(
+		nil, groupId, consume.AppendPtrsTo(&tasks)); err != nil {
+		t.Fatalf("Error reading tasks: %v", err)
+	}
+	for _, task := range tasks {
+		task.CreatedAt = time.Time{}
+		task.UpdatedAt = time.Time{}
+	}
+	if len(expected) == 0 {
+		expected = nil
+	}
+	if !reflect.DeepEqual(expected, tasks) {
+		t.Errorf("Expected %v, got %v", expected, tasks)
+	}
+}
+
+func newStore(t *testing.T, db *bbolt.DB) for_bolt.Store {
+	store, err := for_bolt.New(db)
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+	return store
+}
+
+func closeDb(t *testing.T, db *bbolt.DB, dir string) {
+	if err := db.Close(); err != nil {
+		t.Errorf("Error closing database: %v", err)
+	}
+	os.RemoveAll(dir)
+}
+
+func openDb(t *testing.T) (*bbolt.DB, string) {
+	dir, err := ioutil.TempDir("", "for_bolt")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	db, err := bbolt.Open(filepath.Join(dir, "hue.db"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Error opening database: %v", err)
+	}
+	return db, dir
+}
@@ -0,0 +1,719 @@
+// Package for_json provides an implementation of interfaces in huedb
+// package that keeps all tables in a single JSON file. for_json is meant
+// for tiny installations and for human readable backups. Each change
+// rewrites the whole file atomically so that a crash never leaves a
+// partially written file behind.
+package for_json
+
+import (
+	"encoding/json"
+	"github.com/keep94/consume"
+	"github.com/keep94/marvin2/huedb"
+	"github.com/keep94/marvin2/huedb/internal/lightjson"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/toolbox/db"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store implements the interfaces in the huedb package that the
+// for_sqlite Store implements. Transactions passed to the methods of
+// Store must come from the db.Doer that NewDoer returns. Store instances
+// are safe to use with multiple goroutines.
+type Store struct {
+	path string
+	mu   sync.Mutex
+	data *tables
+}
+
+// Open returns a Store that persists to the JSON file at path. If no
+// file exists at path, the Store starts out empty and creates the file on
+// the first change.
+func Open(path string) (*Store, error) {
+	data := &tables{}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(contents, data); err != nil {
+			return nil, err
+		}
+	}
+	return &Store{path: path, data: data}, nil
+}
+
+// NewDoer returns a db.Doer that runs each action within a single
+// transaction of store. If an action fails, none of its changes are kept.
+func NewDoer(store *Store) db.Doer {
+	return doer{store}
+}
+
+func (s *Store) NamedColorsById(
+	t db.Transaction, id int64, namedColors *ops.NamedColors) error {
+	return s.do(t, func(d *tables) error {
+		idx := d.namedColorsIndex(id)
+		if idx == -1 {
+			return huedb.ErrNoSuchId
+		}
+		return d.NamedColors[idx].get(namedColors)
+	})
+}
+
+func (s *Store) NamedColors(
+	t db.Transaction, consumer consume.Consumer) error {
+	return s.namedColors(t, "", false, consumer)
+}
+
+func (s *Store) NamedColorsByDescription(
+	t db.Transaction, query string, consumer consume.Consumer) error {
+	return s.namedColors(t, query, false, consumer)
+}
+
+func (s *Store) NamedColorsWithOptions(
+	t db.Transaction,
+	options *huedb.NamedColorsOptions,
+	consumer consume.Consumer) error {
+	end := math.MaxInt32
+	if options.Limit > 0 {
+		end = options.Offset + options.Limit
+	}
+	consumer = consume.Slice(consumer, options.Offset, end)
+	return s.namedColors(
+		t, options.Description, options.OrderByDescription, consumer)
+}
+
+func (s *Store) AddNamedColors(
+	t db.Transaction, namedColors *ops.NamedColors) error {
+	return s.update(t, func(d *tables) error {
+		var stored jsonNamedColors
+		if err := stored.set(namedColors); err != nil {
+			return err
+		}
+		d.Sequences.NamedColors++
+		stored.Id = d.Sequences.NamedColors
+		stored.CreatedAt = time.Now()
+		stored.UpdatedAt = stored.CreatedAt
+		stored.Version = 1
+		d.NamedColors = append(d.NamedColors, stored)
+		namedColors.Id = stored.Id
+		namedColors.Version = stored.Version
+		return nil
+	})
+}
+
+func (s *Store) UpdateNamedColors(
+	t db.Transaction, namedColors *ops.NamedColors) error {
+	return s.update(t, func(d *tables) error {
+		idx := d.namedColorsIndex(namedColors.Id)
+		if idx == -1 {
+			return nil
+		}
+		stored := d.NamedColors[idx]
+		if namedColors.Version != 0 && stored.Version != namedColors.Version {
+			return huedb.ErrConcurrentModification
+		}
+		if err := stored.set(namedColors); err != nil {
+			return err
+		}
+		stored.UpdatedAt = time.Now()
+		stored.Version++
+		d.NamedColors[idx] = stored
+		namedColors.Version = stored.Version
+		return nil
+	})
+}
+
+func (s *Store) NamedColorsModifiedSince(
+	t db.Transaction, since time.Time, consumer consume.Consumer) error {
+	return s.do(t, func(d *tables) error {
+		var matches []*jsonNamedColors
+		for i := range d.NamedColors {
+			if !d.NamedColors[i].Archived &&
+				!d.NamedColors[i].UpdatedAt.Before(since) {
+				matches = append(matches, &d.NamedColors[i])
+			}
+		}
+		sort.SliceStable(matches, func(i, j int) bool {
+			if !matches[i].UpdatedAt.Equal(matches[j].UpdatedAt) {
+				return matches[i].UpdatedAt.After(matches[j].UpdatedAt)
+			}
+			return matches[i].Id > matches[j].Id
+		})
+		for _, match := range matches {
+			if !consumer.CanConsume() {
+				break
+			}
+			var namedColors ops.NamedColors
+			if err := match.get(&namedColors); err != nil {
+				return err
+			}
+			consumer.Consume(&namedColors)
+		}
+		return nil
+	})
+}
+
+func (s *Store) ArchiveNamedColors(t db.Transaction, id int64) error {
+	return s.setArchived(t, id, true)
+}
+
+func (s *Store) RestoreNamedColors(t db.Transaction, id int64) error {
+	return s.setArchived(t, id, false)
+}
+
+func (s *Store) ArchivedNamedColors(
+	t db.Transaction, consumer consume.Consumer) error {
+	return s.do(t, func(d *tables) error {
+		for i := range d.NamedColors {
+			if !consumer.CanConsume() {
+				break
+			}
+			if !d.NamedColors[i].Archived {
+				continue
+			}
+			var namedColors ops.NamedColors
+			if err := d.NamedColors[i].get(&namedColors); err != nil {
+				return err
+			}
+			consumer.Consume(&namedColors)
+		}
+		return nil
+	})
+}
+
+func (s *Store) RemoveNamedColors(t db.Transaction, id int64) error {
+	return s.update(t, func(d *tables) error {
+		if idx := d.namedColorsIndex(id); idx != -1 {
+			d.NamedColors = append(
+				d.NamedColors[:idx], d.NamedColors[idx+1:]...)
+		}
+		return nil
+	})
+}
+
+func (s *Store) EncodedAtTimeTasks(
+	t db.Transaction, groupId string, consumer consume.Consumer) error {
+	return s.do(t, func(d *tables) error {
+		for i := range d.AtTimeTasks {
+			if !consumer.CanConsume() {
+				break
+			}
+			if d.AtTimeTasks[i].GroupId == groupId {
+				task := d.AtTimeTasks[i]
+				consumer.Consume(&task)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) EncodedAtTimeTasksModifiedSince(
+	t db.Transaction,
+	groupId string,
+	since time.Time,
+	consumer consume.Consumer) error {
+	return s.do(t, func(d *tables) error {
+		var matches []*huedb.EncodedAtTimeTask
+		for i := range d.AtTimeTasks {
+			task := &d.AtTimeTasks[i]
+			if task.GroupId == groupId && !task.UpdatedAt.Before(since) {
+				matches = append(matches, task)
+			}
+		}
+		sort.SliceStable(matches, func(i, j int) bool {
+			if !matches[i].UpdatedAt.Equal(matches[j].UpdatedAt) {
+				return matches[i].UpdatedAt.After(matches[j].UpdatedAt)
+			}
+			return matches[i].Id > matches[j].Id
+		})
+		for _, match := range matches {
+			if !consumer.CanConsume() {
+				break
+			}
+			task := *match
+			consumer.Consume(&task)
+		}
+		return nil
+	})
+}
+
+func (s *Store) AddEncodedAtTimeTask(
+	t db.Transaction, task *huedb.EncodedAtTimeTask) error {
+	return s.update(t, func(d *tables) error {
+		d.addAtTimeTask(task, time.Now())
+		return nil
+	})
+}
+
+func (s *Store) AddEncodedAtTimeTasks(
+	t db.Transaction, tasks []*huedb.EncodedAtTimeTask) error {
+	return s.update(t, func(d *tables) error {
+		createdAt := time.Now()
+		for _, task := range tasks {
+			d.addAtTimeTask(task, createdAt)
+		}
+		return nil
+	})
+}
+
+func (s *Store) RemoveEncodedAtTimeTaskByScheduleId(
+	t db.Transaction, groupId, scheduleId string) error {
+	return s.update(t, func(d *tables) error {
+		var kept []huedb.EncodedAtTimeTask
+		for _, task := range d.AtTimeTasks {
+			if task.GroupId != groupId || task.ScheduleId != scheduleId {
+				kept = append(kept, task)
+			}
+		}
+		d.AtTimeTasks = kept
+		return nil
+	})
+}
+
+func (s *Store) RemoveEncodedAtTimeTasksBefore(
+	t db.Transaction, before int64) error {
+	return s.update(t, func(d *tables) error {
+		var kept []huedb.EncodedAtTimeTask
+		for _, task := range d.AtTimeTasks {
+			if !task.EndedBefore(before) {
+				kept = append(kept, task)
+			}
+		}
+		d.AtTimeTasks = kept
+		return nil
+	})
+}
+
+func (s *Store) ClearEncodedAtTimeTasks(t db.Transaction) error {
+	return s.update(t, func(d *tables) error {
+		d.AtTimeTasks = nil
+		return nil
+	})
+}
+
+func (s *Store) EncodedScheduledTasks(
+	t db.Transaction, consumer consume.Consumer) error {
+	return s.do(t, func(d *tables) error {
+		for i := range d.ScheduledTasks {
+			if !consumer.CanConsume() {
+				break
+			}
+			task := d.ScheduledTasks[i]
+			consumer.Consume(&task)
+		}
+		return nil
+	})
+}
+
+func (s *Store) AddEncodedScheduledTask(
+	t db.Transaction, task *huedb.EncodedScheduledTask) error {
+	return s.update(t, func(d *tables) error {
+		d.Sequences.ScheduledTasks++
+		task.Id = d.Sequences.ScheduledTasks
+		d.ScheduledTasks = append(d.ScheduledTasks, *task)
+		return nil
+	})
+}
+
+func (s *Store) UpdateEncodedScheduledTask(
+	t db.Transaction, task *huedb.EncodedScheduledTask) error {
+	return s.update(t, func(d *tables) error {
+		if idx := d.scheduledTaskIndex(task.Id); idx != -1 {
+			d.ScheduledTasks[idx] = *task
+		}
+		return nil
+	})
+}
+
+func (s *Store) EnableEncodedScheduledTask(
+	t db.Transaction, id int64, enabled bool) error {
+	return s.update(t, func(d *tables) error {
+		if idx := d.scheduledTaskIndex(id); idx != -1 {
+			d.ScheduledTasks[idx].Enabled = enabled
+		}
+		return nil
+	})
+}
+
+func (s *Store) RemoveEncodedScheduledTask(t db.Transaction, id int64) error {
+	return s.update(t, func(d *tables) error {
+		if idx := d.scheduledTaskIndex(id); idx != -1 {
+			d.ScheduledTasks = append(
+				d.ScheduledTasks[:idx], d.ScheduledTasks[idx+1:]...)
+		}
+		return nil
+	})
+}
+
+func (s *Store) SceneById(
+	t db.Transaction, id int64, scene *huedb.Scene) error {
+	return s.do(t, func(d *tables) error {
+		idx := d.sceneIndex(id)
+		if idx == -1 {
+			return huedb.ErrNoSuchId
+		}
+		return d.Scenes[idx].get(scene)
+	})
+}
+
+func (s *Store) Scenes(t db.Transaction, consumer consume.Consumer) error {
+	return s.do(t, func(d *tables) error {
+		for i := range d.Scenes {
+			if !consumer.CanConsume() {
+				break
+			}
+			var scene huedb.Scene
+			if err := d.Scenes[i].get(&scene); err != nil {
+				return err
+			}
+			consumer.Consume(&scene)
+		}
+		return nil
+	})
+}
+
+func (s *Store) AddScene(t db.Transaction, scene *huedb.Scene) error {
+	return s.update(t, func(d *tables) error {
+		var stored jsonScene
+		if err := stored.set(scene); err != nil {
+			return err
+		}
+		d.Sequences.Scenes++
+		stored.Id = d.Sequences.Scenes
+		d.Scenes = append(d.Scenes, stored)
+		scene.Id = stored.Id
+		return nil
+	})
+}
+
+func (s *Store) UpdateScene(t db.Transaction, scene *huedb.Scene) error {
+	return s.update(t, func(d *tables) error {
+		idx := d.sceneIndex(scene.Id)
+		if idx == -1 {
+			return nil
+		}
+		return d.Scenes[idx].set(scene)
+	})
+}
+
+func (s *Store) RemoveScene(t db.Transaction, id int64) error {
+	return s.update(t, func(d *tables) error {
+		if idx := d.sceneIndex(id); idx != -1 {
+			d.Scenes = append(d.Scenes[:idx], d.Scenes[idx+1:]...)
+		}
+		return nil
+	})
+}
+
+func (s *Store) LastParams(
+	t db.Transaction, hueTaskId int, params *huedb.LastParams) error {
+	return s.do(t, func(d *tables) error {
+		for _, lastParams := range d.LastParams {
+			if lastParams.HueTaskId == hueTaskId {
+				*params = lastParams
+				params.Values = copyValues(lastParams.Values)
+				return nil
+			}
+		}
+		return huedb.ErrNoSuchId
+	})
+}
+
+func (s *Store) SaveLastParams(
+	t db.Transaction, params *huedb.LastParams) error {
+	return s.update(t, func(d *tables) error {
+		saved := *params
+		saved.Values = copyValues(params.Values)
+		for i := range d.LastParams {
+			if d.LastParams[i].HueTaskId == params.HueTaskId {
+				d.LastParams[i] = saved
+				return nil
+			}
+		}
+		d.LastParams = append(d.LastParams, saved)
+		return nil
+	})
+}
+
+func (s *Store) namedColors(
+	t db.Transaction,
+	query string,
+	orderByDescription bool,
+	consumer consume.Consumer) error {
+	query = strings.ToLower(query)
+	return s.do(t, func(d *tables) error {
+		var matches []*jsonNamedColors
+		for i := range d.NamedColors {
+			if !d.NamedColors[i].Archived && strings.Contains(
+				strings.ToLower(d.NamedColors[i].Description), query) {
+				matches = append(matches, &d.NamedColors[i])
+			}
+		}
+		if orderByDescription {
+			sort.SliceStable(matches, func(i, j int) bool {
+				return strings.ToLower(matches[i].Description) <
+					strings.ToLower(matches[j].Description)
+			})
+		}
+		for _, match := range matches {
+			if !consumer.CanConsume() {
+				break
+			}
+			var namedColors ops.NamedColors
+			if err := match.get(&namedColors); err != nil {
+				return err
+			}
+			consumer.Consume(&namedColors)
+		}
+		return nil
+	})
+}
+
+func (s *Store) setArchived(t db.Transaction, id int64, archived bool) error {
+	return s.update(t, func(d *tables) error {
+		if idx := d.namedColorsIndex(id); idx != -1 {
+			d.NamedColors[idx].Archived = archived
+		}
+		return nil
+	})
+}
+
+// do runs f on the tables of this store. If t is nil, do runs f in its
+// own transaction.
+func (s *Store) do(t db.Transaction, f func(d *tables) error) error {
+	if t != nil {
+		return f(t.(*transaction).data)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return f(s.data)
+}
+
+// update is like do except that when t is nil, update runs f in its own
+// transaction and saves the changes f makes.
+func (s *Store) update(t db.Transaction, f func(d *tables) error) error {
+	if t != nil {
+		return f(t.(*transaction).data)
+	}
+	return NewDoer(s).Do(func(t db.Transaction) error {
+		return f(t.(*transaction).data)
+	})
+}
+
+// save atomically replaces the JSON file with data.
+func (s *Store) save(data *tables) error {
+	contents, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, s.path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+type transaction struct {
+	data *tables
+}
+
+type doer struct {
+	store *Store
+}
+
+func (d doer) Do(action db.Action) error {
+	s := d.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.data.copy()
+	if err := action(&transaction{data}); err != nil {
+		return err
+	}
+	if err := s.save(data); err != nil {
+		return err
+	}
+	s.data = data
+	return nil
+}
+
+// tables is the contents of the JSON file.
+type tables struct {
+	Sequences      sequences                    `json:"sequences"`
+	NamedColors    []jsonNamedColors            `json:"named_colors"`
+	AtTimeTasks    []huedb.EncodedAtTimeTask    `json:"at_time_tasks"`
+	ScheduledTasks []huedb.EncodedScheduledTask `json:"scheduled_tasks"`
+	Scenes         []jsonScene                  `json:"scenes"`
+	LastParams     []huedb.LastParams           `json:"last_params"`
+}
+
+// copy returns a copy of t that can be changed without changing t.
+// Only the slices need copying as their elements are never changed in
+// place through a pointer that a caller holds.
+func (t *tables) copy() *tables {
+	result := *t
+	result.NamedColors = append([]jsonNamedColors(nil), t.NamedColors...)
+	result.AtTimeTasks = append(
+		[]huedb.EncodedAtTimeTask(nil), t.AtTimeTasks...)
+	result.ScheduledTasks = append(
+		[]huedb.EncodedScheduledTask(nil), t.ScheduledTasks...)
+	result.Scenes = append([]jsonScene(nil), t.Scenes...)
+	result.LastParams = append([]huedb.LastParams(nil), t.LastParams...)
+	return &result
+}
+
+// addAtTimeTask adds task as created at createdAt and sets its Id.
+func (t *tables) addAtTimeTask(
+	task *huedb.EncodedAtTimeTask, createdAt time.Time) {
+	t.Sequences.AtTimeTasks++
+	task.Id = t.Sequences.AtTimeTasks
+	stored := *task
+	stored.CreatedAt = createdAt
+	stored.UpdatedAt = createdAt
+	t.AtTimeTasks = append(t.AtTimeTasks, stored)
+}
+
+func (t *tables) namedColorsIndex(id int64) int {
+	for i := range t.NamedColors {
+		if t.NamedColors[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *tables) scheduledTaskIndex(id int64) int {
+	for i := range t.ScheduledTasks {
+		if t.ScheduledTasks[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *tables) sceneIndex(id int64) int {
+	for i := range t.Scenes {
+		if t.Scenes[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// sequences holds the last id assigned in each table. Like sqlite
+// autoincrement columns, ids are never reused.
+type sequences struct {
+	NamedColors    int64 `json:"named_colors"`
+	AtTimeTasks    int64 `json:"at_time_tasks"`
+	ScheduledTasks int64 `json:"scheduled_tasks"`
+	Scenes         int64 `json:"scenes"`
+}
+
+// jsonNamedColors is how ops.NamedColors are stored in the JSON file.
+type jsonNamedColors struct {
+	Id          int64                             `json:"id"`
+	Description string                            `json:"description"`
+	Colors      map[int]lightjson.ColorBrightness `json:"colors"`
+	CreatedAt   time.Time                         `json:"created_at"`
+	UpdatedAt   time.Time                         `json:"updated_at"`
+	Version     int64                             `json:"version"`
+	Archived    bool                              `json:"archived,omitempty"`
+}
+
+func (j *jsonNamedColors) set(namedColors *ops.NamedColors) error {
+	colors, ok := lightjson.FromColors(namedColors.Colors)
+	if !ok {
+		return huedb.ErrBadLightColors
+	}
+	j.Id = namedColors.Id
+	j.Description = namedColors.Description
+	j.Colors = colors
+	return nil
+}
+
+func (j *jsonNamedColors) get(namedColors *ops.NamedColors) error {
+	colors, ok := lightjson.ToColors(j.Colors)
+	if !ok {
+		return huedb.ErrBadLightColors
+	}
+	*namedColors = ops.NamedColors{
+		Id:          j.Id,
+		Colors:      colors,
+		Description: j.Description,
+		CreatedAt:   j.CreatedAt,
+		UpdatedAt:   j.UpdatedAt,
+		Version:     j.Version,
+	}
+	return nil
+}
+
+// jsonScene is how a huedb.Scene is stored in the JSON file.
+type jsonScene struct {
+	Id     int64                        `json:"id"`
+	Name   string                       `json:"name"`
+	States map[int]lightjson.LightState `json:"states"`
+	Tags   []string                     `json:"tags,omitempty"`
+}
+
+func (j *jsonScene) set(scene *huedb.Scene) error {
+	states, ok := lightjson.FromStates(scene.States)
+	if !ok {
+		return huedb.ErrBadLightColors
+	}
+	j.Id = scene.Id
+	j.Name = scene.Name
+	j.States = states
+	j.Tags = append([]string(nil), scene.Tags...)
+	return nil
+}
+
+func (j *jsonScene) get(scene *huedb.Scene) error {
+	states, ok := lightjson.ToStates(j.States)
+	if !ok {
+		return huedb.ErrBadLightColors
+	}
+	var tags []string
+	if len(j.Tags) > 0 {
+		tags = append([]string(nil), j.Tags...)
+	}
+	*scene = huedb.Scene{
+		Id:     j.Id,
+		Name:   j.Name,
+		States: states,
+		Tags:   tags,
+	}
+	return nil
+}
+
+func copyValues(values map[string][]string) map[string][]string {
+	result := make(map[string][]string, len(values))
+	for key, value := range values {
+		result[key] = append([]string(nil), value...)
+	}
+	return result
+}
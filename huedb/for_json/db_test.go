@@ -0,0 +1,188 @@
+package for_json_test
+
+import (
+	"errors"
+	"github.com/keep94/consume"
+	"github.com/keep94/marvin2/huedb"
+	"github.com/keep94/marvin2/huedb/fixture"
+	"github.com/keep94/marvin2/huedb/for_json"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/toolbox/db"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNamedColorsById(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fixture.NamedColorsById(t, openStore(t, dir))
+}
+
+func TestNamedColors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fixture.NamedColors(t, openStore(t, dir))
+}
+
+func TestNamedColorsByDescription(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fixture.NamedColorsByDescription(t, openStore(t, dir))
+}
+
+func TestNamedColorsWithOptions(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fixture.NamedColorsWithOptions(t, openStore(t, dir))
+}
+
+func TestUpdateNamedColors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fixture.UpdateNamedColors(t, openStore(t, dir))
+}
+
+func TestConcurrentUpdateNamedColors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fixture.ConcurrentUpdateNamedColors(t, openStore(t, dir))
+}
+
+func TestRemoveNamedColors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fixture.RemoveNamedColors(t, openStore(t, dir))
+}
+
+func TestArchiveNamedColors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fixture.ArchiveNamedColors(t, openStore(t, dir))
+}
+
+func TestEncodedAtTimeTasks(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fixture.EncodedAtTimeTasks(t, openStore(t, dir))
+}
+
+func TestModifiedSince(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fixture.ModifiedSince(t, openStore(t, dir))
+}
+
+func TestScenes(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fixture.Scenes(t, openStore(t, dir))
+}
+
+func TestLastParams(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fixture.LastParams(t, openStore(t, dir))
+}
+
+func TestScheduledTasks(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fixture.ScheduledTasks(t, openStore(t, dir))
+}
+
+func TestReopen(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	store := openStore(t, dir)
+	namedColors := &ops.NamedColors{Description: "Foo"}
+	if err := store.AddNamedColors(nil, namedColors); err != nil {
+		t.Fatalf("Error adding: %v", err)
+	}
+	task := &huedb.EncodedAtTimeTask{
+		GroupId: "g", ScheduleId: "1:2:All", HueTaskId: 1, Time: 2}
+	if err := store.AddEncodedAtTimeTask(nil, task); err != nil {
+		t.Fatalf("Error adding: %v", err)
+	}
+	var expected ops.NamedColors
+	if err := store.NamedColorsById(nil, namedColors.Id, &expected); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	var expectedTasks []huedb.EncodedAtTimeTask
+	if err := store.EncodedAtTimeTasks(
+		nil, "g", consume.AppendTo(&expectedTasks)); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	store = openStore(t, dir)
+	var actual ops.NamedColors
+	if err := store.NamedColorsById(nil, namedColors.Id, &actual); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	// Timestamps survive reopening but lose their monotonic clock reading
+	// and location so compare them with Equal.
+	if !actual.CreatedAt.Equal(expected.CreatedAt) ||
+		!actual.UpdatedAt.Equal(expected.UpdatedAt) {
+		t.Errorf("Expected %v, got %v", &expected, &actual)
+	}
+	actual.CreatedAt, actual.UpdatedAt = expected.CreatedAt, expected.UpdatedAt
+	if !reflect.DeepEqual(&expected, &actual) {
+		t.Errorf("Expected %v, got %v", &expected, &actual)
+	}
+	var tasks []huedb.EncodedAtTimeTask
+	if err := store.EncodedAtTimeTasks(
+		nil, "g", consume.AppendTo(&tasks)); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	if len(tasks) != 1 || !tasks[0].CreatedAt.Equal(expectedTasks[0].CreatedAt) {
+		t.Fatalf("Expected %v, got %v", expectedTasks, tasks)
+	}
+	tasks[0].CreatedAt = expectedTasks[0].CreatedAt
+	tasks[0].UpdatedAt = expectedTasks[0].UpdatedAt
+	if !reflect.DeepEqual(expectedTasks, tasks) {
+		t.Errorf("Expected %v, got %v", expectedTasks, tasks)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	store := openStore(t, dir)
+	errRollback := errors.New("rollback")
+	err := for_json.NewDoer(store).Do(func(t db.Transaction) error {
+		if err := store.AddNamedColors(
+			t, &ops.NamedColors{Description: "Foo"}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Fatalf("Expected errRollback, got %v", err)
+	}
+	var actual ops.NamedColors
+	if err := store.NamedColorsById(
+		nil, 1, &actual); err != huedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	if _, err := os.Stat(
+		filepath.Join(dir, "hue.json")); !os.IsNotExist(err) {
+		t.Errorf("Expected no file, got %v", err)
+	}
+}
+
+func openStore(t *testing.T, dir string) *for_json.Store {
+	store, err := for_json.Open(filepath.Join(dir, "hue.json"))
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	return store
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "for_json")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	return dir
+}
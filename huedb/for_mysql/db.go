@@ -0,0 +1,66 @@
+// Package for_mysql provides a MySQL and MariaDB implementation of
+// interfaces in huedb package. Callers open the database with
+// database/sql using a MySQL driver such as github.com/go-sql-driver/mysql
+// and set up its tables with the mysql_setup package.
+package for_mysql
+
+import (
+	"database/sql"
+	"github.com/keep94/marvin2/huedb/internal/sqlstore"
+	"github.com/keep94/toolbox/db"
+)
+
+var kStatements = &sqlstore.Statements{
+	NamedColorsById:          "select id, colors, description, created_at, updated_at, version from named_colors where id = ?",
+	NamedColors:              "select id, colors, description, created_at, updated_at, version from named_colors where archived = false order by 1",
+	NamedColorsByDescription: `select id, colors, description, created_at, updated_at, version from named_colors where archived = false and lower(description) like lower(?) escape '\\' order by 1`,
+	NamedColorsWithOptions:   `select id, colors, description, created_at, updated_at, version from named_colors where archived = false and lower(description) like lower(?) escape '\\' order by %s limit ? offset ?`,
+	NamedColorsModifiedSince: "select id, colors, description, created_at, updated_at, version from named_colors where archived = false and updated_at >= ? order by updated_at desc, id desc",
+	AddNamedColors:           "insert into named_colors (colors, description, created_at, updated_at, version) values (?, ?, ?, ?, 1)",
+	NamedColorsVersion:       "select version from named_colors where id = ? for update",
+	UpdateNamedColors:        "update named_colors set colors = ?, description = ?, updated_at = ?, version = version + 1 where id = ?",
+	RemoveNamedColors:        "delete from named_colors where id = ?",
+	ArchiveNamedColors:       "update named_colors set archived = true where id = ?",
+	RestoreNamedColors:       "update named_colors set archived = false where id = ?",
+	ArchivedNamedColors:      "select id, colors, description, created_at, updated_at, version from named_colors where archived = true order by 1",
+
+	AddEncodedAtTimeTask:                "insert into at_time_tasks (schedule_id, hue_task_id, action, description, light_set, time, end_time, restore_at_end, group_id, created_at, updated_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	EncodedAtTimeTasks:                  "select id, schedule_id, hue_task_id, action, description, light_set, time, end_time, restore_at_end, group_id, created_at, updated_at from at_time_tasks where group_id = ? order by 1",
+	EncodedAtTimeTasksModifiedSince:     "select id, schedule_id, hue_task_id, action, description, light_set, time, end_time, restore_at_end, group_id, created_at, updated_at from at_time_tasks where group_id = ? and updated_at >= ? order by updated_at desc, id desc",
+	RemoveEncodedAtTimeTaskByScheduleId: "delete from at_time_tasks where group_id = ? and schedule_id = ?",
+	ClearEncodedAtTimeTasks:             "delete from at_time_tasks",
+	RemoveEncodedAtTimeTasksBefore:      "delete from at_time_tasks where time < ? and end_time < ?",
+
+	EncodedScheduledTasks:      "select id, hue_task_id, action, description, light_set, recurring, priority, enabled from scheduled_tasks order by 1",
+	AddEncodedScheduledTask:    "insert into scheduled_tasks (hue_task_id, action, description, light_set, recurring, priority, enabled) values (?, ?, ?, ?, ?, ?, ?)",
+	UpdateEncodedScheduledTask: "update scheduled_tasks set hue_task_id = ?, action = ?, description = ?, light_set = ?, recurring = ?, priority = ?, enabled = ? where id = ?",
+	RemoveEncodedScheduledTask: "delete from scheduled_tasks where id = ?",
+	EnableEncodedScheduledTask: "update scheduled_tasks set enabled = ? where id = ?",
+
+	SceneById:   "select id, name, states, tags from scenes where id = ?",
+	Scenes:      "select id, name, states, tags from scenes order by 1",
+	AddScene:    "insert into scenes (name, states, tags) values (?, ?, ?)",
+	UpdateScene: "update scenes set name = ?, states = ?, tags = ? where id = ?",
+	RemoveScene: "delete from scenes where id = ?",
+
+	LastParams:     "select hue_task_id, params, action, description from last_params where hue_task_id = ?",
+	SaveLastParams: "insert into last_params (hue_task_id, params, action, description) values (?, ?, ?, ?) on duplicate key update params = values(params), action = values(action), description = values(description)",
+}
+
+// Store implements the same interfaces in the huedb package that the
+// Store in the for_sqlite package does. Transactions passed to the
+// methods of Store are *sql.Tx instances such as the ones that the
+// db.Doer that NewDoer returns creates.
+type Store struct {
+	sqlstore.Store
+}
+
+func New(db *sql.DB) Store {
+	return Store{sqlstore.New(db, kStatements)}
+}
+
+// NewDoer returns a db.Doer that runs each action within a single
+// transaction of db.
+func NewDoer(db *sql.DB) db.Doer {
+	return sqlstore.NewDoer(db)
+}
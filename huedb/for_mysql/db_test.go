@@ -0,0 +1,125 @@
+package for_mysql_test
+
+import (
+	"database/sql"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/keep94/marvin2/huedb/fixture"
+	"github.com/keep94/marvin2/huedb/for_mysql"
+	"github.com/keep94/marvin2/huedb/mysql_setup"
+	"os"
+	"testing"
+)
+
+// kDSNEnv names the environment variable with the data source name of a
+// scratch MySQL database for testing. Tests drop all tables in that
+// database. If the environment variable is not set, tests are skipped.
+const kDSNEnv = "MARVIN2_MYSQL_DSN"
+
+func TestNamedColorsById(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.NamedColorsById(t, for_mysql.New(db))
+}
+
+func TestNamedColors(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.NamedColors(t, for_mysql.New(db))
+}
+
+func TestNamedColorsByDescription(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.NamedColorsByDescription(t, for_mysql.New(db))
+}
+
+func TestNamedColorsWithOptions(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.NamedColorsWithOptions(t, for_mysql.New(db))
+}
+
+func TestUpdateNamedColors(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.UpdateNamedColors(t, for_mysql.New(db))
+}
+
+func TestConcurrentUpdateNamedColors(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.ConcurrentUpdateNamedColors(t, for_mysql.New(db))
+}
+
+func TestRemoveNamedColors(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.RemoveNamedColors(t, for_mysql.New(db))
+}
+
+func TestContexts(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.Contexts(t, for_mysql.New(db))
+}
+
+func TestArchiveNamedColors(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.ArchiveNamedColors(t, for_mysql.New(db))
+}
+
+func TestEncodedAtTimeTasks(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.EncodedAtTimeTasks(t, for_mysql.New(db))
+}
+
+func TestModifiedSince(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.ModifiedSince(t, for_mysql.New(db))
+}
+
+func TestScheduledTasks(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.ScheduledTasks(t, for_mysql.New(db))
+}
+
+func TestScenes(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.Scenes(t, for_mysql.New(db))
+}
+
+func TestLastParams(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.LastParams(t, for_mysql.New(db))
+}
+
+func closeDb(t *testing.T, db *sql.DB) {
+	if err := db.Close(); err != nil {
+		t.Errorf("Error closing database: %v", err)
+	}
+}
+
+func openDb(t *testing.T) *sql.DB {
+	dsn := os.Getenv(kDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", kDSNEnv)
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	_, err = db.Exec("drop table if exists named_colors, at_time_tasks, scheduled_tasks, last_params, scenes")
+	if err != nil {
+		t.Fatalf("Error dropping tables: %v", err)
+	}
+	if err := mysql_setup.SetUpTables(db); err != nil {
+		t.Fatalf("Error creating tables: %v", err)
+	}
+	return db
+}
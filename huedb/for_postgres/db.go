@@ -0,0 +1,68 @@
+// Package for_postgres provides a PostgreSQL implementation of interfaces
+// in huedb package. Callers open the database with database/sql using a
+// PostgreSQL driver such as github.com/jackc/pgx/v4/stdlib and set up
+// its tables with the postgres_setup package.
+package for_postgres
+
+import (
+	"database/sql"
+	"github.com/keep94/marvin2/huedb/internal/sqlstore"
+	"github.com/keep94/toolbox/db"
+)
+
+var kStatements = &sqlstore.Statements{
+	NamedColorsById:          "select id, colors, description, created_at, updated_at, version from named_colors where id = $1",
+	NamedColors:              "select id, colors, description, created_at, updated_at, version from named_colors where archived = false order by 1",
+	NamedColorsByDescription: `select id, colors, description, created_at, updated_at, version from named_colors where archived = false and description ilike $1 escape '\' order by 1`,
+	NamedColorsWithOptions:   `select id, colors, description, created_at, updated_at, version from named_colors where archived = false and description ilike $1 escape '\' order by %s limit $2 offset $3`,
+	NamedColorsModifiedSince: "select id, colors, description, created_at, updated_at, version from named_colors where archived = false and updated_at >= $1 order by updated_at desc, id desc",
+	AddNamedColors:           "insert into named_colors (colors, description, created_at, updated_at, version) values ($1, $2, $3, $4, 1) returning id",
+	NamedColorsVersion:       "select version from named_colors where id = $1 for update",
+	UpdateNamedColors:        "update named_colors set colors = $1, description = $2, updated_at = $3, version = version + 1 where id = $4",
+	RemoveNamedColors:        "delete from named_colors where id = $1",
+	ArchiveNamedColors:       "update named_colors set archived = true where id = $1",
+	RestoreNamedColors:       "update named_colors set archived = false where id = $1",
+	ArchivedNamedColors:      "select id, colors, description, created_at, updated_at, version from named_colors where archived = true order by 1",
+
+	AddEncodedAtTimeTask:                "insert into at_time_tasks (schedule_id, hue_task_id, action, description, light_set, time, end_time, restore_at_end, group_id, created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id",
+	EncodedAtTimeTasks:                  "select id, schedule_id, hue_task_id, action, description, light_set, time, end_time, restore_at_end, group_id, created_at, updated_at from at_time_tasks where group_id = $1 order by 1",
+	EncodedAtTimeTasksModifiedSince:     "select id, schedule_id, hue_task_id, action, description, light_set, time, end_time, restore_at_end, group_id, created_at, updated_at from at_time_tasks where group_id = $1 and updated_at >= $2 order by updated_at desc, id desc",
+	RemoveEncodedAtTimeTaskByScheduleId: "delete from at_time_tasks where group_id = $1 and schedule_id = $2",
+	ClearEncodedAtTimeTasks:             "delete from at_time_tasks",
+	RemoveEncodedAtTimeTasksBefore:      "delete from at_time_tasks where time < $1 and end_time < $2",
+
+	EncodedScheduledTasks:      "select id, hue_task_id, action, description, light_set, recurring, priority, enabled from scheduled_tasks order by 1",
+	AddEncodedScheduledTask:    "insert into scheduled_tasks (hue_task_id, action, description, light_set, recurring, priority, enabled) values ($1, $2, $3, $4, $5, $6, $7) returning id",
+	UpdateEncodedScheduledTask: "update scheduled_tasks set hue_task_id = $1, action = $2, description = $3, light_set = $4, recurring = $5, priority = $6, enabled = $7 where id = $8",
+	RemoveEncodedScheduledTask: "delete from scheduled_tasks where id = $1",
+	EnableEncodedScheduledTask: "update scheduled_tasks set enabled = $1 where id = $2",
+
+	SceneById:   "select id, name, states, tags from scenes where id = $1",
+	Scenes:      "select id, name, states, tags from scenes order by 1",
+	AddScene:    "insert into scenes (name, states, tags) values ($1, $2, $3) returning id",
+	UpdateScene: "update scenes set name = $1, states = $2, tags = $3 where id = $4",
+	RemoveScene: "delete from scenes where id = $1",
+
+	LastParams:     "select hue_task_id, params, action, description from last_params where hue_task_id = $1",
+	SaveLastParams: "insert into last_params (hue_task_id, params, action, description) values ($1, $2, $3, $4) on conflict (hue_task_id) do update set params = excluded.params, action = excluded.action, description = excluded.description",
+
+	Returning: true,
+}
+
+// Store implements the same interfaces in the huedb package that the
+// Store in the for_sqlite package does. Transactions passed to the
+// methods of Store are *sql.Tx instances such as the ones that the
+// db.Doer that NewDoer returns creates.
+type Store struct {
+	sqlstore.Store
+}
+
+func New(db *sql.DB) Store {
+	return Store{sqlstore.New(db, kStatements)}
+}
+
+// NewDoer returns a db.Doer that runs each action within a single
+// transaction of db.
+func NewDoer(db *sql.DB) db.Doer {
+	return sqlstore.NewDoer(db)
+}
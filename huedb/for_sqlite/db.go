@@ -3,30 +3,68 @@
 package for_sqlite
 
 import (
+	"fmt"
 	"github.com/keep94/consume"
-	"github.com/keep94/gohue"
 	"github.com/keep94/gosqlite/sqlite"
 	"github.com/keep94/marvin2/huedb"
+	"github.com/keep94/marvin2/huedb/internal/columns"
 	"github.com/keep94/marvin2/ops"
-	"github.com/keep94/maybe"
 	"github.com/keep94/toolbox/db"
 	"github.com/keep94/toolbox/db/sqlite_db"
 	"github.com/keep94/toolbox/db/sqlite_rw"
-	"strconv"
-	"strings"
+	"net/url"
+	"time"
 )
 
 const (
-	kSQLNamedColorsById   = "select id, colors, description from named_colors where id = ?"
-	kSQLNamedColors       = "select id, colors, description from named_colors order by 1"
-	kSQLAddNamedColors    = "insert into named_colors (colors, description) values (?, ?)"
-	kSQLUpdateNamedColors = "update named_colors set colors = ?, description = ? where id = ?"
-	kSQLRemoveNamedColors = "delete from named_colors where id = ?"
-
-	kSQLAddEncodedAtTimeTask                = "insert into at_time_tasks (schedule_id, hue_task_id, action, description, light_set, time, group_id) values (?, ?, ?, ?, ?, ?, ?)"
-	kSQLEncodedAtTimeTasks                  = "select id, schedule_id, hue_task_id, action, description, light_set, time, group_id from at_time_tasks where group_id = ? order by 1"
+	kSQLNamedColorsById               = "select id, colors, description, created_at, updated_at, version from named_colors where id = ?"
+	kSQLNamedColors                   = "select id, colors, description, created_at, updated_at, version from named_colors where archived = 0 order by 1"
+	kSQLNamedColorsByDescription      = "select id, colors, description, created_at, updated_at, version from named_colors where archived = 0 and description like ? escape '\\' order by 1"
+	kSQLNamedColorsByExactDescription = "select id, colors, description, created_at, updated_at, version from named_colors where archived = 0 and description = ? collate nocase order by 1"
+	kSQLNamedColorsWithOptions        = "select id, colors, description, created_at, updated_at, version from named_colors where archived = 0 and description like ? escape '\\' order by %s limit ? offset ?"
+	kSQLArchivedNamedColors           = "select id, colors, description, created_at, updated_at, version from named_colors where archived = 1 order by 1"
+	kSQLNamedColorsModifiedSince      = "select id, colors, description, created_at, updated_at, version from named_colors where archived = 0 and updated_at >= ? order by updated_at desc, id desc"
+	kSQLAddNamedColors                = "insert into named_colors (colors, description, created_at, updated_at, version) values (?1, ?2, ?3, ?3, 1)"
+	kSQLNamedColorsVersion            = "select version from named_colors where id = ?"
+	kSQLUpdateNamedColors             = "update named_colors set colors = ?, description = ?, updated_at = ?, version = version + 1 where id = ?"
+	kSQLRemoveNamedColors             = "delete from named_colors where id = ?"
+	kSQLArchiveNamedColors            = "update named_colors set archived = 1 where id = ?"
+	kSQLRestoreNamedColors            = "update named_colors set archived = 0 where id = ?"
+
+	kSQLAddEncodedAtTimeTask                = "insert into at_time_tasks (schedule_id, hue_task_id, action, description, light_set, time, end_time, restore_at_end, group_id, created_at, updated_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	kSQLEncodedAtTimeTasks                  = "select id, schedule_id, hue_task_id, action, description, light_set, time, end_time, restore_at_end, group_id, created_at, updated_at from at_time_tasks where group_id = ? order by 1"
+	kSQLEncodedAtTimeTasksModifiedSince     = "select id, schedule_id, hue_task_id, action, description, light_set, time, end_time, restore_at_end, group_id, created_at, updated_at from at_time_tasks where group_id = ? and updated_at >= ? order by updated_at desc, id desc"
 	kSQLRemoveEncodedAtTimeTaskByScheduleId = "delete from at_time_tasks where group_id = ? and schedule_id = ?"
 	kSQLClearEncodedAtTimeTasks             = "delete from at_time_tasks"
+	kSQLRemoveEncodedAtTimeTasksBefore      = "delete from at_time_tasks where time < ? and end_time < ?"
+
+	kSQLEncodedScheduledTasks      = "select id, hue_task_id, action, description, light_set, recurring, priority, enabled from scheduled_tasks order by 1"
+	kSQLAddEncodedScheduledTask    = "insert into scheduled_tasks (hue_task_id, action, description, light_set, recurring, priority, enabled) values (?, ?, ?, ?, ?, ?, ?)"
+	kSQLUpdateEncodedScheduledTask = "update scheduled_tasks set hue_task_id = ?, action = ?, description = ?, light_set = ?, recurring = ?, priority = ?, enabled = ? where id = ?"
+	kSQLRemoveEncodedScheduledTask = "delete from scheduled_tasks where id = ?"
+	kSQLEnableEncodedScheduledTask = "update scheduled_tasks set enabled = ? where id = ?"
+
+	kSQLSceneById   = "select id, name, states, tags from scenes where id = ?"
+	kSQLScenes      = "select id, name, states, tags from scenes order by 1"
+	kSQLAddScene    = "insert into scenes (name, states, tags) values (?, ?, ?)"
+	kSQLUpdateScene = "update scenes set name = ?, states = ?, tags = ? where id = ?"
+	kSQLRemoveScene = "delete from scenes where id = ?"
+
+	kSQLLastFired     = "select scheduled_task_id, time from last_fired where scheduled_task_id = ?"
+	kSQLSaveLastFired = "insert or replace into last_fired (scheduled_task_id, time) values (?, ?)"
+
+	kSQLLastParams     = "select hue_task_id, params, action, description from last_params where hue_task_id = ?"
+	kSQLSaveLastParams = "insert or replace into last_params (hue_task_id, params, action, description) values (?, ?, ?, ?)"
+
+	kSQLDescriptionOverride       = "select hue_task_id, description from description_overrides where hue_task_id = ?"
+	kSQLDescriptionOverrides      = "select hue_task_id, description from description_overrides order by 1"
+	kSQLSaveDescriptionOverride   = "insert or replace into description_overrides (hue_task_id, description) values (?, ?)"
+	kSQLRemoveDescriptionOverride = "delete from description_overrides where hue_task_id = ?"
+
+	kSQLAddTaskRun     = "insert into task_runs (hue_task_id, description, start, duration) values (?, ?, ?, ?)"
+	kSQLLastTaskRun    = "select id, hue_task_id, description, start, duration from task_runs where hue_task_id = ? order by start desc, id desc limit 1"
+	kSQLTaskStats      = "select hue_task_id, description, count(*), avg(duration), max(start) from task_runs where start >= ? group by hue_task_id order by 3 desc, 1"
+	kSQLTaskRunsPerDay = "select start from task_runs where start >= ? order by 1"
 )
 
 type Store struct {
@@ -41,6 +79,13 @@ func ConnNew(conn *sqlite.Conn) Store {
 	return Store{sqlite_db.NewSqliteDoer(conn)}
 }
 
+// NewDoer returns a db.Doer that runs each action within a single sqlite
+// transaction of db. If the action fails, the transaction rolls back.
+// Use with huedb.Atomically to change several tables at once.
+func NewDoer(db *sqlite_db.Db) db.Doer {
+	return sqlite_db.NewDoer(db)
+}
+
 func (s Store) NamedColorsById(
 	t db.Transaction, id int64, namedColors *ops.NamedColors) error {
 	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
@@ -64,24 +109,106 @@ func (s Store) NamedColors(
 	})
 }
 
+func (s Store) NamedColorsByDescription(
+	t db.Transaction, query string, consumer consume.Consumer) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadMultiple(
+			conn,
+			(&rawNamedColors{}).init(&ops.NamedColors{}),
+			consumer,
+			kSQLNamedColorsByDescription,
+			"%"+columns.EscapeLike(query)+"%")
+	})
+}
+
+func (s Store) NamedColorsByExactDescription(
+	t db.Transaction, description string, consumer consume.Consumer) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadMultiple(
+			conn,
+			(&rawNamedColors{}).init(&ops.NamedColors{}),
+			consumer,
+			kSQLNamedColorsByExactDescription,
+			description)
+	})
+}
+
+func (s Store) NamedColorsModifiedSince(
+	t db.Transaction, since time.Time, consumer consume.Consumer) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadMultiple(
+			conn,
+			(&rawNamedColors{}).init(&ops.NamedColors{}),
+			consumer,
+			kSQLNamedColorsModifiedSince,
+			columns.EncodeTime(since))
+	})
+}
+
+func (s Store) NamedColorsWithOptions(
+	t db.Transaction,
+	options *huedb.NamedColorsOptions,
+	consumer consume.Consumer) error {
+	orderBy := "id"
+	if options.OrderByDescription {
+		orderBy = "description collate nocase, id"
+	}
+	limit := options.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadMultiple(
+			conn,
+			(&rawNamedColors{}).init(&ops.NamedColors{}),
+			consumer,
+			fmt.Sprintf(kSQLNamedColorsWithOptions, orderBy),
+			"%"+columns.EscapeLike(options.Description)+"%",
+			limit,
+			options.Offset)
+	})
+}
+
 func (s Store) AddNamedColors(
 	t db.Transaction, namedColors *ops.NamedColors) error {
+	stored := *namedColors
+	stored.CreatedAt = now()
+	stored.UpdatedAt = stored.CreatedAt
 	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
-		return sqlite_rw.AddRow(
+		if err := sqlite_rw.AddRow(
 			conn,
-			(&rawNamedColors{}).init(namedColors),
+			(&rawNamedColors{}).init(&stored),
 			&namedColors.Id,
-			kSQLAddNamedColors)
+			kSQLAddNamedColors); err != nil {
+			return err
+		}
+		namedColors.Version = 1
+		return nil
 	})
 }
 
 func (s Store) UpdateNamedColors(
 	t db.Transaction, namedColors *ops.NamedColors) error {
 	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
-		return sqlite_rw.UpdateRow(
+		version, ok, err := namedColorsVersion(conn, namedColors.Id)
+		if err != nil {
+			return err
+		}
+		if ok && namedColors.Version != 0 && version != namedColors.Version {
+			return huedb.ErrConcurrentModification
+		}
+		stored := *namedColors
+		stored.UpdatedAt = now()
+		if err := sqlite_rw.UpdateRow(
 			conn,
-			(&rawNamedColors{}).init(namedColors),
-			kSQLUpdateNamedColors)
+			(&rawNamedColors{}).init(&stored),
+			kSQLUpdateNamedColors); err != nil {
+			return err
+		}
+		if ok {
+			namedColors.Version = version + 1
+		}
+		return nil
 	})
 }
 
@@ -91,6 +218,29 @@ func (s Store) RemoveNamedColors(t db.Transaction, id int64) error {
 	})
 }
 
+func (s Store) ArchiveNamedColors(t db.Transaction, id int64) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return conn.Exec(kSQLArchiveNamedColors, id)
+	})
+}
+
+func (s Store) RestoreNamedColors(t db.Transaction, id int64) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return conn.Exec(kSQLRestoreNamedColors, id)
+	})
+}
+
+func (s Store) ArchivedNamedColors(
+	t db.Transaction, consumer consume.Consumer) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadMultiple(
+			conn,
+			(&rawNamedColors{}).init(&ops.NamedColors{}),
+			consumer,
+			kSQLArchivedNamedColors)
+	})
+}
+
 func (s Store) EncodedAtTimeTasks(
 	t db.Transaction, groupId string, consumer consume.Consumer) error {
 	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
@@ -103,17 +253,56 @@ func (s Store) EncodedAtTimeTasks(
 	})
 }
 
+func (s Store) EncodedAtTimeTasksModifiedSince(
+	t db.Transaction,
+	groupId string,
+	since time.Time,
+	consumer consume.Consumer) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadMultiple(
+			conn,
+			(&rawEncodedAtTimeTask{}).init(&huedb.EncodedAtTimeTask{}),
+			consumer,
+			kSQLEncodedAtTimeTasksModifiedSince,
+			groupId,
+			columns.EncodeTime(since))
+	})
+}
+
 func (s Store) AddEncodedAtTimeTask(
 	t db.Transaction, task *huedb.EncodedAtTimeTask) error {
+	stored := *task
+	stored.CreatedAt = now()
+	stored.UpdatedAt = stored.CreatedAt
 	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
 		return sqlite_rw.AddRow(
 			conn,
-			(&rawEncodedAtTimeTask{}).init(task),
+			(&rawEncodedAtTimeTask{}).init(&stored),
 			&task.Id,
 			kSQLAddEncodedAtTimeTask)
 	})
 }
 
+func (s Store) AddEncodedAtTimeTasks(
+	t db.Transaction, tasks []*huedb.EncodedAtTimeTask) error {
+	createdAt := now()
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		for _, task := range tasks {
+			stored := *task
+			stored.CreatedAt = createdAt
+			stored.UpdatedAt = createdAt
+			if err := sqlite_rw.AddRow(
+				conn,
+				(&rawEncodedAtTimeTask{}).init(&stored),
+				&task.Id,
+				kSQLAddEncodedAtTimeTask); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (s Store) RemoveEncodedAtTimeTaskByScheduleId(
 	t db.Transaction, groupId, scheduleId string) error {
 	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
@@ -128,125 +317,345 @@ func (s Store) ClearEncodedAtTimeTasks(t db.Transaction) error {
 	})
 }
 
-type rawNamedColors struct {
-	*ops.NamedColors
-	colors string
+func (s Store) RemoveEncodedAtTimeTasksBefore(
+	t db.Transaction, before int64) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return conn.Exec(kSQLRemoveEncodedAtTimeTasksBefore, before, before)
+	})
 }
 
-func (r *rawNamedColors) init(bo *ops.NamedColors) *rawNamedColors {
-	r.NamedColors = bo
-	return r
+func (s Store) EncodedScheduledTasks(
+	t db.Transaction, consumer consume.Consumer) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadMultiple(
+			conn,
+			(&rawEncodedScheduledTask{}).init(&huedb.EncodedScheduledTask{}),
+			consumer,
+			kSQLEncodedScheduledTasks)
+	})
 }
 
-func (r *rawNamedColors) ValuePtr() interface{} {
-	return r.NamedColors
+func (s Store) AddEncodedScheduledTask(
+	t db.Transaction, task *huedb.EncodedScheduledTask) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.AddRow(
+			conn,
+			(&rawEncodedScheduledTask{}).init(task),
+			&task.Id,
+			kSQLAddEncodedScheduledTask)
+	})
 }
 
-func (r *rawNamedColors) Ptrs() []interface{} {
-	return []interface{}{&r.Id, &r.colors, &r.Description}
+func (s Store) UpdateEncodedScheduledTask(
+	t db.Transaction, task *huedb.EncodedScheduledTask) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.UpdateRow(
+			conn,
+			(&rawEncodedScheduledTask{}).init(task),
+			kSQLUpdateEncodedScheduledTask)
+	})
 }
 
-func (r *rawNamedColors) Values() []interface{} {
-	return []interface{}{r.colors, r.Description, r.Id}
+func (s Store) RemoveEncodedScheduledTask(t db.Transaction, id int64) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return conn.Exec(kSQLRemoveEncodedScheduledTask, id)
+	})
 }
 
-func (r *rawNamedColors) Unmarshall() error {
-	if !strings.HasPrefix(r.colors, "0|") && r.colors != "0" {
-		return huedb.ErrBadLightColors
-	}
-	marshalled := strings.Split(r.colors, "|")
-	marshalledLen := len(marshalled)
-	lightColors := make(ops.LightColors, (marshalledLen-1)/4)
-	for idx := 1; idx < marshalledLen; idx += 4 {
-		lightId, err := strconv.Atoi(marshalled[idx])
+func (s Store) EnableEncodedScheduledTask(
+	t db.Transaction, id int64, enabled bool) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return conn.Exec(kSQLEnableEncodedScheduledTask, enabled, id)
+	})
+}
+
+func (s Store) SceneById(
+	t db.Transaction, id int64, scene *huedb.Scene) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadSingle(
+			conn,
+			(&rawScene{}).init(scene),
+			huedb.ErrNoSuchId,
+			kSQLSceneById,
+			id)
+	})
+}
+
+func (s Store) Scenes(t db.Transaction, consumer consume.Consumer) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadMultiple(
+			conn,
+			(&rawScene{}).init(&huedb.Scene{}),
+			consumer,
+			kSQLScenes)
+	})
+}
+
+func (s Store) AddScene(t db.Transaction, scene *huedb.Scene) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.AddRow(
+			conn,
+			(&rawScene{}).init(scene),
+			&scene.Id,
+			kSQLAddScene)
+	})
+}
+
+func (s Store) UpdateScene(t db.Transaction, scene *huedb.Scene) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.UpdateRow(
+			conn,
+			(&rawScene{}).init(scene),
+			kSQLUpdateScene)
+	})
+}
+
+func (s Store) RemoveScene(t db.Transaction, id int64) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return conn.Exec(kSQLRemoveScene, id)
+	})
+}
+
+func (s Store) LastFired(
+	t db.Transaction, scheduledTaskId int, fired *huedb.LastFired) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadSingle(
+			conn,
+			(&rawLastFired{}).init(fired),
+			huedb.ErrNoSuchId,
+			kSQLLastFired,
+			scheduledTaskId)
+	})
+}
+
+func (s Store) SaveLastFired(
+	t db.Transaction, fired *huedb.LastFired) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return conn.Exec(
+			kSQLSaveLastFired,
+			fired.ScheduledTaskId,
+			fired.Time.Unix())
+	})
+}
+
+func (s Store) LastParams(
+	t db.Transaction, hueTaskId int, params *huedb.LastParams) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadSingle(
+			conn,
+			(&rawLastParams{}).init(params),
+			huedb.ErrNoSuchId,
+			kSQLLastParams,
+			hueTaskId)
+	})
+}
+
+func (s Store) SaveLastParams(
+	t db.Transaction, params *huedb.LastParams) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return conn.Exec(
+			kSQLSaveLastParams,
+			params.HueTaskId,
+			params.Values.Encode(),
+			params.Action,
+			params.Description)
+	})
+}
+
+func (s Store) DescriptionOverride(
+	t db.Transaction,
+	hueTaskId int,
+	override *huedb.DescriptionOverride) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadSingle(
+			conn,
+			(&rawDescriptionOverride{}).init(override),
+			huedb.ErrNoSuchId,
+			kSQLDescriptionOverride,
+			hueTaskId)
+	})
+}
+
+func (s Store) DescriptionOverrides(
+	t db.Transaction, consumer consume.Consumer) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadMultiple(
+			conn,
+			(&rawDescriptionOverride{}).init(&huedb.DescriptionOverride{}),
+			consumer,
+			kSQLDescriptionOverrides)
+	})
+}
+
+func (s Store) SaveDescriptionOverride(
+	t db.Transaction, override *huedb.DescriptionOverride) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return conn.Exec(
+			kSQLSaveDescriptionOverride,
+			override.HueTaskId,
+			override.Description)
+	})
+}
+
+func (s Store) RemoveDescriptionOverride(
+	t db.Transaction, hueTaskId int) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return conn.Exec(kSQLRemoveDescriptionOverride, hueTaskId)
+	})
+}
+
+func (s Store) AddTaskRun(t db.Transaction, run *huedb.TaskRun) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.AddRow(
+			conn,
+			(&rawTaskRun{}).init(run),
+			&run.Id,
+			kSQLAddTaskRun)
+	})
+}
+
+func (s Store) LastTaskRun(
+	t db.Transaction, hueTaskId int, run *huedb.TaskRun) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadSingle(
+			conn,
+			(&rawTaskRun{}).init(run),
+			huedb.ErrNoSuchId,
+			kSQLLastTaskRun,
+			hueTaskId)
+	})
+}
+
+func (s Store) TaskStats(
+	t db.Transaction, since time.Time, consumer consume.Consumer) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		return sqlite_rw.ReadMultiple(
+			conn,
+			(&rawTaskStats{}).init(&huedb.TaskStats{}),
+			consumer,
+			kSQLTaskStats,
+			since.Unix())
+	})
+}
+
+func (s Store) TaskRunsPerDay(
+	t db.Transaction, since time.Time, consumer consume.Consumer) error {
+	return sqlite_db.ToDoer(s.db, t).Do(func(conn *sqlite.Conn) error {
+		stmt, err := conn.Prepare(kSQLTaskRunsPerDay)
 		if err != nil {
 			return err
 		}
-		var ix int
-		if ix, err = strconv.Atoi(marshalled[idx+1]); err != nil {
+		defer stmt.Finalize()
+		if err := stmt.Exec(since.Unix()); err != nil {
 			return err
 		}
-		var iy int
-		if iy, err = strconv.Atoi(marshalled[idx+2]); err != nil {
-			return err
+		// Days run midnight to midnight local time which sqlite cannot
+		// group by reliably, so we group here.
+		var current *huedb.DailyRuns
+		for stmt.Next() {
+			var start int64
+			if err := stmt.Scan(&start); err != nil {
+				return err
+			}
+			day := localMidnight(time.Unix(start, 0))
+			if current != nil && current.Day.Equal(day) {
+				current.Runs++
+				continue
+			}
+			if current != nil {
+				if !consumer.CanConsume() {
+					return nil
+				}
+				consumer.Consume(current)
+			}
+			current = &huedb.DailyRuns{Day: day, Runs: 1}
 		}
-		var ibrightness int
-		if ibrightness, err = strconv.Atoi(marshalled[idx+3]); err != nil {
+		if err := stmt.Error(); err != nil {
 			return err
 		}
-		if lightId < 0 {
-			return huedb.ErrBadLightColors
-		}
-		var theColor gohue.MaybeColor
-		if ix != -1 {
-			x := float64(ix) / 10000.0
-			y := float64(iy) / 10000.0
-			if x < 0.0 || x > 1.0 || y < 0.0 || y > 1.0 {
-				return huedb.ErrBadLightColors
-			}
-			theColor.Set(gohue.NewColor(x, y))
-		}
-		var theBrightness maybe.Uint8
-		if ibrightness != -1 {
-			if ibrightness < 0 || ibrightness > 255 {
-				return huedb.ErrBadLightColors
-			}
-			theBrightness.Set(uint8(ibrightness))
+		if current != nil && consumer.CanConsume() {
+			consumer.Consume(current)
 		}
-		lightColors[lightId] = ops.ColorBrightness{
-			Color: theColor, Brightness: theBrightness}
+		return nil
+	})
+}
+
+// namedColorsVersion returns the version of the named colors with given
+// id. ok is false if there are no such named colors.
+func namedColorsVersion(
+	conn *sqlite.Conn, id int64) (version int64, ok bool, err error) {
+	stmt, err := conn.Prepare(kSQLNamedColorsVersion)
+	if err != nil {
+		return
 	}
-	if len(lightColors) == 0 {
-		r.Colors = nil
-	} else {
-		r.Colors = lightColors
+	defer stmt.Finalize()
+	if err = stmt.Exec(id); err != nil {
+		return
 	}
-	return nil
+	if !stmt.Next() {
+		return
+	}
+	if err = stmt.Scan(&version); err != nil {
+		return
+	}
+	return version, true, nil
 }
 
-func (r *rawNamedColors) Marshall() error {
-	marshalled := make([]string, 4*len(r.Colors)+1)
-	marshalled[0] = "0"
-	var idx = 1
-	for lightId, colorBrightness := range r.Colors {
-		if lightId < 0 {
-			return huedb.ErrBadLightColors
-		}
-		var ix, iy int
-		if colorBrightness.Color.Valid {
-			x := colorBrightness.Color.X()
-			y := colorBrightness.Color.Y()
-			if x < 0.0 || x > 1.0 || y < 0.0 || y > 1.0 {
-				return huedb.ErrBadLightColors
-			}
-			ix = int(x*10000.0 + 0.5)
-			iy = int(y*10000.0 + 0.5)
-		} else {
-			ix = -1
-			iy = 0
-		}
-		var iBrightness int
-		if colorBrightness.Brightness.Valid {
-			iBrightness = int(colorBrightness.Brightness.Value)
-		} else {
-			iBrightness = -1
-		}
-		marshalled[idx] = strconv.Itoa(lightId)
-		idx++
-		marshalled[idx] = strconv.Itoa(ix)
-		idx++
-		marshalled[idx] = strconv.Itoa(iy)
-		idx++
-		marshalled[idx] = strconv.Itoa(iBrightness)
-		idx++
-	}
-	r.colors = strings.Join(marshalled, "|")
-	return nil
+// localMidnight returns midnight local time of the day of t.
+func localMidnight(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// now returns the current time to the second as the database stores
+// times to the second.
+func now() time.Time {
+	return time.Unix(time.Now().Unix(), 0)
+}
+
+type rawNamedColors struct {
+	*ops.NamedColors
+	colors    string
+	createdAt int64
+	updatedAt int64
+}
+
+func (r *rawNamedColors) init(bo *ops.NamedColors) *rawNamedColors {
+	r.NamedColors = bo
+	return r
+}
+
+func (r *rawNamedColors) ValuePtr() interface{} {
+	return r.NamedColors
+}
+
+func (r *rawNamedColors) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.colors, &r.Description, &r.createdAt, &r.updatedAt, &r.Version}
+}
+
+// Values omits created_at as updates must not change it. Adding uses
+// updated_at for created_at too.
+func (r *rawNamedColors) Values() []interface{} {
+	return []interface{}{r.colors, r.Description, r.updatedAt, r.Id}
+}
+
+func (r *rawNamedColors) Unmarshall() (err error) {
+	r.CreatedAt = columns.DecodeTime(r.createdAt)
+	r.UpdatedAt = columns.DecodeTime(r.updatedAt)
+	r.Colors, err = columns.DecodeLightColors(r.colors)
+	return
+}
+
+func (r *rawNamedColors) Marshall() (err error) {
+	r.updatedAt = columns.EncodeTime(r.UpdatedAt)
+	r.colors, err = columns.EncodeLightColors(r.Colors)
+	return
 }
 
 type rawEncodedAtTimeTask struct {
 	*huedb.EncodedAtTimeTask
-	sqlite_rw.SimpleRow
+	createdAt int64
+	updatedAt int64
 }
 
 func (r *rawEncodedAtTimeTask) init(
@@ -260,9 +669,209 @@ func (r *rawEncodedAtTimeTask) ValuePtr() interface{} {
 }
 
 func (r *rawEncodedAtTimeTask) Ptrs() []interface{} {
-	return []interface{}{&r.Id, &r.ScheduleId, &r.HueTaskId, &r.Action, &r.Description, &r.LightSet, &r.Time, &r.GroupId}
+	return []interface{}{&r.Id, &r.ScheduleId, &r.HueTaskId, &r.Action, &r.Description, &r.LightSet, &r.Time, &r.EndTime, &r.RestoreAtEnd, &r.GroupId, &r.createdAt, &r.updatedAt}
 }
 
 func (r *rawEncodedAtTimeTask) Values() []interface{} {
-	return []interface{}{r.ScheduleId, r.HueTaskId, r.Action, r.Description, r.LightSet, r.Time, r.GroupId, r.Id}
+	return []interface{}{r.ScheduleId, r.HueTaskId, r.Action, r.Description, r.LightSet, r.Time, r.EndTime, r.RestoreAtEnd, r.GroupId, r.createdAt, r.updatedAt, r.Id}
+}
+
+func (r *rawEncodedAtTimeTask) Unmarshall() error {
+	r.CreatedAt = columns.DecodeTime(r.createdAt)
+	r.UpdatedAt = columns.DecodeTime(r.updatedAt)
+	return nil
+}
+
+func (r *rawEncodedAtTimeTask) Marshall() error {
+	r.createdAt = columns.EncodeTime(r.CreatedAt)
+	r.updatedAt = columns.EncodeTime(r.UpdatedAt)
+	return nil
+}
+
+type rawEncodedScheduledTask struct {
+	*huedb.EncodedScheduledTask
+	sqlite_rw.SimpleRow
+}
+
+func (r *rawEncodedScheduledTask) init(
+	bo *huedb.EncodedScheduledTask) *rawEncodedScheduledTask {
+	r.EncodedScheduledTask = bo
+	return r
+}
+
+func (r *rawEncodedScheduledTask) ValuePtr() interface{} {
+	return r.EncodedScheduledTask
+}
+
+func (r *rawEncodedScheduledTask) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.HueTaskId, &r.Action, &r.Description, &r.LightSet, &r.Recurring, &r.Priority, &r.Enabled}
+}
+
+func (r *rawEncodedScheduledTask) Values() []interface{} {
+	return []interface{}{r.HueTaskId, r.Action, r.Description, r.LightSet, r.Recurring, r.Priority, r.Enabled, r.Id}
+}
+
+type rawScene struct {
+	*huedb.Scene
+	states string
+	tags   string
+}
+
+func (r *rawScene) init(bo *huedb.Scene) *rawScene {
+	r.Scene = bo
+	return r
+}
+
+func (r *rawScene) ValuePtr() interface{} {
+	return r.Scene
+}
+
+func (r *rawScene) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.Name, &r.states, &r.tags}
+}
+
+func (r *rawScene) Values() []interface{} {
+	return []interface{}{r.Name, r.states, r.tags, r.Id}
+}
+
+func (r *rawScene) Unmarshall() (err error) {
+	if r.States, err = columns.DecodeLightStates(r.states); err != nil {
+		return
+	}
+	r.Tags, err = columns.DecodeTags(r.tags)
+	return
+}
+
+func (r *rawScene) Marshall() (err error) {
+	if r.states, err = columns.EncodeLightStates(r.States); err != nil {
+		return
+	}
+	r.tags, err = columns.EncodeTags(r.Tags)
+	return
+}
+
+type rawLastFired struct {
+	*huedb.LastFired
+	sqlite_rw.SimpleRow
+	time int64
+}
+
+func (r *rawLastFired) init(bo *huedb.LastFired) *rawLastFired {
+	r.LastFired = bo
+	return r
+}
+
+func (r *rawLastFired) ValuePtr() interface{} {
+	return r.LastFired
+}
+
+func (r *rawLastFired) Ptrs() []interface{} {
+	return []interface{}{&r.ScheduledTaskId, &r.time}
+}
+
+func (r *rawLastFired) Unmarshall() error {
+	r.Time = time.Unix(r.time, 0)
+	return nil
+}
+
+type rawDescriptionOverride struct {
+	*huedb.DescriptionOverride
+	sqlite_rw.SimpleRow
+}
+
+func (r *rawDescriptionOverride) init(
+	bo *huedb.DescriptionOverride) *rawDescriptionOverride {
+	r.DescriptionOverride = bo
+	return r
+}
+
+func (r *rawDescriptionOverride) ValuePtr() interface{} {
+	return r.DescriptionOverride
+}
+
+func (r *rawDescriptionOverride) Ptrs() []interface{} {
+	return []interface{}{&r.HueTaskId, &r.Description}
+}
+
+type rawLastParams struct {
+	*huedb.LastParams
+	values string
+}
+
+func (r *rawLastParams) init(bo *huedb.LastParams) *rawLastParams {
+	r.LastParams = bo
+	return r
+}
+
+func (r *rawLastParams) ValuePtr() interface{} {
+	return r.LastParams
+}
+
+func (r *rawLastParams) Ptrs() []interface{} {
+	return []interface{}{&r.HueTaskId, &r.values, &r.Action, &r.Description}
+}
+
+func (r *rawLastParams) Unmarshall() (err error) {
+	r.Values, err = url.ParseQuery(r.values)
+	return
+}
+
+type rawTaskRun struct {
+	*huedb.TaskRun
+	start    int64
+	duration int64
+}
+
+func (r *rawTaskRun) init(bo *huedb.TaskRun) *rawTaskRun {
+	r.TaskRun = bo
+	return r
+}
+
+func (r *rawTaskRun) ValuePtr() interface{} {
+	return r.TaskRun
+}
+
+func (r *rawTaskRun) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.HueTaskId, &r.Description, &r.start, &r.duration}
+}
+
+func (r *rawTaskRun) Values() []interface{} {
+	return []interface{}{r.HueTaskId, r.Description, r.start, r.duration, r.Id}
+}
+
+func (r *rawTaskRun) Unmarshall() error {
+	r.Start = time.Unix(r.start, 0)
+	r.Duration = time.Duration(r.duration) * time.Millisecond
+	return nil
+}
+
+func (r *rawTaskRun) Marshall() error {
+	r.start = r.Start.Unix()
+	r.duration = int64(r.Duration / time.Millisecond)
+	return nil
+}
+
+type rawTaskStats struct {
+	*huedb.TaskStats
+	averageDuration float64
+	lastRun         int64
+}
+
+func (r *rawTaskStats) init(bo *huedb.TaskStats) *rawTaskStats {
+	r.TaskStats = bo
+	return r
+}
+
+func (r *rawTaskStats) ValuePtr() interface{} {
+	return r.TaskStats
+}
+
+func (r *rawTaskStats) Ptrs() []interface{} {
+	return []interface{}{&r.HueTaskId, &r.Description, &r.Runs, &r.averageDuration, &r.lastRun}
+}
+
+func (r *rawTaskStats) Unmarshall() error {
+	r.AverageDuration = time.Duration(r.averageDuration * float64(time.Millisecond))
+	r.LastRun = time.Unix(r.lastRun, 0)
+	return nil
 }
@@ -1,12 +1,22 @@
 package for_sqlite_test
 
 import (
+	"errors"
+	"github.com/keep94/consume"
+	"github.com/keep94/gohue"
 	"github.com/keep94/gosqlite/sqlite"
+	"github.com/keep94/marvin2/huedb"
 	"github.com/keep94/marvin2/huedb/fixture"
 	"github.com/keep94/marvin2/huedb/for_sqlite"
 	"github.com/keep94/marvin2/huedb/sqlite_setup"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/maybe"
+	"github.com/keep94/toolbox/db"
 	"github.com/keep94/toolbox/db/sqlite_db"
+	"net/url"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestNamedColorsById(t *testing.T) {
@@ -21,18 +31,383 @@ func TestNamedColors(t *testing.T) {
 	fixture.NamedColors(t, for_sqlite.New(db))
 }
 
+func TestNamedColorsByDescription(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.NamedColorsByDescription(t, for_sqlite.New(db))
+}
+
+func TestNamedColorsByExactDescription(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.NamedColorsByExactDescription(t, for_sqlite.New(db))
+}
+
+func TestNamedColorsWithOptions(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.NamedColorsWithOptions(t, for_sqlite.New(db))
+}
+
 func TestUpdateNamedColors(t *testing.T) {
 	db := openDb(t)
 	defer closeDb(t, db)
 	fixture.UpdateNamedColors(t, for_sqlite.New(db))
 }
 
+func TestConcurrentUpdateNamedColors(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.ConcurrentUpdateNamedColors(t, for_sqlite.New(db))
+}
+
 func TestRemoveNamedColors(t *testing.T) {
 	db := openDb(t)
 	defer closeDb(t, db)
 	fixture.RemoveNamedColors(t, for_sqlite.New(db))
 }
 
+func TestContexts(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.Contexts(t, for_sqlite.New(db))
+}
+
+func TestArchiveNamedColors(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.ArchiveNamedColors(t, for_sqlite.New(db))
+}
+
+func TestModifiedSince(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.ModifiedSince(t, for_sqlite.New(db))
+}
+
+func TestEncodedAtTimeTasks(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.EncodedAtTimeTasks(t, for_sqlite.New(db))
+}
+
+func TestScenes(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.Scenes(t, for_sqlite.New(db))
+}
+
+func TestDescriptionOverrides(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.DescriptionOverrides(t, for_sqlite.New(db))
+}
+
+func TestLastFired(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.LastFired(t, for_sqlite.New(db))
+}
+
+func TestLastParams(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.LastParams(t, for_sqlite.New(db))
+}
+
+func TestTaskRuns(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.TaskRuns(t, for_sqlite.New(db))
+}
+
+func TestTaskRunsPerDayLocal(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.TaskRunsPerDayLocal(t, for_sqlite.New(db))
+}
+
+func TestScheduledTasks(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	fixture.ScheduledTasks(t, for_sqlite.New(db))
+}
+
+func TestAtomically(t *testing.T) {
+	database := openDb(t)
+	defer closeDb(t, database)
+	store := for_sqlite.New(database)
+	doer := for_sqlite.NewDoer(database)
+	errStep := errors.New("step failed")
+	first := &ops.NamedColors{Description: "First"}
+	err := huedb.Atomically(
+		doer,
+		huedb.AddNamedColorsStep(store, first),
+		func(t db.Transaction) error {
+			return errStep
+		})
+	if err != errStep {
+		t.Errorf("Expected errStep, got %v", err)
+	}
+	var namedColors []*ops.NamedColors
+	if err := store.NamedColors(
+		nil, consume.AppendPtrsTo(&namedColors)); err != nil {
+		t.Fatalf("Error reading named colors: %v", err)
+	}
+	if len(namedColors) != 0 {
+		t.Errorf("Expected rollback, got %v", namedColors)
+	}
+	second := &ops.NamedColors{Description: "Second"}
+	task := &huedb.EncodedScheduledTask{
+		Action:      "ignored",
+		Description: "Second",
+		LightSet:    "All",
+		Recurring:   "7:00",
+		Enabled:     true,
+	}
+	if err := huedb.AddNamedColorsWithSchedule(
+		doer, store, second, task); err != nil {
+		t.Fatalf("Error adding: %v", err)
+	}
+	var tasks []*huedb.EncodedScheduledTask
+	if err := store.EncodedScheduledTasks(
+		nil, consume.AppendPtrsTo(&tasks)); err != nil {
+		t.Fatalf("Error reading scheduled tasks: %v", err)
+	}
+	expected := []*huedb.EncodedScheduledTask{
+		{
+			Id:          task.Id,
+			HueTaskId:   int(second.Id) + ops.PersistentTaskIdOffset,
+			Description: "Second",
+			LightSet:    "All",
+			Recurring:   "7:00",
+			Enabled:     true,
+		},
+	}
+	if !reflect.DeepEqual(expected, tasks) {
+		t.Errorf("Expected %v, got %v", expected, tasks)
+	}
+}
+
+func TestLegacyColors(t *testing.T) {
+	database := openDb(t)
+	defer closeDb(t, database)
+	err := database.Do(func(conn *sqlite.Conn) error {
+		return conn.Exec("insert into named_colors (description, colors) values ('Old', '0|3|5000|3000|98|6|-1|0|-1')")
+	})
+	if err != nil {
+		t.Fatalf("Error inserting legacy row: %v", err)
+	}
+	store := for_sqlite.New(database)
+	var namedColors ops.NamedColors
+	if err := store.NamedColorsById(nil, 1, &namedColors); err != nil {
+		t.Fatalf("Error reading legacy row: %v", err)
+	}
+	expected := &ops.NamedColors{
+		Id:          1,
+		Description: "Old",
+		Colors: ops.LightColors{
+			3: {
+				Color:      gohue.NewMaybeColor(gohue.NewColor(0.5, 0.3)),
+				Brightness: maybe.NewUint8(98),
+			},
+			6: {},
+		},
+	}
+	if !reflect.DeepEqual(expected, &namedColors) {
+		t.Errorf("Expected %v, got %v", expected, &namedColors)
+	}
+	if err := store.UpdateNamedColors(nil, &namedColors); err != nil {
+		t.Fatalf("Error updating: %v", err)
+	}
+	var colors string
+	err = database.Do(func(conn *sqlite.Conn) error {
+		stmt, err := conn.Prepare("select colors from named_colors where id = 1")
+		if err != nil {
+			return err
+		}
+		defer stmt.Finalize()
+		if err := stmt.Exec(); err != nil {
+			return err
+		}
+		if !stmt.Next() {
+			return huedb.ErrNoSuchId
+		}
+		return stmt.Scan(&colors)
+	})
+	if err != nil {
+		t.Fatalf("Error reading colors column: %v", err)
+	}
+	if expectedColors := `{"3":{"x":0.5,"y":0.3,"bri":98},"6":{}}`; colors != expectedColors {
+		t.Errorf("Expected %s, got %s", expectedColors, colors)
+	}
+	expected.Version = namedColors.Version
+	var rewritten ops.NamedColors
+	if err := store.NamedColorsById(nil, 1, &rewritten); err != nil {
+		t.Fatalf("Error reading rewritten row: %v", err)
+	}
+	if rewritten.UpdatedAt.IsZero() {
+		t.Error("Expected update to set UpdatedAt")
+	}
+	expected.UpdatedAt = rewritten.UpdatedAt
+	if !reflect.DeepEqual(expected, &rewritten) {
+		t.Errorf("Expected %v, got %v", expected, &rewritten)
+	}
+}
+
+func TestUpgradeAtTimeTasks(t *testing.T) {
+	conn, err := sqlite.Open(":memory:")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	db := sqlite_db.New(conn)
+	defer closeDb(t, db)
+	err = db.Do(func(conn *sqlite.Conn) error {
+		err := conn.Exec("create table at_time_tasks (id INTEGER PRIMARY KEY AUTOINCREMENT, schedule_id TEXT, hue_task_id INTEGER, action TEXT, description TEXT, light_set TEXT, time INTEGER, group_id TEXT)")
+		if err != nil {
+			return err
+		}
+		err = conn.Exec("insert into at_time_tasks (schedule_id, hue_task_id, action, description, light_set, time, group_id) values ('1:2:All', 1, 'a', 'b', 'All', 2, 'g')")
+		if err != nil {
+			return err
+		}
+		if err := sqlite_setup.SetUpTables(conn); err != nil {
+			return err
+		}
+		// Setting up tables again should be harmless
+		return sqlite_setup.SetUpTables(conn)
+	})
+	if err != nil {
+		t.Fatalf("Error upgrading tables: %v", err)
+	}
+	store := for_sqlite.New(db)
+	added := &huedb.EncodedAtTimeTask{
+		GroupId:      "g",
+		ScheduleId:   "3:4:All",
+		HueTaskId:    3,
+		Action:       "c",
+		Description:  "d",
+		LightSet:     "All",
+		Time:         4,
+		EndTime:      9,
+		RestoreAtEnd: true,
+	}
+	if err := store.AddEncodedAtTimeTask(nil, added); err != nil {
+		t.Fatalf("Error adding task: %v", err)
+	}
+	var tasks []*huedb.EncodedAtTimeTask
+	if err := store.EncodedAtTimeTasks(
+		nil, "g", consume.AppendPtrsTo(&tasks)); err != nil {
+		t.Fatalf("Error reading tasks: %v", err)
+	}
+	for _, task := range tasks {
+		task.CreatedAt = time.Time{}
+		task.UpdatedAt = time.Time{}
+	}
+	expected := []*huedb.EncodedAtTimeTask{
+		{
+			Id:          1,
+			GroupId:     "g",
+			ScheduleId:  "1:2:All",
+			HueTaskId:   1,
+			Action:      "a",
+			Description: "b",
+			LightSet:    "All",
+			Time:        2,
+		},
+		added,
+	}
+	if !reflect.DeepEqual(expected, tasks) {
+		t.Errorf("Expected %v, got %v", expected, tasks)
+	}
+}
+
+func TestUpgradeLastActions(t *testing.T) {
+	conn, err := sqlite.Open(":memory:")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	db := sqlite_db.New(conn)
+	defer closeDb(t, db)
+	err = db.Do(func(conn *sqlite.Conn) error {
+		statements := []string{
+			"create table last_params (hue_task_id INTEGER PRIMARY KEY, params TEXT)",
+			"create table last_actions (hue_task_id INTEGER PRIMARY KEY, action TEXT, description TEXT)",
+			"insert into last_params (hue_task_id, params) values (1, '0=5')",
+			"insert into last_params (hue_task_id, params) values (2, '0=6')",
+			"insert into last_actions (hue_task_id, action, description) values (1, 'a', 'b')",
+			"insert into last_actions (hue_task_id, action, description) values (3, 'c', 'd')",
+		}
+		for _, statement := range statements {
+			if err := conn.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return sqlite_setup.SetUpTables(conn)
+	})
+	if err != nil {
+		t.Fatalf("Error upgrading tables: %v", err)
+	}
+	store := for_sqlite.New(db)
+	expected := []huedb.LastParams{
+		{
+			HueTaskId:   1,
+			Values:      url.Values{"0": {"5"}},
+			Action:      "a",
+			Description: "b",
+		},
+		{HueTaskId: 2, Values: url.Values{"0": {"6"}}},
+		{
+			HueTaskId:   3,
+			Values:      url.Values{},
+			Action:      "c",
+			Description: "d",
+		},
+	}
+	for _, e := range expected {
+		var actual huedb.LastParams
+		if err := store.LastParams(nil, e.HueTaskId, &actual); err != nil {
+			t.Fatalf("Error reading last params: %v", err)
+		}
+		if !reflect.DeepEqual(e, actual) {
+			t.Errorf("Expected %v, got %v", e, actual)
+		}
+	}
+}
+
+func TestUpgradeScheduledTaskPriorities(t *testing.T) {
+	conn, err := sqlite.Open(":memory:")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	db := sqlite_db.New(conn)
+	defer closeDb(t, db)
+	err = db.Do(func(conn *sqlite.Conn) error {
+		statements := []string{
+			"create table scheduled_tasks (id INTEGER PRIMARY KEY AUTOINCREMENT, hue_task_id INTEGER, action TEXT, description TEXT, light_set TEXT, recurring_id INTEGER, high_priority INTEGER, enabled INTEGER)",
+			"insert into scheduled_tasks (hue_task_id, action, description, light_set, recurring_id, high_priority, enabled) values (1, 'a', 'b', 'All', 2, 1, 1)",
+			"insert into scheduled_tasks (hue_task_id, action, description, light_set, recurring_id, high_priority, enabled) values (3, 'c', 'd', 'All', 2, 0, 0)",
+		}
+		for _, statement := range statements {
+			if err := conn.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return sqlite_setup.SetUpTables(conn)
+	})
+	if err != nil {
+		t.Fatalf("Error upgrading tables: %v", err)
+	}
+	var tasks []*huedb.EncodedScheduledTask
+	if err := for_sqlite.New(db).EncodedScheduledTasks(
+		nil, consume.AppendPtrsTo(&tasks)); err != nil {
+		t.Fatalf("Error reading scheduled tasks: %v", err)
+	}
+	if len(tasks) != 2 || tasks[0].Priority != 100 || tasks[1].Priority != 0 {
+		t.Errorf("Expected priorities 100 and 0, got %v", tasks)
+	}
+}
+
 func closeDb(t *testing.T, db *sqlite_db.Db) {
 	if err := db.Close(); err != nil {
 		t.Errorf("Error closing database: %v", err)
@@ -0,0 +1,155 @@
+package for_sqlite
+
+import (
+	"errors"
+	"fmt"
+	"github.com/keep94/gosqlite/sqlite"
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/toolbox/db/sqlite_db"
+	"strings"
+	"time"
+)
+
+var (
+	// Indicates that sqlite would not switch to write-ahead logging.
+	ErrWALNotSupported = errors.New("for_sqlite: WAL not supported.")
+)
+
+// Options configures how a sqlite database is used so that the web
+// handlers and the scheduler can share it without spurious busy errors.
+type Options struct {
+	// If true, use write-ahead logging so that readers do not block the
+	// writer. Ignored for in memory databases.
+	WAL bool
+
+	// How long sqlite waits for a lock before failing with SQLITE_BUSY.
+	// 0 means fail right away.
+	BusyTimeout time.Duration
+
+	// The synchronous level e.g "OFF", "NORMAL", or "FULL". Empty means
+	// leave as is.
+	Synchronous string
+
+	// How many more times to try an operation that fails with
+	// SQLITE_BUSY. 0 means do not retry.
+	BusyRetries int
+
+	// How long to wait before each retry.
+	BusyRetryDelay time.Duration
+}
+
+// Configure applies the WAL, BusyTimeout, and Synchronous fields of
+// options to conn. Because sqlite cannot change these within a
+// transaction, call Configure on a newly opened connection before passing
+// it to sqlite_db.New.
+func Configure(conn *sqlite.Conn, options *Options) error {
+	if options.WAL {
+		if err := enableWAL(conn); err != nil {
+			return err
+		}
+	}
+	if options.BusyTimeout > 0 {
+		err := conn.BusyTimeout(int(options.BusyTimeout / time.Millisecond))
+		if err != nil {
+			return err
+		}
+	}
+	if options.Synchronous != "" {
+		if !isSynchronousLevel(options.Synchronous) {
+			return fmt.Errorf(
+				"for_sqlite: Bad synchronous level %q", options.Synchronous)
+		}
+		return conn.Exec("pragma synchronous = " + options.Synchronous)
+	}
+	return nil
+}
+
+// NewWithOptions works like New except that operations run outside a
+// transaction that fail with SQLITE_BUSY are retried according to the
+// BusyRetries and BusyRetryDelay fields of options.
+func NewWithOptions(db *sqlite_db.Db, options *Options) Store {
+	return Store{retryDoer{sqlite_db.Doer(db), *options}}
+}
+
+// NewDoerWithOptions works like NewDoer except that actions that fail with
+// SQLITE_BUSY are retried according to the BusyRetries and BusyRetryDelay
+// fields of options. Retrying reruns the entire action, so actions must
+// be safe to rerun after a rollback.
+func NewDoerWithOptions(database *sqlite_db.Db, options *Options) db.Doer {
+	return retryTransactionDoer{sqlite_db.NewDoer(database), *options}
+}
+
+// IsBusy returns true if err means that the sqlite database was locked.
+func IsBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == sqlite.ErrBusy {
+		return true
+	}
+	return strings.HasPrefix(err.Error(), sqlite.ErrBusy.Error())
+}
+
+type retryDoer struct {
+	delegate sqlite_db.Doer
+	options  Options
+}
+
+func (r retryDoer) Do(action sqlite_db.Action) error {
+	return retryBusy(&r.options, func() error {
+		return r.delegate.Do(action)
+	})
+}
+
+type retryTransactionDoer struct {
+	delegate db.Doer
+	options  Options
+}
+
+func (r retryTransactionDoer) Do(action db.Action) error {
+	return retryBusy(&r.options, func() error {
+		return r.delegate.Do(action)
+	})
+}
+
+func retryBusy(options *Options, f func() error) error {
+	err := f()
+	for i := 0; i < options.BusyRetries && IsBusy(err); i++ {
+		time.Sleep(options.BusyRetryDelay)
+		err = f()
+	}
+	return err
+}
+
+func enableWAL(conn *sqlite.Conn) error {
+	stmt, err := conn.Prepare("pragma journal_mode = WAL")
+	if err != nil {
+		return err
+	}
+	defer stmt.Finalize()
+	if err := stmt.Exec(); err != nil {
+		return err
+	}
+	if !stmt.Next() {
+		return ErrWALNotSupported
+	}
+	var mode string
+	if err := stmt.Scan(&mode); err != nil {
+		return err
+	}
+	switch strings.ToLower(mode) {
+	case "wal", "memory":
+		return nil
+	default:
+		return ErrWALNotSupported
+	}
+}
+
+func isSynchronousLevel(level string) bool {
+	switch strings.ToUpper(level) {
+	case "OFF", "NORMAL", "FULL", "EXTRA", "0", "1", "2", "3":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,91 @@
+package for_sqlite_test
+
+import (
+	"github.com/keep94/gosqlite/sqlite"
+	"github.com/keep94/marvin2/huedb/for_sqlite"
+	"github.com/keep94/marvin2/huedb/sqlite_setup"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/toolbox/db/sqlite_db"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBusyRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "for_sqlite")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "marvin.db")
+	options := &for_sqlite.Options{
+		WAL:            true,
+		Synchronous:    "NORMAL",
+		BusyRetries:    100,
+		BusyRetryDelay: 10 * time.Millisecond,
+	}
+	database := openFileDb(t, path, options)
+	defer closeDb(t, database)
+	err = database.Do(func(conn *sqlite.Conn) error {
+		return sqlite_setup.SetUpTables(conn)
+	})
+	if err != nil {
+		t.Fatalf("Error creating tables: %v", err)
+	}
+	locker, err := sqlite.Open(path)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer locker.Close()
+	if err := locker.Exec("begin exclusive"); err != nil {
+		t.Fatalf("Error locking database: %v", err)
+	}
+	if err := for_sqlite.New(database).AddNamedColors(
+		nil, &ops.NamedColors{Description: "Busy"}); !for_sqlite.IsBusy(err) {
+		t.Errorf("Expected busy error, got %v", err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		locker.Exec("commit")
+	}()
+	store := for_sqlite.NewWithOptions(database, options)
+	namedColors := &ops.NamedColors{Description: "Retried"}
+	if err := store.AddNamedColors(nil, namedColors); err != nil {
+		t.Fatalf("Expected retry to succeed, got %v", err)
+	}
+	var fetched ops.NamedColors
+	if err := store.NamedColorsById(nil, namedColors.Id, &fetched); err != nil {
+		t.Fatalf("Error reading named colors: %v", err)
+	}
+	if fetched.Description != "Retried" {
+		t.Errorf("Expected Retried, got %s", fetched.Description)
+	}
+}
+
+func TestConfigureBadSynchronous(t *testing.T) {
+	conn, err := sqlite.Open(":memory:")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer conn.Close()
+	err = for_sqlite.Configure(
+		conn, &for_sqlite.Options{Synchronous: "NORMAL; drop table x"})
+	if err == nil {
+		t.Error("Expected error for bad synchronous level")
+	}
+}
+
+func openFileDb(
+	t *testing.T, path string, options *for_sqlite.Options) *sqlite_db.Db {
+	conn, err := sqlite.Open(path)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	if err := for_sqlite.Configure(conn, options); err != nil {
+		conn.Close()
+		t.Fatalf("Error configuring database: %v", err)
+	}
+	return sqlite_db.New(conn)
+}
@@ -0,0 +1,217 @@
+// Package importv1 imports the named colors and schedules of a database
+// from the original marvin app into marvin2 so that existing users can
+// migrate without retyping everything.
+package importv1
+
+import (
+	"fmt"
+	"github.com/keep94/gosqlite/sqlite"
+	"github.com/keep94/marvin2/huedb"
+	"github.com/keep94/marvin2/huedb/internal/columns"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/toolbox/db"
+	"strconv"
+	"strings"
+)
+
+const (
+	// The original marvin app gave each named colors the hue task id
+	// kV1NamedColorsOffset plus its id.
+	kV1NamedColorsOffset = 10000
+)
+
+// v1Column is a column that Import reads from a table of the original
+// marvin app.
+type v1Column struct {
+	name string
+
+	// The SQL literal that Import uses when the table does not have this
+	// column. Empty means Import needs the column.
+	fallback string
+}
+
+var (
+	kV1NamedColors = []v1Column{
+		{name: "id"},
+		{name: "description", fallback: "''"},
+		{name: "colors"},
+	}
+	kV1AtTimeTasks = []v1Column{
+		{name: "schedule_id"},
+		{name: "hue_task_id"},
+		{name: "action", fallback: "''"},
+		{name: "description", fallback: "''"},
+		{name: "light_set"},
+		{name: "time"},
+		{name: "group_id", fallback: "''"},
+	}
+)
+
+// Config tells Import how to map a database of the original marvin app.
+type Config struct {
+	// The hue task ids of named colors in marvin2. Import always sets
+	// SkipDuplicates.
+	huedb.ImportConfig
+
+	// The group of imported at time tasks that have no group such as
+	// when the database predates groups.
+	DefaultGroupId string
+}
+
+// Import reads the named colors and at time tasks from conn, a sqlite
+// database that the original marvin app wrote, and adds them to store
+// within a single transaction of doer using huedb.ImportDump. Import skips
+// tables that conn does not have and fills in optional columns that a
+// table lacks. Import does not add named colors or at time tasks that
+// store already has, so importing the same database twice is harmless.
+// Import only reads from conn.
+func Import(
+	conn *sqlite.Conn,
+	doer db.Doer,
+	store huedb.ImportStore,
+	config *Config) error {
+	var dump huedb.Dump
+	if err := readNamedColors(conn, &dump); err != nil {
+		return err
+	}
+	if err := readAtTimeTasks(conn, config, &dump); err != nil {
+		return err
+	}
+	importConfig := config.ImportConfig
+	importConfig.SkipDuplicates = true
+	return huedb.ImportDump(doer, store, &dump, &importConfig)
+}
+
+func readNamedColors(conn *sqlite.Conn, dump *huedb.Dump) error {
+	sql, err := selectSQL(conn, "named_colors", kV1NamedColors)
+	if err != nil || sql == "" {
+		return err
+	}
+	stmt, err := conn.Prepare(sql)
+	if err != nil {
+		return err
+	}
+	defer stmt.Finalize()
+	if err := stmt.Exec(); err != nil {
+		return err
+	}
+	for stmt.Next() {
+		var id int64
+		var description, encodedColors string
+		if err := stmt.Scan(&id, &description, &encodedColors); err != nil {
+			return err
+		}
+		colors, err := columns.DecodeLightColors(encodedColors)
+		if err != nil {
+			return fmt.Errorf(
+				"importv1: Bad colors in named colors %d: %v", id, err)
+		}
+		dump.NamedColors = append(dump.NamedColors, huedb.DumpedNamedColors{
+			NamedColors: ops.NamedColors{
+				Id: id, Colors: colors, Description: description},
+		})
+	}
+	return stmt.Error()
+}
+
+func readAtTimeTasks(
+	conn *sqlite.Conn, config *Config, dump *huedb.Dump) error {
+	sql, err := selectSQL(conn, "at_time_tasks", kV1AtTimeTasks)
+	if err != nil || sql == "" {
+		return err
+	}
+	stmt, err := conn.Prepare(sql)
+	if err != nil {
+		return err
+	}
+	defer stmt.Finalize()
+	if err := stmt.Exec(); err != nil {
+		return err
+	}
+	for stmt.Next() {
+		var task huedb.EncodedAtTimeTask
+		if err := stmt.Scan(
+			&task.ScheduleId,
+			&task.HueTaskId,
+			&task.Action,
+			&task.Description,
+			&task.LightSet,
+			&task.Time,
+			&task.GroupId); err != nil {
+			return err
+		}
+		if task.GroupId == "" {
+			task.GroupId = config.DefaultGroupId
+		}
+		if task.HueTaskId >= kV1NamedColorsOffset {
+			newId := config.NamedColors.Global(
+				int64(task.HueTaskId - kV1NamedColorsOffset))
+			task.ScheduleId = fixScheduleId(
+				task.ScheduleId, task.HueTaskId, newId)
+			task.HueTaskId = newId
+		}
+		dump.AtTimeTasks = append(dump.AtTimeTasks, task)
+	}
+	return stmt.Error()
+}
+
+// fixScheduleId changes the hue task id that starts scheduleId from
+// oldId to newId.
+func fixScheduleId(scheduleId string, oldId, newId int) string {
+	prefix := strconv.Itoa(oldId) + ":"
+	if !strings.HasPrefix(scheduleId, prefix) {
+		return scheduleId
+	}
+	return strconv.Itoa(newId) + ":" + scheduleId[len(prefix):]
+}
+
+// selectSQL returns the SQL that reads wantColumns from table in order
+// ordered by rowid. selectSQL returns the empty string if conn does not
+// have table.
+func selectSQL(
+	conn *sqlite.Conn, table string, wantColumns []v1Column) (
+	string, error) {
+	existing, err := tableColumns(conn, table)
+	if err != nil || len(existing) == 0 {
+		return "", err
+	}
+	selected := make([]string, len(wantColumns))
+	for i, column := range wantColumns {
+		switch {
+		case existing[column.name]:
+			selected[i] = column.name
+		case column.fallback != "":
+			selected[i] = column.fallback
+		default:
+			return "", fmt.Errorf(
+				"importv1: Table %s has no %s column", table, column.name)
+		}
+	}
+	return fmt.Sprintf(
+		"select %s from %s order by rowid",
+		strings.Join(selected, ", "),
+		table), nil
+}
+
+// tableColumns returns the names of the columns in table. tableColumns
+// returns no columns if conn does not have table.
+func tableColumns(conn *sqlite.Conn, table string) (map[string]bool, error) {
+	stmt, err := conn.Prepare(fmt.Sprintf("pragma table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Finalize()
+	if err := stmt.Exec(); err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool)
+	for stmt.Next() {
+		var cid, name, columnType, notNull, defaultValue, pk string
+		if err := stmt.Scan(
+			&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		result[strings.ToLower(name)] = true
+	}
+	return result, stmt.Error()
+}
@@ -0,0 +1,215 @@
+package importv1_test
+
+import (
+	"github.com/keep94/consume"
+	"github.com/keep94/gohue"
+	"github.com/keep94/gosqlite/sqlite"
+	"github.com/keep94/marvin2/huedb"
+	"github.com/keep94/marvin2/huedb/for_sqlite"
+	"github.com/keep94/marvin2/huedb/importv1"
+	"github.com/keep94/marvin2/huedb/sqlite_setup"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/maybe"
+	"github.com/keep94/toolbox/db/sqlite_db"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	kConfig = &importv1.Config{
+		ImportConfig: huedb.ImportConfig{
+			NamedColors: ops.IdRange{
+				Name:  ops.PersistentIdRange,
+				Start: 20000,
+				End:   30000,
+			},
+		},
+		DefaultGroupId: "default",
+	}
+)
+
+func TestImport(t *testing.T) {
+	conn := openV1(t,
+		"create table named_colors (id INTEGER PRIMARY KEY AUTOINCREMENT, description TEXT, colors TEXT)",
+		"create table at_time_tasks (id INTEGER PRIMARY KEY AUTOINCREMENT, schedule_id TEXT, hue_task_id INTEGER, action TEXT, description TEXT, light_set TEXT, time INTEGER, group_id TEXT)",
+		"insert into named_colors (id, description, colors) values (4, 'Old', '0|3|5000|3000|98|6|-1|0|-1')",
+		"insert into at_time_tasks (schedule_id, hue_task_id, action, description, light_set, time, group_id) values ('"+strconv.Itoa(ops.PersistentTaskIdOffset+4)+":2:All', "+strconv.Itoa(ops.PersistentTaskIdOffset+4)+", '', 'Old', 'All', 2, 'g')",
+		"insert into at_time_tasks (schedule_id, hue_task_id, action, description, light_set, time, group_id) values ('7:3:1,2', 7, 'a', 'Seven', '1,2', 3, 'g')")
+	defer conn.Close()
+	dbase := openDb(t)
+	defer closeDb(t, dbase)
+	store := for_sqlite.New(dbase)
+	if err := store.AddNamedColors(
+		nil, &ops.NamedColors{Description: "Existing"}); err != nil {
+		t.Fatalf("Error adding named colors: %v", err)
+	}
+	importTwice(t, conn, dbase)
+	var namedColors ops.NamedColors
+	if err := store.NamedColorsById(nil, 2, &namedColors); err != nil {
+		t.Fatalf("Error reading named colors: %v", err)
+	}
+	expectedColors := ops.LightColors{
+		3: {
+			Color:      gohue.NewMaybeColor(gohue.NewColor(0.5, 0.3)),
+			Brightness: maybe.NewUint8(98),
+		},
+		6: {},
+	}
+	if namedColors.Description != "Old" ||
+		!reflect.DeepEqual(expectedColors, namedColors.Colors) {
+		t.Errorf("Got %v", &namedColors)
+	}
+	var tasks []*huedb.EncodedAtTimeTask
+	if err := store.EncodedAtTimeTasks(
+		nil, "g", consume.AppendPtrsTo(&tasks)); err != nil {
+		t.Fatalf("Error reading tasks: %v", err)
+	}
+	newHueTaskId := kConfig.NamedColors.Global(2)
+	expected := []*huedb.EncodedAtTimeTask{
+		{
+			Id:          1,
+			GroupId:     "g",
+			ScheduleId:  strconv.Itoa(newHueTaskId) + ":2:All",
+			HueTaskId:   newHueTaskId,
+			Description: "Old",
+			LightSet:    "All",
+			Time:        2,
+		},
+		{
+			Id:          2,
+			GroupId:     "g",
+			ScheduleId:  "7:3:1,2",
+			HueTaskId:   7,
+			Action:      "a",
+			Description: "Seven",
+			LightSet:    "1,2",
+			Time:        3,
+		},
+	}
+	for _, task := range tasks {
+		task.CreatedAt = time.Time{}
+		task.UpdatedAt = time.Time{}
+	}
+	if !reflect.DeepEqual(expected, tasks) {
+		t.Errorf("Expected %v, got %v", expected, tasks)
+	}
+}
+
+func TestImportOldSchema(t *testing.T) {
+	conn := openV1(t,
+		"create table named_colors (id INTEGER PRIMARY KEY AUTOINCREMENT, colors TEXT)",
+		"create table at_time_tasks (id INTEGER PRIMARY KEY AUTOINCREMENT, schedule_id TEXT, hue_task_id INTEGER, light_set TEXT, time INTEGER)",
+		"insert into named_colors (id, colors) values (4, '0|3|5000|3000|98|6|-1|0|-1')",
+		"insert into at_time_tasks (schedule_id, hue_task_id, light_set, time) values ('"+strconv.Itoa(ops.PersistentTaskIdOffset+4)+":2:All', "+strconv.Itoa(ops.PersistentTaskIdOffset+4)+", 'All', 2)")
+	defer conn.Close()
+	dbase := openDb(t)
+	defer closeDb(t, dbase)
+	importTwice(t, conn, dbase)
+	var tasks []*huedb.EncodedAtTimeTask
+	if err := for_sqlite.New(dbase).EncodedAtTimeTasks(
+		nil, kConfig.DefaultGroupId, consume.AppendPtrsTo(&tasks)); err != nil {
+		t.Fatalf("Error reading tasks: %v", err)
+	}
+	newHueTaskId := kConfig.NamedColors.Global(1)
+	if len(tasks) != 1 ||
+		tasks[0].HueTaskId != newHueTaskId ||
+		tasks[0].ScheduleId != strconv.Itoa(newHueTaskId)+":2:All" {
+		t.Errorf("Got %v", tasks)
+	}
+}
+
+func TestImportMissingColumn(t *testing.T) {
+	conn := openV1(t,
+		"create table named_colors (id INTEGER PRIMARY KEY AUTOINCREMENT, description TEXT)")
+	defer conn.Close()
+	dbase := openDb(t)
+	defer closeDb(t, dbase)
+	if err := importv1.Import(
+		conn,
+		for_sqlite.NewDoer(dbase),
+		for_sqlite.New(dbase),
+		kConfig); err == nil {
+		t.Error("Expected error importing without colors column")
+	}
+}
+
+func TestImportMissingTables(t *testing.T) {
+	conn := openV1(t)
+	defer conn.Close()
+	dbase := openDb(t)
+	defer closeDb(t, dbase)
+	if err := importv1.Import(
+		conn,
+		for_sqlite.NewDoer(dbase),
+		for_sqlite.New(dbase),
+		kConfig); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestImportBadColors(t *testing.T) {
+	conn := openV1(t,
+		"create table named_colors (id INTEGER PRIMARY KEY AUTOINCREMENT, description TEXT, colors TEXT)",
+		"insert into named_colors (description, colors) values ('Bad', 'garbage')")
+	defer conn.Close()
+	dbase := openDb(t)
+	defer closeDb(t, dbase)
+	if err := importv1.Import(
+		conn,
+		for_sqlite.NewDoer(dbase),
+		for_sqlite.New(dbase),
+		kConfig); err == nil {
+		t.Error("Expected error importing bad colors")
+	}
+}
+
+// importTwice imports conn into dbase twice as the second import must
+// add nothing.
+func importTwice(t *testing.T, conn *sqlite.Conn, dbase *sqlite_db.Db) {
+	for i := 0; i < 2; i++ {
+		if err := importv1.Import(
+			conn,
+			for_sqlite.NewDoer(dbase),
+			for_sqlite.New(dbase),
+			kConfig); err != nil {
+			t.Fatalf("Error importing: %v", err)
+		}
+	}
+}
+
+func openDb(t *testing.T) *sqlite_db.Db {
+	conn, err := sqlite.Open(":memory:")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	dbase := sqlite_db.New(conn)
+	err = dbase.Do(func(conn *sqlite.Conn) error {
+		return sqlite_setup.SetUpTables(conn)
+	})
+	if err != nil {
+		t.Fatalf("Error creating tables: %v", err)
+	}
+	return dbase
+}
+
+func closeDb(t *testing.T, dbase *sqlite_db.Db) {
+	if err := dbase.Close(); err != nil {
+		t.Errorf("Error closing database: %v", err)
+	}
+}
+
+func openV1(t *testing.T, statements ...string) *sqlite.Conn {
+	conn, err := sqlite.Open(":memory:")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	for _, statement := range statements {
+		if err := conn.Exec(statement); err != nil {
+			conn.Close()
+			t.Fatalf("Error setting up database: %v", err)
+		}
+	}
+	return conn
+}
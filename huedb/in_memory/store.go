@@ -0,0 +1,747 @@
+// Package in_memory provides an in memory implementation of interfaces in
+// huedb package. in_memory is for tests and for apps that don't need to
+// persist anything.
+package in_memory
+
+import (
+	"github.com/keep94/consume"
+	"github.com/keep94/marvin2/huedb"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/toolbox/db"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store implements all the store interfaces in the huedb package.
+// Store ignores the transaction passed to its methods. Each method
+// of Store is atomic on its own, but Store does not support running
+// multiple methods in a single transaction. Store instances are safe to
+// use with multiple goroutines. The zero value is an empty Store ready
+// to use.
+type Store struct {
+	mu               sync.Mutex
+	namedColors      []*ops.NamedColors
+	archived         map[int64]bool
+	atTimeTasks      []*huedb.EncodedAtTimeTask
+	scheduledTasks   []*huedb.EncodedScheduledTask
+	scenes           []*huedb.Scene
+	lastFired        map[int]huedb.LastFired
+	lastParams       map[int]huedb.LastParams
+	descriptions     map[int]string
+	taskRuns         []*huedb.TaskRun
+	lastNamedColorId int64
+	lastAtTimeTaskId int64
+	lastScheduledId  int64
+	lastSceneId      int64
+	lastTaskRunId    int64
+}
+
+// New returns a new, empty Store.
+func New() *Store {
+	return &Store{}
+}
+
+func (s *Store) NamedColorsById(
+	t db.Transaction, id int64, namedColors *ops.NamedColors) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.namedColorsIndex(id)
+	if idx == -1 {
+		return huedb.ErrNoSuchId
+	}
+	copyNamedColors(namedColors, s.namedColors[idx])
+	return nil
+}
+
+func (s *Store) NamedColors(
+	t db.Transaction, consumer consume.Consumer) error {
+	return s.consumeNamedColors("", false, consumer)
+}
+
+func (s *Store) NamedColorsByDescription(
+	t db.Transaction, query string, consumer consume.Consumer) error {
+	return s.consumeNamedColors(query, false, consumer)
+}
+
+func (s *Store) NamedColorsByExactDescription(
+	t db.Transaction, description string, consumer consume.Consumer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, namedColors := range s.namedColors {
+		if !consumer.CanConsume() {
+			break
+		}
+		if s.archived[namedColors.Id] ||
+			!strings.EqualFold(namedColors.Description, description) {
+			continue
+		}
+		var copied ops.NamedColors
+		copyNamedColors(&copied, namedColors)
+		consumer.Consume(&copied)
+	}
+	return nil
+}
+
+func (s *Store) NamedColorsModifiedSince(
+	t db.Transaction, since time.Time, consumer consume.Consumer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matches []*ops.NamedColors
+	for _, namedColors := range s.namedColors {
+		if !s.archived[namedColors.Id] && !namedColors.UpdatedAt.Before(since) {
+			matches = append(matches, namedColors)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if !matches[i].UpdatedAt.Equal(matches[j].UpdatedAt) {
+			return matches[i].UpdatedAt.After(matches[j].UpdatedAt)
+		}
+		return matches[i].Id > matches[j].Id
+	})
+	for _, match := range matches {
+		if !consumer.CanConsume() {
+			break
+		}
+		var namedColors ops.NamedColors
+		copyNamedColors(&namedColors, match)
+		consumer.Consume(&namedColors)
+	}
+	return nil
+}
+
+func (s *Store) NamedColorsWithOptions(
+	t db.Transaction,
+	options *huedb.NamedColorsOptions,
+	consumer consume.Consumer) error {
+	end := int(^uint(0) >> 1)
+	if options.Limit > 0 {
+		end = options.Offset + options.Limit
+	}
+	return s.consumeNamedColors(
+		options.Description,
+		options.OrderByDescription,
+		consume.Slice(consumer, options.Offset, end))
+}
+
+func (s *Store) AddNamedColors(
+	t db.Transaction, namedColors *ops.NamedColors) error {
+	if err := checkLightColors(namedColors.Colors); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastNamedColorId++
+	namedColors.Id = s.lastNamedColorId
+	namedColors.Version = 1
+	var stored ops.NamedColors
+	copyNamedColors(&stored, namedColors)
+	stored.CreatedAt = time.Now()
+	stored.UpdatedAt = stored.CreatedAt
+	s.namedColors = append(s.namedColors, &stored)
+	return nil
+}
+
+func (s *Store) UpdateNamedColors(
+	t db.Transaction, namedColors *ops.NamedColors) error {
+	if err := checkLightColors(namedColors.Colors); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idx := s.namedColorsIndex(namedColors.Id); idx != -1 {
+		version := s.namedColors[idx].Version
+		if namedColors.Version != 0 && version != namedColors.Version {
+			return huedb.ErrConcurrentModification
+		}
+		namedColors.Version = version + 1
+		var stored ops.NamedColors
+		copyNamedColors(&stored, namedColors)
+		stored.CreatedAt = s.namedColors[idx].CreatedAt
+		stored.UpdatedAt = time.Now()
+		s.namedColors[idx] = &stored
+	}
+	return nil
+}
+
+func (s *Store) RemoveNamedColors(t db.Transaction, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idx := s.namedColorsIndex(id); idx != -1 {
+		s.namedColors = append(s.namedColors[:idx], s.namedColors[idx+1:]...)
+	}
+	delete(s.archived, id)
+	return nil
+}
+
+func (s *Store) ArchiveNamedColors(t db.Transaction, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.namedColorsIndex(id) == -1 {
+		return nil
+	}
+	if s.archived == nil {
+		s.archived = make(map[int64]bool)
+	}
+	s.archived[id] = true
+	return nil
+}
+
+func (s *Store) RestoreNamedColors(t db.Transaction, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.archived, id)
+	return nil
+}
+
+func (s *Store) ArchivedNamedColors(
+	t db.Transaction, consumer consume.Consumer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, namedColors := range s.namedColors {
+		if !consumer.CanConsume() {
+			break
+		}
+		if s.archived[namedColors.Id] {
+			var namedColorsCopy ops.NamedColors
+			copyNamedColors(&namedColorsCopy, namedColors)
+			consumer.Consume(&namedColorsCopy)
+		}
+	}
+	return nil
+}
+
+func (s *Store) EncodedAtTimeTasks(
+	t db.Transaction, groupId string, consumer consume.Consumer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, task := range s.atTimeTasks {
+		if !consumer.CanConsume() {
+			break
+		}
+		if task.GroupId == groupId {
+			taskCopy := *task
+			consumer.Consume(&taskCopy)
+		}
+	}
+	return nil
+}
+
+func (s *Store) EncodedAtTimeTasksModifiedSince(
+	t db.Transaction,
+	groupId string,
+	since time.Time,
+	consumer consume.Consumer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matches []*huedb.EncodedAtTimeTask
+	for _, task := range s.atTimeTasks {
+		if task.GroupId == groupId && !task.UpdatedAt.Before(since) {
+			matches = append(matches, task)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if !matches[i].UpdatedAt.Equal(matches[j].UpdatedAt) {
+			return matches[i].UpdatedAt.After(matches[j].UpdatedAt)
+		}
+		return matches[i].Id > matches[j].Id
+	})
+	for _, match := range matches {
+		if !consumer.CanConsume() {
+			break
+		}
+		taskCopy := *match
+		consumer.Consume(&taskCopy)
+	}
+	return nil
+}
+
+func (s *Store) AddEncodedAtTimeTask(
+	t db.Transaction, task *huedb.EncodedAtTimeTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastAtTimeTaskId++
+	task.Id = s.lastAtTimeTaskId
+	stored := *task
+	stored.CreatedAt = time.Now()
+	stored.UpdatedAt = stored.CreatedAt
+	s.atTimeTasks = append(s.atTimeTasks, &stored)
+	return nil
+}
+
+func (s *Store) AddEncodedAtTimeTasks(
+	t db.Transaction, tasks []*huedb.EncodedAtTimeTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	createdAt := time.Now()
+	for _, task := range tasks {
+		s.lastAtTimeTaskId++
+		task.Id = s.lastAtTimeTaskId
+		stored := *task
+		stored.CreatedAt = createdAt
+		stored.UpdatedAt = createdAt
+		s.atTimeTasks = append(s.atTimeTasks, &stored)
+	}
+	return nil
+}
+
+func (s *Store) RemoveEncodedAtTimeTaskByScheduleId(
+	t db.Transaction, groupId, scheduleId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kept []*huedb.EncodedAtTimeTask
+	for _, task := range s.atTimeTasks {
+		if task.GroupId != groupId || task.ScheduleId != scheduleId {
+			kept = append(kept, task)
+		}
+	}
+	s.atTimeTasks = kept
+	return nil
+}
+
+func (s *Store) RemoveEncodedAtTimeTasksBefore(
+	t db.Transaction, before int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kept []*huedb.EncodedAtTimeTask
+	for _, task := range s.atTimeTasks {
+		if !task.EndedBefore(before) {
+			kept = append(kept, task)
+		}
+	}
+	s.atTimeTasks = kept
+	return nil
+}
+
+func (s *Store) ClearEncodedAtTimeTasks(t db.Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.atTimeTasks = nil
+	return nil
+}
+
+func (s *Store) EncodedScheduledTasks(
+	t db.Transaction, consumer consume.Consumer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, task := range s.scheduledTasks {
+		if !consumer.CanConsume() {
+			break
+		}
+		taskCopy := *task
+		consumer.Consume(&taskCopy)
+	}
+	return nil
+}
+
+func (s *Store) AddEncodedScheduledTask(
+	t db.Transaction, task *huedb.EncodedScheduledTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastScheduledId++
+	task.Id = s.lastScheduledId
+	stored := *task
+	s.scheduledTasks = append(s.scheduledTasks, &stored)
+	return nil
+}
+
+func (s *Store) UpdateEncodedScheduledTask(
+	t db.Transaction, task *huedb.EncodedScheduledTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idx := s.scheduledTaskIndex(task.Id); idx != -1 {
+		stored := *task
+		s.scheduledTasks[idx] = &stored
+	}
+	return nil
+}
+
+func (s *Store) EnableEncodedScheduledTask(
+	t db.Transaction, id int64, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idx := s.scheduledTaskIndex(id); idx != -1 {
+		stored := *s.scheduledTasks[idx]
+		stored.Enabled = enabled
+		s.scheduledTasks[idx] = &stored
+	}
+	return nil
+}
+
+func (s *Store) RemoveEncodedScheduledTask(t db.Transaction, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idx := s.scheduledTaskIndex(id); idx != -1 {
+		s.scheduledTasks = append(
+			s.scheduledTasks[:idx], s.scheduledTasks[idx+1:]...)
+	}
+	return nil
+}
+
+func (s *Store) SceneById(
+	t db.Transaction, id int64, scene *huedb.Scene) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.sceneIndex(id)
+	if idx == -1 {
+		return huedb.ErrNoSuchId
+	}
+	copyScene(scene, s.scenes[idx])
+	return nil
+}
+
+func (s *Store) Scenes(t db.Transaction, consumer consume.Consumer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, scene := range s.scenes {
+		if !consumer.CanConsume() {
+			break
+		}
+		var sceneCopy huedb.Scene
+		copyScene(&sceneCopy, scene)
+		consumer.Consume(&sceneCopy)
+	}
+	return nil
+}
+
+func (s *Store) AddScene(t db.Transaction, scene *huedb.Scene) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSceneId++
+	scene.Id = s.lastSceneId
+	var stored huedb.Scene
+	copyScene(&stored, scene)
+	s.scenes = append(s.scenes, &stored)
+	return nil
+}
+
+func (s *Store) UpdateScene(t db.Transaction, scene *huedb.Scene) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idx := s.sceneIndex(scene.Id); idx != -1 {
+		var stored huedb.Scene
+		copyScene(&stored, scene)
+		s.scenes[idx] = &stored
+	}
+	return nil
+}
+
+func (s *Store) RemoveScene(t db.Transaction, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idx := s.sceneIndex(id); idx != -1 {
+		s.scenes = append(s.scenes[:idx], s.scenes[idx+1:]...)
+	}
+	return nil
+}
+
+func (s *Store) LastFired(
+	t db.Transaction, scheduledTaskId int, fired *huedb.LastFired) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result, ok := s.lastFired[scheduledTaskId]
+	if !ok {
+		return huedb.ErrNoSuchId
+	}
+	*fired = result
+	return nil
+}
+
+func (s *Store) SaveLastFired(
+	t db.Transaction, fired *huedb.LastFired) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastFired == nil {
+		s.lastFired = make(map[int]huedb.LastFired)
+	}
+	result := *fired
+	result.Time = time.Unix(fired.Time.Unix(), 0)
+	s.lastFired[fired.ScheduledTaskId] = result
+	return nil
+}
+
+func (s *Store) LastParams(
+	t db.Transaction, hueTaskId int, params *huedb.LastParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result, ok := s.lastParams[hueTaskId]
+	if !ok {
+		return huedb.ErrNoSuchId
+	}
+	*params = result
+	params.Values = copyValues(result.Values)
+	return nil
+}
+
+func (s *Store) SaveLastParams(
+	t db.Transaction, params *huedb.LastParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastParams == nil {
+		s.lastParams = make(map[int]huedb.LastParams)
+	}
+	saved := *params
+	saved.Values = copyValues(params.Values)
+	s.lastParams[params.HueTaskId] = saved
+	return nil
+}
+
+func (s *Store) DescriptionOverride(
+	t db.Transaction,
+	hueTaskId int,
+	override *huedb.DescriptionOverride) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	description, ok := s.descriptions[hueTaskId]
+	if !ok {
+		return huedb.ErrNoSuchId
+	}
+	*override = huedb.DescriptionOverride{
+		HueTaskId: hueTaskId, Description: description}
+	return nil
+}
+
+func (s *Store) DescriptionOverrides(
+	t db.Transaction, consumer consume.Consumer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hueTaskIds := make([]int, 0, len(s.descriptions))
+	for hueTaskId := range s.descriptions {
+		hueTaskIds = append(hueTaskIds, hueTaskId)
+	}
+	sort.Ints(hueTaskIds)
+	for _, hueTaskId := range hueTaskIds {
+		if !consumer.CanConsume() {
+			break
+		}
+		consumer.Consume(&huedb.DescriptionOverride{
+			HueTaskId: hueTaskId, Description: s.descriptions[hueTaskId]})
+	}
+	return nil
+}
+
+func (s *Store) SaveDescriptionOverride(
+	t db.Transaction, override *huedb.DescriptionOverride) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.descriptions == nil {
+		s.descriptions = make(map[int]string)
+	}
+	s.descriptions[override.HueTaskId] = override.Description
+	return nil
+}
+
+func (s *Store) RemoveDescriptionOverride(
+	t db.Transaction, hueTaskId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.descriptions, hueTaskId)
+	return nil
+}
+
+func (s *Store) AddTaskRun(t db.Transaction, run *huedb.TaskRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastTaskRunId++
+	run.Id = s.lastTaskRunId
+	stored := *run
+	s.taskRuns = append(s.taskRuns, &stored)
+	return nil
+}
+
+func (s *Store) LastTaskRun(
+	t db.Transaction, hueTaskId int, run *huedb.TaskRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result *huedb.TaskRun
+	for _, stored := range s.taskRuns {
+		if stored.HueTaskId != hueTaskId {
+			continue
+		}
+		if result == nil || !stored.Start.Before(result.Start) {
+			result = stored
+		}
+	}
+	if result == nil {
+		return huedb.ErrNoSuchId
+	}
+	*run = *result
+	return nil
+}
+
+func (s *Store) TaskStats(
+	t db.Transaction, since time.Time, consumer consume.Consumer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statsById := make(map[int]*huedb.TaskStats)
+	totals := make(map[int]time.Duration)
+	var stats []*huedb.TaskStats
+	for _, run := range s.taskRuns {
+		if run.Start.Before(since) {
+			continue
+		}
+		stat := statsById[run.HueTaskId]
+		if stat == nil {
+			stat = &huedb.TaskStats{HueTaskId: run.HueTaskId}
+			statsById[run.HueTaskId] = stat
+			stats = append(stats, stat)
+		}
+		stat.Runs++
+		totals[run.HueTaskId] += run.Duration
+		if !run.Start.Before(stat.LastRun) {
+			stat.LastRun = run.Start
+			stat.Description = run.Description
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Runs != stats[j].Runs {
+			return stats[i].Runs > stats[j].Runs
+		}
+		return stats[i].HueTaskId < stats[j].HueTaskId
+	})
+	for _, stat := range stats {
+		if !consumer.CanConsume() {
+			break
+		}
+		stat.AverageDuration = totals[stat.HueTaskId] / time.Duration(stat.Runs)
+		consumer.Consume(stat)
+	}
+	return nil
+}
+
+func (s *Store) TaskRunsPerDay(
+	t db.Transaction, since time.Time, consumer consume.Consumer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runsByDay := make(map[time.Time]int64)
+	var days []time.Time
+	for _, run := range s.taskRuns {
+		if run.Start.Before(since) {
+			continue
+		}
+		start := run.Start.Local()
+		day := time.Date(
+			start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.Local)
+		if runsByDay[day] == 0 {
+			days = append(days, day)
+		}
+		runsByDay[day]++
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	for _, day := range days {
+		if !consumer.CanConsume() {
+			break
+		}
+		consumer.Consume(&huedb.DailyRuns{Day: day, Runs: runsByDay[day]})
+	}
+	return nil
+}
+
+func (s *Store) consumeNamedColors(
+	query string, orderByDescription bool, consumer consume.Consumer) error {
+	query = strings.ToLower(query)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matches []*ops.NamedColors
+	for _, namedColors := range s.namedColors {
+		if s.archived[namedColors.Id] {
+			continue
+		}
+		if strings.Contains(strings.ToLower(namedColors.Description), query) {
+			matches = append(matches, namedColors)
+		}
+	}
+	if orderByDescription {
+		sort.SliceStable(matches, func(i, j int) bool {
+			return strings.ToLower(matches[i].Description) <
+				strings.ToLower(matches[j].Description)
+		})
+	}
+	for _, match := range matches {
+		if !consumer.CanConsume() {
+			break
+		}
+		var namedColors ops.NamedColors
+		copyNamedColors(&namedColors, match)
+		consumer.Consume(&namedColors)
+	}
+	return nil
+}
+
+func (s *Store) namedColorsIndex(id int64) int {
+	for i := range s.namedColors {
+		if s.namedColors[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Store) scheduledTaskIndex(id int64) int {
+	for i := range s.scheduledTasks {
+		if s.scheduledTasks[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Store) sceneIndex(id int64) int {
+	for i := range s.scenes {
+		if s.scenes[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkLightColors returns huedb.ErrBadLightColors if colors has values
+// that the database backends reject.
+func checkLightColors(colors ops.LightColors) error {
+	for lightId, colorBrightness := range colors {
+		if lightId < 0 {
+			return huedb.ErrBadLightColors
+		}
+		if colorBrightness.Color.Valid {
+			x := colorBrightness.Color.X()
+			y := colorBrightness.Color.Y()
+			if x < 0.0 || x > 1.0 || y < 0.0 || y > 1.0 {
+				return huedb.ErrBadLightColors
+			}
+		}
+	}
+	return nil
+}
+
+func copyNamedColors(dest, src *ops.NamedColors) {
+	*dest = *src
+	if src.Colors != nil {
+		dest.Colors = make(ops.LightColors, len(src.Colors))
+		for lightId, colorBrightness := range src.Colors {
+			dest.Colors[lightId] = colorBrightness
+		}
+	}
+}
+
+func copyScene(dest, src *huedb.Scene) {
+	*dest = *src
+	if src.States != nil {
+		dest.States = make(ops.LightStates, len(src.States))
+		for lightId, state := range src.States {
+			dest.States[lightId] = state
+		}
+	}
+	if src.Tags != nil {
+		dest.Tags = append([]string(nil), src.Tags...)
+	}
+}
+
+func copyValues(values url.Values) url.Values {
+	result := make(url.Values, len(values))
+	for key, value := range values {
+		result[key] = append([]string(nil), value...)
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+package in_memory_test
+
+import (
+	"github.com/keep94/marvin2/huedb/fixture"
+	"github.com/keep94/marvin2/huedb/in_memory"
+	"testing"
+)
+
+func TestNamedColorsById(t *testing.T) {
+	fixture.NamedColorsById(t, in_memory.New())
+}
+
+func TestNamedColors(t *testing.T) {
+	fixture.NamedColors(t, in_memory.New())
+}
+
+func TestNamedColorsByDescription(t *testing.T) {
+	fixture.NamedColorsByDescription(t, in_memory.New())
+}
+
+func TestNamedColorsByExactDescription(t *testing.T) {
+	fixture.NamedColorsByExactDescription(t, in_memory.New())
+}
+
+func TestNamedColorsWithOptions(t *testing.T) {
+	fixture.NamedColorsWithOptions(t, in_memory.New())
+}
+
+func TestUpdateNamedColors(t *testing.T) {
+	fixture.UpdateNamedColors(t, in_memory.New())
+}
+
+func TestConcurrentUpdateNamedColors(t *testing.T) {
+	fixture.ConcurrentUpdateNamedColors(t, in_memory.New())
+}
+
+func TestRemoveNamedColors(t *testing.T) {
+	fixture.RemoveNamedColors(t, in_memory.New())
+}
+
+func TestArchiveNamedColors(t *testing.T) {
+	fixture.ArchiveNamedColors(t, in_memory.New())
+}
+
+func TestModifiedSince(t *testing.T) {
+	fixture.ModifiedSince(t, in_memory.New())
+}
+
+func TestEncodedAtTimeTasks(t *testing.T) {
+	fixture.EncodedAtTimeTasks(t, in_memory.New())
+}
+
+func TestScenes(t *testing.T) {
+	fixture.Scenes(t, in_memory.New())
+}
+
+func TestDescriptionOverrides(t *testing.T) {
+	fixture.DescriptionOverrides(t, in_memory.New())
+}
+
+func TestLastFired(t *testing.T) {
+	fixture.LastFired(t, in_memory.New())
+}
+
+func TestContexts(t *testing.T) {
+	fixture.Contexts(t, in_memory.New())
+}
+
+func TestLastParams(t *testing.T) {
+	fixture.LastParams(t, in_memory.New())
+}
+
+func TestTaskRuns(t *testing.T) {
+	fixture.TaskRuns(t, in_memory.New())
+}
+
+func TestTaskRunsPerDayLocal(t *testing.T) {
+	fixture.TaskRunsPerDayLocal(t, in_memory.New())
+}
+
+func TestScheduledTasks(t *testing.T) {
+	fixture.ScheduledTasks(t, in_memory.New())
+}
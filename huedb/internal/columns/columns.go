@@ -0,0 +1,175 @@
+// Package columns encodes the values of database columns that all
+// backends of the huedb package store the same way.
+package columns
+
+import (
+	"encoding/json"
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/huedb"
+	"github.com/keep94/marvin2/huedb/internal/lightjson"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/maybe"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var kLikeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// EscapeLike escapes s so that a like clause with escape '\' matches
+// s literally.
+func EscapeLike(s string) string {
+	return kLikeEscaper.Replace(s)
+}
+
+// EncodeTime encodes t as seconds after Jan 1 1970 GMT. EncodeTime encodes
+// the zero time as 0.
+func EncodeTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// DecodeTime reverses EncodeTime.
+func DecodeTime(secs int64) time.Time {
+	if secs == 0 {
+		return time.Time{}
+	}
+	return time.Unix(secs, 0)
+}
+
+// EncodeLightColors encodes colors for a named_colors colors column.
+// The encoding is a JSON object keyed by light id so that new fields can
+// be added later without breaking old readers.
+func EncodeLightColors(colors ops.LightColors) (string, error) {
+	converted, ok := lightjson.FromColors(colors)
+	if !ok {
+		return "", huedb.ErrBadLightColors
+	}
+	encoded, err := json.Marshal(converted)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// DecodeLightColors decodes a named_colors colors column. DecodeLightColors
+// also decodes the "0|id|x|y|bri|..." encoding that earlier versions
+// wrote so that old rows keep working until they are next updated.
+func DecodeLightColors(s string) (ops.LightColors, error) {
+	if strings.HasPrefix(s, "{") {
+		var converted map[int]lightjson.ColorBrightness
+		if err := json.Unmarshal([]byte(s), &converted); err != nil {
+			return nil, err
+		}
+		colors, ok := lightjson.ToColors(converted)
+		if !ok {
+			return nil, huedb.ErrBadLightColors
+		}
+		return colors, nil
+	}
+	return decodeLegacyLightColors(s)
+}
+
+// decodeLegacyLightColors decodes the pipe delimited encoding of light
+// colors.
+func decodeLegacyLightColors(s string) (ops.LightColors, error) {
+	if !strings.HasPrefix(s, "0|") && s != "0" {
+		return nil, huedb.ErrBadLightColors
+	}
+	marshalled := strings.Split(s, "|")
+	marshalledLen := len(marshalled)
+	lightColors := make(ops.LightColors, (marshalledLen-1)/4)
+	for idx := 1; idx < marshalledLen; idx += 4 {
+		lightId, err := strconv.Atoi(marshalled[idx])
+		if err != nil {
+			return nil, err
+		}
+		var ix int
+		if ix, err = strconv.Atoi(marshalled[idx+1]); err != nil {
+			return nil, err
+		}
+		var iy int
+		if iy, err = strconv.Atoi(marshalled[idx+2]); err != nil {
+			return nil, err
+		}
+		var ibrightness int
+		if ibrightness, err = strconv.Atoi(marshalled[idx+3]); err != nil {
+			return nil, err
+		}
+		if lightId < 0 {
+			return nil, huedb.ErrBadLightColors
+		}
+		var theColor gohue.MaybeColor
+		if ix != -1 {
+			x := float64(ix) / 10000.0
+			y := float64(iy) / 10000.0
+			if x < 0.0 || x > 1.0 || y < 0.0 || y > 1.0 {
+				return nil, huedb.ErrBadLightColors
+			}
+			theColor.Set(gohue.NewColor(x, y))
+		}
+		var theBrightness maybe.Uint8
+		if ibrightness != -1 {
+			if ibrightness < 0 || ibrightness > 255 {
+				return nil, huedb.ErrBadLightColors
+			}
+			theBrightness.Set(uint8(ibrightness))
+		}
+		lightColors[lightId] = ops.ColorBrightness{
+			Color: theColor, Brightness: theBrightness}
+	}
+	if len(lightColors) == 0 {
+		return nil, nil
+	}
+	return lightColors, nil
+}
+
+// EncodeLightStates encodes states for a scenes states column.
+func EncodeLightStates(states ops.LightStates) (string, error) {
+	converted, ok := lightjson.FromStates(states)
+	if !ok {
+		return "", huedb.ErrBadLightColors
+	}
+	encoded, err := json.Marshal(converted)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// DecodeLightStates decodes a scenes states column.
+func DecodeLightStates(s string) (ops.LightStates, error) {
+	var converted map[int]lightjson.LightState
+	if err := json.Unmarshal([]byte(s), &converted); err != nil {
+		return nil, err
+	}
+	states, ok := lightjson.ToStates(converted)
+	if !ok {
+		return nil, huedb.ErrBadLightColors
+	}
+	return states, nil
+}
+
+// EncodeTags encodes tags for a tags column. No tags encode as the
+// empty string.
+func EncodeTags(tags []string) (string, error) {
+	if len(tags) == 0 {
+		return "", nil
+	}
+	encoded, err := json.Marshal(tags)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// DecodeTags decodes a tags column.
+func DecodeTags(s string) (tags []string, err error) {
+	if s == "" {
+		return nil, nil
+	}
+	err = json.Unmarshal([]byte(s), &tags)
+	return
+}
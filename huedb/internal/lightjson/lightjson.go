@@ -0,0 +1,166 @@
+// Package lightjson converts light colors and light states to and from
+// forms that encoding/json can marshal. The functions in this package
+// return false if they find values that are out of range.
+package lightjson
+
+import (
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/ops"
+)
+
+// ColorBrightness is the JSON form of an ops.ColorBrightness. X and Y
+// keep 4 decimal places.
+type ColorBrightness struct {
+	X          *float64 `json:"x,omitempty"`
+	Y          *float64 `json:"y,omitempty"`
+	Brightness *uint8   `json:"bri,omitempty"`
+}
+
+// LightState is the JSON form of an ops.LightState. X and Y are
+// multiplied by 10000.
+type LightState struct {
+	On             bool    `json:"on,omitempty"`
+	X              *int    `json:"x,omitempty"`
+	Y              *int    `json:"y,omitempty"`
+	Brightness     *uint8  `json:"bri,omitempty"`
+	Ct             *uint16 `json:"ct,omitempty"`
+	ColorMode      string  `json:"colormode,omitempty"`
+	Effect         string  `json:"effect,omitempty"`
+	TransitionTime *uint16 `json:"transitiontime,omitempty"`
+}
+
+// FromColors converts colors to JSON form.
+func FromColors(colors ops.LightColors) (map[int]ColorBrightness, bool) {
+	result := make(map[int]ColorBrightness, len(colors))
+	for lightId, colorBrightness := range colors {
+		if lightId < 0 {
+			return nil, false
+		}
+		var converted ColorBrightness
+		if colorBrightness.Color.Valid {
+			x, y, ok := round(colorBrightness.Color.Color)
+			if !ok {
+				return nil, false
+			}
+			converted.X = &x
+			converted.Y = &y
+		}
+		if colorBrightness.Brightness.Valid {
+			brightness := colorBrightness.Brightness.Value
+			converted.Brightness = &brightness
+		}
+		result[lightId] = converted
+	}
+	return result, true
+}
+
+// ToColors converts colors from JSON form. ToColors returns nil if colors
+// is empty.
+func ToColors(colors map[int]ColorBrightness) (ops.LightColors, bool) {
+	if len(colors) == 0 {
+		return nil, true
+	}
+	result := make(ops.LightColors, len(colors))
+	for lightId, converted := range colors {
+		if lightId < 0 {
+			return nil, false
+		}
+		var colorBrightness ops.ColorBrightness
+		if converted.X != nil && converted.Y != nil {
+			x, y := *converted.X, *converted.Y
+			if x < 0.0 || x > 1.0 || y < 0.0 || y > 1.0 {
+				return nil, false
+			}
+			colorBrightness.Color.Set(gohue.NewColor(x, y))
+		}
+		if converted.Brightness != nil {
+			colorBrightness.Brightness.Set(*converted.Brightness)
+		}
+		result[lightId] = colorBrightness
+	}
+	return result, true
+}
+
+// FromStates converts states to JSON form.
+func FromStates(states ops.LightStates) (map[int]LightState, bool) {
+	result := make(map[int]LightState, len(states))
+	for lightId, state := range states {
+		if lightId < 0 {
+			return nil, false
+		}
+		converted := LightState{
+			On:        state.On,
+			ColorMode: state.ColorMode,
+			Effect:    state.Effect,
+		}
+		if state.Color.Valid {
+			x := int(state.Color.X()*10000.0 + 0.5)
+			y := int(state.Color.Y()*10000.0 + 0.5)
+			converted.X = &x
+			converted.Y = &y
+		}
+		if state.Brightness.Valid {
+			brightness := state.Brightness.Value
+			converted.Brightness = &brightness
+		}
+		if state.Ct.Valid {
+			ct := state.Ct.Value
+			converted.Ct = &ct
+		}
+		if state.TransitionTime.Valid {
+			transitionTime := state.TransitionTime.Value
+			converted.TransitionTime = &transitionTime
+		}
+		result[lightId] = converted
+	}
+	return result, true
+}
+
+// ToStates converts states from JSON form. ToStates returns nil if states
+// is empty.
+func ToStates(states map[int]LightState) (ops.LightStates, bool) {
+	if len(states) == 0 {
+		return nil, true
+	}
+	result := make(ops.LightStates, len(states))
+	for lightId, converted := range states {
+		if lightId < 0 {
+			return nil, false
+		}
+		state := ops.LightState{
+			On:        converted.On,
+			ColorMode: converted.ColorMode,
+			Effect:    converted.Effect,
+		}
+		if converted.X != nil && converted.Y != nil {
+			x, y := *converted.X, *converted.Y
+			if x < 0 || x > 10000 || y < 0 || y > 10000 {
+				return nil, false
+			}
+			state.Color.Set(
+				gohue.NewColor(float64(x)/10000.0, float64(y)/10000.0))
+		}
+		if converted.Brightness != nil {
+			state.Brightness.Set(*converted.Brightness)
+		}
+		if converted.Ct != nil {
+			state.Ct.Set(*converted.Ct)
+		}
+		if converted.TransitionTime != nil {
+			state.TransitionTime.Set(*converted.TransitionTime)
+		}
+		result[lightId] = state
+	}
+	return result, true
+}
+
+func round(color gohue.Color) (x, y float64, ok bool) {
+	x = color.X()
+	y = color.Y()
+	if x < 0.0 || x > 1.0 || y < 0.0 || y > 1.0 {
+		return 0.0, 0.0, false
+	}
+	x = float64(int(x*10000.0+0.5)) / 10000.0
+	y = float64(int(y*10000.0+0.5)) / 10000.0
+	return x, y, true
+}
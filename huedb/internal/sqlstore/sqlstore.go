@@ -0,0 +1,790 @@
+// Package sqlstore implements the interfaces in the huedb package on top
+// of database/sql. Each database/sql backend supplies the SQL statements
+// for its own dialect.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/keep94/consume"
+	"github.com/keep94/marvin2/huedb"
+	"github.com/keep94/marvin2/huedb/internal/columns"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/toolbox/db"
+	"math"
+	"net/url"
+	"time"
+)
+
+// Statements are the SQL statements of a particular dialect. Statements
+// take their parameters in the same order as the sqlite statements in
+// the for_sqlite package.
+type Statements struct {
+	NamedColorsById          string
+	NamedColors              string
+	NamedColorsByDescription string
+
+	// Has a %s for the order by clause. Takes like pattern, limit, and
+	// offset as parameters.
+	NamedColorsWithOptions string
+
+	// Takes updated at time in seconds as a parameter.
+	NamedColorsModifiedSince string
+
+	AddNamedColors string
+
+	// Locks the row it reads until the transaction ends.
+	NamedColorsVersion string
+
+	UpdateNamedColors   string
+	RemoveNamedColors   string
+	ArchiveNamedColors  string
+	RestoreNamedColors  string
+	ArchivedNamedColors string
+
+	AddEncodedAtTimeTask                string
+	EncodedAtTimeTasks                  string
+	EncodedAtTimeTasksModifiedSince     string
+	RemoveEncodedAtTimeTaskByScheduleId string
+	ClearEncodedAtTimeTasks             string
+	RemoveEncodedAtTimeTasksBefore      string
+
+	EncodedScheduledTasks      string
+	AddEncodedScheduledTask    string
+	UpdateEncodedScheduledTask string
+	RemoveEncodedScheduledTask string
+	EnableEncodedScheduledTask string
+
+	SceneById   string
+	Scenes      string
+	AddScene    string
+	UpdateScene string
+	RemoveScene string
+
+	LastParams     string
+	SaveLastParams string
+
+	// If true, add statements end with a returning clause that returns
+	// the new id; otherwise the new id comes from sql.Result.LastInsertId.
+	Returning bool
+}
+
+// Store implements the interfaces in the huedb package. Store implements
+// the Ctx interfaces in the huedb package natively by passing ctx on to
+// database/sql so that ctx being done aborts a running statement.
+type Store struct {
+	db         *sql.DB
+	statements *Statements
+}
+
+// New returns a new Store that uses statements.
+func New(db *sql.DB, statements *Statements) Store {
+	return Store{db: db, statements: statements}
+}
+
+// NewDoer returns a db.Doer that runs each action within a single
+// database/sql transaction. The transactions that the returned db.Doer
+// passes to actions are *sql.Tx instances.
+func NewDoer(db *sql.DB) db.Doer {
+	return doer{db}
+}
+
+func (s Store) NamedColorsById(
+	t db.Transaction, id int64, namedColors *ops.NamedColors) error {
+	return s.NamedColorsByIdCtx(context.Background(), t, id, namedColors)
+}
+
+func (s Store) NamedColorsByIdCtx(
+	ctx context.Context,
+	t db.Transaction,
+	id int64,
+	namedColors *ops.NamedColors) error {
+	return readSingle(
+		s.queryer(t).QueryRowContext(ctx, s.statements.NamedColorsById, id),
+		(&rawNamedColors{}).init(namedColors))
+}
+
+func (s Store) NamedColors(
+	t db.Transaction, consumer consume.Consumer) error {
+	return s.NamedColorsCtx(context.Background(), t, consumer)
+}
+
+func (s Store) NamedColorsCtx(
+	ctx context.Context, t db.Transaction, consumer consume.Consumer) error {
+	return readMultiple(
+		ctx,
+		s.queryer(t),
+		(&rawNamedColors{}).init(&ops.NamedColors{}),
+		consumer,
+		s.statements.NamedColors)
+}
+
+func (s Store) NamedColorsByDescription(
+	t db.Transaction, query string, consumer consume.Consumer) error {
+	return s.NamedColorsByDescriptionCtx(
+		context.Background(), t, query, consumer)
+}
+
+func (s Store) NamedColorsByDescriptionCtx(
+	ctx context.Context,
+	t db.Transaction,
+	query string,
+	consumer consume.Consumer) error {
+	return readMultiple(
+		ctx,
+		s.queryer(t),
+		(&rawNamedColors{}).init(&ops.NamedColors{}),
+		consumer,
+		s.statements.NamedColorsByDescription,
+		"%"+columns.EscapeLike(query)+"%")
+}
+
+func (s Store) NamedColorsWithOptions(
+	t db.Transaction,
+	options *huedb.NamedColorsOptions,
+	consumer consume.Consumer) error {
+	orderBy := "id"
+	if options.OrderByDescription {
+		orderBy = "lower(description), id"
+	}
+	var limit int64 = math.MaxInt64
+	if options.Limit > 0 {
+		limit = int64(options.Limit)
+	}
+	return readMultiple(
+		context.Background(),
+		s.queryer(t),
+		(&rawNamedColors{}).init(&ops.NamedColors{}),
+		consumer,
+		fmt.Sprintf(s.statements.NamedColorsWithOptions, orderBy),
+		"%"+columns.EscapeLike(options.Description)+"%",
+		limit,
+		options.Offset)
+}
+
+func (s Store) NamedColorsModifiedSince(
+	t db.Transaction, since time.Time, consumer consume.Consumer) error {
+	return readMultiple(
+		context.Background(),
+		s.queryer(t),
+		(&rawNamedColors{}).init(&ops.NamedColors{}),
+		consumer,
+		s.statements.NamedColorsModifiedSince,
+		columns.EncodeTime(since))
+}
+
+func (s Store) AddNamedColors(
+	t db.Transaction, namedColors *ops.NamedColors) error {
+	return s.AddNamedColorsCtx(context.Background(), t, namedColors)
+}
+
+func (s Store) AddNamedColorsCtx(
+	ctx context.Context,
+	t db.Transaction,
+	namedColors *ops.NamedColors) error {
+	colors, err := columns.EncodeLightColors(namedColors.Colors)
+	if err != nil {
+		return err
+	}
+	createdAt := columns.EncodeTime(time.Now())
+	if err := s.add(
+		ctx,
+		t,
+		&namedColors.Id,
+		s.statements.AddNamedColors,
+		colors,
+		namedColors.Description,
+		createdAt,
+		createdAt); err != nil {
+		return err
+	}
+	namedColors.Version = 1
+	return nil
+}
+
+func (s Store) UpdateNamedColors(
+	t db.Transaction, namedColors *ops.NamedColors) error {
+	return s.UpdateNamedColorsCtx(context.Background(), t, namedColors)
+}
+
+func (s Store) UpdateNamedColorsCtx(
+	ctx context.Context,
+	t db.Transaction,
+	namedColors *ops.NamedColors) error {
+	if t == nil {
+		return doer{s.db}.doCtx(ctx, func(t db.Transaction) error {
+			return s.UpdateNamedColorsCtx(ctx, t, namedColors)
+		})
+	}
+	colors, err := columns.EncodeLightColors(namedColors.Colors)
+	if err != nil {
+		return err
+	}
+	var version int64
+	err = s.queryer(t).QueryRowContext(
+		ctx,
+		s.statements.NamedColorsVersion,
+		namedColors.Id).Scan(&version)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if namedColors.Version != 0 && version != namedColors.Version {
+		return huedb.ErrConcurrentModification
+	}
+	if err := s.exec(
+		ctx,
+		t,
+		s.statements.UpdateNamedColors,
+		colors,
+		namedColors.Description,
+		columns.EncodeTime(time.Now()),
+		namedColors.Id); err != nil {
+		return err
+	}
+	namedColors.Version = version + 1
+	return nil
+}
+
+func (s Store) RemoveNamedColors(t db.Transaction, id int64) error {
+	return s.RemoveNamedColorsCtx(context.Background(), t, id)
+}
+
+func (s Store) RemoveNamedColorsCtx(
+	ctx context.Context, t db.Transaction, id int64) error {
+	return s.exec(ctx, t, s.statements.RemoveNamedColors, id)
+}
+
+func (s Store) ArchiveNamedColors(t db.Transaction, id int64) error {
+	return s.exec(
+		context.Background(), t, s.statements.ArchiveNamedColors, id)
+}
+
+func (s Store) RestoreNamedColors(t db.Transaction, id int64) error {
+	return s.exec(
+		context.Background(), t, s.statements.RestoreNamedColors, id)
+}
+
+func (s Store) ArchivedNamedColors(
+	t db.Transaction, consumer consume.Consumer) error {
+	return readMultiple(
+		context.Background(),
+		s.queryer(t),
+		(&rawNamedColors{}).init(&ops.NamedColors{}),
+		consumer,
+		s.statements.ArchivedNamedColors)
+}
+
+func (s Store) EncodedAtTimeTasks(
+	t db.Transaction, groupId string, consumer consume.Consumer) error {
+	return s.EncodedAtTimeTasksCtx(context.Background(), t, groupId, consumer)
+}
+
+func (s Store) EncodedAtTimeTasksCtx(
+	ctx context.Context,
+	t db.Transaction,
+	groupId string,
+	consumer consume.Consumer) error {
+	return readMultiple(
+		ctx,
+		s.queryer(t),
+		(&rawEncodedAtTimeTask{}).init(&huedb.EncodedAtTimeTask{}),
+		consumer,
+		s.statements.EncodedAtTimeTasks,
+		groupId)
+}
+
+func (s Store) EncodedAtTimeTasksModifiedSince(
+	t db.Transaction,
+	groupId string,
+	since time.Time,
+	consumer consume.Consumer) error {
+	return readMultiple(
+		context.Background(),
+		s.queryer(t),
+		(&rawEncodedAtTimeTask{}).init(&huedb.EncodedAtTimeTask{}),
+		consumer,
+		s.statements.EncodedAtTimeTasksModifiedSince,
+		groupId,
+		columns.EncodeTime(since))
+}
+
+func (s Store) AddEncodedAtTimeTask(
+	t db.Transaction, task *huedb.EncodedAtTimeTask) error {
+	return s.AddEncodedAtTimeTaskCtx(context.Background(), t, task)
+}
+
+func (s Store) AddEncodedAtTimeTaskCtx(
+	ctx context.Context,
+	t db.Transaction,
+	task *huedb.EncodedAtTimeTask) error {
+	return s.addAtTimeTask(ctx, t, task, time.Now())
+}
+
+func (s Store) AddEncodedAtTimeTasks(
+	t db.Transaction, tasks []*huedb.EncodedAtTimeTask) error {
+	if t == nil {
+		return NewDoer(s.db).Do(func(t db.Transaction) error {
+			return s.AddEncodedAtTimeTasks(t, tasks)
+		})
+	}
+	createdAt := time.Now()
+	for _, task := range tasks {
+		if err := s.addAtTimeTask(
+			context.Background(), t, task, createdAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s Store) RemoveEncodedAtTimeTaskByScheduleId(
+	t db.Transaction, groupId, scheduleId string) error {
+	return s.RemoveEncodedAtTimeTaskByScheduleIdCtx(
+		context.Background(), t, groupId, scheduleId)
+}
+
+func (s Store) RemoveEncodedAtTimeTaskByScheduleIdCtx(
+	ctx context.Context, t db.Transaction, groupId, scheduleId string) error {
+	return s.exec(
+		ctx,
+		t,
+		s.statements.RemoveEncodedAtTimeTaskByScheduleId,
+		groupId,
+		scheduleId)
+}
+
+func (s Store) ClearEncodedAtTimeTasks(t db.Transaction) error {
+	return s.exec(
+		context.Background(), t, s.statements.ClearEncodedAtTimeTasks)
+}
+
+func (s Store) RemoveEncodedAtTimeTasksBefore(
+	t db.Transaction, before int64) error {
+	return s.exec(
+		context.Background(),
+		t,
+		s.statements.RemoveEncodedAtTimeTasksBefore,
+		before,
+		before)
+}
+
+func (s Store) EncodedScheduledTasks(
+	t db.Transaction, consumer consume.Consumer) error {
+	return s.EncodedScheduledTasksCtx(context.Background(), t, consumer)
+}
+
+func (s Store) EncodedScheduledTasksCtx(
+	ctx context.Context, t db.Transaction, consumer consume.Consumer) error {
+	return readMultiple(
+		ctx,
+		s.queryer(t),
+		(&rawEncodedScheduledTask{}).init(&huedb.EncodedScheduledTask{}),
+		consumer,
+		s.statements.EncodedScheduledTasks)
+}
+
+func (s Store) AddEncodedScheduledTask(
+	t db.Transaction, task *huedb.EncodedScheduledTask) error {
+	return s.add(
+		context.Background(),
+		t,
+		&task.Id,
+		s.statements.AddEncodedScheduledTask,
+		task.HueTaskId,
+		task.Action,
+		task.Description,
+		task.LightSet,
+		task.Recurring,
+		task.Priority,
+		task.Enabled)
+}
+
+func (s Store) UpdateEncodedScheduledTask(
+	t db.Transaction, task *huedb.EncodedScheduledTask) error {
+	return s.exec(
+		context.Background(),
+		t,
+		s.statements.UpdateEncodedScheduledTask,
+		task.HueTaskId,
+		task.Action,
+		task.Description,
+		task.LightSet,
+		task.Recurring,
+		task.Priority,
+		task.Enabled,
+		task.Id)
+}
+
+func (s Store) RemoveEncodedScheduledTask(t db.Transaction, id int64) error {
+	return s.exec(
+		context.Background(), t, s.statements.RemoveEncodedScheduledTask, id)
+}
+
+func (s Store) EnableEncodedScheduledTask(
+	t db.Transaction, id int64, enabled bool) error {
+	return s.exec(
+		context.Background(),
+		t,
+		s.statements.EnableEncodedScheduledTask,
+		enabled,
+		id)
+}
+
+func (s Store) SceneById(
+	t db.Transaction, id int64, scene *huedb.Scene) error {
+	return readSingle(
+		s.queryer(t).QueryRowContext(
+			context.Background(), s.statements.SceneById, id),
+		(&rawScene{}).init(scene))
+}
+
+func (s Store) Scenes(t db.Transaction, consumer consume.Consumer) error {
+	return readMultiple(
+		context.Background(),
+		s.queryer(t),
+		(&rawScene{}).init(&huedb.Scene{}),
+		consumer,
+		s.statements.Scenes)
+}
+
+func (s Store) AddScene(t db.Transaction, scene *huedb.Scene) error {
+	states, tags, err := encodeScene(scene)
+	if err != nil {
+		return err
+	}
+	return s.add(
+		context.Background(),
+		t,
+		&scene.Id,
+		s.statements.AddScene,
+		scene.Name,
+		states,
+		tags)
+}
+
+func (s Store) UpdateScene(t db.Transaction, scene *huedb.Scene) error {
+	states, tags, err := encodeScene(scene)
+	if err != nil {
+		return err
+	}
+	return s.exec(
+		context.Background(),
+		t,
+		s.statements.UpdateScene,
+		scene.Name,
+		states,
+		tags,
+		scene.Id)
+}
+
+func (s Store) RemoveScene(t db.Transaction, id int64) error {
+	return s.exec(context.Background(), t, s.statements.RemoveScene, id)
+}
+
+func (s Store) LastParams(
+	t db.Transaction, hueTaskId int, params *huedb.LastParams) error {
+	return s.LastParamsCtx(context.Background(), t, hueTaskId, params)
+}
+
+func (s Store) LastParamsCtx(
+	ctx context.Context,
+	t db.Transaction,
+	hueTaskId int,
+	params *huedb.LastParams) error {
+	return readSingle(
+		s.queryer(t).QueryRowContext(ctx, s.statements.LastParams, hueTaskId),
+		(&rawLastParams{}).init(params))
+}
+
+func (s Store) SaveLastParams(
+	t db.Transaction, params *huedb.LastParams) error {
+	return s.SaveLastParamsCtx(context.Background(), t, params)
+}
+
+func (s Store) SaveLastParamsCtx(
+	ctx context.Context, t db.Transaction, params *huedb.LastParams) error {
+	return s.exec(
+		ctx,
+		t,
+		s.statements.SaveLastParams,
+		params.HueTaskId,
+		params.Values.Encode(),
+		params.Action,
+		params.Description)
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common.
+type queryer interface {
+	ExecContext(
+		ctx context.Context,
+		query string,
+		args ...interface{}) (sql.Result, error)
+	QueryContext(
+		ctx context.Context,
+		query string,
+		args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(
+		ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (s Store) queryer(t db.Transaction) queryer {
+	if t == nil {
+		return s.db
+	}
+	return t.(*sql.Tx)
+}
+
+func (s Store) exec(
+	ctx context.Context,
+	t db.Transaction,
+	statement string,
+	args ...interface{}) error {
+	_, err := s.queryer(t).ExecContext(ctx, statement, args...)
+	return err
+}
+
+func (s Store) addAtTimeTask(
+	ctx context.Context,
+	t db.Transaction,
+	task *huedb.EncodedAtTimeTask,
+	createdAt time.Time) error {
+	return s.add(
+		ctx,
+		t,
+		&task.Id,
+		s.statements.AddEncodedAtTimeTask,
+		task.ScheduleId,
+		task.HueTaskId,
+		task.Action,
+		task.Description,
+		task.LightSet,
+		task.Time,
+		task.EndTime,
+		task.RestoreAtEnd,
+		task.GroupId,
+		columns.EncodeTime(createdAt),
+		columns.EncodeTime(createdAt))
+}
+
+func (s Store) add(
+	ctx context.Context,
+	t db.Transaction,
+	id *int64,
+	statement string,
+	args ...interface{}) error {
+	if s.statements.Returning {
+		return s.queryer(t).QueryRowContext(ctx, statement, args...).Scan(id)
+	}
+	result, err := s.queryer(t).ExecContext(ctx, statement, args...)
+	if err != nil {
+		return err
+	}
+	*id, err = result.LastInsertId()
+	return err
+}
+
+type doer struct {
+	db *sql.DB
+}
+
+func (d doer) Do(action db.Action) error {
+	return d.doCtx(context.Background(), action)
+}
+
+// doCtx runs action within a transaction that the database rolls back
+// if ctx is done before the transaction commits.
+func (d doer) doCtx(ctx context.Context, action db.Action) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := action(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// row is a row read from the database.
+type row interface {
+	// Ptrs returns the pointers to pass to Scan.
+	Ptrs() []interface{}
+
+	// Unmarshall converts what Scan read into the business object.
+	Unmarshall() error
+
+	// ValuePtr returns the business object.
+	ValuePtr() interface{}
+}
+
+func readSingle(sqlRow *sql.Row, r row) error {
+	err := sqlRow.Scan(r.Ptrs()...)
+	if err == sql.ErrNoRows {
+		return huedb.ErrNoSuchId
+	}
+	if err != nil {
+		return err
+	}
+	return r.Unmarshall()
+}
+
+func readMultiple(
+	ctx context.Context,
+	q queryer,
+	r row,
+	consumer consume.Consumer,
+	statement string,
+	args ...interface{}) error {
+	rows, err := q.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for consumer.CanConsume() && rows.Next() {
+		if err := rows.Scan(r.Ptrs()...); err != nil {
+			return err
+		}
+		if err := r.Unmarshall(); err != nil {
+			return err
+		}
+		consumer.Consume(r.ValuePtr())
+	}
+	return rows.Err()
+}
+
+func encodeScene(scene *huedb.Scene) (states, tags string, err error) {
+	if states, err = columns.EncodeLightStates(scene.States); err != nil {
+		return
+	}
+	tags, err = columns.EncodeTags(scene.Tags)
+	return
+}
+
+type rawNamedColors struct {
+	*ops.NamedColors
+	colors    string
+	createdAt int64
+	updatedAt int64
+}
+
+func (r *rawNamedColors) init(bo *ops.NamedColors) *rawNamedColors {
+	r.NamedColors = bo
+	return r
+}
+
+func (r *rawNamedColors) ValuePtr() interface{} {
+	return r.NamedColors
+}
+
+func (r *rawNamedColors) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.colors, &r.Description, &r.createdAt, &r.updatedAt, &r.Version}
+}
+
+func (r *rawNamedColors) Unmarshall() (err error) {
+	r.CreatedAt = columns.DecodeTime(r.createdAt)
+	r.UpdatedAt = columns.DecodeTime(r.updatedAt)
+	r.Colors, err = columns.DecodeLightColors(r.colors)
+	return
+}
+
+type rawEncodedAtTimeTask struct {
+	*huedb.EncodedAtTimeTask
+	createdAt int64
+	updatedAt int64
+}
+
+func (r *rawEncodedAtTimeTask) init(
+	bo *huedb.EncodedAtTimeTask) *rawEncodedAtTimeTask {
+	r.EncodedAtTimeTask = bo
+	return r
+}
+
+func (r *rawEncodedAtTimeTask) ValuePtr() interface{} {
+	return r.EncodedAtTimeTask
+}
+
+func (r *rawEncodedAtTimeTask) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.ScheduleId, &r.HueTaskId, &r.Action, &r.Description, &r.LightSet, &r.Time, &r.EndTime, &r.RestoreAtEnd, &r.GroupId, &r.createdAt, &r.updatedAt}
+}
+
+func (r *rawEncodedAtTimeTask) Unmarshall() error {
+	r.CreatedAt = columns.DecodeTime(r.createdAt)
+	r.UpdatedAt = columns.DecodeTime(r.updatedAt)
+	return nil
+}
+
+type rawEncodedScheduledTask struct {
+	*huedb.EncodedScheduledTask
+}
+
+func (r *rawEncodedScheduledTask) init(
+	bo *huedb.EncodedScheduledTask) *rawEncodedScheduledTask {
+	r.EncodedScheduledTask = bo
+	return r
+}
+
+func (r *rawEncodedScheduledTask) ValuePtr() interface{} {
+	return r.EncodedScheduledTask
+}
+
+func (r *rawEncodedScheduledTask) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.HueTaskId, &r.Action, &r.Description, &r.LightSet, &r.Recurring, &r.Priority, &r.Enabled}
+}
+
+func (r *rawEncodedScheduledTask) Unmarshall() error {
+	return nil
+}
+
+type rawScene struct {
+	*huedb.Scene
+	states string
+	tags   string
+}
+
+func (r *rawScene) init(bo *huedb.Scene) *rawScene {
+	r.Scene = bo
+	return r
+}
+
+func (r *rawScene) ValuePtr() interface{} {
+	return r.Scene
+}
+
+func (r *rawScene) Ptrs() []interface{} {
+	return []interface{}{&r.Id, &r.Name, &r.states, &r.tags}
+}
+
+func (r *rawScene) Unmarshall() (err error) {
+	if r.States, err = columns.DecodeLightStates(r.states); err != nil {
+		return
+	}
+	r.Tags, err = columns.DecodeTags(r.tags)
+	return
+}
+
+type rawLastParams struct {
+	*huedb.LastParams
+	values string
+}
+
+func (r *rawLastParams) init(bo *huedb.LastParams) *rawLastParams {
+	r.LastParams = bo
+	return r
+}
+
+func (r *rawLastParams) ValuePtr() interface{} {
+	return r.LastParams
+}
+
+func (r *rawLastParams) Ptrs() []interface{} {
+	return []interface{}{&r.HueTaskId, &r.values, &r.Action, &r.Description}
+}
+
+func (r *rawLastParams) Unmarshall() (err error) {
+	r.Values, err = url.ParseQuery(r.values)
+	return
+}
@@ -0,0 +1,153 @@
+// Package migrate upgrades the schema of a sqlite database for Hue Web App
+// one version at a time. The schema_version table records the version
+// of the schema so that each migration runs only once.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/keep94/gosqlite/sqlite"
+)
+
+var (
+	// Indicates that the database has a newer schema than the newest
+	// migration.
+	ErrVersionTooNew = errors.New("migrate: Database schema is too new.")
+
+	// Indicates that migrations are not numbered 1, 2, 3, ...
+	ErrBadVersions = errors.New("migrate: Migration versions must be 1, 2, 3, ...")
+)
+
+// Migration upgrades a database schema from Version - 1 to Version.
+type Migration struct {
+	// The version of the schema after this migration runs. The first
+	// migration has version 1.
+	Version int
+
+	// What this migration does e.g "Add end_time to at_time_tasks"
+	Description string
+
+	// Up performs this migration.
+	Up func(conn *sqlite.Conn) error
+}
+
+// Version returns the schema version of the database. Version returns 0
+// for a database that no migration has touched.
+func Version(conn *sqlite.Conn) (int, error) {
+	if err := createVersionTable(conn); err != nil {
+		return 0, err
+	}
+	stmt, err := conn.Prepare("select version from schema_version")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Finalize()
+	if err := stmt.Exec(); err != nil {
+		return 0, err
+	}
+	if !stmt.Next() {
+		return 0, stmt.Error()
+	}
+	var version int
+	if err := stmt.Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Run runs the migrations that the database has not yet had in order of
+// version. Each migration runs in its own savepoint so that a failing
+// migration leaves the database at the version of the last migration that
+// succeeded. Run works whether or not the caller has already started a
+// transaction on conn. migrations must be ordered by version starting
+// at 1. Run returns ErrVersionTooNew if the database has a schema version
+// newer than the last migration.
+func Run(conn *sqlite.Conn, migrations []Migration) error {
+	for i := range migrations {
+		if migrations[i].Version != i+1 {
+			return ErrBadVersions
+		}
+	}
+	version, err := Version(conn)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return ErrVersionTooNew
+	}
+	for _, migration := range migrations[version:] {
+		if err := run(conn, &migration); err != nil {
+			return fmt.Errorf(
+				"migrate: %d %s: %v",
+				migration.Version,
+				migration.Description,
+				err)
+		}
+	}
+	return nil
+}
+
+// AddColumnIfMissing adds a column to table unless table already has
+// the column. definition is the type and constraints of the new column
+// e.g "INTEGER NOT NULL DEFAULT 0". AddColumnIfMissing lets a migration
+// upgrade databases that predate this package.
+func AddColumnIfMissing(
+	conn *sqlite.Conn, table, column, definition string) error {
+	exists, err := columnExists(conn, table, column)
+	if err != nil || exists {
+		return err
+	}
+	return conn.Exec(fmt.Sprintf(
+		"alter table %s add column %s %s", table, column, definition))
+}
+
+func run(conn *sqlite.Conn, migration *Migration) error {
+	if err := conn.Exec("savepoint migrate"); err != nil {
+		return err
+	}
+	err := migration.Up(conn)
+	if err == nil {
+		err = setVersion(conn, migration.Version)
+	}
+	if err != nil {
+		conn.Exec("rollback to migrate")
+		conn.Exec("release migrate")
+		return err
+	}
+	return conn.Exec("release migrate")
+}
+
+func createVersionTable(conn *sqlite.Conn) error {
+	return conn.Exec("create table if not exists schema_version (version INTEGER NOT NULL)")
+}
+
+func setVersion(conn *sqlite.Conn, version int) error {
+	if err := conn.Exec("delete from schema_version"); err != nil {
+		return err
+	}
+	return conn.Exec(
+		"insert into schema_version (version) values (?)", version)
+}
+
+func columnExists(conn *sqlite.Conn, table, column string) (bool, error) {
+	stmt, err := conn.Prepare(fmt.Sprintf("pragma table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Finalize()
+	if err := stmt.Exec(); err != nil {
+		return false, err
+	}
+	for stmt.Next() {
+		var cid, notNull, primaryKey int
+		var name, columnType, defaultValue string
+		if err := stmt.Scan(
+			&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, stmt.Error()
+}
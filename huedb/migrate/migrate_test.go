@@ -0,0 +1,121 @@
+package migrate_test
+
+import (
+	"errors"
+	"github.com/keep94/gosqlite/sqlite"
+	"github.com/keep94/marvin2/huedb/migrate"
+	"testing"
+)
+
+var kMigrations = []migrate.Migration{
+	{
+		Version:     1,
+		Description: "Create foo",
+		Up: func(conn *sqlite.Conn) error {
+			return conn.Exec("create table foo (id INTEGER PRIMARY KEY)")
+		},
+	},
+	{
+		Version:     2,
+		Description: "Add bar to foo",
+		Up: func(conn *sqlite.Conn) error {
+			return migrate.AddColumnIfMissing(
+				conn, "foo", "bar", "TEXT NOT NULL DEFAULT ''")
+		},
+	},
+}
+
+func TestRun(t *testing.T) {
+	conn := openConn(t)
+	defer conn.Close()
+	assertVersion(t, conn, 0)
+	if err := migrate.Run(conn, kMigrations[:1]); err != nil {
+		t.Fatalf("Got error migrating: %v", err)
+	}
+	assertVersion(t, conn, 1)
+	if err := migrate.Run(conn, kMigrations); err != nil {
+		t.Fatalf("Got error migrating: %v", err)
+	}
+	assertVersion(t, conn, 2)
+	if err := conn.Exec("insert into foo (bar) values ('baz')"); err != nil {
+		t.Errorf("Expected bar column: %v", err)
+	}
+	// Running again should be harmless
+	if err := migrate.Run(conn, kMigrations); err != nil {
+		t.Fatalf("Got error migrating: %v", err)
+	}
+	assertVersion(t, conn, 2)
+	if err := migrate.Run(
+		conn, kMigrations[:1]); err != migrate.ErrVersionTooNew {
+		t.Errorf("Expected ErrVersionTooNew, got %v", err)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	conn := openConn(t)
+	defer conn.Close()
+	migrations := []migrate.Migration{
+		kMigrations[0],
+		{
+			Version:     2,
+			Description: "Fail",
+			Up: func(conn *sqlite.Conn) error {
+				if err := conn.Exec("create table partial (id INTEGER)"); err != nil {
+					return err
+				}
+				return errors.New("failed")
+			},
+		},
+	}
+	if err := migrate.Run(conn, migrations); err == nil {
+		t.Error("Expected error migrating")
+	}
+	assertVersion(t, conn, 1)
+	if err := conn.Exec("insert into partial (id) values (1)"); err == nil {
+		t.Error("Expected failed migration to be rolled back")
+	}
+}
+
+func TestRunInTransaction(t *testing.T) {
+	conn := openConn(t)
+	defer conn.Close()
+	if err := conn.Exec("begin"); err != nil {
+		t.Fatalf("Got error beginning: %v", err)
+	}
+	if err := migrate.Run(conn, kMigrations); err != nil {
+		t.Fatalf("Got error migrating: %v", err)
+	}
+	if err := conn.Exec("commit"); err != nil {
+		t.Fatalf("Got error committing: %v", err)
+	}
+	assertVersion(t, conn, 2)
+}
+
+func TestRunBadVersions(t *testing.T) {
+	conn := openConn(t)
+	defer conn.Close()
+	if err := migrate.Run(
+		conn, kMigrations[1:]); err != migrate.ErrBadVersions {
+		t.Errorf("Expected ErrBadVersions, got %v", err)
+	}
+	assertVersion(t, conn, 0)
+}
+
+func assertVersion(t *testing.T, conn *sqlite.Conn, expected int) {
+	t.Helper()
+	version, err := migrate.Version(conn)
+	if err != nil {
+		t.Fatalf("Got error reading version: %v", err)
+	}
+	if version != expected {
+		t.Errorf("Expected version %d, got %d", expected, version)
+	}
+}
+
+func openConn(t *testing.T) *sqlite.Conn {
+	conn, err := sqlite.Open(":memory:")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	return conn
+}
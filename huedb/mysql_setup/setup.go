@@ -0,0 +1,25 @@
+// Package mysql_setup sets up a MySQL or MariaDB database for Hue Web App
+package mysql_setup
+
+import (
+	"database/sql"
+)
+
+var kTables = []string{
+	"create table if not exists named_colors (id BIGINT AUTO_INCREMENT PRIMARY KEY, description TEXT NOT NULL, colors TEXT NOT NULL, created_at BIGINT NOT NULL DEFAULT 0, updated_at BIGINT NOT NULL DEFAULT 0, version BIGINT NOT NULL DEFAULT 1, archived BOOLEAN NOT NULL DEFAULT FALSE)",
+	"create table if not exists at_time_tasks (id BIGINT AUTO_INCREMENT PRIMARY KEY, schedule_id VARCHAR(255) NOT NULL, hue_task_id INT NOT NULL, action TEXT NOT NULL, description TEXT NOT NULL, light_set TEXT NOT NULL, time BIGINT NOT NULL, group_id VARCHAR(255) NOT NULL, end_time BIGINT NOT NULL DEFAULT 0, restore_at_end BOOLEAN NOT NULL DEFAULT FALSE, created_at BIGINT NOT NULL DEFAULT 0, updated_at BIGINT NOT NULL DEFAULT 0, INDEX at_time_tasks_scheduleid_idx (group_id, schedule_id))",
+	"create table if not exists scheduled_tasks (id BIGINT AUTO_INCREMENT PRIMARY KEY, hue_task_id INT NOT NULL, action TEXT NOT NULL, description TEXT NOT NULL, light_set TEXT NOT NULL, recurring TEXT NOT NULL, priority INT NOT NULL, enabled BOOLEAN NOT NULL)",
+	"create table if not exists last_params (hue_task_id INT PRIMARY KEY, params TEXT NOT NULL, action TEXT NOT NULL, description TEXT NOT NULL)",
+	"create table if not exists scenes (id BIGINT AUTO_INCREMENT PRIMARY KEY, name TEXT NOT NULL, states TEXT NOT NULL, tags TEXT NOT NULL)",
+}
+
+// SetUpTables creates all needed tables in database. SetUpTables is
+// harmless to call on a database that already has the tables.
+func SetUpTables(db *sql.DB) error {
+	for _, statement := range kTables {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+// Package postgres_setup sets up a PostgreSQL database for Hue Web App
+package postgres_setup
+
+import (
+	"database/sql"
+)
+
+var kTables = []string{
+	"create table if not exists named_colors (id BIGSERIAL PRIMARY KEY, description TEXT NOT NULL DEFAULT '', colors TEXT NOT NULL DEFAULT '', created_at BIGINT NOT NULL DEFAULT 0, updated_at BIGINT NOT NULL DEFAULT 0, version BIGINT NOT NULL DEFAULT 1, archived BOOLEAN NOT NULL DEFAULT FALSE)",
+	"create table if not exists at_time_tasks (id BIGSERIAL PRIMARY KEY, schedule_id TEXT NOT NULL, hue_task_id INTEGER NOT NULL, action TEXT NOT NULL, description TEXT NOT NULL, light_set TEXT NOT NULL, time BIGINT NOT NULL, group_id TEXT NOT NULL, end_time BIGINT NOT NULL DEFAULT 0, restore_at_end BOOLEAN NOT NULL DEFAULT FALSE, created_at BIGINT NOT NULL DEFAULT 0, updated_at BIGINT NOT NULL DEFAULT 0)",
+	"create index if not exists at_time_tasks_scheduleid_idx on at_time_tasks (group_id, schedule_id)",
+	"create table if not exists scheduled_tasks (id BIGSERIAL PRIMARY KEY, hue_task_id INTEGER NOT NULL, action TEXT NOT NULL, description TEXT NOT NULL, light_set TEXT NOT NULL, recurring TEXT NOT NULL, priority INTEGER NOT NULL, enabled BOOLEAN NOT NULL)",
+	"create table if not exists last_params (hue_task_id INTEGER PRIMARY KEY, params TEXT NOT NULL, action TEXT NOT NULL, description TEXT NOT NULL)",
+	"create table if not exists scenes (id BIGSERIAL PRIMARY KEY, name TEXT NOT NULL, states TEXT NOT NULL, tags TEXT NOT NULL)",
+}
+
+// SetUpTables creates all needed tables in database. SetUpTables is
+// harmless to call on a database that already has the tables.
+func SetUpTables(db *sql.DB) error {
+	for _, statement := range kTables {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,21 +3,173 @@ package sqlite_setup
 
 import (
 	"github.com/keep94/gosqlite/sqlite"
+	"github.com/keep94/marvin2/huedb/migrate"
 )
 
-// SetUpTables creates all needed tables in database.
+// kMigrations builds the schema. Never change a migration that has
+// shipped; add a new one instead. Migrations 1 through 6 use
+// "if not exists" and migrate.AddColumnIfMissing because databases that
+// predate the schema_version table start at version 0 even though they
+// already have some of the tables.
+var kMigrations = []migrate.Migration{
+	{
+		Version:     1,
+		Description: "Create named_colors",
+		Up: execAll(
+			"create table if not exists named_colors (id INTEGER PRIMARY KEY AUTOINCREMENT, description TEXT, colors TEXT)"),
+	},
+	{
+		Version:     2,
+		Description: "Create at_time_tasks",
+		Up: func(conn *sqlite.Conn) error {
+			err := conn.Exec("create table if not exists at_time_tasks (id INTEGER PRIMARY KEY AUTOINCREMENT, schedule_id TEXT, hue_task_id INTEGER, action TEXT, description TEXT, light_set TEXT, time INTEGER, group_id TEXT, end_time INTEGER NOT NULL DEFAULT 0, restore_at_end INTEGER NOT NULL DEFAULT 0)")
+			if err != nil {
+				return err
+			}
+			err = migrate.AddColumnIfMissing(conn, "at_time_tasks", "end_time", "INTEGER NOT NULL DEFAULT 0")
+			if err != nil {
+				return err
+			}
+			err = migrate.AddColumnIfMissing(conn, "at_time_tasks", "restore_at_end", "INTEGER NOT NULL DEFAULT 0")
+			if err != nil {
+				return err
+			}
+			return conn.Exec("create index if not exists at_time_tasks_scheduleid_idx on at_time_tasks (group_id, schedule_id)")
+		},
+	},
+	{
+		Version:     3,
+		Description: "Create scheduled_tasks",
+		Up: execAll(
+			"create table if not exists scheduled_tasks (id INTEGER PRIMARY KEY AUTOINCREMENT, hue_task_id INTEGER, action TEXT, description TEXT, light_set TEXT, recurring_id INTEGER, high_priority INTEGER, enabled INTEGER)"),
+	},
+	{
+		Version:     4,
+		Description: "Create last_params",
+		Up: execAll(
+			"create table if not exists last_params (hue_task_id INTEGER PRIMARY KEY, params TEXT)"),
+	},
+	{
+		Version:     5,
+		Description: "Create scenes",
+		Up: execAll(
+			"create table if not exists scenes (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, states TEXT, tags TEXT)"),
+	},
+	{
+		Version:     6,
+		Description: "Create last_actions",
+		Up: execAll(
+			"create table if not exists last_actions (hue_task_id INTEGER PRIMARY KEY, action TEXT, description TEXT)"),
+	},
+	{
+		Version:     7,
+		Description: "Add archived to named_colors",
+		Up: execAll(
+			"alter table named_colors add column archived INTEGER NOT NULL DEFAULT 0"),
+	},
+	{
+		Version:     8,
+		Description: "Create description_overrides",
+		Up: execAll(
+			"create table description_overrides (hue_task_id INTEGER PRIMARY KEY, description TEXT)"),
+	},
+	{
+		Version:     9,
+		Description: "Add created_at and updated_at",
+		Up: execAll(
+			"alter table named_colors add column created_at INTEGER NOT NULL DEFAULT 0",
+			"alter table named_colors add column updated_at INTEGER NOT NULL DEFAULT 0",
+			"alter table at_time_tasks add column created_at INTEGER NOT NULL DEFAULT 0",
+			"alter table at_time_tasks add column updated_at INTEGER NOT NULL DEFAULT 0"),
+	},
+	{
+		Version:     10,
+		Description: "Add version to named_colors",
+		Up: execAll(
+			"alter table named_colors add column version INTEGER NOT NULL DEFAULT 0"),
+	},
+	{
+		Version:     11,
+		Description: "Create task_runs",
+		Up: execAll(
+			"create table task_runs (id INTEGER PRIMARY KEY AUTOINCREMENT, hue_task_id INTEGER, description TEXT, start INTEGER, duration INTEGER)",
+			"create index task_runs_start_idx on task_runs (start)"),
+	},
+	{
+		Version:     12,
+		Description: "Create last_fired",
+		Up: execAll(
+			"create table last_fired (scheduled_task_id INTEGER PRIMARY KEY, time INTEGER)"),
+	},
+	{
+		Version:     13,
+		Description: "Create last_runs",
+		Up: execAll(
+			"create table last_runs (hue_task_id INTEGER PRIMARY KEY, time INTEGER)"),
+	},
+	{
+		Version:     14,
+		Description: "Create enabled_states",
+		Up: execAll(
+			"create table enabled_states (scheduled_task_id INTEGER PRIMARY KEY, enabled INTEGER)"),
+	},
+	{
+		Version:     15,
+		Description: "Fold last_actions into last_params",
+		Up: execAll(
+			"alter table last_params add column action TEXT NOT NULL DEFAULT ''",
+			"alter table last_params add column description TEXT NOT NULL DEFAULT ''",
+			"update last_params set action = (select action from last_actions where last_actions.hue_task_id = last_params.hue_task_id), description = (select description from last_actions where last_actions.hue_task_id = last_params.hue_task_id) where hue_task_id in (select hue_task_id from last_actions)",
+			"insert into last_params (hue_task_id, params, action, description) select hue_task_id, '', action, description from last_actions where hue_task_id not in (select hue_task_id from last_params)",
+			"drop table last_actions"),
+	},
+	{
+		Version:     16,
+		Description: "Drop enabled_states in favor of scheduled_tasks.enabled",
+		Up: execAll(
+			"drop table enabled_states"),
+	},
+	{
+		Version:     17,
+		Description: "Drop last_runs in favor of task_runs",
+		Up: execAll(
+			"drop table last_runs"),
+	},
+	{
+		Version:     18,
+		Description: "Persist recurring specs in scheduled_tasks",
+		Up: execAll(
+			"alter table scheduled_tasks add column recurring TEXT not null default ''"),
+	},
+	{
+		Version:     19,
+		Description: "Replace scheduled_tasks.high_priority with integer priorities",
+		Up: execAll(
+			"alter table scheduled_tasks add column priority INTEGER not null default 0",
+			"update scheduled_tasks set priority = case when high_priority then 100 else 0 end"),
+	},
+	{
+		Version:     20,
+		Description: "Start named_colors versions at 1 so that 0 means unchecked",
+		Up: execAll(
+			"update named_colors set version = 1 where version = 0"),
+	},
+}
+
+// SetUpTables creates all needed tables in database by running the
+// migrations that database has not yet had. SetUpTables also upgrades
+// tables created by earlier versions.
 func SetUpTables(conn *sqlite.Conn) error {
-	err := conn.Exec("create table if not exists named_colors (id INTEGER PRIMARY KEY AUTOINCREMENT, description TEXT, colors TEXT)")
-	if err != nil {
-		return err
-	}
-	err = conn.Exec("create table if not exists at_time_tasks (id INTEGER PRIMARY KEY AUTOINCREMENT, schedule_id TEXT, hue_task_id INTEGER, action TEXT, description TEXT, light_set TEXT, time INTEGER, group_id TEXT)")
-	if err != nil {
-		return err
-	}
-	err = conn.Exec("create index if not exists at_time_tasks_scheduleid_idx on at_time_tasks (group_id, schedule_id)")
-	if err != nil {
-		return err
+	return migrate.Run(conn, kMigrations)
+}
+
+func execAll(statements ...string) func(conn *sqlite.Conn) error {
+	return func(conn *sqlite.Conn) error {
+		for _, statement := range statements {
+			if err := conn.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
-	return nil
 }
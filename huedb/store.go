@@ -5,12 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"github.com/keep94/consume"
+	"github.com/keep94/gohue"
 	"github.com/keep94/marvin2/dynamic"
 	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/logging"
 	"github.com/keep94/marvin2/ops"
 	"github.com/keep94/tasks"
 	"github.com/keep94/toolbox/db"
-	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,6 +24,8 @@ var (
 	ErrNoSuchId = errors.New("huedb: No such Id.")
 	// Indicates that LightColors map has bad values.
 	ErrBadLightColors = errors.New("huedb: Bad values in LightColors.")
+	// Indicates that someone else changed the entity since it was read.
+	ErrConcurrentModification = errors.New("huedb: Concurrent modification.")
 )
 
 type NamedColorsByIdRunner interface {
@@ -31,13 +38,53 @@ type NamedColorsRunner interface {
 	NamedColors(t db.Transaction, consumer consume.Consumer) error
 }
 
+type NamedColorsByDescriptionRunner interface {
+	// NamedColorsByDescription gets the named colors whose description
+	// contains query ignoring case.
+	NamedColorsByDescription(
+		t db.Transaction, query string, consumer consume.Consumer) error
+}
+
+// NamedColorsOptions controls which named colors NamedColorsWithOptions
+// gets and in what order so that callers can page through named colors.
+type NamedColorsOptions struct {
+	// If non-empty, get only the named colors whose description contains
+	// Description ignoring case.
+	Description string
+
+	// If true, order by description ignoring case; otherwise order by id.
+	OrderByDescription bool
+
+	// The maximum number of named colors to get. 0 means no limit.
+	Limit int
+
+	// The number of named colors to skip.
+	Offset int
+}
+
+type NamedColorsWithOptionsRunner interface {
+	// NamedColorsWithOptions gets the named colors that options selects.
+	NamedColorsWithOptions(
+		t db.Transaction,
+		options *NamedColorsOptions,
+		consumer consume.Consumer) error
+}
+
 type AddNamedColorsRunner interface {
-	// AddNamedColros adds named colors.
+	// AddNamedColors adds named colors and sets their Id. Stores that
+	// support optimistic concurrency also set their Version. Stores that
+	// keep timestamps record when the named colors were added without
+	// changing the CreatedAt and UpdatedAt fields of colors.
 	AddNamedColors(t db.Transaction, colors *ops.NamedColors) error
 }
 
 type UpdateNamedColorsRunner interface {
-	// UpdateNamedColors updates named colors by id.
+	// UpdateNamedColors updates named colors by id. Stores that support
+	// optimistic concurrency return ErrConcurrentModification if the
+	// Version of colors is not 0 and does not match the stored version.
+	// Otherwise they set the Version of colors to the new stored version.
+	// UpdateNamedColors leaves the CreatedAt and UpdatedAt fields of colors
+	// unchanged.
 	UpdateNamedColors(t db.Transaction, colors *ops.NamedColors) error
 }
 
@@ -46,6 +93,85 @@ type RemoveNamedColorsRunner interface {
 	RemoveNamedColors(t db.Transaction, id int64) error
 }
 
+type NamedColorsModifiedSinceRunner interface {
+	// NamedColorsModifiedSince gets the named colors added or updated at
+	// or after since, most recently modified first.
+	NamedColorsModifiedSince(
+		t db.Transaction, since time.Time, consumer consume.Consumer) error
+}
+
+// ArchiveNamedColorsRunner soft deletes named colors. NamedColors,
+// NamedColorsByDescription, NamedColorsWithOptions, and
+// NamedColorsModifiedSince skip archived named colors, but
+// NamedColorsById still gets them so that persisted tasks referring to
+// them keep working.
+type ArchiveNamedColorsRunner interface {
+	// ArchiveNamedColors archives named colors by id.
+	ArchiveNamedColors(t db.Transaction, id int64) error
+}
+
+type RestoreNamedColorsRunner interface {
+	// RestoreNamedColors restores archived named colors by id.
+	RestoreNamedColors(t db.Transaction, id int64) error
+}
+
+type ArchivedNamedColorsRunner interface {
+	// ArchivedNamedColors gets all archived named colors.
+	ArchivedNamedColors(t db.Transaction, consumer consume.Consumer) error
+}
+
+// Scene is a multi-light scene that marvin captured and saved. Unlike
+// ops.NamedColors, a Scene holds the full state of each light including
+// color temperature. These instances must be treated as immutable.
+type Scene struct {
+	// The unique database dependent numeric ID of this scene.
+	Id int64
+
+	// The name of this scene.
+	Name string
+
+	// The state of each light in this scene.
+	States ops.LightStates
+
+	// Optional tags for grouping scenes e.g "party", "holiday".
+	Tags []string
+}
+
+// AsHueTask converts this instance to a HueTask with an Id from idRange.
+func (s *Scene) AsHueTask(idRange ops.IdRange) *ops.HueTask {
+	return &ops.HueTask{
+		Id:          idRange.Global(s.Id),
+		HueAction:   ops.StatesHueAction(s.States),
+		Description: s.Name,
+		Tags:        s.Tags,
+	}
+}
+
+type SceneByIdRunner interface {
+	// SceneById gets a scene by id.
+	SceneById(t db.Transaction, id int64, scene *Scene) error
+}
+
+type ScenesRunner interface {
+	// Scenes gets all scenes.
+	Scenes(t db.Transaction, consumer consume.Consumer) error
+}
+
+type AddSceneRunner interface {
+	// AddScene adds a scene.
+	AddScene(t db.Transaction, scene *Scene) error
+}
+
+type UpdateSceneRunner interface {
+	// UpdateScene updates a scene by id.
+	UpdateScene(t db.Transaction, scene *Scene) error
+}
+
+type RemoveSceneRunner interface {
+	// RemoveScene removes a scene by id.
+	RemoveScene(t db.Transaction, id int64) error
+}
+
 // HueTasks returns all the named colors as hue tasks.
 func HueTasks(store NamedColorsRunner) (ops.HueTaskList, error) {
 	var tasks ops.HueTaskList
@@ -80,7 +206,8 @@ func HueTaskById(store NamedColorsByIdRunner, hueTaskId int) *ops.HueTask {
 }
 
 // DescriptionMap maps hue task ids to descriptions. These instances must
-// be treated as immutable.
+// be treated as immutable. To change descriptions without recompiling,
+// store them as DescriptionOverride instances instead.
 type DescriptionMap map[int]string
 
 type descriptionMapFilter DescriptionMap
@@ -118,6 +245,83 @@ func FixDescriptionsRunner(
 		filter:   descriptionMapFilter(descriptionMap)}
 }
 
+// DescriptionOverride replaces the description of a persistent hue task
+// so that renaming a hue task does not require changing a DescriptionMap
+// in code.
+type DescriptionOverride struct {
+	// The id of the hue task
+	HueTaskId int
+
+	// The new description
+	Description string
+}
+
+type DescriptionOverrideRunner interface {
+	// DescriptionOverride gets the description override of a hue task by
+	// hue task id.
+	DescriptionOverride(
+		t db.Transaction, hueTaskId int, override *DescriptionOverride) error
+}
+
+type DescriptionOverridesRunner interface {
+	// DescriptionOverrides gets all description overrides.
+	DescriptionOverrides(t db.Transaction, consumer consume.Consumer) error
+}
+
+type SaveDescriptionOverrideRunner interface {
+	// SaveDescriptionOverride adds or replaces the description override
+	// of a hue task.
+	SaveDescriptionOverride(
+		t db.Transaction, override *DescriptionOverride) error
+}
+
+type RemoveDescriptionOverrideRunner interface {
+	// RemoveDescriptionOverride removes the description override of a hue
+	// task by hue task id.
+	RemoveDescriptionOverride(t db.Transaction, hueTaskId int) error
+}
+
+// LoadDescriptionMap returns the description overrides in store as a
+// DescriptionMap.
+func LoadDescriptionMap(
+	store DescriptionOverridesRunner) (DescriptionMap, error) {
+	return loadDescriptionMap(nil, store)
+}
+
+func loadDescriptionMap(
+	t db.Transaction,
+	store DescriptionOverridesRunner) (DescriptionMap, error) {
+	result := make(DescriptionMap)
+	consumer := consume.ConsumerFunc(func(ptr interface{}) {
+		override := ptr.(*DescriptionOverride)
+		result[override.HueTaskId] = override.Description
+	})
+	if err := store.DescriptionOverrides(t, consumer); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// FixDescriptionByIdStoreRunner works like FixDescriptionByIdRunner
+// except that it reads the description overrides from overrides each time
+// so that changes to overrides take effect right away.
+func FixDescriptionByIdStoreRunner(
+	delegate NamedColorsByIdRunner,
+	overrides DescriptionOverrideRunner) NamedColorsByIdRunner {
+	return &fixDescriptionByIdStoreRunner{
+		delegate: delegate, overrides: overrides}
+}
+
+// FixDescriptionsStoreRunner works like FixDescriptionsRunner except that
+// it reads the description overrides from overrides each time so that
+// changes to overrides take effect right away.
+func FixDescriptionsStoreRunner(
+	delegate NamedColorsRunner,
+	overrides DescriptionOverridesRunner) NamedColorsRunner {
+	return &fixDescriptionsStoreRunner{
+		delegate: delegate, overrides: overrides}
+}
+
 // FutureHueTask creates a HueTask from persistent storage by Id.
 type FutureHueTask struct {
 	// Id is the HueTaskId
@@ -140,6 +344,255 @@ func (f *FutureHueTask) GetDescription() string {
 	return f.Description
 }
 
+// LastParams holds the parameter values a user last submitted for a
+// dynamic hue task along with the hue action that the dynamic hue task
+// last generated.
+type LastParams struct {
+	// The id of the dynamic hue task
+	HueTaskId int
+
+	// The submitted values keyed like the url values that
+	// dynamic.HueTask.FromUrlValues takes but without the prefix.
+	Values url.Values
+
+	// The encoded form of the hue action the dynamic hue task last
+	// generated. Empty if it has generated nothing yet.
+	Action string
+
+	// The description of the hue task the dynamic hue task last generated
+	Description string
+}
+
+// NewLastParams returns the LastParams for the dynamic hue task with
+// given id from the url values the user submitted. prefix is the prefix
+// passed to dynamic.HueTask.FromUrlValues. Only url values that start with
+// prefix followed by a digit are kept.
+func NewLastParams(
+	hueTaskId int, prefix string, values url.Values) *LastParams {
+	result := &LastParams{HueTaskId: hueTaskId, Values: make(url.Values)}
+	for key, value := range values {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		key = key[len(prefix):]
+		if key == "" || key[0] < '0' || key[0] > '9' {
+			continue
+		}
+		result.Values[key] = append([]string(nil), value...)
+	}
+	return result
+}
+
+type LastParamsRunner interface {
+	// LastParams gets the last submitted parameter values for a dynamic
+	// hue task. LastParams returns ErrNoSuchId if there are none.
+	LastParams(t db.Transaction, hueTaskId int, params *LastParams) error
+}
+
+type SaveLastParamsRunner interface {
+	// SaveLastParams saves the last submitted parameter values for a
+	// dynamic hue task replacing any previously saved values.
+	SaveLastParams(t db.Transaction, params *LastParams) error
+}
+
+type LastParamsStore interface {
+	LastParamsRunner
+	SaveLastParamsRunner
+}
+
+// WithLastParams returns task with the parameter values last saved for it
+// in store as defaults. See dynamic.HueTask.WithDefaults. If store has no
+// saved values for task, WithLastParams returns task unchanged.
+func WithLastParams(
+	store LastParamsRunner, task *dynamic.HueTask) (*dynamic.HueTask, error) {
+	var params LastParams
+	err := store.LastParams(nil, task.Id, &params)
+	if err == ErrNoSuchId {
+		return task, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return task.WithDefaults(params.Values), nil
+}
+
+// SaveLastHueTask saves h, which a dynamic hue task generated, in store
+// so that LastHueTask can run it again later. encoder encodes the hue
+// action of h. SaveLastHueTask keeps the parameter values already saved
+// for the dynamic hue task.
+func SaveLastHueTask(
+	store LastParamsStore, encoder ActionEncoder, h *ops.HueTask) error {
+	action, err := encoder.Encode(h.Id, h.HueAction)
+	if err != nil {
+		return err
+	}
+	var params LastParams
+	err = store.LastParams(nil, h.Id, &params)
+	if err == ErrNoSuchId {
+		params = LastParams{HueTaskId: h.Id}
+	} else if err != nil {
+		return err
+	}
+	params.Action = action
+	params.Description = h.Description
+	return store.SaveLastParams(nil, &params)
+}
+
+// LastHueTask returns the hue task that the dynamic hue task with given
+// id last generated as saved by SaveLastHueTask. decoder decodes the
+// saved hue action. LastHueTask returns ErrNoSuchId if nothing was saved
+// for hueTaskId.
+func LastHueTask(
+	store LastParamsRunner,
+	decoder ActionDecoder,
+	hueTaskId int) (*ops.HueTask, error) {
+	var params LastParams
+	if err := store.LastParams(nil, hueTaskId, &params); err != nil {
+		return nil, err
+	}
+	if params.Action == "" {
+		return nil, ErrNoSuchId
+	}
+	action, err := decoder.Decode(hueTaskId, params.Action)
+	if err != nil {
+		return nil, err
+	}
+	return &ops.HueTask{
+		Id:          hueTaskId,
+		HueAction:   action,
+		Description: params.Description,
+	}, nil
+}
+
+// LastFired records when a scheduled task last fired.
+type LastFired struct {
+	// The id of the scheduled task
+	ScheduledTaskId int
+
+	// When the scheduled task last fired. Stores keep second precision.
+	Time time.Time
+}
+
+type LastFiredRunner interface {
+	// LastFired gets when a scheduled task last fired. LastFired returns
+	// ErrNoSuchId if the scheduled task never fired.
+	LastFired(t db.Transaction, scheduledTaskId int, fired *LastFired) error
+}
+
+type SaveLastFiredRunner interface {
+	// SaveLastFired saves when a scheduled task last fired replacing
+	// any previously saved time.
+	SaveLastFired(t db.Transaction, fired *LastFired) error
+}
+
+// LastFiredStore is the interface that FireTimeStore needs.
+type LastFiredStore interface {
+	LastFiredRunner
+	SaveLastFiredRunner
+}
+
+// FireTimeStore adapts a LastFiredStore to the utils.FireTimeStore
+// interface.
+type FireTimeStore struct {
+	store  LastFiredStore
+	logger logging.Logger
+}
+
+// NewFireTimeStore creates and returns a new FireTimeStore ready for use.
+// logger gets any errors from store.
+func NewFireTimeStore(
+	store LastFiredStore, logger logging.Logger) *FireTimeStore {
+	return &FireTimeStore{store: store, logger: logger}
+}
+
+// LastFired returns when the scheduled task with given id last fired.
+func (s *FireTimeStore) LastFired(scheduledTaskId int) (time.Time, bool) {
+	var fired LastFired
+	err := s.store.LastFired(nil, scheduledTaskId, &fired)
+	if err == ErrNoSuchId {
+		return time.Time{}, false
+	}
+	if err != nil {
+		s.logger.Log(
+			"Error reading last fired time",
+			logging.NewField("scheduled_task_id", scheduledTaskId),
+			logging.NewField("error", err))
+		return time.Time{}, false
+	}
+	return fired.Time, true
+}
+
+// SetLastFired records that the scheduled task with given id fired at t.
+func (s *FireTimeStore) SetLastFired(scheduledTaskId int, t time.Time) {
+	err := s.store.SaveLastFired(
+		nil, &LastFired{ScheduledTaskId: scheduledTaskId, Time: t})
+	if err != nil {
+		s.logger.Log(
+			"Error saving last fired time",
+			logging.NewField("scheduled_task_id", scheduledTaskId),
+			logging.NewField("error", err))
+	}
+}
+
+// RunTimeStore adapts a LastTaskRunRunner to the utils.RunTimeStore
+// interface so that a utils.RunGuard sees the runs in the task run log.
+type RunTimeStore struct {
+	store  LastTaskRunRunner
+	logger logging.Logger
+}
+
+// NewRunTimeStore creates and returns a new RunTimeStore ready for use.
+// logger gets any errors from store.
+func NewRunTimeStore(
+	store LastTaskRunRunner, logger logging.Logger) *RunTimeStore {
+	return &RunTimeStore{store: store, logger: logger}
+}
+
+// LastRun returns when the hue task with given id last started.
+func (s *RunTimeStore) LastRun(hueTaskId int) (time.Time, bool) {
+	var run TaskRun
+	err := s.store.LastTaskRun(nil, hueTaskId, &run)
+	if err == ErrNoSuchId {
+		return time.Time{}, false
+	}
+	if err != nil {
+		s.logger.Log(
+			"Error reading last run time",
+			logging.NewField("hue_task_id", hueTaskId),
+			logging.NewField("error", err))
+		return time.Time{}, false
+	}
+	return run.Start, true
+}
+
+// EnabledStore adapts an EnableEncodedScheduledTaskRunner to the
+// utils.EnabledStore interface. The ids it gets are the Ids of the
+// persisted scheduled tasks.
+type EnabledStore struct {
+	store  EnableEncodedScheduledTaskRunner
+	logger logging.Logger
+}
+
+// NewEnabledStore creates and returns a new EnabledStore ready for use.
+// logger gets any errors from store.
+func NewEnabledStore(
+	store EnableEncodedScheduledTaskRunner,
+	logger logging.Logger) *EnabledStore {
+	return &EnabledStore{store: store, logger: logger}
+}
+
+// SetEnabled saves whether the persisted scheduled task with given id is
+// enabled.
+func (s *EnabledStore) SetEnabled(id int, enabled bool) {
+	err := s.store.EnableEncodedScheduledTask(nil, int64(id), enabled)
+	if err != nil {
+		s.logger.Log(
+			"Error saving enabled state",
+			logging.NewField("scheduled_task_id", id),
+			logging.NewField("error", err))
+	}
+}
+
 // EncodedAtTimeTask is the form of ops.AtTimeTask that can be persisted to
 // a database.
 type EncodedAtTimeTask struct {
@@ -166,12 +619,32 @@ type EncodedAtTimeTask struct {
 
 	// The time the hue task is to run in seconds after Jan 1 1970 GMT
 	Time int64
+
+	// The time the hue task is to end in seconds after Jan 1 1970 GMT.
+	// 0 means the hue task ends on its own.
+	EndTime int64
+
+	// If true, lights are restored rather than turned off at EndTime.
+	RestoreAtEnd bool
+
+	// When this task was added and last changed. Stores set these when
+	// reading; they are zero if unknown.
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// EndedBefore returns true if this task both started and ended before
+// before which is in seconds after Jan 1 1970 GMT.
+func (e *EncodedAtTimeTask) EndedBefore(before int64) bool {
+	return e.Time < before && e.EndTime < before
 }
 
 // EncodedAtTimeTaskStore persists EncodedAtTimeTask instances.
 type EncodedAtTimeTaskStore interface {
 
-	// AddEncodedAtTimeTask adds a task.
+	// AddEncodedAtTimeTask adds a task and sets its Id. Stores that keep
+	// timestamps leave the CreatedAt and UpdatedAt fields of task
+	// unchanged.
 	AddEncodedAtTimeTask(t db.Transaction, task *EncodedAtTimeTask) error
 
 	// RemoveEncodedAtTimeTaskByScheduleId removes a task by
@@ -184,6 +657,140 @@ type EncodedAtTimeTaskStore interface {
 		t db.Transaction, groupId string, consumer consume.Consumer) error
 }
 
+type EncodedAtTimeTasksModifiedSinceRunner interface {
+	// EncodedAtTimeTasksModifiedSince gets the tasks in a particular group
+	// added or updated at or after since, most recently modified first.
+	EncodedAtTimeTasksModifiedSince(
+		t db.Transaction,
+		groupId string,
+		since time.Time,
+		consumer consume.Consumer) error
+}
+
+type AddEncodedAtTimeTasksRunner interface {
+	// AddEncodedAtTimeTasks adds tasks within a single transaction so that
+	// either all of them get added or none of them do.
+	AddEncodedAtTimeTasks(t db.Transaction, tasks []*EncodedAtTimeTask) error
+}
+
+// AddEncodedAtTimeTasks adds tasks to store. If store implements
+// AddEncodedAtTimeTasksRunner, AddEncodedAtTimeTasks uses it; otherwise it
+// adds tasks one at a time.
+func AddEncodedAtTimeTasks(
+	t db.Transaction,
+	store EncodedAtTimeTaskStore,
+	tasks []*EncodedAtTimeTask) error {
+	if batch, ok := store.(AddEncodedAtTimeTasksRunner); ok {
+		return batch.AddEncodedAtTimeTasks(t, tasks)
+	}
+	for _, task := range tasks {
+		if err := store.AddEncodedAtTimeTask(t, task); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type RemoveEncodedAtTimeTasksBeforeRunner interface {
+	// RemoveEncodedAtTimeTasksBefore removes the tasks in every group
+	// that ended before before which is in seconds after Jan 1 1970 GMT.
+	// See EncodedAtTimeTask.EndedBefore.
+	RemoveEncodedAtTimeTasksBefore(t db.Transaction, before int64) error
+}
+
+// ExpireAtTimeTasks removes the tasks in store that ended more than maxAge
+// before now. Call ExpireAtTimeTasks at start up so that a scheduler
+// that was down for a long time does not run stale tasks.
+func ExpireAtTimeTasks(
+	store RemoveEncodedAtTimeTasksBeforeRunner,
+	now time.Time,
+	maxAge time.Duration) error {
+	return store.RemoveEncodedAtTimeTasksBefore(nil, now.Add(-maxAge).Unix())
+}
+
+// EncodedScheduledTask is the form of a recurring utils.ScheduledTask that
+// can be persisted to a database.
+type EncodedScheduledTask struct {
+	// The unique database dependent numeric ID of this scheduled task.
+	Id int64
+
+	// The ID of the scheduled hue task.
+	HueTaskId int
+
+	// The encoded form of the hue action in the scheduled hue task.
+	Action string
+
+	// The description of the scheduled hue task.
+	Description string
+
+	// The encoded set of lights on which the scheduled hue task will run.
+	LightSet string
+
+	// The spec of the recurring time that says when the scheduled hue task
+	// runs. See recurring.Parse.
+	Recurring string
+
+	// The priority of the scheduled hue task. The scheduled hue task
+	// interrupts only running tasks with lower priority. See
+	// utils.MultiExecutor.StartWithPriority.
+	Priority int
+
+	// If true the scheduled hue task is enabled at startup.
+	Enabled bool
+}
+
+type EncodedScheduledTasksRunner interface {
+	// EncodedScheduledTasks fetches all scheduled tasks.
+	EncodedScheduledTasks(t db.Transaction, consumer consume.Consumer) error
+}
+
+type AddEncodedScheduledTaskRunner interface {
+	// AddEncodedScheduledTask adds a scheduled task.
+	AddEncodedScheduledTask(t db.Transaction, task *EncodedScheduledTask) error
+}
+
+type UpdateEncodedScheduledTaskRunner interface {
+	// UpdateEncodedScheduledTask updates a scheduled task by id.
+	UpdateEncodedScheduledTask(
+		t db.Transaction, task *EncodedScheduledTask) error
+}
+
+type RemoveEncodedScheduledTaskRunner interface {
+	// RemoveEncodedScheduledTask removes a scheduled task by id.
+	RemoveEncodedScheduledTask(t db.Transaction, id int64) error
+}
+
+type EnableEncodedScheduledTaskRunner interface {
+	// EnableEncodedScheduledTask sets the Enabled field of a scheduled
+	// task by id.
+	EnableEncodedScheduledTask(t db.Transaction, id int64, enabled bool) error
+}
+
+// NewEncodedScheduledTask encodes h so that it runs on lightSet at the
+// times that recurringSpec describes. priority and enabled become the
+// Priority and Enabled fields of the returned value.
+func NewEncodedScheduledTask(
+	encoder ActionEncoder,
+	h *ops.HueTask,
+	lightSet lights.Set,
+	recurringSpec string,
+	priority int,
+	enabled bool) (*EncodedScheduledTask, error) {
+	action, err := encoder.Encode(h.Id, h.HueAction)
+	if err != nil {
+		return nil, err
+	}
+	return &EncodedScheduledTask{
+		HueTaskId:   h.Id,
+		Action:      action,
+		Description: h.Description,
+		LightSet:    lightSet.String(),
+		Recurring:   recurringSpec,
+		Priority:    priority,
+		Enabled:     enabled,
+	}, nil
+}
+
 // ActionEncoder converts a hue action to a string.
 // hueTaskId is the id of the enclosing hue task;
 // action is what is to be encoded.
@@ -214,7 +821,12 @@ type DynamicHueTaskStore interface {
 // If hueTaskId >= ops.PersistentTaskIdOffset, then Encode returns the
 // empty string with no error.
 func NewActionEncoder(store DynamicHueTaskStore) ActionEncoder {
-	return basicActionEncoder{store}
+	return NewIdSpaceActionEncoder(
+		ops.DefaultIdSpace,
+		map[string]ActionEncoder{
+			ops.StaticIdRange:     NewDynamicActionEncoder(store),
+			ops.PersistentIdRange: NewNamedColorsActionEncoder(),
+		})
 }
 
 // NewActionDecoder returns an ActionDecoder.
@@ -229,7 +841,105 @@ func NewActionEncoder(store DynamicHueTaskStore) ActionEncoder {
 func NewActionDecoder(
 	store DynamicHueTaskStore,
 	dbStore NamedColorsByIdRunner) ActionDecoder {
-	return &basicActionDecoder{store: store, dbStore: dbStore}
+	persistent, _ := ops.DefaultIdSpace.Range(ops.PersistentIdRange)
+	return NewIdSpaceActionDecoder(
+		ops.DefaultIdSpace,
+		map[string]ActionDecoder{
+			ops.StaticIdRange: NewDynamicActionDecoder(store),
+			ops.PersistentIdRange: NewNamedColorsActionDecoder(
+				dbStore, persistent),
+		})
+}
+
+// NewIdSpaceActionEncoder returns an ActionEncoder that routes each
+// hue task id to the ActionEncoder in encoders keyed by the name of the
+// range in space that contains that id. The returned ActionEncoder
+// reports an error for ids that are in no range or in a range with no
+// ActionEncoder.
+func NewIdSpaceActionEncoder(
+	space *ops.IdSpace, encoders map[string]ActionEncoder) ActionEncoder {
+	return &idSpaceActionEncoder{space: space, encoders: encoders}
+}
+
+// NewIdSpaceActionDecoder returns an ActionDecoder that routes each
+// hue task id to the ActionDecoder in decoders keyed by the name of the
+// range in space that contains that id. The returned ActionDecoder
+// reports an error for ids that are in no range or in a range with no
+// ActionDecoder.
+func NewIdSpaceActionDecoder(
+	space *ops.IdSpace, decoders map[string]ActionDecoder) ActionDecoder {
+	return &idSpaceActionDecoder{space: space, decoders: decoders}
+}
+
+// NewDynamicActionEncoder returns an ActionEncoder for hue tasks from
+// store. Encode uses store to look up the HueTask by hueTaskId and
+// delegates to the Factory field of the fetched hue task after converting
+// it to a dynamic.Encoder. Encode reports an error if the Factory field
+// cannot be converted to a dynamic.Encoder.
+func NewDynamicActionEncoder(store DynamicHueTaskStore) ActionEncoder {
+	return basicActionEncoder{store}
+}
+
+// NewDynamicActionDecoder returns an ActionDecoder for hue tasks from
+// store. Decode uses store to look up the HueTask by hueTaskId and
+// delegates to the Factory field of the fetched hue task after converting
+// it to a dynamic.Decoder. Decode reports an error if the Factory field
+// cannot be converted to a dynamic.Decoder.
+func NewDynamicActionDecoder(store DynamicHueTaskStore) ActionDecoder {
+	return basicActionDecoder{store}
+}
+
+// NewNamedColorsActionEncoder returns an ActionEncoder for hue tasks
+// made from named colors. Since the named colors themselves are
+// persisted, Encode always returns the empty string with no error.
+func NewNamedColorsActionEncoder() ActionEncoder {
+	return namedColorsActionEncoder{}
+}
+
+// NewNamedColorsActionDecoder returns an ActionDecoder for hue tasks
+// made from named colors. Decode uses dbStore to look up the named colors
+// with id idRange.Local(hueTaskId).
+func NewNamedColorsActionDecoder(
+	dbStore NamedColorsByIdRunner, idRange ops.IdRange) ActionDecoder {
+	return &namedColorsActionDecoder{dbStore: dbStore, idRange: idRange}
+}
+
+type idSpaceActionEncoder struct {
+	space    *ops.IdSpace
+	encoders map[string]ActionEncoder
+}
+
+func (b *idSpaceActionEncoder) Encode(
+	id int, action ops.HueAction) (string, error) {
+	idRange, err := b.space.RangeOf(id)
+	if err != nil {
+		return "", fmt.Errorf("Hue task ID %d: %v", id, err)
+	}
+	encoder, ok := b.encoders[idRange.Name]
+	if !ok {
+		return "", fmt.Errorf(
+			"No encoder for hue task ID %d in range %s", id, idRange.Name)
+	}
+	return encoder.Encode(id, action)
+}
+
+type idSpaceActionDecoder struct {
+	space    *ops.IdSpace
+	decoders map[string]ActionDecoder
+}
+
+func (b *idSpaceActionDecoder) Decode(
+	id int, encoded string) (ops.HueAction, error) {
+	idRange, err := b.space.RangeOf(id)
+	if err != nil {
+		return nil, fmt.Errorf("Hue task ID %d: %v", id, err)
+	}
+	decoder, ok := b.decoders[idRange.Name]
+	if !ok {
+		return nil, fmt.Errorf(
+			"No decoder for hue task ID %d in range %s", id, idRange.Name)
+	}
+	return decoder.Decode(id, encoded)
 }
 
 type basicActionEncoder struct {
@@ -238,9 +948,6 @@ type basicActionEncoder struct {
 
 func (b basicActionEncoder) Encode(
 	id int, action ops.HueAction) (string, error) {
-	if id >= ops.PersistentTaskIdOffset {
-		return "", nil
-	}
 	task := b.store.ById(id)
 	if task == nil {
 		return "", errors.New(fmt.Sprintf("No such Dynamic HueTask ID: %d", id))
@@ -254,20 +961,11 @@ func (b basicActionEncoder) Encode(
 }
 
 type basicActionDecoder struct {
-	store   DynamicHueTaskStore
-	dbStore NamedColorsByIdRunner
+	store DynamicHueTaskStore
 }
 
-func (b *basicActionDecoder) Decode(
+func (b basicActionDecoder) Decode(
 	id int, encoded string) (ops.HueAction, error) {
-	if id >= ops.PersistentTaskIdOffset {
-		var namedColors ops.NamedColors
-		if err := b.dbStore.NamedColorsById(
-			nil, int64(id-ops.PersistentTaskIdOffset), &namedColors); err != nil {
-			return nil, err
-		}
-		return ops.StaticHueAction(namedColors.Colors), nil
-	}
 	task := b.store.ById(id)
 	if task == nil {
 		return nil, errors.New(fmt.Sprintf("No such Dynamic HueTask ID: %d", id))
@@ -280,13 +978,37 @@ func (b *basicActionDecoder) Decode(
 	return decoder.Decode(encoded)
 }
 
+type namedColorsActionEncoder struct {
+}
+
+func (n namedColorsActionEncoder) Encode(
+	id int, action ops.HueAction) (string, error) {
+	return "", nil
+}
+
+type namedColorsActionDecoder struct {
+	dbStore NamedColorsByIdRunner
+	idRange ops.IdRange
+}
+
+func (n *namedColorsActionDecoder) Decode(
+	id int, encoded string) (ops.HueAction, error) {
+	var namedColors ops.NamedColors
+	if err := n.dbStore.NamedColorsById(
+		nil, n.idRange.Local(id), &namedColors); err != nil {
+		return nil, err
+	}
+	return ops.StaticHueAction(namedColors.Colors), nil
+}
+
 // AtTimeTaskStore is a store for ops.AtTimeTask instances.
 type AtTimeTaskStore struct {
 	encoder ActionEncoder
 	decoder ActionDecoder
 	store   EncodedAtTimeTaskStore
 	groupId string
-	logger  *log.Logger
+	logger  logging.Logger
+	maxAge  time.Duration
 }
 
 // NewAtTimeTaskStore creates and returns a new AtTimeTaskStore ready for use
@@ -295,7 +1017,7 @@ func NewAtTimeTaskStore(
 	decoder ActionDecoder,
 	store EncodedAtTimeTaskStore,
 	groupId string,
-	logger *log.Logger) *AtTimeTaskStore {
+	logger logging.Logger) *AtTimeTaskStore {
 	return &AtTimeTaskStore{
 		encoder: encoder,
 		decoder: decoder,
@@ -304,22 +1026,39 @@ func NewAtTimeTaskStore(
 		logger:  logger}
 }
 
+// SkipExpired makes All skip and remove tasks that ended more than maxAge
+// ago. maxAge of 0 or less, the default, means All returns every task.
+// SkipExpired must be called before this instance is used.
+func (s *AtTimeTaskStore) SkipExpired(maxAge time.Duration) {
+	s.maxAge = maxAge
+}
+
 // All returns all tasks.
 func (s *AtTimeTaskStore) All() []*ops.AtTimeTask {
 	var allEncoded []*EncodedAtTimeTask
 	consumer := consume.AppendPtrsTo(&allEncoded)
 	if err := s.store.EncodedAtTimeTasks(nil, s.groupId, consumer); err != nil {
-		s.logger.Println(err)
+		s.logError("Error reading at time tasks", err)
 		return nil
 	}
+	var expiredBefore int64
+	if s.maxAge > 0 {
+		expiredBefore = time.Now().Add(-s.maxAge).Unix()
+	}
 	result := make([]*ops.AtTimeTask, len(allEncoded))
 	idx := 0
 	for i := range allEncoded {
-		atask := s.asAtTimeTask(allEncoded[i])
+		var atask *ops.AtTimeTask
+		if s.maxAge <= 0 || !allEncoded[i].EndedBefore(expiredBefore) {
+			atask = s.asAtTimeTask(allEncoded[i])
+		}
 		if atask == nil {
 			if err := s.store.RemoveEncodedAtTimeTaskByScheduleId(
 				nil, s.groupId, allEncoded[i].ScheduleId); err != nil {
-				s.logger.Println(err)
+				s.logError(
+					"Error removing at time task",
+					err,
+					logging.NewField("schedule_id", allEncoded[i].ScheduleId))
 			}
 		} else {
 			result[idx] = atask
@@ -331,31 +1070,75 @@ func (s *AtTimeTaskStore) All() []*ops.AtTimeTask {
 
 // Add adds a new scheduled task
 func (s *AtTimeTaskStore) Add(task *ops.AtTimeTask) {
+	encoded := s.asEncoded(task)
+	if encoded == nil {
+		return
+	}
+	if err := s.store.AddEncodedAtTimeTask(nil, encoded); err != nil {
+		s.logError(
+			"Error adding at time task",
+			err,
+			logging.NewField("schedule_id", encoded.ScheduleId))
+	}
+}
+
+// AddAll adds several new scheduled tasks at once which is much faster
+// than calling Add for each task when the underlying store supports
+// adding in batches. AddAll skips tasks whose actions cannot be encoded.
+func (s *AtTimeTaskStore) AddAll(tasks []*ops.AtTimeTask) {
+	allEncoded := make([]*EncodedAtTimeTask, 0, len(tasks))
+	for _, task := range tasks {
+		if encoded := s.asEncoded(task); encoded != nil {
+			allEncoded = append(allEncoded, encoded)
+		}
+	}
+	if err := AddEncodedAtTimeTasks(nil, s.store, allEncoded); err != nil {
+		s.logError("Error adding at time tasks", err)
+	}
+}
+
+// Remove removes a scheduled task by id
+func (s *AtTimeTaskStore) Remove(scheduleId string) {
+	err := s.store.RemoveEncodedAtTimeTaskByScheduleId(nil, s.groupId, scheduleId)
+	if err != nil {
+		s.logError(
+			"Error removing at time task",
+			err,
+			logging.NewField("schedule_id", scheduleId))
+	}
+}
+
+func (s *AtTimeTaskStore) logError(
+	msg string, err error, fields ...logging.Field) {
+	fields = append(
+		fields,
+		logging.NewField("group_id", s.groupId),
+		logging.NewField("error", err))
+	s.logger.Log(msg, fields...)
+}
+
+func (s *AtTimeTaskStore) asEncoded(task *ops.AtTimeTask) *EncodedAtTimeTask {
 	var encoded EncodedAtTimeTask
 	var err error
 	encoded.Action, err = s.encoder.Encode(task.H.Id, task.H.HueAction)
 	if err != nil {
-		s.logger.Printf("While encoding hue task %d: %v", task.H.Id, err)
-		return
+		s.logError(
+			"Error encoding hue task",
+			err,
+			logging.NewField("hue_task_id", task.H.Id))
+		return nil
 	}
 	encoded.ScheduleId = task.Id
 	encoded.HueTaskId = task.H.Id
 	encoded.Description = task.H.Description
 	encoded.LightSet = task.Ls.String()
 	encoded.Time = task.StartTime.Unix()
-	encoded.GroupId = s.groupId
-	err = s.store.AddEncodedAtTimeTask(nil, &encoded)
-	if err != nil {
-		s.logger.Println(err)
-	}
-}
-
-// Remove removes a scheduled task by id
-func (s *AtTimeTaskStore) Remove(scheduleId string) {
-	err := s.store.RemoveEncodedAtTimeTaskByScheduleId(nil, s.groupId, scheduleId)
-	if err != nil {
-		s.logger.Println(err)
+	if !task.EndTime.IsZero() {
+		encoded.EndTime = task.EndTime.Unix()
+		encoded.RestoreAtEnd = task.RestoreAtEnd
 	}
+	encoded.GroupId = s.groupId
+	return &encoded
 }
 
 func (s *AtTimeTaskStore) asAtTimeTask(encoded *EncodedAtTimeTask) *ops.AtTimeTask {
@@ -367,19 +1150,30 @@ func (s *AtTimeTaskStore) asAtTimeTask(encoded *EncodedAtTimeTask) *ops.AtTimeTa
 	resultH.HueAction, err = s.decoder.Decode(
 		encoded.HueTaskId, encoded.Action)
 	if err != nil {
-		s.logger.Printf("While decoding hue task %d: %v", encoded.HueTaskId, err)
+		s.logError(
+			"Error decoding hue task",
+			err,
+			logging.NewField("hue_task_id", encoded.HueTaskId))
 		return nil
 	}
 	resultLs, err := lights.InvString(encoded.LightSet)
 	if err != nil {
-		s.logger.Printf("Error parsing light set %s", encoded.LightSet)
+		s.logError(
+			"Error parsing light set",
+			err,
+			logging.NewField("light_set", encoded.LightSet))
 		return nil
 	}
-	return &ops.AtTimeTask{
+	result := &ops.AtTimeTask{
 		Id:        encoded.ScheduleId,
 		H:         resultH,
 		Ls:        resultLs,
 		StartTime: time.Unix(encoded.Time, 0)}
+	if encoded.EndTime != 0 {
+		result.EndTime = time.Unix(encoded.EndTime, 0)
+		result.RestoreAtEnd = encoded.RestoreAtEnd
+	}
+	return result
 }
 
 type errAction struct {
@@ -421,3 +1215,191 @@ func (r *fixDescriptionByIdRunner) NamedColorsById(
 	r.filter.Filter(&origNamedColors, namedColors)
 	return nil
 }
+
+type fixDescriptionByIdStoreRunner struct {
+	delegate  NamedColorsByIdRunner
+	overrides DescriptionOverrideRunner
+}
+
+func (r *fixDescriptionByIdStoreRunner) NamedColorsById(
+	t db.Transaction, id int64, namedColors *ops.NamedColors) error {
+	if err := r.delegate.NamedColorsById(t, id, namedColors); err != nil {
+		return err
+	}
+	var override DescriptionOverride
+	err := r.overrides.DescriptionOverride(
+		t, int(id)+ops.PersistentTaskIdOffset, &override)
+	if err == ErrNoSuchId {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	namedColors.Description = override.Description
+	return nil
+}
+
+type fixDescriptionsStoreRunner struct {
+	delegate  NamedColorsRunner
+	overrides DescriptionOverridesRunner
+}
+
+func (r *fixDescriptionsStoreRunner) NamedColors(
+	t db.Transaction, consumer consume.Consumer) error {
+	descriptionMap, err := loadDescriptionMap(t, r.overrides)
+	if err != nil {
+		return err
+	}
+	return FixDescriptionsRunner(
+		r.delegate, descriptionMap).NamedColors(t, consumer)
+}
+
+// kFavoriteKeyPrefix followed by the id of a named colors entry is the
+// key of a favorite color in the picker that NamedColorsPicker returns.
+const kFavoriteKeyPrefix = "nc"
+
+// NamedColorsPicker returns a dynamic.Param that lets the user choose a
+// color from the predefined colors of dynamic.ColorChoices followed by
+// the favorite colors in store. A favorite color is a named colors entry
+// that sets the color of exactly one light, and its choice name is the
+// description of the entry. Favorite colors are keyed by the id of
+// their entry as in dynamic.KeyedPicker so that saved choices keep
+// their color when entries are added or removed. The returned Param
+// reads store lazily: the first time it is used and then again once
+// maxAge has passed since the last read. maxAge of 0 or less means read
+// every time. If reading store fails, the returned Param keeps the
+// choices it already has. defaultColor and defaultName are as in
+// dynamic.ColorPicker.
+func NamedColorsPicker(
+	store NamedColorsRunner,
+	defaultColor gohue.Color,
+	defaultName string,
+	maxAge time.Duration) dynamic.Param {
+	return &namedColorsPicker{
+		cache: &namedColorsPickerCache{
+			store:        store,
+			defaultColor: defaultColor,
+			defaultName:  defaultName,
+			maxAge:       maxAge,
+		},
+	}
+}
+
+// NamedColorsPlainFactory returns a dynamic.PlainFactory whose color
+// picker includes the favorite colors in store. The default color is
+// white. See NamedColorsPicker.
+func NamedColorsPlainFactory(
+	store NamedColorsRunner, maxAge time.Duration) dynamic.PlainFactory {
+	return dynamic.NewPlainFactory(
+		NamedColorsPicker(store, gohue.White, "White", maxAge))
+}
+
+// FavoriteColors returns the favorite colors in store as choices in the
+// order store returns them. See NamedColorsPicker.
+func FavoriteColors(store NamedColorsRunner) (dynamic.ChoiceList, error) {
+	choices, _, err := favoriteColors(store)
+	return choices, err
+}
+
+// favoriteColors returns the favorite colors in store along with their
+// keys.
+func favoriteColors(store NamedColorsRunner) (
+	choices dynamic.ChoiceList, keys []string, err error) {
+	consumer := consume.MapFilter(
+		consume.AppendTo(&choices),
+		func(src *ops.NamedColors, dest *dynamic.Choice) bool {
+			if len(src.Colors) != 1 {
+				return false
+			}
+			for _, colorBrightness := range src.Colors {
+				if !colorBrightness.Color.Valid {
+					return false
+				}
+				*dest = dynamic.Choice{
+					Name:  src.Description,
+					Value: colorBrightness.Color.Color,
+				}
+			}
+			keys = append(
+				keys, kFavoriteKeyPrefix+strconv.FormatInt(src.Id, 10))
+			return true
+		})
+	if err = store.NamedColors(nil, consumer); err != nil {
+		return nil, nil, err
+	}
+	return
+}
+
+type namedColorsPickerCache struct {
+	store        NamedColorsRunner
+	defaultColor gohue.Color
+	defaultName  string
+	maxAge       time.Duration
+	mutex        sync.Mutex
+	picker       dynamic.Param
+	lastRead     time.Time
+}
+
+func (c *namedColorsPickerCache) get() dynamic.Param {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	if c.picker != nil && now.Sub(c.lastRead) < c.maxAge {
+		return c.picker
+	}
+	favorites, favoriteKeys, err := favoriteColors(c.store)
+	if err != nil {
+		if c.picker == nil {
+			return dynamic.ColorPicker(c.defaultColor, c.defaultName)
+		}
+		return c.picker
+	}
+	builtIn := dynamic.ColorChoices()
+	keys := append(make([]string, len(builtIn)), favoriteKeys...)
+	c.picker = dynamic.KeyedPicker(
+		append(builtIn, favorites...),
+		keys,
+		c.defaultColor,
+		c.defaultName)
+	c.lastRead = now
+	return c.picker
+}
+
+type namedColorsPicker struct {
+	cache     *namedColorsPickerCache
+	translate dynamic.Translator
+}
+
+func (p *namedColorsPicker) current() dynamic.Param {
+	result := p.cache.get()
+	if p.translate != nil {
+		return dynamic.Localize(result, p.translate)
+	}
+	return result
+}
+
+func (p *namedColorsPicker) Selection() []string {
+	return p.current().Selection()
+}
+
+func (p *namedColorsPicker) MaxCharCount() int {
+	return p.current().MaxCharCount()
+}
+
+func (p *namedColorsPicker) Convert(s string) (interface{}, string) {
+	return p.current().Convert(s)
+}
+
+func (p *namedColorsPicker) ConvertStrict(
+	s string) (interface{}, string, error) {
+	return dynamic.ConvertStrict(p.current(), s)
+}
+
+func (p *namedColorsPicker) Schema() *dynamic.ParamSchema {
+	return dynamic.Schema("", dynamic.NamedParam{Param: p.current()})
+}
+
+func (p *namedColorsPicker) Localize(
+	translate dynamic.Translator) dynamic.Param {
+	return &namedColorsPicker{cache: p.cache, translate: translate}
+}
@@ -2,6 +2,7 @@ package huedb_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"github.com/keep94/consume"
 	"github.com/keep94/gohue"
@@ -9,25 +10,28 @@ import (
 	"github.com/keep94/marvin2/dynamic"
 	"github.com/keep94/marvin2/huedb"
 	"github.com/keep94/marvin2/huedb/for_sqlite"
+	"github.com/keep94/marvin2/huedb/in_memory"
 	"github.com/keep94/marvin2/huedb/sqlite_setup"
 	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/logging"
 	"github.com/keep94/marvin2/ops"
 	"github.com/keep94/maybe"
 	"github.com/keep94/tasks"
 	"github.com/keep94/toolbox/db"
 	"github.com/keep94/toolbox/db/sqlite_db"
 	"log"
+	"net/url"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
 var (
-	kNilEncodedAtTimeTask = &huedb.EncodedAtTimeTask{}
-	kEncodeNotSupported   = errors.New("huedb: Encode not supported")
-	kDecodeNotSupported   = errors.New("huedb: Decode not supported")
-	kDbError              = errors.New("huedb: Some database error.")
+	kEncodeNotSupported = errors.New("huedb: Encode not supported")
+	kDecodeNotSupported = errors.New("huedb: Decode not supported")
+	kDbError            = errors.New("huedb: Some database error.")
 )
 
 const (
@@ -81,6 +85,14 @@ var (
 			Description: "Baz",
 		},
 	}
+	kImportConfig = &huedb.ImportConfig{
+		NamedColors: ops.IdRange{
+			Name:  ops.PersistentIdRange,
+			Start: ops.PersistentTaskIdOffset,
+			End:   20000,
+		},
+		Scenes: ops.IdRange{Name: "scenes", Start: 20000, End: 30000},
+	}
 )
 
 func TestHueTasks(t *testing.T) {
@@ -110,6 +122,43 @@ func TestHueTaskById2(t *testing.T) {
 	}
 }
 
+func TestFixDescriptionsFromStore(t *testing.T) {
+	overrides := in_memory.New()
+	if err := overrides.SaveDescriptionOverride(
+		nil,
+		&huedb.DescriptionOverride{HueTaskId: 10004, Description: "Baz"}); err != nil {
+		t.Fatalf("Error saving: %v", err)
+	}
+	descriptionMap, err := huedb.LoadDescriptionMap(overrides)
+	if err != nil {
+		t.Fatalf("Error loading: %v", err)
+	}
+	if !reflect.DeepEqual(kDescriptionMap, descriptionMap) {
+		t.Errorf("Expected %v, got %v", kDescriptionMap, descriptionMap)
+	}
+	tasks, err := huedb.HueTasks(huedb.FixDescriptionsStoreRunner(
+		kFakeStore, overrides))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if !reflect.DeepEqual(kExpectedHueTasks, tasks) {
+		t.Errorf("Expected %v, got %v", kExpectedHueTasks, tasks)
+	}
+	byIdRunner := huedb.FixDescriptionByIdStoreRunner(
+		fakeNamedColorsByIdRunner{kFakeStore[1]}, overrides)
+	task := huedb.HueTaskById(byIdRunner, 10004)
+	if !reflect.DeepEqual(kExpectedHueTasks[1], task) {
+		t.Errorf("Expected %v, got %v", kExpectedHueTasks[1], task)
+	}
+	// Changes to overrides take effect right away
+	if err := overrides.RemoveDescriptionOverride(nil, 10004); err != nil {
+		t.Fatalf("Error removing: %v", err)
+	}
+	if task := huedb.HueTaskById(byIdRunner, 10004); task.Description != "Bar" {
+		t.Errorf("Expected Bar, got %s", task.Description)
+	}
+}
+
 func TestHueTaskByIdError(t *testing.T) {
 	task := huedb.HueTaskById(
 		fakeNamedColorsByIdRunner{kFakeStore[1]}, 10003)
@@ -122,10 +171,10 @@ func TestHueTaskByIdError2(t *testing.T) {
 }
 
 func TestActionEncoder(t *testing.T) {
-	fakeStore := fakeDynamicHueTaskStore{
-		35: &dynamic.HueTask{Id: 35, Factory: fakeSpecificActionEncoder(135)},
-		36: &dynamic.HueTask{Id: 36, Factory: badFactory{}},
-	}
+	fakeStore := newRegistry(
+		&dynamic.HueTask{Id: 35, Factory: fakeSpecificActionEncoder(135)},
+		&dynamic.HueTask{Id: 36, Factory: badFactory{}},
+	)
 	ae := huedb.NewActionEncoder(fakeStore)
 	if actual, err := ae.Encode(10007, intAction(52)); actual != "" || err != nil {
 		t.Errorf("Expected empty string and no error, got %s with %v", actual, err)
@@ -142,11 +191,11 @@ func TestActionEncoder(t *testing.T) {
 }
 
 func TestActionDecoder(t *testing.T) {
-	fakeStore := fakeDynamicHueTaskStore{
-		42: &dynamic.HueTask{Id: 42, Factory: fakeSpecificActionEncoder(142)},
-		43: &dynamic.HueTask{Id: 43, Factory: badFactory{}},
-		44: &dynamic.HueTask{Id: 44, Factory: fakeSpecificActionEncoder(kIdDoesNotSupportDecode)},
-	}
+	fakeStore := newRegistry(
+		&dynamic.HueTask{Id: 42, Factory: fakeSpecificActionEncoder(142)},
+		&dynamic.HueTask{Id: 43, Factory: badFactory{}},
+		&dynamic.HueTask{Id: 44, Factory: fakeSpecificActionEncoder(kIdDoesNotSupportDecode)},
+	)
 	fakeDbStore := fakeNamedColorsByIdRunner{kFakeStore[0]}
 	ad := huedb.NewActionDecoder(fakeStore, fakeDbStore)
 	actual, err := ad.Decode(10002, "")
@@ -176,25 +225,648 @@ func TestActionDecoder(t *testing.T) {
 	}
 }
 
+func TestIdSpaceActionEncoderDecoder(t *testing.T) {
+	space := ops.MustIdSpace(
+		ops.IdRange{Name: "first", Start: 0, End: 100},
+		ops.IdRange{Name: "second", Start: 100, End: 200},
+		ops.IdRange{Name: "third", Start: 200, End: 300})
+	var fakeEncoder fakeActionEncoder
+	encoder := huedb.NewIdSpaceActionEncoder(
+		space,
+		map[string]huedb.ActionEncoder{
+			"first":  fakeEncoder,
+			"second": huedb.NewNamedColorsActionEncoder(),
+		})
+	decoder := huedb.NewIdSpaceActionDecoder(
+		space,
+		map[string]huedb.ActionDecoder{"first": fakeEncoder})
+	if out, err := encoder.Encode(5, intAction(10)); out != "15" || err != nil {
+		t.Errorf("Expected 15, got %s, %v", out, err)
+	}
+	if out, err := encoder.Encode(105, intAction(10)); out != "" || err != nil {
+		t.Errorf("Expected empty string, got %s, %v", out, err)
+	}
+	if _, err := encoder.Encode(205, intAction(10)); err == nil {
+		t.Error("Expected error for range with no encoder")
+	}
+	if _, err := encoder.Encode(305, intAction(10)); err == nil {
+		t.Error("Expected error for id in no range")
+	}
+	if out, err := decoder.Decode(5, "15"); out != intAction(10) || err != nil {
+		t.Errorf("Expected 10, got %v, %v", out, err)
+	}
+	if _, err := decoder.Decode(105, "15"); err == nil {
+		t.Error("Expected error for range with no decoder")
+	}
+}
+
+func TestNewLastParams(t *testing.T) {
+	values := url.Values{
+		"p0":   {"3", "1"},
+		"p1":   {"98"},
+		"p0.1": {"5"},
+		"pid":  {"12"},
+		"q0":   {"4"},
+	}
+	expected := &huedb.LastParams{
+		HueTaskId: 12,
+		Values: url.Values{
+			"0":   {"3", "1"},
+			"1":   {"98"},
+			"0.1": {"5"},
+		},
+	}
+	if actual := huedb.NewLastParams(12, "p", values); !reflect.DeepEqual(
+		expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestSceneAsHueTask(t *testing.T) {
+	scene := &huedb.Scene{
+		Id:     3,
+		Name:   "Movie",
+		States: ops.LightStates{2: {On: true}},
+		Tags:   []string{"evening"},
+	}
+	expected := &ops.HueTask{
+		Id:          20003,
+		HueAction:   ops.StatesHueAction{2: {On: true}},
+		Description: "Movie",
+		Tags:        []string{"evening"},
+	}
+	actual := scene.AsHueTask(
+		ops.IdRange{Name: "scenes", Start: 20000, End: 30000})
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	source := in_memory.New()
+	sunset := &ops.NamedColors{Description: "Sunset", Colors: kColorMap1}
+	old := &ops.NamedColors{Description: "Old", Colors: kColorMap2}
+	scene := &huedb.Scene{
+		Name:   "Movie",
+		States: ops.LightStates{2: {On: true, Ct: maybe.NewUint16(300)}},
+		Tags:   []string{"evening"},
+	}
+	if err := source.AddNamedColors(nil, sunset); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	if err := source.AddNamedColors(nil, old); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	if err := source.ArchiveNamedColors(nil, old.Id); err != nil {
+		t.Fatalf("Got error archiving: %v", err)
+	}
+	if err := source.AddScene(nil, scene); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	scheduled := &huedb.EncodedScheduledTask{
+		HueTaskId:   int(old.Id) + ops.PersistentTaskIdOffset,
+		Description: "Old",
+		LightSet:    "All",
+		Recurring:   "hourly",
+		Enabled:     true,
+	}
+	if err := source.AddEncodedScheduledTask(nil, scheduled); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	atTime := &huedb.EncodedAtTimeTask{
+		GroupId:    "g",
+		ScheduleId: "1:2:All",
+		HueTaskId:  31,
+		Action:     "131",
+		LightSet:   "All",
+		Time:       2,
+	}
+	if err := source.AddEncodedAtTimeTask(nil, atTime); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	sceneTask := &huedb.EncodedAtTimeTask{
+		GroupId:    "g",
+		ScheduleId: "3:4:All",
+		HueTaskId:  kImportConfig.Scenes.Global(scene.Id),
+		LightSet:   "All",
+		Time:       4,
+	}
+	if err := source.AddEncodedAtTimeTask(nil, sceneTask); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	ignored := &huedb.EncodedAtTimeTask{GroupId: "h", ScheduleId: "x"}
+	if err := source.AddEncodedAtTimeTask(nil, ignored); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	var buffer bytes.Buffer
+	if err := huedb.Export(&buffer, source, "g"); err != nil {
+		t.Fatalf("Got error exporting: %v", err)
+	}
+
+	// Make ids in dest differ from ids in source
+	db := openDb(t)
+	defer closeDb(t, db)
+	dest := for_sqlite.New(db)
+	if err := dest.AddNamedColors(
+		nil, &ops.NamedColors{Description: "Existing"}); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	existingScene := &huedb.Scene{Name: "Existing"}
+	if err := dest.AddScene(nil, existingScene); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	if err := huedb.Import(
+		&buffer, for_sqlite.NewDoer(db), dest, kImportConfig); err != nil {
+		t.Fatalf("Got error importing: %v", err)
+	}
+	var namedColors []*ops.NamedColors
+	if err := dest.NamedColors(
+		nil, consume.AppendPtrsTo(&namedColors)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	expectedNamedColors := []*ops.NamedColors{
+		{Id: 1, Description: "Existing", Version: 1},
+		{Id: 2, Description: "Sunset", Colors: kColorMap1, Version: 1},
+	}
+	clearTimestamps(namedColors...)
+	if !reflect.DeepEqual(expectedNamedColors, namedColors) {
+		t.Errorf("Expected %v, got %v", expectedNamedColors, namedColors)
+	}
+	var archived []*ops.NamedColors
+	if err := dest.ArchivedNamedColors(
+		nil, consume.AppendPtrsTo(&archived)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	expectedArchived := []*ops.NamedColors{
+		{Id: 3, Description: "Old", Colors: kColorMap2, Version: 1},
+	}
+	clearTimestamps(archived...)
+	if !reflect.DeepEqual(expectedArchived, archived) {
+		t.Errorf("Expected %v, got %v", expectedArchived, archived)
+	}
+	var scenes []*huedb.Scene
+	if err := dest.Scenes(nil, consume.AppendPtrsTo(&scenes)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	scene.Id = 2
+	if !reflect.DeepEqual([]*huedb.Scene{existingScene, scene}, scenes) {
+		t.Errorf("Expected %v, got %v", scene, scenes)
+	}
+	var scheduledTasks []*huedb.EncodedScheduledTask
+	if err := dest.EncodedScheduledTasks(
+		nil, consume.AppendPtrsTo(&scheduledTasks)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	scheduled.HueTaskId = 3 + ops.PersistentTaskIdOffset
+	if !reflect.DeepEqual(
+		[]*huedb.EncodedScheduledTask{scheduled}, scheduledTasks) {
+		t.Errorf("Expected %v, got %v", scheduled, scheduledTasks)
+	}
+	atTime.Id = 1
+	sceneTask.Id = 2
+	sceneTask.HueTaskId = kImportConfig.Scenes.Global(2)
+	expectedAtTime := []*huedb.EncodedAtTimeTask{atTime, sceneTask}
+	out := encodedAtTimeTasks(t, dest, "g")
+	if !reflect.DeepEqual(expectedAtTime, out) {
+		t.Errorf("Expected %v, got %v", expectedAtTime, out)
+	}
+	if out := encodedAtTimeTasks(t, dest, "h"); len(out) != 0 {
+		t.Errorf("Expected no tasks in group h, got %v", out)
+	}
+}
+
+func TestImportRollsBack(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	store := for_sqlite.New(db)
+	doc := `{"version": 1, "named_colors": [{"id": 1, "description": "Good"}, {"id": 2, "description": "Bad", "colors": {"-1": {}}}]}`
+	err := huedb.Import(
+		bytes.NewBufferString(doc),
+		for_sqlite.NewDoer(db),
+		store,
+		kImportConfig)
+	if err != huedb.ErrBadLightColors {
+		t.Errorf("Expected ErrBadLightColors, got %v", err)
+	}
+	var namedColors []*ops.NamedColors
+	if err := store.NamedColors(
+		nil, consume.AppendPtrsTo(&namedColors)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if len(namedColors) != 0 {
+		t.Errorf("Expected nothing imported, got %v", namedColors)
+	}
+}
+
+func TestImportBadVersion(t *testing.T) {
+	db := openDb(t)
+	defer closeDb(t, db)
+	err := huedb.Import(
+		bytes.NewBufferString(`{"version": 99}`),
+		for_sqlite.NewDoer(db),
+		for_sqlite.New(db),
+		kImportConfig)
+	if err != huedb.ErrExportVersion {
+		t.Errorf("Expected ErrExportVersion, got %v", err)
+	}
+}
+
+func TestContextAdapters(t *testing.T) {
+	store := in_memory.New()
+	ctx := context.Background()
+	added := &ops.NamedColors{Description: "Foo", Colors: kColorMap1}
+	if err := store.AddNamedColors(nil, added); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	var fetched ops.NamedColors
+	if err := huedb.NamedColorsByIdCtx(store).NamedColorsByIdCtx(
+		ctx, nil, added.Id, &fetched); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	clearTimestamps(&fetched)
+	if !reflect.DeepEqual(added, &fetched) {
+		t.Errorf("Expected %v, got %v", added, &fetched)
+	}
+	if err := huedb.NamedColorsByIdCtx(store).NamedColorsByIdCtx(
+		ctx, nil, added.Id+1, &fetched); err != huedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	var all []*ops.NamedColors
+	if err := huedb.NamedColorsCtx(store).NamedColorsCtx(
+		ctx, nil, consume.AppendPtrsTo(&all)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	clearTimestamps(all...)
+	if !reflect.DeepEqual([]*ops.NamedColors{added}, all) {
+		t.Errorf("Expected %v, got %v", added, all)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := huedb.NamedColorsByIdCtx(store).NamedColorsByIdCtx(
+		cancelled, nil, added.Id, &fetched); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestContextAdaptersNative(t *testing.T) {
+	store := &nativeNamedColorsRunner{}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	var all []*ops.NamedColors
+	if err := huedb.NamedColorsCtx(store).NamedColorsCtx(
+		ctx, nil, consume.AppendPtrsTo(&all)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if store.ctx != ctx {
+		t.Error("Expected store to get ctx")
+	}
+}
+
+func TestCheckDescriptions(t *testing.T) {
+	store := in_memory.New()
+	var logged bytes.Buffer
+	logger := logging.JSONLogger(&logged)
+	writer := huedb.CheckDescriptions(
+		store, huedb.RejectDuplicateDescriptions, logger)
+	first := &ops.NamedColors{Description: "Reading"}
+	if err := writer.AddNamedColors(nil, first); err != nil {
+		t.Fatalf("Error adding: %v", err)
+	}
+	if err := writer.AddNamedColors(
+		nil, &ops.NamedColors{Description: "READING"}); err != huedb.ErrDuplicateDescription {
+		t.Errorf("Expected ErrDuplicateDescription, got %v", err)
+	}
+	second := &ops.NamedColors{Description: "Relax"}
+	if err := writer.AddNamedColors(nil, second); err != nil {
+		t.Fatalf("Error adding: %v", err)
+	}
+	// Updating named colors without changing the description is fine
+	if err := writer.UpdateNamedColors(nil, first); err != nil {
+		t.Errorf("Error updating: %v", err)
+	}
+	second.Description = "reading"
+	if err := writer.UpdateNamedColors(nil, second); err != huedb.ErrDuplicateDescription {
+		t.Errorf("Expected ErrDuplicateDescription, got %v", err)
+	}
+	if err := store.ArchiveNamedColors(nil, first.Id); err != nil {
+		t.Fatalf("Error archiving: %v", err)
+	}
+	if err := writer.UpdateNamedColors(nil, second); err != nil {
+		t.Errorf("Error updating: %v", err)
+	}
+	if logged.Len() != 0 {
+		t.Errorf("Expected nothing logged, got %s", logged.String())
+	}
+	warner := huedb.CheckDescriptions(
+		store, huedb.WarnDuplicateDescriptions, logger)
+	if err := warner.AddNamedColors(
+		nil, &ops.NamedColors{Description: "Reading"}); err != nil {
+		t.Errorf("Expected duplicate to be added, got %v", err)
+	}
+	if !strings.Contains(logged.String(), `"named_colors_id":2`) {
+		t.Errorf("Expected duplicate to be logged, got %s", logged.String())
+	}
+}
+
+func TestNamedColorsCache(t *testing.T) {
+	store := &countingNamedColorsStore{NamedColorsCacheStore: in_memory.New()}
+	cache := huedb.NewNamedColorsCache(store)
+	namedColors := &ops.NamedColors{
+		Description: "Red",
+		Colors: ops.LightColors{
+			3: {Color: gohue.NewMaybeColor(gohue.NewColor(0.6, 0.3))},
+		},
+	}
+	if err := cache.AddNamedColors(nil, namedColors); err != nil {
+		t.Fatalf("Error adding: %v", err)
+	}
+	var fetched ops.NamedColors
+	for i := 0; i < 3; i++ {
+		if err := cache.NamedColorsById(nil, namedColors.Id, &fetched); err != nil {
+			t.Fatalf("Error reading: %v", err)
+		}
+	}
+	clearTimestamps(&fetched)
+	if !reflect.DeepEqual(namedColors, &fetched) {
+		t.Errorf("Expected %v, got %v", namedColors, &fetched)
+	}
+	if store.reads != 1 {
+		t.Errorf("Expected 1 read, got %d", store.reads)
+	}
+	// Changing what we fetched must not change the cache.
+	fetched.Colors[3] = ops.ColorBrightness{}
+	namedColors.Description = "Crimson"
+	if err := cache.UpdateNamedColors(nil, namedColors); err != nil {
+		t.Fatalf("Error updating: %v", err)
+	}
+	if err := cache.NamedColorsById(nil, namedColors.Id, &fetched); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	clearTimestamps(&fetched)
+	if !reflect.DeepEqual(namedColors, &fetched) {
+		t.Errorf("Expected %v, got %v", namedColors, &fetched)
+	}
+	if store.reads != 2 {
+		t.Errorf("Expected 2 reads, got %d", store.reads)
+	}
+	if err := cache.RemoveNamedColors(nil, namedColors.Id); err != nil {
+		t.Fatalf("Error removing: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := cache.NamedColorsById(nil, namedColors.Id, &fetched); err != huedb.ErrNoSuchId {
+			t.Errorf("Expected ErrNoSuchId, got %v", err)
+		}
+	}
+	if store.reads != 3 {
+		t.Errorf("Expected 3 reads, got %d", store.reads)
+	}
+	// Reads within a transaction bypass the cache
+	cache.NamedColorsById(1, namedColors.Id, &fetched)
+	if store.reads != 4 {
+		t.Errorf("Expected 4 reads, got %d", store.reads)
+	}
+	cache.InvalidateAll()
+	cache.NamedColorsById(nil, namedColors.Id, &fetched)
+	if store.reads != 5 {
+		t.Errorf("Expected 5 reads, got %d", store.reads)
+	}
+}
+
+func TestFindByExactDescription(t *testing.T) {
+	store := in_memory.New()
+	first := &ops.NamedColors{Description: "50% off"}
+	second := &ops.NamedColors{Description: "50% OFF"}
+	third := &ops.NamedColors{Description: "50% off sale"}
+	for _, nc := range []*ops.NamedColors{first, second, third} {
+		if err := store.AddNamedColors(nil, nc); err != nil {
+			t.Fatalf("Error adding: %v", err)
+		}
+	}
+	// Hide the NamedColorsByExactDescription method of store so that
+	// FindByExactDescription has to filter.
+	fallback := struct {
+		huedb.NamedColorsByDescriptionRunner
+	}{store}
+	var matches []*ops.NamedColors
+	if err := huedb.FindByExactDescription(
+		nil, fallback, "50% Off", consume.AppendPtrsTo(&matches)); err != nil {
+		t.Fatalf("Error finding: %v", err)
+	}
+	expected := []*ops.NamedColors{first, second}
+	clearTimestamps(matches...)
+	if !reflect.DeepEqual(expected, matches) {
+		t.Errorf("Expected %v, got %v", expected, matches)
+	}
+}
+
+func TestLastHueTask(t *testing.T) {
+	store := in_memory.New()
+	var fakeEncoder fakeActionEncoder
+	if _, err := huedb.LastHueTask(store, fakeEncoder, 31); err != huedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	values := url.Values{"0": {"5"}}
+	if err := store.SaveLastParams(nil, &huedb.LastParams{
+		HueTaskId: 31, Values: values}); err != nil {
+		t.Fatalf("Got error saving: %v", err)
+	}
+	if _, err := huedb.LastHueTask(store, fakeEncoder, 31); err != huedb.ErrNoSuchId {
+		t.Errorf("Expected ErrNoSuchId, got %v", err)
+	}
+	h := &ops.HueTask{Id: 31, HueAction: intAction(131), Description: "Foo"}
+	if err := huedb.SaveLastHueTask(store, fakeEncoder, h); err != nil {
+		t.Fatalf("Got error saving: %v", err)
+	}
+	var saved huedb.LastParams
+	if err := store.LastParams(nil, 31, &saved); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if out := saved.Action; out != "162" {
+		t.Errorf("Expected encoded action 162, got %s", out)
+	}
+	if !reflect.DeepEqual(values, saved.Values) {
+		t.Errorf("Expected %v, got %v", values, saved.Values)
+	}
+	actual, err := huedb.LastHueTask(store, fakeEncoder, 31)
+	if err != nil {
+		t.Fatalf("Got error: %v", err)
+	}
+	if !reflect.DeepEqual(h, actual) {
+		t.Errorf("Expected %v, got %v", h, actual)
+	}
+	h = &ops.HueTask{Id: kIdDoesNotSupportEncode, HueAction: intAction(1)}
+	if err := huedb.SaveLastHueTask(store, fakeEncoder, h); err != kEncodeNotSupported {
+		t.Errorf("Expected kEncodeNotSupported, got %v", err)
+	}
+	if err := store.SaveLastParams(nil, &huedb.LastParams{
+		HueTaskId: kIdDoesNotSupportDecode, Action: "1"}); err != nil {
+		t.Fatalf("Got error saving: %v", err)
+	}
+	if _, err := huedb.LastHueTask(store, fakeEncoder, kIdDoesNotSupportDecode); err != kDecodeNotSupported {
+		t.Errorf("Expected kDecodeNotSupported, got %v", err)
+	}
+}
+
+func TestWithLastParams(t *testing.T) {
+	task := &dynamic.HueTask{
+		Id:          12,
+		Description: "Foo",
+		Factory:     dynamic.PlainFactory{},
+	}
+	store := in_memory.New()
+	if err := store.SaveLastParams(nil, &huedb.LastParams{
+		HueTaskId: 12, Values: url.Values{"1": {"40"}}}); err != nil {
+		t.Fatalf("Got error saving: %v", err)
+	}
+	withDefaults, err := huedb.WithLastParams(store, task)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	actual := withDefaults.FromUrlValues("p", make(url.Values))
+	if actual.Description != "Foo Color: White Bri: 40" {
+		t.Errorf("Got %s", actual.Description)
+	}
+	otherTask := &dynamic.HueTask{Id: 13, Factory: dynamic.PlainFactory{}}
+	if out, err := huedb.WithLastParams(store, otherTask); out != otherTask || err != nil {
+		t.Errorf("Expected task unchanged, got %v %v", out, err)
+	}
+	if _, err := huedb.WithLastParams(
+		errLastParamsStore{}, task); err != kDbError {
+		t.Errorf("Expected kDbError, got %v", err)
+	}
+}
+
+func TestNamedColorsPicker(t *testing.T) {
+	store := &countingNamedColorsRunner{
+		namedColors: []*ops.NamedColors{
+			{
+				Id:          1,
+				Description: "Sunset",
+				Colors: ops.LightColors{
+					3: {Color: gohue.NewMaybeColor(gohue.NewColor(0.6, 0.4))},
+				},
+			},
+			{
+				Id:          2,
+				Description: "Two lights",
+				Colors: ops.LightColors{
+					3: {Color: gohue.NewMaybeColor(gohue.Red)},
+					4: {Color: gohue.NewMaybeColor(gohue.Blue)},
+				},
+			},
+			{
+				Id:          3,
+				Description: "Brightness only",
+				Colors:      ops.LightColors{3: {Brightness: maybe.NewUint8(3)}},
+			},
+		},
+	}
+	picker := huedb.NamedColorsPicker(store, gohue.White, "White", time.Hour)
+	builtIn := len(dynamic.ColorChoices())
+	selection := picker.Selection()
+	if len(selection) != builtIn+2 || selection[builtIn+1] != "Sunset" {
+		t.Errorf("Unexpected selection %v", selection)
+	}
+	val, str := picker.Convert(strconv.Itoa(builtIn + 1))
+	if val != gohue.NewColor(0.6, 0.4) || str != "Sunset" {
+		t.Errorf("Expected Sunset, got %v %s", val, str)
+	}
+	if val, str = picker.Convert(""); val != gohue.White || str != "White" {
+		t.Errorf("Expected White, got %v %s", val, str)
+	}
+	schema := picker.(dynamic.SchemaParam).Schema()
+	if key := schema.ChoiceKeys[builtIn]; key != "nc1" {
+		t.Errorf("Expected key nc1, got %s", key)
+	}
+	if val, str = picker.Convert("nc1"); val != gohue.NewColor(0.6, 0.4) || str != "Sunset" {
+		t.Errorf("Expected Sunset by key, got %v %s", val, str)
+	}
+	if store.reads != 1 {
+		t.Errorf("Expected 1 read, got %d", store.reads)
+	}
+	factory := huedb.NamedColorsPlainFactory(store, time.Hour)
+	if out := len(factory.Params()[0].Selection()); out != builtIn+2 {
+		t.Errorf("Expected favorite colors in factory, got %d", out)
+	}
+
+	store.err = kDbError
+	picker = huedb.NamedColorsPicker(store, gohue.White, "White", 0)
+	if out := len(picker.Selection()); out != builtIn+1 {
+		t.Errorf("Expected only built in colors on error, got %d", out)
+	}
+	store.err = nil
+	if out := len(picker.Selection()); out != builtIn+2 {
+		t.Errorf("Expected favorite colors after error, got %d", out)
+	}
+	store.err = kDbError
+	if out := len(picker.Selection()); out != builtIn+2 {
+		t.Errorf("Expected choices kept on error, got %d", out)
+	}
+	store.err = nil
+	store.namedColors = append([]*ops.NamedColors{
+		{
+			Id:          4,
+			Description: "Sky",
+			Colors: ops.LightColors{
+				3: {Color: gohue.NewMaybeColor(gohue.Blue)},
+			},
+		},
+	}, store.namedColors...)
+	if out := len(picker.Selection()); out != builtIn+3 {
+		t.Errorf("Expected refreshed choices, got %d", out)
+	}
+	// A saved key still picks the same color after the list changes.
+	if val, str := picker.Convert("nc1"); val != gohue.NewColor(0.6, 0.4) || str != "Sunset" {
+		t.Errorf("Expected Sunset by key, got %v %s", val, str)
+	}
+}
+
+func TestNewEncodedScheduledTask(t *testing.T) {
+	var fakeEncoder fakeActionEncoder
+	h := &ops.HueTask{Id: 31, HueAction: intAction(131), Description: "Foo"}
+	encoded, err := huedb.NewEncodedScheduledTask(
+		fakeEncoder, h, lights.New(3), "7:00", 100, true)
+	if err != nil {
+		t.Fatalf("Got error: %v", err)
+	}
+	expected := &huedb.EncodedScheduledTask{
+		HueTaskId:   31,
+		Action:      "162",
+		Description: "Foo",
+		LightSet:    "3",
+		Recurring:   "7:00",
+		Priority:    100,
+		Enabled:     true,
+	}
+	if !reflect.DeepEqual(expected, encoded) {
+		t.Errorf("Expected %v, got %v", expected, encoded)
+	}
+	h = &ops.HueTask{Id: kIdDoesNotSupportEncode, HueAction: intAction(1)}
+	if _, err := huedb.NewEncodedScheduledTask(
+		fakeEncoder, h, lights.All, "7:00", 100, true); err != kEncodeNotSupported {
+		t.Errorf("Expected kEncodeNotSupported, got %v", err)
+	}
+}
+
 func TestAtTimeTaskStore(t *testing.T) {
-	var fakeStore fakeEncodedAtTimeTaskStore
+	fakeStore := in_memory.New()
 	var fakeEncoder fakeActionEncoder
 	buffer := bytes.NewBuffer(nil)
 	logger := log.New(buffer, "", 0)
 	store := huedb.NewAtTimeTaskStore(
-		fakeEncoder, fakeEncoder, &fakeStore, "default", logger)
+		fakeEncoder, fakeEncoder, fakeStore, "default", logging.StdLogger(logger))
 	verifyAtTimeTaskStoreNormal(t, store)
 	if len(buffer.Bytes()) > 0 {
 		t.Errorf("No logs expected: %s", string(buffer.Bytes()))
 	}
 	// Just to be sure encoding of action works.
-	if fakeStore[0].Action != "162" {
-		t.Errorf("Expected encoded action 162, got %s", fakeStore[0].Action)
+	if out := encodedAtTimeTasks(t, fakeStore, "default")[0].Action; out != "162" {
+		t.Errorf("Expected encoded action 162, got %s", out)
 	}
 	// AtTimeTaskStores with different group Ids should not interfere with
 	// each other
 	store2 := huedb.NewAtTimeTaskStore(
-		fakeEncoder, fakeEncoder, &fakeStore, "second", logger)
+		fakeEncoder, fakeEncoder, fakeStore, "second", logging.StdLogger(logger))
 	verifyAtTimeTaskStoreNormal(t, store2)
 }
 
@@ -206,7 +878,7 @@ func TestAtTimeTaskStoreErrors(t *testing.T) {
 	buffer := bytes.NewBuffer(nil)
 	logger := log.New(buffer, "", 0)
 	store := huedb.NewAtTimeTaskStore(
-		fakeEncoder, fakeEncoder, fakeStore, "default", logger)
+		fakeEncoder, fakeEncoder, fakeStore, "default", logging.StdLogger(logger))
 	first := &ops.AtTimeTask{
 		Id: "firstId",
 		H: &ops.HueTask{
@@ -236,12 +908,12 @@ func TestAtTimeTaskStoreErrors(t *testing.T) {
 }
 
 func TestAtTimeTaskStoreEncodeErrors(t *testing.T) {
-	var fakeStore fakeEncodedAtTimeTaskStore
+	fakeStore := in_memory.New()
 	var fakeEncoder fakeActionEncoder
 	buffer := bytes.NewBuffer(nil)
 	logger := log.New(buffer, "", 0)
 	store := huedb.NewAtTimeTaskStore(
-		fakeEncoder, fakeEncoder, &fakeStore, "default", logger)
+		fakeEncoder, fakeEncoder, fakeStore, "default", logging.StdLogger(logger))
 	first := &ops.AtTimeTask{
 		Id: "firstId",
 		H: &ops.HueTask{
@@ -289,7 +961,7 @@ func TestAtTimeTaskStoreEncodeErrors(t *testing.T) {
 	}
 
 	// Now there should be 5 entries in the store.
-	if out := fakeStore.Size(); out != 5 {
+	if out := len(encodedAtTimeTasks(t, fakeStore, "default")); out != 5 {
 		t.Errorf("Expected 5 entries in store, got %d", out)
 	}
 
@@ -299,7 +971,7 @@ func TestAtTimeTaskStoreEncodeErrors(t *testing.T) {
 	}
 
 	// All should have deleted the two entries that could not be read
-	if out := fakeStore.Size(); out != 3 {
+	if out := len(encodedAtTimeTasks(t, fakeStore, "default")); out != 3 {
 		t.Errorf("Expected 3 entries in store, got %d", out)
 	}
 
@@ -321,7 +993,7 @@ func TestAtTimeTaskStoreEncodeErrors(t *testing.T) {
 
 	// If Encoding a task causes an error, it shouldn't be added to the
 	// database. size should still be 3.
-	if out := fakeStore.Size(); out != 3 {
+	if out := len(encodedAtTimeTasks(t, fakeStore, "default")); out != 3 {
 		t.Errorf("Expected 3 entries in store, got %d", out)
 	}
 
@@ -340,13 +1012,13 @@ func TestAttimeTaskStoreSqlite(t *testing.T) {
 	defer closeDb(t, db)
 	dbStore := for_sqlite.New(db)
 	store := huedb.NewAtTimeTaskStore(
-		fakeEncoder, fakeEncoder, dbStore, "default", logger)
+		fakeEncoder, fakeEncoder, dbStore, "default", logging.StdLogger(logger))
 	verifyAtTimeTaskStoreNormal(t, store)
 
 	// AtTimeTaskStores with different group Ids shouldn't interfere with
 	// each other
 	store2 := huedb.NewAtTimeTaskStore(
-		fakeEncoder, fakeEncoder, dbStore, "second", logger)
+		fakeEncoder, fakeEncoder, dbStore, "second", logging.StdLogger(logger))
 	verifyAtTimeTaskStoreNormal(t, store2)
 
 	if len(buffer.Bytes()) > 0 {
@@ -358,6 +1030,157 @@ func TestAttimeTaskStoreSqlite(t *testing.T) {
 	}
 }
 
+func TestAddEncodedAtTimeTasks(t *testing.T) {
+	database := openDb(t)
+	defer closeDb(t, database)
+	verifyAddEncodedAtTimeTasks(t, for_sqlite.New(database))
+	verifyAddEncodedAtTimeTasks(t, in_memory.New())
+	// Hide the AddEncodedAtTimeTasks method so that tasks get added one
+	// at a time.
+	verifyAddEncodedAtTimeTasks(t, struct {
+		huedb.EncodedAtTimeTaskStore
+	}{in_memory.New()})
+}
+
+func TestAtTimeTaskStoreAddAll(t *testing.T) {
+	fakeStore := in_memory.New()
+	var fakeEncoder fakeActionEncoder
+	buffer := bytes.NewBuffer(nil)
+	logger := log.New(buffer, "", 0)
+	store := huedb.NewAtTimeTaskStore(
+		fakeEncoder, fakeEncoder, fakeStore, "default", logging.StdLogger(logger))
+	now := time.Unix(1300000000, 0)
+	first := &ops.AtTimeTask{
+		Id:        "firstId",
+		H:         &ops.HueTask{Id: 31, HueAction: intAction(131)},
+		Ls:        lights.All,
+		StartTime: now,
+	}
+	second := &ops.AtTimeTask{
+		Id:        "secondId",
+		H:         &ops.HueTask{Id: 41, HueAction: intAction(141)},
+		Ls:        lights.New(1, 4),
+		StartTime: now.Add(time.Hour),
+	}
+	store.AddAll([]*ops.AtTimeTask{first, second})
+	all := store.All()
+	if len(all) != 2 || all[0].Id != "firstId" || all[1].Id != "secondId" {
+		t.Errorf("Expected firstId and secondId, got %v", all)
+	}
+	if len(buffer.Bytes()) > 0 {
+		t.Errorf("No logs expected, got: %s", string(buffer.Bytes()))
+	}
+}
+
+func TestExpireAtTimeTasks(t *testing.T) {
+	database := openDb(t)
+	defer closeDb(t, database)
+	verifyExpireAtTimeTasks(t, for_sqlite.New(database))
+	verifyExpireAtTimeTasks(t, in_memory.New())
+}
+
+func TestAtTimeTaskStoreSkipExpired(t *testing.T) {
+	fakeStore := in_memory.New()
+	var fakeEncoder fakeActionEncoder
+	buffer := bytes.NewBuffer(nil)
+	logger := log.New(buffer, "", 0)
+	store := huedb.NewAtTimeTaskStore(
+		fakeEncoder, fakeEncoder, fakeStore, "default", logging.StdLogger(logger))
+	store.SkipExpired(time.Hour)
+	now := time.Now()
+	stale := &ops.AtTimeTask{
+		Id:        "staleId",
+		H:         &ops.HueTask{Id: 31, HueAction: intAction(131)},
+		StartTime: now.Add(-2 * time.Hour),
+	}
+	recent := &ops.AtTimeTask{
+		Id:        "recentId",
+		H:         &ops.HueTask{Id: 41, HueAction: intAction(141)},
+		StartTime: now.Add(-2 * time.Hour),
+		EndTime:   now.Add(-30 * time.Minute),
+	}
+	store.Add(stale)
+	store.Add(recent)
+	all := store.All()
+	if len(all) != 1 || all[0].Id != "recentId" {
+		t.Errorf("Expected only recentId, got %v", all)
+	}
+	encoded := encodedAtTimeTasks(t, fakeStore, "default")
+	if len(encoded) != 1 || encoded[0].ScheduleId != "recentId" {
+		t.Errorf("Expected staleId to be removed, got %v", encoded)
+	}
+	if len(buffer.Bytes()) > 0 {
+		t.Errorf("No logs expected, got: %s", string(buffer.Bytes()))
+	}
+}
+
+type countingNamedColorsStore struct {
+	huedb.NamedColorsCacheStore
+	reads int
+}
+
+func (s *countingNamedColorsStore) NamedColorsById(
+	t db.Transaction, id int64, namedColors *ops.NamedColors) error {
+	s.reads++
+	return s.NamedColorsCacheStore.NamedColorsById(t, id, namedColors)
+}
+
+type expiringAtTimeTaskStore interface {
+	huedb.EncodedAtTimeTaskStore
+	huedb.RemoveEncodedAtTimeTasksBeforeRunner
+}
+
+func verifyExpireAtTimeTasks(t *testing.T, store expiringAtTimeTaskStore) {
+	now := time.Unix(1300000000, 0)
+	added := []*huedb.EncodedAtTimeTask{
+		{GroupId: "a", ScheduleId: "old", Time: now.Add(-25 * time.Hour).Unix()},
+		{GroupId: "b", ScheduleId: "oldToo", Time: now.Add(-49 * time.Hour).Unix()},
+		{
+			GroupId:    "a",
+			ScheduleId: "stillRunning",
+			Time:       now.Add(-25 * time.Hour).Unix(),
+			EndTime:    now.Add(-time.Hour).Unix(),
+		},
+		{GroupId: "a", ScheduleId: "recent", Time: now.Add(-time.Hour).Unix()},
+	}
+	for _, task := range added {
+		if err := store.AddEncodedAtTimeTask(nil, task); err != nil {
+			t.Fatalf("Error adding task: %v", err)
+		}
+	}
+	if err := huedb.ExpireAtTimeTasks(store, now, 24*time.Hour); err != nil {
+		t.Fatalf("Error expiring tasks: %v", err)
+	}
+	expected := []*huedb.EncodedAtTimeTask{added[2], added[3]}
+	if actual := encodedAtTimeTasks(t, store, "a"); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	if actual := encodedAtTimeTasks(t, store, "b"); len(actual) != 0 {
+		t.Errorf("Expected no tasks in group b, got %v", actual)
+	}
+}
+
+func verifyAddEncodedAtTimeTasks(
+	t *testing.T, store huedb.EncodedAtTimeTaskStore) {
+	added := []*huedb.EncodedAtTimeTask{
+		{GroupId: "a", ScheduleId: "first", HueTaskId: 1, Time: 100},
+		{GroupId: "b", ScheduleId: "second", HueTaskId: 2, Time: 200},
+		{GroupId: "a", ScheduleId: "third", HueTaskId: 3, Time: 300},
+	}
+	if err := huedb.AddEncodedAtTimeTasks(nil, store, added); err != nil {
+		t.Fatalf("Error adding tasks: %v", err)
+	}
+	for _, task := range added {
+		if task.Id == 0 {
+			t.Error("Expected Id to be set.")
+		}
+	}
+	expected := []*huedb.EncodedAtTimeTask{added[0], added[2]}
+	if actual := encodedAtTimeTasks(t, store, "a"); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
 func verifyErrorTask(t *testing.T, h *ops.HueTask, id int) {
 	err := tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
 		h.Do(nil, nil, e)
@@ -392,8 +1215,10 @@ func verifyAtTimeTaskStoreNormal(t *testing.T, store *huedb.AtTimeTaskStore) {
 			HueAction:   intAction(141),
 			Description: "Second Description",
 		},
-		Ls:        lights.New(1, 4),
-		StartTime: now.Add(23 * time.Minute),
+		Ls:           lights.New(1, 4),
+		StartTime:    now.Add(23 * time.Minute),
+		EndTime:      now.Add(53 * time.Minute),
+		RestoreAtEnd: true,
 	}
 	third := &ops.AtTimeTask{
 		Id: "thirdId",
@@ -491,49 +1316,17 @@ func (f fakeEncodedAtTimeTaskStoreWithErrors) EncodedAtTimeTasks(
 	return kDbError
 }
 
-type fakeEncodedAtTimeTaskStore []*huedb.EncodedAtTimeTask
-
-func (f *fakeEncodedAtTimeTaskStore) AddEncodedAtTimeTask(
-	t db.Transaction, task *huedb.EncodedAtTimeTask) error {
-	task.Id = int64(len(*f) + 1)
-	stored := *task
-	*f = append(*f, &stored)
-	return nil
+type errEncodedScheduledTaskStore struct {
 }
 
-func (f fakeEncodedAtTimeTaskStore) RemoveEncodedAtTimeTaskByScheduleId(
-	t db.Transaction, groupId, scheduleId string) error {
-	for i := range f {
-		if f[i].ScheduleId == scheduleId && f[i].GroupId == groupId {
-			f[i] = kNilEncodedAtTimeTask
-			return nil
-		}
-	}
-	return nil
-}
-
-func (f fakeEncodedAtTimeTaskStore) Size() (result int) {
-	for i := range f {
-		if f[i].Id != 0 {
-			result++
-		}
-	}
-	return result
+func (e errEncodedScheduledTaskStore) EncodedScheduledTasks(
+	t db.Transaction, consumer consume.Consumer) error {
+	return kDbError
 }
 
-func (f fakeEncodedAtTimeTaskStore) EncodedAtTimeTasks(
-	t db.Transaction, groupId string, consumer consume.Consumer) error {
-	for i := range f {
-		if !consumer.CanConsume() {
-			break
-		}
-		if f[i].Id == 0 || f[i].GroupId != groupId {
-			continue
-		}
-		encodedAtTimeTask := *f[i]
-		consumer.Consume(&encodedAtTimeTask)
-	}
-	return nil
+func (e errEncodedScheduledTaskStore) EnableEncodedScheduledTask(
+	t db.Transaction, id int64, enabled bool) error {
+	return kDbError
 }
 
 type fakeActionEncoder struct {
@@ -571,16 +1364,43 @@ func (i intAction) UsedLights(lightSet lights.Set) lights.Set {
 	return lightSet
 }
 
+// clearTimestamps zeros the timestamps that stores set on named colors
+// so that tests can compare against literals.
+func clearTimestamps(namedColors ...*ops.NamedColors) {
+	for _, nc := range namedColors {
+		nc.CreatedAt = time.Time{}
+		nc.UpdatedAt = time.Time{}
+	}
+}
+
+func encodedAtTimeTasks(
+	t *testing.T,
+	store huedb.EncodedAtTimeTaskStore,
+	groupId string) []*huedb.EncodedAtTimeTask {
+	var result []*huedb.EncodedAtTimeTask
+	if err := store.EncodedAtTimeTasks(
+		nil, groupId, consume.AppendPtrsTo(&result)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	for _, task := range result {
+		task.CreatedAt = time.Time{}
+		task.UpdatedAt = time.Time{}
+	}
+	return result
+}
+
 func closeDb(t *testing.T, db *sqlite_db.Db) {
 	if err := db.Close(); err != nil {
 		t.Errorf("Error closing database: %v", err)
 	}
 }
 
-type fakeDynamicHueTaskStore map[int]*dynamic.HueTask
-
-func (f fakeDynamicHueTaskStore) ById(id int) *dynamic.HueTask {
-	return f[id]
+func newRegistry(tasks ...*dynamic.HueTask) *dynamic.Registry {
+	result, err := dynamic.NewRegistry(tasks...)
+	if err != nil {
+		panic(err)
+	}
+	return result
 }
 
 type fakeSpecificActionEncoder int
@@ -627,3 +1447,112 @@ func openDb(t *testing.T) *sqlite_db.Db {
 	}
 	return db
 }
+
+// nativeNamedColorsRunner implements both huedb.NamedColorsRunner and
+// huedb.NamedColorsCtxRunner.
+type nativeNamedColorsRunner struct {
+	ctx context.Context
+}
+
+func (n *nativeNamedColorsRunner) NamedColors(
+	t db.Transaction, consumer consume.Consumer) error {
+	return n.NamedColorsCtx(context.Background(), t, consumer)
+}
+
+func (n *nativeNamedColorsRunner) NamedColorsCtx(
+	ctx context.Context, t db.Transaction, consumer consume.Consumer) error {
+	n.ctx = ctx
+	return nil
+}
+
+type errLastParamsStore struct {
+}
+
+func (e errLastParamsStore) LastParams(
+	t db.Transaction, hueTaskId int, params *huedb.LastParams) error {
+	return kDbError
+}
+
+type countingNamedColorsRunner struct {
+	namedColors fakeNamedColorsRunner
+	reads       int
+	err         error
+}
+
+func (c *countingNamedColorsRunner) NamedColors(
+	t db.Transaction, consumer consume.Consumer) error {
+	c.reads++
+	if c.err != nil {
+		return c.err
+	}
+	return c.namedColors.NamedColors(t, consumer)
+}
+
+func TestFireTimeStore(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := log.New(buffer, "", 0)
+	store := huedb.NewFireTimeStore(in_memory.New(), logging.StdLogger(logger))
+	if _, ok := store.LastFired(3); ok {
+		t.Error("Expected no last fired time")
+	}
+	fired := time.Unix(1400000000, 0)
+	store.SetLastFired(3, fired)
+	if actual, ok := store.LastFired(3); !ok || !actual.Equal(fired) {
+		t.Errorf("Expected %v, got %v", fired, actual)
+	}
+	if len(buffer.Bytes()) > 0 {
+		t.Errorf("No logs expected, got: %s", string(buffer.Bytes()))
+	}
+}
+
+func TestRunTimeStore(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := log.New(buffer, "", 0)
+	memStore := in_memory.New()
+	store := huedb.NewRunTimeStore(memStore, logging.StdLogger(logger))
+	if _, ok := store.LastRun(3); ok {
+		t.Error("Expected no last run time")
+	}
+	ran := time.Unix(1400000000, 0)
+	for _, start := range []time.Time{ran.Add(-time.Hour), ran} {
+		if err := memStore.AddTaskRun(
+			nil, &huedb.TaskRun{HueTaskId: 3, Start: start}); err != nil {
+			t.Fatalf("Got error adding: %v", err)
+		}
+	}
+	if actual, ok := store.LastRun(3); !ok || !actual.Equal(ran) {
+		t.Errorf("Expected %v, got %v", ran, actual)
+	}
+	if len(buffer.Bytes()) > 0 {
+		t.Errorf("No logs expected, got: %s", string(buffer.Bytes()))
+	}
+}
+
+func TestEnabledStore(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := log.New(buffer, "", 0)
+	memStore := in_memory.New()
+	encoded := &huedb.EncodedScheduledTask{HueTaskId: 3, LightSet: "All"}
+	if err := memStore.AddEncodedScheduledTask(nil, encoded); err != nil {
+		t.Fatalf("Got error adding: %v", err)
+	}
+	store := huedb.NewEnabledStore(memStore, logging.StdLogger(logger))
+	store.SetEnabled(int(encoded.Id), true)
+	var tasks []*huedb.EncodedScheduledTask
+	if err := memStore.EncodedScheduledTasks(
+		nil, consume.AppendPtrsTo(&tasks)); err != nil {
+		t.Fatalf("Got error reading: %v", err)
+	}
+	if len(tasks) != 1 || !tasks[0].Enabled {
+		t.Errorf("Expected scheduled task to be enabled, got %v", tasks)
+	}
+	if len(buffer.Bytes()) > 0 {
+		t.Errorf("No logs expected, got: %s", string(buffer.Bytes()))
+	}
+	store = huedb.NewEnabledStore(
+		errEncodedScheduledTaskStore{}, logging.StdLogger(logger))
+	store.SetEnabled(1, false)
+	if len(buffer.Bytes()) == 0 {
+		t.Error("Expected error to be logged")
+	}
+}
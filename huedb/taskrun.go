@@ -0,0 +1,79 @@
+package huedb
+
+import (
+	"github.com/keep94/consume"
+	"github.com/keep94/toolbox/db"
+	"time"
+)
+
+// TaskRun records one run of a hue task in the task run log.
+type TaskRun struct {
+	// The unique database dependent numeric ID of this run.
+	Id int64
+
+	// The ID of the hue task that ran.
+	HueTaskId int
+
+	// The description of the hue task when it ran.
+	Description string
+
+	// When the hue task started.
+	Start time.Time
+
+	// How long the hue task ran. Stores keep millisecond precision.
+	Duration time.Duration
+}
+
+// TaskStats summarizes the runs of one hue task.
+type TaskStats struct {
+	// The ID of the hue task.
+	HueTaskId int
+
+	// The description of the hue task when it last ran.
+	Description string
+
+	// How many times the hue task ran.
+	Runs int64
+
+	// The average duration of the runs.
+	AverageDuration time.Duration
+
+	// When the hue task last started.
+	LastRun time.Time
+}
+
+// DailyRuns is how many hue tasks ran on a particular day.
+type DailyRuns struct {
+	// Midnight local time of the day.
+	Day time.Time
+
+	// How many hue tasks started that day.
+	Runs int64
+}
+
+type AddTaskRunRunner interface {
+	// AddTaskRun adds a run to the task run log.
+	AddTaskRun(t db.Transaction, run *TaskRun) error
+}
+
+type LastTaskRunRunner interface {
+	// LastTaskRun gets the run of a hue task that started last.
+	// LastTaskRun returns ErrNoSuchId if the hue task never ran.
+	LastTaskRun(t db.Transaction, hueTaskId int, run *TaskRun) error
+}
+
+type TaskStatsRunner interface {
+	// TaskStats gets statistics for each hue task that started at or after
+	// since, most run hue tasks first. Ties go to the lower hue task id.
+	// TaskStats consumes TaskStats instances.
+	TaskStats(t db.Transaction, since time.Time, consumer consume.Consumer) error
+}
+
+type TaskRunsPerDayRunner interface {
+	// TaskRunsPerDay gets how many hue tasks started on each day at or
+	// after since, earliest day first. Days run midnight to midnight
+	// local time and days without runs are skipped. TaskRunsPerDay
+	// consumes DailyRuns instances.
+	TaskRunsPerDay(
+		t db.Transaction, since time.Time, consumer consume.Consumer) error
+}
@@ -0,0 +1,68 @@
+package huedb
+
+import (
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/toolbox/db"
+)
+
+// Step is one store operation that runs within transaction t. Steps must
+// pass t to every store method they call.
+type Step func(t db.Transaction) error
+
+// Atomically runs steps in order within a single transaction that doer
+// creates. If a step fails, Atomically stops and returns that error, and
+// the transaction rolls back so that none of the steps take effect.
+// Later steps see the changes of earlier steps including any ids that
+// earlier steps assigned.
+func Atomically(doer db.Doer, steps ...Step) error {
+	return doer.Do(func(t db.Transaction) error {
+		for _, step := range steps {
+			if err := step(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddNamedColorsStep returns a Step that adds namedColors to store.
+func AddNamedColorsStep(
+	store AddNamedColorsRunner, namedColors *ops.NamedColors) Step {
+	return func(t db.Transaction) error {
+		return store.AddNamedColors(t, namedColors)
+	}
+}
+
+// AddEncodedScheduledTaskStep returns a Step that adds task to store.
+func AddEncodedScheduledTaskStep(
+	store AddEncodedScheduledTaskRunner, task *EncodedScheduledTask) Step {
+	return func(t db.Transaction) error {
+		return store.AddEncodedScheduledTask(t, task)
+	}
+}
+
+// NamedColorsWithScheduleStore adds named colors and scheduled tasks.
+type NamedColorsWithScheduleStore interface {
+	AddNamedColorsRunner
+	AddEncodedScheduledTaskRunner
+}
+
+// AddNamedColorsWithSchedule adds namedColors and a scheduled task that
+// runs them to store atomically. AddNamedColorsWithSchedule sets the
+// HueTaskId of task to the id of the hue task for namedColors and clears
+// its Action as named colors need no encoded action.
+func AddNamedColorsWithSchedule(
+	doer db.Doer,
+	store NamedColorsWithScheduleStore,
+	namedColors *ops.NamedColors,
+	task *EncodedScheduledTask) error {
+	return Atomically(
+		doer,
+		AddNamedColorsStep(store, namedColors),
+		func(t db.Transaction) error {
+			task.HueTaskId = namedColors.AsHueTask().Id
+			task.Action = ""
+			return nil
+		},
+		AddEncodedScheduledTaskStep(store, task))
+}
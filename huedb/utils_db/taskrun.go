@@ -0,0 +1,84 @@
+package utils_db
+
+import (
+	"github.com/keep94/marvin2/huedb"
+	"github.com/keep94/marvin2/utils"
+	"github.com/keep94/tasks"
+	"sync"
+	"time"
+)
+
+// TaskRunRecorder records each run of a hue task in the task run log.
+// TaskRunRecorder implements utils.Listener; register it with
+// utils.MultiExecutor.AddListener. TaskRunRecorder ignores timers.
+// TaskRunRecorder instances are safe to use with multiple goroutines.
+type TaskRunRecorder struct {
+	store   huedb.AddTaskRunRunner
+	clock   tasks.Clock
+	logger  utils.Logger
+	mu      sync.Mutex
+	started map[utils.Task]time.Time
+}
+
+// NewTaskRunRecorder creates a TaskRunRecorder that adds runs to store.
+// clock should be the clock of the utils.MultiExecutor that this instance
+// observes. NewTaskRunRecorder logs errors from store to logger.
+func NewTaskRunRecorder(
+	store huedb.AddTaskRunRunner,
+	clock tasks.Clock,
+	logger utils.Logger) *TaskRunRecorder {
+	return &TaskRunRecorder{
+		store:   store,
+		clock:   clock,
+		logger:  logger,
+		started: make(map[utils.Task]time.Time),
+	}
+}
+
+func (r *TaskRunRecorder) TaskStarted(task utils.Task) {
+	if _, ok := task.(*utils.HueTaskWrapper); !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started[task] = r.clock.Now()
+}
+
+func (r *TaskRunRecorder) TaskFinished(task utils.Task) {
+	r.record(task)
+}
+
+func (r *TaskRunRecorder) TaskInterrupted(task utils.Task) {
+	r.record(task)
+}
+
+func (r *TaskRunRecorder) TaskError(task utils.Task, err error) {
+	r.record(task)
+}
+
+func (r *TaskRunRecorder) record(task utils.Task) {
+	hueTask, ok := task.(*utils.HueTaskWrapper)
+	if !ok {
+		return
+	}
+	end := r.clock.Now()
+	r.mu.Lock()
+	start, ok := r.started[task]
+	delete(r.started, task)
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+	run := &huedb.TaskRun{
+		HueTaskId:   hueTask.H.Id,
+		Description: hueTask.H.Description,
+		Start:       start,
+		Duration:    end.Sub(start),
+	}
+	if err := r.store.AddTaskRun(nil, run); err != nil {
+		r.logger.Log(
+			"Error recording task run",
+			utils.NewField("hue_task_id", hueTask.H.Id),
+			utils.NewField("error", err))
+	}
+}
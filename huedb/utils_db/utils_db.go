@@ -0,0 +1,89 @@
+// Package utils_db connects the utils package to the huedb persistence
+// layer so that huedb itself need not depend on utils.
+package utils_db
+
+import (
+	"github.com/keep94/consume"
+	"github.com/keep94/marvin2/huedb"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/marvin2/recurring"
+	"github.com/keep94/marvin2/utils"
+	"log"
+)
+
+// ScheduledTasksStore is the interface that ScheduledTasks needs.
+type ScheduledTasksStore interface {
+	huedb.EncodedScheduledTasksRunner
+	huedb.EnableEncodedScheduledTaskRunner
+}
+
+// ScheduledTasks reconstructs the scheduled tasks persisted in store so
+// that they run with te. decoder decodes their hue actions, and
+// recurring.Parse decodes their Recurring fields. The Id of each returned
+// scheduled task is idRange.Global of the Id of the persisted one.
+// If setUp is non-nil, ScheduledTasks calls it on each scheduled task
+// before enabling any of them so that setUp can set fields such as Misfire
+// and FireTimes. ScheduledTasks then enables the returned scheduled tasks
+// that were persisted as enabled. From then on, enabling or disabling a returned scheduled
+// task updates its Enabled field in store. ScheduledTasks logs and skips
+// persisted scheduled tasks that it cannot reconstruct.
+func ScheduledTasks(
+	store ScheduledTasksStore,
+	decoder huedb.ActionDecoder,
+	idRange ops.IdRange,
+	te *utils.MultiExecutor,
+	setUp func(st *utils.ScheduledTask),
+	logger *log.Logger) (utils.ScheduledTaskList, error) {
+	var allEncoded []*huedb.EncodedScheduledTask
+	if err := store.EncodedScheduledTasks(
+		nil, consume.AppendPtrsTo(&allEncoded)); err != nil {
+		return nil, err
+	}
+	enabledStore := huedb.NewEnabledStore(store, utils.StdLogger(logger))
+	var result utils.ScheduledTaskList
+	var loaded []*huedb.EncodedScheduledTask
+	for _, encoded := range allEncoded {
+		h, err := decoder.Decode(encoded.HueTaskId, encoded.Action)
+		if err != nil {
+			logger.Printf(
+				"While decoding hue task %d: %v", encoded.HueTaskId, err)
+			continue
+		}
+		lightSet, err := lights.InvString(encoded.LightSet)
+		if err != nil {
+			logger.Printf("Error parsing light set %s", encoded.LightSet)
+			continue
+		}
+		r, err := recurring.Parse(encoded.Recurring)
+		if err != nil {
+			logger.Printf(
+				"Error parsing recurring spec %q of scheduled task %d",
+				encoded.Recurring, encoded.Id)
+			continue
+		}
+		scheduledTask := utils.HueTaskToScheduledTask(
+			idRange.Global(encoded.Id),
+			&ops.HueTask{
+				Id:          encoded.HueTaskId,
+				HueAction:   h,
+				Description: encoded.Description,
+			},
+			lightSet,
+			&utils.Recurring{R: r, Description: encoded.Recurring},
+			encoded.Priority,
+			te)
+		if setUp != nil {
+			setUp(scheduledTask)
+		}
+		result = append(result, scheduledTask)
+		loaded = append(loaded, encoded)
+	}
+	for i, scheduledTask := range result {
+		if loaded[i].Enabled {
+			scheduledTask.Enable()
+		}
+		scheduledTask.SaveEnabled(enabledStore, int(loaded[i].Id))
+	}
+	return result, nil
+}
@@ -0,0 +1,213 @@
+package utils_db_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/keep94/consume"
+	"github.com/keep94/marvin2/huedb"
+	"github.com/keep94/marvin2/huedb/in_memory"
+	"github.com/keep94/marvin2/huedb/utils_db"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/marvin2/utils"
+	"github.com/keep94/tasks"
+	"github.com/keep94/toolbox/db"
+	"log"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	kDecodeNotSupported = errors.New("utils_db: Decode not supported")
+	kDbError            = errors.New("utils_db: Some database error.")
+)
+
+const (
+	kIdDoesNotSupportDecode = 109
+)
+
+func TestScheduledTasks(t *testing.T) {
+	store := fakeEncodedScheduledTaskStore{
+		{
+			Id:          1,
+			HueTaskId:   31,
+			Action:      "131",
+			Description: "First",
+			LightSet:    "1,2",
+			Recurring:   "3:00",
+			Priority:    utils.PriorityHigh,
+			Enabled:     true,
+		},
+		{Id: 2, HueTaskId: kIdDoesNotSupportDecode, LightSet: "All", Recurring: "3:00"},
+		{Id: 3, HueTaskId: 32, Action: "32", LightSet: "All", Recurring: "bad"},
+		{Id: 4, HueTaskId: 33, Action: "33", LightSet: "bad", Recurring: "3:00"},
+		{
+			Id:          5,
+			HueTaskId:   34,
+			Action:      "44",
+			Description: "Second",
+			LightSet:    "All",
+			Recurring:   "hourly",
+		},
+	}
+	buffer := bytes.NewBuffer(nil)
+	logger := log.New(buffer, "", 0)
+	var fakeDecoder fakeActionDecoder
+	var setUpCount int
+	setUp := func(st *utils.ScheduledTask) {
+		if st.IsEnabled() {
+			t.Errorf("Expected %d to be set up before enabling", st.Id)
+		}
+		st.Misfire = utils.MisfireRunOnce
+		setUpCount++
+	}
+	scheduledTasks, err := utils_db.ScheduledTasks(
+		store,
+		fakeDecoder,
+		ops.IdRange{Name: "scheduled", Start: 1000, End: 2000},
+		utils.NewMultiExecutor(nil, utils.StdLogger(logger)),
+		setUp,
+		logger)
+	if err != nil {
+		t.Fatalf("Got error: %v", err)
+	}
+	if out := len(scheduledTasks); out != 2 {
+		t.Fatalf("Expected 2 scheduled tasks, got %d", out)
+	}
+	if setUpCount != 2 || scheduledTasks[0].Misfire != utils.MisfireRunOnce {
+		t.Errorf("Expected each scheduled task to be set up, got %d", setUpCount)
+	}
+	defer scheduledTasks[0].Disable()
+	first, second := scheduledTasks[0], scheduledTasks[1]
+	if first.Id != 1001 || first.Description != "First" || first.Priority != utils.PriorityHigh || !reflect.DeepEqual(lights.New(1, 2), first.Lights) || first.Times.Description != "3:00" {
+		t.Errorf("Unexpected first scheduled task: %+v", first)
+	}
+	if !first.IsEnabled() {
+		t.Error("Expected first scheduled task to be enabled")
+	}
+	if second.Id != 1005 || second.Description != "Second" || second.Priority != utils.PriorityLow || !second.Lights.IsAll() || second.Times.Description != "hourly" {
+		t.Errorf("Unexpected second scheduled task: %+v", second)
+	}
+	if second.IsEnabled() {
+		t.Error("Expected second scheduled task to be disabled")
+	}
+	if len(buffer.Bytes()) == 0 {
+		t.Error("Expected skipped scheduled tasks to be logged")
+	}
+	second.Enable()
+	defer second.Disable()
+	if !store[4].Enabled {
+		t.Error("Expected enabling second to persist")
+	}
+	first.Disable()
+	if store[0].Enabled {
+		t.Error("Expected disabling first to persist")
+	}
+	if _, err := utils_db.ScheduledTasks(
+		errEncodedScheduledTaskStore{},
+		fakeDecoder,
+		ops.IdRange{Name: "scheduled", Start: 1000, End: 2000},
+		utils.NewMultiExecutor(nil, utils.StdLogger(logger)),
+		nil,
+		logger); err != kDbError {
+		t.Errorf("Expected kDbError, got %v", err)
+	}
+}
+
+func TestTaskRunRecorder(t *testing.T) {
+	store := in_memory.New()
+	start := time.Date(2026, 3, 14, 7, 0, 0, 0, time.Local)
+	clock := &tasks.ClockForTesting{Current: start}
+	te := utils.NewMultiExecutorWithClock(nil, nil, clock)
+	defer te.Close()
+	te.AddListener(utils_db.NewTaskRunRecorder(
+		store, clock, utils.StdLogger(log.New(bytes.NewBuffer(nil), "", 0))))
+	e := te.Start(
+		&ops.HueTask{Id: 5, HueAction: sleepAction(time.Minute), Description: "Five"},
+		lights.All)
+	<-e.Done()
+	var run huedb.TaskRun
+	if err := store.LastTaskRun(nil, 5, &run); err != nil {
+		t.Fatalf("Expected run to be recorded, got %v", err)
+	}
+	if run.Description != "Five" || !run.Start.Equal(start) || run.Duration != time.Minute {
+		t.Errorf("Unexpected run: %+v", run)
+	}
+}
+
+type fakeEncodedScheduledTaskStore []*huedb.EncodedScheduledTask
+
+func (f fakeEncodedScheduledTaskStore) EncodedScheduledTasks(
+	t db.Transaction, consumer consume.Consumer) error {
+	for i := range f {
+		if !consumer.CanConsume() {
+			break
+		}
+		encoded := *f[i]
+		consumer.Consume(&encoded)
+	}
+	return nil
+}
+
+func (f fakeEncodedScheduledTaskStore) EnableEncodedScheduledTask(
+	t db.Transaction, id int64, enabled bool) error {
+	for i := range f {
+		if f[i].Id == id {
+			f[i].Enabled = enabled
+		}
+	}
+	return nil
+}
+
+type errEncodedScheduledTaskStore struct {
+}
+
+func (e errEncodedScheduledTaskStore) EncodedScheduledTasks(
+	t db.Transaction, consumer consume.Consumer) error {
+	return kDbError
+}
+
+func (e errEncodedScheduledTaskStore) EnableEncodedScheduledTask(
+	t db.Transaction, id int64, enabled bool) error {
+	return kDbError
+}
+
+type fakeActionDecoder struct {
+}
+
+func (f fakeActionDecoder) Decode(
+	id int, encoded string) (action ops.HueAction, err error) {
+	if id == kIdDoesNotSupportDecode {
+		err = kDecodeNotSupported
+		return
+	}
+	var aid int
+	if aid, err = strconv.Atoi(encoded); err != nil {
+		return
+	}
+	action = intAction(aid - id)
+	return
+}
+
+type sleepAction time.Duration
+
+func (s sleepAction) Do(
+	ctx ops.Context, lightSet lights.Set, e *tasks.Execution) {
+	e.Sleep(time.Duration(s))
+}
+
+func (s sleepAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
+
+type intAction int
+
+func (i intAction) Do(
+	ctx ops.Context, lightSet lights.Set, e *tasks.Execution) {
+}
+
+func (i intAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
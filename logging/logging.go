@@ -0,0 +1,102 @@
+// Package logging provides the structured Logger that marvin2 packages
+// log through.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Field is a named value that goes with a log message such as a task id
+// or a set of lights.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// NewField returns a new Field.
+func NewField(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger logs messages along with structured fields. Implementations
+// must be safe to use with multiple goroutines.
+type Logger interface {
+	Log(msg string, fields ...Field)
+}
+
+// StdLogger returns a Logger that writes each message to l as one line
+// of the form "msg: key1=value1 key2=value2". StdLogger returns nil if
+// l is nil.
+func StdLogger(l *log.Logger) Logger {
+	if l == nil {
+		return nil
+	}
+	return stdLogger{l}
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (s stdLogger) Log(msg string, fields ...Field) {
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for i, field := range fields {
+		if i == 0 {
+			sb.WriteString(":")
+		}
+		value := fieldValue(field.Value)
+		if str, ok := value.(string); ok && strings.ContainsAny(str, " \t\"=") {
+			value = fmt.Sprintf("%q", str)
+		}
+		fmt.Fprintf(&sb, " %s=%v", field.Key, value)
+	}
+	s.l.Println(sb.String())
+}
+
+// JSONLogger returns a Logger that writes each message to w as a JSON
+// object on its own line. The object has the time, the message as "msg",
+// and each field. Errors and values implementing fmt.Stringer appear as
+// strings.
+func JSONLogger(w io.Writer) Logger {
+	return &jsonLogger{w: w}
+}
+
+type jsonLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (j *jsonLogger) Log(msg string, fields ...Field) {
+	entry := make(map[string]interface{}, len(fields)+2)
+	for _, field := range fields {
+		entry[field.Key] = fieldValue(field.Value)
+	}
+	entry["time"] = time.Now().Format(time.RFC3339)
+	entry["msg"] = msg
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]interface{}{
+			"msg": msg, "error": err.Error()})
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.w.Write(append(line, '\n'))
+}
+
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return value
+	}
+}
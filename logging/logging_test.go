@@ -0,0 +1,54 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/keep94/marvin2/logging"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := logging.StdLogger(log.New(&buffer, "", 0))
+	logger.Log(
+		"Task finished",
+		logging.NewField("task_id", 7),
+		logging.NewField("description", "Wake up"),
+		logging.NewField("error", errors.New("bad")))
+	expected := "Task finished: task_id=7 description=\"Wake up\" error=bad\n"
+	if output := buffer.String(); output != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+	if logging.StdLogger(nil) != nil {
+		t.Error("Expected nil Logger")
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := logging.JSONLogger(&buffer)
+	logger.Log(
+		"Task started",
+		logging.NewField("task_id", 7),
+		logging.NewField("wait", 90*time.Second))
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buffer.Bytes(), &entry); err != nil {
+		t.Fatalf("Error decoding %q: %v", buffer.String(), err)
+	}
+	if _, ok := entry["time"]; !ok {
+		t.Error("Expected time")
+	}
+	delete(entry, "time")
+	expected := map[string]interface{}{
+		"msg":     "Task started",
+		"task_id": 7.0,
+		"wait":    "1m30s",
+	}
+	if !reflect.DeepEqual(expected, entry) {
+		t.Errorf("Expected %v, got %v", expected, entry)
+	}
+}
@@ -0,0 +1,94 @@
+package ops
+
+import (
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/tasks"
+	"time"
+)
+
+const (
+	// AlertNone stops any alert in progress.
+	AlertNone = "none"
+
+	// AlertSelect makes a light breathe once.
+	AlertSelect = "select"
+
+	// AlertLSelect makes a light breathe repeatedly for 15 seconds.
+	AlertLSelect = "lselect"
+)
+
+// Interface AlertContext starts and stops the breathe effect on a light.
+// Context implementations that support alerts should implement this
+// interface.
+type AlertContext interface {
+	// Alert sets the alert of a light to AlertNone, AlertSelect, or
+	// AlertLSelect. Light Id 0 means all lights.
+	Alert(lightId int, alert string) (response []byte, err error)
+}
+
+// AlertAction flashes lights using the breathe effect of the hue bridge
+// and then restores the lights to their previous state. AlertAction needs
+// a Context that implements both AlertContext and LightReader and reports
+// an *UnsupportedError without one.
+// When run on all lights, AlertAction has no way to know the previous
+// state of the lights, so it leaves them as the breathe effect leaves them.
+type AlertAction struct {
+	// If true, lights breathe for 15 seconds; otherwise lights breathe
+	// once.
+	Long bool
+}
+
+func (a AlertAction) Do(
+	ctxt Context, lightSet lights.Set, e *tasks.Execution) {
+	alerter, ok := ctxt.(AlertContext)
+	if !ok {
+		e.SetError(&UnsupportedError{Interface: "AlertContext"})
+		return
+	}
+	reader, ok := ctxt.(LightReader)
+	if !ok {
+		e.SetError(&UnsupportedError{Interface: "LightReader"})
+		return
+	}
+	ids, ok := lightSet.Slice()
+	if !ok {
+		return
+	}
+	if len(ids) == 0 {
+		ids = []int{0}
+	}
+	states, err := SnapshotStates(reader, lightSet)
+	if err != nil {
+		e.SetError(err)
+		return
+	}
+	alert, duration := AlertSelect, time.Second
+	if a.Long {
+		alert, duration = AlertLSelect, 15*time.Second
+	}
+	if err := setAlert(alerter, ids, alert); err != nil {
+		e.SetError(err)
+		return
+	}
+	e.Sleep(duration)
+	if err := setAlert(alerter, ids, AlertNone); err != nil {
+		e.SetError(err)
+		return
+	}
+	if err := RestoreStates(ctxt, states); err != nil {
+		e.SetError(err)
+	}
+}
+
+func (a AlertAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
+
+func setAlert(alerter AlertContext, ids []int, alert string) error {
+	for _, id := range ids {
+		if response, err := alerter.Alert(id, alert); err != nil {
+			return FixError(id, response, err)
+		}
+	}
+	return nil
+}
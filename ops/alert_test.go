@@ -0,0 +1,123 @@
+package ops_test
+
+import (
+	"fmt"
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/maybe"
+	"github.com/keep94/tasks"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAlertAction(t *testing.T) {
+	ctxt := &alertContextForTesting{
+		readWriteContextForTesting: readWriteContextForTesting{
+			contextForTesting{
+				2: {
+					C:   gohue.NewMaybeColor(gohue.Red),
+					Bri: maybe.NewUint8(100),
+					On:  maybe.NewBool(true),
+				},
+			},
+		},
+	}
+	clock := &tasks.ClockForTesting{Current: time.Date(
+		2020, 6, 1, 0, 0, 0, 0, time.Local)}
+	err := tasks.RunForTesting(tasks.TaskFunc(func(e *tasks.Execution) {
+		ops.AlertAction{Long: true}.Do(ctxt, lights.New(2), e)
+	}), clock)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expectedAlerts := []string{"2:lselect", "2:none"}
+	if !reflect.DeepEqual(expectedAlerts, ctxt.alerts) {
+		t.Errorf("Expected %v, got %v", expectedAlerts, ctxt.alerts)
+	}
+	expected := &gohue.LightProperties{
+		C:              gohue.NewMaybeColor(gohue.Red),
+		Bri:            maybe.NewUint8(100),
+		On:             maybe.NewBool(true),
+		TransitionTime: maybe.NewUint16(4),
+	}
+	if out := ctxt.contextForTesting[2]; !reflect.DeepEqual(expected, out) {
+		t.Errorf("Expected %v, got %v", expected, out)
+	}
+	if out := clock.Current.Sub(time.Date(
+		2020, 6, 1, 0, 0, 0, 0, time.Local)); out != 15*time.Second {
+		t.Errorf("Expected 15s alert, got %v", out)
+	}
+}
+
+func TestAlertActionAllLights(t *testing.T) {
+	ctxt := &alertContextForTesting{
+		readWriteContextForTesting: readWriteContextForTesting{
+			make(contextForTesting)},
+	}
+	clock := &tasks.ClockForTesting{}
+	err := tasks.RunForTesting(tasks.TaskFunc(func(e *tasks.Execution) {
+		ops.AlertAction{}.Do(ctxt, lights.All, e)
+	}), clock)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expectedAlerts := []string{"0:select", "0:none"}
+	if !reflect.DeepEqual(expectedAlerts, ctxt.alerts) {
+		t.Errorf("Expected %v, got %v", expectedAlerts, ctxt.alerts)
+	}
+}
+
+func TestAlertActionNoAlertContext(t *testing.T) {
+	ctxt := readWriteContextForTesting{contextForTesting{
+		2: {On: maybe.NewBool(false)}}}
+	err := tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		ops.AlertAction{}.Do(ctxt, lights.New(2), e)
+	}))
+	if unsupported, ok := err.(*ops.UnsupportedError); !ok ||
+		unsupported.Interface != "AlertContext" {
+		t.Errorf("Expected UnsupportedError, got %v", err)
+	}
+	if out := ctxt.contextForTesting[2]; out.TransitionTime.Valid {
+		t.Errorf("Expected light untouched, got %v", out)
+	}
+}
+
+func TestAlertActionNoLightReader(t *testing.T) {
+	ctxt := &alertOnlyContextForTesting{
+		contextForTesting: make(contextForTesting)}
+	err := tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		ops.AlertAction{}.Do(ctxt, lights.New(2), e)
+	}))
+	if unsupported, ok := err.(*ops.UnsupportedError); !ok ||
+		unsupported.Interface != "LightReader" {
+		t.Errorf("Expected UnsupportedError, got %v", err)
+	}
+	if len(ctxt.alerts) != 0 {
+		t.Errorf("Expected no alerts, got %v", ctxt.alerts)
+	}
+}
+
+type alertContextForTesting struct {
+	readWriteContextForTesting
+	alerts []string
+}
+
+func (c *alertContextForTesting) Alert(lightId int, alert string) (
+	[]byte, error) {
+	c.alerts = append(c.alerts, fmt.Sprintf("%d:%s", lightId, alert))
+	return nil, nil
+}
+
+// alertOnlyContextForTesting supports alerts but cannot read lights.
+type alertOnlyContextForTesting struct {
+	contextForTesting
+	alerts []string
+}
+
+func (c *alertOnlyContextForTesting) Alert(lightId int, alert string) (
+	[]byte, error) {
+	c.alerts = append(c.alerts, fmt.Sprintf("%d:%s", lightId, alert))
+	return nil, nil
+}
@@ -0,0 +1,313 @@
+package ops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/maybe"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// BridgeContext talks to a hue bridge just like gohue.Context except that
+// it implements CancelableContext so that its calls to the bridge abort
+// as soon as the task making them is interrupted. BridgeContext also
+// implements LightReader, LightStateReader, and LightStateWriter so that
+// snapshots keep the color temperature and effect of each light and
+// SceneContext so that it can recall the scenes stored on the bridge and
+// AlertContext so that it can flash lights with AlertAction. Like
+// gohue.Context, BridgeContext reports error
+// responses from the bridge as gohue.NoSuchResourceError or
+// gohue.GeneralError along with the raw response so that FixError works
+// with it. BridgeContext instances are safe to use with multiple
+// goroutines.
+type BridgeContext struct {
+	host   string
+	userId string
+	client *http.Client
+	ctx    context.Context
+}
+
+// NewBridgeContext creates a new BridgeContext. host is the ip address or
+// DNS name of the hue bridge; userId is the user Id that the bridge
+// issued. client sends the requests to the bridge; nil means
+// http.DefaultClient.
+func NewBridgeContext(
+	host, userId string, client *http.Client) *BridgeContext {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &BridgeContext{
+		host:   host,
+		userId: userId,
+		client: client,
+		ctx:    context.Background()}
+}
+
+// WithContext returns a BridgeContext whose calls to the bridge abort as
+// soon as ctx is done.
+func (c *BridgeContext) WithContext(ctx context.Context) Context {
+	result := *c
+	result.ctx = ctx
+	return &result
+}
+
+// Set sets the properties of a light. 0 means all lights.
+func (c *BridgeContext) Set(
+	lightId int, properties *gohue.LightProperties) ([]byte, error) {
+	jsonMap := make(map[string]interface{})
+	if properties.C.Valid {
+		jsonMap["xy"] = []float64{properties.C.X(), properties.C.Y()}
+	}
+	if properties.Bri.Valid {
+		jsonMap["bri"] = properties.Bri.Value
+	}
+	if properties.On.Valid {
+		jsonMap["on"] = properties.On.Value
+	}
+	if properties.TransitionTime.Valid {
+		jsonMap["transitiontime"] = properties.TransitionTime.Value
+	}
+	return c.put(lightId, jsonMap)
+}
+
+// Get gets the on/off state, color, and brightness of a light.
+func (c *BridgeContext) Get(lightId int) (
+	*gohue.LightProperties, []byte, error) {
+	state, response, err := c.getState(lightId)
+	if err != nil {
+		return nil, response, err
+	}
+	return &gohue.LightProperties{
+		C:   state.color(),
+		Bri: maybe.NewUint8(state.Bri),
+		On:  maybe.NewBool(state.On)}, response, nil
+}
+
+// GetState gets the full state of a light.
+func (c *BridgeContext) GetState(lightId int) (*LightState, []byte, error) {
+	state, response, err := c.getState(lightId)
+	if err != nil {
+		return nil, response, err
+	}
+	result := &LightState{
+		On:         state.On,
+		Color:      state.color(),
+		Brightness: maybe.NewUint8(state.Bri),
+		ColorMode:  ColorModeXY,
+		Effect:     state.Effect,
+	}
+	if state.Ct != nil {
+		result.Ct = maybe.NewUint16(*state.Ct)
+	}
+	if state.ColorMode == ColorModeCT {
+		result.ColorMode = ColorModeCT
+	}
+	return result, response, nil
+}
+
+// SetState sets the full state of a light. A light in ColorModeCT gets
+// its color temperature rather than its xy color. SetState sets the
+// effect only if state has one because the bridge rejects effects for
+// lights that don't support them.
+func (c *BridgeContext) SetState(
+	lightId int, state *LightState) ([]byte, error) {
+	jsonMap := map[string]interface{}{"on": state.On}
+	if state.TransitionTime.Valid {
+		jsonMap["transitiontime"] = state.TransitionTime.Value
+	}
+	if state.On {
+		if state.Brightness.Valid {
+			jsonMap["bri"] = state.Brightness.Value
+		}
+		if state.ColorMode == ColorModeCT && state.Ct.Valid {
+			jsonMap["ct"] = state.Ct.Value
+		} else if state.Color.Valid {
+			jsonMap["xy"] = []float64{state.Color.X(), state.Color.Y()}
+		}
+		if state.Effect != "" {
+			jsonMap["effect"] = state.Effect
+		}
+	}
+	return c.put(lightId, jsonMap)
+}
+
+// Alert sets the alert of a light to AlertNone, AlertSelect, or
+// AlertLSelect. 0 means all lights.
+func (c *BridgeContext) Alert(lightId int, alert string) ([]byte, error) {
+	return c.put(lightId, map[string]interface{}{"alert": alert})
+}
+
+// RecallScene recalls the scene with given Id on all lights. RecallScene
+// reports gohue.NoSuchResourceError if the bridge has no such scene.
+func (c *BridgeContext) RecallScene(sceneId string) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{"scene": sceneId})
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.do("PUT", c.url("groups/0/action"), body)
+	if err != nil {
+		return response, err
+	}
+	// The bridge reports an invalid value for an unknown scene Id.
+	if bridgeErrorType(response) == ErrInvalidValue.Type {
+		return response, gohue.NoSuchResourceError
+	}
+	return response, bridgeResponseError(response)
+}
+
+// Scenes returns the scenes stored on the bridge ordered by Id.
+func (c *BridgeContext) Scenes() (SceneList, []byte, error) {
+	response, err := c.do("GET", c.url("scenes"), nil)
+	if err != nil {
+		return nil, response, err
+	}
+	var scenes map[string]jsonScene
+	if err := json.Unmarshal(response, &scenes); err != nil {
+		if err := bridgeResponseError(response); err != nil {
+			return nil, response, err
+		}
+		return nil, response, gohue.GeneralError
+	}
+	result := make(SceneList, 0, len(scenes))
+	for id, scene := range scenes {
+		result = append(result, scene.toScene(id))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+	return result, response, nil
+}
+
+// jsonScene is a scene as the bridge reports it.
+type jsonScene struct {
+	Name   string
+	Lights []string
+}
+
+func (s *jsonScene) toScene(id string) *Scene {
+	lightIds := make([]int, 0, len(s.Lights))
+	for _, light := range s.Lights {
+		if lightId, err := strconv.Atoi(light); err == nil {
+			lightIds = append(lightIds, lightId)
+		}
+	}
+	return &Scene{Id: id, Name: s.Name, Lights: lights.New(lightIds...)}
+}
+
+// jsonLightState is the state of a light as the bridge reports it.
+type jsonLightState struct {
+	On        bool
+	Bri       uint8
+	XY        []float64
+	Ct        *uint16
+	ColorMode string
+	Effect    string
+}
+
+func (s *jsonLightState) color() gohue.MaybeColor {
+	if len(s.XY) != 2 {
+		return gohue.MaybeColor{}
+	}
+	return gohue.NewMaybeColor(gohue.NewColor(s.XY[0], s.XY[1]))
+}
+
+type jsonLight struct {
+	State *jsonLightState
+}
+
+func (c *BridgeContext) getState(lightId int) (
+	*jsonLightState, []byte, error) {
+	response, err := c.do(
+		"GET", c.url(fmt.Sprintf("lights/%d", lightId)), nil)
+	if err != nil {
+		return nil, response, err
+	}
+	var light jsonLight
+	if err := json.Unmarshal(response, &light); err != nil {
+		if err := bridgeResponseError(response); err != nil {
+			return nil, response, err
+		}
+		return nil, response, gohue.GeneralError
+	}
+	if light.State == nil {
+		return nil, response, gohue.GeneralError
+	}
+	return light.State, response, nil
+}
+
+func (c *BridgeContext) put(
+	lightId int, jsonMap map[string]interface{}) ([]byte, error) {
+	body, err := json.Marshal(jsonMap)
+	if err != nil {
+		return nil, err
+	}
+	path := fmt.Sprintf("lights/%d/state", lightId)
+	if lightId == 0 {
+		path = "groups/0/action"
+	}
+	response, err := c.do("PUT", c.url(path), body)
+	if err != nil {
+		return response, err
+	}
+	return response, bridgeResponseError(response)
+}
+
+func (c *BridgeContext) url(path string) string {
+	u := url.URL{
+		Scheme: "http",
+		Host:   c.host,
+		Path:   fmt.Sprintf("/api/%s/%s", c.userId, path),
+	}
+	return u.String()
+}
+
+func (c *BridgeContext) do(
+	method, rawURL string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequestWithContext(c.ctx, method, rawURL, reader)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// bridgeResponseError returns the error that gohue reports for
+// rawResponse or nil if rawResponse reports no error.
+func bridgeResponseError(rawResponse []byte) error {
+	switch bridgeErrorType(rawResponse) {
+	case 0:
+		return nil
+	case ErrResourceNotAvailable.Type:
+		return gohue.NoSuchResourceError
+	default:
+		return gohue.GeneralError
+	}
+}
+
+// bridgeErrorType returns the type of the error that rawResponse reports
+// or 0 if rawResponse reports no error.
+func bridgeErrorType(rawResponse []byte) int {
+	var responses []jsonBridgeResponse
+	if json.Unmarshal(rawResponse, &responses) != nil {
+		return 0
+	}
+	if len(responses) > 0 && responses[0].Error != nil {
+		return responses[0].Error.Type
+	}
+	return 0
+}
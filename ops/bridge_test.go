@@ -0,0 +1,269 @@
+package ops_test
+
+import (
+	"context"
+	"errors"
+	"github.com/keep94/gohue"
+	"github.com/keep94/gohue/actions"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/maybe"
+	"github.com/keep94/tasks"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBridgeContext(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests = append(
+				requests, r.Method+" "+r.URL.Path+" "+string(body))
+			switch r.URL.Path {
+			case "/api/user/lights/2":
+				io.WriteString(
+					w, `{"state":{"on":true,"bri":100,"xy":[0.3,0.4]}}`)
+			case "/api/user/lights/9", "/api/user/lights/9/state":
+				io.WriteString(
+					w, `[{"error":{"type":3,"address":"/lights/9","description":"not available"}}]`)
+			default:
+				io.WriteString(w, `[{"success":{}}]`)
+			}
+		}))
+	defer server.Close()
+	ctxt := ops.NewBridgeContext(
+		strings.TrimPrefix(server.URL, "http://"), "user", nil)
+	if _, err := ctxt.Set(0, &gohue.LightProperties{
+		On: maybe.NewBool(true), Bri: maybe.NewUint8(50)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctxt.Set(3, &gohue.LightProperties{
+		C: gohue.NewMaybeColor(gohue.NewColor(0.5, 0.25))}); err != nil {
+		t.Fatal(err)
+	}
+	properties, _, err := ctxt.Get(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !properties.On.Value || properties.Bri.Value != 100 ||
+		properties.C.Color != gohue.NewColor(0.3, 0.4) {
+		t.Errorf("Unexpected properties %v", properties)
+	}
+	_, response, err := ctxt.Get(9)
+	if err != gohue.NoSuchResourceError {
+		t.Errorf("Expected NoSuchResourceError, got %v", err)
+	}
+	if _, ok := ops.FixError(9, response, err).(*actions.NoSuchLightIdError); !ok {
+		t.Error("Expected NoSuchLightIdError")
+	}
+	response, err = ctxt.Set(9, &gohue.LightProperties{})
+	if _, ok := ops.FixError(9, response, err).(*actions.NoSuchLightIdError); !ok {
+		t.Error("Expected NoSuchLightIdError")
+	}
+	expected := []string{
+		`PUT /api/user/groups/0/action {"bri":50,"on":true}`,
+		`PUT /api/user/lights/3/state {"xy":[0.5,0.25]}`,
+		`GET /api/user/lights/2 `,
+		`GET /api/user/lights/9 `,
+		`PUT /api/user/lights/9/state {}`,
+	}
+	if strings.Join(requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %v, got %v", expected, requests)
+	}
+}
+
+func TestBridgeContextCanceled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}))
+	defer server.Close()
+	defer close(release)
+	var ctxt ops.CancelableContext = ops.NewBridgeContext(
+		strings.TrimPrefix(server.URL, "http://"), "user", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	canceledCtxt := ctxt.WithContext(ctx)
+	if _, ok := canceledCtxt.(ops.LightReader); !ok {
+		t.Error("Expected LightReader to be preserved.")
+	}
+	errs := make(chan error, 1)
+	go func() {
+		_, err := canceledCtxt.Set(1, &gohue.LightProperties{})
+		errs <- err
+	}()
+	cancel()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected Set to abort once its context was canceled.")
+	}
+}
+
+func TestBridgeContextStates(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests = append(
+				requests, r.Method+" "+r.URL.Path+" "+string(body))
+			switch r.URL.Path {
+			case "/api/user/lights/1":
+				io.WriteString(
+					w, `{"state":{"on":true,"bri":200,"xy":[0.4,0.4],"ct":366,"colormode":"ct","effect":"none"}}`)
+			case "/api/user/lights/2":
+				io.WriteString(
+					w, `{"state":{"on":true,"bri":100,"xy":[0.3,0.4],"ct":153,"colormode":"xy","effect":"colorloop"}}`)
+			case "/api/user/lights/3":
+				io.WriteString(w, `{"state":{"on":false,"bri":10}}`)
+			default:
+				io.WriteString(w, `[{"success":{}}]`)
+			}
+		}))
+	defer server.Close()
+	ctxt := ops.NewBridgeContext(
+		strings.TrimPrefix(server.URL, "http://"), "user", nil)
+	states, err := ops.SnapshotStates(ctxt, lights.New(1, 2, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ops.LightStates{
+		1: {
+			On:         true,
+			Color:      gohue.NewMaybeColor(gohue.NewColor(0.4, 0.4)),
+			Brightness: maybe.NewUint8(200),
+			Ct:         maybe.NewUint16(366),
+			ColorMode:  ops.ColorModeCT,
+			Effect:     ops.EffectNone,
+		},
+		2: {
+			On:         true,
+			Color:      gohue.NewMaybeColor(gohue.NewColor(0.3, 0.4)),
+			Brightness: maybe.NewUint8(100),
+			Ct:         maybe.NewUint16(153),
+			ColorMode:  ops.ColorModeXY,
+			Effect:     ops.EffectColorLoop,
+		},
+		3: {Brightness: maybe.NewUint8(10), ColorMode: ops.ColorModeXY},
+	}
+	if !reflect.DeepEqual(expected, states) {
+		t.Errorf("Expected %v, got %v", expected, states)
+	}
+	requests = nil
+	for _, id := range []int{1, 2, 3} {
+		state := states[id]
+		if _, err := ctxt.SetState(id, &state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedRequests := []string{
+		`PUT /api/user/lights/1/state {"bri":200,"ct":366,"effect":"none","on":true}`,
+		`PUT /api/user/lights/2/state {"bri":100,"effect":"colorloop","on":true,"xy":[0.3,0.4]}`,
+		`PUT /api/user/lights/3/state {"on":false}`,
+	}
+	if strings.Join(requests, "\n") != strings.Join(expectedRequests, "\n") {
+		t.Errorf("Expected %v, got %v", expectedRequests, requests)
+	}
+}
+
+func TestBridgeContextScenes(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests = append(
+				requests, r.Method+" "+r.URL.Path+" "+string(body))
+			switch {
+			case r.URL.Path == "/api/user/scenes":
+				io.WriteString(
+					w, `{"abc":{"name":"Relax","lights":["1","3"]},"xyz":{"name":"Bright","lights":["2"]}}`)
+			case strings.Contains(string(body), "missing"):
+				io.WriteString(
+					w, `[{"error":{"type":7,"address":"/groups/0/action/scene","description":"invalid value, missing, for parameter, scene"}}]`)
+			default:
+				io.WriteString(w, `[{"success":{}}]`)
+			}
+		}))
+	defer server.Close()
+	ctxt := ops.NewBridgeContext(
+		strings.TrimPrefix(server.URL, "http://"), "user", nil)
+	scenes, err := ops.Scenes(ctxt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ops.SceneList{
+		{Id: "xyz", Name: "Bright", Lights: lights.New(2)},
+		{Id: "abc", Name: "Relax", Lights: lights.New(1, 3)},
+	}
+	if !reflect.DeepEqual(expected, scenes) {
+		t.Errorf("Expected %v, got %v", expected, scenes)
+	}
+	if err := runAction(
+		ops.RecallSceneAction{Scene: scenes[1]}, ctxt); err != nil {
+		t.Errorf("Got error recalling scene: %v", err)
+	}
+	if err := runAction(
+		ops.RecallSceneAction{Scene: &ops.Scene{Id: "missing"}},
+		ctxt); err != ops.ErrNoSuchScene {
+		t.Errorf("Expected ErrNoSuchScene, got %v", err)
+	}
+	expectedRequests := []string{
+		`GET /api/user/scenes `,
+		`PUT /api/user/groups/0/action {"scene":"abc"}`,
+		`PUT /api/user/groups/0/action {"scene":"missing"}`,
+	}
+	if strings.Join(requests, "\n") != strings.Join(expectedRequests, "\n") {
+		t.Errorf("Expected %v, got %v", expectedRequests, requests)
+	}
+}
+
+func TestBridgeContextAlert(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests = append(
+				requests, r.Method+" "+r.URL.Path+" "+string(body))
+			switch r.URL.Path {
+			case "/api/user/lights/2":
+				io.WriteString(
+					w, `{"state":{"on":true,"bri":100,"xy":[0.3,0.4],"colormode":"xy"}}`)
+			default:
+				io.WriteString(w, `[{"success":{}}]`)
+			}
+		}))
+	defer server.Close()
+	ctxt := ops.NewBridgeContext(
+		strings.TrimPrefix(server.URL, "http://"), "user", nil)
+	err := tasks.RunForTesting(tasks.TaskFunc(func(e *tasks.Execution) {
+		ops.AlertAction{}.Do(ctxt, lights.New(2), e)
+	}), &tasks.ClockForTesting{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctxt.Alert(0, ops.AlertNone); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`GET /api/user/lights/2 `,
+		`PUT /api/user/lights/2/state {"alert":"select"}`,
+		`PUT /api/user/lights/2/state {"alert":"none"}`,
+		`PUT /api/user/lights/2/state {"bri":100,"on":true,"transitiontime":4,"xy":[0.3,0.4]}`,
+		`PUT /api/user/groups/0/action {"alert":"none"}`,
+	}
+	if strings.Join(requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %v, got %v", expected, requests)
+	}
+}
@@ -0,0 +1,65 @@
+package ops
+
+import (
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/maybe"
+	"github.com/keep94/tasks"
+	"math"
+)
+
+// AdjustBrightness returns a HueAction that changes the brightness of each
+// light that is on by deltaPercent percent of full brightness leaving its
+// color unchanged, so a deltaPercent of -20 dims by 20%. The resulting
+// brightness is clamped between off and full brightness. Lights that are
+// off stay off. The returned HueAction needs a Context that implements
+// LightReader and reports an *UnsupportedError without one. The returned
+// HueAction does nothing when run on all lights because it cannot tell
+// what lights exist.
+func AdjustBrightness(deltaPercent int) HueAction {
+	return adjustBrightnessAction(deltaPercent)
+}
+
+type adjustBrightnessAction int
+
+func (a adjustBrightnessAction) Do(
+	ctxt Context, lightSet lights.Set, e *tasks.Execution) {
+	reader, ok := ctxt.(LightReader)
+	if !ok {
+		e.SetError(&UnsupportedError{Interface: "LightReader"})
+		return
+	}
+	delta := int(math.Round(float64(a) * 255.0 / 100.0))
+	colors, err := Snapshot(reader, lightSet)
+	if err != nil {
+		e.SetError(err)
+		return
+	}
+	for id, colorBrightness := range colors {
+		if !colorBrightness.Brightness.Valid {
+			continue
+		}
+		brightness := clampBrightness(
+			int(colorBrightness.Brightness.Value) + delta)
+		response, err := ctxt.Set(
+			id, &gohue.LightProperties{Bri: maybe.NewUint8(brightness)})
+		if err != nil {
+			e.SetError(FixError(id, response, err))
+			return
+		}
+	}
+}
+
+func (a adjustBrightnessAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
+
+func clampBrightness(brightness int) uint8 {
+	if brightness < 0 {
+		return 0
+	}
+	if brightness > 255 {
+		return 255
+	}
+	return uint8(brightness)
+}
@@ -0,0 +1,81 @@
+package ops_test
+
+import (
+	"github.com/keep94/gohue/actions"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/maybe"
+	"github.com/keep94/tasks"
+	"reflect"
+	"testing"
+)
+
+func TestAdjustBrightness(t *testing.T) {
+	ctxt := readWriteContextForTesting{contextForTesting{
+		1: {Bri: maybe.NewUint8(100), On: maybe.NewBool(true)},
+		2: {Bri: maybe.NewUint8(20), On: maybe.NewBool(true)},
+		3: {Bri: maybe.NewUint8(100), On: maybe.NewBool(false)},
+	}}
+	err := tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		ops.AdjustBrightness(-20).Do(ctxt, lights.New(1, 2, 3), e)
+	}))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := contextForTesting{
+		1: {Bri: maybe.NewUint8(49)},
+		2: {Bri: maybe.NewUint8(0)},
+		3: {Bri: maybe.NewUint8(100), On: maybe.NewBool(false)},
+	}
+	if !reflect.DeepEqual(expected, ctxt.contextForTesting) {
+		t.Errorf("Expected %v, got %v", expected, ctxt.contextForTesting)
+	}
+	ctxt = readWriteContextForTesting{contextForTesting{
+		1: {Bri: maybe.NewUint8(240), On: maybe.NewBool(true)},
+	}}
+	err = tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		ops.AdjustBrightness(20).Do(ctxt, lights.New(1), e)
+	}))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if out := ctxt.contextForTesting[1].Bri; out != maybe.NewUint8(255) {
+		t.Errorf("Expected 255, got %v", out)
+	}
+}
+
+func TestAdjustBrightnessUsedLights(t *testing.T) {
+	a := ops.AdjustBrightness(10)
+	if out := a.UsedLights(lights.New(2, 5)); !reflect.DeepEqual(
+		lights.New(2, 5), out) {
+		t.Errorf("Expected {2, 5}, got %v", out)
+	}
+}
+
+func TestAdjustBrightnessError(t *testing.T) {
+	ctxt := readWriteContextForTesting{contextForTesting{
+		1: {Bri: maybe.NewUint8(100), On: maybe.NewBool(true)},
+	}}
+	err := tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		ops.AdjustBrightness(10).Do(ctxt, lights.New(1, 2), e)
+	}))
+	if _, ok := err.(*actions.NoSuchLightIdError); !ok {
+		t.Errorf("Expected NoSuchLightIdError, got %v", err)
+	}
+	if out := ctxt.contextForTesting[1].Bri; out != maybe.NewUint8(100) {
+		t.Errorf("Expected light 1 unchanged, got %v", out)
+	}
+}
+
+func TestAdjustBrightnessNoReader(t *testing.T) {
+	ctxt := make(contextForTesting)
+	err := tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		ops.AdjustBrightness(10).Do(ctxt, lights.New(1), e)
+	}))
+	if _, ok := err.(*ops.UnsupportedError); !ok {
+		t.Errorf("Expected UnsupportedError, got %v", err)
+	}
+	if len(ctxt) != 0 {
+		t.Errorf("Expected nothing set, got %v", ctxt)
+	}
+}
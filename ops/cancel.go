@@ -0,0 +1,68 @@
+package ops
+
+import (
+	"context"
+	"github.com/keep94/tasks"
+	"time"
+)
+
+// CancelableContext is implemented by Context instances whose calls to
+// the hue bridge can be aborted.
+type CancelableContext interface {
+	Context
+
+	// WithContext returns a Context that works like this instance except
+	// that its calls to the hue bridge abort as soon as ctx is done.
+	// If this instance implements LightReader, the returned Context must
+	// also implement LightReader.
+	WithContext(ctx context.Context) Context
+}
+
+// ExecutionContext returns a context.Context that is canceled as soon as
+// e is signaled to end. Callers must call the returned cancel function
+// once they no longer need the returned context.Context.
+func ExecutionContext(e *tasks.Execution) (
+	context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-e.Ended():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// BindContext binds ctxt to e so that calls to the hue bridge abort as soon
+// as e is signaled to end. If ctxt does not implement CancelableContext,
+// BindContext returns ctxt unchanged. Callers must call the returned
+// function once the task running in e no longer needs the returned Context.
+func BindContext(ctxt Context, e *tasks.Execution) (Context, func()) {
+	cancelable, ok := ctxt.(CancelableContext)
+	if !ok {
+		return ctxt, func() {}
+	}
+	ctx, cancel := ExecutionContext(e)
+	return cancelable.WithContext(ctx), cancel
+}
+
+// sleepContext sleeps for d or until ctx is done, whichever comes first.
+// sleepContext returns false if ctx finished first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func withContext(ctxt Context, ctx context.Context) Context {
+	if cancelable, ok := ctxt.(CancelableContext); ok {
+		return cancelable.WithContext(ctx)
+	}
+	return ctxt
+}
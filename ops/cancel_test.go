@@ -0,0 +1,70 @@
+package ops_test
+
+import (
+	"context"
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/tasks"
+	"testing"
+	"time"
+)
+
+func TestBindContext(t *testing.T) {
+	plain := make(contextForTesting)
+	e := tasks.Start(tasks.TaskFunc(func(e *tasks.Execution) {
+		<-e.Ended()
+	}))
+	bound, cancel := ops.BindContext(plain, e)
+	if _, ok := bound.(contextForTesting); !ok {
+		t.Error("Expected non cancelable context to be returned as is.")
+	}
+	cancel()
+	cancelable := &cancelableContext{}
+	bound, cancel = ops.BindContext(cancelable, e)
+	defer cancel()
+	ctx := bound.(*cancelableContext).ctx
+	if ctx.Err() != nil {
+		t.Error("Expected context not to be done yet.")
+	}
+	e.End()
+	<-e.Done()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("Expected context to be done after execution ended.")
+	}
+}
+
+func TestRetryContextCanceled(t *testing.T) {
+	ctxt := &flakyContext{failures: 5, err: kNetworkError}
+	retryCtxt := ops.NewRetryContext(
+		ctxt, &ops.RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	canceledCtxt := retryCtxt.(ops.CancelableContext).WithContext(ctx)
+	if _, ok := canceledCtxt.(ops.LightReader); !ok {
+		t.Error("Expected LightReader to be preserved.")
+	}
+	if _, err := canceledCtxt.Set(1, &gohue.LightProperties{}); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if ctxt.calls != 1 {
+		t.Errorf("Expected 1 call, got %d", ctxt.calls)
+	}
+}
+
+type cancelableContext struct {
+	ctx context.Context
+}
+
+func (c *cancelableContext) Set(
+	lightId int, properties *gohue.LightProperties) ([]byte, error) {
+	if c.ctx != nil && c.ctx.Err() != nil {
+		return nil, c.ctx.Err()
+	}
+	return nil, nil
+}
+
+func (c *cancelableContext) WithContext(ctx context.Context) ops.Context {
+	return &cancelableContext{ctx: ctx}
+}
@@ -0,0 +1,276 @@
+package ops
+
+import (
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/tasks"
+	"sync"
+	"time"
+)
+
+// Parallel returns a HueAction that runs actions concurrently, each on
+// the lights it uses. The actions should use disjoint sets of lights.
+// The returned HueAction finishes when all of the actions finish.
+// If exactly one action reports an error, the returned HueAction reports
+// that error; if more than one action reports an error, it reports a
+// SetErrors instance.
+func Parallel(actions ...HueAction) HueAction {
+	return parallelAction(actions)
+}
+
+type parallelAction []HueAction
+
+func (p parallelAction) Do(
+	ctxt Context, lightSet lights.Set, e *tasks.Execution) {
+	errs := make([]error, len(p))
+	var ptasks []tasks.Task
+	for i := range p {
+		usedLights := p[i].UsedLights(lightSet)
+		if usedLights.IsNone() {
+			continue
+		}
+		action, idx := p[i], i
+		ptasks = append(ptasks, tasks.TaskFunc(func(e *tasks.Execution) {
+			errs[idx] = runChild(e, func(child *tasks.Execution) {
+				action.Do(ctxt, usedLights, child)
+			})
+		}))
+	}
+	tasks.ParallelTasks(ptasks...).Do(e)
+	e.SetError(mergeErrors(errs))
+}
+
+func (p parallelAction) UsedLights(lightSet lights.Set) lights.Set {
+	var builder lights.Builder
+	for _, action := range p {
+		builder.Add(action.UsedLights(lightSet))
+	}
+	return builder.Build()
+}
+
+// Sequence returns a HueAction that runs actions one after the other, each
+// on the lights it uses. The returned HueAction stops early if its task
+// is ended or if one of the actions reports an error.
+func Sequence(actions ...HueAction) HueAction {
+	return sequenceAction(actions)
+}
+
+type sequenceAction []HueAction
+
+func (s sequenceAction) Do(
+	ctxt Context, lightSet lights.Set, e *tasks.Execution) {
+	for _, action := range s {
+		if e.IsEnded() || e.Error() != nil {
+			return
+		}
+		usedLights := action.UsedLights(lightSet)
+		if usedLights.IsNone() {
+			continue
+		}
+		action.Do(ctxt, usedLights, e)
+	}
+}
+
+func (s sequenceAction) UsedLights(lightSet lights.Set) lights.Set {
+	return parallelAction(s).UsedLights(lightSet)
+}
+
+// If returns a HueAction that reads the current state of the lights that
+// thenAction and elseAction use and passes that state to predicate. If
+// predicate returns true, the returned HueAction runs thenAction; otherwise
+// it runs elseAction. elseAction may be nil meaning do nothing.
+// The state passed to predicate is empty when the lights used are all
+// lights. The returned HueAction needs a Context that implements
+// LightReader and reports an *UnsupportedError without one.
+func If(predicate func(colors LightColors) bool,
+	thenAction, elseAction HueAction) HueAction {
+	return &ifAction{
+		predicate:  predicate,
+		thenAction: thenAction,
+		elseAction: elseAction}
+}
+
+// AllOff returns true if all the lights in colors are off. AllOff can be
+// used as a predicate for If.
+func AllOff(colors LightColors) bool {
+	for _, colorBrightness := range colors {
+		if colorBrightness.Brightness.Valid {
+			return false
+		}
+	}
+	return true
+}
+
+type ifAction struct {
+	predicate  func(colors LightColors) bool
+	thenAction HueAction
+	elseAction HueAction
+}
+
+func (a *ifAction) Do(
+	ctxt Context, lightSet lights.Set, e *tasks.Execution) {
+	reader, ok := ctxt.(LightReader)
+	if !ok {
+		e.SetError(&UnsupportedError{Interface: "LightReader"})
+		return
+	}
+	colors, err := Snapshot(reader, a.UsedLights(lightSet))
+	if err != nil {
+		e.SetError(err)
+		return
+	}
+	action := a.elseAction
+	if a.predicate(colors) {
+		action = a.thenAction
+	}
+	if action != nil {
+		action.Do(ctxt, action.UsedLights(lightSet), e)
+	}
+}
+
+func (a *ifAction) UsedLights(lightSet lights.Set) lights.Set {
+	if a.elseAction == nil {
+		return a.thenAction.UsedLights(lightSet)
+	}
+	return a.thenAction.UsedLights(lightSet).Add(
+		a.elseAction.UsedLights(lightSet))
+}
+
+// Until returns a HueAction that runs action and then keeps control of
+// the lights until endTime. When endTime arrives, the returned HueAction
+// turns the lights off or, if restore is true, puts the lights back to
+// the state they were in before action ran. Restoring needs a Context
+// that implements LightReader and particular lights to snapshot; if
+// restore is true and either is missing, the returned HueAction reports
+// an *UnsupportedError without running action. If interrupted before
+// endTime, the returned HueAction leaves the lights alone.
+func Until(action HueAction, endTime time.Time, restore bool) HueAction {
+	return &untilAction{action: action, endTime: endTime, restore: restore}
+}
+
+type untilAction struct {
+	action  HueAction
+	endTime time.Time
+	restore bool
+}
+
+func (a *untilAction) Do(
+	ctxt Context, lightSet lights.Set, e *tasks.Execution) {
+	var states LightStates
+	if a.restore {
+		reader, ok := ctxt.(LightReader)
+		if !ok || lightSet.IsAll() {
+			e.SetError(&UnsupportedError{Interface: "LightReader"})
+			return
+		}
+		var err error
+		if states, err = SnapshotStates(reader, lightSet); err != nil {
+			e.SetError(err)
+			return
+		}
+	}
+	a.action.Do(ctxt, lightSet, e)
+	if e.Error() != nil || e.IsEnded() {
+		return
+	}
+	if d := a.endTime.Sub(e.Now()); d > 0 && !e.Sleep(d) {
+		return
+	}
+	if states != nil {
+		if err := RestoreStates(ctxt, states); err != nil {
+			e.SetError(err)
+		}
+		return
+	}
+	StaticHueAction{0: {}}.Do(ctxt, lightSet, e)
+}
+
+func (a *untilAction) UsedLights(lightSet lights.Set) lights.Set {
+	return a.action.UsedLights(lightSet)
+}
+
+// runChild runs f in a new execution that is a child of parent and
+// returns the error that f reports. The child execution ends when parent
+// ends, and sleeping in the child execution honors pausing of parent.
+// runChild must be called from a goroutine running parent, and it blocks
+// that goroutine until f returns.
+func runChild(parent *tasks.Execution, f func(child *tasks.Execution)) error {
+	me := tasks.NewMultiExecutorWithClock(&childTasks{}, childClock{parent})
+	defer me.Close()
+	child := me.Start(tasks.TaskFunc(f))
+	select {
+	case <-child.Done():
+	case <-parent.Ended():
+		child.End()
+		<-child.Done()
+	}
+	return child.Error()
+}
+
+// childTasks is the TaskCollection of the executor that runChild creates.
+// That executor runs only one task, so no tasks conflict.
+type childTasks struct {
+	mu sync.Mutex
+	e  *tasks.Execution
+}
+
+func (c *childTasks) Add(t tasks.Task, e *tasks.Execution) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.e = e
+}
+
+func (c *childTasks) Remove(t tasks.Task) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.e = nil
+}
+
+func (c *childTasks) Conflicts(t tasks.Task) []*tasks.Execution {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t != nil || c.e == nil {
+		return nil
+	}
+	return []*tasks.Execution{c.e}
+}
+
+// childClock sleeps through its parent execution so that child executions
+// pause whenever their parent pauses. A child sleeping through its parent
+// stands in for the goroutine of parent that waits in runChild.
+type childClock struct {
+	parent *tasks.Execution
+}
+
+func (c childClock) Now() time.Time {
+	return c.parent.Now()
+}
+
+func (c childClock) After(d time.Duration) <-chan time.Time {
+	result := make(chan time.Time, 1)
+	// If parent ends first, never fire so that the child sees that it
+	// ended rather than that its sleep finished.
+	if c.parent.Sleep(d) {
+		result <- c.parent.Now()
+	}
+	return result
+}
+
+// mergeErrors returns nil if errs contains no errors, the error if errs
+// contains exactly one, or a SetErrors instance if errs contains more than
+// one.
+func mergeErrors(errs []error) error {
+	var result SetErrors
+	for _, err := range errs {
+		if err != nil {
+			result = append(result, err)
+		}
+	}
+	switch len(result) {
+	case 0:
+		return nil
+	case 1:
+		return result[0]
+	default:
+		return result
+	}
+}
@@ -0,0 +1,443 @@
+package ops_test
+
+import (
+	"errors"
+	"github.com/keep94/gohue"
+	"github.com/keep94/gohue/actions"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/maybe"
+	"github.com/keep94/tasks"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParallel(t *testing.T) {
+	someBrightness := maybe.NewUint8(128)
+	a := ops.Parallel(
+		ops.StaticHueAction{
+			1: {gohue.NewMaybeColor(gohue.Red), someBrightness},
+			2: {gohue.NewMaybeColor(gohue.Red), someBrightness}},
+		ops.StaticHueAction{
+			4: {gohue.NewMaybeColor(gohue.Blue), someBrightness}})
+	ctxt := &syncContextForTesting{c: make(contextForTesting)}
+	err := tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		a.Do(ctxt, lights.All, e)
+	}))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := contextForTesting{
+		1: {
+			C:   gohue.NewMaybeColor(gohue.Red),
+			Bri: someBrightness,
+			On:  maybe.NewBool(true),
+		},
+		2: {
+			C:   gohue.NewMaybeColor(gohue.Red),
+			Bri: someBrightness,
+			On:  maybe.NewBool(true),
+		},
+		4: {
+			C:   gohue.NewMaybeColor(gohue.Blue),
+			Bri: someBrightness,
+			On:  maybe.NewBool(true),
+		},
+	}
+	if !reflect.DeepEqual(expected, ctxt.c) {
+		t.Errorf("Expected %v, got %v", expected, ctxt.c)
+	}
+}
+
+func TestParallelUsedLights(t *testing.T) {
+	a := ops.Parallel(
+		ops.StaticHueAction{1: {}, 2: {}},
+		ops.StaticHueAction{4: {}})
+	if out := a.UsedLights(lights.All); !reflect.DeepEqual(
+		lights.New(1, 2, 4), out) {
+		t.Errorf("Expected {1, 2, 4}, got %v", out)
+	}
+	b := ops.Parallel(ops.StaticHueAction{1: {}}, ops.StaticHueAction{0: {}})
+	if out := b.UsedLights(lights.All); !out.IsAll() {
+		t.Errorf("Expected all lights, got %v", out)
+	}
+}
+
+func TestParallelErrors(t *testing.T) {
+	firstError := errors.New("first")
+	secondError := errors.New("second")
+	a := ops.Parallel(
+		errorAction{lightSet: lights.New(1), err: firstError},
+		errorAction{lightSet: lights.New(2), err: secondError},
+		errorAction{lightSet: lights.New(3)})
+	err := tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		a.Do(make(contextForTesting), lights.All, e)
+	}))
+	expected := ops.SetErrors{firstError, secondError}
+	if !reflect.DeepEqual(expected, err) {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+	a = ops.Parallel(
+		errorAction{lightSet: lights.New(1), err: firstError},
+		errorAction{lightSet: lights.New(2)})
+	err = tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		a.Do(make(contextForTesting), lights.All, e)
+	}))
+	if err != firstError {
+		t.Errorf("Expected %v, got %v", firstError, err)
+	}
+}
+
+func TestParallelEnd(t *testing.T) {
+	a := ops.Parallel(
+		sleepAction{lightSet: lights.New(1), d: time.Hour},
+		sleepAction{lightSet: lights.New(2), d: time.Hour})
+	e := tasks.Start(tasks.TaskFunc(func(e *tasks.Execution) {
+		a.Do(make(contextForTesting), lights.All, e)
+	}))
+	e.End()
+	select {
+	case <-e.Done():
+	case <-time.After(time.Second):
+		t.Error("Expected Parallel to finish when execution ends.")
+	}
+}
+
+func TestParallelPause(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.Local)
+	clock := tasks.NewFakeClock(start)
+	ctxt := &syncContextForTesting{c: make(contextForTesting)}
+	a := ops.Parallel(
+		sleepAction{lightSet: lights.New(1), d: time.Minute},
+		sleepAction{lightSet: lights.New(2), d: time.Minute})
+	executor := tasks.NewMultiExecutorWithClock(
+		&taskCollectionForTesting{}, clock)
+	defer executor.Close()
+	e := executor.Start(tasks.TaskFunc(func(e *tasks.Execution) {
+		a.Do(ctxt, lights.All, e)
+	}))
+	executor.Pause()
+	clock.Advance(time.Hour)
+	time.Sleep(50 * time.Millisecond)
+	ctxt.mutex.Lock()
+	lightsSet := len(ctxt.c)
+	ctxt.mutex.Unlock()
+	if lightsSet != 0 {
+		t.Errorf("Expected no lights set while paused, got %d", lightsSet)
+	}
+	executor.Resume()
+	for !isDone(e) {
+		if clock.Now().Sub(start) > 100*time.Hour {
+			t.Fatal("Expected Parallel to finish after resume.")
+		}
+		clock.Advance(time.Hour)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if out := len(ctxt.c); out != 2 {
+		t.Errorf("Expected 2 lights set, got %d", out)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	someBrightness := maybe.NewUint8(128)
+	a := ops.Sequence(
+		ops.StaticHueAction{
+			0: {gohue.NewMaybeColor(gohue.Red), someBrightness}},
+		ops.StaticHueAction{
+			4: {gohue.NewMaybeColor(gohue.Blue), someBrightness}})
+	ctxt := make(contextForTesting)
+	if err := runActionOn(a, ctxt, lights.New(2, 4)); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := contextForTesting{
+		2: {
+			C:   gohue.NewMaybeColor(gohue.Red),
+			Bri: someBrightness,
+			On:  maybe.NewBool(true),
+		},
+		4: {
+			C:   gohue.NewMaybeColor(gohue.Blue),
+			Bri: someBrightness,
+			On:  maybe.NewBool(true),
+		},
+	}
+	if !reflect.DeepEqual(expected, ctxt) {
+		t.Errorf("Expected %v, got %v", expected, ctxt)
+	}
+	if out := a.UsedLights(lights.New(2, 4, 5)); !reflect.DeepEqual(
+		lights.New(2, 4, 5), out) {
+		t.Errorf("Expected {2, 4, 5}, got %v", out)
+	}
+}
+
+func TestSequenceError(t *testing.T) {
+	firstError := errors.New("first")
+	ctxt := make(contextForTesting)
+	a := ops.Sequence(
+		errorAction{lightSet: lights.New(1), err: firstError},
+		ops.StaticHueAction{2: {Brightness: maybe.NewUint8(3)}})
+	if err := runAction(a, ctxt); err != firstError {
+		t.Errorf("Expected %v, got %v", firstError, err)
+	}
+	if len(ctxt) != 0 {
+		t.Errorf("Expected no lights set, got %v", ctxt)
+	}
+}
+
+func TestSequenceEnd(t *testing.T) {
+	ctxt := &syncContextForTesting{c: make(contextForTesting)}
+	a := ops.Sequence(
+		sleepAction{lightSet: lights.New(1), d: time.Hour},
+		ops.StaticHueAction{2: {Brightness: maybe.NewUint8(3)}})
+	e := tasks.Start(tasks.TaskFunc(func(e *tasks.Execution) {
+		a.Do(ctxt, lights.All, e)
+	}))
+	e.End()
+	<-e.Done()
+	if len(ctxt.c) != 0 {
+		t.Errorf("Expected no lights set, got %v", ctxt.c)
+	}
+}
+
+func TestIf(t *testing.T) {
+	turnOn := ops.StaticHueAction{
+		3: {gohue.NewMaybeColor(gohue.White), maybe.NewUint8(255)}}
+	a := ops.If(ops.AllOff, turnOn, nil)
+	ctxt := readWriteContextForTesting{contextForTesting{
+		3: {On: maybe.NewBool(false)}}}
+	if err := runAction(a, ctxt); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if out := ctxt.contextForTesting[3].Bri; out != maybe.NewUint8(255) {
+		t.Errorf("Expected light 3 turned on, got %v", out)
+	}
+	dim := ops.StaticHueAction{
+		3: {gohue.NewMaybeColor(gohue.White), maybe.NewUint8(10)}}
+	a = ops.If(ops.AllOff, dim, nil)
+	if err := runAction(a, ctxt); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if out := ctxt.contextForTesting[3].Bri; out != maybe.NewUint8(255) {
+		t.Errorf("Expected light 3 unchanged, got %v", out)
+	}
+	a = ops.If(ops.AllOff, turnOn, dim)
+	if err := runAction(a, ctxt); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if out := ctxt.contextForTesting[3].Bri; out != maybe.NewUint8(10) {
+		t.Errorf("Expected light 3 dimmed, got %v", out)
+	}
+	if out := a.UsedLights(lights.All); !reflect.DeepEqual(
+		lights.New(3), out) {
+		t.Errorf("Expected {3}, got %v", out)
+	}
+}
+
+func TestIfNoReader(t *testing.T) {
+	a := ops.If(
+		func(colors ops.LightColors) bool { return true },
+		ops.StaticHueAction{3: {}},
+		nil)
+	ctxt := make(contextForTesting)
+	err := runAction(a, ctxt)
+	if unsupported, ok := err.(*ops.UnsupportedError); !ok ||
+		unsupported.Interface != "LightReader" {
+		t.Errorf("Expected UnsupportedError, got %v", err)
+	}
+	if len(ctxt) != 0 {
+		t.Errorf("Expected nothing set, got %v", ctxt)
+	}
+}
+
+func TestIfError(t *testing.T) {
+	a := ops.If(ops.AllOff, ops.StaticHueAction{3: {}}, nil)
+	ctxt := readWriteContextForTesting{make(contextForTesting)}
+	err := runAction(a, ctxt)
+	if _, ok := err.(*actions.NoSuchLightIdError); !ok {
+		t.Errorf("Expected NoSuchLightIdError, got %v", err)
+	}
+}
+
+func TestUntil(t *testing.T) {
+	start := time.Date(2020, 6, 1, 22, 0, 0, 0, time.Local)
+	turnOn := ops.StaticHueAction{
+		3: {gohue.NewMaybeColor(gohue.White), maybe.NewUint8(255)}}
+	ctxt := make(contextForTesting)
+	clock := &tasks.ClockForTesting{Current: start}
+	a := ops.Until(turnOn, start.Add(time.Hour), false)
+	err := tasks.RunForTesting(tasks.TaskFunc(func(e *tasks.Execution) {
+		a.Do(ctxt, a.UsedLights(lights.All), e)
+	}), clock)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if out := clock.Current; out != start.Add(time.Hour) {
+		t.Errorf("Expected to end at %v, got %v", start.Add(time.Hour), out)
+	}
+	expected := &gohue.LightProperties{On: maybe.NewBool(false)}
+	if out := ctxt[3]; !reflect.DeepEqual(expected, out) {
+		t.Errorf("Expected %v, got %v", expected, out)
+	}
+}
+
+func TestUntilRestore(t *testing.T) {
+	start := time.Date(2020, 6, 1, 22, 0, 0, 0, time.Local)
+	turnOn := ops.StaticHueAction{
+		3: {gohue.NewMaybeColor(gohue.White), maybe.NewUint8(255)}}
+	ctxt := readWriteContextForTesting{contextForTesting{
+		3: {
+			C:   gohue.NewMaybeColor(gohue.Red),
+			Bri: maybe.NewUint8(10),
+			On:  maybe.NewBool(true),
+		},
+	}}
+	clock := &tasks.ClockForTesting{Current: start}
+	a := ops.Until(turnOn, start.Add(time.Hour), true)
+	err := tasks.RunForTesting(tasks.TaskFunc(func(e *tasks.Execution) {
+		a.Do(ctxt, a.UsedLights(lights.All), e)
+	}), clock)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := &gohue.LightProperties{
+		C:              gohue.NewMaybeColor(gohue.Red),
+		Bri:            maybe.NewUint8(10),
+		On:             maybe.NewBool(true),
+		TransitionTime: maybe.NewUint16(4),
+	}
+	if out := ctxt.contextForTesting[3]; !reflect.DeepEqual(expected, out) {
+		t.Errorf("Expected %v, got %v", expected, out)
+	}
+}
+
+func TestUntilRestoreUnsupported(t *testing.T) {
+	turnOn := ops.StaticHueAction{
+		3: {gohue.NewMaybeColor(gohue.White), maybe.NewUint8(255)}}
+	ctxt := make(contextForTesting)
+	err := runAction(ops.Until(turnOn, time.Now().Add(time.Hour), true), ctxt)
+	if uerr, ok := err.(*ops.UnsupportedError); !ok ||
+		uerr.Interface != "LightReader" {
+		t.Errorf("Expected UnsupportedError for LightReader, got %v", err)
+	}
+	if len(ctxt) != 0 {
+		t.Errorf("Expected action not to run, got %v", ctxt)
+	}
+	turnAllOn := ops.StaticHueAction{
+		0: {gohue.NewMaybeColor(gohue.White), maybe.NewUint8(255)}}
+	readWriteCtxt := readWriteContextForTesting{make(contextForTesting)}
+	err = runAction(
+		ops.Until(turnAllOn, time.Now().Add(time.Hour), true), readWriteCtxt)
+	if uerr, ok := err.(*ops.UnsupportedError); !ok ||
+		uerr.Interface != "LightReader" {
+		t.Errorf("Expected UnsupportedError for LightReader, got %v", err)
+	}
+	if len(readWriteCtxt.contextForTesting) != 0 {
+		t.Errorf(
+			"Expected action not to run, got %v",
+			readWriteCtxt.contextForTesting)
+	}
+}
+
+func TestUntilInterrupted(t *testing.T) {
+	turnOn := ops.StaticHueAction{
+		3: {gohue.NewMaybeColor(gohue.White), maybe.NewUint8(255)}}
+	ctxt := &syncContextForTesting{c: make(contextForTesting)}
+	a := ops.Until(turnOn, time.Now().Add(time.Hour), false)
+	e := tasks.Start(tasks.TaskFunc(func(e *tasks.Execution) {
+		a.Do(ctxt, a.UsedLights(lights.All), e)
+	}))
+	e.End()
+	<-e.Done()
+	ctxt.mutex.Lock()
+	defer ctxt.mutex.Unlock()
+	if out := ctxt.c[3]; out != nil && !out.On.Value {
+		t.Errorf("Expected light not turned off, got %v", out)
+	}
+}
+
+func runAction(a ops.HueAction, ctxt ops.Context) error {
+	return runActionOn(a, ctxt, lights.All)
+}
+
+func runActionOn(
+	a ops.HueAction, ctxt ops.Context, lightSet lights.Set) error {
+	return tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		a.Do(ctxt, a.UsedLights(lightSet), e)
+	}))
+}
+
+func isDone(e *tasks.Execution) bool {
+	select {
+	case <-e.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+type errorAction struct {
+	lightSet lights.Set
+	err      error
+}
+
+func (a errorAction) Do(
+	ctxt ops.Context, lightSet lights.Set, e *tasks.Execution) {
+	if a.err != nil {
+		e.SetError(a.err)
+	}
+}
+
+func (a errorAction) UsedLights(lightSet lights.Set) lights.Set {
+	return a.lightSet
+}
+
+// sleepAction sleeps for d and then turns its lights on.
+type sleepAction struct {
+	lightSet lights.Set
+	d        time.Duration
+}
+
+func (a sleepAction) Do(
+	ctxt ops.Context, lightSet lights.Set, e *tasks.Execution) {
+	if !e.Sleep(a.d) {
+		return
+	}
+	for id := range lightSet {
+		ctxt.Set(id, &gohue.LightProperties{On: maybe.NewBool(true)})
+	}
+}
+
+func (a sleepAction) UsedLights(lightSet lights.Set) lights.Set {
+	return a.lightSet
+}
+
+type readWriteContextForTesting struct {
+	contextForTesting
+}
+
+func (c readWriteContextForTesting) Get(lightId int) (
+	*gohue.LightProperties, []byte, error) {
+	properties, ok := c.contextForTesting[lightId]
+	if !ok {
+		return nil, nil, gohue.NoSuchResourceError
+	}
+	return properties, nil, nil
+}
+
+// taskCollectionForTesting reports every task it has seen as conflicting.
+type taskCollectionForTesting struct {
+	executions []*tasks.Execution
+}
+
+func (c *taskCollectionForTesting) Add(t tasks.Task, e *tasks.Execution) {
+	c.executions = append(c.executions, e)
+}
+
+func (c *taskCollectionForTesting) Remove(t tasks.Task) {
+}
+
+func (c *taskCollectionForTesting) Conflicts(
+	t tasks.Task) []*tasks.Execution {
+	return c.executions
+}
@@ -0,0 +1,48 @@
+package ops
+
+import (
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/maybe"
+	"github.com/keep94/tasks"
+	"time"
+)
+
+// CycleAction changes the lights to each color in Colors in turn waiting
+// Step between colors. After the last color, CycleAction starts over
+// with the first. CycleAction runs until its task is ended or until
+// setting the lights fails.
+// These instances must be treated as immutable.
+type CycleAction struct {
+	// The colors in the order they are shown
+	Colors []gohue.Color
+
+	// The brightness of the lights
+	Brightness uint8
+
+	// How long each color is shown
+	Step time.Duration
+}
+
+func (a CycleAction) Do(
+	ctxt Context, lightSet lights.Set, e *tasks.Execution) {
+	if len(a.Colors) == 0 {
+		return
+	}
+	for i := 0; ; i = (i + 1) % len(a.Colors) {
+		action := StaticHueAction{
+			0: {
+				Color:      gohue.NewMaybeColor(a.Colors[i]),
+				Brightness: maybe.NewUint8(a.Brightness),
+			},
+		}
+		action.Do(ctxt, lightSet, e)
+		if e.Error() != nil || !e.Sleep(a.Step) {
+			return
+		}
+	}
+}
+
+func (a CycleAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
@@ -0,0 +1,84 @@
+package ops_test
+
+import (
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/tasks"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCycleAction(t *testing.T) {
+	ctxt := &historyContextForTesting{limit: 5}
+	clock := &tasks.ClockForTesting{Current: time.Date(
+		2020, 6, 1, 0, 0, 0, 0, time.Local)}
+	action := ops.CycleAction{
+		Colors:     []gohue.Color{gohue.Red, gohue.Green, gohue.Blue},
+		Brightness: 200,
+		Step:       3 * time.Second,
+	}
+	err := tasks.RunForTesting(tasks.TaskFunc(func(e *tasks.Execution) {
+		ctxt.end = e.End
+		action.Do(ctxt, lights.New(4), e)
+	}), clock)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := []gohue.Color{
+		gohue.Red, gohue.Green, gohue.Blue, gohue.Red, gohue.Green}
+	if !reflect.DeepEqual(expected, ctxt.colors) {
+		t.Errorf("Expected %v, got %v", expected, ctxt.colors)
+	}
+	if out := clock.Current.Sub(time.Date(
+		2020, 6, 1, 0, 0, 0, 0, time.Local)); out != 15*time.Second {
+		t.Errorf("Expected 15s, got %v", out)
+	}
+}
+
+func TestCycleActionError(t *testing.T) {
+	ctxt := &historyContextForTesting{limit: 1, err: kNetworkError}
+	action := ops.CycleAction{
+		Colors: []gohue.Color{gohue.Red, gohue.Green},
+		Step:   time.Hour,
+	}
+	err := tasks.RunForTesting(tasks.TaskFunc(func(e *tasks.Execution) {
+		action.Do(ctxt, lights.New(4), e)
+	}), &tasks.ClockForTesting{})
+	if err == nil {
+		t.Error("Expected error")
+	}
+	if len(ctxt.colors) != 1 {
+		t.Errorf("Expected 1 color, got %v", ctxt.colors)
+	}
+}
+
+func TestCycleActionNoColors(t *testing.T) {
+	ctxt := &historyContextForTesting{}
+	if err := runAction(ops.CycleAction{Step: time.Hour}, ctxt); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if len(ctxt.colors) != 0 {
+		t.Errorf("Expected no colors, got %v", ctxt.colors)
+	}
+}
+
+// historyContextForTesting records the color of each Set call. Once it
+// records limit colors it calls end so that long running actions stop.
+type historyContextForTesting struct {
+	colors []gohue.Color
+	limit  int
+	end    func()
+	err    error
+}
+
+func (c *historyContextForTesting) Set(
+	lightId int,
+	properties *gohue.LightProperties) (response []byte, err error) {
+	c.colors = append(c.colors, properties.C.Color)
+	if len(c.colors) == c.limit && c.end != nil {
+		c.end()
+	}
+	return nil, c.err
+}
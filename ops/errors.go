@@ -0,0 +1,115 @@
+package ops
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Error types that the hue bridge reports. Use errors.Is to test whether
+// an error is one of these e.g errors.Is(err, ops.ErrDeviceOff).
+var (
+	// The user name is not authorized on the bridge
+	ErrUnauthorized = &BridgeError{Type: 1}
+
+	// The bridge could not parse the request body
+	ErrInvalidJSON = &BridgeError{Type: 2}
+
+	// The light or other resource is not available
+	ErrResourceNotAvailable = &BridgeError{Type: 3}
+
+	// A parameter in the request is not available
+	ErrParameterNotAvailable = &BridgeError{Type: 6}
+
+	// A parameter in the request has an invalid value
+	ErrInvalidValue = &BridgeError{Type: 7}
+
+	// A parameter in the request cannot be modified
+	ErrParameterNotModifiable = &BridgeError{Type: 8}
+
+	// The light is off so the parameter cannot be modified
+	ErrDeviceOff = &BridgeError{Type: 201}
+
+	// The bridge had an internal error
+	ErrInternal = &BridgeError{Type: 901}
+)
+
+// BridgeError reports an error response from the hue bridge.
+type BridgeError struct {
+
+	// The light Id. 0 means all lights or that no particular light was
+	// involved.
+	LightId int
+
+	// The type of error the bridge reports. 0 means the bridge response
+	// could not be parsed.
+	Type int
+
+	// The address of the resource in error e.g "/lights/3/state/bri"
+	Address string
+
+	// The description of the error the bridge reports
+	Description string
+
+	// The raw response received from the hue bridge
+	RawResponse []byte
+
+	// The original error from gohue.Get() or gohue.Set()
+	Err error
+}
+
+// Error returns the raw response from the bridge.
+func (e *BridgeError) Error() string {
+	return string(e.RawResponse)
+}
+
+// Unwrap returns the original error from gohue.Get() or gohue.Set().
+func (e *BridgeError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is a *BridgeError with the same non-zero
+// Type as this instance.
+func (e *BridgeError) Is(target error) bool {
+	t, ok := target.(*BridgeError)
+	return ok && t.Type != 0 && t.Type == e.Type
+}
+
+type jsonBridgeError struct {
+	Type        int
+	Address     string
+	Description string
+}
+
+type jsonBridgeResponse struct {
+	Error *jsonBridgeError
+}
+
+func newBridgeError(lightId int, rawResponse []byte, err error) *BridgeError {
+	result := &BridgeError{
+		LightId: lightId, RawResponse: rawResponse, Err: err}
+	var responses []jsonBridgeResponse
+	if json.Unmarshal(rawResponse, &responses) != nil {
+		return result
+	}
+	for _, response := range responses {
+		if response.Error != nil {
+			result.Type = response.Error.Type
+			result.Address = response.Error.Address
+			result.Description = response.Error.Description
+			break
+		}
+	}
+	return result
+}
+
+// UnsupportedError is reported when a HueAction runs with a Context that
+// does not implement an interface that the HueAction needs.
+type UnsupportedError struct {
+
+	// The name of the interface the Context lacks e.g "LightReader"
+	Interface string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("ops: Context does not implement %s.", e.Interface)
+}
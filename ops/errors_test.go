@@ -0,0 +1,69 @@
+package ops_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/keep94/gohue"
+	"github.com/keep94/gohue/actions"
+	"github.com/keep94/marvin2/ops"
+	"testing"
+)
+
+func TestFixErrorBridgeError(t *testing.T) {
+	raw := []byte(`[{"error":{"type":201,"address":"/lights/3/state/bri","description":"parameter, bri, is not modifiable. Device is set to off."}}]`)
+	err := ops.FixError(3, raw, gohue.GeneralError)
+	var bridgeErr *ops.BridgeError
+	if !errors.As(err, &bridgeErr) {
+		t.Fatalf("Expected BridgeError, got %v", err)
+	}
+	if bridgeErr.LightId != 3 || bridgeErr.Type != 201 {
+		t.Errorf("Expected light 3 type 201, got %d %d",
+			bridgeErr.LightId, bridgeErr.Type)
+	}
+	if bridgeErr.Address != "/lights/3/state/bri" {
+		t.Errorf("Got address %s", bridgeErr.Address)
+	}
+	if bridgeErr.Description != "parameter, bri, is not modifiable. Device is set to off." {
+		t.Errorf("Got description %s", bridgeErr.Description)
+	}
+	if err.Error() != string(raw) {
+		t.Errorf("Expected raw response as message, got %s", err.Error())
+	}
+	wrapped := fmt.Errorf("Setting light: %w", err)
+	if !errors.Is(wrapped, ops.ErrDeviceOff) {
+		t.Error("Expected ErrDeviceOff")
+	}
+	if errors.Is(wrapped, ops.ErrInvalidValue) {
+		t.Error("Did not expect ErrInvalidValue")
+	}
+	if !errors.Is(wrapped, gohue.GeneralError) {
+		t.Error("Expected gohue.GeneralError")
+	}
+}
+
+func TestFixErrorUnparsable(t *testing.T) {
+	err := ops.FixError(2, []byte("garbage"), gohue.GeneralError)
+	var bridgeErr *ops.BridgeError
+	if !errors.As(err, &bridgeErr) {
+		t.Fatalf("Expected BridgeError, got %v", err)
+	}
+	if bridgeErr.Type != 0 {
+		t.Errorf("Expected type 0, got %d", bridgeErr.Type)
+	}
+	if err.Error() != "garbage" {
+		t.Errorf("Expected garbage, got %s", err.Error())
+	}
+	if errors.Is(err, &ops.BridgeError{}) {
+		t.Error("Zero type should match nothing")
+	}
+}
+
+func TestFixErrorOthers(t *testing.T) {
+	err := ops.FixError(4, []byte("no light"), gohue.NoSuchResourceError)
+	if _, ok := err.(*actions.NoSuchLightIdError); !ok {
+		t.Errorf("Expected NoSuchLightIdError, got %v", err)
+	}
+	if err := ops.FixError(4, nil, kNetworkError); err != kNetworkError {
+		t.Errorf("Expected network error, got %v", err)
+	}
+}
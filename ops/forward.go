@@ -0,0 +1,54 @@
+package ops
+
+import (
+	"errors"
+)
+
+// The Context decorators in this package such as the ones that
+// NewObservedContext and NewRetryContext return implement every optional
+// Context interface so that decorating a Context never hides what it
+// can do. When the decorated Context lacks an optional interface, the
+// corresponding methods of the decorator report an *UnsupportedError.
+// SnapshotStates, RestoreStates, and Scenes treat such an error the same
+// way they treat a Context that lacks the interface.
+
+func asLightReader(ctxt Context) (LightReader, error) {
+	if reader, ok := ctxt.(LightReader); ok {
+		return reader, nil
+	}
+	return nil, &UnsupportedError{Interface: "LightReader"}
+}
+
+func asLightStateReader(ctxt Context) (LightStateReader, error) {
+	if reader, ok := ctxt.(LightStateReader); ok {
+		return reader, nil
+	}
+	return nil, &UnsupportedError{Interface: "LightStateReader"}
+}
+
+func asLightStateWriter(ctxt Context) (LightStateWriter, error) {
+	if writer, ok := ctxt.(LightStateWriter); ok {
+		return writer, nil
+	}
+	return nil, &UnsupportedError{Interface: "LightStateWriter"}
+}
+
+func asSceneContext(ctxt Context) (SceneContext, error) {
+	if sceneCtxt, ok := ctxt.(SceneContext); ok {
+		return sceneCtxt, nil
+	}
+	return nil, &UnsupportedError{Interface: "SceneContext"}
+}
+
+func asAlertContext(ctxt Context) (AlertContext, error) {
+	if alerter, ok := ctxt.(AlertContext); ok {
+		return alerter, nil
+	}
+	return nil, &UnsupportedError{Interface: "AlertContext"}
+}
+
+// isUnsupported returns true if err is an *UnsupportedError.
+func isUnsupported(err error) bool {
+	var unsupported *UnsupportedError
+	return errors.As(err, &unsupported)
+}
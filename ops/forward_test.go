@@ -0,0 +1,130 @@
+package ops_test
+
+import (
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/maybe"
+	"github.com/keep94/tasks"
+	"reflect"
+	"testing"
+)
+
+// assertForwards asserts that the Context decorator decorate forwards
+// every optional Context interface.
+func assertForwards(t *testing.T, decorate func(ops.Context) ops.Context) {
+	t.Helper()
+	state := ops.LightState{
+		On:         true,
+		Brightness: maybe.NewUint8(200),
+		Ct:         maybe.NewUint16(366),
+		ColorMode:  ops.ColorModeCT,
+	}
+	reader, ok := decorate(stateContext{2: state}).(ops.LightReader)
+	if !ok {
+		t.Fatal("Expected LightReader.")
+	}
+	states, err := ops.SnapshotStates(reader, lights.New(2))
+	if err != nil {
+		t.Fatalf("Got error snapshotting states: %v", err)
+	}
+	if expected := (ops.LightStates{2: state}); !reflect.DeepEqual(
+		expected, states) {
+		t.Errorf("Expected %v, got %v", expected, states)
+	}
+	restored := make(stateContext)
+	if err := ops.RestoreStates(decorate(restored), states); err != nil {
+		t.Fatalf("Got error restoring states: %v", err)
+	}
+	state.TransitionTime = maybe.NewUint16(4)
+	if expected := (stateContext{2: state}); !reflect.DeepEqual(
+		expected, restored) {
+		t.Errorf("Expected %v, got %v", expected, restored)
+	}
+
+	// Without LightStateReader and LightStateWriter, fall back to Get
+	// and Set.
+	plain := readWriteContextForTesting{contextForTesting{
+		5: {
+			C:   gohue.NewMaybeColor(gohue.Blue),
+			Bri: maybe.NewUint8(50),
+			On:  maybe.NewBool(true),
+		},
+	}}
+	states, err = ops.SnapshotStates(
+		decorate(plain).(ops.LightReader), lights.New(5))
+	if err != nil {
+		t.Fatalf("Got error snapshotting states: %v", err)
+	}
+	if err := ops.RestoreStates(decorate(plain), states); err != nil {
+		t.Fatalf("Got error restoring states: %v", err)
+	}
+	expectedProperties := &gohue.LightProperties{
+		C:              gohue.NewMaybeColor(gohue.Blue),
+		Bri:            maybe.NewUint8(50),
+		On:             maybe.NewBool(true),
+		TransitionTime: maybe.NewUint16(4),
+	}
+	if out := plain.contextForTesting[5]; !reflect.DeepEqual(
+		expectedProperties, out) {
+		t.Errorf("Expected %v, got %v", expectedProperties, out)
+	}
+
+	sceneCtxt := &sceneContextForTesting{
+		contextForTesting: make(contextForTesting),
+		scenes:            ops.SceneList{{Id: "1", Name: "Bright"}},
+	}
+	scenes, err := ops.Scenes(decorate(sceneCtxt))
+	if err != nil {
+		t.Fatalf("Got error listing scenes: %v", err)
+	}
+	if !reflect.DeepEqual(sceneCtxt.scenes, scenes) {
+		t.Errorf("Expected %v, got %v", sceneCtxt.scenes, scenes)
+	}
+	if err := runAction(
+		ops.RecallSceneAction{Scene: scenes[0]},
+		decorate(sceneCtxt)); err != nil {
+		t.Errorf("Got error recalling scene: %v", err)
+	}
+	if expected := []string{"1"}; !reflect.DeepEqual(
+		expected, sceneCtxt.recalled) {
+		t.Errorf("Expected %v, got %v", expected, sceneCtxt.recalled)
+	}
+	scenes, err = ops.Scenes(decorate(make(contextForTesting)))
+	if err != nil || len(scenes) != 0 {
+		t.Errorf("Expected no scenes, got %v, %v", scenes, err)
+	}
+	err = runAction(
+		ops.RecallSceneAction{Scene: &ops.Scene{Id: "1"}},
+		decorate(make(contextForTesting)))
+	assertUnsupported(t, "SceneContext", err)
+
+	alertCtxt := &alertContextForTesting{
+		readWriteContextForTesting: readWriteContextForTesting{
+			contextForTesting{2: {On: maybe.NewBool(false)}}},
+	}
+	err = tasks.RunForTesting(tasks.TaskFunc(func(e *tasks.Execution) {
+		ops.AlertAction{}.Do(decorate(alertCtxt), lights.New(2), e)
+	}), &tasks.ClockForTesting{})
+	if err != nil {
+		t.Errorf("Got error alerting: %v", err)
+	}
+	if expected := []string{"2:select", "2:none"}; !reflect.DeepEqual(
+		expected, alertCtxt.alerts) {
+		t.Errorf("Expected %v, got %v", expected, alertCtxt.alerts)
+	}
+	alerter, ok := decorate(make(contextForTesting)).(ops.AlertContext)
+	if !ok {
+		t.Fatal("Expected AlertContext.")
+	}
+	_, err = alerter.Alert(1, ops.AlertNone)
+	assertUnsupported(t, "AlertContext", err)
+}
+
+func assertUnsupported(t *testing.T, expected string, err error) {
+	t.Helper()
+	if unsupported, ok := err.(*ops.UnsupportedError); !ok ||
+		unsupported.Interface != expected {
+		t.Errorf("Expected UnsupportedError for %s, got %v", expected, err)
+	}
+}
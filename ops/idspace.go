@@ -0,0 +1,137 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// StaticIdRange names the range of ids of hard-coded hue tasks.
+	StaticIdRange = "static"
+
+	// PersistentIdRange names the range of ids of hue tasks from
+	// persistent storage.
+	PersistentIdRange = "persistent"
+)
+
+var (
+	// ErrNoSuchIdRange is returned when an id or name matches no range.
+	ErrNoSuchIdRange = errors.New("ops: No such id range.")
+)
+
+// IdRange is a named range of hue task ids from Start inclusive to End
+// exclusive.
+type IdRange struct {
+	Name  string
+	Start int
+	End   int
+}
+
+// Contains returns true if id is in this range.
+func (r IdRange) Contains(id int) bool {
+	return id >= r.Start && id < r.End
+}
+
+// Local converts a hue task id in this range to an id local to this range
+// such as a database id. The first id in this range has local id 0.
+func (r IdRange) Local(id int) int64 {
+	return int64(id - r.Start)
+}
+
+// Global converts a local id as returned by Local back to a hue task id.
+func (r IdRange) Global(localId int64) int {
+	return int(localId) + r.Start
+}
+
+// IdSpace is a set of named, non-overlapping ranges of hue task ids.
+// Installations use IdSpace to give hard-coded hue tasks, hue tasks from
+// a database, and hue tasks from plugins their own ids.
+// IdSpace instances must be treated as immutable.
+type IdSpace struct {
+	ranges []IdRange
+}
+
+// NewIdSpace returns a new IdSpace made up of ranges. NewIdSpace returns
+// an error if any ranges overlap, if any range is empty, or if two ranges
+// have the same name.
+func NewIdSpace(ranges ...IdRange) (*IdSpace, error) {
+	sorted := make([]IdRange, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+	names := make(map[string]bool, len(sorted))
+	for i := range sorted {
+		if sorted[i].Start >= sorted[i].End {
+			return nil, fmt.Errorf("ops: Empty id range %s", sorted[i].Name)
+		}
+		if names[sorted[i].Name] {
+			return nil, fmt.Errorf(
+				"ops: Duplicate id range %s", sorted[i].Name)
+		}
+		names[sorted[i].Name] = true
+		if i > 0 && sorted[i-1].End > sorted[i].Start {
+			return nil, fmt.Errorf(
+				"ops: Id ranges %s and %s overlap",
+				sorted[i-1].Name, sorted[i].Name)
+		}
+	}
+	return &IdSpace{ranges: sorted}, nil
+}
+
+// MustIdSpace works like NewIdSpace but panics on error.
+func MustIdSpace(ranges ...IdRange) *IdSpace {
+	result, err := NewIdSpace(ranges...)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// DefaultIdSpace has the id ranges marvin2 has always used. Hard-coded
+// hue tasks have ids less than PersistentTaskIdOffset while hue tasks
+// from persistent storage have ids of PersistentTaskIdOffset or more.
+var DefaultIdSpace = MustIdSpace(
+	IdRange{
+		Name:  StaticIdRange,
+		Start: math.MinInt32,
+		End:   PersistentTaskIdOffset,
+	},
+	IdRange{
+		Name:  PersistentIdRange,
+		Start: PersistentTaskIdOffset,
+		End:   math.MaxInt32,
+	},
+)
+
+// Ranges returns the ranges in this instance sorted by Start.
+func (s *IdSpace) Ranges() []IdRange {
+	result := make([]IdRange, len(s.ranges))
+	copy(result, s.ranges)
+	return result
+}
+
+// RangeOf returns the range containing id. If no range contains id,
+// RangeOf returns ErrNoSuchIdRange.
+func (s *IdSpace) RangeOf(id int) (IdRange, error) {
+	idx := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].End > id
+	})
+	if idx == len(s.ranges) || !s.ranges[idx].Contains(id) {
+		return IdRange{}, ErrNoSuchIdRange
+	}
+	return s.ranges[idx], nil
+}
+
+// Range returns the range with given name. If there is no such range,
+// Range returns ErrNoSuchIdRange.
+func (s *IdSpace) Range(name string) (IdRange, error) {
+	for _, r := range s.ranges {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return IdRange{}, ErrNoSuchIdRange
+}
@@ -0,0 +1,88 @@
+package ops_test
+
+import (
+	"github.com/keep94/marvin2/ops"
+	"testing"
+)
+
+func TestIdSpace(t *testing.T) {
+	space, err := ops.NewIdSpace(
+		ops.IdRange{Name: "plugin", Start: 20000, End: 30000},
+		ops.IdRange{Name: "static", Start: 0, End: 10000},
+		ops.IdRange{Name: "db", Start: 10000, End: 20000})
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	r, err := space.RangeOf(20005)
+	if err != nil || r.Name != "plugin" {
+		t.Errorf("Expected plugin, got %v, %v", r, err)
+	}
+	if out := r.Local(20005); out != 5 {
+		t.Errorf("Expected 5, got %d", out)
+	}
+	if out := r.Global(5); out != 20005 {
+		t.Errorf("Expected 20005, got %d", out)
+	}
+	if r, err = space.RangeOf(10000); err != nil || r.Name != "db" {
+		t.Errorf("Expected db, got %v, %v", r, err)
+	}
+	if r, err = space.RangeOf(0); err != nil || r.Name != "static" {
+		t.Errorf("Expected static, got %v, %v", r, err)
+	}
+	if _, err = space.RangeOf(30000); err != ops.ErrNoSuchIdRange {
+		t.Errorf("Expected ErrNoSuchIdRange, got %v", err)
+	}
+	if _, err = space.RangeOf(-1); err != ops.ErrNoSuchIdRange {
+		t.Errorf("Expected ErrNoSuchIdRange, got %v", err)
+	}
+	if r, err = space.Range("db"); err != nil || r.Start != 10000 {
+		t.Errorf("Expected db range, got %v, %v", r, err)
+	}
+	if _, err = space.Range("missing"); err != ops.ErrNoSuchIdRange {
+		t.Errorf("Expected ErrNoSuchIdRange, got %v", err)
+	}
+	ranges := space.Ranges()
+	if len(ranges) != 3 || ranges[0].Name != "static" || ranges[2].Name != "plugin" {
+		t.Errorf("Expected ranges sorted by start, got %v", ranges)
+	}
+}
+
+func TestIdSpaceErrors(t *testing.T) {
+	if _, err := ops.NewIdSpace(
+		ops.IdRange{Name: "a", Start: 0, End: 100},
+		ops.IdRange{Name: "b", Start: 99, End: 200}); err == nil {
+		t.Error("Expected error for overlapping ranges")
+	}
+	if _, err := ops.NewIdSpace(
+		ops.IdRange{Name: "a", Start: 0, End: 100},
+		ops.IdRange{Name: "a", Start: 100, End: 200}); err == nil {
+		t.Error("Expected error for duplicate names")
+	}
+	if _, err := ops.NewIdSpace(
+		ops.IdRange{Name: "a", Start: 100, End: 100}); err == nil {
+		t.Error("Expected error for empty range")
+	}
+}
+
+func TestDefaultIdSpace(t *testing.T) {
+	r, err := ops.DefaultIdSpace.RangeOf(ops.PersistentTaskIdOffset + 3)
+	if err != nil || r.Name != ops.PersistentIdRange || r.Local(
+		ops.PersistentTaskIdOffset+3) != 3 {
+		t.Errorf("Expected persistent range, got %v, %v", r, err)
+	}
+	r, err = ops.DefaultIdSpace.RangeOf(-5)
+	if err != nil || r.Name != ops.StaticIdRange {
+		t.Errorf("Expected static range, got %v, %v", r, err)
+	}
+}
+
+func TestNamedColorsAsHueTaskInRange(t *testing.T) {
+	nc := &ops.NamedColors{Id: 7, Description: "Seven"}
+	h := nc.AsHueTaskInRange(ops.IdRange{Name: "db", Start: 50000, End: 60000})
+	if h.Id != 50007 || h.Description != "Seven" {
+		t.Errorf("Expected 50007 Seven, got %d %s", h.Id, h.Description)
+	}
+	if out := nc.AsHueTask().Id; out != ops.PersistentTaskIdOffset+7 {
+		t.Errorf("Expected %d, got %d", ops.PersistentTaskIdOffset+7, out)
+	}
+}
@@ -0,0 +1,197 @@
+package ops
+
+import (
+	"context"
+	"github.com/keep94/gohue"
+	"sync"
+	"time"
+)
+
+// Interface Observer receives the outcome of each call to the hue bridge
+// made through a Context returned from NewObservedContext.
+// Implementations must be safe to use with multiple goroutines.
+type Observer interface {
+
+	// OnSet is called after each call that changes lights such as Set,
+	// SetState, Alert, and RecallScene. elapsed is how long the call took;
+	// err is the error the call returned. lightId is 0 for RecallScene.
+	OnSet(lightId int, elapsed time.Duration, err error)
+
+	// OnGet is called after each call that reads from the hue bridge such
+	// as Get, GetState, and Scenes. elapsed is how long the call took; err
+	// is the error the call returned. lightId is 0 for Scenes.
+	OnGet(lightId int, elapsed time.Duration, err error)
+}
+
+// NewObservedContext returns a Context that works like ctxt except that it
+// reports the outcome of each call to observer. The returned Context
+// implements every optional Context interface. Calls to an optional
+// interface that ctxt lacks report an *UnsupportedError without being
+// observed.
+func NewObservedContext(ctxt Context, observer Observer) Context {
+	return &observedContext{ctxt: ctxt, observer: observer}
+}
+
+type observedContext struct {
+	ctxt     Context
+	observer Observer
+}
+
+func (o *observedContext) WithContext(ctx context.Context) Context {
+	return NewObservedContext(withContext(o.ctxt, ctx), o.observer)
+}
+
+func (o *observedContext) Set(
+	lightId int, properties *gohue.LightProperties) (
+	response []byte, err error) {
+	start := time.Now()
+	response, err = o.ctxt.Set(lightId, properties)
+	o.observer.OnSet(lightId, time.Since(start), err)
+	return
+}
+
+func (o *observedContext) Get(lightId int) (
+	properties *gohue.LightProperties, response []byte, err error) {
+	reader, err := asLightReader(o.ctxt)
+	if err != nil {
+		return nil, nil, err
+	}
+	start := time.Now()
+	properties, response, err = reader.Get(lightId)
+	o.observer.OnGet(lightId, time.Since(start), err)
+	return
+}
+
+func (o *observedContext) GetState(lightId int) (
+	state *LightState, response []byte, err error) {
+	reader, err := asLightStateReader(o.ctxt)
+	if err != nil {
+		return nil, nil, err
+	}
+	start := time.Now()
+	state, response, err = reader.GetState(lightId)
+	o.observer.OnGet(lightId, time.Since(start), err)
+	return
+}
+
+func (o *observedContext) SetState(
+	lightId int, state *LightState) (response []byte, err error) {
+	writer, err := asLightStateWriter(o.ctxt)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	response, err = writer.SetState(lightId, state)
+	o.observer.OnSet(lightId, time.Since(start), err)
+	return
+}
+
+func (o *observedContext) Alert(
+	lightId int, alert string) (response []byte, err error) {
+	alerter, err := asAlertContext(o.ctxt)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	response, err = alerter.Alert(lightId, alert)
+	o.observer.OnSet(lightId, time.Since(start), err)
+	return
+}
+
+func (o *observedContext) RecallScene(
+	sceneId string) (response []byte, err error) {
+	sceneCtxt, err := asSceneContext(o.ctxt)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	response, err = sceneCtxt.RecallScene(sceneId)
+	o.observer.OnSet(0, time.Since(start), err)
+	return
+}
+
+func (o *observedContext) Scenes() (
+	scenes SceneList, response []byte, err error) {
+	sceneCtxt, err := asSceneContext(o.ctxt)
+	if err != nil {
+		return nil, nil, err
+	}
+	start := time.Now()
+	scenes, response, err = sceneCtxt.Scenes()
+	o.observer.OnGet(0, time.Since(start), err)
+	return
+}
+
+// CallStats summarizes calls to the hue bridge.
+type CallStats struct {
+
+	// The number of calls that changed lights
+	Sets int
+
+	// The number of calls that read from the hue bridge
+	Gets int
+
+	// The number of calls that failed
+	Errors int
+
+	// The total time spent in calls that changed lights
+	SetTime time.Duration
+
+	// The total time spent in calls that read from the hue bridge
+	GetTime time.Duration
+}
+
+// AvgSetTime returns the average time of a Set call or 0 if there were
+// no Set calls.
+func (s *CallStats) AvgSetTime() time.Duration {
+	if s.Sets == 0 {
+		return 0
+	}
+	return s.SetTime / time.Duration(s.Sets)
+}
+
+// AvgGetTime returns the average time of a Get call or 0 if there were
+// no Get calls.
+func (s *CallStats) AvgGetTime() time.Duration {
+	if s.Gets == 0 {
+		return 0
+	}
+	return s.GetTime / time.Duration(s.Gets)
+}
+
+// StatsObserver is an Observer that accumulates CallStats. For example,
+// an executor can pass a new StatsObserver to NewObservedContext for each
+// task it runs to learn how many commands that task issued.
+// The zero value is ready to use.
+// StatsObserver instances are safe to use with multiple goroutines.
+type StatsObserver struct {
+	mutex sync.Mutex
+	stats CallStats
+}
+
+func (s *StatsObserver) OnSet(lightId int, elapsed time.Duration, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats.Sets++
+	s.stats.SetTime += elapsed
+	if err != nil {
+		s.stats.Errors++
+	}
+}
+
+func (s *StatsObserver) OnGet(lightId int, elapsed time.Duration, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats.Gets++
+	s.stats.GetTime += elapsed
+	if err != nil {
+		s.stats.Errors++
+	}
+}
+
+// Stats returns the stats accumulated so far.
+func (s *StatsObserver) Stats() CallStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stats
+}
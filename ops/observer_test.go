@@ -0,0 +1,79 @@
+package ops_test
+
+import (
+	"context"
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/maybe"
+	"testing"
+	"time"
+)
+
+func TestObservedContext(t *testing.T) {
+	var observer ops.StatsObserver
+	ctxt := ops.NewObservedContext(
+		readWriteContextForTesting{contextForTesting{
+			1: {On: maybe.NewBool(true)}}},
+		&observer)
+	ctxt.Set(1, &gohue.LightProperties{Bri: maybe.NewUint8(10)})
+	ctxt.Set(2, &gohue.LightProperties{Bri: maybe.NewUint8(10)})
+	reader, ok := ctxt.(ops.LightReader)
+	if !ok {
+		t.Fatal("Expected LightReader to be preserved.")
+	}
+	reader.Get(1)
+	reader.Get(3)
+	stats := observer.Stats()
+	if stats.Sets != 2 || stats.Gets != 2 || stats.Errors != 1 {
+		t.Errorf("Expected 2 sets, 2 gets, 1 error, got %+v", stats)
+	}
+	if stats.SetTime < 0 || stats.GetTime < 0 {
+		t.Errorf("Expected non negative times, got %+v", stats)
+	}
+}
+
+func TestObservedContextPlain(t *testing.T) {
+	var observer ops.StatsObserver
+	ctxt := ops.NewObservedContext(make(contextForTesting), &observer)
+	ctxt.Set(1, &gohue.LightProperties{})
+	_, _, err := ctxt.(ops.LightReader).Get(1)
+	assertUnsupported(t, "LightReader", err)
+	if out := observer.Stats(); out.Sets != 1 || out.Gets != 0 {
+		t.Errorf("Expected 1 set and no gets, got %+v", out)
+	}
+}
+
+func TestObservedContextForwards(t *testing.T) {
+	var observer ops.StatsObserver
+	assertForwards(t, func(ctxt ops.Context) ops.Context {
+		return ops.NewObservedContext(ctxt, &observer)
+	})
+	if out := observer.Stats(); out.Sets == 0 || out.Gets == 0 {
+		t.Errorf("Expected forwarded calls to be observed, got %+v", out)
+	}
+}
+
+func TestObservedContextWithContext(t *testing.T) {
+	var observer ops.StatsObserver
+	ctxt := ops.NewObservedContext(&cancelableContext{}, &observer)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	canceled := ctxt.(ops.CancelableContext).WithContext(ctx)
+	if _, err := canceled.Set(1, &gohue.LightProperties{}); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if out := observer.Stats().Errors; out != 1 {
+		t.Errorf("Expected 1 error, got %d", out)
+	}
+}
+
+func TestCallStatsAverages(t *testing.T) {
+	stats := ops.CallStats{
+		Sets: 4, SetTime: 400 * time.Millisecond}
+	if out := stats.AvgSetTime(); out != 100*time.Millisecond {
+		t.Errorf("Expected 100ms, got %v", out)
+	}
+	if out := stats.AvgGetTime(); out != 0 {
+		t.Errorf("Expected 0, got %v", out)
+	}
+}
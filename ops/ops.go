@@ -2,12 +2,13 @@
 package ops
 
 import (
-	"errors"
 	"github.com/keep94/gohue"
 	"github.com/keep94/gohue/actions"
 	"github.com/keep94/marvin2/lights"
 	"github.com/keep94/maybe"
 	"github.com/keep94/tasks"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -31,7 +32,8 @@ type HueAction interface {
 	// ctxt is the connection to the hue bridge; lightSet is the exact set of
 	// lights. The tasks package provides e.
 	// If a Do implementation needs more than the Context interface and
-	// ctxt does not implement it then Do does nothing.
+	// ctxt does not implement it then Do reports an *UnsupportedError
+	// through e.
 	Do(ctxt Context, lightSet lights.Set, e *tasks.Execution)
 
 	// UsedLights returns the lights this instance will use given an initial
@@ -48,6 +50,14 @@ type HueTask struct {
 	Id int
 	HueAction
 	Description string
+
+	// Optional tags for grouping hue tasks e.g "party", "holiday".
+	Tags []string
+}
+
+// HasTag returns true if this instance has tag ignoring case.
+func (h *HueTask) HasTag(tag string) bool {
+	return hasTag(h.Tags, tag)
 }
 
 // Refresh returns this instance.
@@ -75,11 +85,63 @@ type AtTimeTask struct {
 
 	// The time to start
 	StartTime time.Time
+
+	// The time to end. The zero value means the hue task ends on its own.
+	EndTime time.Time
+
+	// If true, the lights go back to the state they were in before the
+	// hue task started when EndTime arrives; otherwise they turn off.
+	// Ignored if EndTime is the zero value. See Until.
+	RestoreAtEnd bool
+}
+
+// HueTaskToRun returns the hue task to run at StartTime. If EndTime is
+// set, the returned hue task wraps H with Until.
+func (a *AtTimeTask) HueTaskToRun() *HueTask {
+	if a.EndTime.IsZero() {
+		return a.H
+	}
+	return &HueTask{
+		Id:          a.H.Id,
+		HueAction:   Until(a.H.HueAction, a.EndTime, a.RestoreAtEnd),
+		Description: a.H.Description,
+		Tags:        a.H.Tags,
+	}
 }
 
 // HueTaskList represents an immutable list of hue tasks.
 type HueTaskList []*HueTask
 
+// Filter returns a new HueTaskList containing the hue tasks in this
+// instance for which predicate returns true in the same order.
+func (l HueTaskList) Filter(predicate func(h *HueTask) bool) HueTaskList {
+	var result HueTaskList
+	for _, h := range l {
+		if predicate(h) {
+			result = append(result, h)
+		}
+	}
+	return result
+}
+
+// ByTag returns a new HueTaskList containing the hue tasks in this
+// instance that have tag ignoring case.
+func (l HueTaskList) ByTag(tag string) HueTaskList {
+	return l.Filter(func(h *HueTask) bool {
+		return h.HasTag(tag)
+	})
+}
+
+// Search returns a new HueTaskList containing the hue tasks in this
+// instance whose description contains query ignoring case. An empty
+// query matches every hue task.
+func (l HueTaskList) Search(query string) HueTaskList {
+	query = strings.ToLower(query)
+	return l.Filter(func(h *HueTask) bool {
+		return strings.Contains(strings.ToLower(h.Description), query)
+	})
+}
+
 // ColorBrightness represents a color and brightness.
 type ColorBrightness struct {
 	Color      gohue.MaybeColor
@@ -187,12 +249,77 @@ func (a StaticHueAction) UsedLights(lightSet lights.Set) lights.Set {
 	return usedLights.Intersect(lightSet)
 }
 
+// ConcurrentStaticHueAction works like StaticHueAction except that it
+// issues the Set calls for individual lights in parallel so that large
+// rooms change all at once instead of one light at a time. The Context
+// passed to Do must be safe to use with multiple goroutines. If more than
+// one light fails, Do reports a SetErrors instance.
+// These instances must be treated as immutable.
+type ConcurrentStaticHueAction struct {
+	StaticHueAction
+
+	// The maximum number of concurrent Set calls. 0 or negative means 1.
+	Workers int
+}
+
+func (a ConcurrentStaticHueAction) Do(
+	ctxt Context, lightSet lights.Set, e *tasks.Execution) {
+	var globalLightProperties *gohue.LightProperties
+	if globalCb, ok := a.StaticHueAction[0]; ok {
+		globalLightProperties = colorBrightnessToLightProperties(globalCb)
+	}
+	ids, ok := lightSet.Slice()
+	if !ok {
+		return
+	}
+	if len(ids) == 0 {
+		a.StaticHueAction.Do(ctxt, lightSet, e)
+		return
+	}
+	err := setConcurrently(
+		ctxt,
+		ids,
+		func(id int) *gohue.LightProperties {
+			if globalLightProperties != nil {
+				return globalLightProperties
+			}
+			return colorBrightnessToLightProperties(a.StaticHueAction[id])
+		},
+		a.Workers)
+	if err != nil {
+		e.SetError(err)
+	}
+}
+
+// SetErrors reports multiple failures while controlling lights such as
+// when multiple Set calls to the hue bridge fail. Each element is a
+// single failure.
+type SetErrors []error
+
+func (s SetErrors) Error() string {
+	parts := make([]string, len(s))
+	for i := range s {
+		parts[i] = s[i].Error()
+	}
+	return strings.Join(parts, "; ")
+}
+
 // NamedColors represents colors for lights by name read from persistent
 // storage.
 type NamedColors struct {
 	Id          int64
 	Colors      LightColors
 	Description string
+
+	// When these named colors were added and last changed. Stores set
+	// these; they are zero if unknown.
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// Stores that support optimistic concurrency start Version at 1,
+	// increment it on each update, and refuse updates whose Version does
+	// not match. A Version of 0 means update without checking.
+	Version int64
 }
 
 // AsHueTask converts this instance to a HueTask
@@ -204,6 +331,16 @@ func (nc *NamedColors) AsHueTask() *HueTask {
 	}
 }
 
+// AsHueTaskInRange works like AsHueTask except that the Id of the returned
+// HueTask comes from idRange instead of PersistentTaskIdOffset.
+func (nc *NamedColors) AsHueTaskInRange(idRange IdRange) *HueTask {
+	return &HueTask{
+		Id:          idRange.Global(nc.Id),
+		HueAction:   StaticHueAction(nc.Colors),
+		Description: nc.Description,
+	}
+}
+
 // Blink takes a sequence of brightnesses and returns what those brighnesses
 // should be when they blink. brights are the original brighnesses. magnitude
 // is a value between -255 and 255 inclusive that indicates the magnitude of
@@ -285,17 +422,57 @@ func Blink(brights []uint8, magnitude int) []uint8 {
 // FixError converts a response from gohue.Get() or gohue.Set() into
 // a descriptive error. lightId is the lightId, rawResponse is the
 // response from gohue.Get() or gohue.Set(), err is the original
-// error from gohue.Get() or gohue.Set()
+// error from gohue.Get() or gohue.Set(). If the bridge sent a response,
+// FixError returns a *BridgeError unless the light does not exist in
+// which case it returns an *actions.NoSuchLightIdError.
 func FixError(lightId int, rawResponse []byte, err error) error {
 	if err == gohue.NoSuchResourceError {
 		return &actions.NoSuchLightIdError{LightId: lightId, RawResponse: rawResponse}
 	}
 	if len(rawResponse) > 0 {
-		return errors.New(string(rawResponse))
+		return newBridgeError(lightId, rawResponse, err)
 	}
 	return err
 }
 
+// setConcurrently sets the properties of the lights in ids using at most
+// workers goroutines. propertiesFunc returns the properties for a given
+// light id. setConcurrently returns nil if all Set calls succeed, the
+// error if exactly one fails, or a SetErrors if more than one fails.
+func setConcurrently(
+	ctxt Context,
+	ids []int,
+	propertiesFunc func(id int) *gohue.LightProperties,
+	workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(ids) {
+		workers = len(ids)
+	}
+	errs := make([]error, len(ids))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				id := ids[idx]
+				if response, err := ctxt.Set(id, propertiesFunc(id)); err != nil {
+					errs[idx] = FixError(id, response, err)
+				}
+			}
+		}()
+	}
+	for i := range ids {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return mergeErrors(errs)
+}
+
 func colorBrightnessToLightProperties(
 	cb ColorBrightness) *gohue.LightProperties {
 	var transitionTime maybe.Uint16
@@ -317,3 +494,12 @@ func colorBrightnessToLightPropertiesWithTransition(
 		On:             maybe.NewBool(true),
 		TransitionTime: transitionTime}
 }
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -5,7 +5,9 @@ import (
 	"github.com/keep94/marvin2/lights"
 	"github.com/keep94/marvin2/ops"
 	"github.com/keep94/maybe"
+	"github.com/keep94/tasks"
 	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -129,6 +131,70 @@ func TestStaticHueActionDoSome(t *testing.T) {
 	}
 }
 
+func TestConcurrentStaticHueActionDo(t *testing.T) {
+	someColor := gohue.NewMaybeColor(gohue.Red)
+	someBrightness := maybe.NewUint8(128)
+	a := ops.ConcurrentStaticHueAction{
+		StaticHueAction: ops.StaticHueAction{
+			2: {someColor, someBrightness},
+			4: {gohue.NewMaybeColor(gohue.Green), maybe.NewUint8(192)},
+			5: {gohue.NewMaybeColor(gohue.Blue), maybe.NewUint8(64)},
+			7: {someColor, someBrightness}},
+		Workers: 3,
+	}
+	ctxt := &syncContextForTesting{c: make(contextForTesting)}
+	err := tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		a.Do(ctxt, lights.New(2, 4, 5, 7), e)
+	}))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := contextForTesting{
+		2: {C: someColor, Bri: someBrightness, On: maybe.NewBool(true)},
+		4: {
+			C:   gohue.NewMaybeColor(gohue.Green),
+			Bri: maybe.NewUint8(192),
+			On:  maybe.NewBool(true),
+		},
+		5: {
+			C:   gohue.NewMaybeColor(gohue.Blue),
+			Bri: maybe.NewUint8(64),
+			On:  maybe.NewBool(true),
+		},
+		7: {C: someColor, Bri: someBrightness, On: maybe.NewBool(true)},
+	}
+	if !reflect.DeepEqual(expected, ctxt.c) {
+		t.Errorf("Expected %v, got %v", expected, ctxt.c)
+	}
+}
+
+func TestConcurrentStaticHueActionErrors(t *testing.T) {
+	a := ops.ConcurrentStaticHueAction{
+		StaticHueAction: ops.StaticHueAction{
+			0: {gohue.NewMaybeColor(gohue.Red), maybe.NewUint8(128)}},
+		Workers: 2,
+	}
+	ctxt := &syncContextForTesting{
+		c: make(contextForTesting), bad: lights.New(3, 6)}
+	err := tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		a.Do(ctxt, lights.New(1, 3, 5, 6), e)
+	}))
+	setErrors, ok := err.(ops.SetErrors)
+	if !ok || len(setErrors) != 2 {
+		t.Fatalf("Expected 2 SetErrors, got %v", err)
+	}
+	if out := len(ctxt.c); out != 2 {
+		t.Errorf("Expected 2 lights set, got %d", out)
+	}
+	ctxt = &syncContextForTesting{c: make(contextForTesting), bad: lights.New(5)}
+	err = tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		a.Do(ctxt, lights.New(1, 3, 5, 6), e)
+	}))
+	if _, ok := err.(ops.SetErrors); ok || err == nil {
+		t.Errorf("Expected a single error, got %v", err)
+	}
+}
+
 func TestBlinkDesiredDirection(t *testing.T) {
 	actual := ops.Blink([]uint8{47, 49, 48}, -47)
 	expected := []uint8{0, 2, 1}
@@ -220,3 +286,46 @@ func (c contextForTesting) Set(
 	c[lightId] = &propertiesCopy
 	return
 }
+
+type syncContextForTesting struct {
+	mutex sync.Mutex
+	c     contextForTesting
+	bad   lights.Set
+}
+
+func (s *syncContextForTesting) Set(
+	lightId int,
+	properties *gohue.LightProperties) (response []byte, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.bad[lightId] {
+		return []byte("Bad light"), gohue.GeneralError
+	}
+	return s.c.Set(lightId, properties)
+}
+
+func TestHueTaskListFilter(t *testing.T) {
+	hueTasks := ops.HueTaskList{
+		{Id: 1, Description: "Red Alert", Tags: []string{"Alarm"}},
+		{Id: 2, Description: "Party", Tags: []string{"party", "alarm"}},
+		{Id: 3, Description: "Bedtime"},
+	}
+	expected := ops.HueTaskList{hueTasks[0], hueTasks[1]}
+	if actual := hueTasks.ByTag("ALARM"); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	expected = ops.HueTaskList{hueTasks[0], hueTasks[2]}
+	if actual := hueTasks.Search("E"); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	if actual := hueTasks.Search("xyz"); len(actual) != 0 {
+		t.Errorf("Expected no hue tasks, got %v", actual)
+	}
+	expected = ops.HueTaskList{hueTasks[2]}
+	actual := hueTasks.Filter(func(h *ops.HueTask) bool {
+		return !h.HasTag("alarm")
+	})
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
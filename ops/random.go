@@ -0,0 +1,71 @@
+package ops
+
+import (
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/maybe"
+	"github.com/keep94/tasks"
+	"math/rand"
+	"time"
+)
+
+// RandomColorAction changes each light to a random color every Interval.
+// The colors come from a pseudo-random sequence that Seed determines, so
+// RandomColorActions with the same Seed run on the same lights show the
+// same colors in the same order. When run on all lights, all lights get
+// the same color. RandomColorAction runs until its task is ended or until
+// setting the lights fails.
+// These instances must be treated as immutable.
+type RandomColorAction struct {
+	// How long to wait between color changes
+	Interval time.Duration
+
+	// The brightness of the lights
+	Brightness uint8
+
+	// Determines the sequence of colors
+	Seed int64
+}
+
+func (a RandomColorAction) Do(
+	ctxt Context, lightSet lights.Set, e *tasks.Execution) {
+	ids, ok := lightSet.Slice()
+	if !ok {
+		return
+	}
+	if len(ids) == 0 {
+		ids = []int{0}
+	}
+	rnd := rand.New(rand.NewSource(a.Seed))
+	for {
+		action := make(StaticHueAction, len(ids))
+		for _, id := range ids {
+			action[id] = ColorBrightness{
+				Color:      gohue.NewMaybeColor(randomColor(rnd)),
+				Brightness: maybe.NewUint8(a.Brightness),
+			}
+		}
+		action.Do(ctxt, lightSet, e)
+		if e.Error() != nil || !e.Sleep(a.Interval) {
+			return
+		}
+	}
+}
+
+func (a RandomColorAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
+
+// randomColor returns a random color within the triangle that red, green,
+// and blue make so that the hue bridge can show it.
+func randomColor(rnd *rand.Rand) gohue.Color {
+	r1, r2 := rnd.Float64(), rnd.Float64()
+	if r1+r2 > 1.0 {
+		r1, r2 = 1.0-r1, 1.0-r2
+	}
+	return gohue.NewColor(
+		gohue.Red.X()+r1*(gohue.Green.X()-gohue.Red.X())+
+			r2*(gohue.Blue.X()-gohue.Red.X()),
+		gohue.Red.Y()+r1*(gohue.Green.Y()-gohue.Red.Y())+
+			r2*(gohue.Blue.Y()-gohue.Red.Y()))
+}
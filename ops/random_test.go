@@ -0,0 +1,62 @@
+package ops_test
+
+import (
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/tasks"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRandomColorAction(t *testing.T) {
+	action := ops.RandomColorAction{
+		Interval:   time.Minute,
+		Brightness: 100,
+		Seed:       42,
+	}
+	first := runRandomColorAction(action, lights.New(1, 3), 6)
+	if len(first) != 6 {
+		t.Fatalf("Expected 6 colors, got %v", first)
+	}
+	if first[0] == first[2] && first[2] == first[4] {
+		t.Error("Expected colors to change")
+	}
+	second := runRandomColorAction(action, lights.New(1, 3), 6)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("Expected same sequence %v, got %v", first, second)
+	}
+	action.Seed = 43
+	third := runRandomColorAction(action, lights.New(1, 3), 6)
+	if reflect.DeepEqual(first, third) {
+		t.Error("Expected different sequence for different seed")
+	}
+}
+
+func TestRandomColorActionAllLights(t *testing.T) {
+	ctxt := &historyContextForTesting{limit: 2}
+	action := ops.RandomColorAction{Interval: time.Minute, Seed: 7}
+	err := tasks.RunForTesting(tasks.TaskFunc(func(e *tasks.Execution) {
+		ctxt.end = e.End
+		action.Do(ctxt, lights.All, e)
+	}), &tasks.ClockForTesting{})
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if len(ctxt.colors) != 2 {
+		t.Errorf("Expected 2 colors, got %v", ctxt.colors)
+	}
+}
+
+func runRandomColorAction(
+	action ops.RandomColorAction,
+	lightSet lights.Set,
+	count int) []gohue.Color {
+	ctxt := &historyContextForTesting{limit: count}
+	tasks.RunForTesting(tasks.TaskFunc(func(e *tasks.Execution) {
+		ctxt.end = e.End
+		action.Do(ctxt, lightSet, e)
+	}), &tasks.ClockForTesting{})
+	return ctxt.colors
+}
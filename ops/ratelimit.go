@@ -0,0 +1,168 @@
+package ops
+
+import (
+	"context"
+	"github.com/keep94/gohue"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits how fast light commands go to the hue bridge using
+// a token bucket. Every Context that NewRateLimitedContext creates
+// from the same RateLimiter shares the same bucket, so all executors
+// talking to one hue bridge should share one RateLimiter.
+// RateLimiter is safe to use with multiple goroutines.
+type RateLimiter struct {
+	mutex     sync.Mutex
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+}
+
+// NewRateLimiter creates a new RateLimiter that allows perSecond commands
+// per second on average with bursts of up to burst commands. The hue
+// bridge recommends no more than 10 commands per second.
+// burst less than 1 means 1. NewRateLimiter panics if perSecond is not
+// positive.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	if perSecond <= 0.0 {
+		panic("perSecond must be positive.")
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		last:      time.Now(),
+	}
+}
+
+// Wait blocks until the caller may send one command to the hue bridge.
+func (r *RateLimiter) Wait() {
+	r.WaitContext(context.Background())
+}
+
+// WaitContext works like Wait except that it returns early with false
+// if ctx finishes first.
+func (r *RateLimiter) WaitContext(ctx context.Context) bool {
+	if d := r.reserve(time.Now()); d > 0 {
+		return sleepContext(ctx, d)
+	}
+	return true
+}
+
+// reserve takes a token from the bucket and returns how long the caller
+// must wait before the token is available.
+func (r *RateLimiter) reserve(now time.Time) time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if now.After(r.last) {
+		r.tokens += now.Sub(r.last).Seconds() * r.perSecond
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+		r.last = now
+	}
+	r.tokens--
+	if r.tokens >= 0.0 {
+		return 0
+	}
+	return time.Duration(-r.tokens / r.perSecond * float64(time.Second))
+}
+
+// NewRateLimitedContext returns a Context that works like ctxt except that
+// each call that changes lights such as Set, SetState, Alert, and
+// RecallScene first waits on limiter. Calls that only read from the hue
+// bridge such as Get, GetState, and Scenes are not rate limited. The
+// returned Context implements every optional Context interface. Calls to
+// an optional interface that ctxt lacks report an *UnsupportedError
+// without waiting on limiter.
+func NewRateLimitedContext(ctxt Context, limiter *RateLimiter) Context {
+	return newRateLimitedContext(ctxt, limiter, context.Background())
+}
+
+func newRateLimitedContext(
+	ctxt Context, limiter *RateLimiter, ctx context.Context) Context {
+	return &rateLimitedContext{ctxt: ctxt, limiter: limiter, ctx: ctx}
+}
+
+type rateLimitedContext struct {
+	ctxt    Context
+	limiter *RateLimiter
+	ctx     context.Context
+}
+
+func (r *rateLimitedContext) Set(
+	lightId int, properties *gohue.LightProperties) ([]byte, error) {
+	if !r.limiter.WaitContext(r.ctx) {
+		return nil, r.ctx.Err()
+	}
+	return r.ctxt.Set(lightId, properties)
+}
+
+func (r *rateLimitedContext) WithContext(ctx context.Context) Context {
+	return newRateLimitedContext(withContext(r.ctxt, ctx), r.limiter, ctx)
+}
+
+func (r *rateLimitedContext) Get(lightId int) (
+	*gohue.LightProperties, []byte, error) {
+	reader, err := asLightReader(r.ctxt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reader.Get(lightId)
+}
+
+func (r *rateLimitedContext) GetState(lightId int) (
+	*LightState, []byte, error) {
+	reader, err := asLightStateReader(r.ctxt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reader.GetState(lightId)
+}
+
+func (r *rateLimitedContext) SetState(
+	lightId int, state *LightState) ([]byte, error) {
+	writer, err := asLightStateWriter(r.ctxt)
+	if err != nil {
+		return nil, err
+	}
+	if !r.limiter.WaitContext(r.ctx) {
+		return nil, r.ctx.Err()
+	}
+	return writer.SetState(lightId, state)
+}
+
+func (r *rateLimitedContext) Alert(lightId int, alert string) ([]byte, error) {
+	alerter, err := asAlertContext(r.ctxt)
+	if err != nil {
+		return nil, err
+	}
+	if !r.limiter.WaitContext(r.ctx) {
+		return nil, r.ctx.Err()
+	}
+	return alerter.Alert(lightId, alert)
+}
+
+func (r *rateLimitedContext) RecallScene(sceneId string) ([]byte, error) {
+	sceneCtxt, err := asSceneContext(r.ctxt)
+	if err != nil {
+		return nil, err
+	}
+	if !r.limiter.WaitContext(r.ctx) {
+		return nil, r.ctx.Err()
+	}
+	return sceneCtxt.RecallScene(sceneId)
+}
+
+func (r *rateLimitedContext) Scenes() (SceneList, []byte, error) {
+	sceneCtxt, err := asSceneContext(r.ctxt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sceneCtxt.Scenes()
+}
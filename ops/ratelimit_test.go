@@ -0,0 +1,85 @@
+package ops_test
+
+import (
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/ops"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedContext(t *testing.T) {
+	limiter := ops.NewRateLimiter(100.0, 2)
+	first := &syncContextForTesting{c: make(contextForTesting)}
+	second := &syncContextForTesting{c: make(contextForTesting)}
+	firstCtxt := ops.NewRateLimitedContext(first, limiter)
+	secondCtxt := ops.NewRateLimitedContext(second, limiter)
+	start := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, ctxt := range []ops.Context{firstCtxt, secondCtxt} {
+		go func(ctxt ops.Context) {
+			defer wg.Done()
+			for i := 1; i <= 4; i++ {
+				ctxt.Set(i, &gohue.LightProperties{})
+			}
+		}(ctxt)
+	}
+	wg.Wait()
+
+	// 8 commands with a burst of 2 means waiting for 6 more tokens at
+	// 100 per second.
+	if elapsed := time.Since(start); elapsed < 55*time.Millisecond {
+		t.Errorf("Expected at least 55ms, got %v", elapsed)
+	}
+	if len(first.c) != 4 || len(second.c) != 4 {
+		t.Error("Expected 4 lights set in each context.")
+	}
+}
+
+func TestRateLimitedContextReader(t *testing.T) {
+	ctxt := ops.NewRateLimitedContext(
+		&flakyContext{}, ops.NewRateLimiter(10.0, 1))
+	if _, ok := ctxt.(ops.LightReader); !ok {
+		t.Error("Expected rate limited context to implement LightReader.")
+	}
+}
+
+func TestRateLimitedContextForwards(t *testing.T) {
+	limiter := ops.NewRateLimiter(1000.0, 10)
+	assertForwards(t, func(ctxt ops.Context) ops.Context {
+		return ops.NewRateLimitedContext(ctxt, limiter)
+	})
+}
+
+func TestRateLimitedContextForwardedCommands(t *testing.T) {
+	limiter := ops.NewRateLimiter(10.0, 1)
+	alertCtxt := ops.NewRateLimitedContext(
+		&alertContextForTesting{
+			readWriteContextForTesting: readWriteContextForTesting{
+				make(contextForTesting)}},
+		limiter)
+	plainCtxt := ops.NewRateLimitedContext(make(contextForTesting), limiter)
+	start := time.Now()
+
+	// Unsupported calls don't use up tokens
+	_, err := plainCtxt.(ops.AlertContext).Alert(1, ops.AlertSelect)
+	assertUnsupported(t, "AlertContext", err)
+	if _, err := alertCtxt.(ops.AlertContext).Alert(
+		1, ops.AlertSelect); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected no wait for first alert, got %v", elapsed)
+	}
+	if _, err := alertCtxt.(ops.AlertContext).Alert(
+		1, ops.AlertNone); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+
+	// A burst of 1 at 10 per second means waiting 100ms for the second
+	// alert.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected at least 90ms, got %v", elapsed)
+	}
+}
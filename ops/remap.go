@@ -0,0 +1,160 @@
+package ops
+
+import (
+	"context"
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/tasks"
+)
+
+// RemapLights returns a HueTask that works like task except that it
+// controls the physical lights that lightMap maps its light ids to. The
+// light sets passed to Do and UsedLights and returned from UsedLights
+// hold physical light ids so that executors see which physical lights
+// the returned HueTask uses. The HueAction of task still sees only
+// virtual light ids, and every call that it makes to the hue bridge
+// goes to the physical light. The HueAction of task sees a Context that
+// implements every optional Context interface and reports an
+// *UnsupportedError for the ones that the Context passed to Do lacks.
+func RemapLights(task *HueTask, lightMap lights.Map) *HueTask {
+	return &HueTask{
+		Id:          task.Id,
+		HueAction:   &remapAction{action: task.HueAction, lightMap: lightMap},
+		Description: task.Description,
+		Tags:        task.Tags,
+	}
+}
+
+type remapAction struct {
+	action   HueAction
+	lightMap lights.Map
+}
+
+func (a *remapAction) Do(
+	ctxt Context, lightSet lights.Set, e *tasks.Execution) {
+	a.action.Do(
+		newRemapContext(ctxt, a.lightMap),
+		a.action.UsedLights(virtualLights(a.lightMap, lightSet)),
+		e)
+}
+
+func (a *remapAction) UsedLights(lightSet lights.Set) lights.Set {
+	return physicalLights(
+		a.lightMap,
+		a.action.UsedLights(virtualLights(a.lightMap, lightSet)))
+}
+
+// physicalLights returns the physical light ids that lightMap maps the
+// virtual light ids in virtual to.
+func physicalLights(lightMap lights.Map, virtual lights.Set) lights.Set {
+	if virtual.IsAll() {
+		return virtual
+	}
+	var builder lights.Builder
+	for id, ok := range virtual {
+		if ok {
+			builder.AddOne(lightMap.Convert(id))
+		}
+	}
+	return builder.Build()
+}
+
+// virtualLights returns the virtual light ids that lightMap maps to
+// the physical light ids in physical.
+func virtualLights(lightMap lights.Map, physical lights.Set) lights.Set {
+	if physical.IsAll() {
+		return physical
+	}
+	var builder lights.Builder
+	for id, ok := range physical {
+		if _, remapped := lightMap[id]; ok && !remapped {
+			builder.AddOne(id)
+		}
+	}
+	for virtualId, physicalId := range lightMap {
+		if physical[physicalId] {
+			builder.AddOne(virtualId)
+		}
+	}
+	return builder.Build()
+}
+
+func newRemapContext(ctxt Context, lightMap lights.Map) Context {
+	return &remapContext{ctxt: ctxt, lightMap: lightMap}
+}
+
+type remapContext struct {
+	ctxt     Context
+	lightMap lights.Map
+}
+
+func (r *remapContext) WithContext(ctx context.Context) Context {
+	return newRemapContext(withContext(r.ctxt, ctx), r.lightMap)
+}
+
+func (r *remapContext) Set(
+	lightId int, properties *gohue.LightProperties) ([]byte, error) {
+	return r.ctxt.Set(r.lightMap.Convert(lightId), properties)
+}
+
+func (r *remapContext) Get(lightId int) (
+	*gohue.LightProperties, []byte, error) {
+	reader, err := asLightReader(r.ctxt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reader.Get(r.lightMap.Convert(lightId))
+}
+
+func (r *remapContext) GetState(lightId int) (*LightState, []byte, error) {
+	reader, err := asLightStateReader(r.ctxt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reader.GetState(r.lightMap.Convert(lightId))
+}
+
+func (r *remapContext) SetState(
+	lightId int, state *LightState) ([]byte, error) {
+	writer, err := asLightStateWriter(r.ctxt)
+	if err != nil {
+		return nil, err
+	}
+	return writer.SetState(r.lightMap.Convert(lightId), state)
+}
+
+func (r *remapContext) Alert(lightId int, alert string) ([]byte, error) {
+	alerter, err := asAlertContext(r.ctxt)
+	if err != nil {
+		return nil, err
+	}
+	return alerter.Alert(r.lightMap.Convert(lightId), alert)
+}
+
+func (r *remapContext) RecallScene(sceneId string) ([]byte, error) {
+	sceneCtxt, err := asSceneContext(r.ctxt)
+	if err != nil {
+		return nil, err
+	}
+	return sceneCtxt.RecallScene(sceneId)
+}
+
+// Scenes returns the scenes with the physical light ids of each scene
+// mapped back to virtual light ids.
+func (r *remapContext) Scenes() (SceneList, []byte, error) {
+	sceneCtxt, err := asSceneContext(r.ctxt)
+	if err != nil {
+		return nil, nil, err
+	}
+	scenes, response, err := sceneCtxt.Scenes()
+	if err != nil {
+		return nil, response, err
+	}
+	result := make(SceneList, len(scenes))
+	for i, scene := range scenes {
+		remapped := *scene
+		remapped.Lights = virtualLights(r.lightMap, scene.Lights)
+		result[i] = &remapped
+	}
+	return result, response, nil
+}
@@ -0,0 +1,152 @@
+package ops_test
+
+import (
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/maybe"
+	"github.com/keep94/tasks"
+	"reflect"
+	"testing"
+)
+
+func TestRemapLights(t *testing.T) {
+	someBrightness := maybe.NewUint8(128)
+	task := &ops.HueTask{
+		Id: 5,
+		HueAction: ops.StaticHueAction{
+			1: {gohue.NewMaybeColor(gohue.Red), someBrightness},
+			2: {gohue.NewMaybeColor(gohue.Blue), someBrightness}},
+		Description: "Red and blue",
+	}
+	remapped := ops.RemapLights(task, lights.Map{1: 11})
+	if remapped.Id != 5 || remapped.Description != "Red and blue" {
+		t.Errorf("Expected same id and description, got %d %s",
+			remapped.Id, remapped.Description)
+	}
+	if out := remapped.UsedLights(lights.All); !reflect.DeepEqual(
+		lights.New(2, 11), out) {
+		t.Errorf("Expected physical lights {2, 11}, got %v", out)
+	}
+	if out := remapped.UsedLights(lights.New(1, 11)); !reflect.DeepEqual(
+		lights.New(11), out) {
+		t.Errorf("Expected physical lights {11}, got %v", out)
+	}
+	ctxt := make(contextForTesting)
+	if err := runAction(remapped, ctxt); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := contextForTesting{
+		11: {
+			C:   gohue.NewMaybeColor(gohue.Red),
+			Bri: someBrightness,
+			On:  maybe.NewBool(true),
+		},
+		2: {
+			C:   gohue.NewMaybeColor(gohue.Blue),
+			Bri: someBrightness,
+			On:  maybe.NewBool(true),
+		},
+	}
+	if !reflect.DeepEqual(expected, ctxt) {
+		t.Errorf("Expected %v, got %v", expected, ctxt)
+	}
+}
+
+func TestRemapLightsReader(t *testing.T) {
+	ctxt := readWriteContextForTesting{contextForTesting{
+		11: {Bri: maybe.NewUint8(100), On: maybe.NewBool(true)},
+	}}
+	task := &ops.HueTask{HueAction: ops.AdjustBrightness(10)}
+	remapped := ops.RemapLights(task, lights.Map{1: 11})
+	if err := runActionOn(remapped, ctxt, lights.New(11)); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if out := ctxt.contextForTesting[11].Bri; out != maybe.NewUint8(126) {
+		t.Errorf("Expected 126, got %v", out)
+	}
+}
+
+func TestRemapLightsForwards(t *testing.T) {
+	assertForwards(t, func(ctxt ops.Context) ops.Context {
+		return remappedContext(ctxt, lights.Map{})
+	})
+}
+
+func TestRemapLightsForwardedIds(t *testing.T) {
+	lightMap := lights.Map{1: 11}
+	state := ops.LightState{On: true, Brightness: maybe.NewUint8(200)}
+	reader := remappedContext(stateContext{11: state}, lightMap)
+	states, err := ops.SnapshotStates(
+		reader.(ops.LightReader), lights.New(1))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if expected := (ops.LightStates{1: state}); !reflect.DeepEqual(
+		expected, states) {
+		t.Errorf("Expected %v, got %v", expected, states)
+	}
+	restored := make(stateContext)
+	if err := ops.RestoreStates(
+		remappedContext(restored, lightMap), states); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if _, ok := restored[11]; !ok || len(restored) != 1 {
+		t.Errorf("Expected light 11 restored, got %v", restored)
+	}
+	alertCtxt := &alertContextForTesting{
+		readWriteContextForTesting: readWriteContextForTesting{
+			make(contextForTesting)},
+	}
+	if _, err := remappedContext(alertCtxt, lightMap).(ops.AlertContext).Alert(
+		1, ops.AlertSelect); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if expected := []string{"11:select"}; !reflect.DeepEqual(
+		expected, alertCtxt.alerts) {
+		t.Errorf("Expected %v, got %v", expected, alertCtxt.alerts)
+	}
+	sceneCtxt := &sceneContextForTesting{
+		contextForTesting: make(contextForTesting),
+		scenes: ops.SceneList{
+			{Id: "1", Name: "Bright", Lights: lights.New(3, 11)}},
+	}
+	scenes, err := ops.Scenes(remappedContext(sceneCtxt, lightMap))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	// Virtual lights 1 and 11 both map to physical light 11.
+	expectedScenes := ops.SceneList{
+		{Id: "1", Name: "Bright", Lights: lights.New(1, 3, 11)}}
+	if !reflect.DeepEqual(expectedScenes, scenes) {
+		t.Errorf("Expected %v, got %v", expectedScenes, scenes)
+	}
+	if out := sceneCtxt.scenes[0].Lights; !reflect.DeepEqual(
+		lights.New(3, 11), out) {
+		t.Errorf("Expected original scene unchanged, got %v", out)
+	}
+}
+
+// remappedContext returns the Context that the HueAction of a task
+// remapped with lightMap sees when run with ctxt.
+func remappedContext(ctxt ops.Context, lightMap lights.Map) ops.Context {
+	var result ops.Context
+	capture := &ops.HueTask{HueAction: captureAction{&result}}
+	remapped := ops.RemapLights(capture, lightMap)
+	remapped.Do(ctxt, lights.All, nil)
+	return result
+}
+
+// captureAction stores the Context passed to Do in ctxt.
+type captureAction struct {
+	ctxt *ops.Context
+}
+
+func (a captureAction) Do(
+	ctxt ops.Context, lightSet lights.Set, e *tasks.Execution) {
+	*a.ctxt = ctxt
+}
+
+func (a captureAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
@@ -0,0 +1,243 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"github.com/keep94/gohue"
+	"time"
+)
+
+// RetryPolicy controls how a Context returned from NewRetryContext retries
+// failed calls to the hue bridge.
+type RetryPolicy struct {
+
+	// The maximum number of attempts including the first one. 0 or
+	// negative means 1.
+	MaxAttempts int
+
+	// The delay before the first retry.
+	InitialBackoff time.Duration
+
+	// The maximum delay between retries. 0 or negative means no maximum.
+	MaxBackoff time.Duration
+
+	// Each delay is this many times longer than the previous one.
+	// Values less than 1.0 mean 2.0.
+	Multiplier float64
+
+	// IsTransient returns true if a failed call is worth retrying.
+	// response and err are what the failed call returned.
+	// If nil, only calls that failed to communicate with the hue bridge are
+	// retried.
+	IsTransient func(response []byte, err error) bool
+}
+
+// RetryError is the error that a Context returned from NewRetryContext
+// reports when all of its attempts fail.
+type RetryError struct {
+
+	// The light id
+	LightId int
+
+	// The number of attempts made
+	Attempts int
+
+	// The raw response from the last attempt
+	RawResponse []byte
+
+	// The error from the last attempt
+	Err error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf(
+		"ops: light %d failed after %d attempts: %v",
+		e.LightId, e.Attempts, FixError(e.LightId, e.RawResponse, e.Err))
+}
+
+// Unwrap returns the error from the last attempt.
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// NewRetryContext returns a Context that works like ctxt except that it
+// retries failed calls with exponential backoff according to policy.
+// The returned Context implements every optional Context interface.
+// Calls to an optional interface that ctxt lacks report an
+// *UnsupportedError right away.
+// When a call fails with an error that is not transient, the returned
+// Context reports that error right away. When all attempts fail,
+// the returned Context reports a *RetryError with a nil response.
+// The returned Context implements CancelableContext. Once canceled, it
+// stops retrying and reports the error of the context.Context.
+func NewRetryContext(ctxt Context, policy *RetryPolicy) Context {
+	return newRetryContext(ctxt, policy, context.Background())
+}
+
+func newRetryContext(
+	ctxt Context, policy *RetryPolicy, ctx context.Context) Context {
+	return &retryContext{ctxt: ctxt, policy: *policy, ctx: ctx}
+}
+
+type retryContext struct {
+	ctxt   Context
+	policy RetryPolicy
+	ctx    context.Context
+}
+
+func (r *retryContext) WithContext(ctx context.Context) Context {
+	return newRetryContext(withContext(r.ctxt, ctx), &r.policy, ctx)
+}
+
+func (r *retryContext) Set(
+	lightId int, properties *gohue.LightProperties) (
+	response []byte, err error) {
+	err = r.retry(lightId, func() ([]byte, error) {
+		response, err = r.ctxt.Set(lightId, properties)
+		return response, err
+	})
+	if _, ok := err.(*RetryError); ok {
+		response = nil
+	}
+	return
+}
+
+func (r *retryContext) retry(
+	lightId int, f func() ([]byte, error)) error {
+	maxAttempts := r.policy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	multiplier := r.policy.Multiplier
+	if multiplier < 1.0 {
+		multiplier = 2.0
+	}
+	isTransient := r.policy.IsTransient
+	if isTransient == nil {
+		isTransient = isCommunicationError
+	}
+	backoff := r.policy.InitialBackoff
+	var response []byte
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			if !sleepContext(r.ctx, backoff) {
+				return r.ctx.Err()
+			}
+			backoff = time.Duration(float64(backoff) * multiplier)
+			if r.policy.MaxBackoff > 0 && backoff > r.policy.MaxBackoff {
+				backoff = r.policy.MaxBackoff
+			}
+		}
+		response, err = f()
+		if err == nil || !isTransient(response, err) {
+			return err
+		}
+	}
+	return &RetryError{
+		LightId:     lightId,
+		Attempts:    maxAttempts,
+		RawResponse: response,
+		Err:         err,
+	}
+}
+
+func (r *retryContext) Get(lightId int) (
+	properties *gohue.LightProperties, response []byte, err error) {
+	reader, err := asLightReader(r.ctxt)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = r.retry(lightId, func() ([]byte, error) {
+		properties, response, err = reader.Get(lightId)
+		return response, err
+	})
+	if _, ok := err.(*RetryError); ok {
+		properties, response = nil, nil
+	}
+	return
+}
+
+func (r *retryContext) GetState(lightId int) (
+	state *LightState, response []byte, err error) {
+	reader, err := asLightStateReader(r.ctxt)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = r.retry(lightId, func() ([]byte, error) {
+		state, response, err = reader.GetState(lightId)
+		return response, err
+	})
+	if _, ok := err.(*RetryError); ok {
+		state, response = nil, nil
+	}
+	return
+}
+
+func (r *retryContext) SetState(
+	lightId int, state *LightState) (response []byte, err error) {
+	writer, err := asLightStateWriter(r.ctxt)
+	if err != nil {
+		return nil, err
+	}
+	err = r.retry(lightId, func() ([]byte, error) {
+		response, err = writer.SetState(lightId, state)
+		return response, err
+	})
+	if _, ok := err.(*RetryError); ok {
+		response = nil
+	}
+	return
+}
+
+func (r *retryContext) Alert(
+	lightId int, alert string) (response []byte, err error) {
+	alerter, err := asAlertContext(r.ctxt)
+	if err != nil {
+		return nil, err
+	}
+	err = r.retry(lightId, func() ([]byte, error) {
+		response, err = alerter.Alert(lightId, alert)
+		return response, err
+	})
+	if _, ok := err.(*RetryError); ok {
+		response = nil
+	}
+	return
+}
+
+func (r *retryContext) RecallScene(
+	sceneId string) (response []byte, err error) {
+	sceneCtxt, err := asSceneContext(r.ctxt)
+	if err != nil {
+		return nil, err
+	}
+	err = r.retry(0, func() ([]byte, error) {
+		response, err = sceneCtxt.RecallScene(sceneId)
+		return response, err
+	})
+	if _, ok := err.(*RetryError); ok {
+		response = nil
+	}
+	return
+}
+
+func (r *retryContext) Scenes() (
+	scenes SceneList, response []byte, err error) {
+	sceneCtxt, err := asSceneContext(r.ctxt)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = r.retry(0, func() ([]byte, error) {
+		scenes, response, err = sceneCtxt.Scenes()
+		return response, err
+	})
+	if _, ok := err.(*RetryError); ok {
+		scenes, response = nil, nil
+	}
+	return
+}
+
+func isCommunicationError(response []byte, err error) bool {
+	return len(response) == 0 && err != gohue.NoSuchResourceError
+}
@@ -0,0 +1,111 @@
+package ops_test
+
+import (
+	"errors"
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/maybe"
+	"testing"
+)
+
+var (
+	kNetworkError = errors.New("ops: network down")
+)
+
+func TestRetryContext(t *testing.T) {
+	ctxt := &flakyContext{failures: 2, err: kNetworkError}
+	retryCtxt := ops.NewRetryContext(
+		ctxt, &ops.RetryPolicy{MaxAttempts: 3})
+	if _, ok := retryCtxt.(ops.LightReader); !ok {
+		t.Error("Expected retry context to implement LightReader.")
+	}
+	properties := &gohue.LightProperties{Bri: maybe.NewUint8(37)}
+	if _, err := retryCtxt.Set(3, properties); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if ctxt.calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", ctxt.calls)
+	}
+	ctxt = &flakyContext{failures: 2, err: kNetworkError}
+	retryCtxt = ops.NewRetryContext(
+		ctxt, &ops.RetryPolicy{MaxAttempts: 3})
+	if _, _, err := retryCtxt.(ops.LightReader).Get(3); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestRetryContextExhausted(t *testing.T) {
+	ctxt := &flakyContext{failures: 5, err: kNetworkError}
+	retryCtxt := ops.NewRetryContext(
+		ctxt, &ops.RetryPolicy{MaxAttempts: 2})
+	response, err := retryCtxt.Set(4, &gohue.LightProperties{})
+	retryErr, ok := err.(*ops.RetryError)
+	if !ok {
+		t.Fatalf("Expected RetryError, got %v", err)
+	}
+	if response != nil {
+		t.Error("Expected nil response")
+	}
+	if retryErr.LightId != 4 || retryErr.Attempts != 2 {
+		t.Errorf("Expected light 4 and 2 attempts, got %v", retryErr)
+	}
+	if !errors.Is(err, kNetworkError) {
+		t.Error("Expected RetryError to wrap last error")
+	}
+	if ctxt.calls != 2 {
+		t.Errorf("Expected 2 calls, got %d", ctxt.calls)
+	}
+}
+
+func TestRetryContextNotTransient(t *testing.T) {
+	ctxt := &flakyContext{
+		failures: 5,
+		err:      gohue.NoSuchResourceError,
+		response: []byte("No such light")}
+	retryCtxt := ops.NewRetryContext(
+		ctxt, &ops.RetryPolicy{MaxAttempts: 4})
+	if _, err := retryCtxt.Set(4, &gohue.LightProperties{}); err != gohue.NoSuchResourceError {
+		t.Errorf("Expected NoSuchResourceError, got %v", err)
+	}
+	if ctxt.calls != 1 {
+		t.Errorf("Expected 1 call, got %d", ctxt.calls)
+	}
+}
+
+func TestRetryContextNoReader(t *testing.T) {
+	retryCtxt := ops.NewRetryContext(
+		make(contextForTesting), &ops.RetryPolicy{MaxAttempts: 3})
+	_, _, err := retryCtxt.(ops.LightReader).Get(1)
+	assertUnsupported(t, "LightReader", err)
+}
+
+func TestRetryContextForwards(t *testing.T) {
+	assertForwards(t, func(ctxt ops.Context) ops.Context {
+		return ops.NewRetryContext(ctxt, &ops.RetryPolicy{MaxAttempts: 3})
+	})
+}
+
+type flakyContext struct {
+	failures int
+	err      error
+	response []byte
+	calls    int
+}
+
+func (f *flakyContext) Set(
+	lightId int, properties *gohue.LightProperties) ([]byte, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return f.response, f.err
+	}
+	return []byte("ok"), nil
+}
+
+func (f *flakyContext) Get(lightId int) (
+	*gohue.LightProperties, []byte, error) {
+	response, err := f.Set(lightId, nil)
+	if err != nil {
+		return nil, response, err
+	}
+	return &gohue.LightProperties{}, response, nil
+}
@@ -0,0 +1,116 @@
+package ops
+
+import (
+	"errors"
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/tasks"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrNoSuchScene is reported when recalling a scene the hue bridge
+	// does not have.
+	ErrNoSuchScene = errors.New("ops: No such scene.")
+)
+
+// Scene represents a scene stored on the hue bridge such as one defined
+// in the official hue app.
+type Scene struct {
+	// The scene Id that the bridge assigns
+	Id string
+
+	// The name of the scene
+	Name string
+
+	// The lights that the scene sets
+	Lights lights.Set
+}
+
+// SceneList represents a list of scenes.
+// These instances must be treated as immutable.
+type SceneList []*Scene
+
+// SortByName sorts this list by name ignoring case.
+func (l SceneList) SortByName() {
+	sort.SliceStable(l, func(i, j int) bool {
+		return strings.ToLower(l[i].Name) < strings.ToLower(l[j].Name)
+	})
+}
+
+// Interface SceneContext recalls and lists scenes stored on the hue bridge.
+// Context implementations that support scenes should implement this
+// interface.
+type SceneContext interface {
+	Context
+
+	// RecallScene recalls the scene with given Id.
+	RecallScene(sceneId string) (response []byte, err error)
+
+	// Scenes returns the scenes stored on the hue bridge.
+	Scenes() (scenes SceneList, response []byte, err error)
+}
+
+// Scenes returns the scenes stored on the hue bridge sorted by name.
+// If ctxt does not implement SceneContext or its Scenes method reports an
+// *UnsupportedError, Scenes returns an empty list.
+func Scenes(ctxt Context) (SceneList, error) {
+	sceneCtxt, ok := ctxt.(SceneContext)
+	if !ok {
+		return nil, nil
+	}
+	scenes, response, err := sceneCtxt.Scenes()
+	if isUnsupported(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fixSceneError(response, err)
+	}
+	result := make(SceneList, len(scenes))
+	copy(result, scenes)
+	result.SortByName()
+	return result, nil
+}
+
+// RecallSceneAction recalls a scene stored on the hue bridge. Since
+// the bridge decides the state of each light in the scene, a
+// RecallSceneAction always uses the lights in the scene regardless of the
+// lights it is run on. RecallSceneAction needs a Context that implements
+// SceneContext and reports an *UnsupportedError without one.
+type RecallSceneAction struct {
+	Scene *Scene
+}
+
+func (a RecallSceneAction) Do(
+	ctxt Context, lightSet lights.Set, e *tasks.Execution) {
+	sceneCtxt, ok := ctxt.(SceneContext)
+	if !ok {
+		e.SetError(&UnsupportedError{Interface: "SceneContext"})
+		return
+	}
+	response, err := sceneCtxt.RecallScene(a.Scene.Id)
+	if err != nil {
+		e.SetError(fixSceneError(response, err))
+	}
+}
+
+func (a RecallSceneAction) UsedLights(lightSet lights.Set) lights.Set {
+	return a.Scene.Lights
+}
+
+// AsHueTask returns a HueTask that recalls this scene.
+func (s *Scene) AsHueTask(id int) *HueTask {
+	return &HueTask{
+		Id:          id,
+		HueAction:   RecallSceneAction{Scene: s},
+		Description: s.Name,
+	}
+}
+
+func fixSceneError(rawResponse []byte, err error) error {
+	if err == gohue.NoSuchResourceError {
+		return ErrNoSuchScene
+	}
+	return FixError(0, rawResponse, err)
+}
@@ -0,0 +1,98 @@
+package ops_test
+
+import (
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/tasks"
+	"reflect"
+	"testing"
+)
+
+func TestRecallSceneAction(t *testing.T) {
+	scene := &ops.Scene{Id: "abc", Name: "Relax", Lights: lights.New(1, 2)}
+	ctxt := &sceneContextForTesting{contextForTesting: make(contextForTesting)}
+	task := scene.AsHueTask(20001)
+	if task.Description != "Relax" {
+		t.Errorf("Expected Relax, got %s", task.Description)
+	}
+	err := tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		task.Do(ctxt, task.UsedLights(lights.New(5)), e)
+	}))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if !reflect.DeepEqual([]string{"abc"}, ctxt.recalled) {
+		t.Errorf("Expected abc recalled, got %v", ctxt.recalled)
+	}
+	if out := task.UsedLights(lights.New(5)); !reflect.DeepEqual(
+		lights.New(1, 2), out) {
+		t.Errorf("Expected {1, 2}, got %v", out)
+	}
+	missing := &ops.Scene{Id: "missing"}
+	err = tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		ops.RecallSceneAction{Scene: missing}.Do(ctxt, lights.All, e)
+	}))
+	if err != ops.ErrNoSuchScene {
+		t.Errorf("Expected ErrNoSuchScene, got %v", err)
+	}
+}
+
+func TestRecallSceneActionNoSceneContext(t *testing.T) {
+	scene := &ops.Scene{Id: "abc", Lights: lights.New(1)}
+	err := tasks.Run(tasks.TaskFunc(func(e *tasks.Execution) {
+		ops.RecallSceneAction{Scene: scene}.Do(
+			make(contextForTesting), lights.All, e)
+	}))
+	if unsupported, ok := err.(*ops.UnsupportedError); !ok ||
+		unsupported.Interface != "SceneContext" {
+		t.Errorf("Expected UnsupportedError, got %v", err)
+	}
+}
+
+func TestScenes(t *testing.T) {
+	ctxt := &sceneContextForTesting{
+		contextForTesting: make(contextForTesting),
+		scenes: ops.SceneList{
+			{Id: "2", Name: "relax"},
+			{Id: "1", Name: "Bright"},
+		},
+	}
+	scenes, err := ops.Scenes(ctxt)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := ops.SceneList{
+		{Id: "1", Name: "Bright"},
+		{Id: "2", Name: "relax"},
+	}
+	if !reflect.DeepEqual(expected, scenes) {
+		t.Errorf("Expected %v, got %v", expected, scenes)
+	}
+	if ctxt.scenes[0].Id != "2" {
+		t.Error("Expected original list to be unchanged.")
+	}
+	scenes, err = ops.Scenes(make(contextForTesting))
+	if err != nil || len(scenes) != 0 {
+		t.Errorf("Expected no scenes, got %v, %v", scenes, err)
+	}
+}
+
+type sceneContextForTesting struct {
+	contextForTesting
+	scenes   ops.SceneList
+	recalled []string
+}
+
+func (c *sceneContextForTesting) RecallScene(sceneId string) (
+	[]byte, error) {
+	if sceneId == "missing" {
+		return nil, gohue.NoSuchResourceError
+	}
+	c.recalled = append(c.recalled, sceneId)
+	return nil, nil
+}
+
+func (c *sceneContextForTesting) Scenes() (ops.SceneList, []byte, error) {
+	return c.scenes, nil, nil
+}
@@ -0,0 +1,181 @@
+package ops
+
+import (
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/maybe"
+	"github.com/keep94/tasks"
+	"time"
+)
+
+const (
+	// ColorModeXY means the light shows its xy color.
+	ColorModeXY = "xy"
+
+	// ColorModeCT means the light shows its color temperature.
+	ColorModeCT = "ct"
+
+	// EffectNone means the light is running no effect.
+	EffectNone = "none"
+
+	// EffectColorLoop means the light cycles through all hues.
+	EffectColorLoop = "colorloop"
+)
+
+// LightState represents the full state of a single light.
+type LightState struct {
+
+	// True if the light is on.
+	On bool
+
+	// The xy color of the light.
+	Color gohue.MaybeColor
+
+	// The brightness of the light.
+	Brightness maybe.Uint8
+
+	// The color temperature of the light in mireds. Nothing means the
+	// light doesn't support color temperature.
+	Ct maybe.Uint16
+
+	// The color mode of the light e.g ColorModeXY or ColorModeCT.
+	// Empty means ColorModeXY.
+	ColorMode string
+
+	// The effect the light is running e.g EffectColorLoop.
+	// Empty means EffectNone.
+	Effect string
+
+	// The transition time in multiples of 100ms to use when restoring this
+	// state. Nothing means 400ms.
+	TransitionTime maybe.Uint16
+}
+
+// ColorBrightness returns the color and brightness of this state in the
+// form that StaticHueAction uses. A light that is off has no color and
+// no brightness.
+func (s *LightState) ColorBrightness() ColorBrightness {
+	if !s.On {
+		return ColorBrightness{}
+	}
+	return ColorBrightness{Color: s.Color, Brightness: s.Brightness}
+}
+
+// LightStates represents the full state of multiple lights keyed by
+// light id. These instances must be treated as immutable.
+type LightStates map[int]LightState
+
+// Colors returns the color and brightness of each light in this instance.
+func (s LightStates) Colors() LightColors {
+	result := make(LightColors, len(s))
+	for id, state := range s {
+		result[id] = state.ColorBrightness()
+	}
+	return result
+}
+
+// StatesHueAction sets each light to its full state using RestoreStates.
+// Unlike StaticHueAction, StatesHueAction always uses the lights it has
+// states for regardless of the lights it is run on.
+// These instances must be treated as immutable.
+type StatesHueAction LightStates
+
+func (a StatesHueAction) Do(
+	ctxt Context, lightSet lights.Set, e *tasks.Execution) {
+	if err := RestoreStates(ctxt, LightStates(a)); err != nil {
+		e.SetError(err)
+	}
+}
+
+func (a StatesHueAction) UsedLights(lightSet lights.Set) lights.Set {
+	result := make(lights.Set, len(a))
+	for id := range a {
+		result[id] = true
+	}
+	return result
+}
+
+// Interface LightStateReader reads the full state of a light.
+// Context implementations that can read color temperature and effects
+// should implement this interface.
+type LightStateReader interface {
+	GetState(lightId int) (*LightState, []byte, error)
+}
+
+// Interface LightStateWriter sets the full state of a light.
+// Context implementations that can set color temperature and effects
+// should implement this interface.
+type LightStateWriter interface {
+	SetState(lightId int, state *LightState) ([]byte, error)
+}
+
+// SnapshotStates works like Snapshot except that it captures the full
+// state of each light. If reader does not implement LightStateReader or
+// its GetState reports an *UnsupportedError, SnapshotStates captures only
+// the on/off state, color, and brightness.
+func SnapshotStates(
+	reader LightReader, lightSet lights.Set) (LightStates, error) {
+	stateReader, hasStates := reader.(LightStateReader)
+	result := make(LightStates, len(lightSet))
+	for lightId, valid := range lightSet {
+		if !valid {
+			continue
+		}
+		if hasStates {
+			state, response, err := stateReader.GetState(lightId)
+			if err == nil {
+				result[lightId] = *state
+				continue
+			}
+			if !isUnsupported(err) {
+				return nil, FixError(lightId, response, err)
+			}
+			hasStates = false
+		}
+		properties, response, err := reader.Get(lightId)
+		if err != nil {
+			return nil, FixError(lightId, response, err)
+		}
+		result[lightId] = LightState{
+			On:         properties.On.Value,
+			Color:      properties.C,
+			Brightness: properties.Bri,
+		}
+	}
+	return result, nil
+}
+
+// RestoreStates works like Restore except that it restores the full
+// state of each light as returned by SnapshotStates. If ctxt does not
+// implement LightStateWriter or its SetState reports an *UnsupportedError,
+// RestoreStates restores only the on/off state, color, and brightness.
+func RestoreStates(ctxt Context, states LightStates) error {
+	stateWriter, hasStates := ctxt.(LightStateWriter)
+	for id := range states {
+		state := states[id]
+		if !state.TransitionTime.Valid {
+			// use 400ms fade in
+			state.TransitionTime = maybe.NewUint16(4)
+		}
+		var response []byte
+		var err error
+		if hasStates {
+			response, err = stateWriter.SetState(id, &state)
+			if isUnsupported(err) {
+				hasStates = false
+			}
+		}
+		if !hasStates {
+			response, err = ctxt.Set(
+				id,
+				colorBrightnessToLightPropertiesWithTransition(
+					state.ColorBrightness(), state.TransitionTime))
+		}
+		if err != nil {
+			return FixError(id, response, err)
+		}
+	}
+	// Wait 500ms for fade in to take effect
+	time.Sleep(500 * time.Millisecond)
+	return nil
+}
@@ -0,0 +1,149 @@
+package ops_test
+
+import (
+	"github.com/keep94/gohue"
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/maybe"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotRestoreStates(t *testing.T) {
+	ctxt := stateContext{
+		2: {
+			On:         true,
+			Brightness: maybe.NewUint8(200),
+			Ct:         maybe.NewUint16(366),
+			ColorMode:  ops.ColorModeCT,
+		},
+		3: {
+			On:         true,
+			Color:      gohue.NewMaybeColor(gohue.Red),
+			Brightness: maybe.NewUint8(100),
+			Effect:     ops.EffectColorLoop,
+		},
+		4: {},
+	}
+	states, err := ops.SnapshotStates(ctxt, lights.New(2, 3))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := ops.LightStates{2: ctxt[2], 3: ctxt[3]}
+	if !reflect.DeepEqual(expected, states) {
+		t.Errorf("Expected %v, got %v", expected, states)
+	}
+	restored := make(stateContext)
+	if err := ops.RestoreStates(restored, states); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	for id, state := range expected {
+		state.TransitionTime = maybe.NewUint16(4)
+		expected[id] = state
+	}
+	if !reflect.DeepEqual(stateContext(expected), restored) {
+		t.Errorf("Expected %v, got %v", expected, restored)
+	}
+}
+
+func TestSnapshotRestoreStatesFallback(t *testing.T) {
+	reader := readerForTesting{
+		5: {
+			C:   gohue.NewMaybeColor(gohue.Blue),
+			Bri: maybe.NewUint8(50),
+			On:  maybe.NewBool(true),
+		},
+		6: {
+			C:   gohue.NewMaybeColor(gohue.Blue),
+			Bri: maybe.NewUint8(50),
+			On:  maybe.NewBool(false),
+		},
+	}
+	states, err := ops.SnapshotStates(reader, lights.New(5, 6))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expectedColors := ops.LightColors{
+		5: {gohue.NewMaybeColor(gohue.Blue), maybe.NewUint8(50)},
+		6: {},
+	}
+	if out := states.Colors(); !reflect.DeepEqual(expectedColors, out) {
+		t.Errorf("Expected %v, got %v", expectedColors, out)
+	}
+	ctxt := make(contextForTesting)
+	if err := ops.RestoreStates(ctxt, states); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := contextForTesting{
+		5: {
+			C:              gohue.NewMaybeColor(gohue.Blue),
+			Bri:            maybe.NewUint8(50),
+			On:             maybe.NewBool(true),
+			TransitionTime: maybe.NewUint16(4),
+		},
+		6: {On: maybe.NewBool(false), TransitionTime: maybe.NewUint16(4)},
+	}
+	if !reflect.DeepEqual(expected, ctxt) {
+		t.Errorf("Expected %v, got %v", expected, ctxt)
+	}
+}
+
+func TestStatesHueAction(t *testing.T) {
+	action := ops.StatesHueAction{
+		2: {
+			On:             true,
+			Brightness:     maybe.NewUint8(200),
+			Ct:             maybe.NewUint16(366),
+			ColorMode:      ops.ColorModeCT,
+			TransitionTime: maybe.NewUint16(0),
+		},
+		5: {TransitionTime: maybe.NewUint16(0)},
+	}
+	if out := action.UsedLights(lights.New(1)); !reflect.DeepEqual(lights.New(2, 5), out) {
+		t.Errorf("Expected lights 2,5, got %v", out)
+	}
+	ctxt := make(stateContext)
+	if err := runAction(action, ctxt); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if !reflect.DeepEqual(stateContext(action), ctxt) {
+		t.Errorf("Expected %v, got %v", action, ctxt)
+	}
+}
+
+type readerForTesting map[int]*gohue.LightProperties
+
+func (r readerForTesting) Get(lightId int) (
+	*gohue.LightProperties, []byte, error) {
+	properties, ok := r[lightId]
+	if !ok {
+		return nil, nil, gohue.NoSuchResourceError
+	}
+	return properties, nil, nil
+}
+
+type stateContext map[int]ops.LightState
+
+func (s stateContext) Set(
+	lightId int, properties *gohue.LightProperties) ([]byte, error) {
+	panic("Set not expected")
+}
+
+func (s stateContext) Get(lightId int) (
+	*gohue.LightProperties, []byte, error) {
+	panic("Get not expected")
+}
+
+func (s stateContext) GetState(lightId int) (*ops.LightState, []byte, error) {
+	state, ok := s[lightId]
+	if !ok {
+		return nil, nil, gohue.NoSuchResourceError
+	}
+	return &state, nil, nil
+}
+
+func (s stateContext) SetState(
+	lightId int, state *ops.LightState) ([]byte, error) {
+	s[lightId] = *state
+	return nil, nil
+}
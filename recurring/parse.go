@@ -0,0 +1,120 @@
+package recurring
+
+import (
+	"errors"
+	tasks_recurring "github.com/keep94/tasks/recurring"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Indicates that a spec passed to Parse is malformed.
+	ErrBadSpec = errors.New("recurring: Bad spec.")
+)
+
+var (
+	kDays = map[string]tasks_recurring.DaysOfWeek{
+		"sun":      tasks_recurring.Sunday,
+		"mon":      tasks_recurring.Monday,
+		"tue":      tasks_recurring.Tuesday,
+		"wed":      tasks_recurring.Wednesday,
+		"thu":      tasks_recurring.Thursday,
+		"fri":      tasks_recurring.Friday,
+		"sat":      tasks_recurring.Saturday,
+		"weekdays": tasks_recurring.Weekdays,
+		"weekend":  tasks_recurring.Weekend,
+	}
+)
+
+// Parse converts a spec into a recurring time so that recurring times
+// can be persisted as strings. Parse understands these forms:
+//
+//	hourly                      each hour on the hour
+//	HH:MM                       each day at HH:MM
+//	HH:MM DAYS                  at HH:MM on DAYS
+//	sunset LAT,LON              each sunset at LAT,LON
+//	sunset LAT,LON before HH:MM each sunset at LAT,LON but no later than HH:MM
+//
+// DAYS is a comma separated list of sun, mon, tue, wed, thu, fri, sat,
+// weekdays, and weekend. Hours use the 24 hour clock.
+func Parse(spec string) (tasks_recurring.R, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, ErrBadSpec
+	}
+	switch {
+	case len(fields) == 1 && fields[0] == "hourly":
+		return tasks_recurring.OnTheHour(), nil
+	case fields[0] == "sunset":
+		return parseSunset(fields[1:])
+	case len(fields) <= 2:
+		return parseAtTime(fields)
+	}
+	return nil, ErrBadSpec
+}
+
+func parseAtTime(fields []string) (tasks_recurring.R, error) {
+	hour, min, err := parseHourMinute(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	r := tasks_recurring.AtTime(hour, min)
+	if len(fields) == 1 {
+		return r, nil
+	}
+	var days tasks_recurring.DaysOfWeek
+	for _, name := range strings.Split(fields[1], ",") {
+		day, ok := kDays[name]
+		if !ok {
+			return nil, ErrBadSpec
+		}
+		days |= day
+	}
+	return tasks_recurring.Filter(r, tasks_recurring.OnDays(days)), nil
+}
+
+func parseSunset(fields []string) (tasks_recurring.R, error) {
+	if len(fields) != 1 && len(fields) != 3 {
+		return nil, ErrBadSpec
+	}
+	latLon := strings.Split(fields[0], ",")
+	if len(latLon) != 2 {
+		return nil, ErrBadSpec
+	}
+	lat, err := strconv.ParseFloat(latLon[0], 64)
+	if err != nil || lat < -90.0 || lat > 90.0 {
+		return nil, ErrBadSpec
+	}
+	lon, err := strconv.ParseFloat(latLon[1], 64)
+	if err != nil || lon < -180.0 || lon > 180.0 {
+		return nil, ErrBadSpec
+	}
+	r := EachSunset(lat, lon)
+	if len(fields) == 1 {
+		return r, nil
+	}
+	if fields[1] != "before" {
+		return nil, ErrBadSpec
+	}
+	hour, min, err := parseHourMinute(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	return OnOrBefore(r, hour, min), nil
+}
+
+func parseHourMinute(s string) (hour, min int, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, 0, ErrBadSpec
+	}
+	hour, err = strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, 0, ErrBadSpec
+	}
+	min, err = strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 2 || min < 0 || min > 59 {
+		return 0, 0, ErrBadSpec
+	}
+	return hour, min, nil
+}
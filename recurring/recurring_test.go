@@ -123,6 +123,74 @@ func TestOnOrBefore6(t *testing.T) {
 	verifyTime(t, time.Date(2013, 10, 25, 21, 14, 35, 451, kLocation), atime)
 }
 
+func TestParse(t *testing.T) {
+	startTime := time.Date(2013, 10, 24, 21, 13, 0, 0, kLocation)
+	var atime time.Time
+
+	r, err := recurring.Parse("7:05")
+	if err != nil {
+		t.Fatalf("Got error parsing: %v", err)
+	}
+	stream := r.ForTime(startTime)
+	stream.Next(&atime)
+	verifyTime(t, time.Date(2013, 10, 25, 7, 5, 0, 0, kLocation), atime)
+
+	// Oct 24, 2013 is a Thursday
+	r, err = recurring.Parse("7:05 mon,sat")
+	if err != nil {
+		t.Fatalf("Got error parsing: %v", err)
+	}
+	stream = r.ForTime(startTime)
+	stream.Next(&atime)
+	verifyTime(t, time.Date(2013, 10, 26, 7, 5, 0, 0, kLocation), atime)
+	stream.Next(&atime)
+	verifyTime(t, time.Date(2013, 10, 28, 7, 5, 0, 0, kLocation), atime)
+
+	r, err = recurring.Parse("hourly")
+	if err != nil {
+		t.Fatalf("Got error parsing: %v", err)
+	}
+	stream = r.ForTime(startTime)
+	stream.Next(&atime)
+	verifyTime(t, time.Date(2013, 10, 24, 22, 0, 0, 0, kLocation), atime)
+
+	r, err = recurring.Parse("sunset 40,-120")
+	if err != nil {
+		t.Fatalf("Got error parsing: %v", err)
+	}
+	stream = r.ForTime(time.Date(2013, 1, 7, 16, 51, 0, 0, kLocation))
+	stream.Next(&atime)
+	verifyTime(t, time.Date(2013, 1, 7, 16, 51, 59, 0, kLocation), atime)
+
+	r, err = recurring.Parse("sunset 40,-120 before 16:30")
+	if err != nil {
+		t.Fatalf("Got error parsing: %v", err)
+	}
+	stream = r.ForTime(time.Date(2013, 1, 7, 12, 0, 0, 0, kLocation))
+	stream.Next(&atime)
+	verifyTime(t, time.Date(2013, 1, 7, 16, 30, 0, 0, kLocation), atime)
+}
+
+func TestParseErrors(t *testing.T) {
+	specs := []string{
+		"",
+		"24:00",
+		"7:5",
+		"7:05 someday",
+		"7:05 mon extra",
+		"sunset",
+		"sunset 40",
+		"sunset 91,0",
+		"sunset 40,-120 after 16:30",
+		"hourly now",
+	}
+	for _, spec := range specs {
+		if _, err := recurring.Parse(spec); err != recurring.ErrBadSpec {
+			t.Errorf("Expected ErrBadSpec for %q, got %v", spec, err)
+		}
+	}
+}
+
 func verifyTime(t *testing.T, expected, actual time.Time) {
 	if expected != actual {
 		t.Errorf("Expected %v, got %v", expected, actual)
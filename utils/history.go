@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/tasks"
+	"sync"
+	"time"
+)
+
+// kHistorySize is how many runs MultiExecutor.History remembers.
+const kHistorySize = 50
+
+// Outcome tells how a run of a hue task ended.
+type Outcome int
+
+const (
+	// The hue task ran to completion.
+	OutcomeFinished Outcome = iota
+
+	// The hue task was interrupted.
+	OutcomeInterrupted
+
+	// The hue task failed.
+	OutcomeError
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeFinished:
+		return "FINISH"
+	case OutcomeInterrupted:
+		return "INTERRUPTED"
+	case OutcomeError:
+		return "ERROR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// TaskRun is one completed run of a hue task.
+type TaskRun struct {
+	// The Id of the hue task.
+	HueTaskId int
+
+	// The description of the hue task.
+	Description string
+
+	// The lights the hue task ran on.
+	Lights lights.Set
+
+	// When the hue task started and ended.
+	Start time.Time
+	End   time.Time
+
+	Outcome Outcome
+
+	// The error when Outcome is OutcomeError.
+	Err error
+}
+
+// runHistory remembers the most recent runs of hue tasks in a ring
+// buffer. runHistory implements Listener.
+type runHistory struct {
+	mu      sync.Mutex
+	clock   tasks.Clock
+	started map[Task]time.Time
+	runs    []TaskRun
+	// index in runs of the next run to add
+	next int
+	full bool
+}
+
+func newRunHistory(size int, clock tasks.Clock) *runHistory {
+	return &runHistory{
+		clock:   clock,
+		started: make(map[Task]time.Time),
+		runs:    make([]TaskRun, size),
+	}
+}
+
+func (h *runHistory) TaskStarted(task Task) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.started[task] = h.clock.Now()
+}
+
+func (h *runHistory) TaskFinished(task Task) {
+	h.add(task, OutcomeFinished, nil)
+}
+
+func (h *runHistory) TaskInterrupted(task Task) {
+	h.add(task, OutcomeInterrupted, nil)
+}
+
+func (h *runHistory) TaskError(task Task, err error) {
+	h.add(task, OutcomeError, err)
+}
+
+func (h *runHistory) add(task Task, outcome Outcome, err error) {
+	end := h.clock.Now()
+	hueTask := task.(*HueTaskWrapper)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	start, ok := h.started[task]
+	if !ok {
+		start = end
+	}
+	delete(h.started, task)
+	h.runs[h.next] = TaskRun{
+		HueTaskId:   hueTask.H.Id,
+		Description: hueTask.H.Description,
+		Lights:      hueTask.Ls,
+		Start:       start,
+		End:         end,
+		Outcome:     outcome,
+		Err:         err,
+	}
+	h.next++
+	if h.next == len(h.runs) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// get returns the remembered runs most recent first.
+func (h *runHistory) get() []TaskRun {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	count := h.next
+	if h.full {
+		count = len(h.runs)
+	}
+	result := make([]TaskRun, count)
+	for i := range result {
+		idx := h.next - 1 - i
+		if idx < 0 {
+			idx += len(h.runs)
+		}
+		result[i] = h.runs[idx]
+	}
+	return result
+}
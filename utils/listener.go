@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"sync"
+)
+
+// Listener observes the execution of tasks so that integrations need not
+// parse log text. MultiExecutor calls TaskStarted when a hue task starts
+// and then exactly one of TaskFinished, TaskInterrupted, or TaskError
+// when it stops. MultiTimer calls TaskStarted when it arms a timer,
+// TaskFinished when the timer fires and hands off its hue task, and
+// TaskInterrupted when the timer is cancelled or was armed after its
+// start time had already passed. task is either a
+// *HueTaskWrapper or a *TimerTaskWrapper. Implementations must be safe to
+// use with multiple goroutines and should return quickly as they run on
+// the goroutine of the task.
+type Listener interface {
+	TaskStarted(task Task)
+	TaskFinished(task Task)
+	TaskInterrupted(task Task)
+	TaskError(task Task, err error)
+}
+
+// listenerList is a list of listeners safe to use with multiple
+// goroutines. A nil listenerList has no listeners.
+type listenerList struct {
+	mu        sync.Mutex
+	listeners []Listener
+}
+
+func (l *listenerList) add(listener Listener) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	listeners := make([]Listener, len(l.listeners), len(l.listeners)+1)
+	copy(listeners, l.listeners)
+	l.listeners = append(listeners, listener)
+}
+
+func (l *listenerList) get() []Listener {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.listeners
+}
+
+func (l *listenerList) started(task Task) {
+	for _, listener := range l.get() {
+		listener.TaskStarted(task)
+	}
+}
+
+func (l *listenerList) finished(task Task) {
+	for _, listener := range l.get() {
+		listener.TaskFinished(task)
+	}
+}
+
+func (l *listenerList) interrupted(task Task) {
+	for _, listener := range l.get() {
+		listener.TaskInterrupted(task)
+	}
+}
+
+func (l *listenerList) failed(task Task, err error) {
+	for _, listener := range l.get() {
+		listener.TaskError(task, err)
+	}
+}
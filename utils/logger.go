@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"github.com/keep94/marvin2/logging"
+	"io"
+	"log"
+)
+
+// Field is a named value that goes with a log message such as a task id
+// or a set of lights.
+type Field = logging.Field
+
+// Logger logs messages along with structured fields. Implementations
+// must be safe to use with multiple goroutines.
+type Logger = logging.Logger
+
+// NewField returns a new Field.
+func NewField(key string, value interface{}) Field {
+	return logging.NewField(key, value)
+}
+
+// StdLogger returns a Logger that writes each message to l as one line
+// of the form "msg: key1=value1 key2=value2". StdLogger returns nil if
+// l is nil.
+func StdLogger(l *log.Logger) Logger {
+	return logging.StdLogger(l)
+}
+
+// JSONLogger returns a Logger that writes each message to w as a JSON
+// object on its own line. See logging.JSONLogger.
+func JSONLogger(w io.Writer) Logger {
+	return logging.JSONLogger(w)
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"github.com/keep94/tasks"
+	"time"
+)
+
+// MisfirePolicy tells what a ScheduledTask does when it is enabled after
+// missing a run, for instance because the process was down.
+type MisfirePolicy int
+
+const (
+	// Missed runs are skipped.
+	MisfireSkip MisfirePolicy = iota
+
+	// If any run was missed, run once right away.
+	MisfireRunOnce
+
+	// Run once right away if the most recent missed run was within
+	// MisfireGrace of enabling.
+	MisfireRunWithinGrace
+)
+
+// FireTimeStore persists when each scheduled task last fired.
+// Implementations must be safe to use with multiple goroutines.
+type FireTimeStore interface {
+	// LastFired returns when the scheduled task with given id last fired.
+	// LastFired returns false if it never fired.
+	LastFired(scheduledTaskId int) (time.Time, bool)
+
+	// SetLastFired records that the scheduled task with given id fired
+	// at t.
+	SetLastFired(scheduledTaskId int, t time.Time)
+}
+
+// Enable runs this scheduled task. If this scheduled task is not already
+// running, Enable first applies the Misfire policy to any run missed
+// since the last time recorded in FireTimes. Set Misfire, MisfireGrace,
+// and FireTimes before calling Enable.
+func (s *ScheduledTask) Enable() {
+	if !s.IsEnabled() {
+		s.handleMisfire(s.clock.Now())
+	}
+	s.BackgroundRunner.Enable()
+}
+
+func (s *ScheduledTask) handleMisfire(now time.Time) {
+	if s.Misfire == MisfireSkip || s.FireTimes == nil || s.once == nil {
+		return
+	}
+	missed, ok := s.lastMissed(now)
+	if !ok {
+		return
+	}
+	if s.Misfire == MisfireRunWithinGrace && now.Sub(missed) > s.MisfireGrace {
+		return
+	}
+	s.FireTimes.SetLastFired(s.Id, now)
+	tasks.Start(s.once)
+}
+
+// lastMissed returns the most recent time at or before now that this
+// scheduled task should have run after it last fired.
+func (s *ScheduledTask) lastMissed(now time.Time) (time.Time, bool) {
+	if s.Times == nil {
+		return time.Time{}, false
+	}
+	lastFired, ok := s.FireTimes.LastFired(s.Id)
+	if !ok {
+		return time.Time{}, false
+	}
+	stream := s.Times.ForTime(lastFired)
+	defer stream.Close()
+	var result time.Time
+	found := false
+	var next time.Time
+	for stream.Next(&next) == nil && !next.After(now) {
+		result = next
+		found = true
+	}
+	return result, found
+}
+
+// firedRecorder records in the FireTimes of a ScheduledTask each time
+// the task it wraps runs.
+type firedRecorder struct {
+	task tasks.Task
+	st   *ScheduledTask
+}
+
+func (f *firedRecorder) Do(e *tasks.Execution) {
+	if f.st.FireTimes != nil {
+		f.st.FireTimes.SetLastFired(f.st.Id, e.Now())
+	}
+	f.task.Do(e)
+}
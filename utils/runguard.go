@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"github.com/keep94/marvin2/lights"
+	"github.com/keep94/marvin2/ops"
+	"github.com/keep94/tasks"
+	"sync"
+	"time"
+)
+
+// RunTimeStore tells when each hue task last ran, for instance from a
+// persisted task run log. Implementations must be safe to use with
+// multiple goroutines.
+type RunTimeStore interface {
+	// LastRun returns when the hue task with given id last started.
+	// LastRun returns false if it never ran.
+	LastRun(hueTaskId int) (time.Time, bool)
+}
+
+// RunGuard keeps hue tasks from running more than once within a window
+// such as when a sunset task gets triggered twice because of clock drift.
+// RunGuard decides before a hue task starts, so a suppressed run never
+// interrupts the tasks already running on its lights. RunGuard keys hue
+// tasks by their Id. RunGuard instances are safe to use with multiple
+// goroutines.
+type RunGuard struct {
+	window time.Duration
+	store  RunTimeStore
+	mu     sync.Mutex
+	starts map[int]time.Time
+}
+
+// NewRunGuard creates a RunGuard that lets a hue task start only if it
+// has not started within window. RunGuard remembers the starts it allows,
+// and it consults store for starts before this process began. If store
+// is nil, RunGuard knows only the starts it allowed itself.
+func NewRunGuard(window time.Duration, store RunTimeStore) *RunGuard {
+	return &RunGuard{
+		window: window, store: store, starts: make(map[int]time.Time)}
+}
+
+// Allow returns true if the hue task with given id may start at now.
+// When Allow returns true, it records that the hue task starts at now.
+func (g *RunGuard) Allow(hueTaskId int, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	last, ok := g.starts[hueTaskId]
+	if g.store != nil {
+		if stored, storedOk := g.store.LastRun(hueTaskId); storedOk && (!ok || stored.After(last)) {
+			last, ok = stored, true
+		}
+	}
+	if ok && now.Sub(last) < g.window {
+		return false
+	}
+	g.starts[hueTaskId] = now
+	return true
+}
+
+// Start starts h on lightSet with te at given priority unless this
+// instance does not allow it. See MultiExecutor.StartWithPriority.
+// Start returns the execution of h or nil if h did not start. When Start
+// does not allow h, Start interrupts nothing.
+func (g *RunGuard) Start(
+	te *MultiExecutor,
+	h *ops.HueTask,
+	lightSet lights.Set,
+	priority int) *tasks.Execution {
+	if !g.Allow(h.Id, te.clock.Now()) {
+		return nil
+	}
+	return te.StartWithPriority(h, lightSet, priority)
+}
@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// Indicates that a scheduled task with the same Id already exists.
+	ErrDuplicateTaskId = errors.New("utils: Duplicate task Id.")
+
+	// Indicates that no scheduled task has the given Id.
+	ErrNoSuchTask = errors.New("utils: No such task.")
+)
+
+// ScheduleChangeKind tells what kind of change a ScheduleChange is.
+type ScheduleChangeKind int
+
+const (
+	// A scheduled task was added.
+	TaskAdded ScheduleChangeKind = iota
+
+	// A scheduled task was removed.
+	TaskRemoved
+
+	// A scheduled task was replaced with another having the same Id.
+	TaskReplaced
+)
+
+// ScheduleChange describes a change to the scheduled tasks in a
+// ScheduleManager.
+type ScheduleChange struct {
+	Kind ScheduleChangeKind
+
+	// The Id of the scheduled task that changed.
+	Id int
+
+	// The scheduled task before the change. nil for TaskAdded.
+	Old *ScheduledTask
+
+	// The scheduled task after the change. nil for TaskRemoved.
+	New *ScheduledTask
+}
+
+// ScheduleManager holds a ScheduledTaskList that can change while the
+// process runs so that scheduled tasks can be edited without a restart.
+// Each change replaces the list rather than changing it in place, so
+// lists returned from Tasks stay immutable. ScheduleManager is safe to
+// use with multiple goroutines.
+type ScheduleManager struct {
+	mu        sync.Mutex
+	tasks     ScheduledTaskList
+	listeners []func(change ScheduleChange)
+}
+
+// NewScheduleManager creates a ScheduleManager that starts out with
+// tasks. tasks must not have duplicate Ids.
+func NewScheduleManager(tasks ScheduledTaskList) *ScheduleManager {
+	return &ScheduleManager{tasks: tasks}
+}
+
+// Enable enables the scheduled task with given id. Enable returns
+// ErrNoSuchTask if there is no such scheduled task.
+func (m *ScheduleManager) Enable(id int) error {
+	return m.setEnabled(id, true)
+}
+
+// Disable disables the scheduled task with given id. Disable returns
+// ErrNoSuchTask if there is no such scheduled task.
+func (m *ScheduleManager) Disable(id int) error {
+	return m.setEnabled(id, false)
+}
+
+// setEnabled holds the lock while it enables or disables so that it
+// never acts on a scheduled task that RemoveTask or ReplaceTask just
+// took out.
+func (m *ScheduleManager) setEnabled(id int, enabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	idx := m.tasks.index(id)
+	if idx == -1 {
+		return ErrNoSuchTask
+	}
+	if enabled {
+		m.tasks[idx].Enable()
+	} else {
+		m.tasks[idx].Disable()
+	}
+	return nil
+}
+
+// OnChange registers f to be called after each change. f runs on the
+// goroutine that made the change after ScheduleManager has released
+// its lock, so f may call methods of ScheduleManager.
+func (m *ScheduleManager) OnChange(f func(change ScheduleChange)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, f)
+}
+
+// Tasks returns the current scheduled tasks.
+func (m *ScheduleManager) Tasks() ScheduledTaskList {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tasks
+}
+
+// ToMap returns the current scheduled tasks as a map keyed by Id.
+func (m *ScheduleManager) ToMap() map[int]*ScheduledTask {
+	return m.Tasks().ToMap()
+}
+
+// AddTask adds task. AddTask returns ErrDuplicateTaskId if a scheduled
+// task with the same Id already exists. AddTask does not enable task.
+func (m *ScheduleManager) AddTask(task *ScheduledTask) error {
+	listeners, err := m.change(func(tasks ScheduledTaskList) (
+		ScheduledTaskList, error) {
+		if tasks.index(task.Id) != -1 {
+			return nil, ErrDuplicateTaskId
+		}
+		result := make(ScheduledTaskList, len(tasks), len(tasks)+1)
+		copy(result, tasks)
+		return append(result, task), nil
+	})
+	if err != nil {
+		return err
+	}
+	notify(listeners, ScheduleChange{Kind: TaskAdded, Id: task.Id, New: task})
+	return nil
+}
+
+// RemoveTask disables and removes the scheduled task with given id.
+// RemoveTask returns ErrNoSuchTask if there is no such scheduled task.
+func (m *ScheduleManager) RemoveTask(id int) error {
+	var old *ScheduledTask
+	listeners, err := m.change(func(tasks ScheduledTaskList) (
+		ScheduledTaskList, error) {
+		idx := tasks.index(id)
+		if idx == -1 {
+			return nil, ErrNoSuchTask
+		}
+		old = tasks[idx]
+		old.Disable()
+		result := make(ScheduledTaskList, 0, len(tasks)-1)
+		result = append(result, tasks[:idx]...)
+		return append(result, tasks[idx+1:]...), nil
+	})
+	if err != nil {
+		return err
+	}
+	notify(listeners, ScheduleChange{Kind: TaskRemoved, Id: id, Old: old})
+	return nil
+}
+
+// ReplaceTask replaces the scheduled task having the same Id as task
+// with task keeping its position. ReplaceTask disables the old scheduled
+// task and enables task if the old one was enabled. ReplaceTask returns
+// ErrNoSuchTask if there is no scheduled task with the same Id as task.
+func (m *ScheduleManager) ReplaceTask(task *ScheduledTask) error {
+	var old *ScheduledTask
+	listeners, err := m.change(func(tasks ScheduledTaskList) (
+		ScheduledTaskList, error) {
+		idx := tasks.index(task.Id)
+		if idx == -1 {
+			return nil, ErrNoSuchTask
+		}
+		old = tasks[idx]
+		if old.IsEnabled() {
+			old.Disable()
+			task.Enable()
+		}
+		result := make(ScheduledTaskList, len(tasks))
+		copy(result, tasks)
+		result[idx] = task
+		return result, nil
+	})
+	if err != nil {
+		return err
+	}
+	notify(listeners, ScheduleChange{
+		Kind: TaskReplaced, Id: task.Id, Old: old, New: task})
+	return nil
+}
+
+// change replaces the scheduled tasks with what f returns unless f
+// returns an error. f runs while change holds the lock. change returns
+// the listeners to notify.
+func (m *ScheduleManager) change(
+	f func(tasks ScheduledTaskList) (ScheduledTaskList, error)) (
+	[]func(change ScheduleChange), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tasks, err := f(m.tasks)
+	if err != nil {
+		return nil, err
+	}
+	m.tasks = tasks
+	return m.listeners, nil
+}
+
+func notify(listeners []func(change ScheduleChange), change ScheduleChange) {
+	for _, listener := range listeners {
+		listener(change)
+	}
+}
+
+func (l ScheduledTaskList) index(id int) int {
+	for i, st := range l {
+		if st.Id == id {
+			return i
+		}
+	}
+	return -1
+}
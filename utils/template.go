@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/keep94/marvin2/lights"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewTemplate returns a new template instance. name is the name
+// of the template; templateStr is the template string. The returned
+// template can use the functions in TemplateFuncs.
+func NewTemplate(name, templateStr string) *template.Template {
+	return template.Must(
+		template.New(name).Funcs(TemplateFuncs()).Parse(templateStr))
+}
+
+// TemplateFuncs returns the functions that templates from NewTemplate
+// can use so that callers building their own templates can add them.
+//
+//	lightNames: LightNames
+//	duration: HumanizeDuration
+//	timeLeft: TimerTaskWrapper.TimeLeftStr
+//	options: SelectOptions
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"lightNames": LightNames,
+		"duration":   HumanizeDuration,
+		"timeLeft": func(t *TimerTaskWrapper, now time.Time) string {
+			return t.TimeLeftStr(now)
+		},
+		"options": SelectOptions,
+	}
+}
+
+// LightNames returns the lights in lightSet comma separated in ascending
+// order using the names in labels. Lights missing from labels appear as
+// their number. LightNames returns "All" or "None" like lights.Set.String.
+func LightNames(lightSet lights.Set, labels map[int]string) string {
+	if lightSet.IsAll() || lightSet.IsNone() {
+		return lightSet.String()
+	}
+	lightIds, _ := lightSet.Slice()
+	names := make([]string, len(lightIds))
+	for i, id := range lightIds {
+		if label, ok := labels[id]; ok {
+			names[i] = label
+		} else {
+			names[i] = strconv.Itoa(id)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// HumanizeDuration returns d rounded to the second in at most its two
+// largest units such as "1d 2h", "5m 3s", or "45s".
+func HumanizeDuration(d time.Duration) string {
+	if d < 0 {
+		return "-" + HumanizeDuration(-d)
+	}
+	d = d.Round(time.Second)
+	units := []struct {
+		size time.Duration
+		name string
+	}{
+		{24 * time.Hour, "d"},
+		{time.Hour, "h"},
+		{time.Minute, "m"},
+		{time.Second, "s"},
+	}
+	var parts []string
+	for _, unit := range units {
+		count := d / unit.size
+		d -= count * unit.size
+		if count > 0 || len(parts) > 0 {
+			parts = append(parts, fmt.Sprintf("%d%s", count, unit.name))
+		}
+		if len(parts) == 2 {
+			break
+		}
+	}
+	if len(parts) == 0 {
+		return "0s"
+	}
+	if len(parts) == 2 && strings.HasPrefix(parts[1], "0") {
+		parts = parts[:1]
+	}
+	return strings.Join(parts, " ")
+}
+
+// SelectOptions renders the option elements of a select element for the
+// choices in selection such as those from dynamic.Param.Selection. The
+// value of each option is its ordinal starting at 0 so that it works with
+// the Convert methods of pickers. The option whose ordinal is selected
+// is selected.
+func SelectOptions(selection []string, selected int) template.HTML {
+	var sb strings.Builder
+	for i, choice := range selection {
+		if i == selected {
+			fmt.Fprintf(&sb, "<option value=\"%d\" selected>", i)
+		} else {
+			fmt.Fprintf(&sb, "<option value=\"%d\">", i)
+		}
+		sb.WriteString(template.HTMLEscapeString(choice))
+		sb.WriteString("</option>")
+	}
+	return template.HTML(sb.String())
+}
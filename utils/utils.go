@@ -2,15 +2,17 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/keep94/marvin2/lights"
 	"github.com/keep94/marvin2/ops"
 	"github.com/keep94/tasks"
 	"github.com/keep94/tasks/recurring"
-	"html/template"
-	"log"
-	"reflect"
+	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,36 +27,171 @@ type Recurring struct {
 // BackgroundRunner runs a single task in the background.
 // BackgroundRunner is safe to use with multiple goroutines.
 type BackgroundRunner struct {
-	task   tasks.Task
-	runner *tasks.SingleExecutor
+	task         tasks.Task
+	runner       *tasks.SingleExecutor
+	mu           sync.Mutex
+	lastError    error
+	restartCount int
+	toggleMu     sync.Mutex
+	store        EnabledStore
+	storeId      int
 }
 
 func NewBackgroundRunner(task tasks.Task) *BackgroundRunner {
 	return &BackgroundRunner{task: task, runner: tasks.NewSingleExecutor()}
 }
 
+// RestartPolicy tells a BackgroundRunner how to restart its task when the
+// task returns or panics without being disabled.
+type RestartPolicy struct {
+	// How long to wait before the first restart. 0 means one second.
+	InitialBackoff time.Duration
+
+	// The wait doubles after each restart up to MaxBackoff. 0 means no
+	// limit.
+	MaxBackoff time.Duration
+
+	// How many times to restart before giving up. 0 means no limit.
+	MaxRestarts int
+}
+
+// NewBackgroundRunnerWithRestart works like NewBackgroundRunner except
+// that the returned BackgroundRunner restarts task according to policy.
+// The returned BackgroundRunner also recovers from panics in task.
+func NewBackgroundRunnerWithRestart(
+	task tasks.Task, policy RestartPolicy) *BackgroundRunner {
+	result := &BackgroundRunner{runner: tasks.NewSingleExecutor()}
+	result.task = &supervisedTask{task: task, policy: policy, br: result}
+	return result
+}
+
+// LastError returns the last error or panic that made the task stop.
+// LastError returns nil if the task never stopped that way.
+func (br *BackgroundRunner) LastError() error {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	return br.lastError
+}
+
+// RestartCount returns how many times the task has been restarted in
+// total.
+func (br *BackgroundRunner) RestartCount() int {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	return br.restartCount
+}
+
+func (br *BackgroundRunner) setLastError(err error) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.lastError = err
+}
+
+func (br *BackgroundRunner) incRestartCount() {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.restartCount++
+}
+
 // IsEnabled returns true if the task is running.
 func (br *BackgroundRunner) IsEnabled() bool {
 	_, e := br.runner.Current()
 	return e != nil
 }
 
+// EnabledStore persists whether each scheduled task is enabled so that
+// the state survives a restart. Implementations must be safe to use with
+// multiple goroutines.
+type EnabledStore interface {
+	// SetEnabled saves whether the scheduled task with given id is enabled.
+	SetEnabled(id int, enabled bool)
+}
+
+// SaveEnabled makes Enable and Disable save in store under id whether
+// the task is running each time they start or stop it.
+func (br *BackgroundRunner) SaveEnabled(store EnabledStore, id int) {
+	br.toggleMu.Lock()
+	defer br.toggleMu.Unlock()
+	br.store = store
+	br.storeId = id
+}
+
 // Enable runs the task.
 func (br *BackgroundRunner) Enable() {
+	br.toggleMu.Lock()
+	defer br.toggleMu.Unlock()
 	if !br.IsEnabled() {
 		br.runner.Start(br.task)
+		br.saveEnabled(true)
 	}
 }
 
 // Disable stops the task.
 func (br *BackgroundRunner) Disable() {
+	br.toggleMu.Lock()
+	defer br.toggleMu.Unlock()
 	_, e := br.runner.Current()
 	if e != nil {
 		e.End()
 		<-e.Done()
+		br.saveEnabled(false)
 	}
 }
 
+func (br *BackgroundRunner) saveEnabled(enabled bool) {
+	if br.store != nil {
+		br.store.SetEnabled(br.storeId, enabled)
+	}
+}
+
+// supervisedTask runs a task restarting it according to a RestartPolicy.
+type supervisedTask struct {
+	task   tasks.Task
+	policy RestartPolicy
+	br     *BackgroundRunner
+}
+
+func (s *supervisedTask) Do(e *tasks.Execution) {
+	backoff := s.policy.InitialBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for restarts := 0; ; restarts++ {
+		if err := s.runOnce(e); err != nil {
+			s.br.setLastError(err)
+		}
+		if e.IsEnded() {
+			return
+		}
+		if s.policy.MaxRestarts > 0 && restarts >= s.policy.MaxRestarts {
+			return
+		}
+		if !e.Sleep(backoff) {
+			return
+		}
+		s.br.incRestartCount()
+		backoff *= 2
+		if s.policy.MaxBackoff > 0 && backoff > s.policy.MaxBackoff {
+			backoff = s.policy.MaxBackoff
+		}
+	}
+}
+
+// runOnce runs the task once returning any panic or new error the task
+// reported.
+func (s *supervisedTask) runOnce(e *tasks.Execution) (err error) {
+	before := e.Error()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("utils: Task panicked: %v", r)
+		} else if after := e.Error(); after != before {
+			err = after
+		}
+	}()
+	s.task.Do(e)
+	return nil
+}
+
 // FutureHueTask represents a future hue task.
 type FutureHueTask interface {
 
@@ -80,38 +217,79 @@ type ScheduledTask struct {
 	Lights lights.Set
 	// When to run. nil means running always.
 	Times *Recurring
-	// If false this scheduled task won't interrupt already running tasks.
-	HighPriority bool
+	// This scheduled task interrupts only running tasks with lower
+	// priority.
+	Priority int
+	// If positive, each run of the hue task ends after this long.
+	// Only scheduled tasks from HueTaskToScheduledTask use this field.
+	// Set it before enabling this scheduled task.
+	MaxDuration time.Duration
+	// What to do about runs missed while the process was down. Requires
+	// FireTimes.
+	Misfire MisfirePolicy
+	// For MisfireRunWithinGrace, how late a missed run may be and still
+	// run.
+	MisfireGrace time.Duration
+	// If non-nil, decides before each run of the hue task whether it
+	// starts at all. Only scheduled tasks from HueTaskToScheduledTask use
+	// this field. Set it before enabling this scheduled task.
+	Guard *RunGuard
+	// Where to persist when this scheduled task last fired. nil means
+	// do not persist.
+	FireTimes FireTimeStore
+	// The task that runs at each time in Times.
+	once tasks.Task
+	// Tells Enable the current time.
+	clock tasks.Clock
 	*BackgroundRunner
 }
 
+// NextRunTime returns the first time after now that this scheduled task
+// runs according to Times. NextRunTime returns false if Times is nil,
+// meaning the task runs always, or if Times has no more times.
+// NextRunTime ignores whether this scheduled task is enabled.
+func (s *ScheduledTask) NextRunTime(now time.Time) (time.Time, bool) {
+	if s.Times == nil {
+		return time.Time{}, false
+	}
+	var result time.Time
+	stream := s.Times.ForTime(now)
+	defer stream.Close()
+	if stream.Next(&result) != nil {
+		return time.Time{}, false
+	}
+	return result, true
+}
+
 // HueTaskToScheduledTask creates a ScheduledTask from a FutureHueTask.
 // id is the id of the new ScheduledTask.
 // h is the FutureHueTask.
 // lightSet is the lights h is to run on.
 // r is when h should run.
-// hiPriority is true if h should preempt other tasks when run.
-// te is what runs h.
+// priority is the priority of h when run. See StartWithPriority.
+// te is what runs h. The returned ScheduledTask gets the current time
+// from the clock of te.
 func HueTaskToScheduledTask(
 	id int,
 	h FutureHueTask,
 	lightSet lights.Set,
 	r *Recurring,
-	hiPriority bool,
+	priority int,
 	te *MultiExecutor) *ScheduledTask {
-	var atask tasks.Task
-	if hiPriority {
-		atask = tasks.TaskFunc(func(e *tasks.Execution) {
-			te.Start(h.Refresh(), lightSet)
-		})
-	} else {
-		atask = tasks.TaskFunc(func(e *tasks.Execution) {
-			te.MaybeStart(h.Refresh(), lightSet)
-		})
-	}
-	result := TaskToScheduledTask(id, h.GetDescription(), r, atask)
+	var result *ScheduledTask
+	atask := tasks.TaskFunc(func(e *tasks.Execution) {
+		hueTask := h.Refresh()
+		if result.Guard != nil && !result.Guard.Allow(hueTask.Id, e.Now()) {
+			return
+		}
+		te.endAfter(
+			te.StartWithPriority(hueTask, lightSet, priority),
+			result.MaxDuration)
+	})
+	result = TaskToScheduledTask(id, h.GetDescription(), r, atask)
 	result.Lights = lightSet
-	result.HighPriority = hiPriority
+	result.Priority = priority
+	result.clock = te.clock
 	return result
 }
 
@@ -125,15 +303,18 @@ func TaskToScheduledTask(
 	description string,
 	r *Recurring,
 	task tasks.Task) *ScheduledTask {
-	if r != nil {
-		task = tasks.RecurringTask(task, r)
+	result := &ScheduledTask{
+		Id:          id,
+		Description: description,
+		Times:       r,
+		clock:       tasks.SystemClock(),
 	}
-	return &ScheduledTask{
-		Id:               id,
-		Description:      description,
-		Times:            r,
-		BackgroundRunner: NewBackgroundRunner(task),
+	if r != nil {
+		result.once = task
+		task = tasks.RecurringTask(&firedRecorder{task: task, st: result}, r)
 	}
+	result.BackgroundRunner = NewBackgroundRunner(task)
+	return result
 }
 
 // ScheduledTaskList represents an immutable list of scheduled tasks.
@@ -148,14 +329,99 @@ func (l ScheduledTaskList) ToMap() map[int]*ScheduledTask {
 	return result
 }
 
+// UpcomingRun is when a scheduled task runs next.
+type UpcomingRun struct {
+	Task *ScheduledTask
+	Time time.Time
+}
+
+// Upcoming returns when each enabled scheduled task in this list runs
+// next, soonest first. Ties go to the lower Id. Upcoming skips scheduled
+// tasks for which NextRunTime returns false.
+func (l ScheduledTaskList) Upcoming(now time.Time) []UpcomingRun {
+	var result []UpcomingRun
+	for _, st := range l {
+		if !st.IsEnabled() {
+			continue
+		}
+		if next, ok := st.NextRunTime(now); ok {
+			result = append(result, UpcomingRun{Task: st, Time: next})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if !result[i].Time.Equal(result[j].Time) {
+			return result[i].Time.Before(result[j].Time)
+		}
+		return result[i].Task.Id < result[j].Task.Id
+	})
+	return result
+}
+
+const (
+	// The lowest priority. Tasks with this priority never interrupt
+	// other tasks. Priorities are never negative.
+	PriorityLow = 0
+
+	// The priority of tasks that Start starts.
+	PriorityHigh = 100
+)
+
 // MultiExecutor executes hue tasks while ensuring that no more than
 // one task is controlling any given light. MultiExecutor is safe to use
 // with multiple goroutines.
 type MultiExecutor struct {
-	me   *tasks.MultiExecutor
-	c    ops.Context
-	hlog *log.Logger
-	name string
+	me        *tasks.MultiExecutor
+	c         ops.Context
+	hlog      Logger
+	name      string
+	clock     tasks.Clock
+	listeners listenerList
+	history   *runHistory
+	// Guards isShutdown so that no task starts once Shutdown begins and
+	// isClosed so that me is closed only once.
+	shutdownMu sync.RWMutex
+	isShutdown bool
+	isClosed   bool
+	// Guards pauseCount
+	pauseMu    sync.Mutex
+	pauseCount int
+	// Held while pausing or resuming me. Guards paused.
+	transitionMu sync.Mutex
+	paused       bool
+	// Held while StartWithPriority checks for blocking tasks and starts
+	// so that two hue tasks can't both find the same lights free.
+	priorityMu sync.Mutex
+	// Guards fairness and skips
+	fairnessMu sync.Mutex
+	fairness   FairnessPolicy
+	skips      map[int]int
+}
+
+// FairnessPolicy keeps hue tasks that StartWithPriority keeps skipping
+// from being starved by long running tasks with higher priority.
+type FairnessPolicy interface {
+	// Priority returns the priority that StartWithPriority uses for a hue
+	// task started with priority that StartWithPriority skipped skipped
+	// times in a row.
+	Priority(priority, skipped int) int
+}
+
+// SkipLimit returns a FairnessPolicy that lets a hue task claim its lights
+// from running tasks with lower priority than PriorityHigh once
+// StartWithPriority has skipped it n times in a row. Such a hue task runs
+// with PriorityHigh, so it never interrupts a task that Start started
+// such as a security task.
+func SkipLimit(n int) FairnessPolicy {
+	return skipLimit(n)
+}
+
+type skipLimit int
+
+func (s skipLimit) Priority(priority, skipped int) int {
+	if skipped >= int(s) && priority < PriorityHigh {
+		return PriorityHigh
+	}
+	return priority
 }
 
 // NewMultiExecutor creates a new MultiExecutor instance.
@@ -163,37 +429,129 @@ type MultiExecutor struct {
 // beyond the Context interface that HueTask instances passed to Start
 // and MaybeStart need. If a HueTask needs a method that c does not implement
 // then it does nothing. hlog captures the start of each HueTask along with
-// its ending or interruption.
-func NewMultiExecutor(c ops.Context, hlog *log.Logger) *MultiExecutor {
-	return &MultiExecutor{
-		me:   tasks.NewMultiExecutor(&TaskCollection{}),
-		c:    c,
-		hlog: hlog,
-	}
+// its ending or interruption. hlog may be nil.
+func NewMultiExecutor(c ops.Context, hlog Logger) *MultiExecutor {
+	return NewNamedMultiExecutor("", c, hlog)
 }
 
 // NewNamedMultiExecutor works like NewMultiExecutor except that it creates
 // a named MultiExecutor instance. The name appears in the execution logs.
 func NewNamedMultiExecutor(
-	name string, c ops.Context, hlog *log.Logger) *MultiExecutor {
-	return &MultiExecutor{
-		me:   tasks.NewMultiExecutor(&TaskCollection{}),
-		c:    c,
-		hlog: hlog,
-		name: name,
+	name string, c ops.Context, hlog Logger) *MultiExecutor {
+	return newMultiExecutor(name, c, hlog, tasks.SystemClock())
+}
+
+// NewMultiExecutorWithClock works like NewMultiExecutor except that
+// the hue tasks it runs see clock through their tasks.Execution rather
+// than the system clock. Provided for testing.
+func NewMultiExecutorWithClock(
+	c ops.Context, hlog Logger, clock tasks.Clock) *MultiExecutor {
+	return newMultiExecutor("", c, hlog, clock)
+}
+
+func newMultiExecutor(
+	name string,
+	c ops.Context,
+	hlog Logger,
+	clock tasks.Clock) *MultiExecutor {
+	result := &MultiExecutor{
+		me: tasks.NewMultiExecutorWithClock(
+			&TaskCollection[*HueTaskWrapper]{}, clock),
+		c:       c,
+		hlog:    hlog,
+		name:    name,
+		clock:   clock,
+		history: newRunHistory(kHistorySize, clock),
+	}
+	result.listeners.add(result.history)
+	return result
+}
+
+// newChild creates a MultiExecutor named name that runs hue tasks with c
+// and that has the log, clock, listeners, and FairnessPolicy of this
+// instance. The new MultiExecutor keeps its own history.
+func (m *MultiExecutor) newChild(name string, c ops.Context) *MultiExecutor {
+	result := newMultiExecutor(name, c, m.hlog, m.clock)
+	for _, listener := range m.listeners.get() {
+		if listener != Listener(m.history) {
+			result.listeners.add(listener)
+		}
 	}
+	m.fairnessMu.Lock()
+	result.fairness = m.fairness
+	m.fairnessMu.Unlock()
+	return result
 }
 
 // MaybeStart is like Start but avoids interrupting running tasks by
 // either not running h or by running h on a subset of the lights in
-// lightSet.
+// lightSet. MaybeStart is the same as StartWithPriority with PriorityLow.
 func (m *MultiExecutor) MaybeStart(
 	h *ops.HueTask, lightSet lights.Set) *tasks.Execution {
-	runningTasks := m.Tasks()
+	return m.StartWithPriority(h, lightSet, PriorityLow)
+}
+
+// SetFairnessPolicy sets the FairnessPolicy that StartWithPriority uses.
+// StartWithPriority keeps track of how many times in a row it skipped each
+// hue task by hue task Id. nil, the default, means no FairnessPolicy.
+func (m *MultiExecutor) SetFairnessPolicy(policy FairnessPolicy) {
+	m.fairnessMu.Lock()
+	defer m.fairnessMu.Unlock()
+	m.fairness = policy
+	m.skips = nil
+}
+
+// StartWithPriority starts h with given priority interrupting only the
+// running tasks with strictly lower priority. Like MaybeStart,
+// StartWithPriority either does not run h or runs h on a subset of the
+// lights in lightSet to avoid interrupting running tasks with the same
+// or higher priority. If this instance has a FairnessPolicy, it may
+// raise priority for hue tasks that StartWithPriority skipped before.
+// StartWithPriority returns the execution of h or nil if h did not run.
+func (m *MultiExecutor) StartWithPriority(
+	h *ops.HueTask, lightSet lights.Set, priority int) *tasks.Execution {
+	m.fairnessMu.Lock()
+	policy := m.fairness
+	if policy != nil {
+		priority = policy.Priority(priority, m.skips[h.Id])
+	}
+	m.fairnessMu.Unlock()
+	m.priorityMu.Lock()
+	result := m.startWithPriority(h, lightSet, priority)
+	m.priorityMu.Unlock()
+	if policy != nil {
+		m.recordSkip(h.Id, result == nil)
+	}
+	return result
+}
+
+// recordSkip records whether StartWithPriority skipped the hue task with
+// given id.
+func (m *MultiExecutor) recordSkip(hueTaskId int, skipped bool) {
+	m.fairnessMu.Lock()
+	defer m.fairnessMu.Unlock()
+	if !skipped {
+		delete(m.skips, hueTaskId)
+		return
+	}
+	if m.skips == nil {
+		m.skips = make(map[int]int)
+	}
+	m.skips[hueTaskId]++
+}
 
-	// If there are not running tasks, start this one.
-	if len(runningTasks) == 0 {
-		return m.Start(h, lightSet)
+func (m *MultiExecutor) startWithPriority(
+	h *ops.HueTask, lightSet lights.Set, priority int) *tasks.Execution {
+	var blockingTasks []*HueTaskWrapper
+	for _, hueTaskWrapper := range m.Tasks() {
+		if hueTaskWrapper.Priority >= priority {
+			blockingTasks = append(blockingTasks, hueTaskWrapper)
+		}
+	}
+
+	// If there are no blocking tasks, start this one.
+	if len(blockingTasks) == 0 {
+		return m.start(h, lightSet, priority)
 	}
 
 	neededLights := h.UsedLights(lightSet)
@@ -201,16 +559,16 @@ func (m *MultiExecutor) MaybeStart(
 		return nil
 	}
 
-	// There are running tasks, and this task uses all the lights.
+	// There are blocking tasks, and this task uses all the lights.
 	// Don't run this task.
 	if neededLights.IsAll() {
 		return nil
 	}
 
-	// Calculate lightsInUse. If a running task uses all
+	// Calculate lightsInUse. If a blocking task uses all
 	// lights give up don't run this task.
 	var lightsInUse lights.Builder
-	for _, hueTaskWrapper := range runningTasks {
+	for _, hueTaskWrapper := range blockingTasks {
 		if hueTaskWrapper.Ls.IsAll() {
 			return nil
 		}
@@ -234,22 +592,150 @@ func (m *MultiExecutor) MaybeStart(
 	// what we have left are the lights that are needed but not available.
 	// We make sure this set is empty before running the task.
 	if lightsThatWillBeUsed.Subtract(neededAndAvailableLights).IsNone() {
-		return m.Start(h, lightsThatWillBeUsed)
+		return m.start(h, lightsThatWillBeUsed, priority)
 	}
 	return nil
 }
 
+// Enqueue works like MaybeStart except that rather than not running h
+// when the lights h needs are in use, Enqueue waits for them to free up
+// and then starts h. Enqueue gives up and returns nil if it could not
+// start h before timeout. Enqueue blocks until it starts h or gives up
+// and returns the execution of h.
+func (m *MultiExecutor) Enqueue(
+	h *ops.HueTask,
+	lightSet lights.Set,
+	timeout time.Duration) *tasks.Execution {
+	return m.EnqueueWithPriority(h, lightSet, PriorityLow, timeout)
+}
+
+// EnqueueWithPriority works like Enqueue except that h runs with given
+// priority as in StartWithPriority. EnqueueWithPriority waits only for
+// running tasks with the same or higher priority as it interrupts the
+// others. If another task grabs the lights first, EnqueueWithPriority
+// keeps waiting.
+func (m *MultiExecutor) EnqueueWithPriority(
+	h *ops.HueTask,
+	lightSet lights.Set,
+	priority int,
+	timeout time.Duration) *tasks.Execution {
+	neededLights := h.UsedLights(lightSet)
+	if neededLights.IsNone() {
+		return nil
+	}
+	timedOut := m.clock.After(timeout)
+	collection := m.running()
+	for {
+		removed := collection.removedCh()
+		if !m.lightsBlocked(neededLights, priority) {
+			if e := m.StartWithPriority(h, neededLights, priority); e != nil {
+				return e
+			}
+			if m.shuttingDown() {
+				return nil
+			}
+		}
+		select {
+		case <-removed:
+		case <-timedOut:
+			return nil
+		}
+	}
+}
+
+// lightsBlocked returns true if a running task with priority or higher
+// priority uses any of lightSet.
+func (m *MultiExecutor) lightsBlocked(lightSet lights.Set, priority int) bool {
+	for _, hueTaskWrapper := range m.Tasks() {
+		if hueTaskWrapper.Priority >= priority &&
+			hueTaskWrapper.Ls.OverlapsWith(lightSet) {
+			return true
+		}
+	}
+	return false
+}
+
+// shuttingDown returns true if Shutdown was called.
+func (m *MultiExecutor) shuttingDown() bool {
+	m.shutdownMu.RLock()
+	defer m.shutdownMu.RUnlock()
+	return m.isShutdown
+}
+
 // Start starts a task for a suggested set of lights. Start
 // interrupts any running task using the lights that h needs before
-// starting h. Start returns the execution of h.
+// starting h regardless of priority. h runs with PriorityHigh.
+// Start returns the execution of h or nil if h uses no lights or if
+// Shutdown was called.
 func (m *MultiExecutor) Start(
 	h *ops.HueTask, lightSet lights.Set) *tasks.Execution {
+	return m.start(h, lightSet, PriorityHigh)
+}
+
+// StartWithTimeout works like Start except that it ends the execution of
+// h after d so that a hue task that never ends on its own does not keep
+// its lights forever.
+func (m *MultiExecutor) StartWithTimeout(
+	h *ops.HueTask, lightSet lights.Set, d time.Duration) *tasks.Execution {
+	return m.endAfter(m.Start(h, lightSet), d)
+}
+
+// endAfter ends e after d on the clock of this instance and returns e.
+// endAfter does nothing if e is nil or d is not positive.
+func (m *MultiExecutor) endAfter(
+	e *tasks.Execution, d time.Duration) *tasks.Execution {
+	if e != nil && d > 0 {
+		m.endAfterCancelable(e, d)
+	}
+	return e
+}
+
+// endAfterCancelable ends e after d on the clock of this instance unless
+// the returned function is called first.
+func (m *MultiExecutor) endAfterCancelable(
+	e *tasks.Execution, d time.Duration) func() {
+	canceled := make(chan struct{})
+	var once sync.Once
+	// Ask for the timer now so that d counts from the call.
+	timeout := m.clock.After(d)
+	go func() {
+		select {
+		case <-timeout:
+			e.End()
+		case <-e.Done():
+		case <-canceled:
+		}
+	}()
+	return func() {
+		once.Do(func() { close(canceled) })
+	}
+}
+
+func (m *MultiExecutor) start(
+	h *ops.HueTask, lightSet lights.Set, priority int) *tasks.Execution {
 	usedLights := h.UsedLights(lightSet)
 	if usedLights.IsNone() {
 		return nil
 	}
-	return m.me.Start(
-		&HueTaskWrapper{H: h, Ls: usedLights, c: m.c, log: m.hlog, name: m.name})
+	m.shutdownMu.RLock()
+	defer m.shutdownMu.RUnlock()
+	if m.isShutdown {
+		return nil
+	}
+	return m.me.Start(&HueTaskWrapper{
+		H:         h,
+		Ls:        usedLights,
+		Priority:  priority,
+		c:         m.c,
+		log:       m.hlog,
+		name:      m.name,
+		listeners: &m.listeners})
+}
+
+// AddListener registers listener to observe the hue tasks this executor
+// runs. listener sees only hue tasks started after AddListener returns.
+func (m *MultiExecutor) AddListener(listener Listener) {
+	m.listeners.add(listener)
 }
 
 // Begin is a synonym for Start. Needed to implement HueTaskBeginner.
@@ -259,41 +745,159 @@ func (m *MultiExecutor) Begin(
 }
 
 // Pause pauses this executor waiting for all tasks to actually stop.
-// Pause() and Resume() must be called from the same goroutine.
-// Calling Pause() and Resume() concurrently from different goroutines
-// causes undefined behavior and may cause Pause() to block indefinitely.
+// Pauses are reference counted: this executor stays paused until Resume
+// has been called once for each call to Pause. Pause and Resume are safe
+// to call from different goroutines.
 func (m *MultiExecutor) Pause() {
-	m.me.Pause()
+	m.addPause(1)
+	m.syncPause()
+}
+
+// PauseWithContext works like Pause except that it gives up waiting for
+// tasks to stop when ctx finishes. When it gives up, PauseWithContext
+// undoes its pause and returns ctx.Err().
+func (m *MultiExecutor) PauseWithContext(ctx context.Context) error {
+	m.addPause(1)
+	done := make(chan struct{})
+	go func() {
+		m.syncPause()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		m.addPause(-1)
+		go m.syncPause()
+		return ctx.Err()
+	}
 }
 
-// Resume resumes this executor.
-// Pause() and Resume() must be called from the same goroutine.
-// Calling Pause() and Resume() concurrently from different goroutines
-// causes undefined behavior and may cause Pause() to block indefinitely.
+// Resume undoes one call to Pause and resumes this executor once
+// every call to Pause is undone. Resume does nothing if this executor
+// is not paused.
 func (m *MultiExecutor) Resume() {
-	m.me.Resume()
+	m.addPause(-1)
+	m.syncPause()
+}
+
+func (m *MultiExecutor) addPause(delta int) {
+	m.pauseMu.Lock()
+	defer m.pauseMu.Unlock()
+	m.pauseCount += delta
+	if m.pauseCount < 0 {
+		m.pauseCount = 0
+	}
+}
+
+// syncPause pauses or resumes me so that it is paused exactly when
+// pauseCount is positive.
+func (m *MultiExecutor) syncPause() {
+	m.transitionMu.Lock()
+	defer m.transitionMu.Unlock()
+	m.shutdownMu.RLock()
+	defer m.shutdownMu.RUnlock()
+	if m.isClosed {
+		return
+	}
+	m.pauseMu.Lock()
+	shouldPause := m.pauseCount > 0
+	m.pauseMu.Unlock()
+	if shouldPause == m.paused {
+		return
+	}
+	if shouldPause {
+		m.me.Pause()
+	} else {
+		m.me.Resume()
+	}
+	m.paused = shouldPause
 }
 
 // Tasks returns the current HueTasks being run
 func (m *MultiExecutor) Tasks() []*HueTaskWrapper {
-	var result []*HueTaskWrapper
-	m.me.Tasks().(*TaskCollection).Tasks(&result)
-	return result
+	return m.running().Tasks()
+}
+
+func (m *MultiExecutor) running() *TaskCollection[*HueTaskWrapper] {
+	return m.me.Tasks().(*TaskCollection[*HueTaskWrapper])
+}
+
+// History returns the most recent runs of hue tasks in this executor,
+// most recent first. History remembers the last 50 runs.
+func (m *MultiExecutor) History() []TaskRun {
+	return m.history.get()
 }
 
 // Stop stops a particular task. taskId is the ID of the task
 // as returned by HueTaskWrapper.TaskId().
 func (m *MultiExecutor) Stop(taskId string) {
-	e := m.me.Tasks().(*TaskCollection).FindByTaskId(taskId)
+	e := m.running().FindByTaskId(taskId)
 	if e != nil {
 		e.End()
 		<-e.Done()
 	}
 }
 
+// Shutdown stops this instance from starting new tasks, interrupts all
+// running tasks, and waits for them to finish so that, for instance,
+// lights being restored finish restoring before the process exits.
+// Once all tasks finish, Shutdown closes this instance and returns what
+// Close returns. If ctx finishes first, Shutdown returns the tasks still
+// running along with ctx.Err() and leaves this instance open so that the
+// caller can call Shutdown again or Close. This instance still starts no
+// new tasks.
+func (m *MultiExecutor) Shutdown(ctx context.Context) (
+	[]*HueTaskWrapper, error) {
+	m.shutdownMu.Lock()
+	m.isShutdown = true
+	m.shutdownMu.Unlock()
+	collection := m.running()
+	for _, e := range collection.Conflicts(nil) {
+		e.End()
+	}
+	for {
+		removed := collection.removedCh()
+		running := m.Tasks()
+		if len(running) == 0 {
+			return nil, m.close()
+		}
+		select {
+		case <-removed:
+		case <-ctx.Done():
+			return running, ctx.Err()
+		}
+	}
+}
+
+// StopAfter stops a particular task after d so that, for instance, movie
+// mode can run for two more hours and then give the lights back. taskId
+// is the ID of the task as returned by HueTaskWrapper.TaskId(). StopAfter
+// returns a function that cancels the stop or nil if no task has taskId.
+func (m *MultiExecutor) StopAfter(taskId string, d time.Duration) func() {
+	e := m.running().FindByTaskId(taskId)
+	if e == nil {
+		return nil
+	}
+	return m.endAfterCancelable(e, d)
+}
+
 // Close closes resources associated with this instance and interrupts all
-// running tasks in this instance.
+// running tasks in this instance. Close may be called more than once and
+// after Shutdown. Once closed, this instance starts no new tasks, and
+// Pause and Resume do nothing.
 func (m *MultiExecutor) Close() error {
+	return m.close()
+}
+
+func (m *MultiExecutor) close() error {
+	m.shutdownMu.Lock()
+	defer m.shutdownMu.Unlock()
+	m.isShutdown = true
+	if m.isClosed {
+		return nil
+	}
+	m.isClosed = true
 	return m.me.Close()
 }
 
@@ -322,6 +926,9 @@ type MultiTimer struct {
 	executor  HueTaskBeginner
 	scheduler *tasks.MultiExecutor
 	store     AtTimeTaskStore
+	listeners listenerList
+	// Keeps Reschedule and UpdateLights atomic
+	mu sync.Mutex
 }
 
 // NewMultiTimer creates a new MultiTimer. executor is the MultiExecutor
@@ -348,23 +955,27 @@ func NewMultiTimerWithStoreAndClock(
 	clock tasks.Clock) *MultiTimer {
 	result := &MultiTimer{
 		executor:  executor,
-		scheduler: tasks.NewMultiExecutorWithClock(&TaskCollection{}, clock),
+		scheduler: tasks.NewMultiExecutorWithClock(&TaskCollection[*TimerTaskWrapper]{}, clock),
 		store:     store}
 	tasks := store.All()
 	for i := range tasks {
-		result.schedule(tasks[i].H, tasks[i].Ls, tasks[i].StartTime)
+		result.schedule(tasks[i])
 	}
 	return result
 }
 
-func (m *MultiTimer) schedule(
-	h *ops.HueTask, usedLights lights.Set, startTime time.Time) string {
+// schedule schedules task ignoring its Id field and returns the
+// schedule Id.
+func (m *MultiTimer) schedule(task *ops.AtTimeTask) string {
 	wrapper := &TimerTaskWrapper{
-		H:         h,
-		Ls:        usedLights,
-		StartTime: startTime,
-		executor:  m.executor,
-		store:     m.store}
+		H:            task.H,
+		Ls:           task.Ls,
+		StartTime:    task.StartTime,
+		EndTime:      task.EndTime,
+		RestoreAtEnd: task.RestoreAtEnd,
+		executor:     m.executor,
+		store:        m.store,
+		listeners:    &m.listeners}
 	m.scheduler.Start(wrapper)
 	return wrapper.TaskId()
 }
@@ -375,27 +986,53 @@ func (m *MultiTimer) schedule(
 // startTime is the time that the hue task should run.
 func (m *MultiTimer) Schedule(
 	h *ops.HueTask, lightSet lights.Set, startTime time.Time) {
+	m.ScheduleUntil(h, lightSet, startTime, time.Time{}, false)
+}
+
+// ScheduleUntil works like Schedule except that h keeps control of its
+// lights until endTime. When endTime arrives, the lights turn off or,
+// if restore is true, go back to the state they were in before h
+// started. A zero endTime means h ends on its own just as with Schedule.
+func (m *MultiTimer) ScheduleUntil(
+	h *ops.HueTask,
+	lightSet lights.Set,
+	startTime, endTime time.Time,
+	restore bool) {
 	usedLights := h.UsedLights(lightSet)
 	if usedLights.IsNone() {
 		return
 	}
-	scheduleId := m.schedule(h, usedLights, startTime)
-	m.store.Add(&ops.AtTimeTask{
-		Id: scheduleId, H: h, Ls: usedLights, StartTime: startTime})
+	task := &ops.AtTimeTask{
+		H:            h,
+		Ls:           usedLights,
+		StartTime:    startTime,
+		EndTime:      endTime,
+		RestoreAtEnd: restore,
+	}
+	task.Id = m.schedule(task)
+	m.store.Add(task)
+}
+
+// AddListener registers listener to observe the timers of this instance.
+// listener sees only timers armed after AddListener returns.
+func (m *MultiTimer) AddListener(listener Listener) {
+	m.listeners.add(listener)
 }
 
 // Scheduled returns the tasks scheduled to be run.
 func (m *MultiTimer) Scheduled() []*TimerTaskWrapper {
-	var result []*TimerTaskWrapper
-	m.scheduler.Tasks().(*TaskCollection).Tasks(&result)
-	return result
+	return m.timers().Tasks()
 }
 
 // FindByScheduleId returns the execution that controls the scheduling of a
 // task. scheduleId identifies the scheduling of the task and comes from
 // TimerTaskWrapper.TaskId() which is different from the ID of a running task.
 func (m *MultiTimer) FindByScheduleId(scheduleId string) *tasks.Execution {
-	return m.scheduler.Tasks().(*TaskCollection).FindByTaskId(scheduleId)
+	return m.timers().FindByTaskId(scheduleId)
+}
+
+func (m *MultiTimer) timers() *TaskCollection[*TimerTaskWrapper] {
+	return m.scheduler.Tasks().(*TaskCollection[*TimerTaskWrapper])
 }
 
 // Cancel cancels a scheduled task. scheduleId comes from
@@ -409,97 +1046,571 @@ func (m *MultiTimer) Cancel(taskId string) {
 	}
 }
 
+// Reschedule moves the scheduled task with given schedule Id to start at
+// startTime. If the task has an end time, Reschedule moves the end time
+// by the same amount. Reschedule cancels the task, persists the moved
+// task, and schedules it again as one operation. Because the schedule Id
+// includes the start time, Reschedule returns the new schedule Id.
+// Reschedule returns ErrNoSuchScheduleId if no task with scheduleId is
+// waiting to start.
+func (m *MultiTimer) Reschedule(
+	scheduleId string, startTime time.Time) (string, error) {
+	return m.replace(scheduleId, func(task *ops.AtTimeTask) error {
+		if !task.EndTime.IsZero() {
+			task.EndTime = task.EndTime.Add(startTime.Sub(task.StartTime))
+		}
+		task.StartTime = startTime
+		return nil
+	})
+}
+
+// Snooze pushes the scheduled task with given schedule Id back by d
+// along with its end time if it has one. Like Reschedule, Snooze persists
+// the change and returns the new schedule Id.
+func (m *MultiTimer) Snooze(
+	scheduleId string, d time.Duration) (string, error) {
+	return m.replace(scheduleId, func(task *ops.AtTimeTask) error {
+		task.StartTime = task.StartTime.Add(d)
+		if !task.EndTime.IsZero() {
+			task.EndTime = task.EndTime.Add(d)
+		}
+		return nil
+	})
+}
+
+// UpdateLights changes the lights of the scheduled task with given
+// schedule Id to lightSet the same way Reschedule changes the start time.
+// UpdateLights returns the new schedule Id. UpdateLights returns
+// ErrNoSuchScheduleId if no task with scheduleId is waiting to start and
+// ErrNoLights leaving the task alone if the hue task would use none of
+// the lights in lightSet.
+func (m *MultiTimer) UpdateLights(
+	scheduleId string, lightSet lights.Set) (string, error) {
+	return m.replace(scheduleId, func(task *ops.AtTimeTask) error {
+		usedLights := task.H.UsedLights(lightSet)
+		if usedLights.IsNone() {
+			return ErrNoLights
+		}
+		task.Ls = usedLights
+		return nil
+	})
+}
+
+// replace cancels the scheduled task with given schedule Id and schedules
+// it again after f changes it.
+func (m *MultiTimer) replace(
+	scheduleId string, f func(task *ops.AtTimeTask) error) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var wrapper *TimerTaskWrapper
+	for _, scheduled := range m.Scheduled() {
+		if scheduled.TaskId() == scheduleId {
+			wrapper = scheduled
+			break
+		}
+	}
+	if wrapper == nil {
+		return "", ErrNoSuchScheduleId
+	}
+	task := &ops.AtTimeTask{
+		H:            wrapper.H,
+		Ls:           wrapper.Ls,
+		StartTime:    wrapper.StartTime,
+		EndTime:      wrapper.EndTime,
+		RestoreAtEnd: wrapper.RestoreAtEnd,
+	}
+	if err := f(task); err != nil {
+		return "", err
+	}
+	m.Cancel(scheduleId)
+
+	// The task may have started before we could cancel it.
+	if wrapper.hasFired() {
+		return "", ErrNoSuchScheduleId
+	}
+	task.Id = m.schedule(task)
+	m.store.Add(task)
+	return task.Id, nil
+}
+
 // Interface LightReaderWriter can both read and update the state of lights
 type LightReaderWriter interface {
 	ops.Context
 	ops.LightReader
 }
 
-// Stack consists of two MultiExecutors: the main one, Base, and an extra
-// one Extra. Calling Push pauses Base, saves the state of the lights
-// and resumes Extra. Then Extra can be used to run programs without
-// messing up what was running in Base. Finally call Pop to pause Extra,
-// restore the lights and resume Base as if no programs were ever run
-// on Extra.
+var (
+	// Indicates that no scheduled task has the given schedule Id.
+	ErrNoSuchScheduleId = errors.New("utils: No such schedule Id.")
+
+	// Indicates that a hue task would run on no lights.
+	ErrNoLights = errors.New("utils: No lights.")
+
+	// Indicates that the Stack is closed.
+	ErrStackClosed = errors.New("utils: Stack closed.")
+
+	// Indicates that Pop was called with only the base frame left.
+	ErrStackEmpty = errors.New("utils: Stack empty.")
+
+	// Indicates that the lights read back after restoring them do not
+	// match the restored state.
+	ErrRestoreMismatch = errors.New("utils: Restored lights do not match.")
+)
+
+// kColorTolerance is how far a restored color may be from the saved
+// color in x or y as bridges round colors.
+const kColorTolerance = 0.01
+
+// RestorePolicy tells a Stack how Pop restores the lights.
+type RestorePolicy struct {
+	// How many times to retry restoring the lights before giving up.
+	// 0 means no retries.
+	MaxRetries int
+
+	// How long to wait before the first retry. 0 means one second.
+	InitialBackoff time.Duration
+
+	// The wait doubles after each retry up to MaxBackoff. 0 means no
+	// limit.
+	MaxBackoff time.Duration
+
+	// If true, Pop reads the lights back after restoring them and treats
+	// lights that do not match the saved state as a failure.
+	Verify bool
+
+	// If non-nil, Pop calls OnFailure with the error after giving up.
+	// OnFailure runs on the goroutine calling Pop after Pop has finished
+	// with the Stack, so it may call Resume.
+	OnFailure func(err *RestoreError)
+}
+
+// RestoreError indicates that Pop could not restore all the lights.
+type RestoreError struct {
+	// The name of the popped frame.
+	Frame string
+
+	// The error restoring the lights.
+	Err error
+
+	// How many times Pop tried to restore the lights.
+	Attempts int
+}
+
+func (e *RestoreError) Error() string {
+	return fmt.Sprintf("utils: Restoring lights for %q: %v", e.Frame, e.Err)
+}
+
+func (e *RestoreError) Unwrap() error {
+	return e.Err
+}
+
+// Stack is a stack of frames where each frame has its own MultiExecutor.
+// Only the MultiExecutor of the top frame runs; the others stay paused.
+// Calling Push pauses the top frame, saves the state of the lights and
+// pushes a new frame with a new MultiExecutor. Then the new MultiExecutor
+// can be used to run programs without messing up what was running
+// underneath. Finally call Pop to close the top MultiExecutor, restore
+// the lights and resume the frame underneath as if no programs were ever
+// run on the popped frame. Frames nest to any depth so that a doorbell
+// flash can interrupt a movie mode which itself interrupted the base
+// schedule. If the context implements ops.LightStateReader and
+// ops.LightStateWriter, Stack saves and restores the full state of the
+// lights including color temperature and effects. If the context
+// implements ops.CancelableContext, calls to the hue bridge abort when
+// the context.Context passed to PushContext or PopContext is done.
 // Stack can be safely used with multiple goroutines.
 type Stack struct {
-	Base  *MultiExecutor
-	Extra *MultiExecutor
+	// The MultiExecutor of the bottom frame. Pop never removes it.
+	Base *MultiExecutor
 	// All the lights that this instance controls
 	AllLights lights.Set
 	context   LightReaderWriter
-	slog      *log.Logger
-	first     chan struct{}
-	second    chan struct{}
-	third     chan struct{}
-	fourth    chan struct{}
+	requests  chan func()
+	closed    chan struct{}
+	mu        sync.Mutex
+	// frames[0] is the base frame. Only loop changes frames.
+	frames []stackFrame
+	// True if a failed Pop left the top frame paused. Accessed only by loop.
+	topPaused bool
+	// Guarded by mu.
+	restorePolicy RestorePolicy
+}
+
+type stackFrame struct {
+	name        string
+	executor    *MultiExecutor
+	lightStates ops.LightStates
 }
 
-// NewStack creates a new Stack instance.
+// NewStack creates a new Stack instance whose bottom frame runs on base.
+// Callers must call Close when done with the returned Stack.
 func NewStack(
-	base, extra *MultiExecutor,
+	base *MultiExecutor,
 	context LightReaderWriter,
-	allLights lights.Set,
-	slog *log.Logger) *Stack {
+	allLights lights.Set) *Stack {
 	result := &Stack{
 		Base:      base,
-		Extra:     extra,
 		AllLights: allLights,
 		context:   context,
-		slog:      slog,
-		first:     make(chan struct{}),
-		second:    make(chan struct{}),
-		third:     make(chan struct{}),
-		fourth:    make(chan struct{})}
+		requests:  make(chan func()),
+		closed:    make(chan struct{}),
+		frames:    []stackFrame{{executor: base}},
+	}
 	go result.loop()
 	return result
 }
 
-func (s *Stack) Push() {
-	var empty struct{}
-	s.first <- empty
-	<-s.second
+// Push pauses the top frame, saves the state of the lights, and pushes
+// a new frame. name names the new frame and its MultiExecutor. Push
+// returns the MultiExecutor of the new frame. If Push cannot save the
+// state of the lights, Push resumes the top frame and returns the error
+// without pushing a new frame.
+func (s *Stack) Push(name string) (*MultiExecutor, error) {
+	return s.PushContext(context.Background(), name)
+}
+
+// PushContext works like Push except that it gives up if ctx finishes
+// first. Then PushContext resumes the top frame and returns ctx.Err()
+// without pushing a new frame. PushContext returns as soon as ctx
+// finishes even if the hue bridge is not responding; the Stack then
+// rolls back once the bridge responds.
+func (s *Stack) PushContext(ctx context.Context, name string) (
+	*MultiExecutor, error) {
+	// Buffered since do may return before push does.
+	results := make(chan *MultiExecutor, 1)
+	err := s.do(ctx, func() error {
+		result, err := s.push(ctx, name)
+		results <- result
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return <-results, nil
+}
+
+// Pop closes the MultiExecutor of the top frame, restores the lights to
+// how they were when that frame was pushed, and resumes the frame
+// underneath. Pop returns ErrStackEmpty if only the base frame is left.
+// Pop retries restoring the lights according to the RestorePolicy of
+// this instance. If Pop cannot restore all the lights, Pop still removes
+// the top frame but leaves the frame underneath paused and returns a
+// *RestoreError so that it does not resume against the wrong light state.
+// The caller can then fix the lights and call Resume.
+func (s *Stack) Pop() error {
+	return s.PopContext(context.Background())
+}
+
+// PopContext works like Pop except that it gives up if ctx finishes
+// before the lights are restored. Then PopContext returns ctx.Err()
+// leaving the top frame on the stack and running with the lights put
+// back to how the top frame left them. Like PushContext, PopContext
+// returns as soon as ctx finishes, and the rollback finishes in the
+// background before the Stack does anything else. If the rollback fails,
+// the top frame stays paused until Resume.
+func (s *Stack) PopContext(ctx context.Context) error {
+	policy := s.currentRestorePolicy()
+	err := s.do(ctx, func() error {
+		return s.pop(ctx, policy)
+	})
+	if restoreErr, ok := err.(*RestoreError); ok && policy.OnFailure != nil {
+		policy.OnFailure(restoreErr)
+	}
+	return err
+}
+
+// SetRestorePolicy sets how Pop restores the lights. By default, Pop tries
+// once without verifying.
+func (s *Stack) SetRestorePolicy(policy RestorePolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.restorePolicy = policy
+}
+
+func (s *Stack) currentRestorePolicy() RestorePolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restorePolicy
+}
+
+// Resume resumes the top frame if a failed Pop left it paused.
+func (s *Stack) Resume(ctx context.Context) error {
+	return s.do(ctx, func() error {
+		if s.topPaused {
+			s.frames[len(s.frames)-1].executor.Resume()
+			s.topPaused = false
+		}
+		return nil
+	})
+}
+
+// Close stops this Stack and closes the MultiExecutors of the frames
+// above the base frame without restoring the lights. Close leaves Base
+// as is. After Close, Push and Pop return ErrStackClosed.
+func (s *Stack) Close() error {
+	err := s.do(context.Background(), func() error {
+		for _, frame := range s.frames[1:] {
+			frame.executor.Close()
+		}
+		s.setFrames(s.frames[:1])
+		close(s.closed)
+		return nil
+	})
+	if err == ErrStackClosed {
+		return nil
+	}
+	return err
+}
+
+// Top returns the MultiExecutor of the top frame.
+func (s *Stack) Top() *MultiExecutor {
+	frames := s.currentFrames()
+	return frames[len(frames)-1].executor
 }
 
-func (s *Stack) Pop() {
-	var empty struct{}
-	s.third <- empty
-	<-s.fourth
+// Names returns the names of the frames above the base frame from the
+// bottom up.
+func (s *Stack) Names() []string {
+	frames := s.currentFrames()
+	result := make([]string, len(frames)-1)
+	for i := range result {
+		result[i] = frames[i+1].name
+	}
+	return result
+}
+
+func (s *Stack) currentFrames() []stackFrame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.frames
+}
+
+// do runs request on the goroutine of this Stack and returns what
+// request returns. If ctx finishes first, do returns ctx.Err() right away
+// and request, if already started, must undo its work once it sees that
+// ctx finished. If request has already finished when ctx finishes, do
+// returns what request returned.
+func (s *Stack) do(ctx context.Context, request func() error) error {
+	done := make(chan error, 1)
+	select {
+	case s.requests <- func() { done <- request() }:
+	case <-s.closed:
+		return ErrStackClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// select picks at random when both are ready.
+		select {
+		case err := <-done:
+			return err
+		default:
+			return ctx.Err()
+		}
+	}
 }
 
 func (s *Stack) loop() {
-	var empty struct{}
 	for {
-		<-s.first
-		s.Base.Pause()
-
-		// Be sure that commands that just finished running take effect before
-		// taking the state of all the lights. By default, hue lights have a
-		// 400ms fade in.
-		time.Sleep(500 * time.Millisecond)
-		lightColors, err := ops.Snapshot(s.context, s.AllLights)
-		if err != nil {
-			s.slog.Printf("ERROR: %v\n", err)
-		}
-		s.Extra.Resume()
-		s.second <- empty
-		<-s.third
-		s.Extra.Pause()
-		if lightColors != nil {
-			err = ops.Restore(s.context, lightColors)
-			if err != nil {
-				s.slog.Printf("ERROR: %v\n", err)
+		select {
+		case request := <-s.requests:
+			request()
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func (s *Stack) push(ctx context.Context, name string) (
+	*MultiExecutor, error) {
+	top := s.frames[len(s.frames)-1].executor
+	if !s.topPaused {
+		top.Pause()
+	}
+
+	// Be sure that commands that just finished running take effect before
+	// taking the state of all the lights. By default, hue lights have a
+	// 400ms fade in.
+	timer := time.NewTimer(500 * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		s.resumeTop()
+		return nil, ctx.Err()
+	case <-timer.C:
+	}
+	lightStates, err := ops.SnapshotStates(s.bind(ctx), s.AllLights)
+	if err == nil {
+		// The caller may have already given up.
+		err = ctx.Err()
+	}
+	if err != nil {
+		s.resumeTop()
+		return nil, err
+	}
+	s.topPaused = false
+	executor := top.newChild(name, s.context)
+	s.setFrames(append(s.frames, stackFrame{
+		name:        name,
+		executor:    executor,
+		lightStates: lightStates,
+	}))
+	return executor, nil
+}
+
+func (s *Stack) pop(ctx context.Context, policy RestorePolicy) error {
+	if len(s.frames) == 1 {
+		return ErrStackEmpty
+	}
+	top := s.frames[len(s.frames)-1]
+	top.executor.Pause()
+
+	// Take the lights as top left them so that we can put them back if
+	// ctx finishes in the middle of restoring.
+	lightSet := make(lights.Set, len(top.lightStates))
+	for id := range top.lightStates {
+		lightSet[id] = true
+	}
+	current, err := ops.SnapshotStates(s.bind(ctx), lightSet)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		top.executor.Resume()
+		return err
+	}
+	attempts, err := s.restore(ctx, top.lightStates, policy)
+	if ctx.Err() != nil {
+		// Roll back leaving top running. The caller is gone, so the
+		// rollback does not honor ctx. If the rollback fails, top stays
+		// paused until Resume.
+		if err := ops.RestoreStates(s.context, current); err != nil {
+			s.topPaused = true
+			return ctx.Err()
+		}
+		top.executor.Resume()
+		return ctx.Err()
+	}
+	top.executor.Close()
+	s.setFrames(s.frames[:len(s.frames)-1])
+	s.topPaused = false
+	if err != nil {
+		s.topPaused = true
+		return &RestoreError{Frame: top.name, Err: err, Attempts: attempts}
+	}
+	s.frames[len(s.frames)-1].executor.Resume()
+	return nil
+}
+
+// restore restores the lights to states retrying according to policy.
+// restore returns how many times it tried along with the last error.
+func (s *Stack) restore(
+	ctx context.Context,
+	states ops.LightStates,
+	policy RestorePolicy) (int, error) {
+	backoff := policy.InitialBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for attempts := 1; ; attempts++ {
+		err := s.restoreOnce(ctx, states, policy.Verify)
+		if err == nil || attempts > policy.MaxRetries {
+			return attempts, err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, err
+		case <-timer.C:
+		}
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// restoreOnce restores the lights to states. If verify is true,
+// restoreOnce reads the lights back and returns ErrRestoreMismatch if they
+// do not match states.
+func (s *Stack) restoreOnce(
+	ctx context.Context, states ops.LightStates, verify bool) error {
+	bound := s.bind(ctx)
+	if err := ops.RestoreStates(bound, states); err != nil {
+		return err
+	}
+	if !verify {
+		return nil
+	}
+	lightSet := make(lights.Set, len(states))
+	for id := range states {
+		lightSet[id] = true
+	}
+	actual, err := ops.SnapshotStates(bound, lightSet)
+	if err != nil {
+		return err
+	}
+	if !statesMatch(states, actual) {
+		return ErrRestoreMismatch
+	}
+	return nil
+}
+
+// statesMatch returns true if the lights in actual show what is in
+// expected allowing for bridges rounding colors.
+func statesMatch(expected, actual ops.LightStates) bool {
+	for id, e := range expected {
+		a, ok := actual[id]
+		if !ok || a.On != e.On {
+			return false
+		}
+		if !e.On {
+			continue
+		}
+		if e.Brightness.Valid && a.Brightness != e.Brightness {
+			return false
+		}
+		if e.Color.Valid {
+			if !a.Color.Valid ||
+				math.Abs(a.Color.X()-e.Color.X()) > kColorTolerance ||
+				math.Abs(a.Color.Y()-e.Color.Y()) > kColorTolerance {
+				return false
 			}
 		}
-		s.Base.Resume()
-		s.fourth <- empty
 	}
+	return true
 }
 
-// NewTemplate returns a new template instance. name is the name
-// of the template; templateStr is the template string.
-func NewTemplate(name, templateStr string) *template.Template {
-	return template.Must(template.New(name).Parse(templateStr))
+// resumeTop resumes the top frame unless a failed Pop left it paused.
+func (s *Stack) resumeTop() {
+	if !s.topPaused {
+		s.frames[len(s.frames)-1].executor.Resume()
+	}
+}
+
+// bind returns the context of this instance bound to ctx if possible.
+func (s *Stack) bind(ctx context.Context) LightReaderWriter {
+	if cancelable, ok := s.context.(ops.CancelableContext); ok {
+		bound, ok := cancelable.WithContext(ctx).(LightReaderWriter)
+		if ok {
+			return bound
+		}
+	}
+	return s.context
+}
+
+// setFrames sets the frames making a copy so that slices returned
+// from currentFrames stay unchanged.
+func (s *Stack) setFrames(frames []stackFrame) {
+	copied := make([]stackFrame, len(frames))
+	copy(copied, frames)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frames = copied
 }
 
 // Task represents a Task that works with TaskCollection
@@ -513,28 +1624,38 @@ type Task interface {
 	TaskId() string
 }
 
-// TaskCollection represents running tasks and implements tasks.TaskCollection.
-// It adds the Tasks method to get all running tasks and the FindByTaskId
-// method to find the execution of a particular task.
-type TaskCollection struct {
+// TaskCollection represents running tasks of type T and implements
+// tasks.TaskCollection. It adds the Tasks method to get all running tasks
+// and the FindByTaskId method to find the execution of a particular task.
+type TaskCollection[T Task] struct {
 	rwmutex sync.RWMutex
-	tasks   []taskExecution
+	tasks   []taskExecution[T]
+	// closed and cleared each time a task is removed
+	removed chan struct{}
 }
 
-func (c *TaskCollection) Add(t tasks.Task, e *tasks.Execution) {
-	task := t.(Task)
+// Add adds t and its execution. Add panics if t is not a T as only the
+// executor that owns this collection may call Add.
+func (c *TaskCollection[T]) Add(t tasks.Task, e *tasks.Execution) {
+	task, ok := t.(T)
+	if !ok {
+		panic(fmt.Sprintf("utils: TaskCollection got unexpected task %T", t))
+	}
 	c.rwmutex.Lock()
 	defer c.rwmutex.Unlock()
-	c.tasks = append(c.tasks, taskExecution{t: task, e: e})
+	c.tasks = append(c.tasks, taskExecution[T]{t: task, e: e})
 }
 
-func (c *TaskCollection) Remove(t tasks.Task) {
-	task := t.(Task)
+func (c *TaskCollection[T]) Remove(t tasks.Task) {
+	task, ok := t.(T)
+	if !ok {
+		return
+	}
 	c.rwmutex.Lock()
 	defer c.rwmutex.Unlock()
 	idx := -1
 	for i := range c.tasks {
-		if c.tasks[i].t == task {
+		if Task(c.tasks[i].t) == Task(task) {
 			idx = i
 			break
 		}
@@ -543,10 +1664,35 @@ func (c *TaskCollection) Remove(t tasks.Task) {
 		copied := copy(c.tasks[idx:], c.tasks[idx+1:])
 		c.tasks = c.tasks[:idx+copied]
 	}
+	if c.removed != nil {
+		close(c.removed)
+		c.removed = nil
+	}
+}
+
+// removedCh returns a channel that closes the next time a task is
+// removed.
+func (c *TaskCollection[T]) removedCh() <-chan struct{} {
+	c.rwmutex.Lock()
+	defer c.rwmutex.Unlock()
+	if c.removed == nil {
+		c.removed = make(chan struct{})
+	}
+	return c.removed
 }
 
-func (c *TaskCollection) Conflicts(t tasks.Task) []*tasks.Execution {
-	task, _ := t.(Task)
+// Conflicts returns the executions of the tasks that conflict with t.
+// If t is nil, Conflicts returns the executions of all the tasks. If t
+// is not a T, nothing conflicts with it.
+func (c *TaskCollection[T]) Conflicts(t tasks.Task) []*tasks.Execution {
+	var task Task
+	if t != nil {
+		typed, ok := t.(T)
+		if !ok {
+			return nil
+		}
+		task = typed
+	}
 	c.rwmutex.RLock()
 	defer c.rwmutex.RUnlock()
 	result := make([]*tasks.Execution, len(c.tasks))
@@ -560,22 +1706,20 @@ func (c *TaskCollection) Conflicts(t tasks.Task) []*tasks.Execution {
 	return result[:idx]
 }
 
-// Gets all running tasks. aSlicePtr points to the slice to hold the
-// running tasks.
-func (c *TaskCollection) Tasks(aSlicePtr interface{}) {
+// Tasks returns all running tasks.
+func (c *TaskCollection[T]) Tasks() []T {
 	c.rwmutex.RLock()
 	defer c.rwmutex.RUnlock()
-	sliceValue := reflect.Indirect(reflect.ValueOf(aSlicePtr))
-	sliceValue.Set(reflect.MakeSlice(
-		sliceValue.Type(), len(c.tasks), len(c.tasks)))
+	result := make([]T, len(c.tasks))
 	for i := range c.tasks {
-		sliceValue.Index(i).Set(reflect.ValueOf(c.tasks[i].t))
+		result[i] = c.tasks[i].t
 	}
+	return result
 }
 
 // FindByTaskId returns the execution of a particular task or nil if that
 // task is not found.
-func (c *TaskCollection) FindByTaskId(taskId string) *tasks.Execution {
+func (c *TaskCollection[T]) FindByTaskId(taskId string) *tasks.Execution {
 	c.rwmutex.RLock()
 	defer c.rwmutex.RUnlock()
 	for i := range c.tasks {
@@ -595,38 +1739,59 @@ type HueTaskWrapper struct {
 	// Empty set means all lights
 	Ls lights.Set
 
+	// The priority of the hue task. See MultiExecutor.StartWithPriority.
+	Priority int
+
 	// The context
 	c ops.Context
 
 	// The log
-	log *log.Logger
+	log Logger
 
 	// Name of enclosing MultiExecutor
 	name string
+
+	listeners *listenerList
 }
 
-// Do performs the task
+// Do performs the task. If the context implements ops.CancelableContext,
+// Do binds it to e so that interrupting the task aborts calls to the hue
+// bridge right away.
 func (t *HueTaskWrapper) Do(e *tasks.Execution) {
+	c, cancel := ops.BindContext(t.c, e)
+	defer cancel()
+	t.logEvent("START")
+	t.listeners.started(t)
+	t.H.Do(c, t.Ls, e)
+	if err := e.Error(); err != nil {
+		t.logEvent("ERROR", NewField("error", err))
+		t.listeners.failed(t, err)
+	} else if e.IsEnded() {
+		t.logEvent("INTERRUPTED")
+		t.listeners.interrupted(t)
+	} else {
+		t.logEvent("FINISH")
+		t.listeners.finished(t)
+	}
+}
+
+func (t *HueTaskWrapper) logEvent(msg string, extra ...Field) {
 	// This added for testing for when there is no log.
 	if t.log == nil {
-		t.H.Do(t.c, t.Ls, e)
 		return
 	}
-	t.log.Printf("START: %s", t)
-	t.H.Do(t.c, t.Ls, e)
-	if err := e.Error(); err != nil {
-		t.log.Printf("ERROR: %s: %v\n", t, err)
-	} else if e.IsEnded() {
-		t.log.Printf("INTERRUPTED: %s", t)
-	} else {
-		t.log.Printf("FINISH: %s", t)
+	fields := []Field{
+		NewField("executor", t.name),
+		NewField("task_id", t.TaskId()),
+		NewField("description", t.H.Description),
+		NewField("lights", t.Ls),
 	}
+	t.log.Log(msg, append(fields, extra...)...)
 }
 
 func (t *HueTaskWrapper) ConflictsWith(other Task) bool {
-	ls := t.Ls
-	otherLs := other.(*HueTaskWrapper).Ls
-	return ls.OverlapsWith(otherLs)
+	otherTask, ok := other.(*HueTaskWrapper)
+	return ok && t.Ls.OverlapsWith(otherTask.Ls)
 }
 
 // TaskId is a combination of the hue task Id and the light set.
@@ -651,24 +1816,78 @@ type TimerTaskWrapper struct {
 	// The time to start
 	StartTime time.Time
 
+	// The time to end. The zero value means the hue task ends on its own.
+	EndTime time.Time
+
+	// If true, lights are restored rather than turned off at EndTime.
+	RestoreAtEnd bool
+
 	executor HueTaskBeginner
 
 	store AtTimeTaskStore
+
+	listeners *listenerList
+
+	// Set once the hue task is sent to the executor
+	fired int32
 }
 
+// Do waits for the start time and then begins the hue task. If the hue
+// task has an end time, its row stays in the store until the action at
+// the end time has run so that a task whose window was cut short by a
+// restart resumes when its row is loaded again before its end time.
 func (t *TimerTaskWrapper) Do(e *tasks.Execution) {
+	t.listeners.started(t)
 	d := t.StartTime.Sub(e.Now())
-	if d > 0 && e.Sleep(d) {
-		t.executor.Begin(t.H, t.Ls)
+	inWindow := d <= 0 && t.EndTime.After(e.Now())
+	if (d > 0 && e.Sleep(d)) || inWindow {
+		atomic.StoreInt32(&t.fired, 1)
+		task := &ops.AtTimeTask{
+			H:            t.H,
+			EndTime:      t.EndTime,
+			RestoreAtEnd: t.RestoreAtEnd,
+		}
+		h := task.HueTaskToRun()
+		if !t.EndTime.IsZero() {
+			h = t.removeAtEnd(h)
+		}
+		t.executor.Begin(h, t.Ls)
+		t.listeners.finished(t)
+		if !t.EndTime.IsZero() {
+			return
+		}
+	} else {
+		t.listeners.interrupted(t)
 	}
 	t.store.Remove(t.TaskId())
 }
 
+// removeAtEnd returns a hue task that works like h except that it removes
+// the row of this instance from the store once h has reached the end
+// time of this instance or has failed.
+func (t *TimerTaskWrapper) removeAtEnd(h *ops.HueTask) *ops.HueTask {
+	return &ops.HueTask{
+		Id: h.Id,
+		HueAction: &removeAtEndAction{
+			HueAction:  h.HueAction,
+			endTime:    t.EndTime,
+			scheduleId: t.TaskId(),
+			store:      t.store,
+		},
+		Description: h.Description,
+		Tags:        h.Tags,
+	}
+}
+
+func (t *TimerTaskWrapper) hasFired() bool {
+	return atomic.LoadInt32(&t.fired) != 0
+}
+
 func (t *TimerTaskWrapper) ConflictsWith(other Task) bool {
-	otherTask := other.(*TimerTaskWrapper)
+	otherTask, ok := other.(*TimerTaskWrapper)
 	// We compare unix times to ensure that tasks with the same task ID
 	// conflict.
-	return t.StartTime.Unix() == otherTask.StartTime.Unix() && t.Ls.OverlapsWith(otherTask.Ls)
+	return ok && t.StartTime.Unix() == otherTask.StartTime.Unix() && t.Ls.OverlapsWith(otherTask.Ls)
 }
 
 // TaskId is combination of hue task Id, light set, and start time
@@ -712,8 +1931,27 @@ func FutureTime(now time.Time, hour, minute int) time.Time {
 	return result
 }
 
-type taskExecution struct {
-	t Task
+// removeAtEndAction removes a row from an AtTimeTaskStore after its
+// HueAction runs until endTime. If the HueAction stops early without
+// an error, for instance because the process is shutting down, the row
+// stays.
+type removeAtEndAction struct {
+	ops.HueAction
+	endTime    time.Time
+	scheduleId string
+	store      AtTimeTaskStore
+}
+
+func (a *removeAtEndAction) Do(
+	ctxt ops.Context, lightSet lights.Set, e *tasks.Execution) {
+	a.HueAction.Do(ctxt, lightSet, e)
+	if e.Error() != nil || !e.Now().Before(a.endTime) {
+		a.store.Remove(a.scheduleId)
+	}
+}
+
+type taskExecution[T Task] struct {
+	t T
 	e *tasks.Execution
 }
 
@@ -1,11 +1,19 @@
 package utils_test
 
 import (
+	"context"
+	"errors"
+	"github.com/keep94/gohue"
 	"github.com/keep94/marvin2/lights"
 	"github.com/keep94/marvin2/ops"
 	"github.com/keep94/marvin2/utils"
+	"github.com/keep94/maybe"
 	"github.com/keep94/tasks"
+	"github.com/keep94/tasks/recurring"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -32,7 +40,7 @@ func TestTaskCollection(t *testing.T) {
 		H: &ops.HueTask{Id: 49}, Ls: lights.New(5, 6)}
 	htwAll := &utils.HueTaskWrapper{H: &ops.HueTask{Id: 50}}
 
-	coll := &utils.TaskCollection{}
+	coll := &utils.TaskCollection[*utils.HueTaskWrapper]{}
 
 	// Test adding
 	coll.Add(htw1, e1)
@@ -69,6 +77,12 @@ func TestTaskCollection(t *testing.T) {
 	coll.Add(htwAll, e1)
 	verifyConflicts(t, coll.Conflicts(htw4), e1)
 	verifyExecution(t, e1, coll.FindByTaskId("50:All"))
+
+	// Tasks of another type conflict with nothing
+	timerTask := &utils.TimerTaskWrapper{H: &ops.HueTask{Id: 50}}
+	verifyConflicts(t, coll.Conflicts(timerTask))
+	coll.Remove(timerTask)
+	verifyTasks(t, coll, htwAll)
 }
 
 func TestTimerTaskWrapper(t *testing.T) {
@@ -195,6 +209,718 @@ func TestMaybeStartUsedLights3(t *testing.T) {
 	verifyHueTaskLights(t, te.Tasks(), "1,2")
 }
 
+func TestStartWithPriority(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	te.StartWithPriority(newHueTask(5), lights.New(1, 2), 50)
+	te.StartWithPriority(newHueTask(6), lights.New(2, 3), 50)
+	verifyHueTaskIds(t, te.Tasks(), 5, 6)
+	verifyHueTaskLights(t, te.Tasks(), "1,2", "3")
+	te.StartWithPriority(newHueTask(7), lights.New(1), 60)
+	verifyHueTaskIds(t, te.Tasks(), 6, 7)
+	te.MaybeStart(newHueTask(8), lights.New(2))
+	te.StartWithPriority(newHueTask(9), lights.All, 60)
+	verifyHueTaskIds(t, te.Tasks(), 6, 7, 8)
+	verifyHueTaskLights(t, te.Tasks(), "3", "1", "2")
+	if priority := te.Tasks()[1].Priority; priority != 60 {
+		t.Errorf("Expected priority 60, got %d", priority)
+	}
+	te.Start(newHueTask(10), lights.All)
+	verifyHueTaskIds(t, te.Tasks(), 10)
+	if priority := te.Tasks()[0].Priority; priority != utils.PriorityHigh {
+		t.Errorf("Expected high priority, got %d", priority)
+	}
+}
+
+func TestFairnessPolicy(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	te.SetFairnessPolicy(utils.SkipLimit(2))
+	te.StartWithPriority(newHueTask(5), lights.New(1, 2), 50)
+	if e := te.MaybeStart(newHueTask(6), lights.New(1)); e != nil {
+		t.Error("Expected first try to be skipped")
+	}
+	if e := te.MaybeStart(newHueTask(7), lights.New(2)); e != nil {
+		t.Error("Expected other hue task to be skipped")
+	}
+	if e := te.MaybeStart(newHueTask(6), lights.New(1)); e != nil {
+		t.Error("Expected second try to be skipped")
+	}
+	if e := te.MaybeStart(newHueTask(6), lights.New(1)); e == nil {
+		t.Fatal("Expected third try to claim its lights")
+	}
+	verifyHueTaskIds(t, te.Tasks(), 6)
+	if priority := te.Tasks()[0].Priority; priority != utils.PriorityHigh {
+		t.Errorf("Expected PriorityHigh, got %d", priority)
+	}
+	// Hue task 7 was skipped only once.
+	te.StartWithPriority(newHueTask(8), lights.New(2), 50)
+	if e := te.MaybeStart(newHueTask(7), lights.New(2)); e != nil {
+		t.Error("Expected hue task 7 to be skipped")
+	}
+	if e := te.MaybeStart(newHueTask(7), lights.New(2)); e == nil {
+		t.Error("Expected hue task 7 to claim its lights")
+	}
+	verifyHueTaskIds(t, te.Tasks(), 6, 7)
+}
+
+func TestFairnessPolicyCap(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	te.SetFairnessPolicy(utils.SkipLimit(1))
+	// Tasks that Start starts such as security tasks are never preempted.
+	te.Start(newHueTask(5), lights.New(1))
+	for i := 0; i < 5; i++ {
+		if e := te.MaybeStart(newHueTask(6), lights.New(1)); e != nil {
+			t.Fatal("Expected starved task not to preempt Start")
+		}
+	}
+	verifyHueTaskIds(t, te.Tasks(), 5)
+	if priority := utils.SkipLimit(1).Priority(utils.PriorityHigh+5, 3); priority != utils.PriorityHigh+5 {
+		t.Errorf("Expected priority to stay, got %d", priority)
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	te.Start(newHueTask(5), lights.New(1, 2))
+	if e := te.Enqueue(newHueTask(6), lights.New(2), 10*time.Millisecond); e != nil {
+		t.Error("Expected Enqueue to time out")
+	}
+	done := make(chan *tasks.Execution)
+	go func() {
+		done <- te.Enqueue(newHueTask(7), lights.New(2, 3), time.Minute)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	verifyHueTaskIds(t, te.Tasks(), 5)
+	te.Stop("5:1,2")
+	if e := <-done; e == nil {
+		t.Fatal("Expected Enqueue to start task")
+	}
+	verifyHueTaskIds(t, te.Tasks(), 7)
+	verifyHueTaskLights(t, te.Tasks(), "2,3")
+}
+
+func TestEnqueueKeepsWaiting(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	te.Start(newHueTask(5), lights.New(1))
+	done := make(chan *tasks.Execution, 2)
+	for _, id := range []int{6, 7} {
+		go func(id int) {
+			done <- te.Enqueue(newHueTask(id), lights.New(1), time.Minute)
+		}(id)
+	}
+	time.Sleep(10 * time.Millisecond)
+	te.Stop("5:1")
+	first := <-done
+	if first == nil {
+		t.Fatal("Expected Enqueue to start a task")
+	}
+	select {
+	case e := <-done:
+		t.Fatalf("Expected other Enqueue to keep waiting, got %v", e)
+	case <-time.After(10 * time.Millisecond):
+	}
+	first.End()
+	if second := <-done; second == nil {
+		t.Error("Expected other Enqueue to start its task")
+	}
+}
+
+func TestEnqueueWithPriority(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	te.StartWithPriority(newHueTask(5), lights.New(1), 10)
+	te.StartWithPriority(newHueTask(6), lights.New(2), 50)
+	if e := te.EnqueueWithPriority(
+		newHueTask(7), lights.New(1), 30, time.Minute); e == nil {
+		t.Error("Expected to interrupt lower priority task right away")
+	}
+	if e := te.EnqueueWithPriority(
+		newHueTask(8), lights.New(2), 30, 10*time.Millisecond); e != nil {
+		t.Error("Expected to wait for higher priority task")
+	}
+	verifyHueTaskIds(t, te.Tasks(), 6, 7)
+}
+
+func TestListener(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	listener := make(recordingListener, 10)
+	te.AddListener(listener)
+	e := te.Start(newHueTaskWithAction(5, intAction(5)), lights.All)
+	<-e.Done()
+	listener.Verify(t, "START 5:All", "FINISH 5:All")
+	te.Start(newHueTask(6), lights.New(1))
+	te.Stop("6:1")
+	listener.Verify(t, "START 6:1", "INTERRUPTED 6:1")
+	e = te.Start(newHueTaskWithAction(7, errAction{}), lights.New(2))
+	<-e.Done()
+	listener.Verify(t, "START 7:2", "ERROR 7:2")
+
+	now := time.Unix(1400000000, 0)
+	beginnerActivity := make(chan interface{}, 10)
+	mt := utils.NewMultiTimerWithStoreAndClock(
+		hueTaskBeginner{beginnerActivity},
+		&atTimeTaskStore{Activity: make(chan interface{}, 10)},
+		tasks.NewFakeClock(now))
+	mt.AddListener(listener)
+	mt.Schedule(newHueTask(8), lights.All, now.Add(time.Hour))
+	mt.Cancel("8:1400003600:All")
+	listener.Verify(t, "START 8:1400003600:All", "INTERRUPTED 8:1400003600:All")
+}
+
+func TestHistory(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	if history := te.History(); len(history) != 0 {
+		t.Errorf("Expected empty history, got %v", history)
+	}
+	e := te.Start(newHueTaskWithAction(5, errAction{}), lights.New(1))
+	<-e.Done()
+	te.Start(newHueTask(6), lights.New(2))
+	te.Stop("6:2")
+	history := te.History()
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 runs, got %v", history)
+	}
+	if history[0].HueTaskId != 6 || history[0].Outcome != utils.OutcomeInterrupted || history[0].Lights.String() != "2" {
+		t.Errorf("Unexpected run: %+v", history[0])
+	}
+	if history[1].HueTaskId != 5 || history[1].Outcome != utils.OutcomeError || history[1].Err == nil {
+		t.Errorf("Unexpected run: %+v", history[1])
+	}
+	if history[0].End.Before(history[0].Start) {
+		t.Errorf("Expected end after start: %+v", history[0])
+	}
+	for i := 0; i < 60; i++ {
+		e := te.Start(newHueTaskWithAction(100+i, intAction(i)), lights.All)
+		<-e.Done()
+	}
+	history = te.History()
+	if len(history) != 50 {
+		t.Fatalf("Expected 50 runs, got %d", len(history))
+	}
+	if history[0].HueTaskId != 159 || history[49].HueTaskId != 110 {
+		t.Errorf("Expected runs 159 through 110, got %d through %d",
+			history[0].HueTaskId, history[49].HueTaskId)
+	}
+	if history[0].Outcome != utils.OutcomeFinished {
+		t.Errorf("Expected finished, got %v", history[0].Outcome)
+	}
+}
+
+func TestStack(t *testing.T) {
+	ctxt := newFakeLights()
+	ctxt.setBrightness(1, 10)
+	base := utils.NewMultiExecutor(ctxt, nil)
+	defer base.Close()
+	stack := utils.NewStack(base, ctxt, lights.New(1))
+	defer stack.Close()
+	if stack.Top() != base {
+		t.Error("Expected base on top")
+	}
+	movie, err := stack.Push("movie")
+	if err != nil {
+		t.Fatalf("Error pushing movie: %v", err)
+	}
+	ctxt.setBrightness(1, 20)
+	doorbell, err := stack.Push("doorbell")
+	if err != nil {
+		t.Fatalf("Error pushing doorbell: %v", err)
+	}
+	ctxt.setBrightness(1, 30)
+	if doorbell == movie || stack.Top() != doorbell {
+		t.Error("Expected doorbell on top")
+	}
+	if names := stack.Names(); !reflect.DeepEqual(
+		[]string{"movie", "doorbell"}, names) {
+		t.Errorf("Expected movie and doorbell, got %v", names)
+	}
+	if err := stack.Pop(); err != nil {
+		t.Fatalf("Error popping doorbell: %v", err)
+	}
+	if stack.Top() != movie {
+		t.Error("Expected movie on top")
+	}
+	if bri := ctxt.brightness(1); bri != 20 {
+		t.Errorf("Expected 20, got %d", bri)
+	}
+	if err := stack.Pop(); err != nil {
+		t.Fatalf("Error popping movie: %v", err)
+	}
+	if stack.Top() != base {
+		t.Error("Expected base on top")
+	}
+	if bri := ctxt.brightness(1); bri != 10 {
+		t.Errorf("Expected 10, got %d", bri)
+	}
+	if err := stack.Pop(); err != utils.ErrStackEmpty {
+		t.Errorf("Expected ErrStackEmpty, got %v", err)
+	}
+}
+
+func TestStackPushKeepsConfig(t *testing.T) {
+	ctxt := newFakeLights()
+	base := utils.NewMultiExecutor(ctxt, nil)
+	defer base.Close()
+	listener := make(recordingListener, 10)
+	base.AddListener(listener)
+	base.SetFairnessPolicy(utils.SkipLimit(1))
+	stack := utils.NewStack(base, ctxt, lights.New(1, 2))
+	defer stack.Close()
+	movie, err := stack.Push("movie")
+	if err != nil {
+		t.Fatalf("Error pushing movie: %v", err)
+	}
+	movie.StartWithPriority(newHueTask(5), lights.New(1), 50)
+	listener.Verify(t, "START 5:1")
+	movie.MaybeStart(newHueTask(6), lights.New(1))
+	if e := movie.MaybeStart(newHueTask(6), lights.New(1)); e == nil {
+		t.Error("Expected pushed executor to keep the FairnessPolicy")
+	}
+	listener.Verify(t, "INTERRUPTED 5:1", "START 6:1")
+	if history := base.History(); len(history) != 0 {
+		t.Errorf("Expected base history to stay empty, got %v", history)
+	}
+}
+
+func TestStackErrors(t *testing.T) {
+	ctxt := newFakeLights()
+	base := utils.NewMultiExecutor(ctxt, nil)
+	defer base.Close()
+	stack := utils.NewStack(base, ctxt, lights.New(1))
+
+	// Push gives up when its context finishes.
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := stack.PushContext(
+		ctx, "movie"); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if len(stack.Names()) != 0 {
+		t.Error("Expected only base frame")
+	}
+
+	// Pop reports a failed restore
+	if _, err := stack.Push("movie"); err != nil {
+		t.Fatalf("Error pushing movie: %v", err)
+	}
+	errSet := errors.New("set failed")
+	ctxt.setError(errSet)
+	err := stack.Pop()
+	restoreErr, ok := err.(*utils.RestoreError)
+	if !ok || restoreErr.Frame != "movie" || !errors.Is(err, errSet) {
+		t.Errorf("Expected RestoreError for movie, got %v", err)
+	}
+	if stack.Top() != base {
+		t.Error("Expected base on top")
+	}
+	ctxt.setError(nil)
+	if err := stack.Resume(context.Background()); err != nil {
+		t.Errorf("Error resuming: %v", err)
+	}
+
+	// Close closes frames above the base
+	if _, err := stack.Push("extra"); err != nil {
+		t.Fatalf("Error pushing extra: %v", err)
+	}
+	if err := stack.Close(); err != nil {
+		t.Errorf("Error closing: %v", err)
+	}
+	if stack.Top() != base {
+		t.Error("Expected base on top")
+	}
+	if _, err := stack.Push("again"); err != utils.ErrStackClosed {
+		t.Errorf("Expected ErrStackClosed, got %v", err)
+	}
+	if err := stack.Pop(); err != utils.ErrStackClosed {
+		t.Errorf("Expected ErrStackClosed, got %v", err)
+	}
+	if err := stack.Close(); err != nil {
+		t.Errorf("Expected closing twice to be harmless, got %v", err)
+	}
+}
+
+func TestStackRestorePolicy(t *testing.T) {
+	ctx := context.Background()
+	ctxt := newFakeLights()
+	ctxt.setBrightness(1, 10)
+	base := utils.NewMultiExecutor(ctxt, nil)
+	defer base.Close()
+	stack := utils.NewStack(base, ctxt, lights.New(1))
+	defer stack.Close()
+	var failures []*utils.RestoreError
+	stack.SetRestorePolicy(utils.RestorePolicy{
+		MaxRetries:     2,
+		InitialBackoff: 10 * time.Millisecond,
+		Verify:         true,
+		OnFailure: func(err *utils.RestoreError) {
+			failures = append(failures, err)
+		},
+	})
+
+	// Pop retries a failed restore
+	if _, err := stack.Push("movie"); err != nil {
+		t.Fatalf("Error pushing movie: %v", err)
+	}
+	ctxt.setBrightness(1, 20)
+	ctxt.setFlaky(2)
+	if err := stack.Pop(); err != nil {
+		t.Fatalf("Error popping movie: %v", err)
+	}
+	if bri := ctxt.brightness(1); bri != 10 {
+		t.Errorf("Expected 10, got %d", bri)
+	}
+
+	// Pop verifies the lights and gives up after MaxRetries
+	if _, err := stack.Push("doorbell"); err != nil {
+		t.Fatalf("Error pushing doorbell: %v", err)
+	}
+	ctxt.setBrightness(1, 30)
+	ctxt.setIgnoreWrites(true)
+	err := stack.Pop()
+	restoreErr, ok := err.(*utils.RestoreError)
+	if !ok || !errors.Is(err, utils.ErrRestoreMismatch) ||
+		restoreErr.Attempts != 3 {
+		t.Errorf("Expected RestoreError after 3 attempts, got %v", err)
+	}
+	if len(failures) != 1 || failures[0] != restoreErr {
+		t.Errorf("Expected OnFailure to get %v, got %v", restoreErr, failures)
+	}
+	ctxt.setIgnoreWrites(false)
+	if err := stack.Resume(ctx); err != nil {
+		t.Errorf("Error resuming: %v", err)
+	}
+}
+
+func TestStackDeadlines(t *testing.T) {
+	ctxt := newFakeLights()
+	ctxt.setBrightness(1, 10)
+	base := utils.NewMultiExecutor(ctxt, nil)
+	defer base.Close()
+	stack := utils.NewStack(base, ctxt, lights.New(1))
+	defer stack.Close()
+	withTimeout := func(d time.Duration) context.Context {
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		t.Cleanup(cancel)
+		return ctx
+	}
+	// settle waits for the stack to finish what it was doing.
+	settle := func() {
+		t.Helper()
+		if err := stack.Resume(context.Background()); err != nil {
+			t.Fatalf("Error resuming: %v", err)
+		}
+	}
+
+	// Push gives up while the bridge hangs taking the snapshot.
+	release := ctxt.hang()
+	if _, err := stack.PushContext(
+		withTimeout(time.Second), "movie"); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	release()
+	settle()
+	if len(stack.Names()) != 0 {
+		t.Error("Expected push to roll back")
+	}
+
+	// Pop gives up while the bridge hangs restoring the lights.
+	movie, err := stack.Push("movie")
+	if err != nil {
+		t.Fatalf("Error pushing movie: %v", err)
+	}
+	ctxt.setBrightness(1, 20)
+	release = ctxt.hangWrites()
+	if err := stack.PopContext(
+		withTimeout(100 * time.Millisecond)); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	release()
+	settle()
+	if stack.Top() != movie {
+		t.Error("Expected pop to roll back")
+	}
+	if bri := ctxt.brightness(1); bri != 20 {
+		t.Errorf("Expected lights put back to 20, got %d", bri)
+	}
+	started := make(chan struct{})
+	movie.Start(newHueTaskWithAction(1, signalAction{started}), lights.New(1))
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Error("Expected movie to keep running")
+	}
+	if err := stack.Pop(); err != nil {
+		t.Fatalf("Error popping movie: %v", err)
+	}
+	if stack.Top() != base {
+		t.Error("Expected base on top")
+	}
+}
+
+func TestPauseReferenceCounting(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			te.Pause()
+		}()
+	}
+	wg.Wait()
+	te.Resume()
+	started := make(chan struct{})
+	te.Start(newHueTaskWithAction(1, signalAction{started}), lights.New(1))
+	select {
+	case <-started:
+		t.Error("Expected task to wait while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+	te.Resume()
+	select {
+	case <-started:
+	case <-time.After(kMaxActivityWaitTime):
+		t.Error("Expected task to start once resumed")
+	}
+	// Extra calls to Resume do nothing
+	te.Resume()
+}
+
+func TestPauseWithContext(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	release := make(chan struct{})
+	te.Start(newHueTaskWithAction(1, stubbornAction{release}), lights.New(1))
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := te.PauseWithContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	close(release)
+	started := make(chan struct{})
+	te.Start(newHueTaskWithAction(2, signalAction{started}), lights.New(2))
+	select {
+	case <-started:
+	case <-time.After(kMaxActivityWaitTime):
+		t.Error("Expected executor not to stay paused")
+	}
+}
+
+func TestStartWithTimeout(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	e := te.StartWithTimeout(newHueTask(1), lights.New(1), 50*time.Millisecond)
+	select {
+	case <-e.Done():
+	case <-time.After(kMaxActivityWaitTime):
+		t.Error("Expected task to end after timeout")
+	}
+	e = te.StartWithTimeout(newHueTask(2), lights.New(2), 0)
+	select {
+	case <-e.Done():
+		t.Error("Expected task to keep running without timeout")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMultiExecutorWithClock(t *testing.T) {
+	now := time.Unix(1400000000, 0)
+	clock := tasks.NewFakeClock(now)
+	te := utils.NewMultiExecutorWithClock(nil, nil, clock)
+	defer te.Close()
+	e := te.Start(
+		newHueTaskWithAction(1, sleepAction{d: time.Hour}),
+		lights.New(1))
+	// Keep advancing in case the task was not yet sleeping.
+	for i := 0; i < 100 && !e.IsDone(); i++ {
+		clock.Advance(time.Hour)
+		select {
+		case <-e.Done():
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if !e.IsDone() {
+		t.Fatal("Expected task to finish with the fake clock")
+	}
+	history := te.History()
+	if len(history) != 1 || history[0].Start.Before(now) ||
+		history[0].End.Sub(history[0].Start) < time.Hour {
+		t.Errorf("Expected hour long run on fake clock, got %v", history)
+	}
+}
+
+func TestShutdownThenClose(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	te.Start(newHueTask(1), lights.New(1))
+	if _, err := te.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if _, err := te.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected second Shutdown to succeed, got %v", err)
+	}
+	te.Pause()
+	te.Resume()
+	if err := te.Close(); err != nil {
+		t.Errorf("Expected Close after Shutdown to succeed, got %v", err)
+	}
+	if err := te.Close(); err != nil {
+		t.Errorf("Expected second Close to succeed, got %v", err)
+	}
+	if e := te.Start(newHueTask(2), lights.New(2)); e != nil {
+		t.Error("Expected no task to start after Close")
+	}
+}
+
+func TestStopAfter(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	if te.StopAfter("1:1", time.Millisecond) != nil {
+		t.Error("Expected nil for missing task")
+	}
+	e1 := te.Start(newHueTask(1), lights.New(1))
+	e2 := te.Start(newHueTask(2), lights.New(2))
+	te.StopAfter("1:1", 50*time.Millisecond)
+	cancel := te.StopAfter("2:2", 50*time.Millisecond)
+	cancel()
+	select {
+	case <-e1.Done():
+	case <-time.After(kMaxActivityWaitTime):
+		t.Error("Expected task 1 to stop")
+	}
+	select {
+	case <-e2.Done():
+		t.Error("Expected task 2 to keep running")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTimeoutsUseClock(t *testing.T) {
+	clock := tasks.NewFakeClock(time.Unix(1400000000, 0))
+	te := utils.NewMultiExecutorWithClock(nil, nil, clock)
+	defer te.Close()
+	// Both tasks would sleep much longer on their own.
+	e1 := te.StartWithTimeout(
+		newHueTaskWithAction(1, sleepAction{d: 24 * time.Hour}),
+		lights.New(1),
+		time.Hour)
+	e2 := te.Start(
+		newHueTaskWithAction(2, sleepAction{d: 24 * time.Hour}),
+		lights.New(2))
+	te.StopAfter("2:2", 2*time.Hour)
+	select {
+	case <-e1.Done():
+		t.Fatal("Expected task 1 to wait for the fake clock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	clock.Advance(time.Hour)
+	select {
+	case <-e1.Done():
+	case <-time.After(kMaxActivityWaitTime):
+		t.Error("Expected task 1 to end after an hour on the fake clock")
+	}
+	if e2.IsDone() {
+		t.Error("Expected task 2 to still run")
+	}
+	clock.Advance(time.Hour)
+	select {
+	case <-e2.Done():
+	case <-time.After(kMaxActivityWaitTime):
+		t.Error("Expected task 2 to stop after two hours on the fake clock")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	e := te.Start(newHueTask(1), lights.New(1))
+	release := make(chan struct{})
+	stubborn := newHueTaskWithAction(2, stubbornAction{release})
+	te.Start(stubborn, lights.New(2))
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 100*time.Millisecond)
+	defer cancel()
+	running, err := te.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if len(running) != 1 || running[0].H != stubborn {
+		t.Errorf("Expected stubborn task still running, got %v", running)
+	}
+	<-e.Done()
+	if te.Start(newHueTask(3), lights.New(3)) != nil {
+		t.Error("Expected no new tasks after Shutdown")
+	}
+	close(release)
+	running, err = te.Shutdown(context.Background())
+	if len(running) != 0 || err != nil {
+		t.Errorf("Expected clean shutdown, got %v, %v", running, err)
+	}
+}
+
+func TestBackgroundRunnerRestart(t *testing.T) {
+	task := &flakyTask{}
+	br := utils.NewBackgroundRunnerWithRestart(
+		task, utils.RestartPolicy{InitialBackoff: time.Millisecond})
+	br.Enable()
+	waitFor(t, func() bool { return br.RestartCount() == 1 })
+	if err := br.LastError(); err == nil {
+		t.Error("Expected panic to be recorded")
+	}
+	if !br.IsEnabled() {
+		t.Error("Expected task to be running again")
+	}
+	br.Disable()
+	if br.IsEnabled() {
+		t.Error("Expected task to be disabled")
+	}
+	if count := br.RestartCount(); count != 1 {
+		t.Errorf("Expected 1 restart, got %d", count)
+	}
+}
+
+func TestBackgroundRunnerMaxRestarts(t *testing.T) {
+	br := utils.NewBackgroundRunnerWithRestart(
+		failingTask{},
+		utils.RestartPolicy{
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     2 * time.Millisecond,
+			MaxRestarts:    3,
+		})
+	br.Enable()
+	waitFor(t, func() bool { return !br.IsEnabled() })
+	if count := br.RestartCount(); count != 3 {
+		t.Errorf("Expected 3 restarts, got %d", count)
+	}
+	if err := br.LastError(); err == nil || err.Error() != "failed" {
+		t.Errorf("Expected failed, got %v", err)
+	}
+}
+
+func TestStopCancelsContext(t *testing.T) {
+	te := utils.NewMultiExecutor(&cancelableContext{}, nil)
+	defer te.Close()
+	canceled := make(chan error, 1)
+	h := newHueTaskWithAction(5, contextWaitAction{canceled})
+	e := te.Start(h, lights.New(1))
+	te.Stop("5:1")
+	<-e.Done()
+	select {
+	case err := <-canceled:
+		if err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(kMaxActivityWaitTime):
+		t.Error("Expected hue bridge call to be canceled.")
+	}
+}
+
 func TestFutureTime(t *testing.T) {
 	now := time.Date(2014, 11, 7, 16, 43, 0, 0, time.Local)
 	future1644 := utils.FutureTime(now, 16, 44)
@@ -203,103 +929,675 @@ func TestFutureTime(t *testing.T) {
 	if out := future1644.Sub(now); out != time.Minute {
 		t.Errorf("Expected 1 minute, got %v", out)
 	}
-	if out := future1700.Sub(now); out != 17*time.Minute {
-		t.Errorf("Expected 17 minutes, got %v", out)
+	if out := future1700.Sub(now); out != 17*time.Minute {
+		t.Errorf("Expected 17 minutes, got %v", out)
+	}
+	if out := future1643.Sub(now); out != 24*time.Hour {
+		t.Errorf("Expected 24 hours, got %v", out)
+	}
+}
+
+func TestMultiTimerPersistence(t *testing.T) {
+	now := time.Unix(1400000000, 0)
+	storedAtTimeTasks := []*ops.AtTimeTask{
+		{H: &ops.HueTask{Id: 21, HueAction: intAction(121), Description: "Foo"},
+			Ls:        nil,
+			StartTime: now.Add(10 * time.Minute),
+		},
+		{H: &ops.HueTask{Id: 22, HueAction: intAction(122), Description: "Baz"},
+			Ls:        nil,
+			StartTime: now.Add(-1 * time.Second),
+		},
+		{H: &ops.HueTask{Id: 25, HueAction: intAction(125), Description: "Bar"},
+			Ls:        lights.New(2, 4),
+			StartTime: now.Add(15 * time.Minute),
+		},
+	}
+	storeActivity := make(chan interface{}, 10)
+	beginnerActivity := make(chan interface{}, 10)
+	defer close(storeActivity)
+	defer close(beginnerActivity)
+	clock := tasks.NewFakeClock(now)
+	store := &atTimeTaskStore{
+		Tasks: storedAtTimeTasks, Activity: storeActivity}
+	beginner := hueTaskBeginner{beginnerActivity}
+	mt := utils.NewMultiTimerWithStoreAndClock(beginner, store, clock)
+	task22ScheduleId := "22:1399999999:All"
+	scheduleOfTaskId22 := mt.FindByScheduleId(task22ScheduleId)
+	store.VerifyRemoved(t, task22ScheduleId, true)
+	if scheduleOfTaskId22 != nil {
+		<-scheduleOfTaskId22.Done()
+	}
+	expectedAtTimeTasks := []*ops.AtTimeTask{
+		{H: &ops.HueTask{Id: 21, HueAction: intAction(121), Description: "Foo"},
+			Ls:        nil,
+			StartTime: now.Add(10 * time.Minute),
+		},
+		{H: &ops.HueTask{Id: 25, HueAction: intAction(125), Description: "Bar"},
+			Ls:        lights.New(2, 4),
+			StartTime: now.Add(15 * time.Minute),
+		},
+	}
+	verifyScheduled(t, expectedAtTimeTasks, mt.Scheduled())
+
+	// Schedule another task that conflicts with an existing task
+	mt.Schedule(
+		&ops.HueTask{Id: 27, HueAction: intAction(127), Description: "Baz"},
+		lights.New(1, 4),
+		now.Add(10*time.Minute))
+	store.VerifyRemoved(t, "21:1400000600:All", false)
+	store.VerifyAdded(t, &ops.AtTimeTask{
+		Id:        "27:1400000600:1,4",
+		H:         &ops.HueTask{Id: 27, HueAction: intAction(127), Description: "Baz"},
+		Ls:        lights.New(1, 4),
+		StartTime: now.Add(10 * time.Minute)}, false)
+	scheduleOfTaskId27 := mt.FindByScheduleId("27:1400000600:1,4")
+	beginner.VerifyNoInteraction(t)
+	expectedAtTimeTasks = []*ops.AtTimeTask{
+		{H: &ops.HueTask{Id: 25, HueAction: intAction(125), Description: "Bar"},
+			Ls:        lights.New(2, 4),
+			StartTime: now.Add(15 * time.Minute),
+		},
+		{H: &ops.HueTask{Id: 27, HueAction: intAction(127), Description: "Baz"},
+			Ls:        lights.New(1, 4),
+			StartTime: now.Add(10 * time.Minute),
+		},
+	}
+	verifyScheduled(t, expectedAtTimeTasks, mt.Scheduled())
+	clock.Advance(10 * time.Minute)
+	beginner.Verify(
+		t,
+		&ops.HueTask{Id: 27, HueAction: intAction(127), Description: "Baz"},
+		lights.New(1, 4))
+	store.VerifyRemoved(t, "27:1400000600:1,4", true)
+
+	// Block until scheduling is complete before verifying task list
+	<-scheduleOfTaskId27.Done()
+	expectedAtTimeTasks = []*ops.AtTimeTask{
+		{H: &ops.HueTask{Id: 25, HueAction: intAction(125), Description: "Bar"},
+			Ls:        lights.New(2, 4),
+			StartTime: now.Add(15 * time.Minute),
+		},
+	}
+	verifyScheduled(t, expectedAtTimeTasks, mt.Scheduled())
+
+	// This should be a noop
+	mt.Cancel("NoSuchTaskId")
+
+	store.VerifyNoInteraction(t)
+	beginner.VerifyNoInteraction(t)
+}
+
+func TestMultiTimerScheduleUntil(t *testing.T) {
+	now := time.Unix(1400000000, 0)
+	storeActivity := make(chan interface{}, 10)
+	beginnerActivity := make(chan interface{}, 10)
+	defer close(storeActivity)
+	defer close(beginnerActivity)
+	clock := newWaitingClock(now)
+	store := &atTimeTaskStore{Activity: storeActivity}
+	beginner := hueTaskBeginner{beginnerActivity}
+	mt := utils.NewMultiTimerWithStoreAndClock(beginner, store, clock)
+	h := &ops.HueTask{Id: 27, HueAction: intAction(127), Description: "Baz"}
+	mt.ScheduleUntil(
+		h,
+		lights.New(1, 4),
+		now.Add(10*time.Minute),
+		now.Add(40*time.Minute),
+		true)
+	expected := &ops.AtTimeTask{
+		Id:           "27:1400000600:1,4",
+		H:            h,
+		Ls:           lights.New(1, 4),
+		StartTime:    now.Add(10 * time.Minute),
+		EndTime:      now.Add(40 * time.Minute),
+		RestoreAtEnd: true,
+	}
+	store.VerifyAdded(t, expected, true)
+	verifyScheduled(t, []*ops.AtTimeTask{expected}, mt.Scheduled())
+	scheduleOfTaskId27 := mt.FindByScheduleId("27:1400000600:1,4")
+	clock.WaitForWaiter(t)
+	clock.Advance(10 * time.Minute)
+	started, ok := nextActivity(beginnerActivity, kMaxActivityWaitTime).(*ops.HueTask)
+	if !ok || started.Id != 27 || started.Description != "Baz" {
+		t.Fatalf("Expected hue task 27 to start, got %v", started)
+	}
+	if ls := nextActivity(beginnerActivity, kMaxActivityWaitTime); !reflect.DeepEqual(lights.New(1, 4), ls) {
+		t.Errorf("Expected lights 1,4, got %v", ls)
+	}
+	<-scheduleOfTaskId27.Done()
+
+	// The row stays until the end action runs
+	store.VerifyNoInteraction(t)
+	tasks.RunForTesting(
+		tasks.TaskFunc(func(e *tasks.Execution) {
+			started.Do(&cancelableContext{}, lights.New(1, 4), e)
+		}),
+		&tasks.ClockForTesting{Current: now.Add(10 * time.Minute)})
+	store.VerifyRemoved(t, "27:1400000600:1,4", true)
+}
+
+func TestMultiTimerResumesWindow(t *testing.T) {
+	now := time.Unix(1400000000, 0)
+	storeActivity := make(chan interface{}, 10)
+	beginnerActivity := make(chan interface{}, 10)
+	defer close(storeActivity)
+	defer close(beginnerActivity)
+	h := &ops.HueTask{Id: 27, HueAction: intAction(127), Description: "Baz"}
+	store := &atTimeTaskStore{
+		Activity: storeActivity,
+		Tasks: []*ops.AtTimeTask{
+			{
+				H:         h,
+				Ls:        lights.New(1),
+				StartTime: now.Add(-10 * time.Minute),
+				EndTime:   now.Add(10 * time.Minute),
+			},
+			{
+				H:         h,
+				Ls:        lights.New(2),
+				StartTime: now.Add(-20 * time.Minute),
+				EndTime:   now.Add(-10 * time.Minute),
+			},
+		},
+	}
+	utils.NewMultiTimerWithStoreAndClock(
+		hueTaskBeginner{beginnerActivity}, store, tasks.NewFakeClock(now))
+
+	// The task still within its window starts again; the stale one goes.
+	started, ok := nextActivity(beginnerActivity, kMaxActivityWaitTime).(*ops.HueTask)
+	if !ok || started.Id != 27 {
+		t.Fatalf("Expected hue task 27 to start, got %v", started)
+	}
+	if ls := nextActivity(beginnerActivity, kMaxActivityWaitTime); !reflect.DeepEqual(lights.New(1), ls) {
+		t.Errorf("Expected light 1, got %v", ls)
+	}
+	store.VerifyRemoved(t, "27:1399998800:2", true)
+}
+
+func TestMultiTimerReschedule(t *testing.T) {
+	now := time.Unix(1400000000, 0)
+	storeActivity := make(chan interface{}, 10)
+	beginnerActivity := make(chan interface{}, 10)
+	defer close(storeActivity)
+	defer close(beginnerActivity)
+	clock := tasks.NewFakeClock(now)
+	store := &atTimeTaskStore{Activity: storeActivity}
+	beginner := hueTaskBeginner{beginnerActivity}
+	mt := utils.NewMultiTimerWithStoreAndClock(beginner, store, clock)
+	h := &ops.HueTask{Id: 27, HueAction: intAction(127), Description: "Baz"}
+	mt.ScheduleUntil(
+		h,
+		lights.New(1, 4),
+		now.Add(10*time.Minute),
+		now.Add(40*time.Minute),
+		true)
+	store.VerifyAdded(t, &ops.AtTimeTask{
+		Id:           "27:1400000600:1,4",
+		H:            h,
+		Ls:           lights.New(1, 4),
+		StartTime:    now.Add(10 * time.Minute),
+		EndTime:      now.Add(40 * time.Minute),
+		RestoreAtEnd: true,
+	}, true)
+	scheduleId, err := mt.Reschedule(
+		"27:1400000600:1,4", now.Add(25*time.Minute))
+	if err != nil {
+		t.Fatalf("Error rescheduling: %v", err)
+	}
+	expected := &ops.AtTimeTask{
+		Id:           "27:1400001500:1,4",
+		H:            h,
+		Ls:           lights.New(1, 4),
+		StartTime:    now.Add(25 * time.Minute),
+		EndTime:      now.Add(55 * time.Minute),
+		RestoreAtEnd: true,
+	}
+	if scheduleId != expected.Id {
+		t.Errorf("Expected %s, got %s", expected.Id, scheduleId)
+	}
+	store.VerifyRemoved(t, "27:1400000600:1,4", true)
+	store.VerifyAdded(t, expected, true)
+	verifyScheduled(t, []*ops.AtTimeTask{expected}, mt.Scheduled())
+	if _, err := mt.UpdateLights(
+		expected.Id, lights.None); err != utils.ErrNoLights {
+		t.Errorf("Expected ErrNoLights, got %v", err)
+	}
+	scheduleId, err = mt.UpdateLights(expected.Id, lights.New(2))
+	if err != nil {
+		t.Fatalf("Error updating lights: %v", err)
+	}
+	store.VerifyRemoved(t, expected.Id, true)
+	expected.Id = "27:1400001500:2"
+	expected.Ls = lights.New(2)
+	if scheduleId != expected.Id {
+		t.Errorf("Expected %s, got %s", expected.Id, scheduleId)
+	}
+	store.VerifyAdded(t, expected, true)
+	verifyScheduled(t, []*ops.AtTimeTask{expected}, mt.Scheduled())
+	if _, err := mt.Reschedule(
+		"27:1400000600:1,4", now); err != utils.ErrNoSuchScheduleId {
+		t.Errorf("Expected ErrNoSuchScheduleId, got %v", err)
+	}
+	mt.Cancel(expected.Id)
+	store.VerifyRemoved(t, expected.Id, true)
+	beginner.VerifyNoInteraction(t)
+}
+
+func TestMultiTimerSnooze(t *testing.T) {
+	now := time.Unix(1400000000, 0)
+	storeActivity := make(chan interface{}, 10)
+	beginnerActivity := make(chan interface{}, 10)
+	defer close(storeActivity)
+	defer close(beginnerActivity)
+	clock := tasks.NewFakeClock(now)
+	store := &atTimeTaskStore{Activity: storeActivity}
+	beginner := hueTaskBeginner{beginnerActivity}
+	mt := utils.NewMultiTimerWithStoreAndClock(beginner, store, clock)
+	h := &ops.HueTask{Id: 27, HueAction: intAction(127), Description: "Wake"}
+	mt.Schedule(h, lights.New(1), now.Add(10*time.Minute))
+	store.VerifyAdded(t, &ops.AtTimeTask{
+		Id:        "27:1400000600:1",
+		H:         h,
+		Ls:        lights.New(1),
+		StartTime: now.Add(10 * time.Minute),
+	}, true)
+	scheduleId, err := mt.Snooze("27:1400000600:1", 9*time.Minute)
+	if err != nil {
+		t.Fatalf("Error snoozing: %v", err)
+	}
+	expected := &ops.AtTimeTask{
+		Id:        "27:1400001140:1",
+		H:         h,
+		Ls:        lights.New(1),
+		StartTime: now.Add(19 * time.Minute),
+	}
+	if scheduleId != expected.Id {
+		t.Errorf("Expected %s, got %s", expected.Id, scheduleId)
+	}
+	store.VerifyRemoved(t, "27:1400000600:1", true)
+	store.VerifyAdded(t, expected, true)
+	verifyScheduled(t, []*ops.AtTimeTask{expected}, mt.Scheduled())
+	if _, err := mt.Snooze(
+		"27:1400000600:1", time.Minute); err != utils.ErrNoSuchScheduleId {
+		t.Errorf("Expected ErrNoSuchScheduleId, got %v", err)
+	}
+	mt.Cancel(expected.Id)
+	store.VerifyRemoved(t, expected.Id, true)
+	beginner.VerifyNoInteraction(t)
+}
+
+func TestUpcoming(t *testing.T) {
+	now := time.Date(2014, 11, 7, 16, 43, 0, 0, time.Local)
+	evening := &utils.Recurring{Id: 1, R: recurring.AtTime(18, 0)}
+	morning := &utils.Recurring{Id: 2, R: recurring.AtTime(7, 0)}
+	never := &utils.Recurring{Id: 3, R: recurring.Nil()}
+	newTask := func(id int, r *utils.Recurring) *utils.ScheduledTask {
+		return utils.TaskToScheduledTask(
+			id, "task", r, &sleepTask{d: time.Hour})
+	}
+	list := utils.ScheduledTaskList{
+		newTask(1, morning),
+		newTask(2, evening),
+		newTask(3, nil),
+		newTask(4, never),
+		newTask(5, evening),
+		newTask(6, evening),
+	}
+	next, ok := list[0].NextRunTime(now)
+	if expected := time.Date(2014, 11, 8, 7, 0, 0, 0, time.Local); !ok ||
+		!next.Equal(expected) {
+		t.Errorf("Expected %v, got %v", expected, next)
+	}
+	if _, ok := list[2].NextRunTime(now); ok {
+		t.Error("Expected no next run time for task that runs always")
+	}
+	if _, ok := list[3].NextRunTime(now); ok {
+		t.Error("Expected no next run time")
+	}
+	for _, st := range list[:5] {
+		st.Enable()
+		defer st.Disable()
+	}
+	var ids []int
+	var times []time.Time
+	for _, run := range list.Upcoming(now) {
+		ids = append(ids, run.Task.Id)
+		times = append(times, run.Time)
+	}
+	if expected := []int{2, 5, 1}; !reflect.DeepEqual(expected, ids) {
+		t.Errorf("Expected %v, got %v", expected, ids)
+	}
+	if len(times) == 3 && !times[2].Equal(next) {
+		t.Errorf("Expected %v, got %v", next, times[2])
+	}
+}
+
+func TestMisfire(t *testing.T) {
+	now := time.Now()
+	// Fires 90 and 30 minutes ago and 30 minutes from now.
+	times := &utils.Recurring{
+		Id: 1, R: recurring.AtInterval(now.Add(-90*time.Minute), time.Hour)}
+	lastFired := now.Add(-100 * time.Minute)
+	newTask := func(
+		policy utils.MisfirePolicy,
+		grace time.Duration) (*utils.ScheduledTask, *fakeFireTimes, chan int) {
+		runs := make(chan int, 10)
+		st := utils.TaskToScheduledTask(7, "task", times, &countTask{runs})
+		st.Misfire = policy
+		st.MisfireGrace = grace
+		fireTimes := &fakeFireTimes{times: map[int]time.Time{7: lastFired}}
+		st.FireTimes = fireTimes
+		return st, fireTimes, runs
+	}
+	verifyRuns := func(runs chan int, expected bool) {
+		t.Helper()
+		select {
+		case <-runs:
+			if !expected {
+				t.Error("Expected missed run to be skipped")
+			}
+		case <-time.After(100 * time.Millisecond):
+			if expected {
+				t.Error("Expected missed run to run")
+			}
+		}
+	}
+
+	st, fireTimes, runs := newTask(utils.MisfireSkip, 0)
+	st.Enable()
+	verifyRuns(runs, false)
+	st.Disable()
+
+	st, fireTimes, runs = newTask(utils.MisfireRunOnce, 0)
+	st.Enable()
+	verifyRuns(runs, true)
+	st.Disable()
+	if fired, _ := fireTimes.LastFired(7); !fired.After(lastFired) {
+		t.Error("Expected last fired time to be updated")
+	}
+
+	st, _, runs = newTask(utils.MisfireRunWithinGrace, 10*time.Minute)
+	st.Enable()
+	verifyRuns(runs, false)
+	st.Disable()
+
+	st, _, runs = newTask(utils.MisfireRunWithinGrace, time.Hour)
+	st.Enable()
+	verifyRuns(runs, true)
+	// Enabling again while running does not run again.
+	st.Enable()
+	verifyRuns(runs, false)
+	st.Disable()
+}
+
+func TestMisfireUsesExecutorClock(t *testing.T) {
+	// Fires long after the real current time.
+	base := time.Date(2100, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &tasks.ClockForTesting{Current: base.Add(5 * time.Minute)}
+	te := utils.NewMultiExecutorWithClock(nil, nil, clock)
+	defer te.Close()
+	st := utils.HueTaskToScheduledTask(
+		7,
+		newHueTask(5),
+		lights.All,
+		&utils.Recurring{R: recurring.AtInterval(base, time.Hour)},
+		utils.PriorityLow,
+		te)
+	st.Misfire = utils.MisfireRunOnce
+	fireTimes := &fakeFireTimes{
+		times: map[int]time.Time{7: base.Add(-10 * time.Minute)}}
+	st.FireTimes = fireTimes
+	now := clock.Current
+	st.Enable()
+	defer st.Disable()
+	if fired, _ := fireTimes.LastFired(7); !fired.Equal(now) {
+		t.Errorf("Expected missed run at %v, got %v", now, fired)
+	}
+}
+
+func TestRunGuard(t *testing.T) {
+	fireTimes := &fakeFireTimes{times: make(map[int]time.Time)}
+	guard := utils.NewRunGuard(time.Hour, runTimes{fireTimes})
+	start := time.Date(2014, 6, 1, 20, 0, 0, 0, time.UTC)
+	if !guard.Allow(5, start) {
+		t.Error("Expected first run to be allowed")
+	}
+	if guard.Allow(5, start.Add(10*time.Minute)) {
+		t.Error("Expected second run within window to be suppressed")
+	}
+	if !guard.Allow(6, start.Add(10*time.Minute)) {
+		t.Error("Expected other hue task to be allowed")
+	}
+	if !guard.Allow(5, start.Add(time.Hour)) {
+		t.Error("Expected run after window to be allowed")
+	}
+	// Runs that store knows about, such as ones from before a restart,
+	// count too.
+	fireTimes.SetLastFired(7, start)
+	if guard.Allow(7, start.Add(30*time.Minute)) {
+		t.Error("Expected stored run to suppress")
+	}
+	if !guard.Allow(7, start.Add(time.Hour)) {
+		t.Error("Expected run after stored window to be allowed")
+	}
+	inMemory := utils.NewRunGuard(time.Hour, nil)
+	if !inMemory.Allow(5, start) || inMemory.Allow(5, start) {
+		t.Error("Expected in memory guard to allow only the first run")
+	}
+}
+
+func TestRunGuardStart(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	guard := utils.NewRunGuard(time.Hour, nil)
+	h := newHueTask(5)
+	if guard.Start(te, h, lights.All, utils.PriorityLow) == nil {
+		t.Fatal("Expected hue task to start")
+	}
+	first := te.Tasks()
+	verifyHueTaskIds(t, first, 5)
+	// A suppressed trigger must not interrupt the running hue task.
+	if guard.Start(te, h, lights.All, utils.PriorityHigh) != nil {
+		t.Error("Expected second trigger to be suppressed")
+	}
+	second := te.Tasks()
+	verifyHueTaskIds(t, second, 5)
+	if first[0] != second[0] {
+		t.Error("Expected running hue task to be left alone")
+	}
+}
+
+func TestScheduledTaskGuard(t *testing.T) {
+	te := utils.NewMultiExecutor(nil, nil)
+	defer te.Close()
+	guard := utils.NewRunGuard(time.Hour, nil)
+	h := newHueTask(5)
+	now := time.Now()
+	first := utils.HueTaskToScheduledTask(
+		1,
+		h,
+		lights.All,
+		&utils.Recurring{
+			R: recurring.AtInterval(now.Add(10*time.Millisecond), time.Hour)},
+		utils.PriorityLow,
+		te)
+	first.Guard = guard
+	// second fires the same hue task shortly after first with a priority
+	// that would otherwise interrupt first.
+	second := utils.HueTaskToScheduledTask(
+		2,
+		h,
+		lights.All,
+		&utils.Recurring{
+			R: recurring.AtInterval(now.Add(50*time.Millisecond), time.Hour)},
+		utils.PriorityHigh,
+		te)
+	second.Guard = guard
+	fireTimes := &fakeFireTimes{times: make(map[int]time.Time)}
+	second.FireTimes = fireTimes
+	first.Enable()
+	defer first.Disable()
+	waitFor(t, func() bool { return len(te.Tasks()) == 1 })
+	running := te.Tasks()[0]
+	second.Enable()
+	defer second.Disable()
+	waitFor(t, func() bool {
+		_, ok := fireTimes.LastFired(2)
+		return ok
+	})
+	// Give an unguarded run time to replace the running hue task.
+	time.Sleep(20 * time.Millisecond)
+	tasks := te.Tasks()
+	if len(tasks) != 1 || tasks[0] != running {
+		t.Error("Expected guarded run to leave running hue task alone")
+	}
+}
+
+func TestScheduleManager(t *testing.T) {
+	first := newScheduledTask(1)
+	second := newScheduledTask(2)
+	manager := utils.NewScheduleManager(utils.ScheduledTaskList{first})
+	var changes []utils.ScheduleChange
+	manager.OnChange(func(change utils.ScheduleChange) {
+		changes = append(changes, change)
+	})
+	if err := manager.AddTask(second); err != nil {
+		t.Fatalf("Error adding: %v", err)
+	}
+	if err := manager.AddTask(newScheduledTask(1)); err != utils.ErrDuplicateTaskId {
+		t.Errorf("Expected ErrDuplicateTaskId, got %v", err)
+	}
+	before := manager.Tasks()
+	first.Enable()
+	replacement := newScheduledTask(1)
+	if err := manager.ReplaceTask(replacement); err != nil {
+		t.Fatalf("Error replacing: %v", err)
+	}
+	if first.IsEnabled() || !replacement.IsEnabled() {
+		t.Error("Expected replacement to take over from first")
 	}
-	if out := future1643.Sub(now); out != 24*time.Hour {
-		t.Errorf("Expected 24 hours, got %v", out)
+	if err := manager.ReplaceTask(newScheduledTask(3)); err != utils.ErrNoSuchTask {
+		t.Errorf("Expected ErrNoSuchTask, got %v", err)
+	}
+	if err := manager.RemoveTask(1); err != nil {
+		t.Fatalf("Error removing: %v", err)
+	}
+	if replacement.IsEnabled() {
+		t.Error("Expected removed task to be disabled")
+	}
+	if err := manager.RemoveTask(1); err != utils.ErrNoSuchTask {
+		t.Errorf("Expected ErrNoSuchTask, got %v", err)
+	}
+	if !reflect.DeepEqual(
+		map[int]*utils.ScheduledTask{2: second}, manager.ToMap()) {
+		t.Errorf("Expected only second, got %v", manager.ToMap())
+	}
+	if len(before) != 2 || before[0] != first || before[1] != second {
+		t.Errorf("Expected earlier list to stay the same, got %v", before)
+	}
+	expected := []utils.ScheduleChange{
+		{Kind: utils.TaskAdded, Id: 2, New: second},
+		{Kind: utils.TaskReplaced, Id: 1, Old: first, New: replacement},
+		{Kind: utils.TaskRemoved, Id: 1, Old: replacement},
+	}
+	if !reflect.DeepEqual(expected, changes) {
+		t.Errorf("Expected %v, got %v", expected, changes)
 	}
 }
 
-func TestMultiTimerPersistence(t *testing.T) {
-	now := time.Unix(1400000000, 0)
-	storedAtTimeTasks := []*ops.AtTimeTask{
-		{H: &ops.HueTask{Id: 21, HueAction: intAction(121), Description: "Foo"},
-			Ls:        nil,
-			StartTime: now.Add(10 * time.Minute),
-		},
-		{H: &ops.HueTask{Id: 22, HueAction: intAction(122), Description: "Baz"},
-			Ls:        nil,
-			StartTime: now.Add(-1 * time.Second),
-		},
-		{H: &ops.HueTask{Id: 25, HueAction: intAction(125), Description: "Bar"},
-			Ls:        lights.New(2, 4),
-			StartTime: now.Add(15 * time.Minute),
-		},
+func TestScheduleManagerEnableRacesRemove(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		task := newScheduledTask(1)
+		manager := utils.NewScheduleManager(utils.ScheduledTaskList{task})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			manager.Enable(1)
+		}()
+		manager.RemoveTask(1)
+		wg.Wait()
+		// Enable either ran first and RemoveTask disabled the task or
+		// Enable found no task.
+		if task.IsEnabled() {
+			task.Disable()
+			t.Fatal("Expected removed task to stay disabled")
+		}
 	}
-	storeActivity := make(chan interface{}, 10)
-	beginnerActivity := make(chan interface{}, 10)
-	defer close(storeActivity)
-	defer close(beginnerActivity)
-	clock := tasks.NewFakeClock(now)
-	store := &atTimeTaskStore{
-		Tasks: storedAtTimeTasks, Activity: storeActivity}
-	beginner := hueTaskBeginner{beginnerActivity}
-	mt := utils.NewMultiTimerWithStoreAndClock(beginner, store, clock)
-	task22ScheduleId := "22:1399999999:All"
-	scheduleOfTaskId22 := mt.FindByScheduleId(task22ScheduleId)
-	store.VerifyRemoved(t, task22ScheduleId, true)
-	if scheduleOfTaskId22 != nil {
-		<-scheduleOfTaskId22.Done()
+}
+
+func TestBackgroundRunnerSaveEnabled(t *testing.T) {
+	store := fakeEnabledStore{}
+	first := newScheduledTask(1)
+	second := newScheduledTask(2)
+	first.SaveEnabled(store, 11)
+	second.SaveEnabled(store, 12)
+	manager := utils.NewScheduleManager(utils.ScheduledTaskList{first, second})
+	first.Enable()
+	second.Enable()
+	defer second.Disable()
+	if err := manager.Disable(1); err != nil {
+		t.Fatalf("Error disabling: %v", err)
 	}
-	expectedAtTimeTasks := []*ops.AtTimeTask{
-		{H: &ops.HueTask{Id: 21, HueAction: intAction(121), Description: "Foo"},
-			Ls:        nil,
-			StartTime: now.Add(10 * time.Minute),
-		},
-		{H: &ops.HueTask{Id: 25, HueAction: intAction(125), Description: "Bar"},
-			Ls:        lights.New(2, 4),
-			StartTime: now.Add(15 * time.Minute),
-		},
+	if first.IsEnabled() {
+		t.Error("Expected first to be disabled")
 	}
-	verifyScheduled(t, expectedAtTimeTasks, mt.Scheduled())
-
-	// Schedule another task that conflicts with an existing task
-	mt.Schedule(
-		&ops.HueTask{Id: 27, HueAction: intAction(127), Description: "Baz"},
-		lights.New(1, 4),
-		now.Add(10*time.Minute))
-	store.VerifyRemoved(t, "21:1400000600:All", false)
-	store.VerifyAdded(t, &ops.AtTimeTask{
-		Id:        "27:1400000600:1,4",
-		H:         &ops.HueTask{Id: 27, HueAction: intAction(127), Description: "Baz"},
-		Ls:        lights.New(1, 4),
-		StartTime: now.Add(10 * time.Minute)}, false)
-	scheduleOfTaskId27 := mt.FindByScheduleId("27:1400000600:1,4")
-	beginner.VerifyNoInteraction(t)
-	expectedAtTimeTasks = []*ops.AtTimeTask{
-		{H: &ops.HueTask{Id: 25, HueAction: intAction(125), Description: "Bar"},
-			Ls:        lights.New(2, 4),
-			StartTime: now.Add(15 * time.Minute),
-		},
-		{H: &ops.HueTask{Id: 27, HueAction: intAction(127), Description: "Baz"},
-			Ls:        lights.New(1, 4),
-			StartTime: now.Add(10 * time.Minute),
-		},
+	if err := manager.Enable(3); err != utils.ErrNoSuchTask {
+		t.Errorf("Expected ErrNoSuchTask, got %v", err)
+	}
+	expected := fakeEnabledStore{11: false, 12: true}
+	if !reflect.DeepEqual(expected, store) {
+		t.Errorf("Expected %v, got %v", expected, store)
 	}
-	verifyScheduled(t, expectedAtTimeTasks, mt.Scheduled())
-	clock.Advance(10 * time.Minute)
-	beginner.Verify(
-		t,
-		&ops.HueTask{Id: 27, HueAction: intAction(127), Description: "Baz"},
-		lights.New(1, 4))
-	store.VerifyRemoved(t, "27:1400000600:1,4", true)
 
-	// Block until scheduling is complete before verifying task list
-	<-scheduleOfTaskId27.Done()
-	expectedAtTimeTasks = []*ops.AtTimeTask{
-		{H: &ops.HueTask{Id: 25, HueAction: intAction(125), Description: "Bar"},
-			Ls:        lights.New(2, 4),
-			StartTime: now.Add(15 * time.Minute),
-		},
+	// Disabling through the BackgroundRunner directly saves too.
+	first.Enable()
+	first.BackgroundRunner.Disable()
+	if store[11] {
+		t.Error("Expected first to be saved as disabled")
 	}
-	verifyScheduled(t, expectedAtTimeTasks, mt.Scheduled())
 
-	// This should be a noop
-	mt.Cancel("NoSuchTaskId")
+	// Disabling an already disabled task saves nothing.
+	delete(store, 11)
+	first.Disable()
+	if _, ok := store[11]; ok {
+		t.Error("Expected nothing saved")
+	}
+}
 
-	store.VerifyNoInteraction(t)
-	beginner.VerifyNoInteraction(t)
+func TestTemplateFuncs(t *testing.T) {
+	labels := map[int]string{1: "Kitchen", 3: "Porch"}
+	assertStrEqual(t, "Kitchen, 2, Porch", utils.LightNames(
+		lights.New(3, 1, 2), labels))
+	assertStrEqual(t, "All", utils.LightNames(lights.All, labels))
+	assertStrEqual(t, "None", utils.LightNames(lights.None, labels))
+	assertStrEqual(t, "0s", utils.HumanizeDuration(0))
+	assertStrEqual(t, "45s", utils.HumanizeDuration(45*time.Second))
+	assertStrEqual(t, "1m 30s", utils.HumanizeDuration(90*time.Second))
+	assertStrEqual(t, "3h", utils.HumanizeDuration(3*time.Hour+20*time.Second))
+	assertStrEqual(t, "1d 2h", utils.HumanizeDuration(26*time.Hour+5*time.Minute))
+	assertStrEqual(t, "-2m", utils.HumanizeDuration(-2*time.Minute))
+	assertStrEqual(
+		t,
+		`<option value="0">--Pick one--</option><option value="1" selected>Red &amp; Blue</option>`,
+		string(utils.SelectOptions([]string{"--Pick one--", "Red & Blue"}, 1)))
+	tmpl := utils.NewTemplate(
+		"test",
+		`{{lightNames .Lights .Labels}} in {{duration .Wait}}: {{timeLeft .Timer .Now}}`)
+	now := time.Date(2014, 6, 1, 20, 0, 0, 0, time.UTC)
+	var sb strings.Builder
+	err := tmpl.Execute(&sb, map[string]interface{}{
+		"Lights": lights.New(1),
+		"Labels": labels,
+		"Wait":   5 * time.Minute,
+		"Timer":  &utils.TimerTaskWrapper{StartTime: now.Add(5 * time.Minute)},
+		"Now":    now,
+	})
+	if err != nil {
+		t.Fatalf("Error executing template: %v", err)
+	}
+	assertStrEqual(t, "Kitchen in 5m: 5:01", sb.String())
 }
 
 func assertStrEqual(t *testing.T, expected, actual string) {
@@ -314,9 +1612,8 @@ func verifyExecution(t *testing.T, expected *tasks.Execution, actual *tasks.Exec
 	}
 }
 
-func verifyTasks(t *testing.T, coll *utils.TaskCollection, expected ...*utils.HueTaskWrapper) {
-	var actual []*utils.HueTaskWrapper
-	coll.Tasks(&actual)
+func verifyTasks(t *testing.T, coll *utils.TaskCollection[*utils.HueTaskWrapper], expected ...*utils.HueTaskWrapper) {
+	actual := coll.Tasks()
 	if len(actual) != len(expected) {
 		t.Errorf("Expected length %d, got %d", len(expected), len(actual))
 		return
@@ -366,6 +1663,79 @@ func verifyHueTaskLights(
 	}
 }
 
+func newScheduledTask(id int) *utils.ScheduledTask {
+	return utils.TaskToScheduledTask(
+		id,
+		"task",
+		nil,
+		&sleepTask{d: time.Hour})
+}
+
+type fakeEnabledStore map[int]bool
+
+func (f fakeEnabledStore) SetEnabled(id int, enabled bool) {
+	f[id] = enabled
+}
+
+// countTask sends to runs each time it runs.
+type countTask struct {
+	runs chan int
+}
+
+func (c *countTask) Do(e *tasks.Execution) {
+	c.runs <- 1
+}
+
+type fakeFireTimes struct {
+	mu    sync.Mutex
+	times map[int]time.Time
+}
+
+func (f *fakeFireTimes) LastFired(id int) (time.Time, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result, ok := f.times[id]
+	return result, ok
+}
+
+func (f *fakeFireTimes) SetLastFired(id int, t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.times[id] = t
+}
+
+// runTimes adapts fakeFireTimes to utils.RunTimeStore.
+type runTimes struct {
+	*fakeFireTimes
+}
+
+func (r runTimes) LastRun(id int) (time.Time, bool) {
+	return r.LastFired(id)
+}
+
+type countAction struct {
+	runs *int
+}
+
+func (c countAction) Do(
+	ctxt ops.Context, lightSet lights.Set, e *tasks.Execution) {
+	*c.runs++
+}
+
+func (c countAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
+
+// sleepTask is comparable unlike tasks.TaskFunc which SingleExecutor
+// needs.
+type sleepTask struct {
+	d time.Duration
+}
+
+func (s *sleepTask) Do(e *tasks.Execution) {
+	e.Sleep(s.d)
+}
+
 func newHueTask(id int) *ops.HueTask {
 	return newHueTaskWithAction(id, longHueAction{})
 }
@@ -397,6 +1767,63 @@ func (l intAction) UsedLights(
 	return lightSet
 }
 
+// sleepAction sleeps using the clock of its execution.
+type sleepAction struct {
+	d time.Duration
+}
+
+func (a sleepAction) Do(
+	c ops.Context, lightSet lights.Set, e *tasks.Execution) {
+	e.Sleep(a.d)
+}
+
+func (a sleepAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
+
+type errAction struct {
+}
+
+func (a errAction) Do(
+	c ops.Context, lightSet lights.Set, e *tasks.Execution) {
+	e.SetError(errors.New("failed"))
+}
+
+func (a errAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
+
+type recordingListener chan string
+
+func (l recordingListener) TaskStarted(task utils.Task) {
+	l <- "START " + task.TaskId()
+}
+
+func (l recordingListener) TaskFinished(task utils.Task) {
+	l <- "FINISH " + task.TaskId()
+}
+
+func (l recordingListener) TaskInterrupted(task utils.Task) {
+	l <- "INTERRUPTED " + task.TaskId()
+}
+
+func (l recordingListener) TaskError(task utils.Task, err error) {
+	l <- "ERROR " + task.TaskId()
+}
+
+func (l recordingListener) Verify(t *testing.T, expected ...string) {
+	for _, e := range expected {
+		select {
+		case actual := <-l:
+			if actual != e {
+				t.Errorf("Expected %s, got %s", e, actual)
+			}
+		case <-time.After(kMaxActivityWaitTime):
+			t.Errorf("Expected %s, got nothing", e)
+		}
+	}
+}
+
 type longAction struct {
 }
 
@@ -405,6 +1832,62 @@ func (l longAction) Do(
 	e.Sleep(time.Hour)
 }
 
+type flakyTask struct {
+	runs int32
+}
+
+func (f *flakyTask) Do(e *tasks.Execution) {
+	if atomic.AddInt32(&f.runs, 1) == 1 {
+		panic("crashed")
+	}
+	e.Sleep(time.Hour)
+}
+
+type failingTask struct {
+}
+
+func (f failingTask) Do(e *tasks.Execution) {
+	e.SetError(errors.New("failed"))
+}
+
+// waitFor waits for cond to become true failing the test if it takes
+// too long.
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(kMaxActivityWaitTime)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type signalAction struct {
+	started chan struct{}
+}
+
+func (a signalAction) Do(
+	c ops.Context, lightSet lights.Set, e *tasks.Execution) {
+	close(a.started)
+}
+
+func (a signalAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
+
+type stubbornAction struct {
+	release chan struct{}
+}
+
+func (a stubbornAction) Do(
+	c ops.Context, lightSet lights.Set, e *tasks.Execution) {
+	<-a.release
+}
+
+func (a stubbornAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
+
 type longHueAction struct {
 	longAction
 }
@@ -441,6 +1924,192 @@ func (l longHueActionFalse) UsedLights(
 	return lights.None
 }
 
+type fakeLights struct {
+	mu     sync.Mutex
+	states map[int]ops.LightState
+	err    error
+	// How many more calls to SetState fail with errFlaky
+	flaky int
+	// If true, SetState succeeds without changing anything.
+	ignoreWrites bool
+	// If non-nil, GetState and SetState wait for hung to close.
+	hung chan struct{}
+	// If non-nil, SetState waits for hungWrites to close.
+	hungWrites chan struct{}
+}
+
+var errFlaky = errors.New("flaky")
+
+func newFakeLights() *fakeLights {
+	return &fakeLights{states: make(map[int]ops.LightState)}
+}
+
+func (f *fakeLights) Set(
+	lightId int, properties *gohue.LightProperties) ([]byte, error) {
+	return nil, nil
+}
+
+func (f *fakeLights) Get(lightId int) (*gohue.LightProperties, []byte, error) {
+	return &gohue.LightProperties{}, nil, nil
+}
+
+func (f *fakeLights) GetState(lightId int) (*ops.LightState, []byte, error) {
+	f.waitIfHung()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state := f.states[lightId]
+	return &state, nil, nil
+}
+
+func (f *fakeLights) SetState(lightId int, state *ops.LightState) (
+	[]byte, error) {
+	f.waitIfHung()
+	f.mu.Lock()
+	hungWrites := f.hungWrites
+	f.mu.Unlock()
+	if hungWrites != nil {
+		<-hungWrites
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.flaky > 0 {
+		f.flaky--
+		return nil, errFlaky
+	}
+	if !f.ignoreWrites {
+		f.states[lightId] = *state
+	}
+	return nil, nil
+}
+
+// hang makes GetState and SetState wait until the returned function is
+// called.
+func (f *fakeLights) hang() func() {
+	hung := make(chan struct{})
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hung = hung
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.hung = nil
+		close(hung)
+	}
+}
+
+// hangWrites makes SetState wait until the returned function is called.
+func (f *fakeLights) hangWrites() func() {
+	hung := make(chan struct{})
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hungWrites = hung
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.hungWrites = nil
+		close(hung)
+	}
+}
+
+func (f *fakeLights) waitIfHung() {
+	f.mu.Lock()
+	hung := f.hung
+	f.mu.Unlock()
+	if hung != nil {
+		<-hung
+	}
+}
+
+func (f *fakeLights) setFlaky(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flaky = n
+}
+
+func (f *fakeLights) setIgnoreWrites(ignore bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ignoreWrites = ignore
+}
+
+func (f *fakeLights) setError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *fakeLights) setBrightness(lightId int, bri uint8) {
+	f.SetState(lightId, &ops.LightState{On: true, Brightness: maybe.NewUint8(bri)})
+}
+
+func (f *fakeLights) brightness(lightId int) uint8 {
+	state, _, _ := f.GetState(lightId)
+	return state.Brightness.Value
+}
+
+type cancelableContext struct {
+	ctx context.Context
+}
+
+func (c *cancelableContext) Set(
+	lightId int, properties *gohue.LightProperties) ([]byte, error) {
+	if c.ctx == nil {
+		return nil, nil
+	}
+	<-c.ctx.Done()
+	return nil, c.ctx.Err()
+}
+
+func (c *cancelableContext) WithContext(ctx context.Context) ops.Context {
+	return &cancelableContext{ctx: ctx}
+}
+
+type contextWaitAction struct {
+	canceled chan<- error
+}
+
+func (c contextWaitAction) Do(
+	ctxt ops.Context, lightSet lights.Set, e *tasks.Execution) {
+	_, err := ctxt.Set(1, &gohue.LightProperties{})
+	c.canceled <- err
+}
+
+func (c contextWaitAction) UsedLights(lightSet lights.Set) lights.Set {
+	return lightSet
+}
+
+// waitingClock is a FakeClock that reports each call to After so that
+// tests advance it only once a task is sleeping.
+type waitingClock struct {
+	*tasks.FakeClock
+	waiters chan struct{}
+}
+
+func newWaitingClock(now time.Time) *waitingClock {
+	return &waitingClock{
+		FakeClock: tasks.NewFakeClock(now),
+		waiters:   make(chan struct{}, 10),
+	}
+}
+
+func (c *waitingClock) After(d time.Duration) <-chan time.Time {
+	result := c.FakeClock.After(d)
+	c.waiters <- struct{}{}
+	return result
+}
+
+// WaitForWaiter blocks until some goroutine calls After.
+func (c *waitingClock) WaitForWaiter(t *testing.T) {
+	select {
+	case <-c.waiters:
+	case <-time.After(kMaxActivityWaitTime):
+		t.Fatal("Expected a task to sleep")
+	}
+}
+
 type hueTaskBeginner struct {
 	Activity chan interface{}
 }
@@ -555,6 +2224,16 @@ func verifyScheduled(
 				"At index %d, expected %s, got %s",
 				i, expected[i].StartTime, actual[i].StartTime)
 		}
+		if !reflect.DeepEqual(expected[i].EndTime, actual[i].EndTime) {
+			t.Errorf(
+				"At index %d, expected end %s, got %s",
+				i, expected[i].EndTime, actual[i].EndTime)
+		}
+		if expected[i].RestoreAtEnd != actual[i].RestoreAtEnd {
+			t.Errorf(
+				"At index %d, expected restore %v, got %v",
+				i, expected[i].RestoreAtEnd, actual[i].RestoreAtEnd)
+		}
 	}
 }
 
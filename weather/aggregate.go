@@ -0,0 +1,139 @@
+package weather
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// NamedProvider names a Provider so that aggregated reports can say where
+// their readings came from.
+type NamedProvider struct {
+	Name     string
+	Provider Provider
+}
+
+// Aggregation is a report aggregated from multiple providers.
+type Aggregation struct {
+	// Only Temperature and Condition are set.
+	Report Report
+
+	// The names of the providers whose temperatures were averaged into
+	// Report.Temperature in the order passed to Aggregate.
+	TemperatureSources []string
+
+	// The names of the providers that reported Report.Condition in the
+	// order passed to Aggregate.
+	ConditionSources []string
+}
+
+// Aggregate fetches from providers concurrently and returns the average
+// temperature along with the most commonly reported condition. Each
+// provider must supply the temperature and condition such as
+// OpenWeatherProvider or NWSProvider. A temperature more than
+// maxDeviation degrees from the median temperature is an outlier and
+// Aggregate ignores that provider entirely. If fewer than quorum
+// providers remain, Aggregate returns an error. When conditions tie,
+// Aggregate uses the one from the earliest provider. Aggregate panics if
+// no providers are passed to it.
+func Aggregate(
+	quorum int,
+	maxDeviation float64,
+	providers ...NamedProvider) (*Aggregation, error) {
+	if len(providers) == 0 {
+		panic("Aggregate must get at least one provider")
+	}
+	reports := make([]Report, len(providers))
+	errs := make([]error, len(providers))
+	var wg sync.WaitGroup
+	for i := range providers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = providers[i].Provider.Fetch(&reports[i])
+		}(i)
+	}
+	wg.Wait()
+	var lastErr error
+	var temperatures []float64
+	for i := range providers {
+		if errs[i] != nil {
+			lastErr = errs[i]
+			continue
+		}
+		temperatures = append(temperatures, reports[i].Temperature)
+	}
+	median := medianOf(temperatures)
+	var inliers []int
+	for i := range providers {
+		if errs[i] == nil &&
+			math.Abs(reports[i].Temperature-median) <= maxDeviation {
+			inliers = append(inliers, i)
+		}
+	}
+	if len(inliers) < quorum || len(inliers) == 0 {
+		if lastErr != nil {
+			return nil, fmt.Errorf(
+				"weather:%d of %d providers agree, need %d: %w",
+				len(inliers), len(providers), quorum, lastErr)
+		}
+		return nil, fmt.Errorf(
+			"weather:%d of %d providers agree, need %d",
+			len(inliers), len(providers), quorum)
+	}
+	var result Aggregation
+	var sum float64
+	counts := make(map[string]int)
+	for _, i := range inliers {
+		result.TemperatureSources = append(
+			result.TemperatureSources, providers[i].Name)
+		sum += reports[i].Temperature
+		if condition := reports[i].Condition; condition != "" {
+			counts[condition]++
+			if counts[condition] > counts[result.Report.Condition] {
+				result.Report.Condition = condition
+			}
+		}
+	}
+	result.Report.Temperature = sum / float64(len(inliers))
+	for _, i := range inliers {
+		if condition := reports[i].Condition; condition != "" &&
+			condition == result.Report.Condition {
+			result.ConditionSources = append(
+				result.ConditionSources, providers[i].Name)
+		}
+	}
+	return &result, nil
+}
+
+// AggregateProvider returns a Provider that supplies the temperature and
+// conditions aggregated from providers. See Aggregate.
+func AggregateProvider(
+	quorum int,
+	maxDeviation float64,
+	providers ...NamedProvider) Provider {
+	return ProviderFunc(func(report *Report) error {
+		aggregation, err := Aggregate(quorum, maxDeviation, providers...)
+		if err != nil {
+			return err
+		}
+		report.Temperature = aggregation.Report.Temperature
+		report.Condition = aggregation.Report.Condition
+		return nil
+	})
+}
+
+func medianOf(values []float64) float64 {
+	if len(values) == 0 {
+		return 0.0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2.0
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,140 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/keep94/toolbox/http_util"
+)
+
+// AirNowConn represents a connection to the EPA AirNow API.
+type AirNowConn struct {
+	client *http.Client
+	url    *url.URL
+}
+
+// NewAirNowConn returns a new, long lived, AirNow connection. apiKey comes
+// from https://docs.airnowapi.org. Each request times out after
+// DefaultTimeout.
+func NewAirNowConn(apiKey string) *AirNowConn {
+	return NewAirNowConnWithClient(apiKey, nil)
+}
+
+// NewAirNowConnWithClient works like NewAirNowConn except that the
+// returned connection sends its requests through client. nil means use a
+// client with DefaultTimeout.
+func NewAirNowConnWithClient(apiKey string, client *http.Client) *AirNowConn {
+	return &AirNowConn{
+		client: clientOrDefault(client), url: getAirNowUrl(apiKey)}
+}
+
+// GetAQIByZip returns the current AQI for a US zip code. The AQI is that
+// of the worst pollutant reported.
+func (c *AirNowConn) GetAQIByZip(zipCode string) (aqi int, err error) {
+	return c.GetAQIByZipContext(context.Background(), zipCode)
+}
+
+// GetAQIByZipContext works like GetAQIByZip except that ctx can cancel
+// the request.
+func (c *AirNowConn) GetAQIByZipContext(
+	ctx context.Context, zipCode string) (aqi int, err error) {
+	return c.get(
+		ctx, "/aq/observation/zipCode/current/", "zipCode", zipCode)
+}
+
+// GetAQIByLatLon returns the current AQI at a latitude and longitude.
+// The AQI is that of the worst pollutant reported.
+func (c *AirNowConn) GetAQIByLatLon(lat, lon float64) (aqi int, err error) {
+	return c.GetAQIByLatLonContext(context.Background(), lat, lon)
+}
+
+// GetAQIByLatLonContext works like GetAQIByLatLon except that ctx can
+// cancel the request.
+func (c *AirNowConn) GetAQIByLatLonContext(
+	ctx context.Context, lat, lon float64) (aqi int, err error) {
+	return c.get(
+		ctx,
+		"/aq/observation/latLong/current/",
+		"latitude", strconv.FormatFloat(lat, 'f', -1, 64),
+		"longitude", strconv.FormatFloat(lon, 'f', -1, 64))
+}
+
+func (c *AirNowConn) get(
+	ctx context.Context, path string, nameValues ...string) (int, error) {
+	u := *c.url
+	u.Path = path
+	resp, err := doGet(
+		ctx, c.client, http_util.AppendParams(&u, nameValues...), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	aqi, err := parseAirNow(resp.Body)
+	return aqi, malformed(err)
+}
+
+// AirNowProvider returns a Provider that supplies the AQI for a US zip
+// code from AirNow.
+func AirNowProvider(conn *AirNowConn, zipCode string) Provider {
+	return ProviderFunc(func(report *Report) error {
+		aqi, err := conn.GetAQIByZip(zipCode)
+		if err != nil {
+			return err
+		}
+		report.AQI = aqi
+		return nil
+	})
+}
+
+// FallbackProvider returns a Provider that fetches from each of providers
+// in turn until one succeeds such as AirNow when the PurpleAir sensors
+// drop out. If all of them fail, the returned Provider returns the last
+// error.
+func FallbackProvider(providers ...Provider) Provider {
+	return ProviderFunc(func(report *Report) error {
+		err := errors.New("weather:No providers")
+		for _, provider := range providers {
+			updated := *report
+			if err = provider.Fetch(&updated); err == nil {
+				*report = updated
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+func parseAirNow(r io.Reader) (int, error) {
+	var observations []airNowObservation
+	if err := json.NewDecoder(r).Decode(&observations); err != nil {
+		return 0, err
+	}
+	if len(observations) == 0 {
+		return 0, errors.New("weather:No observations in AirNow response")
+	}
+	result := 0
+	for _, observation := range observations {
+		if observation.AQI > result {
+			result = observation.AQI
+		}
+	}
+	return result, nil
+}
+
+func getAirNowUrl(apiKey string) *url.URL {
+	base := &url.URL{
+		Scheme: "https",
+		Host:   "www.airnowapi.org"}
+	return http_util.AppendParams(
+		base, "format", "application/json", "API_KEY", apiKey)
+}
+
+type airNowObservation struct {
+	ParameterName string `json:"ParameterName"`
+	AQI           int    `json:"AQI"`
+}
@@ -0,0 +1,19 @@
+package weather
+
+import (
+	"strings"
+	"testing"
+
+	asserts "github.com/stretchr/testify/assert"
+)
+
+func TestParseAirNow(t *testing.T) {
+	assert := asserts.New(t)
+	aqi, err := parseAirNow(strings.NewReader(`[
+		{"ParameterName": "O3", "AQI": 35, "Category": {"Number": 1, "Name": "Good"}},
+		{"ParameterName": "PM2.5", "AQI": 62, "Category": {"Number": 2, "Name": "Moderate"}}]`))
+	assert.NoError(err)
+	assert.Equal(62, aqi)
+	_, err = parseAirNow(strings.NewReader(`[]`))
+	assert.Error(err)
+}
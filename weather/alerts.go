@@ -0,0 +1,162 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/keep94/toolbox/http_util"
+)
+
+// Severity is the severity of a weather alert.
+type Severity int
+
+const (
+	SeverityUnknown Severity = iota
+	SeverityMinor
+	SeverityModerate
+	SeveritySevere
+	SeverityExtreme
+)
+
+var kSeverityNames = []string{"Unknown", "Minor", "Moderate", "Severe", "Extreme"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(kSeverityNames) {
+		return kSeverityNames[SeverityUnknown]
+	}
+	return kSeverityNames[s]
+}
+
+func parseSeverity(s string) Severity {
+	for i, name := range kSeverityNames {
+		if name == s {
+			return Severity(i)
+		}
+	}
+	return SeverityUnknown
+}
+
+// Alert represents a weather alert such as a tornado warning.
+type Alert struct {
+	// The kind of alert e.g 'Tornado Warning' or 'Heat Advisory'
+	Event string
+
+	// A one line summary of the alert
+	Headline string
+
+	Severity Severity
+
+	// When the alert takes effect
+	Effective time.Time
+
+	// When the alert expires. Zero means the alert has no expiry.
+	Expires time.Time
+}
+
+// IsActive returns true if this alert is in effect at now.
+func (a *Alert) IsActive(now time.Time) bool {
+	if now.Before(a.Effective) {
+		return false
+	}
+	return a.Expires.IsZero() || now.Before(a.Expires)
+}
+
+// Alerts returns the active alerts from the National Weather Service for
+// a latitude and longitude in the US. lat is positive for north; lon is
+// positive for east.
+func (c *NWSConn) Alerts(lat, lon float64) (alerts []Alert, err error) {
+	return c.AlertsContext(context.Background(), lat, lon)
+}
+
+// AlertsContext works like Alerts except that ctx can cancel the request.
+func (c *NWSConn) AlertsContext(
+	ctx context.Context, lat, lon float64) (alerts []Alert, err error) {
+	u := *c.url
+	u.Path = "/alerts/active"
+	point := fmt.Sprintf(
+		"%s,%s",
+		strconv.FormatFloat(lat, 'f', 4, 64),
+		strconv.FormatFloat(lon, 'f', 4, 64))
+	var resp *http.Response
+	if resp, err = c.get(ctx, http_util.AppendParams(&u, "point", point)); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	alerts, err = parseAlerts(resp.Body)
+	return alerts, malformed(err)
+}
+
+// NWSAlertsProvider returns a Provider that supplies the active weather
+// alerts for a latitude and longitude from the National Weather Service.
+func NWSAlertsProvider(conn *NWSConn, lat, lon float64) Provider {
+	return ProviderFunc(func(report *Report) error {
+		alerts, err := conn.Alerts(lat, lon)
+		if err != nil {
+			return err
+		}
+		report.Alerts = alerts
+		return nil
+	})
+}
+
+func parseAlerts(r io.Reader) ([]Alert, error) {
+	var response nwsAlerts
+	if err := json.NewDecoder(r).Decode(&response); err != nil {
+		return nil, err
+	}
+	if response.Features == nil {
+		return nil, errors.New("weather:Missing features in NWS alerts response")
+	}
+	result := make([]Alert, 0, len(response.Features))
+	for _, feature := range response.Features {
+		properties := &feature.Properties
+		alert := Alert{
+			Event:    properties.Event,
+			Headline: properties.Headline,
+			Severity: parseSeverity(properties.Severity),
+		}
+		var err error
+		if alert.Effective, err = parseAlertTime(properties.Effective); err != nil {
+			return nil, err
+		}
+		expires := properties.Ends
+		if expires == "" {
+			expires = properties.Expires
+		}
+		if alert.Expires, err = parseAlertTime(expires); err != nil {
+			return nil, err
+		}
+		result = append(result, alert)
+	}
+	return result, nil
+}
+
+func parseAlertTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+type nwsAlerts struct {
+	Features []nwsAlertFeature `json:"features"`
+}
+
+type nwsAlertFeature struct {
+	Properties nwsAlertProperties `json:"properties"`
+}
+
+type nwsAlertProperties struct {
+	Event     string `json:"event"`
+	Headline  string `json:"headline"`
+	Severity  string `json:"severity"`
+	Effective string `json:"effective"`
+	Expires   string `json:"expires"`
+	Ends      string `json:"ends"`
+}
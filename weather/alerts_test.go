@@ -0,0 +1,49 @@
+package weather
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	asserts "github.com/stretchr/testify/assert"
+)
+
+func TestParseAlerts(t *testing.T) {
+	assert := asserts.New(t)
+	alerts, err := parseAlerts(strings.NewReader(`{"features": [
+		{"properties": {
+			"event": "Tornado Warning",
+			"headline": "Tornado Warning until 4:15PM CDT",
+			"severity": "Extreme",
+			"effective": "2024-05-06T15:30:00-05:00",
+			"expires": "2024-05-06T16:15:00-05:00",
+			"ends": null}},
+		{"properties": {
+			"event": "Heat Advisory",
+			"severity": "Moderate",
+			"effective": "2024-05-06T10:00:00-05:00",
+			"expires": "2024-05-06T12:00:00-05:00",
+			"ends": "2024-05-06T20:00:00-05:00"}},
+		{"properties": {"event": "Test Message", "severity": "bogus"}}]}`))
+	assert.NoError(err)
+	cdt := time.FixedZone("CDT", -5*3600)
+	assert.Len(alerts, 3)
+	assert.Equal("Tornado Warning", alerts[0].Event)
+	assert.Equal("Tornado Warning until 4:15PM CDT", alerts[0].Headline)
+	assert.Equal(SeverityExtreme, alerts[0].Severity)
+	assert.True(time.Date(2024, 5, 6, 15, 30, 0, 0, cdt).Equal(alerts[0].Effective))
+	assert.True(time.Date(2024, 5, 6, 16, 15, 0, 0, cdt).Equal(alerts[0].Expires))
+	assert.Equal(SeverityModerate, alerts[1].Severity)
+	assert.True(time.Date(2024, 5, 6, 20, 0, 0, 0, cdt).Equal(alerts[1].Expires))
+	assert.Equal(SeverityUnknown, alerts[2].Severity)
+	assert.True(alerts[2].Expires.IsZero())
+
+	alerts, err = parseAlerts(strings.NewReader(`{"features": []}`))
+	assert.NoError(err)
+	assert.Empty(alerts)
+	_, err = parseAlerts(strings.NewReader(`{}`))
+	assert.Error(err)
+	_, err = parseAlerts(strings.NewReader(
+		`{"features": [{"properties": {"effective": "yesterday"}}]}`))
+	assert.Error(err)
+}
@@ -0,0 +1,115 @@
+package weather
+
+import (
+	"sync"
+	"time"
+)
+
+// ObservationGetter gets the current observation for a place. The meaning
+// of id depends on the implementation. OpenWeatherConn and NWSConn
+// implement this interface.
+type ObservationGetter interface {
+	Get(id string) (*Observation, error)
+}
+
+// CachingGetter decorates an ObservationGetter so that brief outages of
+// the underlying weather service go unnoticed. CachingGetter serves
+// observations it got less than ttl ago without calling the underlying
+// getter. After that, it calls the underlying getter and if that fails,
+// it serves the last good observation instead. An observation is stale
+// once its last successful get is more than staleAfter ago. CachingGetter
+// instances can be safely used with multiple goroutines.
+type CachingGetter struct {
+	getter     ObservationGetter
+	ttl        time.Duration
+	staleAfter time.Duration
+	now        func() time.Time
+	mu         sync.Mutex
+	entries    map[string]*cachedObservation
+}
+
+// NewCachingGetter returns a new CachingGetter that decorates getter.
+func NewCachingGetter(
+	getter ObservationGetter,
+	ttl time.Duration,
+	staleAfter time.Duration) *CachingGetter {
+	return &CachingGetter{
+		getter:     getter,
+		ttl:        ttl,
+		staleAfter: staleAfter,
+		now:        time.Now,
+		entries:    make(map[string]*cachedObservation),
+	}
+}
+
+// Get returns the observation for id. Get returns an error only if the
+// underlying getter fails and there is no good observation for id to
+// fall back on.
+func (c *CachingGetter) Get(id string) (*Observation, error) {
+	if observation, ok := c.fresh(id); ok {
+		return observation, nil
+	}
+	observation, err := c.getter.Get(id)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entries[id]
+	if err != nil {
+		if entry == nil {
+			return nil, err
+		}
+		entry.lastErr = err
+		return entry.observation, nil
+	}
+	c.entries[id] = &cachedObservation{
+		observation: observation, lastSuccess: c.now()}
+	return observation, nil
+}
+
+// LastSuccess returns when the underlying getter last succeeded for id.
+// LastSuccess returns false if it never has.
+func (c *CachingGetter) LastSuccess(id string) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entries[id]
+	if entry == nil {
+		return time.Time{}, false
+	}
+	return entry.lastSuccess, true
+}
+
+// IsStale returns true if the observation that Get serves for id is stale
+// or if there is no observation for id.
+func (c *CachingGetter) IsStale(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entries[id]
+	return entry == nil || c.now().Sub(entry.lastSuccess) > c.staleAfter
+}
+
+// LastError returns the error from the last failed get for id since the
+// last successful one or nil if there is no such error.
+func (c *CachingGetter) LastError(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entries[id]
+	if entry == nil {
+		return nil
+	}
+	return entry.lastErr
+}
+
+func (c *CachingGetter) fresh(id string) (*Observation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entries[id]
+	if entry == nil || c.now().Sub(entry.lastSuccess) >= c.ttl {
+		return nil, false
+	}
+	return entry.observation, true
+}
+
+type cachedObservation struct {
+	observation *Observation
+	lastSuccess time.Time
+	lastErr     error
+}
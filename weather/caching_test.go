@@ -0,0 +1,95 @@
+package weather
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	asserts "github.com/stretchr/testify/assert"
+)
+
+func TestCachingGetter(t *testing.T) {
+	assert := asserts.New(t)
+	getter := &fakeGetter{}
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	start := now
+	cache := NewCachingGetter(getter, time.Minute, 10*time.Minute)
+	cache.now = func() time.Time { return now }
+
+	// Nothing to fall back on yet
+	getter.err = errors.New("offline")
+	_, err := cache.Get("KNUQ")
+	assert.Error(err)
+	assert.True(cache.IsStale("KNUQ"))
+	_, ok := cache.LastSuccess("KNUQ")
+	assert.False(ok)
+
+	getter.err = nil
+	getter.observation = &Observation{Temperature: 20.0}
+	observation, err := cache.Get("KNUQ")
+	assert.NoError(err)
+	assert.Equal(20.0, observation.Temperature)
+	assert.Equal(2, getter.calls)
+	assert.False(cache.IsStale("KNUQ"))
+
+	// Within ttl, served from cache
+	getter.observation = &Observation{Temperature: 21.0}
+	now = now.Add(30 * time.Second)
+	observation, _ = cache.Get("KNUQ")
+	assert.Equal(20.0, observation.Temperature)
+	assert.Equal(2, getter.calls)
+
+	// Outage serves the last good observation
+	getter.err = errors.New("offline")
+	now = now.Add(5 * time.Minute)
+	observation, err = cache.Get("KNUQ")
+	assert.NoError(err)
+	assert.Equal(20.0, observation.Temperature)
+	assert.Equal(getter.err, cache.LastError("KNUQ"))
+	assert.False(cache.IsStale("KNUQ"))
+	now = now.Add(5 * time.Minute)
+	observation, err = cache.Get("KNUQ")
+	assert.NoError(err)
+	assert.Equal(20.0, observation.Temperature)
+	assert.True(cache.IsStale("KNUQ"))
+	lastSuccess, ok := cache.LastSuccess("KNUQ")
+	assert.True(ok)
+	assert.Equal(start, lastSuccess)
+
+	// Recovery
+	getter.err = nil
+	observation, err = cache.Get("KNUQ")
+	assert.NoError(err)
+	assert.Equal(21.0, observation.Temperature)
+	assert.False(cache.IsStale("KNUQ"))
+	assert.NoError(cache.LastError("KNUQ"))
+}
+
+type fakeGetter struct {
+	observation *Observation
+	err         error
+	calls       int
+}
+
+func (f *fakeGetter) Get(id string) (*Observation, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.observation, nil
+}
+
+func TestCachingGetterProvider(t *testing.T) {
+	assert := asserts.New(t)
+	getter := &fakeGetter{
+		observation: &Observation{Temperature: 20.0, Weather: "Fair"}}
+	cache := NewCachingGetter(getter, 0, time.Hour)
+	provider := NWSProvider(cache, "KNUQ")
+	var report Report
+	assert.NoError(provider.Fetch(&report))
+	getter.err = errors.New("offline")
+	report = Report{}
+	assert.NoError(provider.Fetch(&report))
+	assert.Equal(Report{Temperature: 20.0, Condition: "Fair"}, report)
+	assert.Equal(2, getter.calls)
+}
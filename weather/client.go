@@ -0,0 +1,49 @@
+package weather
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultTimeout is the overall timeout for each request that connections
+// created without their own http.Client use.
+const DefaultTimeout = 30 * time.Second
+
+func defaultClient() *http.Client {
+	return &http.Client{Timeout: DefaultTimeout}
+}
+
+func clientOrDefault(client *http.Client) *http.Client {
+	if client == nil {
+		return defaultClient()
+	}
+	return client
+}
+
+// doGet sends a GET request for u using client. doGet returns an *Error
+// if the request fails or the response status is not 200. On success,
+// caller must close the body of the returned response.
+func doGet(
+	ctx context.Context,
+	client *http.Client,
+	u *url.URL,
+	header http.Header) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, &Error{Kind: NetworkError, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, statusError(resp, time.Now())
+	}
+	return resp, nil
+}
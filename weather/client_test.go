@@ -0,0 +1,71 @@
+package weather
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	asserts "github.com/stretchr/testify/assert"
+)
+
+func TestNWSConnWithClient(t *testing.T) {
+	assert := asserts.New(t)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal("/stations/KNUQ/observations/latest", r.URL.Path)
+			assert.Equal("test-agent", r.Header.Get("User-Agent"))
+			w.Write([]byte(`{"properties": {
+				"textDescription": "Fair",
+				"temperature": {"value": 20.0}}}`))
+		}))
+	defer server.Close()
+	conn := NewNWSConnWithClient("test-agent", server.Client())
+	conn.url = serverUrl(t, server)
+	observation, err := conn.Get("KNUQ")
+	assert.NoError(err)
+	assert.Equal(&Observation{Temperature: 20.0, Weather: "Fair"}, observation)
+}
+
+func TestConnTimeouts(t *testing.T) {
+	assert := asserts.New(t)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}))
+	defer server.Close()
+	defer close(release)
+
+	// The client timeout applies to each request
+	client := server.Client()
+	client.Timeout = 50 * time.Millisecond
+	conn := NewOpenWeatherConnWithClient("key", client)
+	conn.url = serverUrl(t, server)
+	_, err := conn.Get("5375480")
+	assert.Error(err)
+
+	// A context deadline applies too
+	conn = NewOpenWeatherConnWithClient("key", server.Client())
+	conn.url = serverUrl(t, server)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = conn.ForecastContext(ctx, "5375480")
+	assert.ErrorIs(err, context.DeadlineExceeded)
+
+	assert.Equal(DefaultTimeout, NewAirNowConn("key").client.Timeout)
+	assert.Equal(DefaultTimeout, NewPurpleAirConn().client.Timeout)
+}
+
+func serverUrl(t *testing.T, server *httptest.Server) *url.URL {
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
@@ -0,0 +1,157 @@
+package weather
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorKind classifies errors from weather services.
+type ErrorKind int
+
+const (
+	// The service could not be reached or did not answer in time.
+	NetworkError ErrorKind = iota + 1
+
+	// The service is rate limiting requests (HTTP 429).
+	RateLimited
+
+	// The service rejected the credentials such as a bad API key
+	// (HTTP 401 or 403).
+	AuthFailed
+
+	// The service sent a response that could not be parsed.
+	MalformedPayload
+
+	// The service returned some other non-200 status.
+	BadStatus
+)
+
+var kErrorKindNames = map[ErrorKind]string{
+	NetworkError:     "network error",
+	RateLimited:      "rate limited",
+	AuthFailed:       "auth failed",
+	MalformedPayload: "malformed payload",
+	BadStatus:        "bad status",
+}
+
+func (k ErrorKind) String() string {
+	if name, ok := kErrorKindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Error is an error from a weather service. The connections in this
+// package return *Error for all failures.
+type Error struct {
+	Kind ErrorKind
+
+	// The HTTP status code or 0 if there was no response.
+	StatusCode int
+
+	// How long the service asked clients to wait before retrying. Only
+	// set for RateLimited errors that included a Retry-After header.
+	RetryAfter time.Duration
+
+	// The underlying error if any.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("weather:%v: %v", e.Kind, e.Err)
+	}
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("weather:%v: HTTP %d", e.Kind, e.StatusCode)
+	}
+	return fmt.Sprintf("weather:%v", e.Kind)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Temporary returns true if retrying later may succeed. Network errors,
+// rate limiting, and 5xx statuses are temporary; auth failures and
+// malformed payloads are not.
+func (e *Error) Temporary() bool {
+	switch e.Kind {
+	case NetworkError, RateLimited:
+		return true
+	case BadStatus:
+		return e.StatusCode >= 500
+	default:
+		return false
+	}
+}
+
+// IsTemporary returns true if err is or wraps a temporary *Error. Alerting
+// can use IsTemporary to ignore transient blips.
+func IsTemporary(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Temporary()
+}
+
+// isRetryable returns true if a Poller should retry after err. Errors
+// that are not *Error are retryable.
+func isRetryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Temporary()
+	}
+	return true
+}
+
+// retryAfter returns how long err asks clients to wait before retrying or
+// 0 if err does not say.
+func retryAfter(err error) time.Duration {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.RetryAfter
+	}
+	return 0
+}
+
+// malformed classifies err from parsing a response as a MalformedPayload
+// error. malformed returns nil if err is nil.
+func malformed(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: MalformedPayload, Err: err}
+}
+
+// statusError returns the error for a non-200 response.
+func statusError(resp *http.Response, now time.Time) *Error {
+	result := &Error{Kind: BadStatus, StatusCode: resp.StatusCode}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		result.Kind = RateLimited
+		result.RetryAfter = parseRetryAfter(
+			resp.Header.Get("Retry-After"), now)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		result.Kind = AuthFailed
+	}
+	return result
+}
+
+// parseRetryAfter parses a Retry-After header which is either seconds or
+// an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
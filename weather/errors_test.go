@@ -0,0 +1,85 @@
+package weather
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	asserts "github.com/stretchr/testify/assert"
+)
+
+func TestErrorKinds(t *testing.T) {
+	assert := asserts.New(t)
+	var status int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "120")
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+	conn := NewOpenWeatherConnWithClient("key", server.Client())
+	conn.url = serverUrl(t, server)
+
+	status = http.StatusTooManyRequests
+	_, err := conn.Get("5375480")
+	assertErrorKind(assert, RateLimited, err)
+	assert.Equal(2*time.Minute, retryAfter(err))
+	assert.True(IsTemporary(err))
+
+	status = http.StatusUnauthorized
+	_, err = conn.Get("5375480")
+	assertErrorKind(assert, AuthFailed, err)
+	assert.False(IsTemporary(err))
+	assert.Equal("weather:auth failed: HTTP 401", err.Error())
+
+	status = http.StatusServiceUnavailable
+	_, err = conn.Get("5375480")
+	assertErrorKind(assert, BadStatus, err)
+	assert.True(IsTemporary(err))
+
+	status = http.StatusNotFound
+	_, err = conn.Get("5375480")
+	assertErrorKind(assert, BadStatus, err)
+	assert.False(IsTemporary(err))
+
+	status = http.StatusOK
+	body = `{"weather": []}`
+	_, err = conn.Get("5375480")
+	assertErrorKind(assert, MalformedPayload, err)
+	assert.False(isRetryable(err))
+
+	server.Close()
+	_, err = conn.Get("5375480")
+	assertErrorKind(assert, NetworkError, err)
+	assert.True(IsTemporary(fmt.Errorf("wrapped: %w", err)))
+
+	// Errors that are not classified are retried but are not temporary
+	plain := errors.New("plain")
+	assert.True(isRetryable(plain))
+	assert.False(IsTemporary(plain))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert := asserts.New(t)
+	now := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	assert.Equal(30*time.Second, parseRetryAfter("30", now))
+	assert.Equal(
+		90*time.Second,
+		parseRetryAfter("Wed, 21 Oct 2015 07:29:30 GMT", now))
+	assert.Equal(time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(time.Duration(0), parseRetryAfter("-5", now))
+	assert.Equal(time.Duration(0), parseRetryAfter("soon", now))
+}
+
+func assertErrorKind(assert *asserts.Assertions, kind ErrorKind, err error) {
+	var e *Error
+	if assert.True(errors.As(err, &e), "%v", err) {
+		assert.Equal(kind, e.Kind)
+	}
+}
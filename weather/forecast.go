@@ -0,0 +1,152 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/keep94/toolbox/http_util"
+)
+
+// Prediction represents a forecast for a single period of time.
+type Prediction struct {
+	// When the prediction is for
+	Time time.Time
+
+	// Temperature in celsius. For daily predictions, the daytime
+	// temperature.
+	Temperature float64
+
+	// Low and high temperature in celsius. Only daily predictions have
+	// these.
+	Low  float64
+	High float64
+
+	// Weather conditions e.g 'light rain' or 'clear sky'
+	Condition string
+
+	// The probability of precipitation from 0.0 to 1.0
+	PrecipitationProbability float64
+}
+
+// Forecast represents a weather forecast.
+type Forecast struct {
+	// Predictions in 3 hour steps for the next 5 days, earliest first.
+	Hourly []Prediction
+
+	// Predictions for each day, earliest first. Times fall around midday
+	// of each day.
+	Daily []Prediction
+}
+
+// Forecast returns the forecast for a particular city so that lighting
+// schedules can react to upcoming weather. cityId works like in Get. The
+// daily forecast needs an API key with access to OpenWeather's daily
+// forecast endpoint.
+func (c *OpenWeatherConn) Forecast(cityId string) (
+	forecast *Forecast, err error) {
+	return c.ForecastContext(context.Background(), cityId)
+}
+
+// ForecastContext works like Forecast except that ctx can cancel the
+// requests. Forecast makes two requests, so a deadline on ctx bounds the
+// overall time.
+func (c *OpenWeatherConn) ForecastContext(
+	ctx context.Context, cityId string) (forecast *Forecast, err error) {
+	var hourly, daily []Prediction
+	if hourly, err = c.predictions(
+		ctx, "/data/2.5/forecast", cityId, parseHourly); err != nil {
+		return
+	}
+	if daily, err = c.predictions(
+		ctx, "/data/2.5/forecast/daily", cityId, parseDaily); err != nil {
+		return
+	}
+	return &Forecast{Hourly: hourly, Daily: daily}, nil
+}
+
+func (c *OpenWeatherConn) predictions(
+	ctx context.Context,
+	path string,
+	cityId string,
+	parse func(r io.Reader) ([]Prediction, error)) ([]Prediction, error) {
+	u := *c.url
+	u.Path = path
+	resp, err := doGet(
+		ctx, c.client, http_util.AppendParams(&u, "id", cityId), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	predictions, err := parse(resp.Body)
+	return predictions, malformed(err)
+}
+
+func parseHourly(r io.Reader) ([]Prediction, error) {
+	var response openWeatherForecast
+	if err := json.NewDecoder(r).Decode(&response); err != nil {
+		return nil, err
+	}
+	result := make([]Prediction, len(response.List))
+	for i, entry := range response.List {
+		if entry.Main == nil {
+			return nil, errors.New("weather:Missing main section in open weather forecast")
+		}
+		result[i] = Prediction{
+			Time:                     time.Unix(entry.Dt, 0),
+			Temperature:              entry.Main.Temp - 273.15,
+			Condition:                entry.condition(),
+			PrecipitationProbability: entry.Pop,
+		}
+	}
+	return result, nil
+}
+
+func parseDaily(r io.Reader) ([]Prediction, error) {
+	var response openWeatherForecast
+	if err := json.NewDecoder(r).Decode(&response); err != nil {
+		return nil, err
+	}
+	result := make([]Prediction, len(response.List))
+	for i, entry := range response.List {
+		if entry.Temp == nil {
+			return nil, errors.New("weather:Missing temp section in open weather forecast")
+		}
+		result[i] = Prediction{
+			Time:                     time.Unix(entry.Dt, 0),
+			Temperature:              entry.Temp.Day - 273.15,
+			Low:                      entry.Temp.Min - 273.15,
+			High:                     entry.Temp.Max - 273.15,
+			Condition:                entry.condition(),
+			PrecipitationProbability: entry.Pop,
+		}
+	}
+	return result, nil
+}
+
+type openWeatherForecast struct {
+	List []openWeatherForecastEntry `json:"list"`
+}
+
+type openWeatherForecastEntry struct {
+	Dt      int64                `json:"dt"`
+	Main    *openWeatherMain     `json:"main"`
+	Temp    *openWeatherTemp     `json:"temp"`
+	Weather []openWeatherWeather `json:"weather"`
+	Pop     float64              `json:"pop"`
+}
+
+func (e *openWeatherForecastEntry) condition() string {
+	if len(e.Weather) == 0 {
+		return ""
+	}
+	return e.Weather[0].Description
+}
+
+type openWeatherTemp struct {
+	Day float64 `json:"day"`
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
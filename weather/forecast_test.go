@@ -0,0 +1,41 @@
+package weather
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	asserts "github.com/stretchr/testify/assert"
+)
+
+func TestParseHourly(t *testing.T) {
+	assert := asserts.New(t)
+	predictions, err := parseHourly(strings.NewReader(`{"list": [
+		{"dt": 1400000000, "main": {"temp": 293.15}, "weather": [{"description": "light rain"}], "pop": 0.4},
+		{"dt": 1400010800, "main": {"temp": 290.15}, "weather": [], "pop": 0}]}`))
+	assert.NoError(err)
+	assert.Equal(2, len(predictions))
+	assert.Equal(time.Unix(1400000000, 0), predictions[0].Time)
+	assert.InDelta(20.0, predictions[0].Temperature, 0.001)
+	assert.Equal("light rain", predictions[0].Condition)
+	assert.Equal(0.4, predictions[0].PrecipitationProbability)
+	assert.InDelta(17.0, predictions[1].Temperature, 0.001)
+	assert.Equal("", predictions[1].Condition)
+	_, err = parseHourly(strings.NewReader(`{"list": [{"dt": 1400000000}]}`))
+	assert.Error(err)
+}
+
+func TestParseDaily(t *testing.T) {
+	assert := asserts.New(t)
+	predictions, err := parseDaily(strings.NewReader(`{"list": [
+		{"dt": 1400000000, "temp": {"day": 298.15, "min": 283.15, "max": 303.15}, "weather": [{"description": "clear sky"}], "pop": 0.1}]}`))
+	assert.NoError(err)
+	assert.Equal(1, len(predictions))
+	assert.InDelta(25.0, predictions[0].Temperature, 0.001)
+	assert.InDelta(10.0, predictions[0].Low, 0.001)
+	assert.InDelta(30.0, predictions[0].High, 0.001)
+	assert.Equal("clear sky", predictions[0].Condition)
+	assert.Equal(0.1, predictions[0].PrecipitationProbability)
+	_, err = parseDaily(strings.NewReader(`{"list": [{"dt": 1400000000}]}`))
+	assert.Error(err)
+}
@@ -0,0 +1,144 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// kDefaultUserAgent is the User-Agent that Get sends.
+const kDefaultUserAgent = "marvin2 (github.com/keep94/marvin2)"
+
+var kNWSConn = NewNWSConn(kDefaultUserAgent)
+
+// NWSConn represents a connection to the National Weather Service API at
+// api.weather.gov.
+type NWSConn struct {
+	client    *http.Client
+	url       *url.URL
+	userAgent string
+}
+
+// NewNWSConn returns a new, long lived, connection to the National Weather
+// Service API. The API requires userAgent to identify the app, ideally with
+// a way to contact its owner e.g "(myapp.example.com, me@example.com)".
+// Each request times out after DefaultTimeout.
+func NewNWSConn(userAgent string) *NWSConn {
+	return NewNWSConnWithClient(userAgent, nil)
+}
+
+// NewNWSConnWithClient works like NewNWSConn except that the returned
+// connection sends its requests through client. nil means use a client
+// with DefaultTimeout.
+func NewNWSConnWithClient(userAgent string, client *http.Client) *NWSConn {
+	return &NWSConn{
+		client:    clientOrDefault(client),
+		url:       getNWSUrl(),
+		userAgent: userAgent,
+	}
+}
+
+// Get returns the latest observation from a weather station. For example
+// "KNUQ" means moffett field.
+func (c *NWSConn) Get(station string) (observation *Observation, err error) {
+	return c.GetContext(context.Background(), station)
+}
+
+// GetContext works like Get except that ctx can cancel the request.
+func (c *NWSConn) GetContext(ctx context.Context, station string) (
+	observation *Observation, err error) {
+	u := *c.url
+	u.Path = fmt.Sprintf("/stations/%s/observations/latest", station)
+	var resp *http.Response
+	if resp, err = c.get(ctx, &u); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	observation, err = parseNWS(resp.Body)
+	return observation, malformed(err)
+}
+
+// get sends a GET request to the NWS API. On success, caller must close
+// the body of the returned response.
+func (c *NWSConn) get(ctx context.Context, u *url.URL) (*http.Response, error) {
+	header := make(http.Header)
+	header.Set("User-Agent", c.userAgent)
+	header.Set("Accept", "application/geo+json")
+	return doGet(ctx, c.client, u, header)
+}
+
+// NWSProvider returns a Provider that supplies the temperature,
+// conditions, humidity, wind and pressure from a National Weather Service
+// station. getter is typically an *NWSConn or a CachingGetter wrapping
+// one.
+func NWSProvider(getter ObservationGetter, station string) Provider {
+	return ProviderFunc(func(report *Report) error {
+		observation, err := getter.Get(station)
+		if err != nil {
+			return err
+		}
+		observation.setReport(report)
+		return nil
+	})
+}
+
+func parseNWS(r io.Reader) (*Observation, error) {
+	var response nwsObservation
+	if err := json.NewDecoder(r).Decode(&response); err != nil {
+		return nil, err
+	}
+	if response.Properties == nil {
+		return nil, errors.New("weather:Missing properties in NWS response")
+	}
+	temperature := response.Properties.Temperature.Value
+	if temperature == nil {
+		return nil, errors.New("weather:Missing temperature in NWS response")
+	}
+	properties := response.Properties
+	return &Observation{
+		Temperature:   *temperature,
+		Weather:       properties.TextDescription,
+		Humidity:      properties.RelativeHumidity.value(),
+		WindSpeed:     properties.WindSpeed.value() / 3.6,
+		WindDirection: properties.WindDirection.value(),
+		Pressure:      properties.BarometricPressure.value() / 100.0,
+	}, nil
+}
+
+func getNWSUrl() *url.URL {
+	return &url.URL{
+		Scheme: "https",
+		Host:   "api.weather.gov"}
+}
+
+type nwsObservation struct {
+	Properties *nwsProperties `json:"properties"`
+}
+
+type nwsProperties struct {
+	TextDescription    string   `json:"textDescription"`
+	Temperature        nwsValue `json:"temperature"`
+	RelativeHumidity   nwsValue `json:"relativeHumidity"`
+	WindSpeed          nwsValue `json:"windSpeed"`
+	WindDirection      nwsValue `json:"windDirection"`
+	BarometricPressure nwsValue `json:"barometricPressure"`
+}
+
+// nwsValue is a measurement in the units the NWS API uses by default e.g
+// celsius for temperature, km/h for wind speed, and pascals for pressure.
+// A nil Value means no reading.
+type nwsValue struct {
+	Value *float64 `json:"value"`
+}
+
+// value returns the reading or 0 if there is none.
+func (v nwsValue) value() float64 {
+	if v.Value == nil {
+		return 0.0
+	}
+	return *v.Value
+}
@@ -0,0 +1,35 @@
+package weather
+
+import (
+	"strings"
+	"testing"
+
+	asserts "github.com/stretchr/testify/assert"
+)
+
+func TestParseNWS(t *testing.T) {
+	assert := asserts.New(t)
+	observation, err := parseNWS(strings.NewReader(`{"properties": {
+		"textDescription": "Mostly Cloudy",
+		"temperature": {"unitCode": "wmoUnit:degC", "value": 18.3}}}`))
+	assert.NoError(err)
+	assert.Equal(&Observation{Temperature: 18.3, Weather: "Mostly Cloudy"}, observation)
+	observation, err = parseNWS(strings.NewReader(`{"properties": {
+		"textDescription": "Windy",
+		"temperature": {"unitCode": "wmoUnit:degC", "value": 12.0},
+		"relativeHumidity": {"unitCode": "wmoUnit:percent", "value": 65.5},
+		"windSpeed": {"unitCode": "wmoUnit:km_h-1", "value": 36.0},
+		"windDirection": {"unitCode": "wmoUnit:degree_(angle)", "value": 270},
+		"barometricPressure": {"unitCode": "wmoUnit:Pa", "value": 101320}}}`))
+	assert.NoError(err)
+	assert.Equal(65.5, observation.Humidity)
+	assert.InDelta(10.0, observation.WindSpeed, 0.001)
+	assert.Equal(270.0, observation.WindDirection)
+	assert.InDelta(1013.2, observation.Pressure, 0.001)
+	_, err = parseNWS(strings.NewReader(`{"properties": {
+		"textDescription": "Fair",
+		"temperature": {"unitCode": "wmoUnit:degC", "value": null}}}`))
+	assert.Error(err)
+	_, err = parseNWS(strings.NewReader(`{}`))
+	assert.Error(err)
+}
@@ -0,0 +1,25 @@
+package weather
+
+import (
+	"strings"
+	"testing"
+
+	asserts "github.com/stretchr/testify/assert"
+)
+
+func TestParseOpenWeather(t *testing.T) {
+	assert := asserts.New(t)
+	observation, err := parseOpenWeather(strings.NewReader(`{
+		"weather": [{"description": "broken clouds"}],
+		"main": {"temp": 293.15, "humidity": 72, "pressure": 1015},
+		"wind": {"speed": 4.6, "deg": 310}}`))
+	assert.NoError(err)
+	assert.InDelta(20.0, observation.Temperature, 0.001)
+	assert.Equal("broken clouds", observation.Weather)
+	assert.Equal(72.0, observation.Humidity)
+	assert.Equal(4.6, observation.WindSpeed)
+	assert.Equal(310.0, observation.WindDirection)
+	assert.Equal(1015.0, observation.Pressure)
+	_, err = parseOpenWeather(strings.NewReader(`{"weather": []}`))
+	assert.Error(err)
+}
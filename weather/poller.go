@@ -0,0 +1,181 @@
+package weather
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/keep94/tasks"
+)
+
+// Provider fetches part of a weather report from a weather service.
+type Provider interface {
+	// Fetch fills in the fields of report that this provider supplies
+	// leaving the other fields alone.
+	Fetch(report *Report) error
+}
+
+// ProviderFunc converts an ordinary function into a Provider.
+type ProviderFunc func(report *Report) error
+
+func (f ProviderFunc) Fetch(report *Report) error {
+	return f(report)
+}
+
+// OpenWeatherProvider returns a Provider that supplies the temperature,
+// conditions, humidity, wind and pressure for a particular city from open
+// weather. getter is typically an *OpenWeatherConn or a CachingGetter
+// wrapping one.
+func OpenWeatherProvider(getter ObservationGetter, cityId string) Provider {
+	return ProviderFunc(func(report *Report) error {
+		observation, err := getter.Get(cityId)
+		if err != nil {
+			return err
+		}
+		observation.setReport(report)
+		return nil
+	})
+}
+
+// AQIProvider returns a Provider that supplies the AQI averaged over
+// multiple stations. See AvgAQI.
+func AQIProvider(
+	getter AQIGetter,
+	delayBetweenCalls time.Duration,
+	stationIds ...int64) Provider {
+	return ProviderFunc(func(report *Report) error {
+		aqi, err := AvgAQI(getter, delayBetweenCalls, stationIds...)
+		if err != nil {
+			return err
+		}
+		report.AQI = aqi
+		return nil
+	})
+}
+
+// Source tells a Poller how to poll one Provider.
+type Source struct {
+	Provider Provider
+
+	// How often to poll Provider.
+	Interval time.Duration
+
+	// How many times to retry a failed fetch before waiting for the next
+	// poll. Errors that are not temporary such as auth failures are never
+	// retried. See Error.Temporary.
+	Retries int
+
+	// How long to wait between retries.
+	RetryDelay time.Duration
+}
+
+// Poller periodically fetches from several providers and writes the
+// merged results to a ReportCache. Each fetch fills in only the fields of
+// its provider, so the cached report keeps the last good value of each
+// field. Poller implements tasks.Task so that it can run as a
+// utils.ScheduledTask. Poller instances can be safely used with multiple
+// goroutines, but should run in only one execution at a time.
+type Poller struct {
+	cache   *ReportCache
+	jitter  time.Duration
+	sources []Source
+	mu      sync.Mutex
+	lastErr error
+}
+
+// NewPoller returns a Poller that writes to cache. Each poll happens up
+// to jitter later than scheduled so that installations do not all hit the
+// weather services at the same time.
+func NewPoller(
+	cache *ReportCache, jitter time.Duration, sources ...Source) *Poller {
+	return &Poller{cache: cache, jitter: jitter, sources: sources}
+}
+
+// Do polls the providers until e ends. Do polls each provider right away
+// and then at its interval.
+func (p *Poller) Do(e *tasks.Execution) {
+	if len(p.sources) == 0 {
+		return
+	}
+	var report Report
+	p.cache.Get(&report)
+	next := make([]time.Time, len(p.sources))
+	now := e.Now()
+	for i := range next {
+		next[i] = now
+	}
+	for {
+		idx := 0
+		for i := range next {
+			if next[i].Before(next[idx]) {
+				idx = i
+			}
+		}
+		if wait := next[idx].Sub(e.Now()) + p.randomJitter(); wait > 0 {
+			if !e.Sleep(wait) {
+				return
+			}
+		}
+		source := &p.sources[idx]
+		updated, ok := p.fetch(e, source, report)
+		if e.IsEnded() {
+			return
+		}
+		if ok {
+			report = updated
+			p.cache.Set(&report)
+		}
+		next[idx] = next[idx].Add(source.Interval)
+		if now := e.Now(); next[idx].Before(now) {
+			next[idx] = now
+		}
+	}
+}
+
+// LastError returns the error from the last failed fetch or nil if no
+// fetch failed. Use IsTemporary to tell transient blips from problems
+// that need attention.
+func (p *Poller) LastError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastErr
+}
+
+// fetch fetches from source into a copy of report retrying as needed.
+// fetch returns the updated copy and true on success. fetch does not
+// retry errors that are not temporary such as auth failures, and when
+// rate limited, it waits at least as long as the service asks.
+func (p *Poller) fetch(
+	e *tasks.Execution, source *Source, report Report) (Report, bool) {
+	for attempt := 0; ; attempt++ {
+		updated := report
+		err := source.Provider.Fetch(&updated)
+		if err == nil {
+			return updated, true
+		}
+		p.setLastError(err)
+		if attempt >= source.Retries || !isRetryable(err) {
+			return report, false
+		}
+		delay := source.RetryDelay
+		if after := retryAfter(err); after > delay {
+			delay = after
+		}
+		if !e.Sleep(delay) {
+			return report, false
+		}
+	}
+}
+
+func (p *Poller) setLastError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastErr = err
+}
+
+func (p *Poller) randomJitter() time.Duration {
+	if p.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(p.jitter)))
+}
@@ -0,0 +1,78 @@
+package weather
+
+import (
+	"math"
+	"time"
+
+	"github.com/keep94/sunrise"
+)
+
+// SolarTimes represents the solar times for a single day at a particular
+// place. All times are in the location of the date passed to SunTimes.
+type SolarTimes struct {
+	// When the sun is 6 degrees below the horizon in the morning.
+	CivilDawn time.Time
+
+	// When the sun rises.
+	Sunrise time.Time
+
+	// When the sun sets. Matches the times from recurring.EachSunset.
+	Sunset time.Time
+
+	// When the sun is 6 degrees below the horizon in the evening.
+	CivilDusk time.Time
+}
+
+// SunTimes returns the solar times for the day of date at the given
+// latitude and longitude. The day of date is taken in the location of
+// date. lat is positive for north and negative for south; lon is
+// positive for east and negative for west. Where the sun stays above or
+// below the horizon all day, the times bunch up at or spread out from
+// solar noon.
+func SunTimes(lat, lon float64, date time.Time) *SolarTimes {
+	noon := time.Date(
+		date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, date.Location())
+	var s sunrise.Sunrise
+	s.Around(lat, lon, noon)
+	rise := s.Sunrise()
+	set := s.Sunset()
+	solarNoon := rise.Add(set.Sub(rise) / 2)
+	civil := hourAngle(lat, declination(solarNoon), -6.0)
+	return &SolarTimes{
+		CivilDawn: solarNoon.Add(-civil),
+		Sunrise:   rise,
+		Sunset:    set,
+		CivilDusk: solarNoon.Add(civil),
+	}
+}
+
+// declination returns the declination of the sun in degrees at t.
+func declination(t time.Time) float64 {
+	days := float64(t.Unix()-946728000) / 86400.0
+	anomaly := 357.5291 + 0.98560028*days
+	center := 1.9148*sinDeg(anomaly) + 0.02*sinDeg(2.0*anomaly) +
+		0.0003*sinDeg(3.0*anomaly)
+	eclipticLon := anomaly + 102.9372 + center + 180.0
+	return degrees(math.Asin(sinDeg(eclipticLon) * sinDeg(23.45)))
+}
+
+// hourAngle returns how long before and after solar noon the sun is at
+// elevation degrees.
+func hourAngle(lat, decl, elevation float64) time.Duration {
+	x := (sinDeg(elevation) - sinDeg(lat)*sinDeg(decl)) /
+		(cosDeg(lat) * cosDeg(decl))
+	x = math.Max(-1.0, math.Min(1.0, x))
+	return time.Duration(degrees(math.Acos(x)) / 360.0 * float64(24*time.Hour))
+}
+
+func sinDeg(x float64) float64 {
+	return math.Sin(x * math.Pi / 180.0)
+}
+
+func cosDeg(x float64) float64 {
+	return math.Cos(x * math.Pi / 180.0)
+}
+
+func degrees(radians float64) float64 {
+	return radians * 180.0 / math.Pi
+}
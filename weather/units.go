@@ -0,0 +1,65 @@
+package weather
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+)
+
+// TemperatureUnit is a unit for displaying temperatures. Temperatures in
+// this package are always stored in celsius.
+type TemperatureUnit int
+
+const (
+	Celsius TemperatureUnit = iota
+	Fahrenheit
+)
+
+// Convert converts celsius to this unit.
+func (u TemperatureUnit) Convert(celsius float64) float64 {
+	if u == Fahrenheit {
+		return CelsiusToFahrenheit(celsius)
+	}
+	return celsius
+}
+
+// Symbol returns the symbol of this unit e.g "°F".
+func (u TemperatureUnit) Symbol() string {
+	if u == Fahrenheit {
+		return "°F"
+	}
+	return "°C"
+}
+
+// Format returns celsius in this unit rounded to the nearest degree
+// e.g "72°F".
+func (u TemperatureUnit) Format(celsius float64) string {
+	return fmt.Sprintf("%d%s", int(math.Round(u.Convert(celsius))), u.Symbol())
+}
+
+// TemplateFuncs returns functions for templates that display temperatures
+// in unit. Callers add them to their templates with template.Funcs.
+// Temperatures passed to these functions are in celsius.
+//
+//	temperature: unit.Format e.g {{temperature .Temperature}} -> "72°F"
+//	convertTemperature: unit.Convert
+//	fahrenheit: CelsiusToFahrenheit
+//	celsius: FahrenheitToCelsius
+func TemplateFuncs(unit TemperatureUnit) template.FuncMap {
+	return template.FuncMap{
+		"temperature":        unit.Format,
+		"convertTemperature": unit.Convert,
+		"fahrenheit":         CelsiusToFahrenheit,
+		"celsius":            FahrenheitToCelsius,
+	}
+}
+
+// CelsiusToFahrenheit converts celsius to fahrenheit.
+func CelsiusToFahrenheit(celsius float64) float64 {
+	return celsius*9.0/5.0 + 32.0
+}
+
+// FahrenheitToCelsius converts fahrenheit to celsius.
+func FahrenheitToCelsius(fahrenheit float64) float64 {
+	return (fahrenheit - 32.0) * 5.0 / 9.0
+}
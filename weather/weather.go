@@ -2,10 +2,10 @@
 package weather
 
 import (
+	"context"
 	"encoding/json"
-	"encoding/xml"
 	"errors"
-	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"sort"
@@ -14,7 +14,6 @@ import (
 	"time"
 
 	"github.com/keep94/toolbox/http_util"
-	"golang.org/x/net/html/charset"
 )
 
 // Report represents a weather report which may include readings from
@@ -28,6 +27,48 @@ type Report struct {
 
 	// The Air Quality Index (0-500)
 	AQI int
+
+	// Relative humidity in percent (0-100)
+	Humidity float64
+
+	// Wind speed in meters per second
+	WindSpeed float64
+
+	// The direction the wind comes from in degrees clockwise from north
+	WindDirection float64
+
+	// Barometric pressure in hectopascals
+	Pressure float64
+
+	// Active weather alerts. These must be treated as immutable.
+	Alerts []Alert
+
+	// The unit for displaying Temperature. Providers leave this alone, so
+	// setting it in the report that a Poller starts from makes it stick.
+	Unit TemperatureUnit
+}
+
+// DisplayTemperature returns Temperature in Unit.
+func (r *Report) DisplayTemperature() float64 {
+	return r.Unit.Convert(r.Temperature)
+}
+
+// FormatTemperature returns Temperature in Unit rounded to the nearest
+// degree e.g "72°F". Templates can use this directly.
+func (r *Report) FormatTemperature() string {
+	return r.Unit.Format(r.Temperature)
+}
+
+// ActiveAlerts returns the alerts in this report that have not expired as
+// of now and are at least as severe as minSeverity.
+func (r *Report) ActiveAlerts(now time.Time, minSeverity Severity) []Alert {
+	var result []Alert
+	for _, alert := range r.Alerts {
+		if alert.Severity >= minSeverity && alert.IsActive(now) {
+			result = append(result, alert)
+		}
+	}
+	return result
 }
 
 // Observation represents a weather observation.
@@ -37,38 +78,54 @@ type Observation struct {
 	Temperature float64 `xml:"temp_c"`
 	// Weather conditions e.g 'Fair' or 'Partly Cloudy'
 	Weather string `xml:"weather"`
+	// Relative humidity in percent (0-100)
+	Humidity float64
+	// Wind speed in meters per second
+	WindSpeed float64
+	// The direction the wind comes from in degrees clockwise from north
+	WindDirection float64
+	// Barometric pressure in hectopascals
+	Pressure float64
+}
+
+// setReport sets the fields of report that come from an observation.
+func (o *Observation) setReport(report *Report) {
+	report.Temperature = o.Temperature
+	report.Condition = o.Weather
+	report.Humidity = o.Humidity
+	report.WindSpeed = o.WindSpeed
+	report.WindDirection = o.WindDirection
+	report.Pressure = o.Pressure
 }
 
 // Get returns the current observation from a NOAA weather station. For example
-// "KNUQ" means moffett field.
+// "KNUQ" means moffett field. Get uses the api.weather.gov API. Apps
+// should use their own NWSConn so that they identify themselves to the
+// National Weather Service.
 func Get(station string) (observation *Observation, err error) {
-	request := &http.Request{
-		Method: "GET",
-		URL:    getUrl(station)}
-	var client http.Client
-	var resp *http.Response
-	if resp, err = client.Do(request); err != nil {
-		return
-	}
-	defer resp.Body.Close()
-	decoder := xml.NewDecoder(resp.Body)
-	decoder.CharsetReader = charset.NewReaderLabel
-	var result Observation
-	if err = decoder.Decode(&result); err != nil {
-		return
-	}
-	return &result, nil
+	return kNWSConn.Get(station)
 }
 
 // OpenWeatherConn represents a connection to the open weather servers
 type OpenWeatherConn struct {
-	client http.Client
+	client *http.Client
 	url    *url.URL
 }
 
 // NewOpenWeatherConn returns a new, long lived, open weather connection.
+// Each request times out after DefaultTimeout.
 func NewOpenWeatherConn(apiKey string) *OpenWeatherConn {
-	return &OpenWeatherConn{url: getOpenWeatherUrl(apiKey)}
+	return NewOpenWeatherConnWithClient(apiKey, nil)
+}
+
+// NewOpenWeatherConnWithClient works like NewOpenWeatherConn except that
+// the returned connection sends its requests through client. Use client
+// to customize timeouts or the transport. nil means use a client with
+// DefaultTimeout.
+func NewOpenWeatherConnWithClient(
+	apiKey string, client *http.Client) *OpenWeatherConn {
+	return &OpenWeatherConn{
+		client: clientOrDefault(client), url: getOpenWeatherUrl(apiKey)}
 }
 
 // Get returns the weather for a particular city. The city ID for a city
@@ -77,65 +134,89 @@ func NewOpenWeatherConn(apiKey string) *OpenWeatherConn {
 // is "5375480"
 func (c *OpenWeatherConn) Get(cityId string) (
 	observation *Observation, err error) {
-	request := &http.Request{
-		Method: "GET",
-		URL:    http_util.AppendParams(c.url, "id", cityId)}
+	return c.GetContext(context.Background(), cityId)
+}
+
+// GetContext works like Get except that ctx can cancel the request.
+func (c *OpenWeatherConn) GetContext(ctx context.Context, cityId string) (
+	observation *Observation, err error) {
 	var resp *http.Response
-	if resp, err = c.client.Do(request); err != nil {
+	if resp, err = doGet(
+		ctx, c.client, http_util.AppendParams(c.url, "id", cityId), nil); err != nil {
 		return
 	}
 	defer resp.Body.Close()
-	decoder := json.NewDecoder(resp.Body)
+	observation, err = parseOpenWeather(resp.Body)
+	return observation, malformed(err)
+}
+
+func parseOpenWeather(r io.Reader) (*Observation, error) {
+	decoder := json.NewDecoder(r)
 	var result openWeatherObservation
-	if err = decoder.Decode(&result); err != nil {
-		return
+	if err := decoder.Decode(&result); err != nil {
+		return nil, err
 	}
 	if len(result.Weather) == 0 {
-		err = errors.New("weather:Missing weather section in open weather response")
-		return
+		return nil, errors.New("weather:Missing weather section in open weather response")
 	}
 	if result.Main == nil {
-		err = errors.New("weather:Missing main section in open weather response")
-		return
+		return nil, errors.New("weather:Missing main section in open weather response")
 	}
 	return &Observation{
-		Temperature: result.Main.Temp - 273.15,
-		Weather:     result.Weather[0].Description,
+		Temperature:   result.Main.Temp - 273.15,
+		Weather:       result.Weather[0].Description,
+		Humidity:      result.Main.Humidity,
+		WindSpeed:     result.Wind.Speed,
+		WindDirection: result.Wind.Deg,
+		Pressure:      result.Main.Pressure,
 	}, nil
 }
 
 // PurpleAirConn represents a connection to purple air
 type PurpleAirConn struct {
-	client http.Client
+	client *http.Client
 	url    *url.URL
 }
 
-var kPurpleAirConn = &PurpleAirConn{url: getPurpleAirUrl()}
+var kPurpleAirConn = NewPurpleAirConnWithClient(nil)
 
 // NewPurpleAirConn returns a new, long lived, purple air connection.
+// Each request times out after DefaultTimeout.
 func NewPurpleAirConn() *PurpleAirConn {
 	return kPurpleAirConn
 }
 
+// NewPurpleAirConnWithClient works like NewPurpleAirConn except that the
+// returned connection sends its requests through client. nil means use a
+// client with DefaultTimeout.
+func NewPurpleAirConnWithClient(client *http.Client) *PurpleAirConn {
+	return &PurpleAirConn{
+		client: clientOrDefault(client), url: getPurpleAirUrl()}
+}
+
 // GetAQI returns the AQI for a particular purple air station.
 func (p *PurpleAirConn) GetAQI(stationId int64) (aqi int, err error) {
-	request := &http.Request{
-		Method: "GET",
-		URL: http_util.AppendParams(
-			p.url, "show", strconv.FormatInt(stationId, 10))}
+	return p.GetAQIContext(context.Background(), stationId)
+}
+
+// GetAQIContext works like GetAQI except that ctx can cancel the request.
+func (p *PurpleAirConn) GetAQIContext(
+	ctx context.Context, stationId int64) (aqi int, err error) {
+	u := http_util.AppendParams(
+		p.url, "show", strconv.FormatInt(stationId, 10))
 	var resp *http.Response
-	if resp, err = p.client.Do(request); err != nil {
+	if resp, err = doGet(ctx, p.client, u, nil); err != nil {
 		return
 	}
 	defer resp.Body.Close()
 	decoder := json.NewDecoder(resp.Body)
 	var result purpleAirResponse
 	if err = decoder.Decode(&result); err != nil {
-		return
+		return 0, malformed(err)
 	}
 	pm2_5, err := result.AveragePM2_5()
 	if err != nil {
-		return
+		return 0, malformed(err)
 	}
 	return computeAQI(pm2_5), nil
 }
@@ -267,13 +348,6 @@ func (c *Cache) set(
 	return result
 }
 
-func getUrl(station string) *url.URL {
-	return &url.URL{
-		Scheme: "http",
-		Host:   "w1.weather.gov",
-		Path:   fmt.Sprintf("/xml/current_obs/%s.xml", station)}
-}
-
 func getPurpleAirUrl() *url.URL {
 	return &url.URL{
 		Scheme: "http",
@@ -292,6 +366,7 @@ func getOpenWeatherUrl(apiKey string) *url.URL {
 type openWeatherObservation struct {
 	Weather []openWeatherWeather `json:"weather"`
 	Main    *openWeatherMain     `json:"main"`
+	Wind    openWeatherWind      `json:"wind"`
 }
 
 type openWeatherWeather struct {
@@ -299,7 +374,14 @@ type openWeatherWeather struct {
 }
 
 type openWeatherMain struct {
-	Temp float64 `json:"temp"`
+	Temp     float64 `json:"temp"`
+	Humidity float64 `json:"humidity"`
+	Pressure float64 `json:"pressure"`
+}
+
+type openWeatherWind struct {
+	Speed float64 `json:"speed"`
+	Deg   float64 `json:"deg"`
 }
 
 type purpleAirResponse struct {
@@ -1,11 +1,15 @@
 package weather_test
 
 import (
+	"encoding/xml"
 	"errors"
+	"html/template"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/keep94/marvin2/weather"
+	"github.com/keep94/tasks"
 	asserts "github.com/stretchr/testify/assert"
 )
 
@@ -85,3 +89,286 @@ func (f fakeConn) GetAQI(stationId int64) (int, error) {
 	}
 	return aqi, nil
 }
+
+func TestSunTimes(t *testing.T) {
+	assert := asserts.New(t)
+	pst := time.FixedZone("PST", -8*3600)
+	times := weather.SunTimes(40.0, -120.0, time.Date(2013, 1, 7, 3, 0, 0, 0, pst))
+	assert.Equal(time.Date(2013, 1, 7, 16, 51, 59, 0, pst), times.Sunset)
+	assert.Equal(pst, times.Sunrise.Location())
+	assert.True(times.Sunrise.Before(times.Sunset))
+	assertBetween(
+		assert, times.Sunrise.Sub(times.CivilDawn), 25*time.Minute, 35*time.Minute)
+	assertBetween(
+		assert, times.CivilDusk.Sub(times.Sunset), 25*time.Minute, 35*time.Minute)
+
+	// Polar night: the sun never rises but there is still twilight.
+	times = weather.SunTimes(70.0, 20.0, time.Date(2013, 12, 21, 12, 0, 0, 0, time.UTC))
+	assert.True(times.CivilDawn.Before(times.Sunrise))
+	assert.True(times.Sunset.Before(times.CivilDusk))
+}
+
+func assertBetween(
+	assert *asserts.Assertions, d, low, high time.Duration) {
+	assert.True(d >= low && d <= high, "Expected %v between %v and %v", d, low, high)
+}
+
+func TestPoller(t *testing.T) {
+	assert := asserts.New(t)
+	cache := weather.NewReportCache()
+	defer cache.Close()
+	start := time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := &tasks.ClockForTesting{Current: start}
+	var execution *tasks.Execution
+	var tempFetches, aqiFetches []time.Time
+	temp := weather.ProviderFunc(func(report *weather.Report) error {
+		tempFetches = append(tempFetches, execution.Now())
+		report.Temperature = float64(len(tempFetches))
+		return nil
+	})
+	errAQI := errors.New("sensor offline")
+	aqi := weather.ProviderFunc(func(report *weather.Report) error {
+		aqiFetches = append(aqiFetches, execution.Now())
+		if len(aqiFetches) == 3 {
+			execution.End()
+		}
+		if len(aqiFetches) == 1 {
+			return errAQI
+		}
+		report.AQI = 40
+		return nil
+	})
+	poller := weather.NewPoller(
+		cache,
+		0,
+		weather.Source{Provider: temp, Interval: 10 * time.Minute},
+		weather.Source{
+			Provider:   aqi,
+			Interval:   time.Hour,
+			Retries:    1,
+			RetryDelay: time.Minute,
+		})
+	tasks.RunForTesting(tasks.TaskFunc(func(e *tasks.Execution) {
+		execution = e
+		poller.Do(e)
+	}), clock)
+	assert.Equal(
+		[]time.Time{start, start.Add(time.Minute), start.Add(time.Hour)},
+		aqiFetches)
+	assert.Equal(start, tempFetches[0])
+	assert.Equal(start.Add(10*time.Minute), tempFetches[1])
+	assert.Equal(7, len(tempFetches))
+	assert.Equal(errAQI, poller.LastError())
+	var report weather.Report
+	cache.Get(&report)
+	assert.Equal(weather.Report{Temperature: 7, AQI: 40}, report)
+}
+
+func TestFallbackProvider(t *testing.T) {
+	assert := asserts.New(t)
+	errOffline := errors.New("offline")
+	failing := weather.ProviderFunc(func(report *weather.Report) error {
+		report.AQI = 999
+		return errOffline
+	})
+	working := weather.ProviderFunc(func(report *weather.Report) error {
+		report.AQI = 42
+		return nil
+	})
+	report := weather.Report{Temperature: 20.0}
+	assert.NoError(weather.FallbackProvider(failing, working).Fetch(&report))
+	assert.Equal(weather.Report{Temperature: 20.0, AQI: 42}, report)
+	report = weather.Report{AQI: 10}
+	assert.Equal(
+		errOffline, weather.FallbackProvider(failing, failing).Fetch(&report))
+	assert.Equal(weather.Report{AQI: 10}, report)
+	assert.Error(weather.FallbackProvider().Fetch(&report))
+}
+
+func TestAggregate(t *testing.T) {
+	assert := asserts.New(t)
+	reading := func(temperature float64, condition string) weather.Provider {
+		return weather.ProviderFunc(func(report *weather.Report) error {
+			report.Temperature = temperature
+			report.Condition = condition
+			return nil
+		})
+	}
+	offline := weather.ProviderFunc(func(report *weather.Report) error {
+		return errors.New("offline")
+	})
+	providers := []weather.NamedProvider{
+		{Name: "nws", Provider: reading(20.0, "Cloudy")},
+		{Name: "openweather", Provider: reading(21.0, "Fair")},
+		{Name: "broken", Provider: reading(45.0, "Fair")},
+		{Name: "down", Provider: offline},
+		{Name: "local", Provider: reading(22.0, "Fair")},
+	}
+	aggregation, err := weather.Aggregate(3, 3.0, providers...)
+	assert.NoError(err)
+	assert.Equal(
+		weather.Report{Temperature: 21.0, Condition: "Fair"},
+		aggregation.Report)
+	assert.Equal(
+		[]string{"nws", "openweather", "local"},
+		aggregation.TemperatureSources)
+	assert.Equal([]string{"openweather", "local"}, aggregation.ConditionSources)
+
+	_, err = weather.Aggregate(4, 3.0, providers...)
+	assert.Error(err)
+
+	report := weather.Report{AQI: 35}
+	assert.NoError(weather.AggregateProvider(1, 3.0, providers[:2]...).Fetch(&report))
+	assert.Equal(
+		weather.Report{Temperature: 20.5, Condition: "Cloudy", AQI: 35}, report)
+	assert.Error(weather.AggregateProvider(
+		1, 3.0, weather.NamedProvider{Name: "down", Provider: offline}).Fetch(&report))
+	assert.Panics(func() { weather.Aggregate(1, 3.0) })
+}
+
+func TestActiveAlerts(t *testing.T) {
+	assert := asserts.New(t)
+	start := time.Date(2024, 5, 6, 15, 0, 0, 0, time.UTC)
+	tornado := weather.Alert{
+		Event:     "Tornado Warning",
+		Severity:  weather.SeverityExtreme,
+		Effective: start,
+		Expires:   start.Add(time.Hour),
+	}
+	advisory := weather.Alert{
+		Event:     "Wind Advisory",
+		Severity:  weather.SeverityMinor,
+		Effective: start.Add(-time.Hour),
+	}
+	report := weather.Report{Alerts: []weather.Alert{tornado, advisory}}
+	assert.Equal(
+		[]weather.Alert{advisory},
+		report.ActiveAlerts(start.Add(-time.Minute), weather.SeverityUnknown))
+	assert.Equal(
+		[]weather.Alert{tornado, advisory},
+		report.ActiveAlerts(start, weather.SeverityUnknown))
+	assert.Equal(
+		[]weather.Alert{tornado},
+		report.ActiveAlerts(start, weather.SeveritySevere))
+	assert.Empty(report.ActiveAlerts(start.Add(time.Hour), weather.SeveritySevere))
+	assert.Equal("Extreme", weather.SeverityExtreme.String())
+	assert.Equal("Unknown", weather.Severity(99).String())
+}
+
+func TestTemperatureUnits(t *testing.T) {
+	assert := asserts.New(t)
+	assert.Equal(212.0, weather.CelsiusToFahrenheit(100.0))
+	assert.Equal(-40.0, weather.CelsiusToFahrenheit(-40.0))
+	assert.Equal(0.0, weather.FahrenheitToCelsius(32.0))
+	assert.InDelta(22.2222, weather.FahrenheitToCelsius(72.0), 0.001)
+	assert.Equal(22.0, weather.Celsius.Convert(22.0))
+	assert.InDelta(71.6, weather.Fahrenheit.Convert(22.0), 0.001)
+	assert.Equal("22°C", weather.Celsius.Format(22.0))
+	assert.Equal("72°F", weather.Fahrenheit.Format(22.0))
+	assert.Equal("-18°C", weather.Celsius.Format(-17.8))
+	assert.Equal("0°C", weather.Celsius.Format(-0.3))
+	tmpl := template.Must(template.New("weather").Funcs(
+		weather.TemplateFuncs(weather.Fahrenheit)).Parse(
+		`{{temperature .Temperature}} {{printf "%.1f" (convertTemperature .Temperature)}} {{printf "%.0f" (fahrenheit 100.0)}} {{printf "%.0f" (celsius 212.0)}}`))
+	var sb strings.Builder
+	assert.NoError(tmpl.Execute(&sb, &weather.Report{Temperature: 22.0}))
+	assert.Equal("72°F 71.6 212 100", sb.String())
+	report := &weather.Report{Temperature: 22.0, Unit: weather.Fahrenheit}
+	assert.InDelta(71.6, report.DisplayTemperature(), 0.001)
+	assert.Equal("72°F", report.FormatTemperature())
+	sb.Reset()
+	assert.NoError(template.Must(template.New("report").Parse(
+		`{{.FormatTemperature}}`)).Execute(&sb, report))
+	assert.Equal("72°F", sb.String())
+}
+
+func TestPollerKeepsUnit(t *testing.T) {
+	assert := asserts.New(t)
+	cache := weather.NewReportCache()
+	defer cache.Close()
+	cache.Set(&weather.Report{Unit: weather.Fahrenheit})
+	var execution *tasks.Execution
+	fetches := 0
+	temp := weather.ProviderFunc(func(report *weather.Report) error {
+		fetches++
+		if fetches == 2 {
+			execution.End()
+		}
+		report.Temperature = 22.0
+		return nil
+	})
+	poller := weather.NewPoller(
+		cache, 0, weather.Source{Provider: temp, Interval: time.Hour})
+	tasks.RunForTesting(tasks.TaskFunc(func(e *tasks.Execution) {
+		execution = e
+		poller.Do(e)
+	}), &tasks.ClockForTesting{Current: time.Date(
+		2014, 6, 1, 12, 0, 0, 0, time.UTC)})
+	var report weather.Report
+	cache.Get(&report)
+	assert.Equal("72°F", report.FormatTemperature())
+}
+
+func TestPollerErrorKinds(t *testing.T) {
+	assert := asserts.New(t)
+	cache := weather.NewReportCache()
+	defer cache.Close()
+	start := time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := &tasks.ClockForTesting{Current: start}
+	var execution *tasks.Execution
+	var authFetches, limitedFetches []time.Time
+	auth := weather.ProviderFunc(func(report *weather.Report) error {
+		authFetches = append(authFetches, execution.Now())
+		return &weather.Error{Kind: weather.AuthFailed, StatusCode: 401}
+	})
+	limited := weather.ProviderFunc(func(report *weather.Report) error {
+		limitedFetches = append(limitedFetches, execution.Now())
+		if len(limitedFetches) == 2 {
+			execution.End()
+		}
+		return &weather.Error{
+			Kind:       weather.RateLimited,
+			StatusCode: 429,
+			RetryAfter: 5 * time.Minute,
+		}
+	})
+	poller := weather.NewPoller(
+		cache,
+		0,
+		weather.Source{
+			Provider:   auth,
+			Interval:   time.Hour,
+			Retries:    3,
+			RetryDelay: time.Minute,
+		},
+		weather.Source{
+			Provider:   limited,
+			Interval:   time.Hour,
+			Retries:    3,
+			RetryDelay: time.Minute,
+		})
+	tasks.RunForTesting(tasks.TaskFunc(func(e *tasks.Execution) {
+		execution = e
+		poller.Do(e)
+	}), clock)
+
+	// Auth failures are not retried
+	assert.Equal([]time.Time{start}, authFetches)
+
+	// Rate limiting waits as long as the service asks
+	assert.Equal(
+		[]time.Time{start, start.Add(5 * time.Minute)}, limitedFetches)
+	assert.True(weather.IsTemporary(poller.LastError()))
+}
+
+func TestObservationXML(t *testing.T) {
+	assert := asserts.New(t)
+	var observation weather.Observation
+	err := xml.Unmarshal([]byte(`<current_observation>
+		<weather>Fair</weather>
+		<temp_c>18.3</temp_c>
+	</current_observation>`), &observation)
+	assert.NoError(err)
+	assert.Equal(
+		weather.Observation{Temperature: 18.3, Weather: "Fair"}, observation)
+}